@@ -53,8 +53,9 @@ func DefaultConfig() *Config {
 // Client is the client to the Vault API. Create a client with
 // NewClient.
 type Client struct {
-	addr   *url.URL
-	config *Config
+	addr    *url.URL
+	config  *Config
+	wrapTTL string
 }
 
 // NewClient returns a new client for the given configuration.
@@ -138,6 +139,13 @@ func (c *Client) ClearToken() {
 	})
 }
 
+// SetWrapTTL sets the TTL of the wrapping token for future requests, so
+// that their responses are wrapped. The TTL is either a duration such
+// as "5m" or a number of seconds, and an empty TTL disables wrapping.
+func (c *Client) SetWrapTTL(ttl string) {
+	c.wrapTTL = ttl
+}
+
 // NewRequest creates a new raw request object to query the Vault server
 // configured for this client. This is an advanced method and generally
 // doesn't need to be called externally.
@@ -149,7 +157,8 @@ func (c *Client) NewRequest(method, path string) *Request {
 			Host:   c.addr.Host,
 			Path:   path,
 		},
-		Params: make(map[string][]string),
+		Params:  make(map[string][]string),
+		WrapTTL: c.wrapTTL,
 	}
 }
 
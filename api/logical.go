@@ -57,3 +57,20 @@ func (c *Logical) Delete(path string) (*Secret, error) {
 
 	return nil, nil
 }
+
+// Unwrap retrieves a response wrapped with the given token. The token
+// is revoked, so the response can only be retrieved once.
+func (c *Logical) Unwrap(token string) (*Secret, error) {
+	r := c.c.NewRequest("PUT", "/v1/sys/wrapping/unwrap")
+	if err := r.SetJSONBody(map[string]interface{}{"token": token}); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.c.RawRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return ParseSecret(resp.Body)
+}
@@ -17,6 +17,10 @@ type Request struct {
 	Obj      interface{}
 	Body     io.Reader
 	BodySize int64
+
+	// WrapTTL requests that the response be wrapped with a token with
+	// this TTL if it is set
+	WrapTTL string
 }
 
 // SetJSONBody is used to set a request body that is a JSON-encoded value.
@@ -57,5 +61,9 @@ func (r *Request) ToHTTP() (*http.Request, error) {
 	req.URL.Host = r.URL.Host
 	req.Host = r.URL.Host
 
+	if r.WrapTTL != "" {
+		req.Header.Set("X-Vault-Wrap-TTL", r.WrapTTL)
+	}
+
 	return req, nil
 }
@@ -3,10 +3,12 @@ package api
 import (
 	"encoding/json"
 	"io"
+	"time"
 )
 
 // Secret is the structure returned for every secret within Vault.
 type Secret struct {
+	RequestID     string `json:"request_id"`
 	LeaseID       string `json:"lease_id"`
 	LeaseDuration int    `json:"lease_duration"`
 	Renewable     bool   `json:"renewable"`
@@ -18,11 +20,24 @@ type Secret struct {
 	// Auth, if non-nil, means that there was authentication information
 	// attached to this response.
 	Auth *SecretAuth `json:"auth,omitempty"`
+
+	// WrapInfo, if non-nil, means that the response was wrapped, and
+	// can be retrieved once with the token using Logical().Unwrap.
+	WrapInfo *SecretWrapInfo `json:"wrap_info,omitempty"`
+}
+
+// SecretWrapInfo is the structure containing the wrapping token of a
+// wrapped response.
+type SecretWrapInfo struct {
+	Token        string    `json:"token"`
+	TTL          int       `json:"ttl"`
+	CreationTime time.Time `json:"creation_time"`
 }
 
 // Auth is the structure containing auth information if we have it.
 type SecretAuth struct {
 	ClientToken string            `json:"client_token"`
+	Accessor    string            `json:"accessor"`
 	Policies    []string          `json:"policies"`
 	Metadata    map[string]string `json:"metadata"`
 
@@ -56,4 +56,5 @@ type Audit struct {
 	Type        string
 	Description string
 	Options     map[string]string
+	BestEffort  bool `json:"best_effort"`
 }
@@ -69,4 +69,5 @@ func (c *Sys) checkAuthPath(path string) error {
 type AuthMount struct {
 	Type        string
 	Description string
+	TokenRole   string `json:"token_role"`
 }
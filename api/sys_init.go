@@ -18,6 +18,15 @@ func (c *Sys) Init(opts *InitRequest) (*InitResponse, error) {
 		"secret_shares":    opts.SecretShares,
 		"secret_threshold": opts.SecretThreshold,
 	}
+	if len(opts.PGPKeys) > 0 {
+		body["pgp_keys"] = opts.PGPKeys
+	}
+	if opts.SecureSeal {
+		body["secure_seal"] = true
+	}
+	if len(opts.ShareLabels) > 0 {
+		body["share_labels"] = opts.ShareLabels
+	}
 
 	r := c.c.NewRequest("PUT", "/v1/sys/init")
 	if err := r.SetJSONBody(body); err != nil {
@@ -38,6 +47,9 @@ func (c *Sys) Init(opts *InitRequest) (*InitResponse, error) {
 type InitRequest struct {
 	SecretShares    int
 	SecretThreshold int
+	PGPKeys         []string
+	SecureSeal      bool
+	ShareLabels     []string
 }
 
 type InitStatusResponse struct {
@@ -46,5 +58,6 @@ type InitStatusResponse struct {
 
 type InitResponse struct {
 	Keys      []string
-	RootToken string `json:"root_token"`
+	KeyLabels []string `json:"key_labels"`
+	RootToken string   `json:"root_token"`
 }
@@ -13,6 +13,15 @@ func (c *Sys) Leader() (*LeaderResponse, error) {
 	return &result, err
 }
 
+func (c *Sys) StepDown() error {
+	r := c.c.NewRequest("PUT", "/v1/sys/step-down")
+	resp, err := c.c.RawRequest(r)
+	if err == nil {
+		defer resp.Body.Close()
+	}
+	return err
+}
+
 type LeaderResponse struct {
 	HAEnabled     bool   `json:"ha_enabled"`
 	IsSelf        bool   `json:"is_self"`
@@ -90,4 +90,5 @@ func (c *Sys) checkMountPath(path string) error {
 type Mount struct {
 	Type        string
 	Description string
+	ReadOnly    bool `json:"read_only"`
 }
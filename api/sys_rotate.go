@@ -0,0 +1,31 @@
+package api
+
+func (c *Sys) Rotate() (*KeyStatus, error) {
+	r := c.c.NewRequest("PUT", "/v1/sys/rotate")
+	resp, err := c.c.RawRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result KeyStatus
+	err = resp.DecodeJSON(&result)
+	return &result, err
+}
+
+func (c *Sys) KeyStatus() (*KeyStatus, error) {
+	r := c.c.NewRequest("GET", "/v1/sys/key-status")
+	resp, err := c.c.RawRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result KeyStatus
+	err = resp.DecodeJSON(&result)
+	return &result, err
+}
+
+type KeyStatus struct {
+	Term uint32 `json:"term"`
+}
@@ -22,8 +22,63 @@ func (c *Sys) Seal() error {
 	return err
 }
 
+// SecureSeal provides a key for the seal in progress when secure seal
+// is enabled. The Vault is sealed once the threshold of keys is given.
+func (c *Sys) SecureSeal(shard string) (*SecureSealResponse, error) {
+	body := map[string]interface{}{"key": shard}
+
+	r := c.c.NewRequest("PUT", "/v1/sys/seal")
+	if err := r.SetJSONBody(body); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.c.RawRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result SecureSealResponse
+	err = resp.DecodeJSON(&result)
+	return &result, err
+}
+
+// Unseal provides a key without a nonce, which is only accepted if no
+// unseal is in progress. The following keys of an unseal must be
+// provided with UnsealWithNonce.
 func (c *Sys) Unseal(shard string) (*SealStatusResponse, error) {
+	return c.UnsealWithNonce("", shard)
+}
+
+// UnsealWithNonce provides a key for the unseal in progress identified
+// by the nonce, which is reset if the nonce does not match. The nonce
+// of an unseal in progress is returned in the seal status.
+func (c *Sys) UnsealWithNonce(nonce, shard string) (*SealStatusResponse, error) {
 	body := map[string]interface{}{"key": shard}
+	if nonce != "" {
+		body["nonce"] = nonce
+	}
+
+	r := c.c.NewRequest("PUT", "/v1/sys/unseal")
+	if err := r.SetJSONBody(body); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.c.RawRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result SealStatusResponse
+	err = resp.DecodeJSON(&result)
+	return &result, err
+}
+
+// ResetUnsealProcess discards the keys provided so far for the unseal
+// in progress
+func (c *Sys) ResetUnsealProcess() (*SealStatusResponse, error) {
+	body := map[string]interface{}{"reset": true}
 
 	r := c.c.NewRequest("PUT", "/v1/sys/unseal")
 	if err := r.SetJSONBody(body); err != nil {
@@ -46,4 +101,10 @@ type SealStatusResponse struct {
 	T        int
 	N        int
 	Progress int
+	Nonce    string
+}
+
+type SecureSealResponse struct {
+	Sealed   bool
+	Progress int
 }
@@ -20,5 +20,17 @@ type Backend interface {
 	LogResponse(*logical.Auth, *logical.Request, *logical.Response, error) error
 }
 
+// BackendConfig contains the configuration given to the factory of
+// an audit backend.
+type BackendConfig struct {
+	// Salt is the secret used to hash sensitive values with an HMAC. It
+	// is persisted for each enabled audit backend, so that the hashes
+	// of a backend are stable across restarts.
+	Salt string
+
+	// Config is the options given when the backend was enabled
+	Config map[string]string
+}
+
 // Factory is the factory function to create an audit backend.
-type Factory func(map[string]string) (Backend, error)
+type Factory func(*BackendConfig) (Backend, error)
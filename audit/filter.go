@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+const (
+	// OmittedMarker replaces the value of an omitted field, so that the
+	// presence of the field is still recorded
+	OmittedMarker = "<omitted>"
+
+	// TruncatedMarker is appended to the value of a truncated field
+	TruncatedMarker = "<truncated>"
+)
+
+// Filter describes the fields of requests and responses that are omitted
+// or truncated before they are given to an audit backend. Fields are the
+// top-level keys of the Data of a request or response. Filtering happens
+// before any values are hashed by the backend.
+type Filter struct {
+	// Omit are the fields whose values are replaced with OmittedMarker
+	Omit []string `json:"omit,omitempty"`
+
+	// Truncate are the fields whose string values are cut to
+	// TruncateLength bytes, followed by TruncatedMarker. Values that
+	// are not strings are omitted instead.
+	Truncate       []string `json:"truncate,omitempty"`
+	TruncateLength int      `json:"truncate_length,omitempty"`
+}
+
+// Validate is used to check that the filter is usable
+func (f *Filter) Validate() error {
+	if len(f.Truncate) > 0 && f.TruncateLength <= 0 {
+		return fmt.Errorf("truncate length must be positive")
+	}
+	return nil
+}
+
+// Request returns the request with its fields filtered. The request is
+// never modified, a copy is returned if any field is filtered.
+func (f *Filter) Request(req *logical.Request) *logical.Request {
+	if f == nil || req == nil {
+		return req
+	}
+	data, ok := f.filter(req.Data)
+	if !ok {
+		return req
+	}
+	cp := *req
+	cp.Data = data
+	return &cp
+}
+
+// Response returns the response with its fields filtered. The response
+// is never modified, a copy is returned if any field is filtered.
+func (f *Filter) Response(resp *logical.Response) *logical.Response {
+	if f == nil || resp == nil {
+		return resp
+	}
+	data, ok := f.filter(resp.Data)
+	if !ok {
+		return resp
+	}
+	cp := *resp
+	cp.Data = data
+	return &cp
+}
+
+// filter returns a copy of the data with its fields filtered, and
+// whether any field was filtered
+func (f *Filter) filter(data map[string]interface{}) (map[string]interface{}, bool) {
+	var out map[string]interface{}
+	set := func(k string, v interface{}) {
+		if out == nil {
+			out = make(map[string]interface{}, len(data))
+			for k, v := range data {
+				out[k] = v
+			}
+		}
+		out[k] = v
+	}
+
+	for _, k := range f.Truncate {
+		v, ok := data[k]
+		if !ok {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			set(k, OmittedMarker)
+			continue
+		}
+		if len(s) > f.TruncateLength {
+			set(k, s[:f.TruncateLength]+TruncatedMarker)
+		}
+	}
+
+	// Omitting is applied last so that it wins over truncating
+	for _, k := range f.Omit {
+		if _, ok := data[k]; ok {
+			set(k, OmittedMarker)
+		}
+	}
+
+	if out == nil {
+		return data, false
+	}
+	return out, true
+}
@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestFilter_Request(t *testing.T) {
+	f := &Filter{
+		Omit:           []string{"blob", "both"},
+		Truncate:       []string{"long", "short", "nested", "both"},
+		TruncateLength: 4,
+	}
+
+	req := &logical.Request{
+		Path: "foo",
+		Data: map[string]interface{}{
+			"blob":   "secret",
+			"long":   "abcdefgh",
+			"short":  "abc",
+			"nested": map[string]interface{}{"a": "b"},
+			"both":   "abcdefgh",
+			"other":  "value",
+		},
+	}
+	out := f.Request(req)
+
+	expected := map[string]interface{}{
+		"blob":   OmittedMarker,
+		"long":   "abcd" + TruncatedMarker,
+		"short":  "abc",
+		"nested": OmittedMarker,
+		"both":   OmittedMarker,
+		"other":  "value",
+	}
+	if !reflect.DeepEqual(out.Data, expected) {
+		t.Fatalf("bad: %#v", out.Data)
+	}
+	if out.Path != "foo" {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	// The original is not modified
+	if req.Data["blob"] != "secret" || req.Data["long"] != "abcdefgh" {
+		t.Fatalf("bad: %#v", req.Data)
+	}
+}
+
+func TestFilter_Response(t *testing.T) {
+	f := &Filter{Omit: []string{"blob"}}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{"blob": "secret"},
+	}
+	out := f.Response(resp)
+	if out.Data["blob"] != OmittedMarker {
+		t.Fatalf("bad: %#v", out.Data)
+	}
+	if resp.Data["blob"] != "secret" {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// Nothing is copied if no field is filtered
+	resp = &logical.Response{
+		Data: map[string]interface{}{"other": "value"},
+	}
+	if out := f.Response(resp); out != resp {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	// A nil filter passes everything through
+	var nilFilter *Filter
+	if out := nilFilter.Response(resp); out != resp {
+		t.Fatalf("bad: %#v", out)
+	}
+}
+
+func TestFilter_Validate(t *testing.T) {
+	f := &Filter{Truncate: []string{"foo"}}
+	if err := f.Validate(); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	f.TruncateLength = 10
+	if err := f.Validate(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
@@ -25,14 +25,18 @@ func (f *FormatJSON) FormatRequest(
 		Type: "request",
 
 		Auth: JSONAuth{
+			Accessor: auth.Accessor,
 			Policies: auth.Policies,
 			Metadata: auth.Metadata,
 		},
 
 		Request: JSONRequest{
+			ID:        req.ID,
 			Operation: req.Operation,
 			Path:      req.Path,
 			Data:      req.Data,
+
+			BreakGlass: req.BreakGlass,
 		},
 	})
 }
@@ -55,6 +59,7 @@ func (f *FormatJSON) FormatResponse(
 	if resp.Auth != nil {
 		respAuth = JSONAuth{
 			ClientToken: resp.Auth.ClientToken,
+			Accessor:    resp.Auth.Accessor,
 			Policies:    resp.Auth.Policies,
 			Metadata:    resp.Auth.Metadata,
 		}
@@ -73,14 +78,18 @@ func (f *FormatJSON) FormatResponse(
 		Type: "response",
 
 		Auth: JSONAuth{
+			Accessor: auth.Accessor,
 			Policies: auth.Policies,
 			Metadata: auth.Metadata,
 		},
 
 		Request: JSONRequest{
+			ID:        req.ID,
 			Operation: req.Operation,
 			Path:      req.Path,
 			Data:      req.Data,
+
+			BreakGlass: req.BreakGlass,
 		},
 
 		Response: JSONResponse{
@@ -109,9 +118,13 @@ type JSONResponseEntry struct {
 }
 
 type JSONRequest struct {
+	ID        string                 `json:"id"`
 	Operation logical.Operation      `json:"operation"`
 	Path      string                 `json:"path"`
 	Data      map[string]interface{} `json:"data"`
+
+	// BreakGlass is set if the ACL was overridden for the request
+	BreakGlass bool `json:"break_glass,omitempty"`
 }
 
 type JSONResponse struct {
@@ -123,6 +136,7 @@ type JSONResponse struct {
 
 type JSONAuth struct {
 	ClientToken string            `json:"string,omitempty"`
+	Accessor    string            `json:"accessor,omitempty"`
 	Policies    []string          `json:"policies"`
 	Metadata    map[string]string `json:"metadata"`
 }
@@ -16,11 +16,20 @@ func TestFormatJSON_formatRequest(t *testing.T) {
 		"auth, request": {
 			&logical.Auth{ClientToken: "foo", Policies: []string{"root"}},
 			&logical.Request{
+				ID:        "req-id",
 				Operation: logical.WriteOperation,
 				Path:      "/foo",
 			},
 			testFormatJSONReqBasicStr,
 		},
+		"auth with accessor, request": {
+			&logical.Auth{ClientToken: "foo", Accessor: "bar", Policies: []string{"root"}},
+			&logical.Request{
+				Operation: logical.WriteOperation,
+				Path:      "/foo",
+			},
+			testFormatJSONReqAccessorStr,
+		},
 	}
 
 	for name, tc := range cases {
@@ -38,5 +47,8 @@ func TestFormatJSON_formatRequest(t *testing.T) {
 	}
 }
 
-const testFormatJSONReqBasicStr = `{"type":"request","auth":{"policies":["root"],"metadata":null},"request":{"operation":"write","path":"/foo","data":null}}
+const testFormatJSONReqBasicStr = `{"type":"request","auth":{"policies":["root"],"metadata":null},"request":{"id":"req-id","operation":"write","path":"/foo","data":null}}
+`
+
+const testFormatJSONReqAccessorStr = `{"type":"request","auth":{"accessor":"bar","policies":["root"],"metadata":null},"request":{"id":"","operation":"write","path":"/foo","data":null}}
 `
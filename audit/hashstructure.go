@@ -1,7 +1,9 @@
 package audit
 
 import (
+	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"reflect"
@@ -12,13 +14,13 @@ import (
 	"github.com/mitchellh/reflectwalk"
 )
 
-// Hash will hash the given type. This has built-in support for auth,
-// requests, and responses. If it is a type that isn't recognized, then
-// it will be passed through.
+// Hash will hash the given type with an HMAC using the given salt. This
+// has built-in support for auth, requests, and responses. If it is a type
+// that isn't recognized, then it will be passed through.
 //
 // The structure is modified in-place.
-func Hash(raw interface{}) error {
-	fn := HashSHA1("")
+func Hash(salt string, raw interface{}) error {
+	fn := HashHMACSHA256(salt)
 
 	switch s := raw.(type) {
 	case *logical.Auth:
@@ -38,7 +40,7 @@ func Hash(raw interface{}) error {
 			return nil
 		}
 		if s.Auth != nil {
-			if err := Hash(s.Auth); err != nil {
+			if err := Hash(salt, s.Auth); err != nil {
 				return err
 			}
 		}
@@ -54,7 +56,7 @@ func Hash(raw interface{}) error {
 			return nil
 		}
 		if s.Auth != nil {
-			if err := Hash(s.Auth); err != nil {
+			if err := Hash(salt, s.Auth); err != nil {
 				return err
 			}
 		}
@@ -101,6 +103,17 @@ func HashSHA1(salt string) HashCallback {
 	}
 }
 
+// HashHMACSHA256 returns a HashCallback that hashes data with an
+// HMAC-SHA256 keyed by the salt, so that the hashes can only be
+// reproduced with the salt.
+func HashHMACSHA256(salt string) HashCallback {
+	return func(v string) (string, error) {
+		hm := hmac.New(sha256.New, []byte(salt))
+		hm.Write([]byte(v))
+		return "hmac-sha256:" + hex.EncodeToString(hm.Sum(nil)), nil
+	}
+}
+
 // hashWalker implements interfaces for the reflectwalk package
 // (github.com/mitchellh/reflectwalk) that can be used to automatically
 // replace primitives with a hashed value.
@@ -86,7 +86,7 @@ func TestHash(t *testing.T) {
 	}{
 		{
 			&logical.Auth{ClientToken: "foo"},
-			&logical.Auth{ClientToken: "sha1:0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33"},
+			&logical.Auth{ClientToken: "hmac-sha256:6a9534d88e984dfcea835f190147b72b3f647fdcc2409e5b8be8b331ec7fe8a5"},
 		},
 		{
 			&logical.Request{
@@ -96,7 +96,7 @@ func TestHash(t *testing.T) {
 			},
 			&logical.Request{
 				Data: map[string]interface{}{
-					"foo": "sha1:62cdb7020ff920e5aa642c3d4066950dd1f01f4d",
+					"foo": "hmac-sha256:c15e9586fe4826c3a8b982297760cdd8b6d5d3bca45920fa18e862c9c899377d",
 				},
 			},
 		},
@@ -108,7 +108,7 @@ func TestHash(t *testing.T) {
 			},
 			&logical.Response{
 				Data: map[string]interface{}{
-					"foo": "sha1:62cdb7020ff920e5aa642c3d4066950dd1f01f4d",
+					"foo": "hmac-sha256:c15e9586fe4826c3a8b982297760cdd8b6d5d3bca45920fa18e862c9c899377d",
 				},
 			},
 		},
@@ -120,7 +120,7 @@ func TestHash(t *testing.T) {
 
 	for _, tc := range cases {
 		input := fmt.Sprintf("%#v", tc.Input)
-		if err := Hash(tc.Input); err != nil {
+		if err := Hash("salt", tc.Input); err != nil {
 			t.Fatalf("err: %s\n\n%s", err, input)
 		}
 		if !reflect.DeepEqual(tc.Input, tc.Output) {
@@ -178,3 +178,23 @@ func TestHashSHA1(t *testing.T) {
 		t.Fatalf("bad: %#v", result)
 	}
 }
+
+func TestHashHMACSHA256(t *testing.T) {
+	fn := HashHMACSHA256("salt")
+	result, err := fn("foo")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result != "hmac-sha256:6a9534d88e984dfcea835f190147b72b3f647fdcc2409e5b8be8b331ec7fe8a5" {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// A different salt results in a different hash
+	other, err := HashHMACSHA256("other")("foo")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if other == result {
+		t.Fatalf("bad: %#v", other)
+	}
+}
@@ -1,26 +1,28 @@
 package file
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/vault/audit"
 	"github.com/hashicorp/vault/logical"
 	"github.com/mitchellh/copystructure"
 )
 
-func Factory(conf map[string]string) (audit.Backend, error) {
-	path, ok := conf["path"]
+func Factory(conf *audit.BackendConfig) (audit.Backend, error) {
+	path, ok := conf.Config["path"]
 	if !ok {
 		return nil, fmt.Errorf("path is required")
 	}
 
 	// Check if raw logging is enabled
 	logRaw := false
-	if raw, ok := conf["log_raw"]; ok {
+	if raw, ok := conf.Config["log_raw"]; ok {
 		b, err := strconv.ParseBool(raw)
 		if err != nil {
 			return nil, err
@@ -28,30 +30,86 @@ func Factory(conf map[string]string) (audit.Backend, error) {
 		logRaw = b
 	}
 
+	// Check if the file is rotated by size or age
+	var rotateBytes int64
+	if raw, ok := conf.Config["rotate_bytes"]; ok {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("rotate_bytes cannot be negative")
+		}
+		rotateBytes = n
+	}
+	var rotateAge time.Duration
+	if raw, ok := conf.Config["rotate_duration"]; ok {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return nil, err
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("rotate_duration cannot be negative")
+		}
+		rotateAge = d
+	}
+	rotateKeep := defaultRotateKeep
+	if raw, ok := conf.Config["rotate_keep"]; ok {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("rotate_keep cannot be negative")
+		}
+		rotateKeep = n
+	}
+
 	b := &Backend{
-		Path:   path,
-		LogRaw: logRaw,
+		Path:        path,
+		LogRaw:      logRaw,
+		RotateBytes: rotateBytes,
+		RotateAge:   rotateAge,
+		RotateKeep:  rotateKeep,
+		salt:        conf.Salt,
 	}
 	return b, nil
 }
 
-// Backend is the audit backend for the file-based audit store.
-//
-// NOTE: This audit backend is currently very simple: it appends to a file.
-// It doesn't do anything more at the moment to assist with rotation
-// or reset the write cursor, this should be done in the future.
+// defaultRotateKeep is the number of rotated files kept by default
+const defaultRotateKeep = 5
+
+// Backend is the audit backend for the file-based audit store. It
+// appends to a file, which is optionally rotated when it grows past
+// a size or age. The rotated files are numbered from the most recent,
+// so that the file at "<path>.1" was rotated last.
 type Backend struct {
 	Path   string
 	LogRaw bool
 
-	once sync.Once
+	// RotateBytes is the size and RotateAge is the age past which the
+	// file is rotated. Either is disabled if zero.
+	RotateBytes int64
+	RotateAge   time.Duration
+
+	// RotateKeep is the number of rotated files to keep
+	RotateKeep int
+
+	// salt is used to hash the sensitive values unless logged raw
+	salt string
+
+	// l protects the file, which is written to concurrently
+	l    sync.Mutex
 	f    *os.File
+	size int64
+
+	// started is when the file was started, which for a file that
+	// already has entries when it is opened is its modification time,
+	// so that reopening the file does not reset its age
+	started time.Time
 }
 
 func (b *Backend) LogRequest(auth *logical.Auth, req *logical.Request) error {
-	if err := b.open(); err != nil {
-		return err
-	}
 	if !b.LogRaw {
 		// Copy the structures
 		cp, err := copystructure.Copy(auth)
@@ -67,16 +125,20 @@ func (b *Backend) LogRequest(auth *logical.Auth, req *logical.Request) error {
 		req = cp.(*logical.Request)
 
 		// Hash any sensitive information
-		if err := audit.Hash(auth); err != nil {
+		if err := audit.Hash(b.salt, auth); err != nil {
 			return err
 		}
-		if err := audit.Hash(req); err != nil {
+		if err := audit.Hash(b.salt, req); err != nil {
 			return err
 		}
 	}
 
+	var buf bytes.Buffer
 	var format audit.FormatJSON
-	return format.FormatRequest(b.f, auth, req)
+	if err := format.FormatRequest(&buf, auth, req); err != nil {
+		return err
+	}
+	return b.write(buf.Bytes())
 }
 
 func (b *Backend) LogResponse(
@@ -84,9 +146,6 @@ func (b *Backend) LogResponse(
 	req *logical.Request,
 	resp *logical.Response,
 	err error) error {
-	if err := b.open(); err != nil {
-		return err
-	}
 	if !b.LogRaw {
 		// Copy the structure
 		cp, err := copystructure.Copy(auth)
@@ -108,21 +167,49 @@ func (b *Backend) LogResponse(
 		resp = cp.(*logical.Response)
 
 		// Hash any sensitive information
-		if err := audit.Hash(auth); err != nil {
+		if err := audit.Hash(b.salt, auth); err != nil {
 			return err
 		}
-		if err := audit.Hash(req); err != nil {
+		if err := audit.Hash(b.salt, req); err != nil {
 			return err
 		}
-		if err := audit.Hash(resp); err != nil {
+		if err := audit.Hash(b.salt, resp); err != nil {
 			return err
 		}
 	}
 
+	var buf bytes.Buffer
 	var format audit.FormatJSON
-	return format.FormatResponse(b.f, auth, req, resp, err)
+	if err := format.FormatResponse(&buf, auth, req, resp, err); err != nil {
+		return err
+	}
+	return b.write(buf.Bytes())
 }
 
+// write is used to append an entry to the file, rotating the file first
+// if the entry would take it past its size or it is past its age. The
+// entry is written at once so that concurrent entries are not mixed.
+func (b *Backend) write(entry []byte) error {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	if err := b.open(); err != nil {
+		return err
+	}
+	if b.size > 0 &&
+		((b.RotateBytes > 0 && b.size+int64(len(entry)) > b.RotateBytes) ||
+			(b.RotateAge > 0 && time.Since(b.started) >= b.RotateAge)) {
+		if err := b.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := b.f.Write(entry)
+	b.size += int64(n)
+	return err
+}
+
+// open is used to open the file if it is not yet, with the lock held
 func (b *Backend) open() error {
 	if b.f != nil {
 		return nil
@@ -131,11 +218,56 @@ func (b *Backend) open() error {
 		return err
 	}
 
-	var err error
-	b.f, err = os.OpenFile(b.Path, os.O_APPEND|os.O_WRONLY, 0600)
+	f, err := os.OpenFile(b.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
 	if err != nil {
+		f.Close()
 		return err
 	}
 
+	b.f = f
+	b.size = info.Size()
+	b.started = time.Now()
+	if b.size > 0 {
+		b.started = info.ModTime()
+	}
 	return nil
 }
+
+// rotate is used to move the file aside and open a new one, with the
+// lock held. The rotated files past the number to keep are removed.
+func (b *Backend) rotate() error {
+	if err := b.f.Close(); err != nil {
+		return err
+	}
+	b.f = nil
+
+	// Shift the rotated files, dropping the oldest
+	if b.RotateKeep == 0 {
+		if err := os.Remove(b.Path); err != nil {
+			return err
+		}
+		return b.open()
+	}
+	if err := os.Remove(b.rotatedPath(b.RotateKeep)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := b.RotateKeep - 1; i > 0; i-- {
+		err := os.Rename(b.rotatedPath(i), b.rotatedPath(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(b.Path, b.rotatedPath(1)); err != nil {
+		return err
+	}
+	return b.open()
+}
+
+// rotatedPath returns the path of the rotated file with the given number
+func (b *Backend) rotatedPath(n int) string {
+	return fmt.Sprintf("%s.%d", b.Path, n)
+}
@@ -0,0 +1,193 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestFactory_Invalid(t *testing.T) {
+	confs := []map[string]string{
+		{},
+		{"path": "audit.log", "rotate_bytes": "foo"},
+		{"path": "audit.log", "rotate_bytes": "-1"},
+		{"path": "audit.log", "rotate_duration": "foo"},
+		{"path": "audit.log", "rotate_keep": "-1"},
+	}
+	for _, conf := range confs {
+		if _, err := Factory(&audit.BackendConfig{Config: conf}); err == nil {
+			t.Fatalf("expected error: %#v", conf)
+		}
+	}
+}
+
+func TestBackend_RotateBytes(t *testing.T) {
+	dir := testTempDir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	raw, err := Factory(&audit.BackendConfig{Config: map[string]string{
+		"path":         path,
+		"rotate_bytes": "1",
+		"rotate_keep":  "2",
+	}})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	b := raw.(*Backend)
+
+	// Every entry is past the size, so each is rotated by the next
+	for _, p := range []string{"first", "second", "third", "fourth"} {
+		if err := b.LogRequest(&logical.Auth{}, &logical.Request{Path: p}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	testFileContains(t, path, "fourth")
+	testFileContains(t, path+".1", "third")
+	testFileContains(t, path+".2", "second")
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestBackend_RotateAge(t *testing.T) {
+	dir := testTempDir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	raw, err := Factory(&audit.BackendConfig{Config: map[string]string{
+		"path":            path,
+		"rotate_duration": "50ms",
+	}})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	b := raw.(*Backend)
+
+	if err := b.LogRequest(&logical.Auth{}, &logical.Request{Path: "first"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := b.LogRequest(&logical.Auth{}, &logical.Request{Path: "second"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Fatalf("err: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if err := b.LogRequest(&logical.Auth{}, &logical.Request{Path: "third"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testFileContains(t, path, "third")
+	testFileContains(t, path+".1", "second")
+}
+
+func TestBackend_RotateAge_Reopen(t *testing.T) {
+	dir := testTempDir(t)
+	defer os.RemoveAll(dir)
+
+	// An existing file is as old as its last modification
+	path := filepath.Join(dir, "audit.log")
+	if err := ioutil.WriteFile(path, []byte("old\n"), 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, past, past); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	raw, err := Factory(&audit.BackendConfig{Config: map[string]string{
+		"path":            path,
+		"rotate_duration": "1m",
+	}})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	b := raw.(*Backend)
+
+	if err := b.LogRequest(&logical.Auth{}, &logical.Request{Path: "first"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testFileContains(t, path, "first")
+	testFileContains(t, path+".1", "old")
+}
+
+func TestBackend_Concurrent(t *testing.T) {
+	dir := testTempDir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	raw, err := Factory(&audit.BackendConfig{Config: map[string]string{
+		"path":         path,
+		"rotate_bytes": "4096",
+		"rotate_keep":  "100",
+	}})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	b := raw.(*Backend)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				b.LogRequest(&logical.Auth{}, &logical.Request{Path: "foo"})
+				b.LogResponse(&logical.Auth{}, &logical.Request{Path: "foo"}, nil, nil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every entry is written whole, and the files stay within the size
+	files, err := filepath.Glob(path + "*")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	lines := 0
+	for _, f := range files {
+		raw, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if len(raw) > 4096 {
+			t.Fatalf("bad: %s is %d bytes", f, len(raw))
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(raw)), "\n") {
+			if !strings.HasPrefix(line, "{") || !strings.HasSuffix(line, "}") {
+				t.Fatalf("bad: %s", line)
+			}
+			lines++
+		}
+	}
+	if lines != 400 {
+		t.Fatalf("bad: %d", lines)
+	}
+}
+
+func testTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "vault-audit")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return dir
+}
+
+func testFileContains(t *testing.T, path, s string) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !strings.Contains(string(raw), s) {
+		t.Fatalf("bad: %s does not contain %s: %s", path, s, raw)
+	}
+}
@@ -0,0 +1,363 @@
+package kafka
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/logical"
+	"github.com/mitchellh/copystructure"
+)
+
+const (
+	// defaultBufferSize is the number of entries buffered by default
+	// while they wait to be delivered
+	defaultBufferSize = 1024
+
+	// defaultBatchSize is the number of entries delivered together
+	defaultBatchSize = 100
+
+	// defaultFlushInterval is how long an entry waits for a batch to fill
+	defaultFlushInterval = time.Second
+
+	// defaultTimeout bounds connecting to and each request to the broker
+	defaultTimeout = 10 * time.Second
+)
+
+func Factory(conf *audit.BackendConfig) (audit.Backend, error) {
+	address, ok := conf.Config["address"]
+	if !ok {
+		return nil, fmt.Errorf("address is required")
+	}
+	topic, ok := conf.Config["topic"]
+	if !ok {
+		return nil, fmt.Errorf("topic is required")
+	}
+
+	var partition int32
+	if raw, ok := conf.Config["partition"]; ok {
+		n, err := strconv.ParseInt(raw, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("partition cannot be negative")
+		}
+		partition = int32(n)
+	}
+
+	// Check if raw logging is enabled
+	logRaw := false
+	if raw, ok := conf.Config["log_raw"]; ok {
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, err
+		}
+		logRaw = b
+	}
+
+	// Check if logging blocks rather than drops when the buffer is full.
+	// This is the default, so that entries are not lost silently.
+	blockOnFull := true
+	if raw, ok := conf.Config["block_on_full"]; ok {
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, err
+		}
+		blockOnFull = b
+	}
+
+	bufferSize, err := parsePositiveInt(conf.Config, "buffer_size", defaultBufferSize)
+	if err != nil {
+		return nil, err
+	}
+	batchSize, err := parsePositiveInt(conf.Config, "batch_size", defaultBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	flushInterval, err := parsePositiveDuration(conf.Config, "flush_interval", defaultFlushInterval)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := parsePositiveDuration(conf.Config, "timeout", defaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &brokerProducer{
+		address:   address,
+		topic:     topic,
+		partition: partition,
+		timeout:   timeout,
+	}
+	return newBackend(p, conf.Salt, logRaw, blockOnFull, bufferSize,
+		batchSize, flushInterval), nil
+}
+
+// Backend is the audit backend for a Kafka topic. Each entry is produced
+// as a JSON message. Entries are buffered and delivered in batches in
+// the background, so that logging does not wait for Kafka. While Kafka
+// is unavailable, a batch is retried until it is delivered, and logging
+// blocks once the buffer is full, unless the backend is configured to
+// drop the new entries instead. The backend is not fail-closed: a
+// request is never failed because its entry was not delivered.
+type Backend struct {
+	logRaw      bool
+	blockOnFull bool
+
+	// salt is used to hash the sensitive values unless logged raw
+	salt string
+
+	producer      producer
+	batchSize     int
+	flushInterval time.Duration
+
+	entryCh   chan []byte
+	stopCh    chan struct{}
+	doneCh    chan struct{}
+	closeOnce sync.Once
+}
+
+// newBackend is used to create a backend delivering to the producer, and
+// start its delivery in the background
+func newBackend(p producer, salt string, logRaw, blockOnFull bool,
+	bufferSize, batchSize int, flushInterval time.Duration) *Backend {
+	b := &Backend{
+		logRaw:        logRaw,
+		blockOnFull:   blockOnFull,
+		salt:          salt,
+		producer:      p,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		entryCh:       make(chan []byte, bufferSize),
+		stopCh:        make(chan struct{}),
+		doneCh:        make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func (b *Backend) LogRequest(auth *logical.Auth, req *logical.Request) error {
+	if !b.logRaw {
+		// Copy the structures
+		cp, err := copystructure.Copy(auth)
+		if err != nil {
+			return err
+		}
+		auth = cp.(*logical.Auth)
+
+		cp, err = copystructure.Copy(req)
+		if err != nil {
+			return err
+		}
+		req = cp.(*logical.Request)
+
+		// Hash any sensitive information
+		if err := audit.Hash(b.salt, auth); err != nil {
+			return err
+		}
+		if err := audit.Hash(b.salt, req); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	var format audit.FormatJSON
+	if err := format.FormatRequest(&buf, auth, req); err != nil {
+		return err
+	}
+	return b.enqueue(buf.Bytes())
+}
+
+func (b *Backend) LogResponse(
+	auth *logical.Auth,
+	req *logical.Request,
+	resp *logical.Response,
+	err error) error {
+	if !b.logRaw {
+		// Copy the structure
+		cp, err := copystructure.Copy(auth)
+		if err != nil {
+			return err
+		}
+		auth = cp.(*logical.Auth)
+
+		cp, err = copystructure.Copy(req)
+		if err != nil {
+			return err
+		}
+		req = cp.(*logical.Request)
+
+		cp, err = copystructure.Copy(resp)
+		if err != nil {
+			return err
+		}
+		resp = cp.(*logical.Response)
+
+		// Hash any sensitive information
+		if err := audit.Hash(b.salt, auth); err != nil {
+			return err
+		}
+		if err := audit.Hash(b.salt, req); err != nil {
+			return err
+		}
+		if err := audit.Hash(b.salt, resp); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	var format audit.FormatJSON
+	if err := format.FormatResponse(&buf, auth, req, resp, err); err != nil {
+		return err
+	}
+	return b.enqueue(buf.Bytes())
+}
+
+// Close is used to stop the delivery, making a last attempt to deliver
+// the buffered entries. It is called when the backend is disabled.
+func (b *Backend) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.stopCh)
+	})
+	<-b.doneCh
+	return nil
+}
+
+// enqueue is used to buffer an entry for delivery. If the buffer is full
+// the entry is dropped, or it waits for room if the backend blocks.
+func (b *Backend) enqueue(entry []byte) error {
+	entry = bytes.TrimRight(entry, "\n")
+	select {
+	case <-b.stopCh:
+		return fmt.Errorf("audit backend is closed")
+	default:
+	}
+
+	if b.blockOnFull {
+		select {
+		case b.entryCh <- entry:
+			return nil
+		case <-b.stopCh:
+			return fmt.Errorf("audit backend is closed")
+		}
+	}
+
+	select {
+	case b.entryCh <- entry:
+	default:
+		metrics.IncrCounter([]string{"audit", "kafka", "dropped"}, 1)
+	}
+	return nil
+}
+
+// run is a long running routine that delivers the buffered entries in
+// batches, once a batch is full or the flush interval has passed
+func (b *Backend) run() {
+	defer close(b.doneCh)
+	defer b.producer.Close()
+
+	var batch [][]byte
+	ticker := time.NewTicker(b.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case entry := <-b.entryCh:
+			batch = append(batch, entry)
+			if len(batch) < b.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-b.stopCh:
+			b.drain(batch)
+			return
+		}
+
+		// Retry the batch until it is delivered, so that entries are
+		// only lost once the buffer is full
+		for !b.deliver(batch) {
+			select {
+			case <-ticker.C:
+			case <-b.stopCh:
+				b.drain(batch)
+				return
+			}
+		}
+		batch = nil
+	}
+}
+
+// drain is used to make a last attempt to deliver the batch and the
+// buffered entries when the backend is closed
+func (b *Backend) drain(batch [][]byte) {
+	for more := true; more; {
+		select {
+		case entry := <-b.entryCh:
+			batch = append(batch, entry)
+		default:
+			more = false
+		}
+	}
+	for len(batch) > 0 {
+		n := len(batch)
+		if n > b.batchSize {
+			n = b.batchSize
+		}
+		if !b.deliver(batch[:n]) {
+			metrics.IncrCounter([]string{"audit", "kafka", "dropped"}, float32(len(batch)))
+			return
+		}
+		batch = batch[n:]
+	}
+}
+
+// deliver is used to produce a batch, returning whether it succeeded
+func (b *Backend) deliver(batch [][]byte) bool {
+	defer metrics.MeasureSince([]string{"audit", "kafka", "produce"}, time.Now())
+	if err := b.producer.Produce(batch); err != nil {
+		metrics.IncrCounter([]string{"audit", "kafka", "produce_error"}, 1)
+		return false
+	}
+	return true
+}
+
+// parsePositiveInt is used to parse an optional option that must be a
+// positive integer
+func parsePositiveInt(conf map[string]string, key string, def int) (int, error) {
+	raw, ok := conf[key]
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("%s must be positive", key)
+	}
+	return n, nil
+}
+
+// parsePositiveDuration is used to parse an optional option that must be
+// a positive duration
+func parsePositiveDuration(conf map[string]string, key string, def time.Duration) (time.Duration, error) {
+	raw, ok := conf[key]
+	if !ok {
+		return def, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s must be positive", key)
+	}
+	return d, nil
+}
@@ -0,0 +1,273 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestFactory_Invalid(t *testing.T) {
+	confs := []map[string]string{
+		{},
+		{"address": "127.0.0.1:9092"},
+		{"topic": "audit"},
+		{"address": "127.0.0.1:9092", "topic": "audit", "partition": "-1"},
+		{"address": "127.0.0.1:9092", "topic": "audit", "buffer_size": "0"},
+		{"address": "127.0.0.1:9092", "topic": "audit", "batch_size": "foo"},
+		{"address": "127.0.0.1:9092", "topic": "audit", "flush_interval": "0s"},
+		{"address": "127.0.0.1:9092", "topic": "audit", "block_on_full": "foo"},
+	}
+	for _, conf := range confs {
+		if _, err := Factory(&audit.BackendConfig{Config: conf}); err == nil {
+			t.Fatalf("expected error: %#v", conf)
+		}
+	}
+}
+
+func TestBackend_Produce(t *testing.T) {
+	broker := testBroker(t)
+	defer broker.Close()
+
+	raw, err := Factory(&audit.BackendConfig{
+		Salt: "foo",
+		Config: map[string]string{
+			"address":        broker.Addr().String(),
+			"topic":          "audit",
+			"flush_interval": "10ms",
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	b := raw.(*Backend)
+	if !b.blockOnFull {
+		t.Fatalf("should block on full by default")
+	}
+
+	req := &logical.Request{
+		ID:   "abcd",
+		Path: "secret/foo",
+		Data: map[string]interface{}{"password": "secret"},
+	}
+	if err := b.LogRequest(&logical.Auth{}, req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := b.LogResponse(&logical.Auth{}, req, &logical.Response{}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	msgs := broker.messages("audit")
+	if len(msgs) != 2 {
+		t.Fatalf("bad: %#v", msgs)
+	}
+	for _, m := range msgs {
+		if !strings.Contains(m, `"id":"abcd"`) {
+			t.Fatalf("bad: %s", m)
+		}
+		if strings.Contains(m, `"password":"secret"`) || strings.HasSuffix(m, "\n") {
+			t.Fatalf("bad: %q", m)
+		}
+	}
+	if !strings.Contains(msgs[0], `"type":"request"`) ||
+		!strings.Contains(msgs[1], `"type":"response"`) {
+		t.Fatalf("bad: %#v", msgs)
+	}
+}
+
+func TestBackend_Drop(t *testing.T) {
+	p := &testProducer{fail: true}
+	b := newBackend(p, "", true, false, 1, 1, 10*time.Millisecond)
+
+	// Logging does not fail or wait while Kafka is unavailable, the
+	// entries past the buffer are dropped
+	for i := 0; i < 5; i++ {
+		if err := b.LogRequest(&logical.Auth{}, &logical.Request{Path: "foo"}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// The buffered entries are delivered once Kafka is available
+	p.setFail(false)
+	b.Close()
+	if n := p.count(); n == 0 || n > 2 {
+		t.Fatalf("bad: %d", n)
+	}
+	if err := b.LogRequest(&logical.Auth{}, &logical.Request{Path: "foo"}); err == nil {
+		t.Fatalf("should fail when closed")
+	}
+}
+
+func TestBackend_BlockOnFull(t *testing.T) {
+	p := &testProducer{fail: true}
+	b := newBackend(p, "", true, true, 1, 1, 10*time.Millisecond)
+	defer b.Close()
+
+	// The first entry is retried and the second fills the buffer
+	for i := 0; i < 2; i++ {
+		if err := b.LogRequest(&logical.Auth{}, &logical.Request{Path: "foo"}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	doneCh := make(chan error)
+	go func() {
+		doneCh <- b.LogRequest(&logical.Auth{}, &logical.Request{Path: "foo"})
+	}()
+	select {
+	case err := <-doneCh:
+		t.Fatalf("should block: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	p.setFail(false)
+	select {
+	case err := <-doneCh:
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("should not block")
+	}
+}
+
+// testProducer is a producer that counts the delivered messages, and
+// fails while told to
+type testProducer struct {
+	l         sync.Mutex
+	fail      bool
+	delivered int
+}
+
+func (p *testProducer) Produce(messages [][]byte) error {
+	p.l.Lock()
+	defer p.l.Unlock()
+	if p.fail {
+		return errors.New("unavailable")
+	}
+	p.delivered += len(messages)
+	return nil
+}
+
+func (p *testProducer) Close() error {
+	return nil
+}
+
+func (p *testProducer) setFail(fail bool) {
+	p.l.Lock()
+	defer p.l.Unlock()
+	p.fail = fail
+}
+
+func (p *testProducer) count() int {
+	p.l.Lock()
+	defer p.l.Unlock()
+	return p.delivered
+}
+
+// testKafkaBroker is a fake Kafka broker that accepts Produce requests
+// and records the messages of each topic
+type testKafkaBroker struct {
+	net.Listener
+
+	l    sync.Mutex
+	msgs map[string][]string
+}
+
+func testBroker(t *testing.T) *testKafkaBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	b := &testKafkaBroker{Listener: ln, msgs: make(map[string][]string)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(t, conn)
+		}
+	}()
+	return b
+}
+
+func (b *testKafkaBroker) messages(topic string) []string {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return b.msgs[topic]
+}
+
+func (b *testKafkaBroker) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size int32
+		if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+			return
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+
+		var header struct {
+			APIKey, APIVersion int16
+			CorrelationID      int32
+		}
+		r := bytes.NewReader(buf)
+		binary.Read(r, binary.BigEndian, &header)
+		readString(r)
+		var acks int16
+		var timeout, topics, partitions, partition, setSize int32
+		binary.Read(r, binary.BigEndian, &acks)
+		binary.Read(r, binary.BigEndian, &timeout)
+		binary.Read(r, binary.BigEndian, &topics)
+		topic, _ := readString(r)
+		binary.Read(r, binary.BigEndian, &partitions)
+		binary.Read(r, binary.BigEndian, &partition)
+		binary.Read(r, binary.BigEndian, &setSize)
+		if header.APIKey != produceAPIKey || topics != 1 || partitions != 1 {
+			t.Errorf("bad request: %#v", header)
+			return
+		}
+
+		// Read the message set, which has no keys
+		for r.Len() > 0 {
+			var msg struct {
+				Offset             int64
+				Size               int32
+				CRC                uint32
+				Magic, Attributes  int8
+				KeySize, ValueSize int32
+			}
+			binary.Read(r, binary.BigEndian, &msg)
+			value := make([]byte, msg.ValueSize)
+			io.ReadFull(r, value)
+			b.l.Lock()
+			b.msgs[topic] = append(b.msgs[topic], string(value))
+			b.l.Unlock()
+		}
+
+		var resp bytes.Buffer
+		binary.Write(&resp, binary.BigEndian, header.CorrelationID)
+		binary.Write(&resp, binary.BigEndian, int32(1))
+		writeString(&resp, topic)
+		binary.Write(&resp, binary.BigEndian, int32(1))
+		binary.Write(&resp, binary.BigEndian, partition)
+		binary.Write(&resp, binary.BigEndian, int16(0))
+		binary.Write(&resp, binary.BigEndian, int64(0))
+		binary.Write(conn, binary.BigEndian, int32(resp.Len()))
+		conn.Write(resp.Bytes())
+	}
+}
@@ -0,0 +1,202 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	// produceAPIKey is the key of the Kafka Produce API, which is used at
+	// version 0 so that any broker version accepts it
+	produceAPIKey     = 0
+	produceAPIVersion = 0
+
+	// produceRequiredAcks waits for the leader of the partition to write
+	// the messages before responding
+	produceRequiredAcks = 1
+
+	// clientID identifies Vault to the broker
+	clientID = "vault"
+)
+
+// producer is used to deliver a batch of messages to Kafka
+type producer interface {
+	Produce(messages [][]byte) error
+	Close() error
+}
+
+// brokerProducer is a minimal Kafka producer that sends messages to a
+// single partition of a topic using the Produce API. The broker must be
+// the leader of the partition, since the cluster metadata is not used
+// to find it. The connection is opened on first use, and reopened after
+// any failure.
+type brokerProducer struct {
+	address   string
+	topic     string
+	partition int32
+	timeout   time.Duration
+
+	conn          net.Conn
+	correlationID int32
+}
+
+// Produce is used to send a batch of messages, returning once the leader
+// of the partition has acknowledged them
+func (p *brokerProducer) Produce(messages [][]byte) error {
+	if p.conn == nil {
+		conn, err := net.DialTimeout("tcp", p.address, p.timeout)
+		if err != nil {
+			return err
+		}
+		p.conn = conn
+	}
+
+	err := p.produce(messages)
+	if err != nil {
+		p.Close()
+	}
+	return err
+}
+
+func (p *brokerProducer) produce(messages [][]byte) error {
+	p.correlationID++
+	req := encodeProduceRequest(p.correlationID, p.topic, p.partition,
+		int32(p.timeout/time.Millisecond), messages)
+
+	p.conn.SetDeadline(time.Now().Add(p.timeout))
+	if _, err := p.conn.Write(req); err != nil {
+		return err
+	}
+
+	// Read the response, which is prefixed with its size
+	var size int32
+	if err := binary.Read(p.conn, binary.BigEndian, &size); err != nil {
+		return err
+	}
+	if size < 4 {
+		return fmt.Errorf("invalid response size %d", size)
+	}
+	resp := make([]byte, size)
+	if _, err := io.ReadFull(p.conn, resp); err != nil {
+		return err
+	}
+	return decodeProduceResponse(p.correlationID, resp)
+}
+
+// Close is used to close the connection to the broker
+func (p *brokerProducer) Close() error {
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
+
+// encodeProduceRequest is used to encode a Produce request of the given
+// messages to a partition, prefixed with its size. The messages are
+// encoded as a message set without keys or compression.
+func encodeProduceRequest(correlationID int32, topic string, partition,
+	timeoutMs int32, messages [][]byte) []byte {
+	var set bytes.Buffer
+	for _, m := range messages {
+		var msg bytes.Buffer
+		msg.WriteByte(0) // magic
+		msg.WriteByte(0) // attributes
+		binary.Write(&msg, binary.BigEndian, int32(-1))
+		binary.Write(&msg, binary.BigEndian, int32(len(m)))
+		msg.Write(m)
+
+		binary.Write(&set, binary.BigEndian, int64(0)) // offset
+		binary.Write(&set, binary.BigEndian, int32(4+msg.Len()))
+		binary.Write(&set, binary.BigEndian, crc32.ChecksumIEEE(msg.Bytes()))
+		set.Write(msg.Bytes())
+	}
+
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, int16(produceAPIKey))
+	binary.Write(&body, binary.BigEndian, int16(produceAPIVersion))
+	binary.Write(&body, binary.BigEndian, correlationID)
+	writeString(&body, clientID)
+	binary.Write(&body, binary.BigEndian, int16(produceRequiredAcks))
+	binary.Write(&body, binary.BigEndian, timeoutMs)
+	binary.Write(&body, binary.BigEndian, int32(1))
+	writeString(&body, topic)
+	binary.Write(&body, binary.BigEndian, int32(1))
+	binary.Write(&body, binary.BigEndian, partition)
+	binary.Write(&body, binary.BigEndian, int32(set.Len()))
+	body.Write(set.Bytes())
+
+	var req bytes.Buffer
+	binary.Write(&req, binary.BigEndian, int32(body.Len()))
+	req.Write(body.Bytes())
+	return req.Bytes()
+}
+
+// decodeProduceResponse is used to check the response to a Produce
+// request, returning the error of any partition
+func decodeProduceResponse(correlationID int32, resp []byte) error {
+	r := bytes.NewReader(resp)
+	var id, topics int32
+	if err := binary.Read(r, binary.BigEndian, &id); err != nil {
+		return err
+	}
+	if id != correlationID {
+		return fmt.Errorf("response to request %d does not match request %d",
+			id, correlationID)
+	}
+	if err := binary.Read(r, binary.BigEndian, &topics); err != nil {
+		return err
+	}
+	for i := int32(0); i < topics; i++ {
+		if _, err := readString(r); err != nil {
+			return err
+		}
+		var partitions int32
+		if err := binary.Read(r, binary.BigEndian, &partitions); err != nil {
+			return err
+		}
+		for j := int32(0); j < partitions; j++ {
+			var result struct {
+				Partition int32
+				ErrorCode int16
+				Offset    int64
+			}
+			if err := binary.Read(r, binary.BigEndian, &result); err != nil {
+				return err
+			}
+			if result.ErrorCode != 0 {
+				return fmt.Errorf("partition %d failed with error code %d",
+					result.Partition, result.ErrorCode)
+			}
+		}
+	}
+	return nil
+}
+
+// writeString is used to write a string prefixed with its size
+func writeString(w *bytes.Buffer, s string) {
+	binary.Write(w, binary.BigEndian, int16(len(s)))
+	w.WriteString(s)
+}
+
+// readString is used to read a string prefixed with its size
+func readString(r io.Reader) (string, error) {
+	var n int16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return "", err
+	}
+	if n < 0 {
+		return "", nil
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
@@ -2,8 +2,11 @@ package cli
 
 import (
 	"os"
+	"os/signal"
+	"syscall"
 
 	auditFile "github.com/hashicorp/vault/builtin/audit/file"
+	auditKafka "github.com/hashicorp/vault/builtin/audit/kafka"
 	auditSyslog "github.com/hashicorp/vault/builtin/audit/syslog"
 
 	credAppId "github.com/hashicorp/vault/builtin/credential/app-id"
@@ -48,9 +51,11 @@ func Commands(metaPtr *command.Meta) map[string]cli.CommandFactory {
 
 		"server": func() (cli.Command, error) {
 			return &command.ServerCommand{
-				Meta: meta,
+				Meta:       meta,
+				ShutdownCh: makeShutdownCh(),
 				AuditBackends: map[string]audit.Factory{
 					"file":   auditFile.Factory,
+					"kafka":  auditKafka.Factory,
 					"syslog": auditSyslog.Factory,
 				},
 				CredentialBackends: map[string]logical.Factory{
@@ -247,3 +252,19 @@ func Commands(metaPtr *command.Meta) map[string]cli.CommandFactory {
 		},
 	}
 }
+
+// makeShutdownCh returns a channel that can be used for shutdown
+// notifications for commands. This channel will send a message for every
+// interrupt or SIGTERM received.
+func makeShutdownCh() <-chan struct{} {
+	resultCh := make(chan struct{})
+	signalCh := make(chan os.Signal, 4)
+	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for {
+			<-signalCh
+			resultCh <- struct{}{}
+		}
+	}()
+	return resultCh
+}
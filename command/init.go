@@ -14,14 +14,21 @@ type InitCommand struct {
 
 func (c *InitCommand) Run(args []string) int {
 	var shares, threshold int
+	var labels string
 	flags := c.Meta.FlagSet("init", FlagSetDefault)
 	flags.Usage = func() { c.Ui.Error(c.Help()) }
 	flags.IntVar(&shares, "key-shares", 5, "")
 	flags.IntVar(&threshold, "key-threshold", 3, "")
+	flags.StringVar(&labels, "key-labels", "", "")
 	if err := flags.Parse(args); err != nil {
 		return 1
 	}
 
+	var shareLabels []string
+	if labels != "" {
+		shareLabels = strings.Split(labels, ",")
+	}
+
 	client, err := c.Client()
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf(
@@ -32,6 +39,7 @@ func (c *InitCommand) Run(args []string) int {
 	resp, err := client.Sys().Init(&api.InitRequest{
 		SecretShares:    shares,
 		SecretThreshold: threshold,
+		ShareLabels:     shareLabels,
 	})
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf(
@@ -40,7 +48,11 @@ func (c *InitCommand) Run(args []string) int {
 	}
 
 	for i, key := range resp.Keys {
-		c.Ui.Output(fmt.Sprintf("Key %d: %s", i+1, key))
+		if len(resp.KeyLabels) > i {
+			c.Ui.Output(fmt.Sprintf("Key %d (%s): %s", i+1, resp.KeyLabels[i], key))
+		} else {
+			c.Ui.Output(fmt.Sprintf("Key %d: %s", i+1, key))
+		}
 	}
 
 	c.Ui.Output(fmt.Sprintf("Initial Root Token: %s", resp.RootToken))
@@ -102,6 +114,10 @@ Init Options:
   -key-threshold=3        The number of key shares required to reconstruct
                           the master key.
 
+  -key-labels=a,b,c       Comma-separated labels, one per key share, that
+                          are printed with the keys for bookkeeping. They
+                          are not stored by Vault.
+
 `
 	return strings.TrimSpace(helpText)
 }
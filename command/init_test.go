@@ -2,6 +2,7 @@ package command
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/vault/http"
@@ -104,3 +105,31 @@ func TestInit_custom(t *testing.T) {
 		t.Fatalf("bad: %#v", sealConf)
 	}
 }
+
+func TestInit_labels(t *testing.T) {
+	ui := new(cli.MockUi)
+	c := &InitCommand{
+		Meta: Meta{
+			Ui: ui,
+		},
+	}
+
+	core := vault.TestCore(t)
+	ln, addr := http.TestServer(t, core)
+	defer ln.Close()
+
+	args := []string{
+		"-address", addr,
+		"-key-shares", "2",
+		"-key-threshold", "2",
+		"-key-labels", "alice,bob",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	output := ui.OutputWriter.String()
+	if !strings.Contains(output, "Key 1 (alice): ") || !strings.Contains(output, "Key 2 (bob): ") {
+		t.Fatalf("bad: %s", output)
+	}
+}
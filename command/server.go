@@ -17,7 +17,9 @@ import (
 	"github.com/hashicorp/vault/command/server"
 	"github.com/hashicorp/vault/helper/flag-slice"
 	"github.com/hashicorp/vault/helper/gated-writer"
+	"github.com/hashicorp/vault/helper/leveled-log"
 	"github.com/hashicorp/vault/helper/mlock"
+	"github.com/hashicorp/vault/helper/prometheus-sink"
 	vaulthttp "github.com/hashicorp/vault/http"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/physical"
@@ -30,6 +32,10 @@ type ServerCommand struct {
 	CredentialBackends map[string]logical.Factory
 	LogicalBackends    map[string]logical.Factory
 
+	// ShutdownCh is closed or signaled to stop the server. The server
+	// runs until killed if it is nil.
+	ShutdownCh <-chan struct{}
+
 	Meta
 }
 
@@ -102,16 +108,38 @@ func (c *ServerCommand) Run(args []string) int {
 		return 1
 	}
 
+	// Initialize the telemetry
+	promSink, err := c.setupTelementry(config)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error initializing telemetry: %s", err))
+		return 1
+	}
+
 	// Initialize the core
-	core, err := vault.NewCore(&vault.CoreConfig{
-		AdvertiseAddr:      config.Backend.AdvertiseAddr,
-		Physical:           backend,
-		AuditBackends:      c.AuditBackends,
-		CredentialBackends: c.CredentialBackends,
-		LogicalBackends:    c.LogicalBackends,
-		Logger:             logger,
-		DisableMlock:       config.DisableMlock,
-	})
+	coreConfig := &vault.CoreConfig{
+		AdvertiseAddr:           config.Backend.AdvertiseAddr,
+		Physical:                backend,
+		AuditBackends:           c.AuditBackends,
+		CredentialBackends:      c.CredentialBackends,
+		LogicalBackends:         c.LogicalBackends,
+		Logger:                  leveledlog.New(logger),
+		DisableMlock:            config.DisableMlock,
+		PrometheusSink:          promSink,
+		EnableRaw:               config.RawStorageEndpoint,
+		CacheVerifyPrefixes:     config.CacheVerifyPrefixes,
+		MaxRequestSize:          config.MaxRequestSize,
+		StorageFailureThreshold: config.StorageFailureThreshold,
+		RequestTimeout:          config.RequestTimeout,
+	}
+	if config.RateLimit != nil {
+		coreConfig.RateLimit = vault.RateLimitConfig{
+			TokenRate:   config.RateLimit.TokenRate,
+			TokenBurst:  config.RateLimit.TokenBurst,
+			GlobalRate:  config.RateLimit.GlobalRate,
+			GlobalBurst: config.RateLimit.GlobalBurst,
+		}
+	}
+	core, err := vault.NewCore(coreConfig)
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error initializing core: %s", err))
 		return 1
@@ -143,6 +171,15 @@ func (c *ServerCommand) Run(args []string) int {
 		))
 	}
 
+	// Unseal using the master key source if one is configured, otherwise
+	// the Vault waits for the unseal keys as usual
+	if !dev {
+		if _, err := core.AutoUnseal(); err != nil {
+			c.Ui.Output(fmt.Sprintf(
+				"==> WARNING: Auto-unseal failed, the unseal keys are required: %s\n", err))
+		}
+	}
+
 	// Compile server information for output later
 	infoKeys := make([]string, 0, 10)
 	info := make(map[string]string)
@@ -158,12 +195,6 @@ func (c *ServerCommand) Run(args []string) int {
 		info["backend"] += " (HA available)"
 	}
 
-	// Initialize the telemetry
-	if err := c.setupTelementry(config); err != nil {
-		c.Ui.Error(fmt.Sprintf("Error initializing telemetry: %s", err))
-		return 1
-	}
-
 	// Initialize the listeners
 	lns := make([]net.Listener, 0, len(config.Listeners))
 	for i, lnConfig := range config.Listeners {
@@ -215,7 +246,14 @@ func (c *ServerCommand) Run(args []string) int {
 	// Release the log gate.
 	logGate.Flush()
 
-	<-make(chan struct{})
+	// Wait for a shutdown, then stop the core so that another Vault can
+	// take over without waiting for the HA lock to expire
+	<-c.ShutdownCh
+	c.Ui.Output("==> Vault shutdown triggered")
+	if err := core.Shutdown(); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error shutting down core: %s", err))
+		return 1
+	}
 	return 0
 }
 
@@ -254,8 +292,10 @@ func (c *ServerCommand) enableDev(core *vault.Core) (*vault.InitResult, error) {
 	return init, nil
 }
 
-// setupTelementry is used ot setup the telemetry sub-systems
-func (c *ServerCommand) setupTelementry(config *server.Config) error {
+// setupTelementry is used ot setup the telemetry sub-systems. The
+// Prometheus sink is returned if it is enabled, so that it can be exposed
+// by the core.
+func (c *ServerCommand) setupTelementry(config *server.Config) (*prometheussink.Sink, error) {
 	/* Setup telemetry
 	Aggregate on 10 second intervals for 1 minute. Expose the
 	metrics over stderr when there is a SIGUSR1 received.
@@ -269,7 +309,7 @@ func (c *ServerCommand) setupTelementry(config *server.Config) error {
 	if config.StatsiteAddr != "" {
 		sink, err := metrics.NewStatsiteSink(config.StatsiteAddr)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		fanout = append(fanout, sink)
 	}
@@ -278,11 +318,18 @@ func (c *ServerCommand) setupTelementry(config *server.Config) error {
 	if config.StatsdAddr != "" {
 		sink, err := metrics.NewStatsdSink(config.StatsdAddr)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		fanout = append(fanout, sink)
 	}
 
+	// Configure the Prometheus sink
+	var promSink *prometheussink.Sink
+	if config.PrometheusMetrics {
+		promSink = prometheussink.New(metricsConf.HostName)
+		fanout = append(fanout, promSink)
+	}
+
 	// Initialize the global sink
 	if len(fanout) > 0 {
 		fanout = append(fanout, inm)
@@ -291,7 +338,7 @@ func (c *ServerCommand) setupTelementry(config *server.Config) error {
 		metricsConf.EnableHostname = false
 		metrics.NewGlobal(metricsConf, inm)
 	}
-	return nil
+	return promSink, nil
 }
 
 func (c *ServerCommand) Synopsis() string {
@@ -6,7 +6,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/hcl"
 	hclobj "github.com/hashicorp/hcl/hcl"
@@ -20,6 +22,30 @@ type Config struct {
 	DisableMlock bool   `hcl:"disable_mlock"`
 	StatsiteAddr string `hcl:"statsite_addr"`
 	StatsdAddr   string `hcl:"statsd_addr"`
+
+	// PrometheusMetrics exposes the metrics at /v1/sys/metrics in the
+	// Prometheus text format
+	PrometheusMetrics bool `hcl:"prometheus_metrics"`
+
+	// RawStorageEndpoint exposes sys/raw for recovering from corruption
+	RawStorageEndpoint bool `hcl:"raw_storage_endpoint"`
+
+	// CacheVerifyPrefixes are the prefixes of the keys whose writes are
+	// read back from the backend before they are cached
+	CacheVerifyPrefixes []string `hcl:"cache_verify_prefixes"`
+
+	// MaxRequestSize is the maximum size in bytes of the data of a request
+	MaxRequestSize int `hcl:"max_request_size"`
+
+	// StorageFailureThreshold is the number of consecutive failed health
+	// checks of the backend after which the Vault seals itself
+	StorageFailureThreshold int `hcl:"storage_failure_threshold"`
+
+	// RequestTimeout is how long a read may take before it is canceled
+	RequestTimeout time.Duration `hcl:"-"`
+
+	// RateLimit limits the rate of requests, if set
+	RateLimit *RateLimit `hcl:"-"`
 }
 
 // DevConfig is a Config that is used for dev mode of Vault.
@@ -63,6 +89,19 @@ func (b *Backend) GoString() string {
 	return fmt.Sprintf("*%#v", *b)
 }
 
+// RateLimit is the rate limit configuration for the server. The rates
+// are in requests per second.
+type RateLimit struct {
+	TokenRate   float64
+	TokenBurst  int
+	GlobalRate  float64
+	GlobalBurst int
+}
+
+func (r *RateLimit) GoString() string {
+	return fmt.Sprintf("*%#v", *r)
+}
+
 // Merge merges two configurations.
 func (c *Config) Merge(c2 *Config) *Config {
 	result := new(Config)
@@ -84,6 +123,29 @@ func (c *Config) Merge(c2 *Config) *Config {
 	if c2.StatsdAddr != "" {
 		result.StatsdAddr = c2.StatsdAddr
 	}
+	result.PrometheusMetrics = c.PrometheusMetrics || c2.PrometheusMetrics
+	result.RawStorageEndpoint = c.RawStorageEndpoint || c2.RawStorageEndpoint
+
+	result.CacheVerifyPrefixes = c.CacheVerifyPrefixes
+	if len(c2.CacheVerifyPrefixes) > 0 {
+		result.CacheVerifyPrefixes = c2.CacheVerifyPrefixes
+	}
+	result.MaxRequestSize = c.MaxRequestSize
+	if c2.MaxRequestSize != 0 {
+		result.MaxRequestSize = c2.MaxRequestSize
+	}
+	result.StorageFailureThreshold = c.StorageFailureThreshold
+	if c2.StorageFailureThreshold != 0 {
+		result.StorageFailureThreshold = c2.StorageFailureThreshold
+	}
+	result.RequestTimeout = c.RequestTimeout
+	if c2.RequestTimeout != 0 {
+		result.RequestTimeout = c2.RequestTimeout
+	}
+	result.RateLimit = c.RateLimit
+	if c2.RateLimit != nil {
+		result.RateLimit = c2.RateLimit
+	}
 
 	return result
 }
@@ -135,6 +197,22 @@ func LoadConfigFile(path string) (*Config, error) {
 			return nil, err
 		}
 	}
+	if o := obj.Get("request_timeout", false); o != nil {
+		var raw string
+		if err := hcl.DecodeObject(&raw, o); err != nil {
+			return nil, fmt.Errorf("Error reading request_timeout: %s", err)
+		}
+		result.RequestTimeout, err = time.ParseDuration(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing request_timeout: %s", err)
+		}
+	}
+	if o := obj.Get("rate_limit", false); o != nil {
+		result.RateLimit, err = loadRateLimit(o)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return &result, nil
 }
@@ -319,3 +397,59 @@ func loadBackend(os *hclobj.Object) (*Backend, error) {
 	result.Config = config
 	return &result, nil
 }
+
+func loadRateLimit(o *hclobj.Object) (*RateLimit, error) {
+	var config map[string]interface{}
+	if err := hcl.DecodeObject(&config, o); err != nil {
+		return nil, fmt.Errorf("Error reading config for rate_limit: %s", err)
+	}
+
+	// The values may be decoded as integers, floats or strings
+	number := func(key string) (float64, error) {
+		switch v := config[key].(type) {
+		case nil:
+			return 0, nil
+		case int:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		case string:
+			return strconv.ParseFloat(v, 64)
+		default:
+			return 0, fmt.Errorf("unexpected type %T", v)
+		}
+	}
+
+	var result RateLimit
+	for key, ptr := range map[string]*float64{
+		"token_rate":  &result.TokenRate,
+		"global_rate": &result.GlobalRate,
+	} {
+		v, err := number(key)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing rate_limit %s: %s", key, err)
+		}
+		*ptr = v
+	}
+	for key, ptr := range map[string]*int{
+		"token_burst":  &result.TokenBurst,
+		"global_burst": &result.GlobalBurst,
+	} {
+		v, err := number(key)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing rate_limit %s: %s", key, err)
+		}
+		if v != float64(int(v)) {
+			return nil, fmt.Errorf("Error parsing rate_limit %s: not an integer", key)
+		}
+		*ptr = int(v)
+	}
+	for key := range config {
+		switch key {
+		case "token_rate", "token_burst", "global_rate", "global_burst":
+		default:
+			return nil, fmt.Errorf("Unknown rate_limit option: %s", key)
+		}
+	}
+	return &result, nil
+}
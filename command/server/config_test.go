@@ -3,6 +3,7 @@ package server
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestLoadConfigFile(t *testing.T) {
@@ -32,6 +33,18 @@ func TestLoadConfigFile(t *testing.T) {
 		DisableMlock: true,
 		StatsiteAddr: "foo",
 		StatsdAddr:   "bar",
+
+		PrometheusMetrics:       true,
+		RawStorageEndpoint:      true,
+		CacheVerifyPrefixes:     []string{"core/", "sys/token/"},
+		MaxRequestSize:          1024,
+		StorageFailureThreshold: 3,
+		RequestTimeout:          30 * time.Second,
+		RateLimit: &RateLimit{
+			TokenRate:  10,
+			TokenBurst: 20,
+			GlobalRate: 0.5,
+		},
 	}
 	if !reflect.DeepEqual(config, expected) {
 		t.Fatalf("bad: %#v", config)
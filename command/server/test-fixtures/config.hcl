@@ -1,6 +1,18 @@
 disable_mlock = true
 statsd_addr = "bar"
 statsite_addr = "foo"
+prometheus_metrics = true
+raw_storage_endpoint = true
+cache_verify_prefixes = ["core/", "sys/token/"]
+max_request_size = 1024
+storage_failure_threshold = 3
+request_timeout = "30s"
+
+rate_limit {
+    token_rate = 10
+    token_burst = 20
+    global_rate = 0.5
+}
 
 listener "tcp" {
     address = "127.0.0.1:443"
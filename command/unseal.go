@@ -19,8 +19,10 @@ type UnsealCommand struct {
 
 func (c *UnsealCommand) Run(args []string) int {
 	var reset bool
+	var nonce string
 	flags := c.Meta.FlagSet("unseal", FlagSetDefault)
 	flags.BoolVar(&reset, "reset", false, "")
+	flags.StringVar(&nonce, "nonce", "", "")
 	flags.Usage = func() { c.Ui.Error(c.Help()) }
 	if err := flags.Parse(args); err != nil {
 		return 1
@@ -57,7 +59,7 @@ func (c *UnsealCommand) Run(args []string) int {
 		}
 	}
 
-	status, err := client.Sys().Unseal(strings.TrimSpace(value))
+	status, err := client.Sys().UnsealWithNonce(nonce, strings.TrimSpace(value))
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf(
 			"Error attempting unseal: %s", err))
@@ -74,6 +76,9 @@ func (c *UnsealCommand) Run(args []string) int {
 		status.T,
 		status.Progress,
 	))
+	if status.Nonce != "" {
+		c.Ui.Output(fmt.Sprintf("Unseal Nonce: %s", status.Nonce))
+	}
 
 	return 0
 }
@@ -115,6 +120,10 @@ General Options:
 
 Unseal Options:
 
+  -nonce=nonce            The nonce of the unseal in progress, which is
+                          output once the first key is entered. Every key
+                          but the first must be entered with the nonce.
+
   -reset                  Reset the unsealing process by throwing away
                           prior keys in process to unseal the vault.
 
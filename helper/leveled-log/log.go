@@ -0,0 +1,91 @@
+package leveledlog
+
+import (
+	"log"
+	"strings"
+)
+
+// Logger is a leveled logger. Each method formats its message as with
+// fmt.Sprintf, and messages are conventionally prefixed with the name
+// of the subsystem logging them, such as "core: ".
+type Logger interface {
+	Error(format string, args ...interface{})
+	Warn(format string, args ...interface{})
+	Info(format string, args ...interface{})
+	Debug(format string, args ...interface{})
+}
+
+// levels maps the level prefixes written by the standard logger adapter
+// to the method of a Logger used to log at that level
+var levels = []struct {
+	prefix string
+	log    func(Logger, string, ...interface{})
+}{
+	{"[ERR] ", Logger.Error},
+	{"[WARN] ", Logger.Warn},
+	{"[INFO] ", Logger.Info},
+	{"[DEBUG] ", Logger.Debug},
+}
+
+// stdLogger is a Logger that writes to a *log.Logger
+type stdLogger struct {
+	logger *log.Logger
+}
+
+// New returns a Logger that writes to the given *log.Logger, prefixing
+// each message with its level, such as "[ERR] ". This is the format
+// expected by logutils.LevelFilter.
+func New(logger *log.Logger) Logger {
+	return &stdLogger{logger: logger}
+}
+
+func (l *stdLogger) Error(format string, args ...interface{}) {
+	l.logger.Printf("[ERR] "+format, args...)
+}
+
+func (l *stdLogger) Warn(format string, args ...interface{}) {
+	l.logger.Printf("[WARN] "+format, args...)
+}
+
+func (l *stdLogger) Info(format string, args ...interface{}) {
+	l.logger.Printf("[INFO] "+format, args...)
+}
+
+func (l *stdLogger) Debug(format string, args ...interface{}) {
+	l.logger.Printf("[DEBUG] "+format, args...)
+}
+
+// StdLogger returns a *log.Logger that writes to the Logger, for use by
+// code that requires one, such as backends. The level of each line is
+// taken from its prefix, such as "[ERR] ", and lines without one are
+// logged at the info level. If the Logger was created by New, its
+// *log.Logger is returned as is.
+func StdLogger(logger Logger) *log.Logger {
+	if l, ok := logger.(*stdLogger); ok {
+		return l.logger
+	}
+	return log.New(&writer{logger: logger}, "", 0)
+}
+
+// writer is an io.Writer that logs each line written to a Logger
+type writer struct {
+	logger Logger
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		w.logLine(line)
+	}
+	return len(p), nil
+}
+
+// logLine is used to log a single line at the level of its prefix
+func (w *writer) logLine(line string) {
+	for _, level := range levels {
+		if strings.HasPrefix(line, level.prefix) {
+			level.log(w.logger, "%s", strings.TrimPrefix(line, level.prefix))
+			return
+		}
+	}
+	w.logger.Info("%s", line)
+}
@@ -0,0 +1,75 @@
+package leveledlog
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"reflect"
+	"testing"
+)
+
+// recordLogger records the messages logged at each level
+type recordLogger struct {
+	lines []string
+}
+
+func (l *recordLogger) record(level, format string, args ...interface{}) {
+	l.lines = append(l.lines, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordLogger) Error(format string, args ...interface{}) {
+	l.record("error", format, args...)
+}
+
+func (l *recordLogger) Warn(format string, args ...interface{}) {
+	l.record("warn", format, args...)
+}
+
+func (l *recordLogger) Info(format string, args ...interface{}) {
+	l.record("info", format, args...)
+}
+
+func (l *recordLogger) Debug(format string, args ...interface{}) {
+	l.record("debug", format, args...)
+}
+
+func TestNew(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := New(log.New(buf, "", 0))
+	l.Error("core: %s", "foo")
+	l.Warn("core: bar")
+	l.Info("core: baz")
+	l.Debug("core: %d", 1)
+
+	exp := "[ERR] core: foo\n[WARN] core: bar\n[INFO] core: baz\n[DEBUG] core: 1\n"
+	if buf.String() != exp {
+		t.Fatalf("bad: %q", buf.String())
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	l := new(recordLogger)
+	std := StdLogger(l)
+	std.Printf("[ERR] backend: %s", "foo")
+	std.Printf("[WARN] backend: bar")
+	std.Printf("[INFO] backend: baz\n[DEBUG] backend: zip")
+	std.Printf("no level")
+
+	exp := []string{
+		"error backend: foo",
+		"warn backend: bar",
+		"info backend: baz",
+		"debug backend: zip",
+		"info no level",
+	}
+	if !reflect.DeepEqual(l.lines, exp) {
+		t.Fatalf("bad: %#v", l.lines)
+	}
+}
+
+func TestStdLogger_New(t *testing.T) {
+	std := log.New(new(bytes.Buffer), "", 0)
+	if StdLogger(New(std)) != std {
+		t.Fatalf("should return the wrapped logger")
+	}
+}
@@ -0,0 +1,754 @@
+// pgpkeys is a package for encrypting values to OpenPGP public keys, so
+// that secrets such as unseal key shares can only be read by the holder
+// of the matching private key.
+//
+// Only the subset of OpenPGP (RFC 4880) needed to encrypt to RSA keys is
+// implemented. The self-signatures of a key are verified to pick the key
+// used for encryption, so keys must have an RSA primary key. Messages are
+// encrypted with AES-256 and integrity protected, so they can be
+// decrypted by any OpenPGP implementation, such as "gpg --decrypt".
+package pgpkeys
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	// Packet tags
+	tagPKESK         = 1
+	tagSignature     = 2
+	tagPublicKey     = 6
+	tagLiteralData   = 11
+	tagUserID        = 13
+	tagPublicSubkey  = 14
+	tagUserAttribute = 17
+	tagSEIPD         = 18
+	tagModDetectCode = 19
+
+	// Public key algorithms
+	algoRSA        = 1
+	algoRSAEncrypt = 2
+	algoRSASign    = 3
+
+	// Signature types
+	sigTypeGenericCert   = 0x10
+	sigTypePositiveCert  = 0x13
+	sigTypeSubkeyBinding = 0x18
+	sigTypeDirectKey     = 0x1f
+	sigTypeKeyRevocation = 0x20
+	sigTypeSubkeyRevoke  = 0x28
+
+	// Signature subpackets
+	subpacketCreationTime = 2
+	subpacketKeyExpiry    = 9
+	subpacketKeyFlags     = 27
+
+	// Key flags that allow encryption
+	flagEncryptComms   = 0x04
+	flagEncryptStorage = 0x08
+
+	// algoAES256 is the symmetric algorithm used for messages
+	algoAES256 = 9
+
+	armorPublicKey = "PGP PUBLIC KEY BLOCK"
+	armorMessage   = "PGP MESSAGE"
+)
+
+var (
+	// ErrNoEncryptionKey is returned if a public key has no RSA key that
+	// can be used for encryption
+	ErrNoEncryptionKey = errors.New("no RSA encryption key found")
+
+	// ErrKeyRevoked is returned if the primary key has been revoked
+	ErrKeyRevoked = errors.New("key has been revoked")
+
+	// ErrKeyExpired is returned if the primary key has expired
+	ErrKeyExpired = errors.New("key has expired")
+)
+
+// hashes are the hash algorithms of the signatures that are verified
+var hashes = map[byte]crypto.Hash{
+	2:  crypto.SHA1,
+	8:  crypto.SHA256,
+	9:  crypto.SHA384,
+	10: crypto.SHA512,
+	11: crypto.SHA224,
+}
+
+// PublicKey is an RSA key parsed from an OpenPGP public key
+type PublicKey struct {
+	KeyID [8]byte
+	key   *rsa.PublicKey
+}
+
+// ParsePublicKey parses an OpenPGP public key, which is either ASCII
+// armored or base64 encoded. The newest RSA subkey that is bound to the
+// primary key for encryption, and is neither revoked nor expired, is
+// used for encryption. The primary key is used if there is no such
+// subkey and it may encrypt.
+func ParsePublicKey(raw string) (*PublicKey, error) {
+	var data []byte
+	var err error
+	raw = strings.TrimSpace(raw)
+	if strings.HasPrefix(raw, "-----BEGIN") {
+		data, err = decodeArmor(raw, armorPublicKey)
+	} else {
+		data, err = base64.StdEncoding.DecodeString(raw)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := readEntity(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return e.encryptionKey(time.Now())
+}
+
+// entity is a primary key along with its user IDs and subkeys, each
+// with the signatures over them
+type entity struct {
+	primary *keyPacket
+	userIDs []*userID
+	subkeys []*keyPacket
+}
+
+// keyPacket is a public key or subkey packet
+type keyPacket struct {
+	body    []byte
+	created time.Time
+	algo    byte
+
+	// rsa is set for RSA keys, and key only for those that may encrypt
+	rsa  *rsa.PublicKey
+	key  *PublicKey
+	sigs []*signature
+}
+
+// userID is a user ID packet
+type userID struct {
+	body []byte
+	sigs []*signature
+}
+
+// signature is a version 4 signature packet. Only the subpackets needed
+// to select the encryption key are parsed, and only those in the hashed
+// area are trusted.
+type signature struct {
+	sigType  byte
+	pubAlgo  byte
+	hashAlgo byte
+	hashed   []byte
+	left16   []byte
+	value    []byte
+
+	created   time.Time
+	keyExpiry time.Duration
+	hasFlags  bool
+	flags     byte
+}
+
+// readEntity reads the first transferable public key, stopping at the
+// next primary key if there is more than one
+func readEntity(r *bytes.Reader) (*entity, error) {
+	e := new(entity)
+	var sigs *[]*signature
+	for {
+		tag, body, err := readPacket(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch tag {
+		case tagPublicKey:
+			if e.primary != nil {
+				return e, nil
+			}
+			if e.primary, err = parseKeyPacket(body); err != nil {
+				return nil, err
+			}
+			sigs = &e.primary.sigs
+		case tagUserID:
+			uid := &userID{body: body}
+			e.userIDs = append(e.userIDs, uid)
+			sigs = &uid.sigs
+		case tagUserAttribute:
+			sigs = nil
+		case tagPublicSubkey:
+			subkey, err := parseKeyPacket(body)
+			if err != nil {
+				return nil, err
+			}
+			e.subkeys = append(e.subkeys, subkey)
+			sigs = &subkey.sigs
+		case tagSignature:
+			if sigs == nil {
+				continue
+			}
+			sig, err := parseSignature(body)
+			if err != nil {
+				return nil, err
+			}
+			if sig != nil {
+				*sigs = append(*sigs, sig)
+			}
+		}
+	}
+	if e.primary == nil {
+		return nil, ErrNoEncryptionKey
+	}
+	return e, nil
+}
+
+// encryptionKey selects the key used for encryption at the given time
+func (e *entity) encryptionKey(now time.Time) (*PublicKey, error) {
+	if e.primary.rsa == nil {
+		return nil, ErrNoEncryptionKey
+	}
+	primaryData := keyHashData(e.primary.body)
+
+	// The primary key must not be revoked
+	for _, sig := range e.primary.sigs {
+		if sig.sigType == sigTypeKeyRevocation && e.verify(sig, primaryData) {
+			return nil, ErrKeyRevoked
+		}
+	}
+
+	// The newest self-signature holds the flags and expiry of the
+	// primary key
+	var self *signature
+	for _, sig := range e.primary.sigs {
+		if sig.sigType == sigTypeDirectKey && sig.newer(self) && e.verify(sig, primaryData) {
+			self = sig
+		}
+	}
+	for _, uid := range e.userIDs {
+		uidData := append([]byte{0xb4, 0, 0, 0, 0}, uid.body...)
+		binary.BigEndian.PutUint32(uidData[1:5], uint32(len(uid.body)))
+		for _, sig := range uid.sigs {
+			if sig.sigType >= sigTypeGenericCert && sig.sigType <= sigTypePositiveCert &&
+				sig.newer(self) && e.verify(sig, primaryData, uidData) {
+				self = sig
+			}
+		}
+	}
+	if self == nil {
+		return nil, fmt.Errorf("key has no valid self-signature")
+	}
+	if expired(e.primary.created, self.keyExpiry, now) {
+		return nil, ErrKeyExpired
+	}
+
+	// Use the newest subkey that may encrypt
+	var subkey *keyPacket
+	for _, k := range e.subkeys {
+		if k.key == nil || (subkey != nil && !k.created.After(subkey.created)) {
+			continue
+		}
+		subkeyData := keyHashData(k.body)
+
+		var binding *signature
+		revoked := false
+		for _, sig := range k.sigs {
+			switch sig.sigType {
+			case sigTypeSubkeyRevoke:
+				if e.verify(sig, primaryData, subkeyData) {
+					revoked = true
+				}
+			case sigTypeSubkeyBinding:
+				if sig.newer(binding) && e.verify(sig, primaryData, subkeyData) {
+					binding = sig
+				}
+			}
+		}
+		if revoked || binding == nil || !binding.mayEncrypt() ||
+			expired(k.created, binding.keyExpiry, now) {
+			continue
+		}
+		subkey = k
+	}
+	if subkey != nil {
+		return subkey.key, nil
+	}
+
+	// Fall back to the primary key
+	if e.primary.key != nil && self.mayEncrypt() {
+		return e.primary.key, nil
+	}
+	return nil, ErrNoEncryptionKey
+}
+
+// verify verifies a signature made by the primary key over the given
+// data, which are the encoded packets that precede the signature
+func (e *entity) verify(sig *signature, data ...[]byte) bool {
+	hash, ok := hashes[sig.hashAlgo]
+	if !ok || (sig.pubAlgo != algoRSA && sig.pubAlgo != algoRSASign) {
+		return false
+	}
+	h := hash.New()
+	for _, d := range data {
+		h.Write(d)
+	}
+	h.Write(sig.hashed)
+	trailer := []byte{4, 0xff, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(trailer[2:], uint32(len(sig.hashed)))
+	h.Write(trailer)
+	digest := h.Sum(nil)
+
+	if !bytes.Equal(digest[:2], sig.left16) {
+		return false
+	}
+
+	// The signature is an MPI, which drops leading zeros, but must be
+	// the size of the modulus to be verified
+	value := sig.value
+	if size := (e.primary.rsa.N.BitLen() + 7) / 8; len(value) < size {
+		value = append(make([]byte, size-len(value)), value...)
+	}
+	return rsa.VerifyPKCS1v15(e.primary.rsa, hash, digest, value) == nil
+}
+
+// newer returns whether the signature was created after another one,
+// which may be nil
+func (s *signature) newer(other *signature) bool {
+	return other == nil || s.created.After(other.created)
+}
+
+// mayEncrypt returns whether the key flags of the signature allow
+// encryption. Keys without flags may be used for anything.
+func (s *signature) mayEncrypt() bool {
+	return !s.hasFlags || s.flags&(flagEncryptComms|flagEncryptStorage) != 0
+}
+
+// expired returns whether a key created at the given time has expired.
+// A zero expiry never expires.
+func expired(created time.Time, expiry time.Duration, now time.Time) bool {
+	return expiry > 0 && now.After(created.Add(expiry))
+}
+
+// keyHashData returns the encoding of a key packet that is hashed by
+// the signatures over it
+func keyHashData(body []byte) []byte {
+	return append([]byte{0x99, byte(len(body) >> 8), byte(len(body))}, body...)
+}
+
+// parseKeyPacket parses the body of a version 4 public key packet
+func parseKeyPacket(body []byte) (*keyPacket, error) {
+	if len(body) < 6 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if body[0] != 4 {
+		return nil, fmt.Errorf("unsupported key version %d", body[0])
+	}
+	k := &keyPacket{
+		body:    body,
+		created: time.Unix(int64(binary.BigEndian.Uint32(body[1:5])), 0),
+		algo:    body[5],
+	}
+	if k.algo != algoRSA && k.algo != algoRSAEncrypt && k.algo != algoRSASign {
+		return k, nil
+	}
+
+	r := bytes.NewReader(body[6:])
+	n, err := readMPI(r)
+	if err != nil {
+		return nil, err
+	}
+	e, err := readMPI(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(e) > 4 {
+		return nil, fmt.Errorf("RSA exponent is too large")
+	}
+	k.rsa = &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}
+	if k.algo == algoRSASign {
+		return k, nil
+	}
+
+	// The key ID is the low 64 bits of the fingerprint
+	fingerprint := sha1.Sum(keyHashData(body))
+	k.key = &PublicKey{key: k.rsa}
+	copy(k.key.KeyID[:], fingerprint[len(fingerprint)-8:])
+	return k, nil
+}
+
+// parseSignature parses the body of a signature packet. A nil signature
+// is returned if it is not a version 4 signature.
+func parseSignature(body []byte) (*signature, error) {
+	if len(body) < 6 || body[0] != 4 {
+		return nil, nil
+	}
+	sig := &signature{
+		sigType:  body[1],
+		pubAlgo:  body[2],
+		hashAlgo: body[3],
+	}
+
+	hashedLen := int(binary.BigEndian.Uint16(body[4:6]))
+	if len(body) < 6+hashedLen+2 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	sig.hashed = body[:6+hashedLen]
+	if err := sig.parseSubpackets(body[6 : 6+hashedLen]); err != nil {
+		return nil, err
+	}
+
+	// The unhashed subpackets are skipped, since they are not covered
+	// by the signature
+	rest := body[6+hashedLen:]
+	unhashedLen := int(binary.BigEndian.Uint16(rest[:2]))
+	if len(rest) < 2+unhashedLen+2 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	rest = rest[2+unhashedLen:]
+	sig.left16 = rest[:2]
+	value, err := readMPI(bytes.NewReader(rest[2:]))
+	if err != nil {
+		return nil, err
+	}
+	sig.value = value
+	return sig, nil
+}
+
+// parseSubpackets parses the hashed subpackets of a signature
+func (s *signature) parseSubpackets(data []byte) error {
+	for len(data) > 0 {
+		var length int
+		switch first := int(data[0]); {
+		case first < 192:
+			length, data = first, data[1:]
+		case first < 255:
+			if len(data) < 2 {
+				return io.ErrUnexpectedEOF
+			}
+			length, data = (first-192)<<8+int(data[1])+192, data[2:]
+		default:
+			if len(data) < 5 {
+				return io.ErrUnexpectedEOF
+			}
+			length, data = int(binary.BigEndian.Uint32(data[1:5])), data[5:]
+		}
+		if length == 0 || length > len(data) {
+			return fmt.Errorf("invalid signature subpacket")
+		}
+		packet := data[:length]
+		data = data[length:]
+
+		// The high bit of the type marks critical subpackets
+		value := packet[1:]
+		switch packet[0] & 0x7f {
+		case subpacketCreationTime:
+			if len(value) != 4 {
+				return fmt.Errorf("invalid signature creation time")
+			}
+			s.created = time.Unix(int64(binary.BigEndian.Uint32(value)), 0)
+		case subpacketKeyExpiry:
+			if len(value) != 4 {
+				return fmt.Errorf("invalid key expiration time")
+			}
+			s.keyExpiry = time.Duration(binary.BigEndian.Uint32(value)) * time.Second
+		case subpacketKeyFlags:
+			if len(value) > 0 {
+				s.hasFlags = true
+				s.flags = value[0]
+			}
+		}
+	}
+	return nil
+}
+
+// Encrypt encrypts the plaintext to the key, returning an ASCII armored
+// OpenPGP message
+func (k *PublicKey) Encrypt(plain []byte) ([]byte, error) {
+	// Generate the session key
+	sessionKey := make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return nil, err
+	}
+
+	// Encrypt the session key to the public key
+	var checksum uint16
+	for _, b := range sessionKey {
+		checksum += uint16(b)
+	}
+	keyData := make([]byte, 0, len(sessionKey)+3)
+	keyData = append(keyData, algoAES256)
+	keyData = append(keyData, sessionKey...)
+	keyData = append(keyData, byte(checksum>>8), byte(checksum))
+	encKey, err := rsa.EncryptPKCS1v15(rand.Reader, k.key, keyData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt session key: %v", err)
+	}
+
+	var out bytes.Buffer
+	pkesk := []byte{3}
+	pkesk = append(pkesk, k.KeyID[:]...)
+	pkesk = append(pkesk, algoRSA)
+	pkesk = append(pkesk, encodeMPI(encKey)...)
+	writePacket(&out, tagPKESK, pkesk)
+
+	// The plaintext is wrapped in a binary literal data packet, with
+	// no file name or date
+	var literal bytes.Buffer
+	writePacket(&literal, tagLiteralData, append([]byte{'b', 0, 0, 0, 0, 0}, plain...))
+
+	// Encrypt the data with a random prefix, whose last two bytes are
+	// repeated, followed by the modification detection code
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, block.BlockSize()+2)
+	if _, err := rand.Read(prefix[:block.BlockSize()]); err != nil {
+		return nil, err
+	}
+	prefix[block.BlockSize()] = prefix[block.BlockSize()-2]
+	prefix[block.BlockSize()+1] = prefix[block.BlockSize()-1]
+
+	var data bytes.Buffer
+	data.Write(prefix)
+	data.Write(literal.Bytes())
+	data.Write([]byte{0xc0 | tagModDetectCode, sha1.Size})
+	mdc := sha1.Sum(data.Bytes())
+	data.Write(mdc[:])
+
+	encrypted := make([]byte, 1+data.Len())
+	encrypted[0] = 1
+	cipher.NewCFBEncrypter(block, make([]byte, block.BlockSize())).
+		XORKeyStream(encrypted[1:], data.Bytes())
+	writePacket(&out, tagSEIPD, encrypted)
+
+	return encodeArmor(out.Bytes(), armorMessage), nil
+}
+
+// EncryptShares encrypts each share to the key at the same index,
+// returning the ASCII armored messages. The shares are hex encoded
+// before they are encrypted, so that the decrypted messages can be
+// entered to unseal as they are.
+func EncryptShares(shares [][]byte, keys []string) ([][]byte, error) {
+	if len(shares) != len(keys) {
+		return nil, fmt.Errorf("%d PGP keys are required, but %d were given",
+			len(shares), len(keys))
+	}
+
+	out := make([][]byte, 0, len(shares))
+	for i, share := range shares {
+		key, err := ParsePublicKey(keys[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse PGP key %d: %v", i+1, err)
+		}
+		encrypted, err := key.Encrypt([]byte(hex.EncodeToString(share)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt to PGP key %d: %v", i+1, err)
+		}
+		out = append(out, encrypted)
+	}
+	return out, nil
+}
+
+// readPacket reads the tag and body of the next packet
+func readPacket(r *bytes.Reader) (int, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	if header&0x80 == 0 {
+		return 0, nil, fmt.Errorf("invalid packet header")
+	}
+
+	var tag, length int
+	if header&0x40 != 0 {
+		// New format
+		tag = int(header & 0x3f)
+		first, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		switch {
+		case first < 192:
+			length = int(first)
+		case first < 224:
+			second, err := r.ReadByte()
+			if err != nil {
+				return 0, nil, io.ErrUnexpectedEOF
+			}
+			length = (int(first)-192)<<8 + int(second) + 192
+		case first == 255:
+			var l uint32
+			if err := binary.Read(r, binary.BigEndian, &l); err != nil {
+				return 0, nil, io.ErrUnexpectedEOF
+			}
+			length = int(l)
+		default:
+			return 0, nil, fmt.Errorf("partial packet lengths are not supported")
+		}
+	} else {
+		// Old format
+		tag = int(header>>2) & 0xf
+		switch header & 0x3 {
+		case 0:
+			l, err := r.ReadByte()
+			if err != nil {
+				return 0, nil, io.ErrUnexpectedEOF
+			}
+			length = int(l)
+		case 1:
+			var l uint16
+			if err := binary.Read(r, binary.BigEndian, &l); err != nil {
+				return 0, nil, io.ErrUnexpectedEOF
+			}
+			length = int(l)
+		case 2:
+			var l uint32
+			if err := binary.Read(r, binary.BigEndian, &l); err != nil {
+				return 0, nil, io.ErrUnexpectedEOF
+			}
+			length = int(l)
+		default:
+			length = r.Len()
+		}
+	}
+
+	if length > r.Len() {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	body := make([]byte, length)
+	r.Read(body)
+	return tag, body, nil
+}
+
+// writePacket writes a packet in the new format
+func writePacket(w *bytes.Buffer, tag int, body []byte) {
+	w.WriteByte(0xc0 | byte(tag))
+	switch l := len(body); {
+	case l < 192:
+		w.WriteByte(byte(l))
+	case l < 8384:
+		l -= 192
+		w.WriteByte(byte(l>>8) + 192)
+		w.WriteByte(byte(l))
+	default:
+		w.WriteByte(255)
+		binary.Write(w, binary.BigEndian, uint32(l))
+	}
+	w.Write(body)
+}
+
+// readMPI reads a multiprecision integer
+func readMPI(r *bytes.Reader) ([]byte, error) {
+	var bits uint16
+	if err := binary.Read(r, binary.BigEndian, &bits); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	out := make([]byte, (int(bits)+7)/8)
+	if _, err := io.ReadFull(r, out); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return out, nil
+}
+
+// encodeMPI encodes a big endian integer as a multiprecision integer
+func encodeMPI(v []byte) []byte {
+	bits := new(big.Int).SetBytes(v).BitLen()
+	out := []byte{byte(bits >> 8), byte(bits)}
+	return append(out, v[len(v)-(bits+7)/8:]...)
+}
+
+// decodeArmor decodes an ASCII armored block of the given type,
+// verifying its checksum if present
+func decodeArmor(raw, blockType string) ([]byte, error) {
+	lines := strings.Split(strings.Replace(raw, "\r\n", "\n", -1), "\n")
+	if strings.TrimSpace(lines[0]) != "-----BEGIN "+blockType+"-----" {
+		return nil, fmt.Errorf("expected armored %s", blockType)
+	}
+
+	// Skip the armor headers, which end at the first blank line
+	i := 1
+	for ; i < len(lines) && strings.Contains(lines[i], ":"); i++ {
+	}
+
+	var body, checksum string
+	for ; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		switch {
+		case strings.HasPrefix(line, "-----END"):
+			data, err := base64.StdEncoding.DecodeString(body)
+			if err != nil {
+				return nil, fmt.Errorf("invalid armor: %v", err)
+			}
+			if checksum != "" {
+				sum, err := base64.StdEncoding.DecodeString(checksum)
+				if err != nil || len(sum) != 3 {
+					return nil, fmt.Errorf("invalid armor checksum")
+				}
+				crc := crc24(data)
+				if sum[0] != byte(crc>>16) || sum[1] != byte(crc>>8) || sum[2] != byte(crc) {
+					return nil, fmt.Errorf("armor checksum mismatch")
+				}
+			}
+			return data, nil
+		case strings.HasPrefix(line, "=") && len(line) == 5:
+			checksum = line[1:]
+		default:
+			body += line
+		}
+	}
+	return nil, fmt.Errorf("armor is not terminated")
+}
+
+// encodeArmor encodes data as an ASCII armored block of the given type
+func encodeArmor(data []byte, blockType string) []byte {
+	var out bytes.Buffer
+	out.WriteString("-----BEGIN " + blockType + "-----\n\n")
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 64 {
+		out.WriteString(encoded[:64] + "\n")
+		encoded = encoded[64:]
+	}
+	out.WriteString(encoded + "\n")
+	crc := crc24(data)
+	out.WriteString("=" + base64.StdEncoding.EncodeToString(
+		[]byte{byte(crc >> 16), byte(crc >> 8), byte(crc)}) + "\n")
+	out.WriteString("-----END " + blockType + "-----\n")
+	return out.Bytes()
+}
+
+// crc24 computes the armor checksum
+func crc24(data []byte) uint32 {
+	crc := uint32(0xb704ce)
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= 0x1864cfb
+			}
+		}
+	}
+	return crc & 0xffffff
+}
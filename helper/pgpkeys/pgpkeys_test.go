@@ -0,0 +1,281 @@
+package pgpkeys
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestParsePublicKey(t *testing.T) {
+	key, err := ParsePublicKey(testPublicKey)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The encryption subkey is used rather than the primary key
+	if id := hex.EncodeToString(key.KeyID[:]); id != "91de16549aba9441" {
+		t.Fatalf("bad: %s", id)
+	}
+	if key.key.N.BitLen() != 2048 {
+		t.Fatalf("bad: %d", key.key.N.BitLen())
+	}
+}
+
+func TestParsePublicKey_RevokedSubkey(t *testing.T) {
+	// The newest subkey is revoked, so the older one is used
+	key, err := ParsePublicKey(testRevokedSubkey)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if id := hex.EncodeToString(key.KeyID[:]); id != "1d1434820c260654" {
+		t.Fatalf("bad: %s", id)
+	}
+}
+
+func TestParsePublicKey_ExpiredSubkey(t *testing.T) {
+	// The only encryption subkey has expired, and the primary key may
+	// only sign
+	if _, err := ParsePublicKey(testExpiredSubkey); err != ErrNoEncryptionKey {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestParsePublicKey_Revoked(t *testing.T) {
+	if _, err := ParsePublicKey(testRevokedKey); err != ErrKeyRevoked {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestParsePublicKey_Unsigned(t *testing.T) {
+	// A key without a valid self-signature is rejected
+	priv, _ := TestKeyPair(t)
+	var buf bytes.Buffer
+	writePacket(&buf, tagPublicKey, testKeyBody(priv, time.Now()))
+	raw := string(encodeArmor(buf.Bytes(), armorPublicKey))
+	if _, err := ParsePublicKey(raw); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestEntity_VerifyShortSignature(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	e := &entity{primary: &keyPacket{rsa: &priv.PublicKey}}
+
+	// Sign until the signature has a leading zero, which its MPI drops
+	hashed := []byte{4, 0x13, algoRSA, 8, 0, 0}
+	for i := 0; i < 10000; i++ {
+		data := []byte{byte(i), byte(i >> 8)}
+		h := sha256.New()
+		h.Write(data)
+		h.Write(hashed)
+		h.Write([]byte{4, 0xff, 0, 0, 0, byte(len(hashed))})
+		digest := h.Sum(nil)
+		value, err := rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, digest)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if value[0] != 0 {
+			continue
+		}
+
+		sig := &signature{
+			pubAlgo:  algoRSA,
+			hashAlgo: 8,
+			hashed:   hashed,
+			left16:   digest[:2],
+			value:    bytes.TrimLeft(value, "\x00"),
+		}
+		if !e.verify(sig, data) {
+			t.Fatalf("failed to verify short signature")
+		}
+		return
+	}
+	t.Fatalf("no short signature made")
+}
+
+func TestParsePublicKey_Invalid(t *testing.T) {
+	for _, raw := range []string{
+		"",
+		"not a key",
+		"-----BEGIN PGP MESSAGE-----\n\n-----END PGP MESSAGE-----",
+		testPublicKey[:len(testPublicKey)/2],
+	} {
+		if _, err := ParsePublicKey(raw); err == nil {
+			t.Fatalf("expected error: %q", raw)
+		}
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	priv, raw := TestKeyPair(t)
+	key, err := ParsePublicKey(raw)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	plain := []byte("the quick brown fox")
+	msg, err := key.Encrypt(plain)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.HasPrefix(msg, []byte("-----BEGIN PGP MESSAGE-----\n")) {
+		t.Fatalf("bad: %s", msg)
+	}
+
+	out := TestDecrypt(t, priv, msg)
+	if !bytes.Equal(out, plain) {
+		t.Fatalf("bad: %q", out)
+	}
+}
+
+func TestEncryptShares(t *testing.T) {
+	priv1, raw1 := TestKeyPair(t)
+	priv2, raw2 := TestKeyPair(t)
+	shares := [][]byte{[]byte("one"), []byte("two")}
+
+	out, err := EncryptShares(shares, []string{raw1, raw2})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if v := TestDecrypt(t, priv1, out[0]); string(v) != hex.EncodeToString([]byte("one")) {
+		t.Fatalf("bad: %q", v)
+	}
+	if v := TestDecrypt(t, priv2, out[1]); string(v) != hex.EncodeToString([]byte("two")) {
+		t.Fatalf("bad: %q", v)
+	}
+
+	// The number of keys must match
+	if _, err := EncryptShares(shares, []string{raw1}); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+// testPublicKey is an RSA key generated by GnuPG, with a primary key
+// for signing and a subkey for encryption
+const testPublicKey = `
+-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mQENBGrQrBMBCACvu5I0qW5+x6fqL5VceSi83G1tnQL9INxa1QLiMkq17OyGuSzw
+vWBsMMcXKNQKfP2TD1D1cXBmzF6VmV+syqHrwy9+9xG2LzDlMFw/ekelmkegHgK4
+XEPrtQbQLazCU7QdIV15qrNnnXyaMS9xYBpX2GtJwv07mLnyP/nZFwW7hrepm9zH
+YzsIBalryMKNVMIKBhjgKBqn91cNm3FHqiIkVNm/LDhfaP2k11vtlLxRx+HpM3eG
+znqmK2DOZRzFVoNsF0qzfhqsPin+mbdhpuNaEeauHGYfEGSw+DpEkF0bTnR7/Mj8
+Rz+euO8bhLUb4e7noNBpqEeMo/f8qav6zzPPABEBAAG0FFRlc3QgPHRAZXhhbXBs
+ZS5jb20+iQFOBBMBCgA4FiEE6azoXpKGRzK73gLWdfaQ6O5AT/cFAmrQrBMCGwMF
+CwkIBwIGFQoJCAsCBBYCAwECHgECF4AACgkQdfaQ6O5AT/cxbgf/ao4PgkClUYbn
+47moxymYXQCKaD0BcNyaxmQY24I8CHMvOLJskXLVcWrDjdHDZ9Klwp+L+xG/D/XN
+H+2Gia5mfgAi9gCE1KzTRQe46PD6hM1pdqo5fL297iJ1zPRa6UWOY2VWN7ZRZq38
++Xmi/RnJBAmeqGkyzQMc4eMhlw7YYl8ewf+3fc6wd5lerIqbyKgr9OyZ4as0wRsA
+1JIWdtoCZN8fka3+rcA8urvjXz3ohquS1BaCBQyVmKQaETa++cbQIyPbgqCOW8AF
+S/i0kOg3KwQfblaW9RAmicSQbbCUTc0v+xGdQ4shBMirItwUwt4Ki5Payjm5GKKq
+2VjawvfOlbkBDQRq0KwTAQgAybOP6plWMXweDPqLQQYd8rPOwjGQ/zUR/bER5G7a
+Z1Ne6YBb8xmpsIzLl3ED/3ulK4o5rhKYP/0IwXJ1iEIH1XHbyvbZvdmJkmQ1C+lg
+KUMHkIIw6b3jqo0j+68dNupNYTEREuXbOlfCJaul5Mm6i6nko2Sdd6Ugb8rQvbt5
+erkH1Ii6iiiE4pExK8gkWJ5pzjAL0o4ti3Q1/yXt42MT5Jz6da5RfvYpzx2Il8sY
+Dy4YH9b4fDbW+gN5hn6DYlcFMrkS+EhMGHZ9BhkI5qJE9tMhccDZD+Nf5ma7G2Ka
+CUMtxZ3gR51OtIgf8z9EIT8rHWvWT0AVzMqFbZ5KGBhrQwARAQABiQE2BBgBCgAg
+FiEE6azoXpKGRzK73gLWdfaQ6O5AT/cFAmrQrBMCGwwACgkQdfaQ6O5AT/dErQgA
+rsQ4dRyGr3BrgYTlUnOlDeN9b88IruRv00K2SEItL7wh/sjpAlvAyGLiR79C7olx
+RuE0j1n6obJYzZlz28buVVoOjqdSjuCXL6b7shM5atskEp8jrDTm5TgO3RNCYbqP
+I+MlT7So6O4CP0O0LDSX9lRbYgwJ3ulJs36scLzG9kI+nD9SV40Cc8K+85D8Lxee
+9qev462gIsaKx39xihdadLZSu64Rh8vmKqbQ2j6Hj4I70ZuBbBc8yg41HDVC1rJV
+gZsIQv2/x2xe8M48XLpRunU3MCtIxe+W7uSMg7RD9iID1T6U5UQoyMzhNR0IUlgP
+FPSp0J1+lgJmkYmpzdO5+A==
+=3JbP
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+// testRevokedSubkey was generated by GnuPG with a primary key for
+// signing and two subkeys for encryption, the newest of which is revoked
+const testRevokedSubkey = `
+-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mI0EatDWXQEEANaen9FxCJwvE64t7/zwiIdea4FTY1176EYiHeGbapnvXYWPILqb
+2iNQVFjRcolSbOTiox9zJpzHUZgzROevhGWCFLIiw4vk0fia/XzkwZ5fDrPqcA2d
+AiIauBmvjRKRvU+wNsedJ31Tz0HMBkvHPrf0pof0KLW8Hf9DuGPteXenABEBAAG0
+HlJldm9rZWQgU3Via2V5IDxyQGV4YW1wbGUuY29tPojOBBMBCgA4FiEEMQSxsaam
+VAoPEYhE6HvSU5UJk9gFAmrQ1l0CGwMFCwkIBwIGFQoJCAsCBBYCAwECHgECF4AA
+CgkQ6HvSU5UJk9i4lQQAm/U9hXSVkLaqicCq2TGXvj5uUKBZCsvSgJDDndfvZYpt
+2dpBY1nNlDwy21RPrcCbRQ2fGUGN65olifckqmu+NvvrWNrOXw13Mn6rv4w4oNMr
+sue1+wQMzDUsHRVdOaMj1XUsynu2exQJdve+M26WTFMTFQttf7PGV5Tw/eAPdLq4
+jQRq0NZdAQQA6WQmxHC8viLHJ928TK+o0/72FcPiptQqOA3zDR3drDSkPP8cS/by
+gPhnhVef3VxhTCY5i9XyH3l5UM02dWRX1Wk96cC/6QD4GGBZbiNygsSRFu8kVH7x
++VFF1xF3B3KsNc+vLCwZ4olz01xgYqP3nYgEO4MkF/6zyNek88giNS8AEQEAAYi2
+BBgBCgAgFiEEMQSxsaamVAoPEYhE6HvSU5UJk9gFAmrQ1l0CGwwACgkQ6HvSU5UJ
+k9glmQP+JckMVLDxSbPOS6BYTbTtto3yBE+8sBTylRmr3epDQenIMxrU4a59PDV1
+Z2BoB2xFNz3/CvF9ugRHdXS5U0qwOeUh/rxEdJIG+R07ABXHmHCO5P+ApDPANZvr
+5AQwODmMJkZQbg3RWDq1BW0y7q5RN1KtO3/ImRNSYh22G+z+anq4jQRq0NZeAQQA
+221ztd8hURpogOGspoVB//HJ7qAduYbIaYnVRED/r4yltr17exce/j6fWJuah82n
+N6vFSVqQmdHXQ7Jb2dcLji8t3Jl+yrPR709j+BzCvTNHCmRvpBnV067z3t9WvU6n
+CE8W6cvC4aVwmRvrDfZDcFgztOgW8w4MY7PoCrxGf9cAEQEAAYi2BCgBCgAgFiEE
+MQSxsaamVAoPEYhE6HvSU5UJk9gFAmrQ1l4CHQAACgkQ6HvSU5UJk9gfcwQAwYef
+TlRAEcbKMAVAzntlvoJLG9SanA0k1b2E1BNyjt4mOzoU2tugsujs1RlGKS4dm6NH
+eEhF2iTzoBPviONsAyiOyMLTDJPlE4pa2uq9HRgZsPc3ME0TmIuBwOlvzM5l29E+
+7SyQ1mLmxMjMn37Y72iln0r2+30h8vXaD7snzeWItgQYAQoAIBYhBDEEsbGmplQK
+DxGIROh70lOVCZPYBQJq0NZeAhsMAAoJEOh70lOVCZPYF6oD/jcgoXaZKyafEf+R
+2Z5QXe2Q6N59w8IUsAyDdBYRL9s0OqwhZ2ZFxYMFLJv34fKmH9PmfGn+mMPjw+nN
+XXShWIFxMHKiqvxpo7yRxFPaOI2bP4giObvbsPsC/twV2bYPdhQaeiFGt2o8ec3X
+s4somEe9CiJDbENltnUrGyqqh2ez
+=Bq0v
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+// testExpiredSubkey was generated by GnuPG with a primary key for
+// signing and a subkey for encryption that expired in 2020
+const testExpiredSubkey = `
+-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mI0EXgvhAAEEANprqFThddTgL/jujQ2o8zUpq8jGe5Dt9lsHHYtrQs0KzCduDRb6
+FS9piLpDqian6sOTNq5usj8RM9pvkGYBkPvxZ8qxPJgLk7xMUK5aHIr3u3QuMMgk
+p6/x2pLQT9/m4oPvZ8Yrkt7/mfYG2vk0kli/D1Du1+xdUct7njXA2esRABEBAAG0
+HkV4cGlyZWQgU3Via2V5IDxlQGV4YW1wbGUuY29tPojOBBMBCgA4FiEEL/S74Ht0
+LB4ir3wEOdojbth9Z0cFAl4L4QACGwMFCwkIBwIGFQoJCAsCBBYCAwECHgECF4AA
+CgkQOdojbth9Z0eJEAP/TRtoP1Aibs2sWe3pnh7mABxs58x/ziTp2dPW2nyBQ5Im
+vNIg6xNjZhGsMb9iWXLHIZm58RDatW31WZ8etCuQHP2u6jIHt3/Fq54uGX7zlLGq
+G89UhQHzjZsOnctmHyJbaBoyTp+B41MM/qTzZYRCO2VpunltbpBCd8GpcZwHXXy4
+jQReC+E8AQQA4vS0jAsU2se6CuvLlNTIVxRR0Qw6UbylT0IxmLrG5lQLaYeUuI4B
+0R0q2H65zWyfgbbJEtoKJk1U9oFZc/4e2Lnkpr/i2UC8rfo5CjrtmxUuL/TNfoc6
+8wPajtYYXHS/i+txBtIw1T3GxnnLg+Pp/XOpbKrRzJI0/k0xuQImEbUAEQEAAYi8
+BBgBCgAmFiEEL/S74Ht0LB4ir3wEOdojbth9Z0cFAl4L4TwCGwwFCQABUYAACgkQ
+Odojbth9Z0exvwQAuhyCZ7FQ09wSod1MwRfChTyBduGUh69alD4p5NbeJUrt1ZM+
+SPynFbb6LzUEunvuD5nNJdJ77ZAZORVkjUGBtdGBYM4sxWtEJ4gim64LS+wqNcWM
+iqhjfNxIm+RFSptnUwJmTyRQkt2jpk1ghACba1dqUefJSUck3Sf6LuZcLHs=
+=O+fp
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+// testRevokedKey was generated by GnuPG with a primary key for signing,
+// which is revoked, and a subkey for encryption
+const testRevokedKey = `
+-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mI0EatDWXgEEAJi7PkQHzoVsCLXD1Tq5qkHUkpLzjMYdpwNROXLVgDnWKcRO9oz2
+hU+Z4cWUzSEIbK9DgS5Ur6f7nnXb40KAKtxCiE6yr7GEk3i8u48bKaXGr9lNDZ3X
+/L+kglhKrinwkLm0iI2opYMBs08pTykbwTVMPBFDLyzzzJWwqbbWb0KBABEBAAGI
+tgQgAQoAIBYhBIQpA4uG0qg6i9bPxqa0uof/OGwNBQJq0NZfAh0AAAoJEKa0uof/
+OGwN6PMD/RJDr9NN41Tw/X1DI2ZsfXdquEhx4xf6NxURup0HHkcPOwpNFrZPwMKq
+vSr4+5H3vGgg60KB8oHWIRnRjfDxqiwDSdGv7Jdk7vTEW15YDDEHOgf7F0Y7NXbR
+kzUAU6pqeyexFZV6oB5ODImqDcmdG2GR1vFwjjnFpgD9KbCFUBzhtBtSZXZva2Vk
+IEtleSA8dkBleGFtcGxlLmNvbT6IzgQTAQoAOBYhBIQpA4uG0qg6i9bPxqa0uof/
+OGwNBQJq0NZeAhsDBQsJCAcCBhUKCQgLAgQWAgMBAh4BAheAAAoJEKa0uof/OGwN
+uogD/jslMSGSynQMmHrPNrX0vvnpv7DclrTdi8wbAln1tp5WwEk4/y16g8YMWuF2
+PQy/41LdfGsEzwbkrZLZmhr5hL4///LQVt1WApq0lARpO4PHVt8PqgEQFoSOdVoD
+dYVcSrSKbEnT7yeL5hUTsCGTs1c38N+G/PnZMWB8nbcSlcrruI0EatDWXwEEANss
+etozivzTCmUYx+F9bhDiyBicPiZTdQpAoMGMd6/oeZLdTTaNvMq/6QD871PXaR3y
+619taD2PgwvqTJmsqVf8ZUeZBVGGtXM/244SOthbnqnRw0pm4VUwAR/yb0fiCtlx
+n/Fq2+kThwm93LP2CVsYxMgeLDMNPGEGAo2diYvNABEBAAGItgQYAQoAIBYhBIQp
+A4uG0qg6i9bPxqa0uof/OGwNBQJq0NZfAhsMAAoJEKa0uof/OGwNcWsD/2wOf3HR
+YBuftsQS/f7ikQ+y/YLAjZ+hyAp6obi0088CxSx39E2BYdT2zqde+IyVkdhx5Ub8
+TkpdZyatzkhbCOZuGsot5jA5ZVlv2Lk75BKoq+etG3Zi38PtjgS3/oN7apgBoeKJ
+Lz6ElG2+csmF/MMgBlnveLE2FuUAcJCqOdgH
+=iEvK
+-----END PGP PUBLIC KEY BLOCK-----
+`
@@ -0,0 +1,131 @@
+package pgpkeys
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// TestKeyPair generates an RSA key for testing, returning it with its
+// armored OpenPGP public key. The key has a user ID certified by itself
+// for signing and encryption, and no subkeys.
+func TestKeyPair(t *testing.T) (*rsa.PrivateKey, string) {
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	created := time.Now().Add(-time.Minute)
+	body := testKeyBody(priv, created)
+	uid := []byte("Test <test@example.com>")
+
+	var buf bytes.Buffer
+	writePacket(&buf, tagPublicKey, body)
+	writePacket(&buf, tagUserID, uid)
+	uidData := append([]byte{0xb4, 0, 0, 0, 0}, uid...)
+	binary.BigEndian.PutUint32(uidData[1:5], uint32(len(uid)))
+	writePacket(&buf, tagSignature, testSign(t, priv, sigTypePositiveCert,
+		created, 0x0f, 0, keyHashData(body), uidData))
+	return priv, string(encodeArmor(buf.Bytes(), armorPublicKey))
+}
+
+// testKeyBody encodes the body of a public key packet
+func testKeyBody(priv *rsa.PrivateKey, created time.Time) []byte {
+	body := []byte{4, 0, 0, 0, 0, algoRSA}
+	binary.BigEndian.PutUint32(body[1:5], uint32(created.Unix()))
+	body = append(body, encodeMPI(priv.N.Bytes())...)
+	return append(body, encodeMPI(big.NewInt(int64(priv.E)).Bytes())...)
+}
+
+// testSign creates a signature packet body over the given data with the
+// key flags and key expiry as hashed subpackets
+func testSign(t *testing.T, priv *rsa.PrivateKey, sigType byte, created time.Time,
+	flags byte, expiry time.Duration, data ...[]byte) []byte {
+	subpackets := []byte{5, subpacketCreationTime, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(subpackets[2:], uint32(created.Unix()))
+	subpackets = append(subpackets, 2, subpacketKeyFlags, flags)
+	if expiry > 0 {
+		exp := []byte{5, subpacketKeyExpiry, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(exp[2:], uint32(expiry/time.Second))
+		subpackets = append(subpackets, exp...)
+	}
+
+	hashed := []byte{4, sigType, algoRSA, 8, 0, byte(len(subpackets))}
+	hashed = append(hashed, subpackets...)
+	h := sha256.New()
+	for _, d := range data {
+		h.Write(d)
+	}
+	h.Write(hashed)
+	h.Write([]byte{4, 0xff, 0, 0, 0, byte(len(hashed))})
+	digest := h.Sum(nil)
+	value, err := rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, digest)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	body := append(hashed, 0, 0)
+	body = append(body, digest[:2]...)
+	return append(body, encodeMPI(value)...)
+}
+
+// TestDecrypt decrypts a message encrypted by Encrypt for testing
+func TestDecrypt(t *testing.T, priv *rsa.PrivateKey, msg []byte) []byte {
+	data, err := decodeArmor(string(msg), armorMessage)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	r := bytes.NewReader(data)
+
+	// Recover the session key
+	tag, body, err := readPacket(r)
+	if err != nil || tag != tagPKESK {
+		t.Fatalf("bad: %d %v", tag, err)
+	}
+	if body[0] != 3 || body[9] != algoRSA {
+		t.Fatalf("bad: %v", body[:10])
+	}
+	encKey, err := readMPI(bytes.NewReader(body[10:]))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	keyData, err := rsa.DecryptPKCS1v15(rand.Reader, priv, encKey)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if keyData[0] != algoAES256 || len(keyData) != 35 {
+		t.Fatalf("bad: %v", keyData)
+	}
+
+	// Decrypt the data and verify the modification detection code
+	tag, body, err = readPacket(r)
+	if err != nil || tag != tagSEIPD || body[0] != 1 {
+		t.Fatalf("bad: %d %v", tag, err)
+	}
+	block, _ := aes.NewCipher(keyData[1:33])
+	plain := make([]byte, len(body)-1)
+	cipher.NewCFBDecrypter(block, make([]byte, block.BlockSize())).
+		XORKeyStream(plain, body[1:])
+	mdc := sha1.Sum(plain[:len(plain)-sha1.Size])
+	if !bytes.Equal(mdc[:], plain[len(plain)-sha1.Size:]) {
+		t.Fatalf("bad MDC")
+	}
+	if plain[14] != plain[16] || plain[15] != plain[17] {
+		t.Fatalf("bad prefix")
+	}
+
+	// Unwrap the literal data
+	tag, body, err = readPacket(bytes.NewReader(plain[18 : len(plain)-sha1.Size-2]))
+	if err != nil || tag != tagLiteralData {
+		t.Fatalf("bad: %d %v", tag, err)
+	}
+	return body[6:]
+}
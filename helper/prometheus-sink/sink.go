@@ -0,0 +1,155 @@
+package prometheussink
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the Prometheus text format
+const ContentType = "text/plain; version=0.0.4"
+
+// Sink is a go-metrics sink that keeps the metrics it is sent so that
+// they can be scraped in the Prometheus text format. It can be combined
+// with other sinks using a metrics.FanoutSink.
+//
+// Counters and samples are cumulative, as Prometheus expects. Samples,
+// such as the timings from metrics.MeasureSince, are exposed as a
+// summary of their sum and count. Keys that go-metrics prefixed with
+// the host name have it moved to a "host" label, so that a metric has
+// the same name on every host.
+type Sink struct {
+	hostname string
+
+	l        sync.Mutex
+	gauges   map[string]*metric
+	counters map[string]*metric
+	samples  map[string]*metric
+}
+
+// metric is the value of a single metric and its labels
+type metric struct {
+	labels string
+	value  float64
+	count  uint64
+}
+
+// New is used to create a new sink. The hostname is the one go-metrics
+// is configured with, if any.
+func New(hostname string) *Sink {
+	return &Sink{
+		hostname: hostname,
+		gauges:   make(map[string]*metric),
+		counters: make(map[string]*metric),
+		samples:  make(map[string]*metric),
+	}
+}
+
+// SetGauge implements metrics.MetricSink
+func (s *Sink) SetGauge(key []string, val float32) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.get(s.gauges, key).value = float64(val)
+}
+
+// EmitKey implements metrics.MetricSink. Keys have no Prometheus
+// equivalent, so they are dropped.
+func (s *Sink) EmitKey(key []string, val float32) {}
+
+// IncrCounter implements metrics.MetricSink
+func (s *Sink) IncrCounter(key []string, val float32) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.get(s.counters, key).value += float64(val)
+}
+
+// AddSample implements metrics.MetricSink
+func (s *Sink) AddSample(key []string, val float32) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	m := s.get(s.samples, key)
+	m.value += float64(val)
+	m.count++
+}
+
+// get is used to find or create the metric for a key. The lock must
+// be held.
+func (s *Sink) get(metrics map[string]*metric, key []string) *metric {
+	name, labels := s.flatten(key)
+	id := name + labels
+	m, ok := metrics[id]
+	if !ok {
+		m = &metric{labels: labels}
+		metrics[id] = m
+	}
+	return m
+}
+
+// flatten is used to turn a key into a valid metric name and its labels
+func (s *Sink) flatten(key []string) (string, string) {
+	var labels string
+	if s.hostname != "" && len(key) > 1 && key[1] == s.hostname {
+		labels = fmt.Sprintf("{host=%q}", s.hostname)
+		key = append([]string{key[0]}, key[2:]...)
+	}
+	return sanitizeName(strings.Join(key, "_")), labels
+}
+
+// sanitizeName is used to replace the characters that are not valid in
+// a metric name with underscores
+func sanitizeName(name string) string {
+	out := []byte(name)
+	for i, c := range out {
+		valid := c == '_' || c == ':' ||
+			(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			(i > 0 && c >= '0' && c <= '9')
+		if !valid {
+			out[i] = '_'
+		}
+	}
+	return string(out)
+}
+
+// WriteTo is used to write every metric in the Prometheus text format
+func (s *Sink) WriteTo(w io.Writer) (int64, error) {
+	s.l.Lock()
+	var buf bytes.Buffer
+	writeMetrics(&buf, "gauge", s.gauges, func(name string, m *metric) {
+		fmt.Fprintf(&buf, "%s%s %v\n", name, m.labels, m.value)
+	})
+	writeMetrics(&buf, "counter", s.counters, func(name string, m *metric) {
+		fmt.Fprintf(&buf, "%s%s %v\n", name, m.labels, m.value)
+	})
+	writeMetrics(&buf, "summary", s.samples, func(name string, m *metric) {
+		fmt.Fprintf(&buf, "%s_sum%s %v\n", name, m.labels, m.value)
+		fmt.Fprintf(&buf, "%s_count%s %d\n", name, m.labels, m.count)
+	})
+	s.l.Unlock()
+
+	return buf.WriteTo(w)
+}
+
+// writeMetrics is used to write the metrics of a type in order, with a
+// type comment before each metric name
+func writeMetrics(buf *bytes.Buffer, typ string, metrics map[string]*metric,
+	write func(string, *metric)) {
+	ids := make([]string, 0, len(metrics))
+	for id := range metrics {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var last string
+	for _, id := range ids {
+		m := metrics[id]
+		name := strings.TrimSuffix(id, m.labels)
+		if name != last {
+			fmt.Fprintf(buf, "# TYPE %s %s\n", name, typ)
+			last = name
+		}
+		write(name, m)
+	}
+}
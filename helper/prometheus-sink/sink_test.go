@@ -0,0 +1,67 @@
+package prometheussink
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSink(t *testing.T) {
+	s := New("")
+	s.SetGauge([]string{"vault", "expire", "num_leases"}, 3)
+	s.SetGauge([]string{"vault", "expire", "num_leases"}, 2)
+	s.EmitKey([]string{"vault", "foo"}, 1)
+	s.IncrCounter([]string{"vault", "route", "read", "secret-"}, 1)
+	s.IncrCounter([]string{"vault", "route", "read", "secret-"}, 2)
+	s.AddSample([]string{"vault", "core", "handle_request"}, 1.5)
+	s.AddSample([]string{"vault", "core", "handle_request"}, 2)
+
+	var buf bytes.Buffer
+	if _, err := s.WriteTo(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expected := `# TYPE vault_expire_num_leases gauge
+vault_expire_num_leases 2
+# TYPE vault_route_read_secret_ counter
+vault_route_read_secret_ 3
+# TYPE vault_core_handle_request summary
+vault_core_handle_request_sum 3.5
+vault_core_handle_request_count 2
+`
+	if buf.String() != expected {
+		t.Fatalf("bad: %s", buf.String())
+	}
+}
+
+func TestSink_hostname(t *testing.T) {
+	s := New("node1")
+	s.SetGauge([]string{"vault", "node1", "runtime", "num_goroutines"}, 10)
+	s.SetGauge([]string{"vault", "runtime", "num_goroutines"}, 20)
+
+	var buf bytes.Buffer
+	if _, err := s.WriteTo(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expected := `# TYPE vault_runtime_num_goroutines gauge
+vault_runtime_num_goroutines 20
+vault_runtime_num_goroutines{host="node1"} 10
+`
+	if buf.String() != expected {
+		t.Fatalf("bad: %s", buf.String())
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	cases := map[string]string{
+		"vault_route_read":   "vault_route_read",
+		"vault.route-read/x": "vault_route_read_x",
+		"1vault:foo2":        "_vault:foo2",
+		"vault_héllo":        "vault_h__llo",
+	}
+	for in, out := range cases {
+		if actual := sanitizeName(in); actual != out {
+			t.Fatalf("%s: bad: %s", in, actual)
+		}
+	}
+}
@@ -3,9 +3,12 @@ package http
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/vault"
@@ -14,6 +17,31 @@ import (
 // AuthCookieName is the name of the cookie containing the token.
 const AuthCookieName = "token"
 
+// IdempotencyKeyHeaderName is the name of the header containing the
+// idempotency key used to de-duplicate retried writes.
+const IdempotencyKeyHeaderName = "X-Vault-Idempotency-Key"
+
+// BreakGlassHeaderName is the name of the header used to request the
+// break-glass override of the ACL.
+const BreakGlassHeaderName = "X-Vault-Break-Glass"
+
+// WrapTTLHeaderName is the name of the header used to request that the
+// response be wrapped, with the TTL of the wrapping token.
+const WrapTTLHeaderName = "X-Vault-Wrap-TTL"
+
+// CASHeaderName is the name of the header containing the version a
+// secret is expected to have for a check-and-set write.
+const CASHeaderName = "X-Vault-CAS"
+
+// RequestIDHeaderName is the name of the header containing the ID the
+// core assigned to the request, which is also in its audit entries.
+const RequestIDHeaderName = "X-Vault-Request-Id"
+
+// maxRequestBodySize is the largest request body that is read. This is
+// a hard limit on top of the maximum request size of the core and of
+// the mounts, which only applies once the body is decoded.
+var maxRequestBodySize int64 = 32 * 1024 * 1024
+
 // Handler returns an http.Handler for the API. This can be used on
 // its own to mount the Vault API within another web server.
 func Handler(core *vault.Core) http.Handler {
@@ -23,6 +51,8 @@ func Handler(core *vault.Core) http.Handler {
 	mux.Handle("/v1/sys/seal-status", handleSysSealStatus(core))
 	mux.Handle("/v1/sys/seal", handleSysSeal(core))
 	mux.Handle("/v1/sys/unseal", handleSysUnseal(core))
+	mux.Handle("/v1/sys/generate-root/attempt", handleSysGenerateRootAttempt(core))
+	mux.Handle("/v1/sys/generate-root/update", handleSysGenerateRootUpdate(core))
 	mux.Handle("/v1/sys/mounts", handleSysListMounts(core))
 	mux.Handle("/v1/sys/mounts/", handleSysMounts(core))
 	mux.Handle("/v1/sys/remount", handleSysRemount(core))
@@ -36,7 +66,14 @@ func Handler(core *vault.Core) http.Handler {
 	mux.Handle("/v1/sys/audit", handleSysListAudit(core))
 	mux.Handle("/v1/sys/audit/", handleSysAudit(core))
 	mux.Handle("/v1/sys/leader", handleSysLeader(core))
+	mux.Handle("/v1/sys/step-down", handleSysStepDown(core))
 	mux.Handle("/v1/sys/health", handleSysHealth(core))
+	mux.Handle("/v1/sys/metrics", handleSysMetrics(core))
+	mux.Handle("/v1/sys/help/", handleSysHelp(core))
+	mux.Handle("/v1/sys/rotate", handleSysRotate(core))
+	mux.Handle("/v1/sys/key-status", handleSysKeyStatus(core))
+	mux.Handle("/v1/sys/verify", handleSysVerify(core))
+	mux.Handle("/v1/sys/wrapping/unwrap", handleSysUnwrap(core))
 	mux.Handle("/v1/", handleLogical(core))
 
 	// Wrap the handler in another handler to trigger all help paths.
@@ -61,7 +98,11 @@ func stripPrefix(prefix, path string) (string, bool) {
 }
 
 func parseRequest(r *http.Request, out interface{}) error {
-	dec := json.NewDecoder(r.Body)
+	// Bound the body before it is decoded, so that a large request is
+	// rejected without buffering it. The core checks the size of the
+	// decoded data against the limit of the mount.
+	body := http.MaxBytesReader(nil, r.Body, maxRequestBodySize)
+	dec := json.NewDecoder(body)
 	return dec.Decode(out)
 }
 
@@ -69,8 +110,27 @@ func parseRequest(r *http.Request, out interface{}) error {
 // case of an error.
 func request(core *vault.Core, w http.ResponseWriter, rawReq *http.Request, r *logical.Request) (*logical.Response, bool) {
 	resp, err := core.HandleRequest(r)
-	if err == vault.ErrStandby {
-		respondStandby(core, w, rawReq.URL)
+	if r.ID != "" {
+		w.Header().Set(RequestIDHeaderName, r.ID)
+	}
+	if standby, ok := err.(vault.ErrStandbyRedirect); ok {
+		respondStandby(w, rawReq.URL, standby.LeaderAddr)
+		return resp, false
+	}
+	if _, ok := err.(vault.ErrNoRoute); ok {
+		respondError(w, http.StatusNotFound, err)
+		return resp, false
+	}
+	if limited, ok := err.(vault.ErrRateLimited); ok {
+		respondRateLimited(w, limited.RetryAfter)
+		return resp, false
+	}
+	if _, ok := err.(vault.ErrRequestTimeout); ok {
+		respondError(w, http.StatusGatewayTimeout, err)
+		return resp, false
+	}
+	if _, ok := err.(vault.ErrRequestTooLarge); ok {
+		respondError(w, http.StatusRequestEntityTooLarge, err)
 		return resp, false
 	}
 	if respondCommon(w, resp) {
@@ -84,18 +144,23 @@ func request(core *vault.Core, w http.ResponseWriter, rawReq *http.Request, r *l
 	return resp, true
 }
 
-// respondStandby is used to trigger a redirect in the case that this Vault is currently a hot standby
-func respondStandby(core *vault.Core, w http.ResponseWriter, reqURL *url.URL) {
-	// Request the leader address
-	_, advertise, err := core.Leader()
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, err)
-		return
+// respondRateLimited is used to reject a request over the rate limit,
+// telling the client how many seconds to wait before retrying
+func respondRateLimited(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
 	}
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	respondError(w, http.StatusTooManyRequests, vault.ErrRateLimited{})
+}
 
+// respondStandby is used to trigger a redirect in the case that this Vault
+// is currently a hot standby, given the advertise address of the leader
+func respondStandby(w http.ResponseWriter, reqURL *url.URL, advertise string) {
 	// If there is no leader, generate a 503 error
 	if advertise == "" {
-		err = fmt.Errorf("no active Vault instance found")
+		err := fmt.Errorf("no active Vault instance found")
 		respondError(w, http.StatusServiceUnavailable, err)
 		return
 	}
@@ -135,9 +200,46 @@ func requestAuth(r *http.Request, req *logical.Request) *logical.Request {
 		req.ClientToken = cookie.Value
 	}
 
+	// Request the break-glass override if asked
+	if v := r.Header.Get(BreakGlassHeaderName); v != "" {
+		req.BreakGlass, _ = strconv.ParseBool(v)
+	}
+
 	return req
 }
 
+// parseWrapTTL returns the TTL of the wrapping token requested by the
+// client, or zero if the response should not be wrapped. The TTL is
+// either a duration such as "5m" or a number of seconds.
+func parseWrapTTL(r *http.Request) (time.Duration, error) {
+	v := r.Header.Get(WrapTTLHeaderName)
+	if v == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		v = fmt.Sprintf("%ds", seconds)
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("invalid %s: %q", WrapTTLHeaderName, v)
+	}
+	return ttl, nil
+}
+
+// parseCAS returns the version given for a check-and-set write, or nil
+// if the write is unconditional
+func parseCAS(r *http.Request) (*int, error) {
+	v := r.Header.Get(CASHeaderName)
+	if v == "" {
+		return nil, nil
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil || version < 0 {
+		return nil, fmt.Errorf("invalid %s: %q", CASHeaderName, v)
+	}
+	return &version, nil
+}
+
 func respondError(w http.ResponseWriter, status int, err error) {
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(status)
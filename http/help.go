@@ -38,3 +38,29 @@ func handleHelp(core *vault.Core, w http.ResponseWriter, req *http.Request) {
 
 	respondOk(w, resp.Data)
 }
+
+func handleSysHelp(core *vault.Core) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" {
+			respondError(w, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		path, ok := stripPrefix("/v1/", req.URL.Path)
+		if !ok {
+			respondError(w, http.StatusNotFound, nil)
+			return
+		}
+
+		// The core handles a read of sys/help/<path> as a help request
+		// for the path
+		resp, ok := request(core, w, req, requestAuth(req, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      path,
+		}))
+		if !ok {
+			return
+		}
+		respondOk(w, resp.Data)
+	})
+}
@@ -25,3 +25,22 @@ func TestHelp(t *testing.T) {
 		t.Fatalf("bad: %#v", actual)
 	}
 }
+
+func TestHelp_sysHelp(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	resp, err := http.Get(addr + "/v1/sys/help/secret/foo")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	if _, ok := actual["help"]; !ok {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
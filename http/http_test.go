@@ -12,6 +12,10 @@ func testHttpDelete(t *testing.T, addr string) *http.Response {
 	return testHttpData(t, "DELETE", addr, nil)
 }
 
+func testHttpList(t *testing.T, addr string) *http.Response {
+	return testHttpData(t, "LIST", addr, nil)
+}
+
 func testHttpPost(t *testing.T, addr string, body interface{}) *http.Response {
 	return testHttpData(t, "POST", addr, body)
 }
@@ -35,7 +39,14 @@ func testHttpData(t *testing.T, method string, addr string, body interface{}) *h
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+	// Redirects are not followed, so that they can be checked
+	client := &http.Client{
+		Jar: http.DefaultClient.Jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		t.Fatalf("err: %s", err)
 	}
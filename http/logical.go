@@ -30,6 +30,10 @@ func handleLogical(core *vault.Core) http.Handler {
 			op = logical.DeleteOperation
 		case "GET":
 			op = logical.ReadOperation
+		case "HEAD":
+			op = logical.ExistenceCheckOperation
+		case "LIST":
+			op = logical.ListOperation
 		case "POST":
 			fallthrough
 		case "PUT":
@@ -53,10 +57,30 @@ func handleLogical(core *vault.Core) http.Handler {
 			}
 		}
 
+		// Reads of the endpoints that take parameters get them from the
+		// query string
+		if op == logical.ReadOperation && core.QueryPath(path) {
+			req = queryParams(r)
+		}
+
+		// Determine if the response should be wrapped
+		wrapTTL, err := parseWrapTTL(r)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Determine if this is a check-and-set write
+		cas, err := parseCAS(r)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
 		// Make the internal request. We attach the connection info
 		// as well in case this is an authentication request that requires
 		// it. Vault core handles stripping this if we need to.
-		resp, ok := request(core, w, r, requestAuth(r, &logical.Request{
+		logicalReq := requestAuth(r, &logical.Request{
 			Operation: op,
 			Path:      path,
 			Data:      req,
@@ -64,7 +88,11 @@ func handleLogical(core *vault.Core) http.Handler {
 				RemoteAddr: r.RemoteAddr,
 				ConnState:  r.TLS,
 			},
-		}))
+			IdempotencyKey: r.Header.Get(IdempotencyKeyHeaderName),
+			WrapTTL:        wrapTTL,
+			CAS:            cas,
+		})
+		resp, ok := request(core, w, r, logicalReq)
 		if !ok {
 			return
 		}
@@ -73,12 +101,23 @@ func handleLogical(core *vault.Core) http.Handler {
 			return
 		}
 
+		// Existence checks only respond with a status code
+		if op == logical.ExistenceCheckOperation {
+			if resp != nil && resp.Data["exists"] == true {
+				w.WriteHeader(http.StatusOK)
+			} else {
+				w.WriteHeader(http.StatusNotFound)
+			}
+			return
+		}
+
 		// Build the proper response
-		respondLogical(w, r, path, resp)
+		respondLogical(w, r, path, logicalReq.ID, resp)
 	})
 }
 
-func respondLogical(w http.ResponseWriter, r *http.Request, path string, resp *logical.Response) {
+func respondLogical(w http.ResponseWriter, r *http.Request, path string,
+	requestID string, resp *logical.Response) {
 	var httpResp interface{}
 	if resp != nil {
 		if resp.Redirect != "" {
@@ -88,7 +127,17 @@ func respondLogical(w http.ResponseWriter, r *http.Request, path string, resp *l
 			return
 		}
 
-		logicalResp := &LogicalResponse{Data: resp.Data}
+		logicalResp := &LogicalResponse{
+			RequestID: requestID,
+			Data:      resp.Data,
+		}
+		if resp.WrapInfo != nil {
+			logicalResp.WrapInfo = &WrapInfo{
+				Token:        resp.WrapInfo.Token,
+				TTL:          int(resp.WrapInfo.TTL.Seconds()),
+				CreationTime: resp.WrapInfo.CreationTime,
+			}
+		}
 		if resp.Secret != nil {
 			logicalResp.LeaseID = resp.Secret.LeaseID
 			logicalResp.Renewable = resp.Secret.Renewable
@@ -118,6 +167,7 @@ func respondLogical(w http.ResponseWriter, r *http.Request, path string, resp *l
 
 			logicalResp.Auth = &Auth{
 				ClientToken:   resp.Auth.ClientToken,
+				Accessor:      resp.Auth.Accessor,
 				Policies:      resp.Auth.Policies,
 				Metadata:      resp.Auth.Metadata,
 				LeaseDuration: int(resp.Auth.Lease.Seconds()),
@@ -133,17 +183,40 @@ func respondLogical(w http.ResponseWriter, r *http.Request, path string, resp *l
 }
 
 type LogicalResponse struct {
+	RequestID     string                 `json:"request_id"`
 	LeaseID       string                 `json:"lease_id"`
 	Renewable     bool                   `json:"renewable"`
 	LeaseDuration int                    `json:"lease_duration"`
 	Data          map[string]interface{} `json:"data"`
 	Auth          *Auth                  `json:"auth"`
+	WrapInfo      *WrapInfo              `json:"wrap_info,omitempty"`
 }
 
 type Auth struct {
 	ClientToken   string            `json:"client_token"`
+	Accessor      string            `json:"accessor"`
 	Policies      []string          `json:"policies"`
 	Metadata      map[string]string `json:"metadata"`
 	LeaseDuration int               `json:"lease_duration"`
 	Renewable     bool              `json:"renewable"`
 }
+
+type WrapInfo struct {
+	Token        string    `json:"token"`
+	TTL          int       `json:"ttl"`
+	CreationTime time.Time `json:"creation_time"`
+}
+
+// queryParams returns the first value of each query parameter of the
+// request, or nil if there are none.
+func queryParams(r *http.Request) map[string]interface{} {
+	query := r.URL.Query()
+	if len(query) == 0 {
+		return nil
+	}
+	data := make(map[string]interface{}, len(query))
+	for k, v := range query {
+		data[k] = v[0]
+	}
+	return data
+}
@@ -3,9 +3,11 @@ package http
 import (
 	"net/http"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/physical"
 	"github.com/hashicorp/vault/vault"
 )
@@ -39,7 +41,12 @@ func TestLogical(t *testing.T) {
 	}
 	testResponseStatus(t, resp, 200)
 	testResponseBody(t, resp, &actual)
+	if id := resp.Header.Get(RequestIDHeaderName); id == "" || actual["request_id"] != id {
+		t.Fatalf("bad: %#v %#v", actual, resp.Header)
+	}
+	delete(actual, "request_id")
 	delete(actual, "lease_id")
+	delete(actual["data"].(map[string]interface{}), "metadata")
 	if !reflect.DeepEqual(actual, expected) {
 		t.Fatalf("bad: %#v %#v", actual, expected)
 	}
@@ -68,6 +75,248 @@ func TestLogical_noExist(t *testing.T) {
 	testResponseStatus(t, resp, 404)
 }
 
+func TestLogical_rateLimited(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	err := core.SetRateLimitConfig(vault.RateLimitConfig{TokenRate: 0.1, TokenBurst: 1})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	resp, err := http.Get(addr + "/v1/secret/foo")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testResponseStatus(t, resp, 404)
+
+	resp, err = http.Get(addr + "/v1/secret/foo")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testResponseStatus(t, resp, 429)
+	if v := resp.Header.Get("Retry-After"); v != "10" {
+		t.Fatalf("bad: %s", v)
+	}
+}
+
+func TestLogical_requestTooLarge(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	_, err := core.HandleRequest(&logical.Request{
+		Operation:   logical.WriteOperation,
+		Path:        "sys/mounts/secret/tune",
+		Data:        map[string]interface{}{"max_request_size": 64},
+		ClientToken: token,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	resp := testHttpPut(t, addr+"/v1/secret/foo", map[string]interface{}{
+		"data": strings.Repeat("a", 128),
+	})
+	testResponseStatus(t, resp, 413)
+}
+
+func TestLogical_requestBodyTooLarge(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	old := maxRequestBodySize
+	maxRequestBodySize = 64
+	defer func() { maxRequestBodySize = old }()
+
+	// The body is rejected before it is decoded
+	resp := testHttpPut(t, addr+"/v1/secret/foo", map[string]interface{}{
+		"data": strings.Repeat("a", 128),
+	})
+	testResponseStatus(t, resp, 400)
+
+	resp = testHttpPut(t, addr+"/v1/secret/foo", map[string]interface{}{
+		"data": "bar",
+	})
+	testResponseStatus(t, resp, 204)
+}
+
+func TestLogical_noMount(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	resp, err := http.Get(addr + "/v1/missing/foo")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testResponseStatus(t, resp, 404)
+
+	resp = testHttpPut(t, addr+"/v1/missing/foo", map[string]interface{}{
+		"data": "bar",
+	})
+	testResponseStatus(t, resp, 404)
+}
+
+func TestLogical_existenceCheck(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	resp, err := http.Head(addr + "/v1/secret/foo")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testResponseStatus(t, resp, 404)
+
+	resp = testHttpPut(t, addr+"/v1/secret/foo", map[string]interface{}{
+		"data": "bar",
+	})
+	testResponseStatus(t, resp, 204)
+
+	resp, err = http.Head(addr + "/v1/secret/foo")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testResponseStatus(t, resp, 200)
+}
+
+func TestLogical_readQuery(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	resp, err := http.Get(addr + "/v1/sys/internal/mounts?type=generic")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	mounts, ok := actual["data"].(map[string]interface{})["mounts"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("bad: %#v", actual)
+	}
+	if _, ok := mounts["secret/"]; !ok || len(mounts) != 1 {
+		t.Fatalf("bad: %#v", mounts)
+	}
+}
+
+func TestLogical_readQueryIgnored(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	resp := testHttpPut(t, addr+"/v1/secret/foo", map[string]interface{}{
+		"data": "bar",
+	})
+	testResponseStatus(t, resp, 204)
+
+	// Query parameters are not passed to other reads
+	resp, err := http.Get(addr + "/v1/secret/foo?data=baz&other=1")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	data, ok := actual["data"].(map[string]interface{})
+	if !ok || data["data"] != "bar" || data["other"] != nil {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestLogical_readQueryVersion(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	req := &logical.Request{
+		Operation:   logical.WriteOperation,
+		Path:        "sys/mounts/versioned",
+		ClientToken: token,
+		Data: map[string]interface{}{
+			"type":    "generic",
+			"options": map[string]interface{}{"versions": "2"},
+		},
+	}
+	if _, err := core.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, v := range []string{"one", "two"} {
+		resp := testHttpPut(t, addr+"/v1/versioned/foo", map[string]interface{}{
+			"data": v,
+		})
+		testResponseStatus(t, resp, 204)
+	}
+
+	// Versioned mounts read previous versions from the query string
+	resp, err := http.Get(addr + "/v1/versioned/foo?version=1")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	data, ok := actual["data"].(map[string]interface{})
+	if !ok || data["data"] != "one" {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestLogical_checkAndSet(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	write := func(cas string) *http.Response {
+		body := strings.NewReader(`{"data":"bar","cas":"foo"}`)
+		req, err := http.NewRequest("PUT", addr+"/v1/secret/foo", body)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(CASHeaderName, cas)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		return resp
+	}
+
+	testResponseStatus(t, write("0"), 204)
+	testResponseStatus(t, write("0"), 400)
+	testResponseStatus(t, write("nope"), 400)
+
+	// The "cas" field is stored with the secret
+	resp, err := http.Get(addr + "/v1/secret/foo")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	data, ok := actual["data"].(map[string]interface{})
+	if !ok || data["cas"] != "foo" {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
 func TestLogical_StandbyRedirect(t *testing.T) {
 	ln1, addr1 := TestListener(t)
 	defer ln1.Close()
@@ -113,6 +362,9 @@ func TestLogical_StandbyRedirect(t *testing.T) {
 		"data": "bar",
 	})
 	testResponseStatus(t, resp, 307)
+	if loc := resp.Header.Get("Location"); loc != addr1+"/v1/secret/foo" {
+		t.Fatalf("bad: %s", loc)
+	}
 
 	//// READ to standby
 	resp, err = http.Get(addr2 + "/v1/auth/token/lookup-self")
@@ -131,12 +383,17 @@ func TestLogical_StandbyRedirect(t *testing.T) {
 			"policies":     []interface{}{"root"},
 			"display_name": "root",
 			"id":           root,
+			"ttl":          float64(0),
+			"orphan":       true,
 		},
 		"auth": nil,
 	}
 	testResponseStatus(t, resp, 200)
 	testResponseBody(t, resp, &actual)
+	delete(actual, "request_id")
 	delete(actual, "lease_id")
+	delete(actual["data"].(map[string]interface{}), "creation_time")
+	delete(actual["data"].(map[string]interface{}), "accessor")
 	if !reflect.DeepEqual(actual, expected) {
 		t.Fatalf("bad: %#v %#v", actual, expected)
 	}
@@ -172,7 +429,9 @@ func TestLogical_CreateToken(t *testing.T) {
 	}
 	testResponseStatus(t, resp, 200)
 	testResponseBody(t, resp, &actual)
+	delete(actual, "request_id")
 	delete(actual["auth"].(map[string]interface{}), "client_token")
+	delete(actual["auth"].(map[string]interface{}), "accessor")
 	if !reflect.DeepEqual(actual, expected) {
 		t.Fatalf("bad: %#v %#v", actual, expected)
 	}
@@ -182,3 +441,20 @@ func TestLogical_CreateToken(t *testing.T) {
 		t.Fatalf("should not get cookies: %#v", cookies)
 	}
 }
+
+func TestLogical_ListTokenAccessors(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	resp := testHttpList(t, addr+"/v1/auth/token/accessors")
+
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	keys := actual["data"].(map[string]interface{})["keys"].([]interface{})
+	if len(keys) != 1 || keys[0] == "" {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
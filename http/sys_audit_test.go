@@ -30,6 +30,7 @@ func TestSysAudit(t *testing.T) {
 			"type":        "noop",
 			"description": "",
 			"options":     map[string]interface{}{},
+			"best_effort": false,
 		},
 	}
 	testResponseStatus(t, resp, 200)
@@ -85,6 +85,7 @@ func handleSysEnableAuth(
 		Data: map[string]interface{}{
 			"type":        req.Type,
 			"description": req.Description,
+			"token_role":  req.TokenRole,
 		},
 	}))
 	if err != nil {
@@ -115,4 +116,5 @@ func handleSysDisableAuth(
 type EnableAuthRequest struct {
 	Type        string `json:"type"`
 	Description string `json:"description"`
+	TokenRole   string `json:"token_role"`
 }
@@ -24,6 +24,7 @@ func TestSysAuth(t *testing.T) {
 		"token/": map[string]interface{}{
 			"description": "token based credentials",
 			"type":        "token",
+			"token_role":  "",
 		},
 	}
 	testResponseStatus(t, resp, 200)
@@ -55,10 +56,12 @@ func TestSysEnableAuth(t *testing.T) {
 		"foo/": map[string]interface{}{
 			"description": "foo",
 			"type":        "noop",
+			"token_role":  "",
 		},
 		"token/": map[string]interface{}{
 			"description": "token based credentials",
 			"type":        "token",
+			"token_role":  "",
 		},
 	}
 	testResponseStatus(t, resp, 200)
@@ -93,6 +96,7 @@ func TestSysDisableAuth(t *testing.T) {
 		"token/": map[string]interface{}{
 			"description": "token based credentials",
 			"type":        "token",
+			"token_role":  "",
 		},
 	}
 	testResponseStatus(t, resp, 200)
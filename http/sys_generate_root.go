@@ -0,0 +1,163 @@
+package http
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/vault"
+)
+
+func handleSysGenerateRootAttempt(core *vault.Core) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			handleSysGenerateRootStatus(core, w, r)
+		case "PUT":
+			handleSysGenerateRootInit(core, w, r)
+		case "DELETE":
+			handleSysGenerateRootCancel(core, w, r)
+		default:
+			respondError(w, http.StatusMethodNotAllowed, nil)
+		}
+	})
+}
+
+func handleSysGenerateRootStatus(core *vault.Core, w http.ResponseWriter, r *http.Request) {
+	status, err := core.GenerateRootStatus()
+	if err == vault.ErrNotInit {
+		respondError(w, http.StatusBadRequest, fmt.Errorf(
+			"server is not yet initialized"))
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	respondOk(w, &GenerateRootStatusResponse{
+		Started:  status.Started,
+		Nonce:    status.Nonce,
+		Progress: status.Progress,
+		Required: status.Required,
+		PGPKeyID: status.PGPKeyID,
+	})
+}
+
+func handleSysGenerateRootInit(core *vault.Core, w http.ResponseWriter, r *http.Request) {
+	// Parse the request
+	var req GenerateRootInitRequest
+	if err := parseRequest(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Start the generation, redirecting to the leader if this Vault is
+	// not active
+	err := core.GenerateRootInit(req.OTP, req.PGPKey)
+	if err == vault.ErrStandby {
+		_, advertise, _ := core.Leader()
+		respondStandby(w, r.URL, advertise)
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	handleSysGenerateRootStatus(core, w, r)
+}
+
+func handleSysGenerateRootCancel(core *vault.Core, w http.ResponseWriter, r *http.Request) {
+	if err := core.GenerateRootCancel(); err != nil {
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+	respondOk(w, nil)
+}
+
+func handleSysGenerateRootUpdate(core *vault.Core) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			respondError(w, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		// Parse the request
+		var req GenerateRootUpdateRequest
+		if err := parseRequest(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.Key == "" {
+			respondError(
+				w, http.StatusBadRequest,
+				errors.New("'key' must specified in request body as JSON"))
+			return
+		}
+
+		// Decode the key, which is hex encoded
+		key, err := hex.DecodeString(req.Key)
+		if err != nil {
+			respondError(
+				w, http.StatusBadRequest,
+				errors.New("'key' must be a valid hex-string"))
+			return
+		}
+
+		// Provide the key to the generation identified by the nonce
+		result, err := core.GenerateRootUpdate(req.Nonce, key)
+		switch {
+		case err == nil:
+		case err == vault.ErrStandby:
+			_, advertise, _ := core.Leader()
+			respondStandby(w, r.URL, advertise)
+			return
+		case err == vault.ErrSealed,
+			err == vault.ErrGenerateRootNotStarted,
+			err == vault.ErrGenerateRootNonceMismatch,
+			errwrap.ContainsType(err, new(vault.ErrInvalidKey)):
+			respondError(w, http.StatusBadRequest, err)
+			return
+		default:
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		respondOk(w, &GenerateRootUpdateResponse{
+			Nonce:            req.Nonce,
+			Complete:         result.EncodedRootToken != "",
+			Progress:         result.Progress,
+			Required:         result.Required,
+			EncodedRootToken: result.EncodedRootToken,
+		})
+	})
+}
+
+type GenerateRootInitRequest struct {
+	OTP    string `json:"otp"`
+	PGPKey string `json:"pgp_key"`
+}
+
+type GenerateRootStatusResponse struct {
+	Started  bool   `json:"started"`
+	Nonce    string `json:"nonce,omitempty"`
+	Progress int    `json:"progress"`
+	Required int    `json:"required"`
+	PGPKeyID string `json:"pgp_key_id,omitempty"`
+}
+
+type GenerateRootUpdateRequest struct {
+	Key   string
+	Nonce string
+}
+
+type GenerateRootUpdateResponse struct {
+	Nonce            string `json:"nonce"`
+	Complete         bool   `json:"complete"`
+	Progress         int    `json:"progress"`
+	Required         int    `json:"required"`
+	EncodedRootToken string `json:"encoded_root_token,omitempty"`
+}
@@ -0,0 +1,114 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/vault"
+)
+
+func TestSysGenerateRoot(t *testing.T) {
+	core, key, _ := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+
+	resp, err := http.Get(addr + "/v1/sys/generate-root/attempt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var actual map[string]interface{}
+	expected := map[string]interface{}{
+		"started":  false,
+		"progress": float64(0),
+		"required": float64(1),
+	}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	otp := make([]byte, 36)
+	if _, err := rand.Read(otp); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	resp = testHttpPut(t, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+		"otp": base64.StdEncoding.EncodeToString(otp),
+	})
+	actual = map[string]interface{}{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	if actual["started"] != true || actual["nonce"] == "" {
+		t.Fatalf("bad: %#v", actual)
+	}
+	nonce := actual["nonce"].(string)
+
+	// A mismatched nonce is rejected
+	resp = testHttpPut(t, addr+"/v1/sys/generate-root/update", map[string]interface{}{
+		"key":   hex.EncodeToString(key),
+		"nonce": "foo",
+	})
+	testResponseStatus(t, resp, 400)
+
+	resp = testHttpPut(t, addr+"/v1/sys/generate-root/update", map[string]interface{}{
+		"key":   hex.EncodeToString(key),
+		"nonce": nonce,
+	})
+	actual = map[string]interface{}{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	if actual["complete"] != true || actual["progress"] != float64(1) {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	// Decode the token with the one-time pad
+	token, err := base64.StdEncoding.DecodeString(actual["encoded_root_token"].(string))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for i := range token {
+		token[i] ^= otp[i]
+	}
+	TestServerAuth(t, addr, string(token))
+	resp = testHttpPut(t, addr+"/v1/sys/policy/foo", map[string]interface{}{
+		"rules": `path "*" { policy = "read" }`,
+	})
+	testResponseStatus(t, resp, 204)
+}
+
+func TestSysGenerateRoot_cancel(t *testing.T) {
+	core, _, _ := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+
+	otp := make([]byte, 36)
+	resp := testHttpPut(t, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+		"otp": base64.StdEncoding.EncodeToString(otp),
+	})
+	testResponseStatus(t, resp, 200)
+
+	// A second generation cannot be started
+	resp = testHttpPut(t, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+		"otp": base64.StdEncoding.EncodeToString(otp),
+	})
+	testResponseStatus(t, resp, 400)
+
+	resp = testHttpDelete(t, addr+"/v1/sys/generate-root/attempt")
+	testResponseStatus(t, resp, 204)
+
+	resp, err := http.Get(addr + "/v1/sys/generate-root/attempt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	if actual["started"] != false {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
@@ -27,13 +27,17 @@ func handleSysHealthGet(core *vault.Core, w http.ResponseWriter, r *http.Request
 		respondError(w, http.StatusInternalServerError, err)
 		return
 	}
+	storageHealthy := core.StorageHealth() == nil
 
-	// Determine the status code
+	// Determine the status code, which is distinct for each state so that
+	// a load balancer can tell them apart without reading the body
 	code := http.StatusOK
 	switch {
 	case !init:
-		code = http.StatusInternalServerError
+		code = http.StatusNotImplemented
 	case sealed:
+		code = http.StatusServiceUnavailable
+	case !storageHealthy:
 		code = http.StatusInternalServerError
 	case standby:
 		code = 429 // Consul warning code
@@ -44,6 +48,8 @@ func handleSysHealthGet(core *vault.Core, w http.ResponseWriter, r *http.Request
 		Initialized: init,
 		Sealed:      sealed,
 		Standby:     standby,
+
+		StorageHealthy: storageHealthy,
 	}
 
 	// Generate the response
@@ -57,4 +63,6 @@ type HealthResponse struct {
 	Initialized bool `json:"initialized"`
 	Sealed      bool `json:"sealed"`
 	Standby     bool `json:"standby"`
+
+	StorageHealthy bool `json:"storage_healthy"`
 }
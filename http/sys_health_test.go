@@ -1,10 +1,13 @@
 package http
 
 import (
+	"fmt"
 	"net/http"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/vault/physical"
 	"github.com/hashicorp/vault/vault"
 )
 
@@ -23,6 +26,8 @@ func TestSysHealth_get(t *testing.T) {
 		"initialized": true,
 		"sealed":      false,
 		"standby":     false,
+
+		"storage_healthy": true,
 	}
 	testResponseStatus(t, resp, 200)
 	testResponseBody(t, resp, &actual)
@@ -30,3 +35,110 @@ func TestSysHealth_get(t *testing.T) {
 		t.Fatalf("bad: %#v", actual)
 	}
 }
+
+func TestSysHealth_sealed(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+
+	if err := core.Seal(token); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	resp, err := http.Get(addr + "/v1/sys/health")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var actual map[string]interface{}
+	expected := map[string]interface{}{
+		"initialized": true,
+		"sealed":      true,
+		"standby":     false,
+
+		"storage_healthy": true,
+	}
+	testResponseStatus(t, resp, 503)
+	testResponseBody(t, resp, &actual)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestSysHealth_uninit(t *testing.T) {
+	core := vault.TestCore(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+
+	resp, err := http.Get(addr + "/v1/sys/health")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var actual map[string]interface{}
+	expected := map[string]interface{}{
+		"initialized": false,
+		"sealed":      true,
+		"standby":     true,
+
+		"storage_healthy": true,
+	}
+	testResponseStatus(t, resp, 501)
+	testResponseBody(t, resp, &actual)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+// unhealthyInmem is an in-memory backend that fails its health check
+type unhealthyInmem struct {
+	*physical.InmemBackend
+}
+
+func (u *unhealthyInmem) HealthCheck() error {
+	return fmt.Errorf("unhealthy")
+}
+
+func TestSysHealth_storageUnhealthy(t *testing.T) {
+	core, err := vault.NewCore(&vault.CoreConfig{
+		Physical:     &unhealthyInmem{InmemBackend: physical.NewInmem()},
+		DisableMlock: true,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	key, _ := vault.TestCoreInit(t, core)
+	if _, err := core.Unseal(vault.TestKeyCopy(key)); err != nil {
+		t.Fatalf("unseal err: %s", err)
+	}
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+
+	// Wait for the first health check
+	start := time.Now()
+	for core.StorageHealth() == nil {
+		if time.Now().Sub(start) > time.Second {
+			t.Fatalf("should be unhealthy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp, err := http.Get(addr + "/v1/sys/health")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var actual map[string]interface{}
+	expected := map[string]interface{}{
+		"initialized": true,
+		"sealed":      false,
+		"standby":     false,
+
+		"storage_healthy": false,
+	}
+	testResponseStatus(t, resp, 500)
+	testResponseBody(t, resp, &actual)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
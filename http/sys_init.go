@@ -44,31 +44,52 @@ func handleSysInitPut(core *vault.Core, w http.ResponseWriter, r *http.Request)
 	result, err := core.Initialize(&vault.SealConfig{
 		SecretShares:    req.SecretShares,
 		SecretThreshold: req.SecretThreshold,
+		PGPKeys:         req.PGPKeys,
+		SecureSeal:      req.SecureSeal,
+		ShareLabels:     req.ShareLabels,
 	})
 	if err != nil {
 		respondError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	// Encode the keys
+	// Encode the keys, which are already armored if encrypted
 	keys := make([]string, 0, len(result.SecretShares))
 	for _, k := range result.SecretShares {
-		keys = append(keys, hex.EncodeToString(k))
+		if len(req.PGPKeys) > 0 {
+			keys = append(keys, string(k))
+		} else {
+			keys = append(keys, hex.EncodeToString(k))
+		}
+	}
+
+	// Return the labels in the order of the keys, if any were given
+	var labels []string
+	if len(req.ShareLabels) > 0 {
+		labels = make([]string, 0, len(result.Shares))
+		for _, share := range result.Shares {
+			labels = append(labels, share.Label)
+		}
 	}
 
 	respondOk(w, &InitResponse{
 		Keys:      keys,
+		KeyLabels: labels,
 		RootToken: result.RootToken,
 	})
 }
 
 type InitRequest struct {
-	SecretShares    int `json:"secret_shares"`
-	SecretThreshold int `json:"secret_threshold"`
+	SecretShares    int      `json:"secret_shares"`
+	SecretThreshold int      `json:"secret_threshold"`
+	PGPKeys         []string `json:"pgp_keys"`
+	SecureSeal      bool     `json:"secure_seal"`
+	ShareLabels     []string `json:"share_labels"`
 }
 
 type InitResponse struct {
 	Keys      []string `json:"keys"`
+	KeyLabels []string `json:"key_labels,omitempty"`
 	RootToken string   `json:"root_token"`
 }
 
@@ -75,13 +75,14 @@ func TestSysInit_put(t *testing.T) {
 		t.Fatal("no root token")
 	}
 
+	var nonce string
 	for _, key := range keysRaw.([]interface{}) {
 		keySlice, err := hex.DecodeString(key.(string))
 		if err != nil {
 			t.Fatalf("bad: %s", err)
 		}
 
-		if _, err := core.Unseal(keySlice); err != nil {
+		if _, nonce, err = core.UnsealWithNonce(nonce, keySlice); err != nil {
 			t.Fatalf("bad: %s", err)
 		}
 	}
@@ -94,3 +95,26 @@ func TestSysInit_put(t *testing.T) {
 		t.Fatal("should not be sealed")
 	}
 }
+
+func TestSysInit_put_labels(t *testing.T) {
+	core := vault.TestCore(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+
+	resp := testHttpPut(t, addr+"/v1/sys/init", map[string]interface{}{
+		"secret_shares":    2,
+		"secret_threshold": 2,
+		"share_labels":     []string{"alice", "bob"},
+	})
+
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	if len(actual["keys"].([]interface{})) != 2 {
+		t.Fatalf("bad: %#v", actual)
+	}
+	expected := []interface{}{"alice", "bob"}
+	if !reflect.DeepEqual(actual["key_labels"], expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
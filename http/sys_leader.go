@@ -3,6 +3,7 @@ package http
 import (
 	"net/http"
 
+	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/vault"
 )
 
@@ -24,6 +25,9 @@ func handleSysLeaderGet(core *vault.Core, w http.ResponseWriter, r *http.Request
 		haEnabled = false
 		err = nil
 	}
+	if err == vault.ErrLeaderUnknown {
+		err = nil
+	}
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, err)
 		return
@@ -36,6 +40,32 @@ func handleSysLeaderGet(core *vault.Core, w http.ResponseWriter, r *http.Request
 	})
 }
 
+func handleSysStepDown(core *vault.Core) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			respondError(w, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		// Get the auth for the request so we can access the token directly
+		req := requestAuth(r, &logical.Request{})
+
+		// Step down with the token above, redirecting to the leader if
+		// this Vault is not active
+		switch err := core.StepDown(req.ClientToken); err {
+		case nil:
+			respondOk(w, nil)
+		case vault.ErrHANotEnabled:
+			respondError(w, http.StatusBadRequest, err)
+		case vault.ErrStandby:
+			_, advertise, _ := core.Leader()
+			respondStandby(w, r.URL, advertise)
+		default:
+			respondError(w, http.StatusInternalServerError, err)
+		}
+	})
+}
+
 type LeaderResponse struct {
 	HAEnabled     bool   `json:"ha_enabled"`
 	IsSelf        bool   `json:"is_self"`
@@ -30,3 +30,13 @@ func TestSysLeader_get(t *testing.T) {
 		t.Fatalf("bad: %#v", actual)
 	}
 }
+
+func TestSysStepDown_haNotEnabled(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	resp := testHttpPut(t, addr+"/v1/sys/step-down", nil)
+	testResponseStatus(t, resp, 400)
+}
@@ -37,18 +37,19 @@ func handleSysRenew(core *vault.Core) http.Handler {
 			}
 		}
 
-		resp, ok := request(core, w, r, requestAuth(r, &logical.Request{
+		logicalReq := requestAuth(r, &logical.Request{
 			Operation: logical.WriteOperation,
 			Path:      "sys/renew/" + path,
 			Data: map[string]interface{}{
 				"increment": req.Increment,
 			},
-		}))
+		})
+		resp, ok := request(core, w, r, logicalReq)
 		if !ok {
 			return
 		}
 
-		respondLogical(w, r, path, resp)
+		respondLogical(w, r, path, logicalReq.ID, resp)
 	})
 }
 
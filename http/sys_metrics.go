@@ -0,0 +1,32 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/hashicorp/vault/helper/prometheus-sink"
+	"github.com/hashicorp/vault/vault"
+)
+
+func handleSysMetrics(core *vault.Core) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			handleSysMetricsGet(core, w, r)
+		default:
+			respondError(w, http.StatusMethodNotAllowed, nil)
+		}
+	})
+}
+
+func handleSysMetricsGet(core *vault.Core, w http.ResponseWriter, r *http.Request) {
+	// The metrics are only exposed if enabled
+	sink := core.PrometheusSink()
+	if sink == nil {
+		respondError(w, http.StatusNotFound, nil)
+		return
+	}
+
+	w.Header().Add("Content-Type", prometheussink.ContentType)
+	w.WriteHeader(http.StatusOK)
+	sink.WriteTo(w)
+}
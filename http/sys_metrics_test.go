@@ -0,0 +1,56 @@
+package http
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/prometheus-sink"
+	"github.com/hashicorp/vault/physical"
+	"github.com/hashicorp/vault/vault"
+)
+
+func TestSysMetrics_get(t *testing.T) {
+	sink := prometheussink.New("")
+	core, err := vault.NewCore(&vault.CoreConfig{
+		Physical:       physical.NewInmem(),
+		DisableMlock:   true,
+		PrometheusSink: sink,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+
+	sink.SetGauge([]string{"vault", "expire", "num_leases"}, 1)
+
+	resp, err := http.Get(addr + "/v1/sys/metrics")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testResponseStatus(t, resp, 200)
+	if ct := resp.Header.Get("Content-Type"); ct != prometheussink.ContentType {
+		t.Fatalf("bad: %s", ct)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(string(body), "vault_expire_num_leases 1\n") {
+		t.Fatalf("bad: %s", body)
+	}
+}
+
+func TestSysMetrics_disabled(t *testing.T) {
+	core, _, _ := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+
+	resp, err := http.Get(addr + "/v1/sys/metrics")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testResponseStatus(t, resp, 404)
+}
@@ -23,10 +23,17 @@ func TestSysMounts(t *testing.T) {
 	expected := map[string]interface{}{
 		"secret/": map[string]interface{}{
 			"description": "generic secret storage",
+			"read_only":   false,
 			"type":        "generic",
 		},
+		"cubbyhole/": map[string]interface{}{
+			"description": "per-token private secret storage",
+			"read_only":   false,
+			"type":        "cubbyhole",
+		},
 		"sys/": map[string]interface{}{
 			"description": "system endpoints used for control, policy and debugging",
+			"read_only":   false,
 			"type":        "system",
 		},
 	}
@@ -58,14 +65,22 @@ func TestSysMount(t *testing.T) {
 	expected := map[string]interface{}{
 		"foo/": map[string]interface{}{
 			"description": "foo",
+			"read_only":   false,
 			"type":        "generic",
 		},
 		"secret/": map[string]interface{}{
 			"description": "generic secret storage",
+			"read_only":   false,
 			"type":        "generic",
 		},
+		"cubbyhole/": map[string]interface{}{
+			"description": "per-token private secret storage",
+			"read_only":   false,
+			"type":        "cubbyhole",
+		},
 		"sys/": map[string]interface{}{
 			"description": "system endpoints used for control, policy and debugging",
+			"read_only":   false,
 			"type":        "system",
 		},
 	}
@@ -103,14 +118,22 @@ func TestSysRemount(t *testing.T) {
 	expected := map[string]interface{}{
 		"bar/": map[string]interface{}{
 			"description": "foo",
+			"read_only":   false,
 			"type":        "generic",
 		},
 		"secret/": map[string]interface{}{
 			"description": "generic secret storage",
+			"read_only":   false,
 			"type":        "generic",
 		},
+		"cubbyhole/": map[string]interface{}{
+			"description": "per-token private secret storage",
+			"read_only":   false,
+			"type":        "cubbyhole",
+		},
 		"sys/": map[string]interface{}{
 			"description": "system endpoints used for control, policy and debugging",
+			"read_only":   false,
 			"type":        "system",
 		},
 	}
@@ -145,10 +168,17 @@ func TestSysUnmount(t *testing.T) {
 	expected := map[string]interface{}{
 		"secret/": map[string]interface{}{
 			"description": "generic secret storage",
+			"read_only":   false,
 			"type":        "generic",
 		},
+		"cubbyhole/": map[string]interface{}{
+			"description": "per-token private secret storage",
+			"read_only":   false,
+			"type":        "cubbyhole",
+		},
 		"sys/": map[string]interface{}{
 			"description": "system endpoints used for control, policy and debugging",
+			"read_only":   false,
 			"type":        "system",
 		},
 	}
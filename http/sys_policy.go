@@ -10,13 +10,19 @@ import (
 
 func handleSysListPolicies(core *vault.Core) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "GET" {
+		var op logical.Operation
+		switch r.Method {
+		case "GET":
+			op = logical.ReadOperation
+		case "LIST":
+			op = logical.ListOperation
+		default:
 			respondError(w, http.StatusMethodNotAllowed, nil)
 			return
 		}
 
 		resp, ok := request(core, w, r, requestAuth(r, &logical.Request{
-			Operation: logical.ReadOperation,
+			Operation: op,
 			Path:      "sys/policy",
 		}))
 		if !ok {
@@ -41,6 +47,10 @@ func handleSysPolicy(core *vault.Core) http.Handler {
 		case "PUT":
 			fallthrough
 		case "POST":
+			if r.URL.Path == "/v1/sys/policy/test" {
+				handleSysTestPolicy(core, w, r)
+				return
+			}
 			handleSysWritePolicy(core, w, r)
 		case "DELETE":
 			handleSysDeletePolicy(core, w, r)
@@ -137,6 +147,32 @@ func handleSysWritePolicy(core *vault.Core, w http.ResponseWriter, r *http.Reque
 	respondOk(w, nil)
 }
 
+// handleSysTestPolicy checks the operations a token with the given
+// policies would be permitted. The request is passed through as is,
+// since a request with rules sets the policy named "test" instead.
+func handleSysTestPolicy(core *vault.Core, w http.ResponseWriter, r *http.Request) {
+	var req map[string]interface{}
+	if err := parseRequest(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	resp, ok := request(core, w, r, requestAuth(r, &logical.Request{
+		Operation: logical.WriteOperation,
+		Path:      "sys/policy/test",
+		Data:      req,
+	}))
+	if !ok {
+		return
+	}
+	if resp == nil {
+		respondOk(w, nil)
+		return
+	}
+
+	respondOk(w, resp.Data)
+}
+
 type listPolicyResponse struct {
 	Policies []string `json:"policies"`
 }
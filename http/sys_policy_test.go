@@ -21,7 +21,26 @@ func TestSysPolicies(t *testing.T) {
 
 	var actual map[string]interface{}
 	expected := map[string]interface{}{
-		"policies": []interface{}{"root"},
+		"policies": []interface{}{"default", "root"},
+	}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestSysPolicies_List(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	resp := testHttpList(t, addr+"/v1/sys/policy")
+
+	var actual map[string]interface{}
+	expected := map[string]interface{}{
+		"policies": []interface{}{"default", "root"},
 	}
 	testResponseStatus(t, resp, 200)
 	testResponseBody(t, resp, &actual)
@@ -71,7 +90,7 @@ func TestSysWritePolicy(t *testing.T) {
 
 	var actual map[string]interface{}
 	expected := map[string]interface{}{
-		"policies": []interface{}{"foo", "root"},
+		"policies": []interface{}{"default", "foo", "root"},
 	}
 	testResponseStatus(t, resp, 200)
 	testResponseBody(t, resp, &actual)
@@ -101,7 +120,7 @@ func TestSysDeletePolicy(t *testing.T) {
 
 	var actual map[string]interface{}
 	expected := map[string]interface{}{
-		"policies": []interface{}{"root"},
+		"policies": []interface{}{"default", "root"},
 	}
 	testResponseStatus(t, resp, 200)
 	testResponseBody(t, resp, &actual)
@@ -109,3 +128,42 @@ func TestSysDeletePolicy(t *testing.T) {
 		t.Fatalf("bad: %#v", actual)
 	}
 }
+
+func TestSysTestPolicy(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	resp := testHttpPost(t, addr+"/v1/sys/policy/foo", map[string]interface{}{
+		"rules": `path "secret/*" { policy = "read" }`,
+	})
+	testResponseStatus(t, resp, 204)
+
+	resp = testHttpPost(t, addr+"/v1/sys/policy/test", map[string]interface{}{
+		"policies": []string{"foo"},
+		"checks": []map[string]interface{}{
+			{"operation": "read", "path": "secret/bar"},
+			{"operation": "write", "path": "secret/bar"},
+		},
+	})
+
+	var actual map[string]interface{}
+	expected := map[string]interface{}{
+		"results": []interface{}{
+			map[string]interface{}{"operation": "read", "path": "secret/bar", "allowed": true},
+			map[string]interface{}{"operation": "write", "path": "secret/bar", "allowed": false},
+		},
+	}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	// A policy named "test" can still be written
+	resp = testHttpPost(t, addr+"/v1/sys/policy/test", map[string]interface{}{
+		"rules": ``,
+	})
+	testResponseStatus(t, resp, 204)
+}
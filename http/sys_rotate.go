@@ -0,0 +1,55 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/vault"
+)
+
+func handleSysKeyStatus(core *vault.Core) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			respondError(w, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		switch term, err := core.ActiveTerm(); err {
+		case nil:
+			respondOk(w, &KeyStatusResponse{Term: term})
+		case vault.ErrStandby:
+			_, advertise, _ := core.Leader()
+			respondStandby(w, r.URL, advertise)
+		default:
+			respondError(w, http.StatusInternalServerError, err)
+		}
+	})
+}
+
+func handleSysRotate(core *vault.Core) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			respondError(w, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		// Get the auth for the request so we can access the token directly
+		req := requestAuth(r, &logical.Request{})
+
+		// Rotate with the token above, redirecting to the leader if this
+		// Vault is not active
+		switch term, err := core.Rotate(req.ClientToken); err {
+		case nil:
+			respondOk(w, &KeyStatusResponse{Term: term})
+		case vault.ErrStandby:
+			_, advertise, _ := core.Leader()
+			respondStandby(w, r.URL, advertise)
+		default:
+			respondError(w, http.StatusInternalServerError, err)
+		}
+	})
+}
+
+type KeyStatusResponse struct {
+	Term uint32 `json:"term"`
+}
@@ -21,13 +21,54 @@ func handleSysSeal(core *vault.Core) http.Handler {
 		// Get the auth for the request so we can access the token directly
 		req := requestAuth(r, &logical.Request{})
 
+		// Parse the request, which only has a body for a secure seal
+		var sealReq SealRequest
+		if r.ContentLength != 0 {
+			if err := parseRequest(r, &sealReq); err != nil {
+				respondError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+
 		// Seal with the token above
-		if err := core.Seal(req.ClientToken); err != nil {
+		if sealReq.Key == "" {
+			err := core.Seal(req.ClientToken)
+			if err == vault.ErrSecureSealRequired {
+				respondError(w, http.StatusBadRequest, err)
+				return
+			}
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, err)
+				return
+			}
+			respondOk(w, nil)
+			return
+		}
+
+		// Decode the key, which is hex encoded
+		key, err := hex.DecodeString(sealReq.Key)
+		if err != nil {
+			respondError(
+				w, http.StatusBadRequest,
+				errors.New("'key' must be a valid hex-string"))
+			return
+		}
+
+		// Provide the key to the secure seal with the token above
+		sealed, err := core.SecureSeal(req.ClientToken, key)
+		if err != nil {
+			if errwrap.ContainsType(err, new(vault.ErrInvalidKey)) {
+				respondError(w, http.StatusBadRequest, err)
+				return
+			}
 			respondError(w, http.StatusInternalServerError, err)
 			return
 		}
 
-		respondOk(w, nil)
+		respondOk(w, &SecureSealResponse{
+			Sealed:   sealed,
+			Progress: core.SecureSealProgress(),
+		})
 	})
 }
 
@@ -44,6 +85,11 @@ func handleSysUnseal(core *vault.Core) http.Handler {
 			respondError(w, http.StatusBadRequest, err)
 			return
 		}
+		if req.Reset {
+			core.ResetUnsealProcess()
+			handleSysSealStatusRaw(core, w, r)
+			return
+		}
 		if req.Key == "" {
 			respondError(
 				w, http.StatusBadRequest,
@@ -60,8 +106,14 @@ func handleSysUnseal(core *vault.Core) http.Handler {
 			return
 		}
 
-		// Attempt the unseal
-		if _, err := core.Unseal(key); err != nil {
+		// Attempt the unseal. Only the first key may be given without
+		// the nonce of the unseal in progress.
+		_, _, err = core.UnsealWithNonce(req.Nonce, key)
+		if err == vault.ErrUnsealNonceMismatch {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err != nil {
 			// Ignore ErrInvalidKey because its a user error that we
 			// mask away. We just show them the seal status.
 			if !errwrap.ContainsType(err, new(vault.ErrInvalidKey)) {
@@ -87,38 +139,45 @@ func handleSysSealStatus(core *vault.Core) http.Handler {
 }
 
 func handleSysSealStatusRaw(core *vault.Core, w http.ResponseWriter, r *http.Request) {
-	sealed, err := core.Sealed()
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, err)
+	status, err := core.SealStatus()
+	if err == vault.ErrNotInit {
+		respondError(w, http.StatusBadRequest, fmt.Errorf(
+			"server is not yet initialized"))
 		return
 	}
-
-	sealConfig, err := core.SealConfig()
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, err)
 		return
 	}
-	if sealConfig == nil {
-		respondError(w, http.StatusBadRequest, fmt.Errorf(
-			"server is not yet initialized"))
-		return
-	}
 
 	respondOk(w, &SealStatusResponse{
-		Sealed:   sealed,
-		T:        sealConfig.SecretThreshold,
-		N:        sealConfig.SecretShares,
-		Progress: core.SecretProgress(),
+		Sealed:   status.Sealed,
+		T:        status.SecretThreshold,
+		N:        status.SecretShares,
+		Progress: status.Progress,
+		Nonce:    status.Nonce,
 	})
 }
 
 type SealStatusResponse struct {
-	Sealed   bool `json:"sealed"`
-	T        int  `json:"t"`
-	N        int  `json:"n"`
-	Progress int  `json:"progress"`
+	Sealed   bool   `json:"sealed"`
+	T        int    `json:"t"`
+	N        int    `json:"n"`
+	Progress int    `json:"progress"`
+	Nonce    string `json:"nonce,omitempty"`
 }
 
 type UnsealRequest struct {
+	Key   string
+	Nonce string
+	Reset bool
+}
+
+type SealRequest struct {
 	Key string
 }
+
+type SecureSealResponse struct {
+	Sealed   bool `json:"sealed"`
+	Progress int  `json:"progress"`
+}
@@ -64,6 +64,55 @@ func TestSysSeal(t *testing.T) {
 	}
 }
 
+func TestSysSeal_secure(t *testing.T) {
+	core := vault.TestCore(t)
+	result, err := core.Initialize(&vault.SealConfig{
+		SecretShares:    3,
+		SecretThreshold: 2,
+		SecureSeal:      true,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var nonce string
+	for _, key := range result.SecretShares[:2] {
+		if _, nonce, err = core.UnsealWithNonce(nonce, vault.TestKeyCopy(key)); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, result.RootToken)
+
+	resp := testHttpPut(t, addr+"/v1/sys/seal", nil)
+	testResponseStatus(t, resp, 400)
+
+	for i, key := range result.SecretShares[1:] {
+		resp = testHttpPut(t, addr+"/v1/sys/seal", map[string]interface{}{
+			"key": hex.EncodeToString(key),
+		})
+
+		var actual map[string]interface{}
+		expected := map[string]interface{}{
+			"sealed":   i == 1,
+			"progress": float64(1 - i),
+		}
+		testResponseStatus(t, resp, 200)
+		testResponseBody(t, resp, &actual)
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("bad: %d %#v", i, actual)
+		}
+	}
+
+	check, err := core.Sealed()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !check {
+		t.Fatal("should be sealed")
+	}
+}
+
 func TestSysSeal_unsealed(t *testing.T) {
 	core, _, token := vault.TestCoreUnsealed(t)
 	ln, addr := TestServer(t, core)
@@ -129,3 +178,97 @@ func TestSysUnseal_badKey(t *testing.T) {
 		t.Fatalf("bad: %#v", actual)
 	}
 }
+
+func TestSysUnseal_nonce(t *testing.T) {
+	core := vault.TestCore(t)
+	result, err := core.Initialize(&vault.SealConfig{
+		SecretShares:    3,
+		SecretThreshold: 3,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+
+	// The first key starts an unseal with a nonce
+	resp := testHttpPut(t, addr+"/v1/sys/unseal", map[string]interface{}{
+		"key": hex.EncodeToString(result.SecretShares[0]),
+	})
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	nonce, _ := actual["nonce"].(string)
+	if nonce == "" || actual["progress"] != float64(1) {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	// A matching nonce continues the unseal
+	resp = testHttpPut(t, addr+"/v1/sys/unseal", map[string]interface{}{
+		"key":   hex.EncodeToString(result.SecretShares[1]),
+		"nonce": nonce,
+	})
+	actual = nil
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	if actual["nonce"] != nonce || actual["progress"] != float64(2) {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	// A wrong nonce resets it
+	resp = testHttpPut(t, addr+"/v1/sys/unseal", map[string]interface{}{
+		"key":   hex.EncodeToString(result.SecretShares[2]),
+		"nonce": "stale",
+	})
+	testResponseStatus(t, resp, 400)
+	if core.SecretProgress() != 0 {
+		t.Fatalf("bad: %d", core.SecretProgress())
+	}
+
+	// A missing nonce also resets it once an unseal is in progress
+	resp = testHttpPut(t, addr+"/v1/sys/unseal", map[string]interface{}{
+		"key": hex.EncodeToString(result.SecretShares[0]),
+	})
+	testResponseStatus(t, resp, 200)
+	resp = testHttpPut(t, addr+"/v1/sys/unseal", map[string]interface{}{
+		"key": hex.EncodeToString(result.SecretShares[1]),
+	})
+	testResponseStatus(t, resp, 400)
+	if core.SecretProgress() != 0 {
+		t.Fatalf("bad: %d", core.SecretProgress())
+	}
+}
+
+func TestSysUnseal_reset(t *testing.T) {
+	core := vault.TestCore(t)
+	result, err := core.Initialize(&vault.SealConfig{
+		SecretShares:    3,
+		SecretThreshold: 3,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+
+	resp := testHttpPut(t, addr+"/v1/sys/unseal", map[string]interface{}{
+		"key": hex.EncodeToString(result.SecretShares[0]),
+	})
+	testResponseStatus(t, resp, 200)
+
+	resp = testHttpPut(t, addr+"/v1/sys/unseal", map[string]interface{}{
+		"reset": true,
+	})
+	var actual map[string]interface{}
+	expected := map[string]interface{}{
+		"sealed":   true,
+		"t":        float64(3),
+		"n":        float64(3),
+		"progress": float64(0),
+	}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
@@ -0,0 +1,42 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/vault"
+)
+
+func handleSysVerify(core *vault.Core) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			respondError(w, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		// Get the auth for the request so we can access the token directly
+		req := requestAuth(r, &logical.Request{})
+
+		// Verify with the token above, redirecting to the leader if this
+		// Vault is not active
+		switch result, err := core.VerifyBarrier(req.ClientToken); err {
+		case nil:
+			respondOk(w, &VerifyResponse{
+				Checked:     result.Checked,
+				Corrupt:     len(result.Failed),
+				CorruptKeys: result.Failed,
+			})
+		case vault.ErrStandby:
+			_, advertise, _ := core.Leader()
+			respondStandby(w, r.URL, advertise)
+		default:
+			respondError(w, http.StatusInternalServerError, err)
+		}
+	})
+}
+
+type VerifyResponse struct {
+	Checked     int               `json:"checked"`
+	Corrupt     int               `json:"corrupt"`
+	CorruptKeys map[string]string `json:"corrupt_keys"`
+}
@@ -0,0 +1,33 @@
+package http
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/vault"
+)
+
+func TestSysVerify(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	resp := testHttpPut(t, addr+"/v1/sys/verify", nil)
+
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	if actual["checked"].(float64) == 0 {
+		t.Fatalf("bad: %#v", actual)
+	}
+	actual["checked"] = nil
+	expected := map[string]interface{}{
+		"checked":      nil,
+		"corrupt":      float64(0),
+		"corrupt_keys": map[string]interface{}{},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
@@ -0,0 +1,53 @@
+package http
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/vault"
+)
+
+func handleSysUnwrap(core *vault.Core) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" && r.Method != "POST" {
+			respondError(w, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		// Parse the request, the wrapping token is either given in the
+		// body or used as the client token
+		var req UnwrapRequest
+		if err := parseRequest(r, &req); err != nil && err != io.EOF {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		token := req.Token
+		if token == "" {
+			token = requestAuth(r, &logical.Request{}).ClientToken
+		}
+
+		// Unwrap the response, redirecting to the leader if this Vault
+		// is not active
+		resp, err := core.Unwrap(token)
+		switch err {
+		case nil:
+		case vault.ErrStandby:
+			_, advertise, _ := core.Leader()
+			respondStandby(w, r.URL, advertise)
+			return
+		case vault.ErrInvalidWrappingToken:
+			respondError(w, http.StatusBadRequest, err)
+			return
+		default:
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		respondLogical(w, r, "sys/wrapping/unwrap", "", resp)
+	})
+}
+
+type UnwrapRequest struct {
+	Token string `json:"token"`
+}
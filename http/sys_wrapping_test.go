@@ -0,0 +1,74 @@
+package http
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/vault/vault"
+)
+
+func TestSysUnwrap(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	resp := testHttpPut(t, addr+"/v1/secret/foo", map[string]interface{}{
+		"data": "bar",
+	})
+	testResponseStatus(t, resp, 204)
+
+	// Read the secret wrapped
+	req, err := http.NewRequest("GET", addr+"/v1/secret/foo", nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	req.Header.Set(WrapTTLHeaderName, "5m")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	if actual["data"] != nil {
+		t.Fatalf("bad: %#v", actual)
+	}
+	wrapInfo, ok := actual["wrap_info"].(map[string]interface{})
+	if !ok || wrapInfo["token"] == "" || wrapInfo["ttl"] != float64(300) {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	// Unwrap the secret, which only works once
+	body := map[string]interface{}{"token": wrapInfo["token"]}
+	resp = testHttpPut(t, addr+"/v1/sys/wrapping/unwrap", body)
+	actual = map[string]interface{}{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	data, ok := actual["data"].(map[string]interface{})
+	if !ok || data["data"] != "bar" {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	resp = testHttpPut(t, addr+"/v1/sys/wrapping/unwrap", body)
+	testResponseStatus(t, resp, 400)
+}
+
+func TestSysUnwrap_badTTL(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	req, err := http.NewRequest("GET", addr+"/v1/secret/foo", nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	req.Header.Set(WrapTTLHeaderName, "foo")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testResponseStatus(t, resp, 400)
+}
@@ -28,6 +28,11 @@ type Auth struct {
 	// This will be filled in by Vault core when an auth structure is
 	// returned. Setting this manually will have no effect.
 	ClientToken string
+
+	// Accessor is the accessor of the client token, which identifies
+	// the token without allowing it to be used. Unlike the token, it is
+	// not hashed in the audit log. This is filled in by Vault core.
+	Accessor string
 }
 
 func (a *Auth) GoString() string {
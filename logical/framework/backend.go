@@ -119,6 +119,13 @@ func (b *Backend) HandleRequest(req *logical.Request) (*logical.Response, error)
 		return nil, logical.ErrUnsupportedOperation
 	}
 
+	// Do not call the callback for a request that was already canceled
+	select {
+	case <-req.Cancel:
+		return nil, logical.ErrRequestCanceled
+	default:
+	}
+
 	// Call the callback with the request and the data
 	return callback(req, &FieldData{
 		Raw:    raw,
@@ -180,6 +187,24 @@ func (b *Backend) init() {
 	}
 }
 
+// logical.FieldsBackend impl.
+func (b *Backend) UnknownFields(req *logical.Request) ([]string, bool) {
+	// Paths that declare no fields may read the raw data themselves
+	path, _ := b.route(req.Path)
+	if path == nil || path.ArbitraryFields || len(path.Fields) == 0 {
+		return nil, false
+	}
+
+	var unknown []string
+	for k := range req.Data {
+		if _, ok := path.Fields[k]; !ok {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown, true
+}
+
 func (b *Backend) route(path string) (*Path, map[string]string) {
 	b.once.Do(b.init)
 
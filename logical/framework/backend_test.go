@@ -39,6 +39,61 @@ func BenchmarkBackendRoute(b *testing.B) {
 
 func TestBackend_impl(t *testing.T) {
 	var _ logical.Backend = new(Backend)
+	var _ logical.FieldsBackend = new(Backend)
+}
+
+func TestBackendUnknownFields(t *testing.T) {
+	b := &Backend{
+		Paths: []*Path{
+			&Path{
+				Pattern: "foo/(?P<name>.+)",
+				Fields: map[string]*FieldSchema{
+					"name": &FieldSchema{Type: TypeString},
+					"ttl":  &FieldSchema{Type: TypeString},
+				},
+			},
+			&Path{
+				Pattern: "raw",
+			},
+			&Path{
+				Pattern: "kv",
+				Fields: map[string]*FieldSchema{
+					"lease": &FieldSchema{Type: TypeString},
+				},
+				ArbitraryFields: true,
+			},
+		},
+	}
+
+	req := &logical.Request{
+		Path: "foo/bar",
+		Data: map[string]interface{}{
+			"ttl": "1h",
+			"tt1": "1h",
+			"bad": true,
+		},
+	}
+	unknown, ok := b.UnknownFields(req)
+	if !ok {
+		t.Fatalf("fields should be known")
+	}
+	if !reflect.DeepEqual(unknown, []string{"bad", "tt1"}) {
+		t.Fatalf("bad: %#v", unknown)
+	}
+
+	// Paths without fields and unknown paths are not checked
+	req.Path = "raw"
+	if _, ok := b.UnknownFields(req); ok {
+		t.Fatalf("fields should not be known")
+	}
+	req.Path = "kv"
+	if _, ok := b.UnknownFields(req); ok {
+		t.Fatalf("fields should not be known")
+	}
+	req.Path = "missing"
+	if _, ok := b.UnknownFields(req); ok {
+		t.Fatalf("fields should not be known")
+	}
 }
 
 func TestBackendHandleRequest(t *testing.T) {
@@ -77,6 +132,39 @@ func TestBackendHandleRequest(t *testing.T) {
 	}
 }
 
+func TestBackendHandleRequest_canceled(t *testing.T) {
+	called := false
+	callback := func(req *logical.Request, data *FieldData) (*logical.Response, error) {
+		called = true
+		return nil, nil
+	}
+
+	b := &Backend{
+		Paths: []*Path{
+			&Path{
+				Pattern: "foo/bar",
+				Callbacks: map[logical.Operation]OperationFunc{
+					logical.ReadOperation: callback,
+				},
+			},
+		},
+	}
+
+	cancelCh := make(chan struct{})
+	close(cancelCh)
+	_, err := b.HandleRequest(&logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "foo/bar",
+		Cancel:    cancelCh,
+	})
+	if err != logical.ErrRequestCanceled {
+		t.Fatalf("err: %v", err)
+	}
+	if called {
+		t.Fatalf("callback should not be called")
+	}
+}
+
 func TestBackendHandleRequest_404(t *testing.T) {
 	callback := func(req *logical.Request, data *FieldData) (*logical.Response, error) {
 		return &logical.Response{
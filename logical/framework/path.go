@@ -37,6 +37,11 @@ type Path struct {
 	// whereas all fields are avaiable in the Write operation.
 	Fields map[string]*FieldSchema
 
+	// ArbitraryFields is set if the path accepts data fields that are
+	// not declared in Fields, such as a path that stores the raw request
+	// data. Fields on such a path are never rejected as unknown.
+	ArbitraryFields bool
+
 	// Callbacks are the set of callbacks that are called for a given
 	// operation. If a callback for a specific operation is not present,
 	// then logical.ErrUnsupportedOperation is automatically generated.
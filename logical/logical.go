@@ -34,6 +34,55 @@ type Backend interface {
 	SetLogger(*log.Logger)
 }
 
+// ExportableBackend is an optional interface that can be implemented
+// by a Backend whose stored data is static and can be safely exported
+// in bulk. Backends that generate dynamic secrets must not implement it.
+type ExportableBackend interface {
+	// Exportable returns true if the stored data can be exported
+	Exportable() bool
+
+	// ExportEntry reads the entry at the given key of the storage and
+	// returns it as it should be exported, decoded from however the
+	// backend stores it. It returns nil if the key does not hold data
+	// to export, such as the internal records of the backend.
+	ExportEntry(s Storage, key string) (*StorageEntry, error)
+}
+
+// FieldsBackend is an optional interface that can be implemented by a
+// Backend that declares the fields accepted by each path. It is used
+// to reject requests carrying unknown fields when strict fields are
+// enabled.
+type FieldsBackend interface {
+	// UnknownFields returns the fields of the request data that are not
+	// accepted by the request path. The second return value is false
+	// if the fields accepted by the path are not known.
+	UnknownFields(*Request) ([]string, bool)
+}
+
+// CleanupBackend is an optional interface that can be implemented by a
+// Backend that holds resources outside of Vault, such as a process. It
+// is called when the backend is unmounted or the Vault is sealed, after
+// which the backend is no longer used.
+type CleanupBackend interface {
+	// Cleanup releases the resources held by the backend
+	Cleanup()
+}
+
+// UpgradeBackend is an optional interface that can be implemented by a
+// Backend that changes the format of its stored data across releases.
+// The version of the data is recorded with the mount, and Upgrade is
+// called when the mount is set up after an unseal if it is older than
+// StorageVersion, before any request is routed to the backend.
+type UpgradeBackend interface {
+	// StorageVersion returns the current version of the stored data
+	StorageVersion() int
+
+	// Upgrade migrates the stored data from the given version to the
+	// current version. It may be called again with the same version if
+	// it fails, so it must be safe to retry.
+	Upgrade(s Storage, from int) error
+}
+
 // Factory is the factory function to create a logical backend.
 type Factory func(map[string]string) (Backend, error)
 
@@ -44,4 +93,13 @@ type Paths struct {
 
 	// Unauthenticated are the paths that can be accessed without any auth.
 	Unauthenticated []string
+
+	// Idempotent are the paths where writes may carry an idempotency key.
+	// A replayed key returns the originally recorded response instead of
+	// executing the write again.
+	Idempotent []string
+
+	// Query are the paths whose reads take their parameters from the
+	// query string of the HTTP request. Other reads have no data.
+	Query []string
 }
@@ -0,0 +1,348 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+var (
+	// ErrUnavailable is returned for requests made while the plugin
+	// process is not running, such as while it is being restarted
+	ErrUnavailable = errors.New("plugin backend is unavailable")
+
+	// startTimeout is how long the plugin has to connect after launch
+	startTimeout = 10 * time.Second
+
+	// restartInterval is how long to wait before restarting a plugin
+	// that has exited
+	restartInterval = time.Second
+)
+
+// Factory returns a logical.Factory for backends served by the plugin
+// executable at the given path, which is invoked with the given args
+func Factory(path string, args ...string) logical.Factory {
+	return func(conf map[string]string) (logical.Backend, error) {
+		b := &Backend{
+			path:     path,
+			args:     args,
+			conf:     conf,
+			logger:   log.New(os.Stderr, "", log.LstdFlags),
+			storages: make(map[uint64]logical.Storage),
+		}
+		if err := b.start(); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+}
+
+// Backend is a logical.Backend that relays requests to a plugin
+// process. The process is restarted if it exits, and requests fail with
+// ErrUnavailable until it is running again.
+type Backend struct {
+	path   string
+	args   []string
+	conf   map[string]string
+	logger *log.Logger
+
+	l      sync.RWMutex
+	cmd    *exec.Cmd
+	client *rpc.Client
+	paths  *logical.Paths
+	closed bool
+
+	storageLock sync.RWMutex
+	storages    map[uint64]logical.Storage
+	storageID   uint64
+}
+
+// start is used to launch the plugin process and setup the backend
+// within it. It must not be called with the lock held.
+func (b *Backend) start() error {
+	dir, err := ioutil.TempDir("", "vault-plugin")
+	if err != nil {
+		return fmt.Errorf("failed to create plugin socket: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "plugin.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		return fmt.Errorf("failed to create plugin socket: %v", err)
+	}
+	defer ln.Close()
+	ln.(*net.UnixListener).SetDeadline(time.Now().Add(startTimeout))
+
+	cmd := exec.Command(b.path, b.args...)
+	cmd.Env = append(os.Environ(), SocketEnv+"="+sock)
+	cmd.Stdout = &logWriter{backend: b}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to launch plugin '%s': %v", b.path, err)
+	}
+
+	client, err := b.connect(ln)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("failed to connect to plugin '%s': %v", b.path, err)
+	}
+
+	// Create the backend, and fetch its paths which do not change
+	var setup SetupReply
+	var paths logical.Paths
+	err = client.Call("Plugin.Setup", &SetupArgs{Config: b.conf}, &setup)
+	if err == nil {
+		err = decodeError(setup.Error)
+	}
+	if err == nil {
+		err = client.Call("Plugin.SpecialPaths", &Empty{}, &paths)
+	}
+	if err != nil {
+		client.Close()
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("failed to setup plugin '%s': %v", b.path, err)
+	}
+
+	b.l.Lock()
+	if b.closed {
+		b.l.Unlock()
+		client.Close()
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil
+	}
+	b.cmd = cmd
+	b.client = client
+	b.paths = &paths
+	b.l.Unlock()
+
+	go b.wait(cmd)
+	return nil
+}
+
+// connect is used to accept the connections of the plugin
+func (b *Backend) connect(ln net.Listener) (*rpc.Client, error) {
+	reqConn, err := ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+	storageConn, err := ln.Accept()
+	if err != nil {
+		reqConn.Close()
+		return nil, err
+	}
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("Storage", &StorageServer{backend: b}); err != nil {
+		reqConn.Close()
+		storageConn.Close()
+		return nil, err
+	}
+	go server.ServeCodec(jsonrpc.NewServerCodec(storageConn))
+	return jsonrpc.NewClient(reqConn), nil
+}
+
+// wait is used to wait for the plugin process to exit, restarting it
+// unless the backend has been cleaned up
+func (b *Backend) wait(cmd *exec.Cmd) {
+	err := cmd.Wait()
+
+	b.l.Lock()
+	if b.closed || b.cmd != cmd {
+		b.l.Unlock()
+		return
+	}
+	b.client.Close()
+	b.client = nil
+	b.l.Unlock()
+
+	b.getLogger().Printf("[ERR] plugin: '%s' exited: %v", b.path, err)
+	for {
+		time.Sleep(restartInterval)
+
+		b.l.RLock()
+		closed := b.closed
+		b.l.RUnlock()
+		if closed {
+			return
+		}
+
+		if err := b.start(); err != nil {
+			b.getLogger().Printf("[ERR] plugin: failed to restart '%s': %v", b.path, err)
+			continue
+		}
+		b.getLogger().Printf("[INFO] plugin: restarted '%s'", b.path)
+		return
+	}
+}
+
+// logical.Backend impl.
+func (b *Backend) HandleRequest(req *logical.Request) (*logical.Response, error) {
+	b.l.RLock()
+	client := b.client
+	b.l.RUnlock()
+	if client == nil {
+		return nil, ErrUnavailable
+	}
+
+	// Register the storage of the request so the plugin can access it
+	id := atomic.AddUint64(&b.storageID, 1)
+	b.storageLock.Lock()
+	b.storages[id] = req.Storage
+	b.storageLock.Unlock()
+	defer func() {
+		b.storageLock.Lock()
+		delete(b.storages, id)
+		b.storageLock.Unlock()
+	}()
+
+	// Only the remote address of the connection is sent
+	wireReq := *req
+	wireReq.Storage = nil
+	if req.Connection != nil {
+		wireReq.Connection = &logical.Connection{RemoteAddr: req.Connection.RemoteAddr}
+	}
+	args := &HandleRequestArgs{
+		StorageID: id,
+		Request:   &wireReq,
+	}
+	if req.Secret != nil {
+		args.SecretLease = &LeaseTimes{
+			Increment: req.Secret.LeaseIncrement,
+			Issue:     req.Secret.LeaseIssue,
+		}
+	}
+	if req.Auth != nil {
+		args.AuthLease = &LeaseTimes{
+			Increment: req.Auth.LeaseIncrement,
+			Issue:     req.Auth.LeaseIssue,
+		}
+	}
+
+	var reply HandleRequestReply
+	if err := client.Call("Plugin.HandleRequest", args, &reply); err != nil {
+		b.getLogger().Printf("[ERR] plugin: request to '%s' failed: %v", b.path, err)
+		return nil, ErrUnavailable
+	}
+	return reply.Response, decodeError(reply.Error)
+}
+
+// logical.Backend impl.
+func (b *Backend) SpecialPaths() *logical.Paths {
+	b.l.RLock()
+	defer b.l.RUnlock()
+	return b.paths
+}
+
+// logical.Backend impl.
+func (b *Backend) SetLogger(logger *log.Logger) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	b.logger = logger
+}
+
+// getLogger returns the logger of the backend
+func (b *Backend) getLogger() *log.Logger {
+	b.l.RLock()
+	defer b.l.RUnlock()
+	return b.logger
+}
+
+// Cleanup implements logical.CleanupBackend by stopping the plugin
+func (b *Backend) Cleanup() {
+	b.l.Lock()
+	defer b.l.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	if b.client != nil {
+		b.client.Close()
+		b.client = nil
+	}
+	if b.cmd != nil {
+		b.cmd.Process.Kill()
+	}
+}
+
+// StorageServer serves the storage calls made by the plugin process
+type StorageServer struct {
+	backend *Backend
+}
+
+// storage returns the storage of the request with the given ID
+func (s *StorageServer) storage(id uint64) (logical.Storage, error) {
+	s.backend.storageLock.RLock()
+	defer s.backend.storageLock.RUnlock()
+	storage, ok := s.backend.storages[id]
+	if !ok || storage == nil {
+		return nil, fmt.Errorf("no storage for request")
+	}
+	return storage, nil
+}
+
+func (s *StorageServer) List(args *StorageArgs, reply *StorageListReply) error {
+	storage, err := s.storage(args.StorageID)
+	if err != nil {
+		return err
+	}
+	reply.Keys, err = storage.List(args.Key)
+	return err
+}
+
+func (s *StorageServer) Get(args *StorageArgs, reply *StorageGetReply) error {
+	storage, err := s.storage(args.StorageID)
+	if err != nil {
+		return err
+	}
+	reply.Entry, err = storage.Get(args.Key)
+	return err
+}
+
+func (s *StorageServer) Put(args *StorageArgs, reply *Empty) error {
+	storage, err := s.storage(args.StorageID)
+	if err != nil {
+		return err
+	}
+	if args.Entry == nil {
+		return fmt.Errorf("missing entry")
+	}
+	return storage.Put(args.Entry)
+}
+
+func (s *StorageServer) Delete(args *StorageArgs, reply *Empty) error {
+	storage, err := s.storage(args.StorageID)
+	if err != nil {
+		return err
+	}
+	return storage.Delete(args.Key)
+}
+
+// logWriter relays the output of the plugin process to the logger
+type logWriter struct {
+	backend *Backend
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		w.backend.getLogger().Printf("[DEBUG] plugin: %s: %s",
+			filepath.Base(w.backend.path), line)
+	}
+	return len(p), nil
+}
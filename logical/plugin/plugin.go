@@ -0,0 +1,102 @@
+// Package plugin allows logical backends to run as external processes,
+// so that they can be added to Vault without recompiling it.
+//
+// Vault launches the plugin executable with the path of a unix socket in
+// the VAULT_PLUGIN_SOCKET environment variable. The plugin connects to it
+// twice: Vault makes requests to the backend over the first connection,
+// and the backend accesses its storage over the second. Both use JSON-RPC.
+// The main function of a plugin only needs to call Serve with the factory
+// of its backend.
+package plugin
+
+import (
+	"errors"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// SocketEnv is the environment variable holding the path of the socket
+// the plugin must connect to
+const SocketEnv = "VAULT_PLUGIN_SOCKET"
+
+// errorsByMessage is used to restore the errors that are compared by
+// value, since only the message of an error crosses the process boundary
+var errorsByMessage = map[string]error{
+	logical.ErrUnsupportedOperation.Error(): logical.ErrUnsupportedOperation,
+	logical.ErrUnsupportedPath.Error():      logical.ErrUnsupportedPath,
+	logical.ErrInvalidRequest.Error():       logical.ErrInvalidRequest,
+	logical.ErrPermissionDenied.Error():     logical.ErrPermissionDenied,
+}
+
+// encodeError returns the message of an error, which is empty if nil
+func encodeError(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// decodeError restores an error from its message
+func decodeError(msg string) error {
+	if msg == "" {
+		return nil
+	}
+	if err, ok := errorsByMessage[msg]; ok {
+		return err
+	}
+	return errors.New(msg)
+}
+
+// Empty is used for the arguments and replies of calls that have none
+type Empty struct{}
+
+// SetupArgs are the arguments to create the backend in the plugin
+type SetupArgs struct {
+	Config map[string]string
+}
+
+// SetupReply is the reply to creating the backend in the plugin
+type SetupReply struct {
+	Error string
+}
+
+// LeaseTimes carries the lease fields of a renewal that are not
+// encoded as JSON
+type LeaseTimes struct {
+	Increment time.Duration
+	Issue     time.Time
+}
+
+// HandleRequestArgs are the arguments to handle a request. The storage
+// of the request is identified by the StorageID, and the connection
+// state is not sent.
+type HandleRequestArgs struct {
+	StorageID   uint64
+	Request     *logical.Request
+	SecretLease *LeaseTimes
+	AuthLease   *LeaseTimes
+}
+
+// HandleRequestReply is the reply to handling a request
+type HandleRequestReply struct {
+	Response *logical.Response
+	Error    string
+}
+
+// StorageArgs are the arguments of storage calls
+type StorageArgs struct {
+	StorageID uint64
+	Key       string
+	Entry     *logical.StorageEntry
+}
+
+// StorageGetReply is the reply to a storage Get
+type StorageGetReply struct {
+	Entry *logical.StorageEntry
+}
+
+// StorageListReply is the reply to a storage List
+type StorageListReply struct {
+	Keys []string
+}
@@ -0,0 +1,193 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// TestHelperProcess is not a real test. It is launched as the plugin
+// process by the tests, serving testBackend.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv(SocketEnv) == "" {
+		return
+	}
+	if err := Serve(testBackendFactory); err != nil {
+		fmt.Fprintf(os.Stderr, "err: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// testBackendFactory creates a backend that stores the "value" of a
+// write and returns it when read
+func testBackendFactory(conf map[string]string) (logical.Backend, error) {
+	if conf["fail"] != "" {
+		return nil, fmt.Errorf("failed by config")
+	}
+	return &framework.Backend{
+		PathsSpecial: &logical.Paths{
+			Root: []string{"root/*"},
+		},
+		Paths: []*framework.Path{
+			&framework.Path{
+				Pattern: "kv/(?P<key>.+)",
+				Fields: map[string]*framework.FieldSchema{
+					"key":   &framework.FieldSchema{Type: framework.TypeString},
+					"value": &framework.FieldSchema{Type: framework.TypeString},
+				},
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ReadOperation: func(
+						req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+						entry, err := req.Storage.Get(d.Get("key").(string))
+						if err != nil || entry == nil {
+							return nil, err
+						}
+						return &logical.Response{
+							Data: map[string]interface{}{"value": string(entry.Value)},
+						}, nil
+					},
+					logical.WriteOperation: func(
+						req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+						return nil, req.Storage.Put(&logical.StorageEntry{
+							Key:   d.Get("key").(string),
+							Value: []byte(d.Get("value").(string)),
+						})
+					},
+				},
+			},
+			&framework.Path{
+				Pattern: "exit",
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.WriteOperation: func(
+						*logical.Request, *framework.FieldData) (*logical.Response, error) {
+						os.Exit(1)
+						return nil, nil
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+func testFactory() logical.Factory {
+	return Factory(os.Args[0], "-test.run=TestHelperProcess")
+}
+
+func TestBackend(t *testing.T) {
+	raw, err := testFactory()(nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	b := raw.(*Backend)
+	defer b.Cleanup()
+
+	expected := &logical.Paths{Root: []string{"root/*"}}
+	if paths := b.SpecialPaths(); !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("bad: %#v", paths)
+	}
+
+	// Writes are stored in the storage of the request
+	storage := new(logical.InmemStorage)
+	req := &logical.Request{
+		Operation: logical.WriteOperation,
+		Path:      "kv/foo",
+		Data:      map[string]interface{}{"value": "bar"},
+		Storage:   storage,
+	}
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	entry, err := storage.Get("foo")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if entry == nil || string(entry.Value) != "bar" {
+		t.Fatalf("bad: %#v", entry)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "kv/foo",
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Data["value"] != "bar" {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// Errors compared by value are preserved
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "unknown",
+		Storage:   storage,
+	}
+	if _, err := b.HandleRequest(req); err != logical.ErrUnsupportedPath {
+		t.Fatalf("err: %v", err)
+	}
+
+	// After cleanup, requests fail
+	b.Cleanup()
+	if _, err := b.HandleRequest(req); err != ErrUnavailable {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestBackend_Restart(t *testing.T) {
+	old := restartInterval
+	restartInterval = 10 * time.Millisecond
+	defer func() {
+		restartInterval = old
+	}()
+
+	raw, err := testFactory()(nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	b := raw.(*Backend)
+	defer b.Cleanup()
+
+	// Crash the plugin, which fails the request
+	storage := new(logical.InmemStorage)
+	req := &logical.Request{
+		Operation: logical.WriteOperation,
+		Path:      "exit",
+		Storage:   storage,
+	}
+	if _, err := b.HandleRequest(req); err != ErrUnavailable {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The plugin is restarted
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "kv/foo",
+		Storage:   storage,
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		_, err := b.HandleRequest(req)
+		if err == nil {
+			break
+		}
+		if err != ErrUnavailable || time.Now().After(deadline) {
+			t.Fatalf("err: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFactory_SetupError(t *testing.T) {
+	_, err := testFactory()(map[string]string{"fail": "true"})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+}
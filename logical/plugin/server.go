@@ -0,0 +1,148 @@
+package plugin
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"sync"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// Serve is called by the main function of a plugin executable to serve
+// the backend created by the factory to Vault. It blocks until Vault
+// closes the connection.
+func Serve(factory logical.Factory) error {
+	sock := os.Getenv(SocketEnv)
+	if sock == "" {
+		return fmt.Errorf("%s is not set, plugins must be launched by Vault", SocketEnv)
+	}
+
+	// The order of the connections is significant, the first is used
+	// for requests and the second for storage
+	reqConn, err := net.Dial("unix", sock)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Vault: %v", err)
+	}
+	defer reqConn.Close()
+	storageConn, err := net.Dial("unix", sock)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Vault: %v", err)
+	}
+	storage := jsonrpc.NewClient(storageConn)
+	defer storage.Close()
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("Plugin", &BackendServer{
+		factory: factory,
+		storage: storage,
+	}); err != nil {
+		return err
+	}
+	server.ServeCodec(jsonrpc.NewServerCodec(reqConn))
+	return nil
+}
+
+// BackendServer serves the calls made by Vault to the backend within
+// the plugin process
+type BackendServer struct {
+	factory logical.Factory
+	storage *rpc.Client
+
+	l       sync.RWMutex
+	backend logical.Backend
+}
+
+// Setup is used to create the backend with the mount configuration
+func (s *BackendServer) Setup(args *SetupArgs, reply *SetupReply) error {
+	backend, err := s.factory(args.Config)
+	if err != nil {
+		reply.Error = err.Error()
+		return nil
+	}
+	backend.SetLogger(log.New(os.Stderr, "", log.LstdFlags))
+
+	s.l.Lock()
+	s.backend = backend
+	s.l.Unlock()
+	return nil
+}
+
+// SpecialPaths returns the special paths of the backend
+func (s *BackendServer) SpecialPaths(args *Empty, reply *logical.Paths) error {
+	backend, err := s.getBackend()
+	if err != nil {
+		return err
+	}
+	if paths := backend.SpecialPaths(); paths != nil {
+		*reply = *paths
+	}
+	return nil
+}
+
+// HandleRequest is used to handle a request using the backend
+func (s *BackendServer) HandleRequest(args *HandleRequestArgs, reply *HandleRequestReply) error {
+	backend, err := s.getBackend()
+	if err != nil {
+		return err
+	}
+
+	req := args.Request
+	req.Storage = &storageClient{
+		client: s.storage,
+		id:     args.StorageID,
+	}
+	if args.SecretLease != nil && req.Secret != nil {
+		req.Secret.LeaseIncrement = args.SecretLease.Increment
+		req.Secret.LeaseIssue = args.SecretLease.Issue
+	}
+	if args.AuthLease != nil && req.Auth != nil {
+		req.Auth.LeaseIncrement = args.AuthLease.Increment
+		req.Auth.LeaseIssue = args.AuthLease.Issue
+	}
+
+	resp, err := backend.HandleRequest(req)
+	reply.Response = resp
+	reply.Error = encodeError(err)
+	return nil
+}
+
+// getBackend returns the backend, which must have been setup
+func (s *BackendServer) getBackend() (logical.Backend, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	if s.backend == nil {
+		return nil, fmt.Errorf("backend is not setup")
+	}
+	return s.backend, nil
+}
+
+// storageClient implements logical.Storage by calling back to Vault
+// for the storage of a request
+type storageClient struct {
+	client *rpc.Client
+	id     uint64
+}
+
+func (s *storageClient) List(prefix string) ([]string, error) {
+	var reply StorageListReply
+	err := s.client.Call("Storage.List", &StorageArgs{StorageID: s.id, Key: prefix}, &reply)
+	return reply.Keys, err
+}
+
+func (s *storageClient) Get(key string) (*logical.StorageEntry, error) {
+	var reply StorageGetReply
+	err := s.client.Call("Storage.Get", &StorageArgs{StorageID: s.id, Key: key}, &reply)
+	return reply.Entry, err
+}
+
+func (s *storageClient) Put(entry *logical.StorageEntry) error {
+	return s.client.Call("Storage.Put", &StorageArgs{StorageID: s.id, Entry: entry}, &Empty{})
+}
+
+func (s *storageClient) Delete(key string) error {
+	return s.client.Call("Storage.Delete", &StorageArgs{StorageID: s.id, Key: key}, &Empty{})
+}
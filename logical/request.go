@@ -3,12 +3,19 @@ package logical
 import (
 	"errors"
 	"fmt"
+	"time"
 )
 
 // Request is a struct that stores the parameters and context
 // of a request being made to Vault. It is used to abstract
 // the details of the higher level request protocol from the handlers.
 type Request struct {
+	// ID is a unique identifier assigned to the request by the core when
+	// it is received. It is included in the audit entries and the log
+	// lines of the request, and returned to the client, so that they
+	// can be correlated.
+	ID string
+
 	// Operation is the requested operation type
 	Operation Operation
 
@@ -47,6 +54,38 @@ type Request struct {
 	// dynamic secrets with the source entity. This is not a sensitive
 	// name, but is useful for operators.
 	DisplayName string
+
+	// IdempotencyKey is an optional key provided by the client so that
+	// a retried write can be de-duplicated. It is only honored on paths
+	// that the backend has marked as idempotent.
+	IdempotencyKey string
+
+	// BreakGlass requests that the ACL be overridden for this request in
+	// an emergency. It is only honored if the token has the break-glass
+	// capability for the path, and every request using it is audited as
+	// such. The core clears it if the ACL permits the request anyway.
+	BreakGlass bool
+
+	// WrapTTL requests that the response be wrapped if it is set. The
+	// core stores the response in the cubbyhole of a new single-use
+	// token with this TTL, and only returns the token, so that the
+	// response can be handed to another party who retrieves it once.
+	WrapTTL time.Duration
+
+	// CAS is the version the secret is expected to have for the write to
+	// succeed, for backends that support check-and-set writes, or nil if
+	// the write is unconditional. It is given separately from the data
+	// so that it never collides with the fields of a secret.
+	CAS *int
+
+	// Cancel is closed if the request is canceled, such as when a read takes
+	// longer than the request timeout. The client has already been sent
+	// an error, so backends making slow calls should give up when it is
+	// closed. Once it is closed, the storage of the request returns
+	// ErrRequestCanceled, but a storage call that is already waiting on
+	// the physical backend is not interrupted. It is nil if the request
+	// cannot be canceled, and is not sent to plugins.
+	Cancel <-chan struct{} `json:"-"`
 }
 
 // Get returns a data field and guards for nil Data
@@ -122,6 +161,10 @@ const (
 	ListOperation             = "list"
 	HelpOperation             = "help"
 
+	// ExistenceCheckOperation checks if a path exists without reading
+	// its value. It requires the same permissions as a read.
+	ExistenceCheckOperation = "existence-check"
+
 	// The operations below are called globally, the path is less relevant.
 	RevokeOperation   Operation = "revoke"
 	RenewOperation              = "renew"
@@ -142,4 +185,8 @@ var (
 
 	// ErrPermissionDeneid is returned if the client is not authorized
 	ErrPermissionDenied = errors.New("permission denied")
+
+	// ErrRequestCanceled is returned if the request was canceled before
+	// it completed
+	ErrRequestCanceled = errors.New("request canceled")
 )
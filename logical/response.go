@@ -1,5 +1,7 @@
 package logical
 
+import "time"
+
 // Response is a struct that stores the response of a request.
 // It is used to abstract the details of the higher level request protocol.
 type Response struct {
@@ -21,6 +23,23 @@ type Response struct {
 	// This is only valid for credential backends. This will be blanked
 	// for any logical backend and ignored.
 	Redirect string
+
+	// WrapInfo, if not nil, means that the response was wrapped. It is
+	// set by the core in place of the rest of the response, which can
+	// be retrieved once with the wrapping token.
+	WrapInfo *WrapInfo
+}
+
+// WrapInfo describes the token a response was wrapped with
+type WrapInfo struct {
+	// Token is the single-use token to unwrap the response with
+	Token string
+
+	// TTL is the lifetime of the token, after which the response is lost
+	TTL time.Duration
+
+	// CreationTime is when the response was wrapped
+	CreationTime time.Time
 }
 
 // IsError returns true if this response seems to indicate an error.
@@ -3,6 +3,7 @@ package logical
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 )
 
 // Storage is the way that logical backends are able read/write data.
@@ -13,6 +14,31 @@ type Storage interface {
 	Delete(string) error
 }
 
+// StreamStorage is an optional interface implemented by a Storage that
+// can read and write large values without buffering them in memory.
+// Only the storage itself avoids buffering, so memory stays bounded only
+// if the caller also streams the value rather than holding it whole.
+// Values written with PutStream can still be read with Get.
+type StreamStorage interface {
+	Storage
+
+	// GetStream returns a reader for the value, or nil if the key
+	// does not exist. The reader must be closed when done.
+	GetStream(string) (io.ReadCloser, error)
+
+	// PutStream writes the value read from the reader
+	PutStream(string, io.Reader) error
+}
+
+// ExistenceStorage is an optional interface implemented by a Storage
+// that can check if a key exists without reading its value.
+type ExistenceStorage interface {
+	Storage
+
+	// Exists returns true if the key exists
+	Exists(string) (bool, error)
+}
+
 // StorageEntry is the entry for an item in a Storage implementation.
 type StorageEntry struct {
 	Key   string
@@ -1,7 +1,11 @@
 package physical
 
 import (
+	"bytes"
+	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/hashicorp/golang-lru"
 )
@@ -15,9 +19,26 @@ const (
 // and provide an LRU cache layer on top. Most of the reads done by
 // Vault are for policy objects so there is a large read reduction
 // by using a simple write-through cache.
+//
+// The cache is invalidated for a key whenever a write of the key fails,
+// since the backend may have applied it anyway, so that the next read
+// returns what the backend actually holds.
 type Cache struct {
+	// hits, misses and evictions count the reads served from the cache,
+	// the reads passed to the backend, and the entries evicted to make
+	// room for others. They are first to be aligned for atomic access.
+	hits      uint64
+	misses    uint64
+	evictions uint64
+
 	backend Backend
 	lru     *lru.Cache
+	size    int
+
+	// verifyPrefixes are the prefixes of the keys whose writes are
+	// read back from the backend before they are cached
+	verifyLock     sync.RWMutex
+	verifyPrefixes []string
 }
 
 // NewCache returns a physical cache of the given size.
@@ -30,24 +51,118 @@ func NewCache(b Backend, size int) *Cache {
 	c := &Cache{
 		backend: b,
 		lru:     cache,
+		size:    size,
 	}
 	return c
 }
 
+// CacheStats describes the use of the cache since it was created
+type CacheStats struct {
+	Size      int // Number of entries in the cache
+	Capacity  int // Maximum number of entries in the cache
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// HitRatio returns the fraction of reads served from the cache, or zero
+// if there were no reads
+func (s CacheStats) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// Stats returns the use of the cache. Purges do not reset the counts.
+func (c *Cache) Stats() CacheStats {
+	return CacheStats{
+		Size:      c.lru.Len(),
+		Capacity:  c.size,
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+	}
+}
+
+// add is used to cache an entry, counting the eviction of another
+func (c *Cache) add(key string, entry *Entry) {
+	if c.lru.Add(key, entry) {
+		atomic.AddUint64(&c.evictions, 1)
+	}
+}
+
 // Purge is used to clear the cache
 func (c *Cache) Purge() {
 	c.lru.Purge()
 }
 
+// SetVerifyPrefixes sets the prefixes of critical keys, whose writes
+// are read back from the backend to verify them before they are cached.
+// This costs a read for every write of the keys, but ensures the cache
+// never holds a value the backend did not store.
+func (c *Cache) SetVerifyPrefixes(prefixes []string) {
+	c.verifyLock.Lock()
+	defer c.verifyLock.Unlock()
+	c.verifyPrefixes = append([]string(nil), prefixes...)
+}
+
+// shouldVerify checks if writes of the key must be verified
+func (c *Cache) shouldVerify(key string) bool {
+	c.verifyLock.RLock()
+	defer c.verifyLock.RUnlock()
+	for _, prefix := range c.verifyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// verify reads the key back from the backend to check that it holds
+// the expected entry, or no entry if expected is nil. The entry read
+// is returned so that it can be cached.
+func (c *Cache) verify(key string, expected *Entry) (*Entry, error) {
+	ent, err := c.backend.Get(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify write of '%s': %v", key, err)
+	}
+	switch {
+	case expected == nil && ent != nil:
+		return nil, fmt.Errorf("failed to verify delete of '%s': key still exists", key)
+	case expected != nil && (ent == nil || !bytes.Equal(ent.Value, expected.Value)):
+		return nil, fmt.Errorf("failed to verify write of '%s': value does not match", key)
+	}
+	return ent, nil
+}
+
 func (c *Cache) Put(entry *Entry) error {
-	err := c.backend.Put(entry)
-	c.lru.Add(entry.Key, entry)
-	return err
+	// Invalidate first, so the old value is not served if the write
+	// fails after it was partially applied
+	c.lru.Remove(entry.Key)
+	if err := c.backend.Put(entry); err != nil {
+		c.lru.Remove(entry.Key)
+		return err
+	}
+
+	// Cache the entry read back from critical keys
+	if c.shouldVerify(entry.Key) {
+		ent, err := c.verify(entry.Key, entry)
+		if err != nil {
+			c.lru.Remove(entry.Key)
+			return err
+		}
+		entry = ent
+	}
+	c.add(entry.Key, entry)
+	return nil
 }
 
 func (c *Cache) Get(key string) (*Entry, error) {
 	// Check the LRU first
 	if raw, ok := c.lru.Get(key); ok {
+		atomic.AddUint64(&c.hits, 1)
 		if raw == nil {
 			return nil, nil
 		} else {
@@ -56,6 +171,7 @@ func (c *Cache) Get(key string) (*Entry, error) {
 	}
 
 	// Read from the underlying backend
+	atomic.AddUint64(&c.misses, 1)
 	ent, err := c.backend.Get(key)
 	if err != nil {
 		return nil, err
@@ -67,18 +183,90 @@ func (c *Cache) Get(key string) (*Entry, error) {
 	// we could potentially negatively cache the leader entry and cause
 	// leader discovery to fail.
 	if ent != nil || !strings.HasPrefix(key, "core/") {
-		c.lru.Add(key, ent)
+		c.add(key, ent)
 	}
 	return ent, err
 }
 
 func (c *Cache) Delete(key string) error {
+	c.lru.Remove(key)
 	err := c.backend.Delete(key)
 	c.lru.Remove(key)
-	return err
+	if err != nil {
+		return err
+	}
+
+	// Ensure critical keys are gone
+	if c.shouldVerify(key) {
+		if _, err := c.verify(key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Transaction applies the operations to the underlying backend, which
+// is atomic only if that backend implements Transactional
+func (c *Cache) Transaction(txns []*TxnEntry) error {
+	// Invalidate first, since some of the operations may be applied
+	// even if the transaction fails
+	for _, txn := range txns {
+		if txn != nil && txn.Entry != nil {
+			c.lru.Remove(txn.Entry.Key)
+		}
+	}
+	if err := Transaction(c.backend, txns); err != nil {
+		for _, txn := range txns {
+			if txn != nil && txn.Entry != nil {
+				c.lru.Remove(txn.Entry.Key)
+			}
+		}
+		return err
+	}
+
+	// Only the last operation on each key determines its value
+	last := make(map[string]*TxnEntry)
+	for _, txn := range txns {
+		last[txn.Entry.Key] = txn
+	}
+
+	// Verify the critical keys before caching anything, the others are
+	// left invalidated if one cannot be verified
+	entries := make(map[string]*Entry)
+	for key, txn := range last {
+		var expected *Entry
+		if txn.Operation == PutOperation {
+			expected = txn.Entry
+		}
+		if !c.shouldVerify(key) {
+			entries[key] = expected
+			continue
+		}
+		ent, err := c.verify(key, expected)
+		if err != nil {
+			return err
+		}
+		entries[key] = ent
+	}
+
+	for key, ent := range entries {
+		if ent != nil {
+			c.add(key, ent)
+		}
+	}
+	return nil
 }
 
 func (c *Cache) List(prefix string) ([]string, error) {
 	// Always pass-through as this would be difficult to cache.
 	return c.backend.List(prefix)
 }
+
+// HealthCheck checks the health of the underlying backend, if it
+// implements HealthChecker
+func (c *Cache) HealthCheck() error {
+	if hc, ok := c.backend.(HealthChecker); ok {
+		return hc.HealthCheck()
+	}
+	return nil
+}
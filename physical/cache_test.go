@@ -1,6 +1,9 @@
 package physical
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 func TestCache(t *testing.T) {
 	inm := NewInmem()
@@ -46,3 +49,205 @@ func TestCache_Purge(t *testing.T) {
 		t.Fatalf("should not have key")
 	}
 }
+
+// faultyBackend fails writes after optionally applying them, or drops
+// them silently, to simulate a storage hiccup
+type faultyBackend struct {
+	Backend
+	fail  bool // Writes return an error
+	apply bool // Failed writes are applied anyway
+	drop  bool // Writes are silently dropped
+}
+
+func (f *faultyBackend) Put(entry *Entry) error {
+	if f.drop {
+		return nil
+	}
+	if f.fail && !f.apply {
+		return errors.New("put failed")
+	}
+	if err := f.Backend.Put(entry); err != nil {
+		return err
+	}
+	if f.fail {
+		return errors.New("put failed after commit")
+	}
+	return nil
+}
+
+func (f *faultyBackend) Delete(key string) error {
+	if f.drop {
+		return nil
+	}
+	if f.fail && !f.apply {
+		return errors.New("delete failed")
+	}
+	if err := f.Backend.Delete(key); err != nil {
+		return err
+	}
+	if f.fail {
+		return errors.New("delete failed after commit")
+	}
+	return nil
+}
+
+// testCacheValue checks the value read through the cache
+func testCacheValue(t *testing.T, cache *Cache, key, expected string) {
+	out, err := cache.Get(key)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	switch {
+	case expected == "" && out != nil:
+		t.Fatalf("bad %s: %q", key, out.Value)
+	case expected != "" && (out == nil || string(out.Value) != expected):
+		t.Fatalf("bad %s: %#v", key, out)
+	}
+}
+
+func TestCache_PutFailure(t *testing.T) {
+	for _, apply := range []bool{false, true} {
+		faulty := &faultyBackend{Backend: NewInmem()}
+		cache := NewCache(faulty, 0)
+		if err := cache.Put(&Entry{Key: "foo", Value: []byte("old")}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		testCacheValue(t, cache, "foo", "old")
+
+		// The cache must return what the backend holds after a failure
+		faulty.fail, faulty.apply = true, apply
+		if err := cache.Put(&Entry{Key: "foo", Value: []byte("new")}); err == nil {
+			t.Fatalf("should fail")
+		}
+		if apply {
+			testCacheValue(t, cache, "foo", "new")
+		} else {
+			testCacheValue(t, cache, "foo", "old")
+		}
+	}
+}
+
+func TestCache_DeleteFailure(t *testing.T) {
+	for _, apply := range []bool{false, true} {
+		faulty := &faultyBackend{Backend: NewInmem()}
+		cache := NewCache(faulty, 0)
+		if err := cache.Put(&Entry{Key: "foo", Value: []byte("old")}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		faulty.fail, faulty.apply = true, apply
+		if err := cache.Delete("foo"); err == nil {
+			t.Fatalf("should fail")
+		}
+		if apply {
+			testCacheValue(t, cache, "foo", "")
+		} else {
+			testCacheValue(t, cache, "foo", "old")
+		}
+	}
+}
+
+func TestCache_Verify(t *testing.T) {
+	faulty := &faultyBackend{Backend: NewInmem()}
+	cache := NewCache(faulty, 0)
+	cache.SetVerifyPrefixes([]string{"core/"})
+	if err := cache.Put(&Entry{Key: "core/foo", Value: []byte("old")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testCacheValue(t, cache, "core/foo", "old")
+
+	// A dropped write of a critical key is detected and not cached
+	faulty.drop = true
+	if err := cache.Put(&Entry{Key: "core/foo", Value: []byte("new")}); err == nil {
+		t.Fatalf("should fail")
+	}
+	testCacheValue(t, cache, "core/foo", "old")
+	if err := cache.Delete("core/foo"); err == nil {
+		t.Fatalf("should fail")
+	}
+	testCacheValue(t, cache, "core/foo", "old")
+
+	// Other keys are not verified
+	if err := cache.Put(&Entry{Key: "bar", Value: []byte("bar")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Verified writes are cached once they succeed
+	faulty.drop = false
+	if err := cache.Put(&Entry{Key: "core/foo", Value: []byte("new")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testCacheValue(t, cache, "core/foo", "new")
+	if err := cache.Delete("core/foo"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testCacheValue(t, cache, "core/foo", "")
+}
+
+func TestCache_VerifyTransaction(t *testing.T) {
+	faulty := &faultyBackend{Backend: NewInmem()}
+	cache := NewCache(&sequentialBackend{faulty}, 0)
+	cache.SetVerifyPrefixes([]string{"core/"})
+	for _, key := range []string{"core/foo", "bar"} {
+		if err := cache.Put(&Entry{Key: key, Value: []byte("old")}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// Nothing in the transaction is cached if a critical key is dropped
+	faulty.drop = true
+	txns := []*TxnEntry{
+		&TxnEntry{
+			Operation: PutOperation,
+			Entry:     &Entry{Key: "bar", Value: []byte("new")},
+		},
+		&TxnEntry{
+			Operation: PutOperation,
+			Entry:     &Entry{Key: "core/foo", Value: []byte("new")},
+		},
+	}
+	if err := cache.Transaction(txns); err == nil {
+		t.Fatalf("should fail")
+	}
+	testCacheValue(t, cache, "core/foo", "old")
+	testCacheValue(t, cache, "bar", "old")
+}
+
+func TestCache_Stats(t *testing.T) {
+	cache := NewCache(NewInmem(), 2)
+	for _, key := range []string{"foo", "bar", "baz"} {
+		if err := cache.Put(&Entry{Key: key, Value: []byte(key)}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// "foo" was evicted, so it is read from the backend and evicts "bar"
+	for _, key := range []string{"baz", "foo", "foo"} {
+		testCacheValue(t, cache, key, key)
+	}
+
+	expected := CacheStats{
+		Size:      2,
+		Capacity:  2,
+		Hits:      2,
+		Misses:    1,
+		Evictions: 2,
+	}
+	stats := cache.Stats()
+	if stats != expected {
+		t.Fatalf("bad: %#v", stats)
+	}
+	if ratio := stats.HitRatio(); ratio < 0.66 || ratio > 0.67 {
+		t.Fatalf("bad: %v", ratio)
+	}
+
+	// Purging does not reset the counts
+	cache.Purge()
+	stats = cache.Stats()
+	if stats.Size != 0 || stats.Hits != 2 {
+		t.Fatalf("bad: %#v", stats)
+	}
+	if ratio := (CacheStats{}).HitRatio(); ratio != 0 {
+		t.Fatalf("bad: %v", ratio)
+	}
+}
@@ -92,6 +92,57 @@ func (c *ConsulBackend) Get(key string) (*Entry, error) {
 	return ent, nil
 }
 
+// consulMaxTxnOps is the most operations Consul applies in one
+// transaction
+const consulMaxTxnOps = 64
+
+// consulTxnOp is an operation of a Consul transaction
+type consulTxnOp struct {
+	KV *consulKVTxnOp
+}
+
+// consulKVTxnOp is an operation on the KV store in a Consul transaction
+type consulKVTxnOp struct {
+	Verb  string
+	Key   string
+	Value []byte `json:",omitempty"`
+}
+
+// Transaction is used to apply the operations atomically using the
+// transaction endpoint of Consul. A transaction larger than Consul allows
+// is applied in order, in groups that are each atomic.
+func (c *ConsulBackend) Transaction(txns []*TxnEntry) error {
+	defer metrics.MeasureSince([]string{"consul", "transaction"}, time.Now())
+	if err := validateTxns(txns); err != nil {
+		return err
+	}
+
+	ops := make([]*consulTxnOp, 0, len(txns))
+	for _, txn := range txns {
+		op := &consulKVTxnOp{Key: c.path + txn.Entry.Key}
+		switch txn.Operation {
+		case PutOperation:
+			op.Verb = "set"
+			op.Value = txn.Entry.Value
+		case DeleteOperation:
+			op.Verb = "delete"
+		}
+		ops = append(ops, &consulTxnOp{KV: op})
+	}
+
+	for len(ops) > 0 {
+		n := consulMaxTxnOps
+		if n > len(ops) {
+			n = len(ops)
+		}
+		if _, err := c.client.Raw().Write("/v1/txn", ops[:n], nil, nil); err != nil {
+			return fmt.Errorf("transaction failed: %v", err)
+		}
+		ops = ops[n:]
+	}
+	return nil
+}
+
 // Delete is used to permanently delete an entry
 func (c *ConsulBackend) Delete(key string) error {
 	defer metrics.MeasureSince([]string{"consul", "delete"}, time.Now())
@@ -112,6 +163,18 @@ func (c *ConsulBackend) List(prefix string) ([]string, error) {
 	return out, err
 }
 
+// HealthCheck is used to check that the Consul cluster has a leader
+func (c *ConsulBackend) HealthCheck() error {
+	leader, err := c.client.Status().Leader()
+	if err != nil {
+		return err
+	}
+	if leader == "" {
+		return fmt.Errorf("consul cluster has no leader")
+	}
+	return nil
+}
+
 // Lock is used for mutual exclusion based on the given key.
 func (c *ConsulBackend) LockWith(key, value string) (Lock, error) {
 	// Create the lock
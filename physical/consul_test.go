@@ -1,8 +1,12 @@
 package physical
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -44,3 +48,53 @@ func TestConsulBackend(t *testing.T) {
 	}
 	testHABackend(t, ha, ha)
 }
+
+func TestConsulBackend_Transaction(t *testing.T) {
+	// Record the operations of each transaction sent to Consul
+	var txns [][]*consulTxnOp
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.URL.Path != "/v1/txn" {
+			t.Fatalf("bad: %s %s", r.Method, r.URL.Path)
+		}
+		var ops []*consulTxnOp
+		if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		txns = append(txns, ops)
+		w.Write([]byte(`{"Results":[],"Errors":null}`))
+	}))
+	defer server.Close()
+
+	b, err := NewBackend("consul", map[string]string{
+		"address": strings.TrimPrefix(server.URL, "http://"),
+		"path":    "vault",
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// A transaction larger than Consul allows is sent in groups
+	var entries []*TxnEntry
+	for i := 0; i < consulMaxTxnOps+1; i++ {
+		entries = append(entries, &TxnEntry{
+			Operation: DeleteOperation,
+			Entry:     &Entry{Key: fmt.Sprintf("foo/%d", i)},
+		})
+	}
+	entries = append(entries, &TxnEntry{
+		Operation: PutOperation,
+		Entry:     &Entry{Key: "bar", Value: []byte("bar")},
+	})
+	if err := Transaction(b, entries); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(txns) != 2 || len(txns[0]) != consulMaxTxnOps || len(txns[1]) != 2 {
+		t.Fatalf("bad: %d", len(txns))
+	}
+	if op := txns[0][0].KV; op.Verb != "delete" || op.Key != "vault/foo/0" {
+		t.Fatalf("bad: %#v", op)
+	}
+	if op := txns[1][1].KV; op.Verb != "set" || op.Key != "vault/bar" || string(op.Value) != "bar" {
+		t.Fatalf("bad: %#v", op)
+	}
+}
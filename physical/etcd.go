@@ -0,0 +1,458 @@
+package physical
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+)
+
+const (
+	// etcdNodeFilePrefix is prefixed to the name of the node that
+	// stores an entry. etcd does not allow a node to be both a value
+	// and a directory, so entries are kept apart from directories,
+	// allowing "foo" and "foo/bar" to both exist.
+	etcdNodeFilePrefix = "."
+
+	// etcdNodeLockPrefix is prefixed to the name of the node of a lock
+	etcdNodeLockPrefix = "_"
+
+	// etcd error codes, see the etcd v2 API documentation
+	etcdErrorKeyNotFound       = 100
+	etcdErrorCompareFailed     = 101
+	etcdErrorNodeExist         = 105
+	etcdErrorDirNotEmpty       = 108
+	etcdErrorEventIndexCleared = 401
+)
+
+// These are variables so that tests can shorten them
+var (
+	// etcdLockTTL is the TTL of a held lock. The lock is released by
+	// etcd if it is not renewed, such as when the holder dies.
+	etcdLockTTL = 15 * time.Second
+
+	// etcdLockRenewInterval is the interval at which a held lock is
+	// renewed. Leadership is given up once the lock has not been renewed
+	// for the TTL less this interval, before etcd can release the lock.
+	etcdLockRenewInterval = 5 * time.Second
+
+	// etcdLockRetryInterval is the interval at which a lock acquisition
+	// is retried after an error
+	etcdLockRetryInterval = time.Second
+
+	// etcdRequestTimeout is the timeout of a request, other than a watch.
+	// It is shorter than the renew interval, so that a hung renewal
+	// cannot outlive the lock.
+	etcdRequestTimeout = 4 * time.Second
+)
+
+// EtcdBackend is a physical backend that stores data at a specific
+// prefix within etcd, using the v2 keys API. It supports HA, using a
+// key with a TTL as the lock.
+type EtcdBackend struct {
+	path    string
+	address string
+
+	// client is used for requests, and watchClient for watches, which
+	// are long-polls that are cancelled rather than timed out
+	client      *http.Client
+	watchClient *http.Client
+}
+
+// newEtcdBackend constructs an etcd backend using the address of an
+// etcd member and the prefix of the keys.
+func newEtcdBackend(conf map[string]string) (Backend, error) {
+	// Get the path in etcd
+	path, ok := conf["path"]
+	if !ok {
+		path = "/vault"
+	}
+
+	// Ensure path is prefixed but not suffixed
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	path = strings.TrimSuffix(path, "/")
+
+	address, ok := conf["address"]
+	if !ok {
+		address = "http://127.0.0.1:2379"
+	}
+	if _, err := url.Parse(address); err != nil {
+		return nil, fmt.Errorf("invalid address: %v", err)
+	}
+
+	e := &EtcdBackend{
+		path:        path,
+		address:     strings.TrimSuffix(address, "/"),
+		client:      &http.Client{Timeout: etcdRequestTimeout},
+		watchClient: &http.Client{},
+	}
+	return e, nil
+}
+
+// Put is used to insert or update an entry
+func (e *EtcdBackend) Put(entry *Entry) error {
+	defer metrics.MeasureSince([]string{"etcd", "put"}, time.Now())
+	value := base64.StdEncoding.EncodeToString(entry.Value)
+	_, err := e.request("PUT", e.nodePath(entry.Key, etcdNodeFilePrefix),
+		nil, url.Values{"value": {value}})
+	return err
+}
+
+// Get is used to fetch an entry
+func (e *EtcdBackend) Get(key string) (*Entry, error) {
+	defer metrics.MeasureSince([]string{"etcd", "get"}, time.Now())
+	resp, err := e.request("GET", e.nodePath(key, etcdNodeFilePrefix), nil, nil)
+	if isEtcdError(err, etcdErrorKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := base64.StdEncoding.DecodeString(resp.Node.Value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode value of '%s': %v", key, err)
+	}
+	ent := &Entry{
+		Key:   key,
+		Value: value,
+	}
+	return ent, nil
+}
+
+// Delete is used to permanently delete an entry
+func (e *EtcdBackend) Delete(key string) error {
+	defer metrics.MeasureSince([]string{"etcd", "delete"}, time.Now())
+	_, err := e.request("DELETE", e.nodePath(key, etcdNodeFilePrefix), nil, nil)
+	if isEtcdError(err, etcdErrorKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Remove the directories left empty, so that they are not listed
+	dir := path.Dir(e.nodePath(key, ""))
+	for dir != e.path && dir != "/" {
+		_, err := e.request("DELETE", dir, url.Values{"dir": {"true"}}, nil)
+		if isEtcdError(err, etcdErrorDirNotEmpty) || isEtcdError(err, etcdErrorKeyNotFound) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		dir = path.Dir(dir)
+	}
+	return nil
+}
+
+// List is used ot list all the keys under a given
+// prefix, up to the next prefix.
+func (e *EtcdBackend) List(prefix string) ([]string, error) {
+	defer metrics.MeasureSince([]string{"etcd", "list"}, time.Now())
+	resp, err := e.request("GET", e.path+"/"+prefix, nil, nil)
+	if isEtcdError(err, etcdErrorKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]string, 0, len(resp.Node.Nodes))
+	for _, node := range resp.Node.Nodes {
+		name := path.Base(node.Key)
+		if node.Dir {
+			out = append(out, name+"/")
+		} else if strings.HasPrefix(name, etcdNodeFilePrefix) {
+			out = append(out, strings.TrimPrefix(name, etcdNodeFilePrefix))
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// HealthCheck is used to check that the path in etcd can be read
+func (e *EtcdBackend) HealthCheck() error {
+	_, err := e.request("GET", e.path, nil, nil)
+	if isEtcdError(err, etcdErrorKeyNotFound) {
+		return nil
+	}
+	return err
+}
+
+// LockWith is used for mutual exclusion based on the given key.
+func (e *EtcdBackend) LockWith(key, value string) (Lock, error) {
+	l := &EtcdLock{
+		backend: e,
+		key:     e.nodePath(key, etcdNodeLockPrefix),
+		value:   value,
+	}
+	return l, nil
+}
+
+// nodePath returns the path in etcd of the node of the given key, with
+// the prefix added to the name of the node
+func (e *EtcdBackend) nodePath(key, prefix string) string {
+	dir, name := path.Split(key)
+	return e.path + "/" + dir + prefix + name
+}
+
+// etcdNode is a node in a response of the etcd keys API
+type etcdNode struct {
+	Key           string      `json:"key"`
+	Value         string      `json:"value"`
+	Dir           bool        `json:"dir"`
+	Nodes         []*etcdNode `json:"nodes"`
+	ModifiedIndex uint64      `json:"modifiedIndex"`
+}
+
+// etcdResponse is a response of the etcd keys API
+type etcdResponse struct {
+	Action string    `json:"action"`
+	Node   *etcdNode `json:"node"`
+
+	// Index is the X-Etcd-Index header, the index of etcd at the time
+	// the response was generated
+	Index uint64 `json:"-"`
+}
+
+// etcdError is an error returned by the etcd keys API
+type etcdError struct {
+	Code    int    `json:"errorCode"`
+	Message string `json:"message"`
+	Cause   string `json:"cause"`
+	Index   uint64 `json:"index"`
+}
+
+func (e *etcdError) Error() string {
+	return fmt.Sprintf("etcd error %d: %s (%s)", e.Code, e.Message, e.Cause)
+}
+
+// isEtcdError checks if the error is an etcd error with the given code
+func isEtcdError(err error, code int) bool {
+	eErr, ok := err.(*etcdError)
+	return ok && eErr.Code == code
+}
+
+// request is used to make a request of the keys API
+func (e *EtcdBackend) request(method, key string, query, form url.Values) (*etcdResponse, error) {
+	return e.requestWith(e.client, method, key, query, form, nil)
+}
+
+// requestWith is used to make a request of the keys API using the given
+// client, which is cancelled if the given channel is closed
+func (e *EtcdBackend) requestWith(client *http.Client, method, key string,
+	query, form url.Values, cancelCh <-chan struct{}) (*etcdResponse, error) {
+	u := &url.URL{Path: "/v2/keys" + key}
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, e.address+u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Cancel = cancelCh
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		var eErr etcdError
+		if err := json.Unmarshal(raw, &eErr); err != nil || eErr.Code == 0 {
+			return nil, fmt.Errorf("unexpected response from etcd: %d: %s",
+				resp.StatusCode, raw)
+		}
+		return nil, &eErr
+	}
+
+	var out etcdResponse
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("failed to decode response from etcd: %v", err)
+	}
+	if out.Node == nil {
+		return nil, fmt.Errorf("unexpected response from etcd: %s", raw)
+	}
+	out.Index, _ = strconv.ParseUint(resp.Header.Get("X-Etcd-Index"), 10, 64)
+	return &out, nil
+}
+
+// EtcdLock is used to provide the Lock interface backed by etcd. The
+// lock is a key with a TTL that is renewed while it is held.
+type EtcdLock struct {
+	backend *EtcdBackend
+	key     string
+	value   string
+
+	l        sync.Mutex
+	held     bool
+	stopCh   chan struct{}
+	leaderCh chan struct{}
+}
+
+func (c *EtcdLock) Lock(stopCh <-chan struct{}) (<-chan struct{}, error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if c.held {
+		return nil, fmt.Errorf("lock already held")
+	}
+
+	for {
+		acquired, index, err := c.tryLock()
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			break
+		}
+
+		// Wait for the lock to change before trying again
+		if err := c.watch(index, stopCh); err != nil {
+			select {
+			case <-stopCh:
+				return nil, nil
+			case <-time.After(etcdLockRetryInterval):
+			}
+		}
+
+		select {
+		case <-stopCh:
+			return nil, nil
+		default:
+		}
+	}
+
+	c.held = true
+	c.stopCh = make(chan struct{})
+	c.leaderCh = make(chan struct{})
+	go c.renew(c.stopCh, c.leaderCh)
+	return c.leaderCh, nil
+}
+
+// tryLock attempts to create the key of the lock. If the lock is held,
+// the index to watch the key from is returned.
+func (c *EtcdLock) tryLock() (bool, uint64, error) {
+	_, err := c.backend.request("PUT", c.key,
+		url.Values{"prevExist": {"false"}},
+		url.Values{
+			"value": {c.value},
+			"ttl":   {strconv.Itoa(int(etcdLockTTL / time.Second))},
+		})
+	if err == nil {
+		return true, 0, nil
+	}
+	if eErr, ok := err.(*etcdError); ok && eErr.Code == etcdErrorNodeExist {
+		return false, eErr.Index + 1, nil
+	}
+	return false, 0, err
+}
+
+// watch blocks until the key of the lock changes after the given index
+func (c *EtcdLock) watch(index uint64, stopCh <-chan struct{}) error {
+	_, err := c.backend.requestWith(c.backend.watchClient, "GET", c.key, url.Values{
+		"wait":      {"true"},
+		"waitIndex": {strconv.FormatUint(index, 10)},
+	}, nil, stopCh)
+	if isEtcdError(err, etcdErrorEventIndexCleared) {
+		return nil
+	}
+	return err
+}
+
+// renew is used to renew the TTL of the lock while it is held,
+// closing the leader channel if the lock is lost
+func (c *EtcdLock) renew(stopCh, leaderCh chan struct{}) {
+	defer close(leaderCh)
+	renewed := time.Now()
+	for {
+		// Give up leadership before the lock can be released by etcd
+		deadline := renewed.Add(etcdLockTTL - etcdLockRenewInterval)
+		wait := etcdLockRenewInterval
+		if remain := deadline.Sub(time.Now()); remain < wait {
+			wait = remain
+		}
+		select {
+		case <-time.After(wait):
+		case <-stopCh:
+			return
+		}
+		if !time.Now().Before(deadline) {
+			return
+		}
+
+		start := time.Now()
+		_, err := c.backend.request("PUT", c.key,
+			url.Values{"prevValue": {c.value}},
+			url.Values{
+				"value": {c.value},
+				"ttl":   {strconv.Itoa(int(etcdLockTTL / time.Second))},
+			})
+		if isEtcdError(err, etcdErrorKeyNotFound) || isEtcdError(err, etcdErrorCompareFailed) {
+			return
+		}
+
+		// After an error the lock is still held until the deadline.
+		// The TTL is counted from when the renewal was sent, since etcd
+		// may have applied it before the response arrived.
+		if err == nil {
+			renewed = start
+		}
+	}
+}
+
+func (c *EtcdLock) Unlock() error {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if !c.held {
+		return nil
+	}
+
+	// Stop the renewal, which closes the leader channel
+	close(c.stopCh)
+	c.held = false
+
+	_, err := c.backend.request("DELETE", c.key,
+		url.Values{"prevValue": {c.value}}, nil)
+	if isEtcdError(err, etcdErrorKeyNotFound) || isEtcdError(err, etcdErrorCompareFailed) {
+		return nil
+	}
+	return err
+}
+
+func (c *EtcdLock) Value() (bool, string, error) {
+	resp, err := c.backend.request("GET", c.key, nil, nil)
+	if isEtcdError(err, etcdErrorKeyNotFound) {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	return true, resp.Node.Value, nil
+}
@@ -0,0 +1,95 @@
+package physical
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEtcdBackend(t *testing.T) {
+	addr := os.Getenv("ETCD_ADDR")
+	if addr == "" {
+		t.SkipNow()
+	}
+
+	randPath := fmt.Sprintf("/vault-%d", time.Now().Unix())
+	b, err := NewBackend("etcd", map[string]string{
+		"address": addr,
+		"path":    randPath,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer func() {
+		b.(*EtcdBackend).request("DELETE", randPath,
+			url.Values{"recursive": {"true"}}, nil)
+	}()
+
+	testBackend(t, b)
+	testBackend_EmptyValue(t, b)
+	testBackend_ListPrefix(t, b)
+
+	ha, ok := b.(HABackend)
+	if !ok {
+		t.Fatalf("etcd does not implement HABackend")
+	}
+	testHABackend(t, ha, ha)
+}
+
+func TestEtcdLock_RenewTimeout(t *testing.T) {
+	defer func(ttl, renew, timeout time.Duration) {
+		etcdLockTTL, etcdLockRenewInterval, etcdRequestTimeout = ttl, renew, timeout
+	}(etcdLockTTL, etcdLockRenewInterval, etcdRequestTimeout)
+	etcdLockTTL = 300 * time.Millisecond
+	etcdLockRenewInterval = 100 * time.Millisecond
+	etcdRequestTimeout = 50 * time.Millisecond
+
+	// The lock is acquired, but every renewal hangs
+	hangCh := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("prevExist") == "false" {
+			w.Write([]byte(`{"action":"create","node":{"key":"/vault/_lock"}}`))
+			return
+		}
+		select {
+		case <-hangCh:
+		case <-time.After(time.Second):
+		}
+	}))
+	defer ts.Close()
+	defer close(hangCh)
+
+	b, err := NewBackend("etcd", map[string]string{
+		"address": ts.URL,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	lock, err := b.(HABackend).LockWith("lock", "bar")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	start := time.Now()
+	leaderCh, err := lock.Lock(nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if leaderCh == nil {
+		t.Fatalf("failed to get leader ch")
+	}
+
+	// Leadership must be lost before etcd would release the lock
+	select {
+	case <-leaderCh:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("leader ch not closed")
+	}
+	if d := time.Now().Sub(start); d >= etcdLockTTL {
+		t.Fatalf("bad: %v", d)
+	}
+}
@@ -31,6 +31,22 @@ func newFileBackend(conf map[string]string) (Backend, error) {
 	return &FileBackend{Path: path}, nil
 }
 
+// HealthCheck is used to check that the path is a directory. It may
+// not exist yet, as it is only created when the first entry is written.
+func (b *FileBackend) HealthCheck() error {
+	info, err := os.Stat(b.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("'%s' is not a directory", b.Path)
+	}
+	return nil
+}
+
 func (b *FileBackend) Delete(k string) error {
 	b.l.Lock()
 	defer b.l.Unlock()
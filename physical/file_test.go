@@ -3,6 +3,7 @@ package physical
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -21,5 +22,33 @@ func TestFileBackend(t *testing.T) {
 	}
 
 	testBackend(t, b)
+	testBackend_EmptyValue(t, b)
 	testBackend_ListPrefix(t, b)
 }
+
+func TestFileBackend_HealthCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A missing directory is created on the first write
+	b := &FileBackend{Path: filepath.Join(dir, "data")}
+	if err := b.HealthCheck(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// A file is not usable
+	if err := ioutil.WriteFile(b.Path, []byte("foo"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := b.HealthCheck(); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// The cache passes the check through
+	if err := NewCache(b, 0).HealthCheck(); err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -5,5 +5,6 @@ import "testing"
 func TestInmem(t *testing.T) {
 	inm := NewInmem()
 	testBackend(t, inm)
+	testBackend_EmptyValue(t, inm)
 	testBackend_ListPrefix(t, inm)
 }
@@ -32,6 +32,14 @@ type HABackend interface {
 	LockWith(key, value string) (Lock, error)
 }
 
+// HealthChecker is an optional interface of a physical backend that
+// can report whether it is able to serve requests. Backends that do not
+// implement it are assumed to be healthy.
+type HealthChecker interface {
+	// HealthCheck returns an error if the backend is unhealthy
+	HealthCheck() error
+}
+
 type Lock interface {
 	// Lock is used to acquire the given lock
 	// The stopCh is optional and if closed should interrupt the lock
@@ -72,5 +80,6 @@ var BuiltinBackends = map[string]Factory{
 		return NewInmem(), nil
 	},
 	"consul": newConsulBackend,
+	"etcd":   newEtcdBackend,
 	"file":   newFileBackend,
 }
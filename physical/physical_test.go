@@ -101,6 +101,44 @@ func testBackend(t *testing.T, b Backend) {
 	}
 }
 
+func testBackend_EmptyValue(t *testing.T, b Backend) {
+	// An empty value must still be distinguishable from a missing key
+	e := &Entry{Key: "empty", Value: []byte{}}
+	if err := b.Put(e); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	out, err := b.Get("empty")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out == nil {
+		t.Fatalf("empty value should be present")
+	}
+	if out.Key != "empty" || len(out.Value) != 0 {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	keys, err := b.List("")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "empty" {
+		t.Fatalf("bad: %v", keys)
+	}
+
+	if err := b.Delete("empty"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	out, err = b.Get("empty")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out != nil {
+		t.Fatalf("bad: %#v", out)
+	}
+}
+
 func testBackend_ListPrefix(t *testing.T, b Backend) {
 	e1 := &Entry{Key: "foo", Value: []byte("test")}
 	e2 := &Entry{Key: "foo/bar", Value: []byte("test")}
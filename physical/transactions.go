@@ -0,0 +1,69 @@
+package physical
+
+import "fmt"
+
+// TxnOperation is the operation of a single entry of a transaction
+type TxnOperation string
+
+const (
+	PutOperation    TxnOperation = "put"
+	DeleteOperation TxnOperation = "delete"
+)
+
+// TxnEntry is an operation that is applied as part of a transaction.
+// Only the Key of the Entry is used for a delete.
+type TxnEntry struct {
+	Operation TxnOperation
+	Entry     *Entry
+}
+
+// Transactional is an optional interface of a physical backend that can
+// apply a group of operations atomically. Either all of the operations
+// are applied, or none of them are. A backend that limits the size of a
+// transaction applies a larger one in order, in groups of the largest
+// size it allows, each of which is atomic.
+type Transactional interface {
+	// Transaction is used to apply the operations in order
+	Transaction([]*TxnEntry) error
+}
+
+// Transaction applies the operations to the backend. They are applied
+// atomically if the backend implements Transactional, otherwise they are
+// applied one at a time in order, stopping at the first failure.
+func Transaction(b Backend, txns []*TxnEntry) error {
+	if err := validateTxns(txns); err != nil {
+		return err
+	}
+	if t, ok := b.(Transactional); ok {
+		return t.Transaction(txns)
+	}
+	for _, txn := range txns {
+		var err error
+		switch txn.Operation {
+		case PutOperation:
+			err = b.Put(txn.Entry)
+		case DeleteOperation:
+			err = b.Delete(txn.Entry.Key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTxns is used to check the operations of a transaction before
+// any of them are applied
+func validateTxns(txns []*TxnEntry) error {
+	for _, txn := range txns {
+		if txn == nil || txn.Entry == nil {
+			return fmt.Errorf("transaction entry is missing")
+		}
+		switch txn.Operation {
+		case PutOperation, DeleteOperation:
+		default:
+			return fmt.Errorf("unknown transaction operation: %s", txn.Operation)
+		}
+	}
+	return nil
+}
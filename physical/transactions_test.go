@@ -0,0 +1,111 @@
+package physical
+
+import (
+	"reflect"
+	"testing"
+)
+
+// sequentialBackend hides the Transactional implementation of a backend
+type sequentialBackend struct {
+	Backend
+}
+
+func testTransaction(t *testing.T, b Backend) {
+	if err := b.Put(&Entry{Key: "foo", Value: []byte("old")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txns := []*TxnEntry{
+		&TxnEntry{
+			Operation: PutOperation,
+			Entry:     &Entry{Key: "bar", Value: []byte("bar")},
+		},
+		&TxnEntry{
+			Operation: DeleteOperation,
+			Entry:     &Entry{Key: "foo"},
+		},
+		&TxnEntry{
+			Operation: PutOperation,
+			Entry:     &Entry{Key: "baz/zip", Value: []byte("zip")},
+		},
+	}
+	if err := Transaction(b, txns); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	out, err := b.Get("foo")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out != nil {
+		t.Fatalf("bad: %v", out)
+	}
+	for _, key := range []string{"bar", "baz/zip"} {
+		out, err := b.Get(key)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if out == nil {
+			t.Fatalf("missing: %s", key)
+		}
+	}
+
+	keys, err := b.List("")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"bar", "baz/"}) {
+		t.Fatalf("bad: %v", keys)
+	}
+
+	// Invalid operations are rejected before anything is applied
+	txns = []*TxnEntry{
+		&TxnEntry{
+			Operation: DeleteOperation,
+			Entry:     &Entry{Key: "bar"},
+		},
+		&TxnEntry{
+			Operation: "foo",
+			Entry:     &Entry{Key: "baz/zip"},
+		},
+	}
+	if err := Transaction(b, txns); err == nil {
+		t.Fatalf("expected error")
+	}
+	out, err = b.Get("bar")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out == nil {
+		t.Fatalf("missing: bar")
+	}
+}
+
+func TestTransaction_Inmem(t *testing.T) {
+	testTransaction(t, NewInmem())
+}
+
+func TestTransaction_Sequential(t *testing.T) {
+	testTransaction(t, &sequentialBackend{NewInmem()})
+}
+
+func TestTransaction_Cache(t *testing.T) {
+	inm := NewInmem()
+	cache := NewCache(inm, 0)
+	testTransaction(t, cache)
+
+	// The cache must agree with the underlying backend
+	for _, key := range []string{"foo", "bar", "baz/zip"} {
+		expected, err := inm.Get(key)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		out, err := cache.Get(key)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if !reflect.DeepEqual(out, expected) {
+			t.Fatalf("bad %s: %v %v", key, out, expected)
+		}
+	}
+}
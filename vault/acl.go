@@ -1,20 +1,25 @@
 package vault
 
 import (
+	"sort"
+
 	"github.com/armon/go-radix"
 	"github.com/hashicorp/vault/logical"
 )
 
-// operationPolicyLevel is used to map each logical operation
-// into the minimum required permissions to allow the operation.
-var operationPolicyLevel = map[logical.Operation]int{
-	logical.ReadOperation:   pathPolicyLevel[PathPolicyRead],
-	logical.WriteOperation:  pathPolicyLevel[PathPolicyWrite],
-	logical.DeleteOperation: pathPolicyLevel[PathPolicyWrite],
-	logical.ListOperation:   pathPolicyLevel[PathPolicyRead],
-	logical.RevokeOperation: pathPolicyLevel[PathPolicyWrite],
-	logical.RenewOperation:  pathPolicyLevel[PathPolicyRead],
-	logical.HelpOperation:   pathPolicyLevel[PathPolicyDeny],
+// operationCapability is used to map each logical operation into the
+// capability required to allow the operation. Operations that are not
+// listed require no capability. A write requires the update capability,
+// or the create capability if nothing exists at the path.
+var operationCapability = map[logical.Operation]uint32{
+	logical.ReadOperation:   ReadCapabilityInt,
+	logical.WriteOperation:  UpdateCapabilityInt,
+	logical.DeleteOperation: DeleteCapabilityInt,
+	logical.ListOperation:   ListCapabilityInt,
+	logical.RevokeOperation: UpdateCapabilityInt,
+	logical.RenewOperation:  ReadCapabilityInt,
+
+	logical.ExistenceCheckOperation: ReadCapabilityInt,
 }
 
 // ACL is used to wrap a set of policies to provide
@@ -23,87 +28,176 @@ type ACL struct {
 	// pathRules contains the path policies
 	pathRules *radix.Tree
 
+	// globRules contains the path policies ending with a glob, keyed
+	// by the path without the glob
+	globRules *radix.Tree
+
+	// denyRules contains the path prefixes denied by any policy, which
+	// take precedence over every other rule
+	denyRules *radix.Tree
+
 	// root is enabled if the "root" named policy is present.
 	root bool
+
+	// breakGlass contains the path prefixes on which the ACL may be
+	// overridden using the break-glass capability
+	breakGlass *radix.Tree
 }
 
 // New is used to construct a policy based ACL from a set of policies.
+// The policies are merged in order of name, so the same set of policies
+// always results in the same ACL regardless of the order given.
 func NewACL(policies []*Policy) (*ACL, error) {
 	// Initialize
 	a := &ACL{
-		pathRules: radix.New(),
-		root:      false,
+		pathRules:  radix.New(),
+		globRules:  radix.New(),
+		denyRules:  radix.New(),
+		root:       false,
+		breakGlass: radix.New(),
 	}
 
-	// Inject each policy
+	// Sort a copy of the policies, ignoring any nil policy objects
+	sorted := make([]*Policy, 0, len(policies))
 	for _, policy := range policies {
-		// Ignore a nil policy object
-		if policy == nil {
-			continue
+		if policy != nil {
+			sorted = append(sorted, policy)
 		}
+	}
+	sort.Stable(policiesByName(sorted))
+
+	// Inject each policy
+	for _, policy := range sorted {
 		// Check if this is root
 		if policy.Name == "root" {
 			a.root = true
 		}
+		for _, prefix := range policy.BreakGlass {
+			a.breakGlass.Insert(prefix, true)
+		}
 		for _, pp := range policy.Paths {
-			// Convert to a policy level
-			policyLevel := pathPolicyLevel[pp.Policy]
-
-			// Check for an existing policy
-			raw, ok := a.pathRules.Get(pp.Prefix)
-			if !ok {
-				a.pathRules.Insert(pp.Prefix, policyLevel)
+			// A deny applies to the path and everything under it,
+			// regardless of the rules of any policy
+			if pp.CapabilitiesBitmap&DenyCapabilityInt != 0 {
+				a.denyRules.Insert(pp.Prefix, true)
 				continue
 			}
-			existing := raw.(int)
 
-			// Check if this policy is a higher access level,
-			// we want to store the highest permission permitted.
-			if policyLevel > existing {
-				a.pathRules.Insert(pp.Prefix, policyLevel)
+			// Determine the rules to insert into
+			rules := a.pathRules
+			if pp.Glob {
+				rules = a.globRules
+			}
+
+			// Combine with the capabilities of an existing rule,
+			// we want to store every capability permitted.
+			capabilities := pp.CapabilitiesBitmap
+			if raw, ok := rules.Get(pp.Prefix); ok {
+				capabilities |= raw.(uint32)
 			}
+			rules.Insert(pp.Prefix, capabilities)
 		}
 	}
 	return a, nil
 }
 
-// AllowOperation is used to check if the given operation is permitted
-func (a *ACL) AllowOperation(op logical.Operation, path string) bool {
+// policiesByName is used to sort policies by name
+type policiesByName []*Policy
+
+func (p policiesByName) Len() int           { return len(p) }
+func (p policiesByName) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p policiesByName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// AllowOperation is used to check if the given operation is permitted,
+// and if the sudo capability is granted, which is required on the paths
+// that need root privileges
+func (a *ACL) AllowOperation(op logical.Operation, path string) (allowed bool, sudo bool) {
 	// Fast-path root
 	if a.root {
-		return true
+		return true, true
 	}
 
-	// Find a matching rule, default deny if no match
-	policyLevel := 0
-	_, rule, ok := a.pathRules.LongestPrefix(path)
-	if ok {
-		policyLevel = rule.(int)
-	}
+	// Convert the operation to the required capability
+	required, ok := operationCapability[op]
 
-	// Convert the operation to a minimum required level
-	requiredLevel := operationPolicyLevel[op]
+	// Check for an explicit deny, which only permits the operations
+	// requiring no capability
+	if a.denied(path) {
+		return !ok, false
+	}
 
-	// Check if the minimum permissions are met
-	return policyLevel >= requiredLevel
+	// Find a matching rule, default deny if no match
+	capabilities := a.capabilities(path)
+	sudo = capabilities&SudoCapabilityInt != 0
+	if !ok {
+		return true, sudo
+	}
+	return capabilities&required != 0, sudo
 }
 
-// RootPrivilege checks if the user has root level permission
-// to given path. This requires that the user be root, or that
-// sudo privilege is available on that path.
-func (a *ACL) RootPrivilege(path string) bool {
-	// Fast-path root
+// AllowCreate checks if the create capability is granted on the given
+// path, which permits a write to a path that does not exist yet
+func (a *ACL) AllowCreate(path string) bool {
 	if a.root {
 		return true
 	}
-
-	// Check the rules for a match
-	_, rule, ok := a.pathRules.LongestPrefix(path)
-	if !ok {
+	if a.denied(path) {
 		return false
 	}
+	return a.capabilities(path)&CreateCapabilityInt != 0
+}
+
+// Capabilities returns the capabilities granted on the given path,
+// which are all of them for root, and none if the path is denied
+func (a *ACL) Capabilities(path string) []string {
+	var capabilities uint32
+	switch {
+	case a.root:
+		capabilities = ^DenyCapabilityInt
+	case !a.denied(path):
+		capabilities = a.capabilities(path)
+	}
+
+	out := make([]string, 0, len(capabilityNames))
+	for _, name := range capabilityNames {
+		if capabilities&capabilityBits[name] != 0 {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// denied checks if the given path is explicitly denied by any policy
+func (a *ACL) denied(path string) bool {
+	_, _, ok := a.denyRules.LongestPrefix(path)
+	return ok
+}
+
+// capabilities is used to find the capabilities of the rule that applies
+// to the given path, which are none if no rule matches. The rule matching
+// the longest part of the path is used, and if a glob and a prefix rule
+// match equally, an exact match of the path takes precedence, followed by
+// the glob and then the prefix.
+func (a *ACL) capabilities(path string) uint32 {
+	prefix, prefixRule, prefixOk := a.pathRules.LongestPrefix(path)
+	glob, globRule, globOk := a.globRules.LongestPrefix(path)
+
+	switch {
+	case prefixOk && prefix == path:
+		return prefixRule.(uint32)
+	case globOk && (!prefixOk || len(glob) >= len(prefix)):
+		return globRule.(uint32)
+	case prefixOk:
+		return prefixRule.(uint32)
+	default:
+		return 0
+	}
+}
 
-	// Check the policy level
-	policyLevel := rule.(int)
-	return policyLevel == pathPolicyLevel[PathPolicySudo]
+// BreakGlass checks if the ACL may be overridden for the given path
+// using the break-glass capability. This is never implied by root, and
+// must be granted explicitly.
+func (a *ACL) BreakGlass(path string) bool {
+	_, _, ok := a.breakGlass.LongestPrefix(path)
+	return ok
 }
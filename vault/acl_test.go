@@ -1,6 +1,7 @@
 package vault
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/hashicorp/vault/logical"
@@ -14,10 +15,11 @@ func TestACL_Root(t *testing.T) {
 		t.Fatalf("err: %v", err)
 	}
 
-	if !acl.RootPrivilege("sys/mount/foo") {
+	allowed, sudo := acl.AllowOperation(logical.WriteOperation, "sys/mount/foo")
+	if !sudo {
 		t.Fatalf("expected root")
 	}
-	if !acl.AllowOperation(logical.WriteOperation, "sys/mount/foo") {
+	if !allowed {
 		t.Fatalf("expected permission")
 	}
 }
@@ -33,7 +35,7 @@ func TestACL_Single(t *testing.T) {
 		t.Fatalf("err: %v", err)
 	}
 
-	if acl.RootPrivilege("sys/mount/foo") {
+	if _, sudo := acl.AllowOperation(logical.ReadOperation, "sys/mount/foo"); sudo {
 		t.Fatalf("unexpected root")
 	}
 
@@ -58,14 +60,195 @@ func TestACL_Single(t *testing.T) {
 		{logical.ReadOperation, "prod/foo", true},
 		{logical.ListOperation, "prod/foo", true},
 		{logical.ReadOperation, "prod/aws/foo", false},
+
+		{logical.ExistenceCheckOperation, "prod/foo", true},
+		{logical.ExistenceCheckOperation, "prod/aws/foo", false},
+	}
+
+	for _, tc := range tcases {
+		out, _ := acl.AllowOperation(tc.op, tc.path)
+		if out != tc.expect {
+			t.Fatalf("bad: case %#v: %v", tc, out)
+		}
+	}
+}
+
+func TestACL_Glob(t *testing.T) {
+	policy, err := Parse(aclPolicyGlob)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	acl, err := NewACL([]*Policy{policy})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	type tcase struct {
+		op     logical.Operation
+		path   string
+		expect bool
+	}
+	tcases := []tcase{
+		// The glob matches everything under the path
+		{logical.ReadOperation, "secret/app/foo", true},
+		{logical.WriteOperation, "secret/app/foo/bar", true},
+
+		// The more specific prefix wins over the glob
+		{logical.ReadOperation, "secret/app/shared", true},
+		{logical.WriteOperation, "secret/app/shared", false},
+		{logical.WriteOperation, "secret/app/shared/foo", false},
+
+		// A glob wins over a prefix of the same path
+		{logical.WriteOperation, "secret/team/foo", true},
+
+		// An exact match wins over a glob of the same path
+		{logical.WriteOperation, "secret/team/", false},
+		{logical.ReadOperation, "secret/team/", true},
+
+		{logical.ReadOperation, "secret/other", false},
+	}
+
+	for _, tc := range tcases {
+		out, _ := acl.AllowOperation(tc.op, tc.path)
+		if out != tc.expect {
+			t.Fatalf("bad: case %#v: %v", tc, out)
+		}
+	}
+
+	if _, sudo := acl.AllowOperation(logical.ReadOperation, "secret/sudo/foo"); !sudo {
+		t.Fatalf("expected root")
+	}
+}
+
+func TestACL_Deny(t *testing.T) {
+	policy1, err := Parse(aclPolicyDeny)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	policy2, err := Parse(aclPolicyDenyOverride)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	acl, err := NewACL([]*Policy{policy1, policy2})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	type tcase struct {
+		op     logical.Operation
+		path   string
+		expect bool
+	}
+	tcases := []tcase{
+		{logical.WriteOperation, "secret/foo", true},
+
+		// The deny wins over the grants of the other policy, including
+		// those on more specific paths
+		{logical.ReadOperation, "secret/admin/foo", false},
+		{logical.WriteOperation, "secret/admin/keys/foo", false},
+		{logical.ReadOperation, "secret/team/admin", false},
+		{logical.HelpOperation, "secret/admin/foo", true},
+	}
+
+	for _, tc := range tcases {
+		out, _ := acl.AllowOperation(tc.op, tc.path)
+		if out != tc.expect {
+			t.Fatalf("bad: case %#v: %v", tc, out)
+		}
+	}
+
+	if _, sudo := acl.AllowOperation(logical.ReadOperation, "secret/foo"); !sudo {
+		t.Fatalf("expected root")
+	}
+	if _, sudo := acl.AllowOperation(logical.ReadOperation, "secret/admin/keys/foo"); sudo {
+		t.Fatalf("unexpected root")
+	}
+}
+
+func TestACL_Capabilities(t *testing.T) {
+	policy, err := Parse(aclPolicyCapabilities)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	acl, err := NewACL([]*Policy{policy})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	type tcase struct {
+		op     logical.Operation
+		path   string
+		expect bool
+	}
+	tcases := []tcase{
+		{logical.ListOperation, "secret/list/", true},
+		{logical.ReadOperation, "secret/list/foo", false},
+
+		{logical.ReadOperation, "secret/create/foo", true},
+		{logical.WriteOperation, "secret/create/foo", false},
+		{logical.DeleteOperation, "secret/create/foo", false},
+
+		{logical.WriteOperation, "secret/update/foo", true},
+		{logical.DeleteOperation, "secret/update/foo", true},
+		{logical.ListOperation, "secret/update/foo", false},
 	}
 
 	for _, tc := range tcases {
-		out := acl.AllowOperation(tc.op, tc.path)
+		out, _ := acl.AllowOperation(tc.op, tc.path)
 		if out != tc.expect {
 			t.Fatalf("bad: case %#v: %v", tc, out)
 		}
 	}
+
+	capabilities := acl.Capabilities("secret/create/foo")
+	if !reflect.DeepEqual(capabilities, []string{"create", "read", "sudo"}) {
+		t.Fatalf("bad: %#v", capabilities)
+	}
+	capabilities = acl.Capabilities("secret/other")
+	if !reflect.DeepEqual(capabilities, []string{}) {
+		t.Fatalf("bad: %#v", capabilities)
+	}
+
+	if !acl.AllowCreate("secret/create/foo") {
+		t.Fatalf("expected create")
+	}
+	if acl.AllowCreate("secret/update/foo") {
+		t.Fatalf("unexpected create")
+	}
+	if _, sudo := acl.AllowOperation(logical.ReadOperation, "secret/create/foo"); !sudo {
+		t.Fatalf("expected root")
+	}
+}
+
+func TestACL_BreakGlass(t *testing.T) {
+	policy, err := Parse(aclPolicy)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	acl, err := NewACL([]*Policy{policy, &Policy{Name: "root"}})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Root does not imply the capability
+	if acl.BreakGlass("dev/foo") {
+		t.Fatalf("unexpected break-glass")
+	}
+
+	policy.BreakGlass = []string{"prod/"}
+	acl, err = NewACL([]*Policy{policy})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !acl.BreakGlass("prod/foo") {
+		t.Fatalf("expected break-glass")
+	}
+	if acl.BreakGlass("dev/foo") {
+		t.Fatalf("unexpected break-glass")
+	}
 }
 
 func TestACL_Layered(t *testing.T) {
@@ -86,8 +269,58 @@ func TestACL_Layered(t *testing.T) {
 	testLayeredACL(t, acl)
 }
 
+func TestACL_Deterministic(t *testing.T) {
+	policy1, err := Parse(aclPolicy)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	policy2, err := Parse(aclPolicy2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expected, err := NewACL([]*Policy{policy1, policy2})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	orders := [][]*Policy{
+		{policy2, policy1},
+		{policy2, nil, policy1, policy2},
+		{nil, policy1, policy1, policy2},
+	}
+	for _, policies := range orders {
+		acl, err := NewACL(policies)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if !reflect.DeepEqual(aclRules(acl), aclRules(expected)) {
+			t.Fatalf("bad: %v %v", aclRules(acl), aclRules(expected))
+		}
+		testLayeredACL(t, acl)
+	}
+}
+
+// aclRules is used to flatten the path rules of an ACL for comparison
+func aclRules(acl *ACL) map[string]uint32 {
+	out := make(map[string]uint32)
+	acl.pathRules.Walk(func(k string, v interface{}) bool {
+		out[k] = v.(uint32)
+		return false
+	})
+	acl.globRules.Walk(func(k string, v interface{}) bool {
+		out[k+"*"] = v.(uint32)
+		return false
+	})
+	acl.denyRules.Walk(func(k string, v interface{}) bool {
+		out["!"+k] = DenyCapabilityInt
+		return false
+	})
+	return out
+}
+
 func testLayeredACL(t *testing.T, acl *ACL) {
-	if acl.RootPrivilege("sys/mount/foo") {
+	if _, sudo := acl.AllowOperation(logical.ReadOperation, "sys/mount/foo"); sudo {
 		t.Fatalf("unexpected root")
 	}
 
@@ -105,7 +338,10 @@ func testLayeredACL(t *testing.T, acl *ACL) {
 
 		{logical.DeleteOperation, "stage/foo", true},
 		{logical.WriteOperation, "stage/aws/foo", false},
-		{logical.WriteOperation, "stage/aws/policy/foo", true},
+
+		// The deny of the second policy wins over the sudo of the first
+		{logical.WriteOperation, "stage/aws/policy/foo", false},
+		{logical.ReadOperation, "stage/aws/policy/foo", false},
 
 		{logical.DeleteOperation, "prod/foo", true},
 		{logical.WriteOperation, "prod/foo", true},
@@ -115,7 +351,7 @@ func testLayeredACL(t *testing.T, acl *ACL) {
 	}
 
 	for _, tc := range tcases {
-		out := acl.AllowOperation(tc.op, tc.path)
+		out, _ := acl.AllowOperation(tc.op, tc.path)
 		if out != tc.expect {
 			t.Fatalf("bad: case %#v: %v", tc, out)
 		}
@@ -156,3 +392,58 @@ path "prod/" {
 	policy = "write"
 }
 `
+
+var aclPolicyGlob = `
+name = "glob"
+path "secret/app/*" {
+	policy = "write"
+}
+path "secret/app/shared" {
+	policy = "read"
+}
+path "secret/team/" {
+	policy = "read"
+}
+path "secret/team/*" {
+	policy = "write"
+}
+path "secret/sudo/*" {
+	policy = "sudo"
+}
+`
+
+var aclPolicyDeny = `
+name = "tenant"
+path "secret/" {
+	policy = "sudo"
+}
+path "secret/admin/" {
+	policy = "deny"
+}
+path "secret/team/admin*" {
+	policy = "deny"
+}
+`
+
+var aclPolicyDenyOverride = `
+name = "admin"
+path "secret/admin/keys/" {
+	policy = "sudo"
+}
+path "secret/team/" {
+	policy = "write"
+}
+`
+
+var aclPolicyCapabilities = `
+name = "capabilities"
+path "secret/list/" {
+	capabilities = ["list"]
+}
+path "secret/create/" {
+	capabilities = ["create", "read", "sudo"]
+}
+path "secret/update/" {
+	capabilities = ["update", "delete"]
+}
+`
@@ -4,14 +4,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"io"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/armon/go-metrics"
 	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/helper/leveled-log"
 	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/physical"
 )
 
 const (
@@ -23,6 +25,14 @@ const (
 	// auditBarrierPrefix is the prefix to the UUID used in the
 	// barrier view for the audit backends.
 	auditBarrierPrefix = "audit/"
+
+	// mandatoryAuditUUID is the UUID used in the barrier view of the
+	// mandatory audit backend if the configuration does not provide one
+	mandatoryAuditUUID = "mandatory"
+
+	// auditSaltLocation is the key in the view of an audit backend at
+	// which the salt used to hash sensitive values is stored
+	auditSaltLocation = "salt"
 )
 
 var (
@@ -32,8 +42,8 @@ var (
 
 // enableAudit is used to enable a new audit backend
 func (c *Core) enableAudit(entry *MountEntry) error {
-	c.audit.Lock()
-	defer c.audit.Unlock()
+	c.auditLock.Lock()
+	defer c.auditLock.Unlock()
 
 	// Ensure we end the path in a slash
 	if !strings.HasSuffix(entry.Path, "/") {
@@ -46,7 +56,11 @@ func (c *Core) enableAudit(entry *MountEntry) error {
 	}
 
 	// Look for matching name
-	for _, ent := range c.audit.Entries {
+	entries := c.audit.Entries
+	if c.mandatoryAudit != nil {
+		entries = append([]*MountEntry{c.mandatoryAudit}, entries...)
+	}
+	for _, ent := range entries {
 		switch {
 		// Existing is sql/mysql/ new is sql/ or
 		// existing is sql/ and new is sql/mysql/
@@ -57,9 +71,8 @@ func (c *Core) enableAudit(entry *MountEntry) error {
 		}
 	}
 
-	// Lookup the new backend
-	backend, err := c.newAuditBackend(entry.Type, entry.Options)
-	if err != nil {
+	// Verify the audit table is not full
+	if err := c.checkMountLimit(c.audit); err != nil {
 		return err
 	}
 
@@ -67,25 +80,41 @@ func (c *Core) enableAudit(entry *MountEntry) error {
 	entry.UUID = generateUUID()
 	view := NewBarrierView(c.barrier, auditBarrierPrefix+entry.UUID+"/")
 
-	// Update the audit table
+	// Lookup the new backend with a new salt
+	salt := generateUUID()
+	backend, err := c.newAuditBackendSalt(entry.Type, salt, entry.Options)
+	if err != nil {
+		return err
+	}
+
+	// Update the audit table, the salt is persisted with it so that a
+	// backend is never enabled without its salt
 	newTable := c.audit.Clone()
 	newTable.Entries = append(newTable.Entries, entry)
-	if err := c.persistAudit(newTable); err != nil {
+	saltEntry := &TxnEntry{
+		Operation: physical.PutOperation,
+		Entry: &Entry{
+			Key:   view.expandKey(auditSaltLocation),
+			Value: []byte(salt),
+		},
+	}
+	if err := c.persistAudit(newTable, saltEntry); err != nil {
+		closeAuditBackend(backend)
 		return errors.New("failed to update audit table")
 	}
 	c.audit = newTable
 
 	// Register the backend
-	c.auditBroker.Register(entry.Path, backend, view)
-	c.logger.Printf("[INFO] core: enabled audit backend '%s' type: %s",
+	c.auditBroker.Register(entry.Path, backend, view, entry.BestEffort, entry.AuditFilter)
+	c.logger.Info("core: enabled audit backend '%s' type: %s",
 		entry.Path, entry.Type)
 	return nil
 }
 
 // disableAudit is used to disable an existing audit backend
 func (c *Core) disableAudit(path string) error {
-	c.audit.Lock()
-	defer c.audit.Unlock()
+	c.auditLock.Lock()
+	defer c.auditLock.Unlock()
 
 	// Ensure we end the path in a slash
 	if !strings.HasSuffix(path, "/") {
@@ -109,22 +138,25 @@ func (c *Core) disableAudit(path string) error {
 
 	// Unmount the backend
 	c.auditBroker.Deregister(path)
-	c.logger.Printf("[INFO] core: disabled audit backend '%s'", path)
+	c.logger.Info("core: disabled audit backend '%s'", path)
 	return nil
 }
 
 // loadAudits is invoked as part of postUnseal to load the audit table
 func (c *Core) loadAudits() error {
+	c.auditLock.Lock()
+	defer c.auditLock.Unlock()
+
 	// Load the existing audit table
 	raw, err := c.barrier.Get(coreAuditConfigPath)
 	if err != nil {
-		c.logger.Printf("[ERR] core: failed to read audit table: %v", err)
+		c.logger.Error("core: failed to read audit table: %v", err)
 		return loadAuditFailed
 	}
 	if raw != nil {
 		c.audit = &MountTable{}
 		if err := json.Unmarshal(raw.Value, c.audit); err != nil {
-			c.logger.Printf("[ERR] core: failed to decode audit table: %v", err)
+			c.logger.Error("core: failed to decode audit table: %v", err)
 			return loadAuditFailed
 		}
 	}
@@ -142,12 +174,13 @@ func (c *Core) loadAudits() error {
 	return nil
 }
 
-// persistAudit is used to persist the audit table after modification
-func (c *Core) persistAudit(table *MountTable) error {
+// persistAudit is used to persist the audit table after modification.
+// Any other entries are written in the same transaction as the table.
+func (c *Core) persistAudit(table *MountTable, txns ...*TxnEntry) error {
 	// Marshal the table
 	raw, err := json.Marshal(table)
 	if err != nil {
-		c.logger.Printf("[ERR] core: failed to encode audit table: %v", err)
+		c.logger.Error("core: failed to encode audit table: %v", err)
 		return err
 	}
 
@@ -158,52 +191,134 @@ func (c *Core) persistAudit(table *MountTable) error {
 	}
 
 	// Write to the physical backend
-	if err := c.barrier.Put(entry); err != nil {
-		c.logger.Printf("[ERR] core: failed to persist audit table: %v", err)
+	txns = append([]*TxnEntry{
+		&TxnEntry{Operation: physical.PutOperation, Entry: entry},
+	}, txns...)
+	if err := barrierTransaction(c.barrier, txns); err != nil {
+		c.logger.Error("core: failed to persist audit table: %v", err)
 		return err
 	}
+	emitMountCount("audit", table)
 	return nil
 }
 
 // setupAudit is invoked after we've loaded the audit able to
 // initialize the audit backends
 func (c *Core) setupAudits() error {
+	c.auditLock.RLock()
+	defer c.auditLock.RUnlock()
+
+	emitMountCount("audit", c.audit)
 	broker := NewAuditBroker(c.logger)
+	if c.mandatoryAudit != nil {
+		if err := c.setupMandatoryAudit(broker); err != nil {
+			c.logger.Error(
+				"core: failed to setup mandatory audit backend '%s': %v",
+				c.mandatoryAudit.Path, err)
+			return loadAuditFailed
+		}
+	}
 	for _, entry := range c.audit.Entries {
+		// Create a barrier view using the UUID
+		view := NewBarrierView(c.barrier, auditBarrierPrefix+entry.UUID+"/")
+
 		// Initialize the backend
-		audit, err := c.newAuditBackend(entry.Type, entry.Options)
+		audit, err := c.newAuditBackend(entry.Type, view, entry.Options)
 		if err != nil {
-			c.logger.Printf(
-				"[ERR] core: failed to create audit entry %#v: %v",
+			c.logger.Error(
+				"core: failed to create audit entry %#v: %v",
 				entry, err)
+			broker.deregisterAll()
 			return loadAuditFailed
 		}
 
-		// Create a barrier view using the UUID
-		view := NewBarrierView(c.barrier, auditBarrierPrefix+entry.UUID+"/")
-
 		// Mount the backend
-		broker.Register(entry.Path, audit, view)
+		broker.Register(entry.Path, audit, view, entry.BestEffort, entry.AuditFilter)
 	}
 	c.auditBroker = broker
 	return nil
 }
 
+// setupMandatoryAudit is used to register the mandatory audit backend.
+// The backend must log a request before it is registered, so that a
+// broken audit sink prevents the Vault from becoming active.
+func (c *Core) setupMandatoryAudit(broker *AuditBroker) error {
+	entry := c.mandatoryAudit
+	view := NewBarrierView(c.barrier, auditBarrierPrefix+entry.UUID+"/")
+	backend, err := c.newAuditBackend(entry.Type, view, entry.Options)
+	if err != nil {
+		return err
+	}
+
+	req := &logical.Request{
+		Operation: logical.WriteOperation,
+		Path:      "sys/audit/" + entry.Path,
+	}
+	if err := backend.LogRequest(nil, req); err != nil {
+		closeAuditBackend(backend)
+		return err
+	}
+
+	broker.Register(entry.Path, backend, view, false, entry.AuditFilter)
+	return nil
+}
+
 // teardownAudit is used before we seal the vault to reset the audit
 // backends to their unloaded state. This is reversed by loadAudits.
 func (c *Core) teardownAudits() error {
+	if c.auditBroker != nil {
+		c.auditBroker.deregisterAll()
+	}
+	c.auditLock.Lock()
 	c.audit = nil
+	c.auditLock.Unlock()
 	c.auditBroker = nil
 	return nil
 }
 
-// newAuditBackend is used to create and configure a new audit backend by name
-func (c *Core) newAuditBackend(t string, conf map[string]string) (audit.Backend, error) {
+// newAuditBackend is used to create and configure a new audit backend by
+// name, with the salt stored in the view of the backend
+func (c *Core) newAuditBackend(t string, view *BarrierView, conf map[string]string) (audit.Backend, error) {
+	if _, ok := c.auditBackends[t]; !ok {
+		return nil, fmt.Errorf("unknown backend type: %s", t)
+	}
+	salt, err := auditSalt(view)
+	if err != nil {
+		return nil, err
+	}
+	return c.newAuditBackendSalt(t, salt, conf)
+}
+
+// newAuditBackendSalt is used to create and configure a new audit backend
+// by name, using the given salt
+func (c *Core) newAuditBackendSalt(t, salt string, conf map[string]string) (audit.Backend, error) {
 	f, ok := c.auditBackends[t]
 	if !ok {
 		return nil, fmt.Errorf("unknown backend type: %s", t)
 	}
-	return f(conf)
+	return f(&audit.BackendConfig{
+		Salt:   salt,
+		Config: conf,
+	})
+}
+
+// auditSalt is used to read the salt of an audit backend from its view,
+// generating and persisting a new salt if there is none yet
+func auditSalt(view *BarrierView) (string, error) {
+	raw, err := view.Get(auditSaltLocation)
+	if err != nil {
+		return "", fmt.Errorf("failed to read salt: %v", err)
+	}
+	if raw != nil {
+		return string(raw.Value), nil
+	}
+
+	salt := generateUUID()
+	raw = &logical.StorageEntry{Key: auditSaltLocation, Value: []byte(salt)}
+	if err := view.Put(raw); err != nil {
+		return "", fmt.Errorf("failed to persist salt: %v", err)
+	}
+	return salt, nil
 }
 
 // defaultAuditTable creates a default audit table
@@ -213,8 +328,10 @@ func defaultAuditTable() *MountTable {
 }
 
 type backendEntry struct {
-	backend audit.Backend
-	view    *BarrierView
+	backend    audit.Backend
+	view       *BarrierView
+	bestEffort bool
+	filter     *audit.Filter
 }
 
 // AuditBroker is used to provide a single ingest interface to auditable
@@ -222,33 +339,65 @@ type backendEntry struct {
 type AuditBroker struct {
 	l        sync.RWMutex
 	backends map[string]backendEntry
-	logger   *log.Logger
+	logger   leveledlog.Logger
 }
 
 // NewAuditBroker creates a new audit broker
-func NewAuditBroker(log *log.Logger) *AuditBroker {
+func NewAuditBroker(logger leveledlog.Logger) *AuditBroker {
 	b := &AuditBroker{
 		backends: make(map[string]backendEntry),
-		logger:   log,
+		logger:   logger,
 	}
 	return b
 }
 
-// Register is used to add new audit backend to the broker
-func (a *AuditBroker) Register(name string, b audit.Backend, v *BarrierView) {
+// Register is used to add new audit backend to the broker. A best effort
+// backend is given every event, but its failures never fail a request.
+// The filter, if any, is applied to the events given to the backend.
+func (a *AuditBroker) Register(name string, b audit.Backend, v *BarrierView,
+	bestEffort bool, filter *audit.Filter) {
 	a.l.Lock()
 	defer a.l.Unlock()
 	a.backends[name] = backendEntry{
-		backend: b,
-		view:    v,
+		backend:    b,
+		view:       v,
+		bestEffort: bestEffort,
+		filter:     filter,
 	}
 }
 
-// Deregister is used to remove an audit backend from the broker
+// Deregister is used to remove an audit backend from the broker. The
+// backend is closed if it implements io.Closer.
 func (a *AuditBroker) Deregister(name string) {
 	a.l.Lock()
-	defer a.l.Unlock()
+	be, ok := a.backends[name]
 	delete(a.backends, name)
+	a.l.Unlock()
+
+	// Close outside the lock, since a backend may flush on close
+	if ok {
+		closeAuditBackend(be.backend)
+	}
+}
+
+// deregisterAll is used to remove and close all the audit backends
+func (a *AuditBroker) deregisterAll() {
+	a.l.Lock()
+	backends := a.backends
+	a.backends = make(map[string]backendEntry)
+	a.l.Unlock()
+
+	for _, be := range backends {
+		closeAuditBackend(be.backend)
+	}
+}
+
+// closeAuditBackend is used to close an audit backend that holds
+// resources, such as one delivering in the background
+func closeAuditBackend(b audit.Backend) {
+	if c, ok := b.(io.Closer); ok {
+		c.Close()
+	}
 }
 
 // IsRegistered is used to check if a given audit backend is registered
@@ -260,51 +409,55 @@ func (a *AuditBroker) IsRegistered(name string) bool {
 }
 
 // LogRequest is used to ensure all the audit backends have an opportunity to
-// log the given request and that *at least one* succeeds.
+// log the given request and that *at least one* required backend succeeds.
 func (a *AuditBroker) LogRequest(auth *logical.Auth, req *logical.Request) error {
 	defer metrics.MeasureSince([]string{"audit", "log_request"}, time.Now())
 	a.l.RLock()
 	defer a.l.RUnlock()
 
-	// Ensure at least one backend logs
-	anyLogged := false
+	// Ensure at least one required backend logs
+	anyRequired, anyLogged := false, false
 	for name, be := range a.backends {
 		start := time.Now()
-		err := be.backend.LogRequest(auth, req)
+		err := be.backend.LogRequest(auth, be.filter.Request(req))
 		metrics.MeasureSince([]string{"audit", name, "log_request"}, start)
 		if err != nil {
-			a.logger.Printf("[ERR] audit: backend '%s' failed to log request: %v", name, err)
-		} else {
-			anyLogged = true
+			a.logger.Error("audit: backend '%s' failed to log request: %v", name, err)
+		}
+		if !be.bestEffort {
+			anyRequired = true
+			anyLogged = anyLogged || err == nil
 		}
 	}
-	if !anyLogged && len(a.backends) > 0 {
+	if anyRequired && !anyLogged {
 		return fmt.Errorf("no audit backend succeeded in logging the request")
 	}
 	return nil
 }
 
 // LogResponse is used to ensure all the audit backends have an opportunity to
-// log the given response and that *at least one* succeeds.
+// log the given response and that *at least one* required backend succeeds.
 func (a *AuditBroker) LogResponse(auth *logical.Auth, req *logical.Request,
 	resp *logical.Response, err error) error {
 	defer metrics.MeasureSince([]string{"audit", "log_response"}, time.Now())
 	a.l.RLock()
 	defer a.l.RUnlock()
 
-	// Ensure at least one backend logs
-	anyLogged := false
+	// Ensure at least one required backend logs
+	anyRequired, anyLogged := false, false
 	for name, be := range a.backends {
 		start := time.Now()
-		err := be.backend.LogResponse(auth, req, resp, err)
+		err := be.backend.LogResponse(auth, be.filter.Request(req), be.filter.Response(resp), err)
 		metrics.MeasureSince([]string{"audit", name, "log_response"}, start)
 		if err != nil {
-			a.logger.Printf("[ERR] audit: backend '%s' failed to log response: %v", name, err)
-		} else {
-			anyLogged = true
+			a.logger.Error("audit: backend '%s' failed to log response: %v", name, err)
+		}
+		if !be.bestEffort {
+			anyRequired = true
+			anyLogged = anyLogged || err == nil
 		}
 	}
-	if !anyLogged && len(a.backends) > 0 {
+	if anyRequired && !anyLogged {
 		return fmt.Errorf("no audit backend succeeded in logging the response")
 	}
 	return nil
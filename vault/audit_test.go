@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/helper/leveled-log"
 	"github.com/hashicorp/vault/logical"
 )
 
@@ -22,6 +23,8 @@ type NoopAudit struct {
 	RespReq  []*logical.Request
 	Resp     []*logical.Response
 	RespErrs []error
+
+	Closed bool
 }
 
 func (n *NoopAudit) LogRequest(a *logical.Auth, r *logical.Request) error {
@@ -38,9 +41,14 @@ func (n *NoopAudit) LogResponse(a *logical.Auth, r *logical.Request, re *logical
 	return n.RespErr
 }
 
+func (n *NoopAudit) Close() error {
+	n.Closed = true
+	return nil
+}
+
 func TestCore_EnableAudit(t *testing.T) {
 	c, key, _ := TestCoreUnsealed(t)
-	c.auditBackends["noop"] = func(map[string]string) (audit.Backend, error) {
+	c.auditBackends["noop"] = func(*audit.BackendConfig) (audit.Backend, error) {
 		return &NoopAudit{}, nil
 	}
 
@@ -62,7 +70,7 @@ func TestCore_EnableAudit(t *testing.T) {
 		AuditBackends: make(map[string]audit.Factory),
 		DisableMlock:  true,
 	}
-	conf.AuditBackends["noop"] = func(map[string]string) (audit.Backend, error) {
+	conf.AuditBackends["noop"] = func(*audit.BackendConfig) (audit.Backend, error) {
 		return &NoopAudit{}, nil
 	}
 	c2, err := NewCore(conf)
@@ -88,9 +96,46 @@ func TestCore_EnableAudit(t *testing.T) {
 	}
 }
 
+func TestCore_EnableAudit_Salt(t *testing.T) {
+	var salts []string
+	factory := func(conf *audit.BackendConfig) (audit.Backend, error) {
+		salts = append(salts, conf.Salt)
+		return &NoopAudit{}, nil
+	}
+
+	c, key, _ := TestCoreUnsealed(t)
+	c.auditBackends["noop"] = factory
+
+	me := &MountEntry{
+		Path: "foo",
+		Type: "noop",
+	}
+	if err := c.enableAudit(me); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	conf := &CoreConfig{
+		Physical:      c.physical,
+		AuditBackends: map[string]audit.Factory{"noop": factory},
+		DisableMlock:  true,
+	}
+	c2, err := NewCore(conf)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if unseal, err := c2.Unseal(key); err != nil || !unseal {
+		t.Fatalf("err: %v %v", unseal, err)
+	}
+
+	// The salt is generated once and reused when the backend is setup again
+	if len(salts) != 2 || salts[0] == "" || salts[0] != salts[1] {
+		t.Fatalf("bad: %#v", salts)
+	}
+}
+
 func TestCore_DisableAudit(t *testing.T) {
 	c, key, _ := TestCoreUnsealed(t)
-	c.auditBackends["noop"] = func(map[string]string) (audit.Backend, error) {
+	c.auditBackends["noop"] = func(*audit.BackendConfig) (audit.Backend, error) {
 		return &NoopAudit{}, nil
 	}
 
@@ -140,6 +185,97 @@ func TestCore_DisableAudit(t *testing.T) {
 	}
 }
 
+func TestCore_MandatoryAudit(t *testing.T) {
+	c, key, _ := TestCoreUnsealed(t)
+
+	noop := &NoopAudit{}
+	conf := &CoreConfig{
+		Physical:      c.physical,
+		AuditBackends: make(map[string]audit.Factory),
+		DisableMlock:  true,
+		MandatoryAudit: &MountEntry{
+			Path: "mandatory",
+			Type: "noop",
+		},
+	}
+	conf.AuditBackends["noop"] = func(*audit.BackendConfig) (audit.Backend, error) {
+		return noop, nil
+	}
+	c2, err := NewCore(conf)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	unseal, err := c2.Unseal(TestKeyCopy(key))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !unseal {
+		t.Fatalf("should be unsealed")
+	}
+
+	// The backend is registered and was verified during unseal
+	if !c2.auditBroker.IsRegistered("mandatory/") {
+		t.Fatalf("missing audit backend")
+	}
+	if len(noop.Req) != 1 || noop.Req[0].Path != "sys/audit/mandatory/" {
+		t.Fatalf("bad: %#v", noop.Req)
+	}
+
+	// The path cannot be reused
+	me := &MountEntry{
+		Path: "mandatory/foo",
+		Type: "noop",
+	}
+	if err := c2.enableAudit(me); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// It is not part of the audit table and cannot be disabled
+	if err := c2.disableAudit("mandatory"); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestCore_MandatoryAudit_Broken(t *testing.T) {
+	c, key, _ := TestCoreUnsealed(t)
+
+	conf := &CoreConfig{
+		Physical:      c.physical,
+		AuditBackends: make(map[string]audit.Factory),
+		DisableMlock:  true,
+		MandatoryAudit: &MountEntry{
+			Path: "mandatory",
+			Type: "noop",
+		},
+	}
+	conf.AuditBackends["noop"] = func(*audit.BackendConfig) (audit.Backend, error) {
+		return &NoopAudit{ReqErr: fmt.Errorf("broken")}, nil
+	}
+	c2, err := NewCore(conf)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A broken audit sink keeps the Vault from becoming active
+	unseal, err := c2.Unseal(TestKeyCopy(key))
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if unseal {
+		t.Fatalf("should not be unsealed")
+	}
+	sealed, err := c2.Sealed()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !sealed {
+		t.Fatalf("should be sealed")
+	}
+	if c2.rollback != nil || c2.expiration != nil || c2.auditBroker != nil {
+		t.Fatalf("partial setup should be torn down")
+	}
+}
+
 func TestCore_DefaultAuditTable(t *testing.T) {
 	c, key, _ := TestCoreUnsealed(t)
 	verifyDefaultAuditTable(t, c.audit)
@@ -183,13 +319,32 @@ func verifyDefaultAuditTable(t *testing.T, table *MountTable) {
 	}
 }
 
+func TestAuditBroker_Deregister_Close(t *testing.T) {
+	l := leveledlog.New(log.New(os.Stderr, "", log.LstdFlags))
+	b := NewAuditBroker(l)
+	a1 := &NoopAudit{}
+	a2 := &NoopAudit{}
+	b.Register("foo", a1, nil, false, nil)
+	b.Register("bar", a2, nil, false, nil)
+
+	b.Deregister("foo")
+	if !a1.Closed || a2.Closed {
+		t.Fatalf("bad: %v %v", a1.Closed, a2.Closed)
+	}
+
+	b.deregisterAll()
+	if !a2.Closed || b.IsRegistered("bar") {
+		t.Fatalf("bad: %v", a2.Closed)
+	}
+}
+
 func TestAuditBroker_LogRequest(t *testing.T) {
-	l := log.New(os.Stderr, "", log.LstdFlags)
+	l := leveledlog.New(log.New(os.Stderr, "", log.LstdFlags))
 	b := NewAuditBroker(l)
 	a1 := &NoopAudit{}
 	a2 := &NoopAudit{}
-	b.Register("foo", a1, nil)
-	b.Register("bar", a2, nil)
+	b.Register("foo", a1, nil, false, nil)
+	b.Register("bar", a2, nil, false, nil)
 
 	auth := &logical.Auth{
 		ClientToken: "foo",
@@ -232,12 +387,12 @@ func TestAuditBroker_LogRequest(t *testing.T) {
 }
 
 func TestAuditBroker_LogResponse(t *testing.T) {
-	l := log.New(os.Stderr, "", log.LstdFlags)
+	l := leveledlog.New(log.New(os.Stderr, "", log.LstdFlags))
 	b := NewAuditBroker(l)
 	a1 := &NoopAudit{}
 	a2 := &NoopAudit{}
-	b.Register("foo", a1, nil)
-	b.Register("bar", a2, nil)
+	b.Register("foo", a1, nil, false, nil)
+	b.Register("bar", a2, nil, false, nil)
 
 	auth := &logical.Auth{
 		ClientToken: "foo",
@@ -298,3 +453,95 @@ func TestAuditBroker_LogResponse(t *testing.T) {
 		t.Fatalf("err: %v", err)
 	}
 }
+
+func TestAuditBroker_Filter(t *testing.T) {
+	l := leveledlog.New(log.New(os.Stderr, "", log.LstdFlags))
+	b := NewAuditBroker(l)
+	a1 := &NoopAudit{}
+	a2 := &NoopAudit{}
+	b.Register("foo", a1, nil, false, &audit.Filter{Omit: []string{"password"}})
+	b.Register("bar", a2, nil, false, nil)
+
+	req := &logical.Request{
+		Operation: logical.WriteOperation,
+		Path:      "secret/foo",
+		Data:      map[string]interface{}{"password": "foo"},
+	}
+	resp := &logical.Response{
+		Data: map[string]interface{}{"password": "foo"},
+	}
+	if err := b.LogRequest(nil, req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := b.LogResponse(nil, req, resp, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Only the backend with the filter has the field omitted
+	if v := a1.Req[0].Data["password"]; v != audit.OmittedMarker {
+		t.Fatalf("bad: %#v", v)
+	}
+	if v := a1.RespReq[0].Data["password"]; v != audit.OmittedMarker {
+		t.Fatalf("bad: %#v", v)
+	}
+	if v := a1.Resp[0].Data["password"]; v != audit.OmittedMarker {
+		t.Fatalf("bad: %#v", v)
+	}
+	if a2.Req[0] != req || a2.Resp[0] != resp {
+		t.Fatalf("bad: %#v %#v", a2.Req[0], a2.Resp[0])
+	}
+	if req.Data["password"] != "foo" || resp.Data["password"] != "foo" {
+		t.Fatalf("bad: %#v %#v", req, resp)
+	}
+}
+
+func TestAuditBroker_BestEffort(t *testing.T) {
+	l := leveledlog.New(log.New(os.Stderr, "", log.LstdFlags))
+	b := NewAuditBroker(l)
+	a1 := &NoopAudit{}
+	a2 := &NoopAudit{}
+	b.Register("foo", a1, nil, false, nil)
+	b.Register("bar", a2, nil, true, nil)
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "sys/mounts",
+	}
+	resp := &logical.Response{}
+
+	// A failing best effort backend is ignored
+	a2.ReqErr = fmt.Errorf("failed")
+	a2.RespErr = fmt.Errorf("failed")
+	if err := b.LogRequest(nil, req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := b.LogResponse(nil, req, resp, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A best effort backend does not stand in for a failing required one
+	a1.ReqErr = fmt.Errorf("failed")
+	a1.RespErr = fmt.Errorf("failed")
+	a2.ReqErr = nil
+	a2.RespErr = nil
+	if err := b.LogRequest(nil, req); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := b.LogResponse(nil, req, resp, nil); err == nil {
+		t.Fatalf("expected error")
+	}
+	if len(a2.Req) != 2 || len(a2.Resp) != 2 {
+		t.Fatalf("bad: %d %d", len(a2.Req), len(a2.Resp))
+	}
+
+	// With only best effort backends, failures never block
+	b.Deregister("foo")
+	a2.ReqErr = fmt.Errorf("failed")
+	a2.RespErr = fmt.Errorf("failed")
+	if err := b.LogRequest(nil, req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := b.LogResponse(nil, req, resp, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
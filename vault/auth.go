@@ -30,8 +30,8 @@ var (
 
 // enableCredential is used to enable a new credential backend
 func (c *Core) enableCredential(entry *MountEntry) error {
-	c.auth.Lock()
-	defer c.auth.Unlock()
+	c.authLock.Lock()
+	defer c.authLock.Unlock()
 
 	// Ensure we end the path in a slash
 	if !strings.HasSuffix(entry.Path, "/") {
@@ -60,6 +60,11 @@ func (c *Core) enableCredential(entry *MountEntry) error {
 		return fmt.Errorf("token credential backend cannot be instantiated")
 	}
 
+	// Verify the auth table is not full
+	if err := c.checkMountLimit(c.auth); err != nil {
+		return err
+	}
+
 	// Lookup the new backend
 	backend, err := c.newCredentialBackend(entry.Type, nil)
 	if err != nil {
@@ -74,6 +79,7 @@ func (c *Core) enableCredential(entry *MountEntry) error {
 	newTable := c.auth.Clone()
 	newTable.Entries = append(newTable.Entries, entry)
 	if err := c.persistAuth(newTable); err != nil {
+		cleanupBackend(backend)
 		return errors.New("failed to update auth table")
 	}
 	c.auth = newTable
@@ -81,17 +87,19 @@ func (c *Core) enableCredential(entry *MountEntry) error {
 	// Mount the backend
 	path := credentialRoutePrefix + entry.Path
 	if err := c.router.Mount(backend, path, entry.UUID, view); err != nil {
+		cleanupBackend(backend)
 		return err
 	}
-	c.logger.Printf("[INFO] core: enabled credential backend '%s' type: %s",
+	c.router.SetMountType(path, entry.Type)
+	c.logger.Info("core: enabled credential backend '%s' type: %s",
 		entry.Path, entry.Type)
 	return nil
 }
 
 // disableCredential is used to disable an existing credential backend
 func (c *Core) disableCredential(path string) error {
-	c.auth.Lock()
-	defer c.auth.Unlock()
+	c.authLock.Lock()
+	defer c.authLock.Unlock()
 
 	// Ensure we end the path in a slash
 	if !strings.HasSuffix(path, "/") {
@@ -141,7 +149,7 @@ func (c *Core) disableCredential(path string) error {
 	if err := c.removeCredEntry(path); err != nil {
 		return err
 	}
-	c.logger.Printf("[INFO] core: disabled credential backend '%s'", path)
+	c.logger.Info("core: disabled credential backend '%s'", path)
 	return nil
 }
 
@@ -178,18 +186,33 @@ func (c *Core) taintCredEntry(path string) error {
 	return nil
 }
 
+// credentialTokenRole returns the token role that the logins of the
+// credential backend at the given path are bound to, if any
+func (c *Core) credentialTokenRole(path string) string {
+	mount := strings.TrimPrefix(c.router.MatchingMount(path), credentialRoutePrefix)
+	c.authLock.RLock()
+	defer c.authLock.RUnlock()
+	if entry := c.auth.Find(mount); entry != nil {
+		return entry.TokenRole
+	}
+	return ""
+}
+
 // loadCredentials is invoked as part of postUnseal to load the auth table
 func (c *Core) loadCredentials() error {
+	c.authLock.Lock()
+	defer c.authLock.Unlock()
+
 	// Load the existing mount table
 	raw, err := c.barrier.Get(coreAuthConfigPath)
 	if err != nil {
-		c.logger.Printf("[ERR] core: failed to read auth table: %v", err)
+		c.logger.Error("core: failed to read auth table: %v", err)
 		return loadAuthFailed
 	}
 	if raw != nil {
 		c.auth = &MountTable{}
 		if err := json.Unmarshal(raw.Value, c.auth); err != nil {
-			c.logger.Printf("[ERR] core: failed to decode auth table: %v", err)
+			c.logger.Error("core: failed to decode auth table: %v", err)
 			return loadAuthFailed
 		}
 	}
@@ -212,7 +235,7 @@ func (c *Core) persistAuth(table *MountTable) error {
 	// Marshal the table
 	raw, err := json.Marshal(table)
 	if err != nil {
-		c.logger.Printf("[ERR] core: failed to encode auth table: %v", err)
+		c.logger.Error("core: failed to encode auth table: %v", err)
 		return err
 	}
 
@@ -224,24 +247,29 @@ func (c *Core) persistAuth(table *MountTable) error {
 
 	// Write to the physical backend
 	if err := c.barrier.Put(entry); err != nil {
-		c.logger.Printf("[ERR] core: failed to persist auth table: %v", err)
+		c.logger.Error("core: failed to persist auth table: %v", err)
 		return err
 	}
+	emitMountCount("auth", table)
 	return nil
 }
 
 // setupCredentials is invoked after we've loaded the auth table to
 // initialize the credential backends and setup the router
 func (c *Core) setupCredentials() error {
+	c.authLock.RLock()
+	defer c.authLock.RUnlock()
+
 	var backend logical.Backend
 	var view *BarrierView
 	var err error
+	emitMountCount("auth", c.auth)
 	for _, entry := range c.auth.Entries {
 		// Initialize the backend
 		backend, err = c.newCredentialBackend(entry.Type, nil)
 		if err != nil {
-			c.logger.Printf(
-				"[ERR] core: failed to create credential entry %#v: %v",
+			c.logger.Error(
+				"core: failed to create credential entry %#v: %v",
 				entry, err)
 			return loadAuthFailed
 		}
@@ -253,9 +281,11 @@ func (c *Core) setupCredentials() error {
 		path := credentialRoutePrefix + entry.Path
 		err = c.router.Mount(backend, path, entry.UUID, view)
 		if err != nil {
-			c.logger.Printf("[ERR] core: failed to mount auth entry %#v: %v", entry, err)
+			cleanupBackend(backend)
+			c.logger.Error("core: failed to mount auth entry %#v: %v", entry, err)
 			return loadAuthFailed
 		}
+		c.router.SetMountType(path, entry.Type)
 
 		// Ensure the path is tainted if set in the mount table
 		if entry.Tainted {
@@ -273,7 +303,9 @@ func (c *Core) setupCredentials() error {
 // teardownCredentials is used before we seal the vault to reset the credential
 // backends to their unloaded state. This is reversed by loadCredentials.
 func (c *Core) teardownCredentials() error {
+	c.authLock.Lock()
 	c.auth = nil
+	c.authLock.Unlock()
 	c.tokenStore = nil
 	return nil
 }
@@ -2,8 +2,11 @@ package vault
 
 import (
 	"errors"
+	"fmt"
+	"io"
 
 	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/physical"
 )
 
 var (
@@ -61,6 +64,27 @@ type SecurityBarrier interface {
 	// be unsealed again to perform any further operations.
 	Seal() error
 
+	// VerifyMaster is used to check that the given key is the master
+	// key, without changing the state of the barrier
+	VerifyMaster(key []byte) error
+
+	// Rotate is used to add a new encryption key, which is used for all
+	// writes from then on while the previous keys remain readable.
+	// The term of the new key is returned.
+	Rotate() (uint32, error)
+
+	// ActiveTerm returns the term of the key used to encrypt
+	ActiveTerm() (uint32, error)
+
+	// ReloadKeyring is used to reload the encryption keys from storage,
+	// to pick up keys added by a rotation on another node
+	ReloadKeyring() error
+
+	// Rewrap is used to re-encrypt the value of a key with the active
+	// key, if it was encrypted with a previous key. It returns whether
+	// the value was rewritten.
+	Rewrap(key string) (bool, error)
+
 	// SecurityBarrier must provide the storage APIs
 	BarrierStorage
 }
@@ -81,6 +105,69 @@ type BarrierStorage interface {
 	List(prefix string) ([]string, error)
 }
 
+// BarrierStreamStorage is an optional interface implemented by a barrier
+// that can store large values as a series of encrypted chunks, so that
+// the whole value never has to be buffered in memory. Values written as
+// a stream are still readable using Get, and Delete removes all of the
+// chunks.
+type BarrierStreamStorage interface {
+	// PutStream is used to insert or update an entry from a reader
+	PutStream(key string, r io.Reader) error
+
+	// GetStream is used to fetch an entry as a reader. The authenticity
+	// of each chunk is verified before its data is returned, and the
+	// reader fails if the chunks were reordered or truncated. A nil
+	// reader is returned if the key does not exist.
+	GetStream(key string) (io.ReadCloser, error)
+}
+
+// BarrierExistenceStorage is an optional interface implemented by a
+// barrier that can check if a key exists without decrypting its value.
+type BarrierExistenceStorage interface {
+	// Exists is used to check if a key exists
+	Exists(key string) (bool, error)
+}
+
+// BarrierTransactional is an optional interface implemented by a barrier
+// that can apply a group of writes atomically, provided the physical
+// backend supports transactions.
+type BarrierTransactional interface {
+	// Transaction is used to apply the operations in order
+	Transaction([]*TxnEntry) error
+}
+
+// TxnEntry is an operation on an entry of the barrier that is applied as
+// part of a transaction. Only the Key of the Entry is used for a delete.
+type TxnEntry struct {
+	Operation physical.TxnOperation
+	Entry     *Entry
+}
+
+// barrierTransaction applies the operations to the barrier. They are
+// applied atomically if the barrier implements BarrierTransactional,
+// otherwise they are applied one at a time in order, stopping at the
+// first failure.
+func barrierTransaction(b BarrierStorage, txns []*TxnEntry) error {
+	if t, ok := b.(BarrierTransactional); ok {
+		return t.Transaction(txns)
+	}
+	for _, txn := range txns {
+		var err error
+		switch txn.Operation {
+		case physical.PutOperation:
+			err = b.Put(txn.Entry)
+		case physical.DeleteOperation:
+			err = b.Delete(txn.Entry.Key)
+		default:
+			err = fmt.Errorf("unknown transaction operation: %s", txn.Operation)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Entry is used to represent data stored by the security barrier
 type Entry struct {
 	Key   string
@@ -4,6 +4,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -15,12 +16,11 @@ import (
 )
 
 const (
-	// keyEpoch is the hard coded key epoch. Eventually, when
-	// a key ring is supported for online key rotation, the epoch
-	// will be incremented and represents the encryption key to use.
+	// keyEpoch is the term of the encryption key created when the
+	// barrier is initialized. Keys added by rotation have higher terms.
 	keyEpoch = 1
 
-	// epochSize is the number of bytes used for the key epoch.
+	// epochSize is the number of bytes used for the key term.
 	epochSize = 4
 
 	// aesgcmVersionByte is prefixed to a message to allow for
@@ -31,7 +31,18 @@ const (
 // barrierInit is the JSON encoded value stored
 type barrierInit struct {
 	Version int    // Version is the current format version
-	Key     []byte // Key is the primary encryption key
+	Key     []byte // Key is the keyring encryption key, of term keyEpoch
+
+	// Terms are the encryption keys added by rotation
+	Terms []*barrierTerm `json:",omitempty"`
+}
+
+// zero is used to clear the keys of the init entry from memory
+func (i *barrierInit) zero() {
+	memzero(i.Key)
+	for _, term := range i.Terms {
+		memzero(term.Key)
+	}
 }
 
 // AESGCMBarrier is a SecurityBarrier implementation that uses the AES
@@ -45,11 +56,25 @@ type AESGCMBarrier struct {
 	l      sync.RWMutex
 	sealed bool
 
-	// primary is the AEAD keyed from the encryption key.
-	// This is the cipher that should be used to encrypt/decrypt
-	// all the underlying values. It will be available if the
-	// barrier is unsealed.
-	primary cipher.AEAD
+	// keyring holds the AEADs keyed from the encryption keys.
+	// The active key is used to encrypt all the underlying values,
+	// and each value is decrypted with the key of its term. It will
+	// be available if the barrier is unsealed.
+	keyring *keyring
+
+	// master is the AEAD keyed from the master key, which is kept
+	// while unsealed to persist the keys added by rotation
+	master cipher.AEAD
+
+	// rotateLock serializes rotations
+	rotateLock sync.Mutex
+
+	// streamKeys holds the keys whose values were written as a stream,
+	// so that the manifest of a value is only read before it is replaced
+	// if it has chunks to remove. It is loaded from the stream markers
+	// when the barrier is unsealed or its keyring is reloaded.
+	streamKeys map[string]struct{}
+	streamLock sync.Mutex
 }
 
 // NewAESGCMBarrier is used to construct a new barrier that uses
@@ -114,7 +139,7 @@ func (b *AESGCMBarrier) Initialize(key []byte) error {
 	defer memzero(buf)
 
 	// Encrypt the barrier init value
-	value := b.encrypt(gcm, buf)
+	value := b.encrypt(newKeyring(keyEpoch, gcm), buf)
 
 	// Create the barrierInitPath
 	pe := &physical.Entry{
@@ -159,45 +184,33 @@ func (b *AESGCMBarrier) Unseal(key []byte) error {
 		return nil
 	}
 
-	// Read the barrier initialization key
-	out, err := b.backend.Get(barrierInitPath)
-	if err != nil {
-		return fmt.Errorf("failed to check for initialization: %v", err)
-	}
-	if out == nil {
-		return ErrBarrierNotInit
-	}
-
 	// Create the AES-GCM
-	gcm, err := b.aeadFromKey(key)
+	master, err := b.aeadFromKey(key)
 	if err != nil {
 		return err
 	}
 
-	// Decrypt the barrier init key
-	plain, err := b.decrypt(gcm, out.Value)
+	// Read the barrier initialization key
+	init, err := b.readInit(master)
 	if err != nil {
-		if strings.Contains(err.Error(), "message authentication failed") {
-			return ErrBarrierInvalidKey
-		}
 		return err
 	}
-	defer memzero(plain)
+	defer init.zero()
 
-	// Unmarshal the barrier init
-	var init barrierInit
-	if err := json.Unmarshal(plain, &init); err != nil {
-		return fmt.Errorf("failed to unmarshal barrier init file")
+	// Initialize the encryption keys
+	keyring, err := b.keyringFromInit(init)
+	if err != nil {
+		return err
 	}
-	defer memzero(init.Key)
 
-	// Initialize the master encryption key
-	b.primary, err = b.aeadFromKey(init.Key)
-	if err != nil {
+	// Load the keys written as a stream
+	if err := b.loadStreamKeys(); err != nil {
 		return err
 	}
 
 	// Set the vault as unsealed
+	b.keyring = keyring
+	b.master = master
 	b.sealed = false
 	return nil
 }
@@ -208,28 +221,231 @@ func (b *AESGCMBarrier) Seal() error {
 	b.l.Lock()
 	defer b.l.Unlock()
 
-	// Remove the primary key, and seal the vault
-	b.primary = nil
+	// Remove the keys, and seal the vault
+	b.keyring = nil
+	b.master = nil
 	b.sealed = true
 	return nil
 }
 
+// VerifyMaster is used to check that the given key is the master key,
+// without changing the state of the barrier
+func (b *AESGCMBarrier) VerifyMaster(key []byte) error {
+	master, err := b.aeadFromKey(key)
+	if err != nil {
+		return err
+	}
+	init, err := b.readInit(master)
+	if err != nil {
+		return err
+	}
+	init.zero()
+	return nil
+}
+
+// Rotate is used to add a new encryption key, which is used for all
+// writes from then on. Values encrypted with the previous keys remain
+// readable, so no value is unreadable at any point during rotation.
+// The term of the new key is returned.
+func (b *AESGCMBarrier) Rotate() (uint32, error) {
+	b.rotateLock.Lock()
+	defer b.rotateLock.Unlock()
+
+	// Persist the new key before using it, so that no value is ever
+	// encrypted with a key that is not stored. The read lock is held so
+	// that requests continue using the current keys meanwhile.
+	b.l.RLock()
+	if b.sealed {
+		b.l.RUnlock()
+		return 0, ErrBarrierSealed
+	}
+	term, keyring, err := b.addTerm(b.master)
+	b.l.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+
+	// Swap in the new keyring, unless sealed in the meantime
+	b.l.Lock()
+	defer b.l.Unlock()
+	if b.sealed {
+		return 0, ErrBarrierSealed
+	}
+	b.keyring = keyring
+	return term, nil
+}
+
+// addTerm is used to generate and persist a new encryption key,
+// returning its term and the keyring it is active in. The init file is
+// read rather than using the keyring in memory, so that keys added by
+// another node are not lost.
+func (b *AESGCMBarrier) addTerm(master cipher.AEAD) (uint32, *keyring, error) {
+	init, err := b.readInit(master)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer init.zero()
+
+	// Generate the key of the next term
+	term := uint32(keyEpoch)
+	for _, t := range init.Terms {
+		if t.Term > term {
+			term = t.Term
+		}
+	}
+	term++
+	key, err := b.GenerateKey()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to generate encryption key: %v", err)
+	}
+	init.Terms = append(init.Terms, &barrierTerm{
+		Term:        term,
+		Key:         key,
+		InstallTime: time.Now().UTC(),
+	})
+
+	keyring, err := b.keyringFromInit(init)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := b.writeInit(master, init); err != nil {
+		return 0, nil, err
+	}
+	return term, keyring, nil
+}
+
+// ActiveTerm returns the term of the key used to encrypt
+func (b *AESGCMBarrier) ActiveTerm() (uint32, error) {
+	b.l.RLock()
+	defer b.l.RUnlock()
+	if b.sealed {
+		return 0, ErrBarrierSealed
+	}
+	term, _ := b.keyring.activeKey()
+	return term, nil
+}
+
+// ReloadKeyring is used to reload the encryption keys from storage,
+// to pick up the keys added by a rotation on another node
+func (b *AESGCMBarrier) ReloadKeyring() error {
+	b.l.Lock()
+	defer b.l.Unlock()
+	if b.sealed {
+		return ErrBarrierSealed
+	}
+
+	init, err := b.readInit(b.master)
+	if err != nil {
+		return err
+	}
+	defer init.zero()
+
+	keyring, err := b.keyringFromInit(init)
+	if err != nil {
+		return err
+	}
+
+	// The streams written by another node are picked up as well
+	if err := b.loadStreamKeys(); err != nil {
+		return err
+	}
+	b.keyring = keyring
+	return nil
+}
+
+// readInit is used to read and decrypt the barrier init entry
+func (b *AESGCMBarrier) readInit(master cipher.AEAD) (*barrierInit, error) {
+	out, err := b.backend.Get(barrierInitPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for initialization: %v", err)
+	}
+	if out == nil {
+		return nil, ErrBarrierNotInit
+	}
+
+	// Decrypt the barrier init key
+	plain, err := b.decrypt(newKeyring(keyEpoch, master), out.Value)
+	if err != nil {
+		if strings.Contains(err.Error(), "message authentication failed") {
+			return nil, ErrBarrierInvalidKey
+		}
+		return nil, err
+	}
+	defer memzero(plain)
+
+	// Unmarshal the barrier init
+	var init barrierInit
+	if err := json.Unmarshal(plain, &init); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal barrier init file")
+	}
+	return &init, nil
+}
+
+// writeInit is used to encrypt and store the barrier init entry
+func (b *AESGCMBarrier) writeInit(master cipher.AEAD, init *barrierInit) error {
+	buf, err := json.Marshal(init)
+	if err != nil {
+		return fmt.Errorf("failed to create barrier entry: %v", err)
+	}
+	defer memzero(buf)
+
+	pe := &physical.Entry{
+		Key:   barrierInitPath,
+		Value: b.encrypt(newKeyring(keyEpoch, master), buf),
+	}
+	if err := b.backend.Put(pe); err != nil {
+		return fmt.Errorf("failed to persist keyring: %v", err)
+	}
+	return nil
+}
+
+// keyringFromInit is used to create the keyring from the encryption
+// keys of the barrier init entry
+func (b *AESGCMBarrier) keyringFromInit(init *barrierInit) (*keyring, error) {
+	gcm, err := b.aeadFromKey(init.Key)
+	if err != nil {
+		return nil, err
+	}
+	keyring := newKeyring(keyEpoch, gcm)
+	for _, term := range init.Terms {
+		gcm, err := b.aeadFromKey(term.Key)
+		if err != nil {
+			return nil, err
+		}
+		keyring = keyring.addKey(term.Term, gcm)
+	}
+	return keyring, nil
+}
+
 // Put is used to insert or update an entry
 func (b *AESGCMBarrier) Put(entry *Entry) error {
 	defer metrics.MeasureSince([]string{"barrier", "put"}, time.Now())
 	b.l.RLock()
 	defer b.l.RUnlock()
 
-	primary := b.primary
-	if primary == nil {
+	keyring := b.keyring
+	if keyring == nil {
 		return ErrBarrierSealed
 	}
 
+	// Load any existing manifest so the chunks of a value that was
+	// written as a stream can be removed
+	manifest, err := b.existingStreamManifest(keyring, entry.Key)
+	if err != nil {
+		return err
+	}
+
 	pe := &physical.Entry{
 		Key:   entry.Key,
-		Value: b.encrypt(primary, entry.Value),
+		Value: b.encrypt(keyring, entry.Value),
+	}
+	if err := b.backend.Put(pe); err != nil {
+		return err
 	}
-	return b.backend.Put(pe)
+	if manifest != nil {
+		return b.removeStream(entry.Key, manifest)
+	}
+	return nil
 }
 
 // Get is used to fetch an entry
@@ -238,8 +454,8 @@ func (b *AESGCMBarrier) Get(key string) (*Entry, error) {
 	b.l.RLock()
 	defer b.l.RUnlock()
 
-	primary := b.primary
-	if primary == nil {
+	keyring := b.keyring
+	if keyring == nil {
 		return nil, ErrBarrierSealed
 	}
 
@@ -251,8 +467,13 @@ func (b *AESGCMBarrier) Get(key string) (*Entry, error) {
 		return nil, nil
 	}
 
+	// Reassemble the value if it was written as a stream
+	if isStreamManifest(pe.Value) {
+		return b.getStreamEntry(keyring, key, pe.Value)
+	}
+
 	// Decrypt the ciphertext
-	plain, err := b.decrypt(primary, pe.Value)
+	plain, err := b.decrypt(keyring, pe.Value)
 	if err != nil {
 		return nil, fmt.Errorf("decryption failed: %v", err)
 	}
@@ -270,11 +491,147 @@ func (b *AESGCMBarrier) Delete(key string) error {
 	defer metrics.MeasureSince([]string{"barrier", "delete"}, time.Now())
 	b.l.RLock()
 	defer b.l.RUnlock()
-	if b.sealed {
+
+	keyring := b.keyring
+	if keyring == nil {
+		return ErrBarrierSealed
+	}
+
+	// Clear any chunks if the value was written as a stream
+	manifest, err := b.existingStreamManifest(keyring, key)
+	if err != nil {
+		return err
+	}
+	if err := b.backend.Delete(key); err != nil {
+		return err
+	}
+	if manifest != nil {
+		return b.removeStream(key, manifest)
+	}
+	return nil
+}
+
+// Transaction is used to apply the operations with a single physical
+// transaction, which is atomic if the physical backend supports it
+func (b *AESGCMBarrier) Transaction(txns []*TxnEntry) error {
+	defer metrics.MeasureSince([]string{"barrier", "transaction"}, time.Now())
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	keyring := b.keyring
+	if keyring == nil {
 		return ErrBarrierSealed
 	}
 
-	return b.backend.Delete(key)
+	// Load the manifests of any values that were written as a stream,
+	// their chunks are removed once the transaction is applied
+	manifests := make(map[string]*streamManifest)
+	ptxns := make([]*physical.TxnEntry, 0, len(txns))
+	for _, txn := range txns {
+		if txn == nil || txn.Entry == nil {
+			return fmt.Errorf("transaction entry is missing")
+		}
+		manifest, err := b.existingStreamManifest(keyring, txn.Entry.Key)
+		if err != nil {
+			return err
+		}
+		if manifest != nil {
+			manifests[txn.Entry.Key] = manifest
+		}
+
+		pe := &physical.Entry{Key: txn.Entry.Key}
+		if txn.Operation == physical.PutOperation {
+			pe.Value = b.encrypt(keyring, txn.Entry.Value)
+		}
+		ptxns = append(ptxns, &physical.TxnEntry{
+			Operation: txn.Operation,
+			Entry:     pe,
+		})
+	}
+
+	if err := physical.Transaction(b.backend, ptxns); err != nil {
+		return err
+	}
+	for key, manifest := range manifests {
+		if err := b.removeStream(key, manifest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rewrap is used to re-encrypt the value of a key with the active key,
+// if it was encrypted with a previous key. The chunks of a value written
+// as a stream are re-encrypted as well. Keys that are not encrypted with
+// the keyring, such as the init entry, are left untouched.
+func (b *AESGCMBarrier) Rewrap(key string) (bool, error) {
+	defer metrics.MeasureSince([]string{"barrier", "rewrap"}, time.Now())
+
+	// The write lock is held so that the value cannot be replaced
+	// while it is rewritten
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	keyring := b.keyring
+	if keyring == nil {
+		return false, ErrBarrierSealed
+	}
+	if key == barrierInitPath || strings.HasPrefix(key, barrierChunkPrefix) ||
+		strings.HasPrefix(key, barrierStreamPrefix) {
+		return false, nil
+	}
+
+	pe, err := b.backend.Get(key)
+	if err != nil {
+		return false, err
+	}
+	if pe == nil || len(pe.Value) <= epochSize {
+		return false, nil
+	}
+
+	switch pe.Value[epochSize] {
+	case aesgcmVersionByte:
+		active, _ := keyring.activeKey()
+		if valueTerm(pe.Value) == active {
+			return false, nil
+		}
+		plain, err := b.decrypt(keyring, pe.Value)
+		if err != nil {
+			return false, fmt.Errorf("decryption failed: %v", err)
+		}
+		out := &physical.Entry{
+			Key:   key,
+			Value: b.encrypt(keyring, plain),
+		}
+		if err := b.backend.Put(out); err != nil {
+			return false, err
+		}
+		return true, nil
+
+	case aesgcmStreamVersionByte:
+		return b.rewrapStream(keyring, key, pe.Value)
+
+	default:
+		return false, nil
+	}
+}
+
+// Exists is used to check if a key exists. The value is not decrypted,
+// so this is cheaper than a Get but does not verify the value.
+func (b *AESGCMBarrier) Exists(key string) (bool, error) {
+	defer metrics.MeasureSince([]string{"barrier", "exists"}, time.Now())
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	if b.keyring == nil {
+		return false, ErrBarrierSealed
+	}
+
+	pe, err := b.backend.Get(key)
+	if err != nil {
+		return false, err
+	}
+	return pe != nil, nil
 }
 
 // List is used ot list all the keys under a given
@@ -307,37 +664,59 @@ func (b *AESGCMBarrier) aeadFromKey(key []byte) (cipher.AEAD, error) {
 }
 
 // encrypt is used to encrypt a value
-func (b *AESGCMBarrier) encrypt(gcm cipher.AEAD, plain []byte) []byte {
-	// Allocate the output buffer with room for epoch, version byte,
+func (b *AESGCMBarrier) encrypt(keyring *keyring, plain []byte) []byte {
+	return b.encryptVersion(keyring, aesgcmVersionByte, plain, nil)
+}
+
+// encryptVersion is used to encrypt a value with the active key, the
+// given version byte and additional authenticated data
+func (b *AESGCMBarrier) encryptVersion(keyring *keyring, version byte, plain, aad []byte) []byte {
+	term, gcm := keyring.activeKey()
+
+	// Allocate the output buffer with room for term, version byte,
 	// nonce, GCM tag and the plaintext
 	capacity := epochSize + 1 + gcm.NonceSize() + gcm.Overhead() + len(plain)
 	size := epochSize + 1 + gcm.NonceSize()
 	out := make([]byte, size, capacity)
 
-	// Set the epoch to 1
-	out[3] = keyEpoch
+	// Set the term of the key
+	binary.BigEndian.PutUint32(out[:epochSize], term)
 
 	// Set the version byte
-	out[4] = aesgcmVersionByte
+	out[4] = version
 
 	// Generate a random nonce
 	nonce := out[5 : 5+gcm.NonceSize()]
 	rand.Read(nonce)
 
 	// Seal the output
-	out = gcm.Seal(out, nonce, plain, nil)
+	out = gcm.Seal(out, nonce, plain, aad)
 	return out
 }
 
+// valueTerm returns the term of the key an encrypted value was
+// encrypted with
+func valueTerm(cipher []byte) uint32 {
+	return binary.BigEndian.Uint32(cipher[:epochSize])
+}
+
 // decrypt is used to decrypt a value
-func (b *AESGCMBarrier) decrypt(gcm cipher.AEAD, cipher []byte) ([]byte, error) {
-	// Verify the epoch
-	if cipher[0] != 0 || cipher[1] != 0 || cipher[2] != 0 || cipher[3] != keyEpoch {
-		return nil, fmt.Errorf("epoch mis-match")
+func (b *AESGCMBarrier) decrypt(keyring *keyring, cipher []byte) ([]byte, error) {
+	return b.decryptVersion(keyring, aesgcmVersionByte, cipher, nil)
+}
+
+// decryptVersion is used to decrypt a value with the key of its term,
+// the given version byte and additional authenticated data
+func (b *AESGCMBarrier) decryptVersion(keyring *keyring, version byte, cipher, aad []byte) ([]byte, error) {
+	// Lookup the key the value was encrypted with
+	term := valueTerm(cipher)
+	gcm := keyring.termKey(term)
+	if gcm == nil {
+		return nil, fmt.Errorf("no encryption key for term %d", term)
 	}
 
 	// Verify the version byte
-	if cipher[4] != aesgcmVersionByte {
+	if cipher[4] != version {
 		return nil, fmt.Errorf("version bytes mis-match")
 	}
 
@@ -347,5 +726,5 @@ func (b *AESGCMBarrier) decrypt(gcm cipher.AEAD, cipher []byte) ([]byte, error)
 	out := make([]byte, 0, len(raw)-gcm.NonceSize())
 
 	// Attempt to open
-	return gcm.Open(out, nonce, raw, nil)
+	return gcm.Open(out, nonce, raw, aad)
 }
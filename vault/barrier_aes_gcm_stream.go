@@ -0,0 +1,457 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/physical"
+)
+
+const (
+	// aesgcmStreamVersionByte is the version byte used for the manifest
+	// of a value written as a stream. The manifest is stored in place of
+	// the value and describes the chunks.
+	aesgcmStreamVersionByte = 0x2
+
+	// aesgcmChunkVersionByte is the version byte used for each chunk
+	// of a value written as a stream.
+	aesgcmChunkVersionByte = 0x3
+
+	// barrierChunkPrefix is the prefix used to store the chunks of
+	// values written as a stream. Chunks are keyed by the stream ID so
+	// that a value can be replaced without clobbering the old chunks
+	// until the new manifest is in place.
+	barrierChunkPrefix = "barrier/chunk/"
+
+	// barrierStreamPrefix is the prefix used to mark the keys of values
+	// written as a stream. The markers are empty and unencrypted, as the
+	// keys are not encrypted either.
+	barrierStreamPrefix = "barrier/stream/"
+
+	// streamChunkSize is the size of the plaintext in each chunk
+	streamChunkSize = 64 * 1024
+)
+
+// streamManifest describes a value that was written as a stream
+type streamManifest struct {
+	ID     string `json:"id"`
+	Chunks int    `json:"chunks"`
+	Size   int64  `json:"size"`
+}
+
+// chunkKey returns the physical key of the given chunk
+func (m *streamManifest) chunkKey(index int) string {
+	return fmt.Sprintf("%s%s/%d", barrierChunkPrefix, m.ID, index)
+}
+
+// chunkAAD returns the additional data authenticated with a chunk. This
+// binds the chunk to its stream and position, and marks the final chunk
+// so that reordered or truncated chunks fail verification.
+func (m *streamManifest) chunkAAD(index int, last bool) []byte {
+	return []byte(fmt.Sprintf("%s/%d/%v", m.ID, index, last))
+}
+
+// isStreamManifest checks if a physical value is a stream manifest
+func isStreamManifest(value []byte) bool {
+	return len(value) > epochSize && value[epochSize] == aesgcmStreamVersionByte
+}
+
+// PutStream is used to insert or update an entry from a reader. The
+// value is encrypted in chunks so that only a bounded amount of it is
+// held in memory.
+func (b *AESGCMBarrier) PutStream(key string, r io.Reader) error {
+	defer metrics.MeasureSince([]string{"barrier", "put_stream"}, time.Now())
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	keyring := b.keyring
+	if keyring == nil {
+		return ErrBarrierSealed
+	}
+
+	// Load any existing manifest so the old chunks can be removed
+	old, err := b.existingStreamManifest(keyring, key)
+	if err != nil {
+		return err
+	}
+
+	// Mark the key before any chunk is written, so that the chunks are
+	// found if the value is replaced
+	if err := b.markStream(key); err != nil {
+		return err
+	}
+
+	// Write out the chunks. We read one chunk ahead so that the final
+	// chunk can be marked as such.
+	manifest := &streamManifest{ID: generateUUID()}
+	cur := make([]byte, streamChunkSize)
+	next := make([]byte, streamChunkSize)
+	n, err := readStreamChunk(r, cur)
+	if err != nil {
+		return err
+	}
+	for {
+		m, err := readStreamChunk(r, next)
+		if err != nil {
+			b.deleteStreamChunks(manifest)
+			return err
+		}
+		last := m == 0
+
+		pe := &physical.Entry{
+			Key: manifest.chunkKey(manifest.Chunks),
+			Value: b.encryptVersion(keyring, aesgcmChunkVersionByte,
+				cur[:n], manifest.chunkAAD(manifest.Chunks, last)),
+		}
+		if err := b.backend.Put(pe); err != nil {
+			b.deleteStreamChunks(manifest)
+			return err
+		}
+		manifest.Chunks++
+		manifest.Size += int64(n)
+
+		if last {
+			break
+		}
+		cur, next = next, cur
+		n = m
+	}
+
+	// Write the manifest in place of the value
+	buf, err := json.Marshal(manifest)
+	if err != nil {
+		b.deleteStreamChunks(manifest)
+		return fmt.Errorf("failed to encode stream manifest: %v", err)
+	}
+	pe := &physical.Entry{
+		Key:   key,
+		Value: b.encryptVersion(keyring, aesgcmStreamVersionByte, buf, []byte(key)),
+	}
+	if err := b.backend.Put(pe); err != nil {
+		b.deleteStreamChunks(manifest)
+		return err
+	}
+
+	// Remove the chunks of the value we replaced
+	if old != nil {
+		return b.deleteStreamChunks(old)
+	}
+	return nil
+}
+
+// GetStream is used to fetch an entry as a reader. Each chunk is read
+// and verified as the reader reaches it, so a value that was tampered
+// with may be partially read before the reader returns an error.
+func (b *AESGCMBarrier) GetStream(key string) (io.ReadCloser, error) {
+	defer metrics.MeasureSince([]string{"barrier", "get_stream"}, time.Now())
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	keyring := b.keyring
+	if keyring == nil {
+		return nil, ErrBarrierSealed
+	}
+
+	// Read the key from the backend
+	pe, err := b.backend.Get(key)
+	if err != nil {
+		return nil, err
+	} else if pe == nil {
+		return nil, nil
+	}
+
+	// Values that were not written as a stream are returned whole
+	if !isStreamManifest(pe.Value) {
+		plain, err := b.decrypt(keyring, pe.Value)
+		if err != nil {
+			return nil, fmt.Errorf("decryption failed: %v", err)
+		}
+		return ioutil.NopCloser(bytes.NewReader(plain)), nil
+	}
+
+	manifest, err := b.decodeStreamManifest(keyring, key, pe.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &streamReader{
+		barrier:  b,
+		manifest: manifest,
+	}
+	return r, nil
+}
+
+// getStreamEntry is used to reassemble a value written as a stream
+// into a single entry
+func (b *AESGCMBarrier) getStreamEntry(keyring *keyring, key string, value []byte) (*Entry, error) {
+	manifest, err := b.decodeStreamManifest(keyring, key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	plain := make([]byte, 0, manifest.Size)
+	for i := 0; i < manifest.Chunks; i++ {
+		chunk, err := b.readChunk(keyring, manifest, i)
+		if err != nil {
+			return nil, err
+		}
+		plain = append(plain, chunk...)
+	}
+	if int64(len(plain)) != manifest.Size {
+		return nil, fmt.Errorf("stream size mis-match")
+	}
+
+	entry := &Entry{
+		Key:   key,
+		Value: plain,
+	}
+	return entry, nil
+}
+
+// readStreamManifest is used to read the manifest for a key, returning
+// nil if the key does not exist or was not written as a stream
+func (b *AESGCMBarrier) readStreamManifest(keyring *keyring, key string) (*streamManifest, error) {
+	pe, err := b.backend.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if pe == nil || !isStreamManifest(pe.Value) {
+		return nil, nil
+	}
+	return b.decodeStreamManifest(keyring, key, pe.Value)
+}
+
+// existingStreamManifest is used to read the manifest for a key before
+// its value is replaced. The value is only read if the key is marked as
+// written as a stream.
+func (b *AESGCMBarrier) existingStreamManifest(keyring *keyring, key string) (*streamManifest, error) {
+	b.streamLock.Lock()
+	_, ok := b.streamKeys[key]
+	b.streamLock.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	return b.readStreamManifest(keyring, key)
+}
+
+// markStream is used to mark a key as written as a stream
+func (b *AESGCMBarrier) markStream(key string) error {
+	b.streamLock.Lock()
+	defer b.streamLock.Unlock()
+	if _, ok := b.streamKeys[key]; ok {
+		return nil
+	}
+	pe := &physical.Entry{Key: barrierStreamPrefix + key, Value: []byte{}}
+	if err := b.backend.Put(pe); err != nil {
+		return fmt.Errorf("failed to mark stream: %v", err)
+	}
+	b.streamKeys[key] = struct{}{}
+	return nil
+}
+
+// removeStream is used to remove the chunks of a value written as a
+// stream once it has been replaced, along with the mark of its key
+func (b *AESGCMBarrier) removeStream(key string, m *streamManifest) error {
+	if err := b.deleteStreamChunks(m); err != nil {
+		return err
+	}
+	b.streamLock.Lock()
+	defer b.streamLock.Unlock()
+	if err := b.backend.Delete(barrierStreamPrefix + key); err != nil {
+		return fmt.Errorf("failed to remove stream mark: %v", err)
+	}
+	delete(b.streamKeys, key)
+	return nil
+}
+
+// loadStreamKeys is used to load the keys marked as written as a stream.
+// This must be called with the write lock held.
+func (b *AESGCMBarrier) loadStreamKeys() error {
+	keys := make(map[string]struct{})
+	var walk func(prefix string) error
+	walk = func(prefix string) error {
+		subs, err := b.backend.List(barrierStreamPrefix + prefix)
+		if err != nil {
+			return fmt.Errorf("failed to list stream marks: %v", err)
+		}
+		for _, sub := range subs {
+			if strings.HasSuffix(sub, "/") {
+				if err := walk(prefix + sub); err != nil {
+					return err
+				}
+				continue
+			}
+			keys[prefix+sub] = struct{}{}
+		}
+		return nil
+	}
+	if err := walk(""); err != nil {
+		return err
+	}
+
+	b.streamLock.Lock()
+	b.streamKeys = keys
+	b.streamLock.Unlock()
+	return nil
+}
+
+// decodeStreamManifest is used to decrypt and decode a stream manifest
+func (b *AESGCMBarrier) decodeStreamManifest(keyring *keyring, key string, value []byte) (*streamManifest, error) {
+	buf, err := b.decryptVersion(keyring, aesgcmStreamVersionByte, value, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: %v", err)
+	}
+
+	manifest := new(streamManifest)
+	if err := json.Unmarshal(buf, manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode stream manifest: %v", err)
+	}
+	return manifest, nil
+}
+
+// readChunk is used to read and decrypt a single chunk
+func (b *AESGCMBarrier) readChunk(keyring *keyring, m *streamManifest, index int) ([]byte, error) {
+	pe, err := b.backend.Get(m.chunkKey(index))
+	if err != nil {
+		return nil, err
+	}
+	if pe == nil {
+		return nil, fmt.Errorf("missing stream chunk %d", index)
+	}
+
+	last := index == m.Chunks-1
+	plain, err := b.decryptVersion(keyring, aesgcmChunkVersionByte,
+		pe.Value, m.chunkAAD(index, last))
+	if err != nil {
+		return nil, fmt.Errorf("decryption of chunk %d failed: %v", index, err)
+	}
+	return plain, nil
+}
+
+// rewrapStream is used to re-encrypt the chunks and the manifest of a
+// value written as a stream with the active key. This must be called
+// with the write lock held.
+func (b *AESGCMBarrier) rewrapStream(keyring *keyring, key string, value []byte) (bool, error) {
+	manifest, err := b.decodeStreamManifest(keyring, key, value)
+	if err != nil {
+		return false, err
+	}
+
+	active, _ := keyring.activeKey()
+	rewrapped := false
+	for i := 0; i < manifest.Chunks; i++ {
+		pe, err := b.backend.Get(manifest.chunkKey(i))
+		if err != nil {
+			return rewrapped, err
+		}
+		if pe == nil {
+			return rewrapped, fmt.Errorf("missing stream chunk %d", i)
+		}
+		if valueTerm(pe.Value) == active {
+			continue
+		}
+
+		aad := manifest.chunkAAD(i, i == manifest.Chunks-1)
+		plain, err := b.decryptVersion(keyring, aesgcmChunkVersionByte, pe.Value, aad)
+		if err != nil {
+			return rewrapped, fmt.Errorf("decryption of chunk %d failed: %v", i, err)
+		}
+		out := &physical.Entry{
+			Key:   pe.Key,
+			Value: b.encryptVersion(keyring, aesgcmChunkVersionByte, plain, aad),
+		}
+		if err := b.backend.Put(out); err != nil {
+			return rewrapped, err
+		}
+		rewrapped = true
+	}
+
+	if valueTerm(value) == active {
+		return rewrapped, nil
+	}
+	buf, err := json.Marshal(manifest)
+	if err != nil {
+		return rewrapped, fmt.Errorf("failed to encode stream manifest: %v", err)
+	}
+	out := &physical.Entry{
+		Key:   key,
+		Value: b.encryptVersion(keyring, aesgcmStreamVersionByte, buf, []byte(key)),
+	}
+	if err := b.backend.Put(out); err != nil {
+		return rewrapped, err
+	}
+	return true, nil
+}
+
+// deleteStreamChunks is used to remove all the chunks of a stream
+func (b *AESGCMBarrier) deleteStreamChunks(m *streamManifest) error {
+	for i := 0; i < m.Chunks; i++ {
+		if err := b.backend.Delete(m.chunkKey(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readStreamChunk is used to fill the buffer from the reader, returning
+// less than a full buffer only at the end of the stream
+func readStreamChunk(r io.Reader, buf []byte) (int, error) {
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return n, err
+}
+
+// streamReader is used to read a value written as a stream, decrypting
+// one chunk at a time.
+type streamReader struct {
+	barrier  *AESGCMBarrier
+	manifest *streamManifest
+	next     int
+	read     int64
+	buf      []byte
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.next >= r.manifest.Chunks {
+			if r.read != r.manifest.Size {
+				return 0, fmt.Errorf("stream size mis-match")
+			}
+			return 0, io.EOF
+		}
+
+		// Ensure the barrier was not sealed while reading
+		r.barrier.l.RLock()
+		keyring := r.barrier.keyring
+		var err error
+		if keyring == nil {
+			err = ErrBarrierSealed
+		} else {
+			r.buf, err = r.barrier.readChunk(keyring, r.manifest, r.next)
+		}
+		r.barrier.l.RUnlock()
+		if err != nil {
+			return 0, err
+		}
+		r.next++
+		r.read += int64(len(r.buf))
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *streamReader) Close() error {
+	r.buf = nil
+	r.next = r.manifest.Chunks
+	return nil
+}
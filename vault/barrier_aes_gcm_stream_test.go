@@ -0,0 +1,357 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/physical"
+)
+
+func mockStreamBarrier(t *testing.T) (physical.Backend, *AESGCMBarrier) {
+	inm, b, _ := mockBarrier(t)
+	return inm, b.(*AESGCMBarrier)
+}
+
+func testChunkKeys(t *testing.T, inm physical.Backend) []string {
+	keys, err := inm.List(barrierChunkPrefix)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var out []string
+	for _, stream := range keys {
+		chunks, err := inm.List(barrierChunkPrefix + stream)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		for _, chunk := range chunks {
+			out = append(out, barrierChunkPrefix+stream+chunk)
+		}
+	}
+	return out
+}
+
+// testReadStream reads the whole stream of the key, returning any error
+func testReadStream(b *AESGCMBarrier, key string) error {
+	r, err := b.GetStream(key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = ioutil.ReadAll(r)
+	return err
+}
+
+// countingBackend counts the reads of the keys of a physical backend
+type countingBackend struct {
+	physical.Backend
+
+	l    sync.Mutex
+	gets map[string]int
+}
+
+func (c *countingBackend) Get(key string) (*physical.Entry, error) {
+	c.l.Lock()
+	c.gets[key]++
+	c.l.Unlock()
+	return c.Backend.Get(key)
+}
+
+func TestAESGCMBarrier_Stream(t *testing.T) {
+	sizes := map[int]int{
+		0:                        1,
+		1:                        1,
+		streamChunkSize:          1,
+		streamChunkSize + 1:      2,
+		3*streamChunkSize - 7:    3,
+		3*streamChunkSize + 1000: 4,
+	}
+	for size, chunks := range sizes {
+		inm, b := mockStreamBarrier(t)
+
+		value := make([]byte, size)
+		rand.Read(value)
+		if err := b.PutStream("test", bytes.NewReader(value)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		if keys := testChunkKeys(t, inm); len(keys) != chunks {
+			t.Fatalf("bad: %d %v", size, keys)
+		}
+
+		// Read back as a stream
+		r, err := b.GetStream("test")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		out, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if !bytes.Equal(out, value) {
+			t.Fatalf("bad: %d", size)
+		}
+
+		// Read back as a whole value
+		entry, err := b.Get("test")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if entry == nil || !bytes.Equal(entry.Value, value) {
+			t.Fatalf("bad: %d", size)
+		}
+
+		// Keys should only show the value itself
+		keys, err := b.List("")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if len(keys) != 2 || keys[0] != "barrier/" || keys[1] != "test" {
+			t.Fatalf("bad: %v", keys)
+		}
+
+		// Delete should remove the chunks
+		if err := b.Delete("test"); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if keys := testChunkKeys(t, inm); len(keys) != 0 {
+			t.Fatalf("bad: %v", keys)
+		}
+		if entry, _ := b.Get("test"); entry != nil {
+			t.Fatalf("bad: %#v", entry)
+		}
+	}
+}
+
+func TestAESGCMBarrier_Stream_Missing(t *testing.T) {
+	_, b := mockStreamBarrier(t)
+	r, err := b.GetStream("test")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if r != nil {
+		t.Fatalf("bad: %#v", r)
+	}
+}
+
+func TestAESGCMBarrier_Stream_NotStreamed(t *testing.T) {
+	_, b := mockStreamBarrier(t)
+	if err := b.Put(&Entry{Key: "test", Value: []byte("foo")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	r, err := b.GetStream("test")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(out) != "foo" {
+		t.Fatalf("bad: %s", out)
+	}
+}
+
+func TestAESGCMBarrier_Stream_Replace(t *testing.T) {
+	inm, b := mockStreamBarrier(t)
+	value := strings.Repeat("a", 2*streamChunkSize)
+	if err := b.PutStream("test", strings.NewReader(value)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Replacing with another stream removes the old chunks
+	if err := b.PutStream("test", strings.NewReader("foo")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if keys := testChunkKeys(t, inm); len(keys) != 1 {
+		t.Fatalf("bad: %v", keys)
+	}
+
+	// Replacing with a regular value removes the chunks
+	if err := b.Put(&Entry{Key: "test", Value: []byte("bar")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if keys := testChunkKeys(t, inm); len(keys) != 0 {
+		t.Fatalf("bad: %v", keys)
+	}
+	entry, err := b.Get("test")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(entry.Value) != "bar" {
+		t.Fatalf("bad: %#v", entry)
+	}
+}
+
+func TestAESGCMBarrier_Stream_Integrity(t *testing.T) {
+	inm, b := mockStreamBarrier(t)
+	value := strings.Repeat("a", 3*streamChunkSize)
+	if err := b.PutStream("test", strings.NewReader(value)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	keys := testChunkKeys(t, inm)
+
+	// Tamper with the last chunk
+	pe, _ := inm.Get(keys[len(keys)-1])
+	pe.Value[len(pe.Value)-1]++
+	inm.Put(pe)
+
+	if err := testReadStream(b, "test"); err == nil {
+		t.Fatalf("should fail")
+	}
+	if _, err := b.Get("test"); err == nil {
+		t.Fatalf("should fail")
+	}
+}
+
+func TestAESGCMBarrier_Stream_Reorder(t *testing.T) {
+	inm, b := mockStreamBarrier(t)
+	value := strings.Repeat("a", streamChunkSize) + strings.Repeat("b", streamChunkSize)
+	if err := b.PutStream("test", strings.NewReader(value)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Swap the two chunks
+	keys := testChunkKeys(t, inm)
+	first, _ := inm.Get(keys[0])
+	second, _ := inm.Get(keys[1])
+	first.Key, second.Key = second.Key, first.Key
+	inm.Put(first)
+	inm.Put(second)
+
+	if err := testReadStream(b, "test"); err == nil {
+		t.Fatalf("should fail")
+	}
+}
+
+func TestAESGCMBarrier_Stream_Truncate(t *testing.T) {
+	inm, b := mockStreamBarrier(t)
+	value := strings.Repeat("a", 2*streamChunkSize+1)
+	if err := b.PutStream("test", strings.NewReader(value)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Remove the final chunk
+	keys := testChunkKeys(t, inm)
+	inm.Delete(keys[len(keys)-1])
+
+	if err := testReadStream(b, "test"); err == nil {
+		t.Fatalf("should fail")
+	}
+}
+
+func TestAESGCMBarrier_Stream_Rewrap(t *testing.T) {
+	inm, b := mockStreamBarrier(t)
+
+	value := make([]byte, 2*streamChunkSize+10)
+	rand.Read(value)
+	if err := b.PutStream("test", bytes.NewReader(value)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := b.Rotate(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	rewrapped, err := b.Rewrap("test")
+	if err != nil || !rewrapped {
+		t.Fatalf("bad: %v %v", rewrapped, err)
+	}
+
+	// The manifest and every chunk use the new key
+	keys := append(testChunkKeys(t, inm), "test")
+	if len(keys) != 4 {
+		t.Fatalf("bad: %v", keys)
+	}
+	for _, key := range keys {
+		pe, err := inm.Get(key)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if term := valueTerm(pe.Value); term != 2 {
+			t.Fatalf("bad %s: %d", key, term)
+		}
+	}
+
+	out, err := b.Get("test")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(out.Value, value) {
+		t.Fatalf("bad value")
+	}
+
+	rewrapped, err = b.Rewrap("test")
+	if err != nil || rewrapped {
+		t.Fatalf("bad: %v %v", rewrapped, err)
+	}
+}
+
+func TestAESGCMBarrier_Stream_ReadOnce(t *testing.T) {
+	inm := &countingBackend{
+		Backend: physical.NewInmem(),
+		gets:    make(map[string]int),
+	}
+	b, err := NewAESGCMBarrier(inm)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	key, _ := b.GenerateKey()
+	b.Initialize(key)
+	b.Unseal(key)
+
+	value := strings.Repeat("a", 3*streamChunkSize)
+	if err := b.PutStream("test", strings.NewReader(value)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := b.Put(&Entry{Key: "plain", Value: []byte("bar")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The value of a key that was never streamed is not read to look
+	// for a manifest
+	if n := inm.gets["plain"]; n != 0 {
+		t.Fatalf("bad: %d", n)
+	}
+
+	// Each chunk is read once
+	if err := testReadStream(b, "test"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, key := range testChunkKeys(t, inm.Backend) {
+		if n := inm.gets[key]; n != 1 {
+			t.Fatalf("bad: %s %d", key, n)
+		}
+	}
+}
+
+func TestAESGCMBarrier_Stream_Unseal(t *testing.T) {
+	inm, b, key := mockBarrier(t)
+	if err := b.(*AESGCMBarrier).PutStream("sub/test", strings.NewReader("foo")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The streamed keys are known again after unsealing
+	b2, err := NewAESGCMBarrier(inm)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := b2.Unseal(key); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Replacing the value removes the chunks and the mark
+	if err := b2.Put(&Entry{Key: "sub/test", Value: []byte("bar")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if keys := testChunkKeys(t, inm); len(keys) != 0 {
+		t.Fatalf("bad: %v", keys)
+	}
+	if marks, _ := inm.List(barrierStreamPrefix + "sub/"); len(marks) != 0 {
+		t.Fatalf("bad: %v", marks)
+	}
+}
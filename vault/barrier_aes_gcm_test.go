@@ -2,6 +2,9 @@ package vault
 
 import (
 	"bytes"
+	"fmt"
+	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/hashicorp/vault/physical"
@@ -102,3 +105,263 @@ func TestAESGCMBarrier_Integrity(t *testing.T) {
 		t.Fatalf("should fail!")
 	}
 }
+
+func TestAESGCMBarrier_Rotate(t *testing.T) {
+	inm, b, key := mockBarrier(t)
+
+	// Write a value with the initial key
+	entry := &Entry{Key: "test", Value: []byte("before")}
+	if err := b.Put(entry); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Unseal a second barrier, such as a standby, before rotating
+	b2, err := NewAESGCMBarrier(inm)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := b2.Unseal(key); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	term, err := b.Rotate()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if term != 2 {
+		t.Fatalf("bad: %d", term)
+	}
+	if active, err := b.ActiveTerm(); err != nil || active != 2 {
+		t.Fatalf("bad: %d %v", active, err)
+	}
+
+	// New values are written with the new key
+	entry = &Entry{Key: "test2", Value: []byte("after")}
+	if err := b.Put(entry); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	pe, err := inm.Get("test2")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if pe.Value[3] != 2 {
+		t.Fatalf("bad: %v", pe.Value[:epochSize])
+	}
+
+	// Both values are readable, including after unsealing again
+	for i := 0; i < 2; i++ {
+		for k, v := range map[string]string{"test": "before", "test2": "after"} {
+			out, err := b.Get(k)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if out == nil || string(out.Value) != v {
+				t.Fatalf("bad: %#v", out)
+			}
+		}
+		b.Seal()
+		if err := b.Unseal(key); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// The second barrier only knows the new key once reloaded
+	if _, err := b2.Get("test2"); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := b2.ReloadKeyring(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	out, err := b2.Get("test2")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out == nil || string(out.Value) != "after" {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	// Rotating again advances the term
+	if term, err := b2.Rotate(); err != nil || term != 3 {
+		t.Fatalf("bad: %d %v", term, err)
+	}
+
+	// Rotation requires the barrier to be unsealed
+	b.Seal()
+	if _, err := b.Rotate(); err != ErrBarrierSealed {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+// Verify that no read fails while rotating under load
+func TestAESGCMBarrier_Rotate_Concurrent(t *testing.T) {
+	_, b, _ := mockBarrier(t)
+
+	const workers = 8
+	for i := 0; i < workers; i++ {
+		entry := &Entry{Key: fmt.Sprintf("key%d", i), Value: []byte("0")}
+		if err := b.Put(entry); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// Each worker writes and reads back its own key until stopped
+	stopCh := make(chan struct{})
+	errCh := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key%d", i)
+			for n := 1; ; n++ {
+				select {
+				case <-stopCh:
+					return
+				default:
+				}
+
+				value := []byte(fmt.Sprintf("%d", n))
+				if err := b.Put(&Entry{Key: key, Value: value}); err != nil {
+					errCh <- err
+					return
+				}
+				out, err := b.Get(key)
+				if err != nil {
+					errCh <- err
+					return
+				}
+				if out == nil || !bytes.Equal(out.Value, value) {
+					errCh <- fmt.Errorf("bad: %#v", out)
+					return
+				}
+			}
+		}(i)
+	}
+
+	for i := 0; i < 20; i++ {
+		if _, err := b.Rotate(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	close(stopCh)
+	wg.Wait()
+
+	select {
+	case err := <-errCh:
+		t.Fatalf("err: %v", err)
+	default:
+	}
+	if term, err := b.ActiveTerm(); err != nil || term != 21 {
+		t.Fatalf("bad: %d %v", term, err)
+	}
+}
+
+func TestAESGCMBarrier_Transaction(t *testing.T) {
+	inm, b, _ := mockBarrier(t)
+	if err := b.Put(&Entry{Key: "foo", Value: []byte("foo")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	txns := []*TxnEntry{
+		&TxnEntry{
+			Operation: physical.PutOperation,
+			Entry:     &Entry{Key: "bar", Value: []byte("bar")},
+		},
+		&TxnEntry{
+			Operation: physical.DeleteOperation,
+			Entry:     &Entry{Key: "foo"},
+		},
+	}
+	if err := b.(BarrierTransactional).Transaction(txns); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	out, err := b.Get("foo")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out != nil {
+		t.Fatalf("bad: %#v", out)
+	}
+	out, err = b.Get("bar")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out == nil || string(out.Value) != "bar" {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	// The value is encrypted
+	pe, err := inm.Get("bar")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if pe == nil || bytes.Equal(pe.Value, []byte("bar")) {
+		t.Fatalf("bad: %#v", pe)
+	}
+
+	// The barrier must be unsealed
+	b.Seal()
+	if err := b.(BarrierTransactional).Transaction(txns); err != ErrBarrierSealed {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestAESGCMBarrier_Rewrap(t *testing.T) {
+	inm, b, _ := mockBarrier(t)
+	if err := b.Put(&Entry{Key: "foo", Value: []byte("foo")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Nothing is rewrapped while the key is active
+	rewrapped, err := b.Rewrap("foo")
+	if err != nil || rewrapped {
+		t.Fatalf("bad: %v %v", rewrapped, err)
+	}
+
+	if _, err := b.Rotate(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	rewrapped, err = b.Rewrap("foo")
+	if err != nil || !rewrapped {
+		t.Fatalf("bad: %v %v", rewrapped, err)
+	}
+
+	pe, err := inm.Get("foo")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if term := valueTerm(pe.Value); term != 2 {
+		t.Fatalf("bad: %d", term)
+	}
+	out, err := b.Get("foo")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out == nil || string(out.Value) != "foo" {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	// Missing keys, values not written through the keyring and the
+	// init entry are left untouched
+	if err := inm.Put(&physical.Entry{Key: "plain", Value: []byte(`{"foo":"bar"}`)}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, key := range []string{"missing", "plain", barrierInitPath} {
+		before, err := inm.Get(key)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		rewrapped, err := b.Rewrap(key)
+		if err != nil || rewrapped {
+			t.Fatalf("bad %s: %v %v", key, rewrapped, err)
+		}
+		after, err := inm.Get(key)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if !reflect.DeepEqual(before, after) {
+			t.Fatalf("bad %s: %#v", key, after)
+		}
+	}
+}
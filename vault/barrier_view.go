@@ -1,7 +1,10 @@
 package vault
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"strings"
 
 	"github.com/hashicorp/vault/logical"
@@ -17,6 +20,10 @@ import (
 type BarrierView struct {
 	barrier BarrierStorage
 	prefix  string
+
+	// cancel is closed if the request using the view is canceled, after
+	// which the view refuses any access
+	cancel <-chan struct{}
 }
 
 // NewBarrierView takes an underlying security barrier and returns
@@ -28,11 +35,24 @@ func NewBarrierView(barrier BarrierStorage, prefix string) *BarrierView {
 	}
 }
 
-// sanityCheck is used to perform a sanity check on a key
+// WithCancel returns a copy of the view that refuses any access once the
+// given channel is closed. This is used to stop a canceled request from
+// reaching the barrier.
+func (v *BarrierView) WithCancel(cancel <-chan struct{}) *BarrierView {
+	return &BarrierView{barrier: v.barrier, prefix: v.prefix, cancel: cancel}
+}
+
+// sanityCheck is used to perform a sanity check on a key, and to check
+// that the request using the view was not canceled
 func (v *BarrierView) sanityCheck(key string) error {
 	if strings.Contains(key, "..") {
 		return fmt.Errorf("key cannot be relative path")
 	}
+	select {
+	case <-v.cancel:
+		return logical.ErrRequestCanceled
+	default:
+	}
 	return nil
 }
 
@@ -78,6 +98,61 @@ func (v *BarrierView) Put(entry *logical.StorageEntry) error {
 	return v.barrier.Put(nested)
 }
 
+// logical.StreamStorage impl.
+func (v *BarrierView) GetStream(key string) (io.ReadCloser, error) {
+	if err := v.sanityCheck(key); err != nil {
+		return nil, err
+	}
+
+	// Fall back to buffering if the barrier does not support streams
+	if stream, ok := v.barrier.(BarrierStreamStorage); ok {
+		return stream.GetStream(v.expandKey(key))
+	}
+	entry, err := v.barrier.Get(v.expandKey(key))
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(entry.Value)), nil
+}
+
+// logical.StreamStorage impl.
+func (v *BarrierView) PutStream(key string, r io.Reader) error {
+	if err := v.sanityCheck(key); err != nil {
+		return err
+	}
+
+	// Fall back to buffering if the barrier does not support streams
+	if stream, ok := v.barrier.(BarrierStreamStorage); ok {
+		return stream.PutStream(v.expandKey(key), r)
+	}
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	nested := &Entry{
+		Key:   v.expandKey(key),
+		Value: value,
+	}
+	return v.barrier.Put(nested)
+}
+
+// logical.ExistenceStorage impl.
+func (v *BarrierView) Exists(key string) (bool, error) {
+	if err := v.sanityCheck(key); err != nil {
+		return false, err
+	}
+
+	// Fall back to reading the value if the barrier cannot probe
+	if exists, ok := v.barrier.(BarrierExistenceStorage); ok {
+		return exists.Exists(v.expandKey(key))
+	}
+	entry, err := v.barrier.Get(v.expandKey(key))
+	if err != nil {
+		return false, err
+	}
+	return entry != nil, nil
+}
+
 // logical.Storage impl.
 func (v *BarrierView) Delete(key string) error {
 	if err := v.sanityCheck(key); err != nil {
@@ -89,7 +164,7 @@ func (v *BarrierView) Delete(key string) error {
 // SubView constructs a nested sub-view using the given prefix
 func (v *BarrierView) SubView(prefix string) *BarrierView {
 	sub := v.expandKey(prefix)
-	return &BarrierView{barrier: v.barrier, prefix: sub}
+	return &BarrierView{barrier: v.barrier, prefix: sub, cancel: v.cancel}
 }
 
 // expandKey is used to expand to the full key path with the prefix
@@ -10,6 +10,45 @@ import (
 
 func TestBarrierView_impl(t *testing.T) {
 	var _ logical.Storage = new(BarrierView)
+	var _ logical.ExistenceStorage = new(BarrierView)
+}
+
+func TestBarrierView_Exists(t *testing.T) {
+	_, barrier, _ := mockBarrier(t)
+	views := []*BarrierView{
+		NewBarrierView(barrier, "foo/"),
+		NewBarrierView(newSealWrapStorage(barrier, &testSealWrapper{}), "foo/"),
+	}
+	for _, view := range views {
+		exists, err := view.Exists("test")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if exists {
+			t.Fatalf("should not exist")
+		}
+
+		entry := &logical.StorageEntry{Key: "test", Value: []byte("test")}
+		if err := view.Put(entry); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		exists, err = view.Exists("test")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if !exists {
+			t.Fatalf("should exist")
+		}
+
+		if err := view.Delete("test"); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// Relative keys are rejected
+	if _, err := views[0].Exists("../test"); err == nil {
+		t.Fatalf("expected error")
+	}
 }
 
 func TestBarrierView_spec(t *testing.T) {
@@ -175,6 +214,44 @@ func TestBarrierView_SubView(t *testing.T) {
 	}
 }
 
+func TestBarrierView_Cancel(t *testing.T) {
+	_, barrier, _ := mockBarrier(t)
+	cancelCh := make(chan struct{})
+	view := NewBarrierView(barrier, "foo/").WithCancel(cancelCh)
+	sub := view.SubView("bar/")
+
+	entry := &logical.StorageEntry{Key: "test", Value: []byte("test")}
+	if err := sub.Put(entry); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Once canceled, the view and its sub-views refuse any access
+	close(cancelCh)
+	for _, v := range []*BarrierView{view, sub} {
+		if _, err := v.Get("test"); err != logical.ErrRequestCanceled {
+			t.Fatalf("err: %v", err)
+		}
+		if err := v.Put(entry); err != logical.ErrRequestCanceled {
+			t.Fatalf("err: %v", err)
+		}
+		if _, err := v.List(""); err != logical.ErrRequestCanceled {
+			t.Fatalf("err: %v", err)
+		}
+		if err := v.Delete("test"); err != logical.ErrRequestCanceled {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// The entry written before is intact
+	out, err := barrier.Get("foo/bar/test")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out == nil {
+		t.Fatalf("missing foo/bar/test")
+	}
+}
+
 func TestBarrierView_Scan(t *testing.T) {
 	_, barrier, _ := mockBarrier(t)
 	view := NewBarrierView(barrier, "view/")
@@ -0,0 +1,35 @@
+package vault
+
+import (
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/physical"
+)
+
+// CacheStats returns the use of the physical cache, and false if the
+// physical backend is not cached
+func (c *Core) CacheStats() (physical.CacheStats, bool) {
+	cache, ok := c.physical.(*physical.Cache)
+	if !ok {
+		return physical.CacheStats{}, false
+	}
+	return cache.Stats(), true
+}
+
+// emitCacheMetrics is used to emit the size of the physical cache, and
+// its hits, misses and evictions since the metrics were last emitted
+func (c *Core) emitCacheMetrics() {
+	stats, ok := c.CacheStats()
+	if !ok {
+		return
+	}
+	metrics.SetGauge([]string{"cache", "size"}, float32(stats.Size))
+	metrics.SetGauge([]string{"cache", "capacity"}, float32(stats.Capacity))
+
+	c.cacheMetricsLock.Lock()
+	last := c.cacheMetricsLast
+	c.cacheMetricsLast = stats
+	c.cacheMetricsLock.Unlock()
+	metrics.IncrCounter([]string{"cache", "hit"}, float32(stats.Hits-last.Hits))
+	metrics.IncrCounter([]string{"cache", "miss"}, float32(stats.Misses-last.Misses))
+	metrics.IncrCounter([]string{"cache", "eviction"}, float32(stats.Evictions-last.Evictions))
+}
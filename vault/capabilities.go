@@ -0,0 +1,38 @@
+package vault
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/armon/go-metrics"
+)
+
+// Capabilities is used to fetch the capabilities that the given token
+// has on the given path. A token that is permitted nothing on the path
+// has no capabilities, which is not an error. This does not take the
+// state lock, as it is called by the system backend.
+func (c *Core) Capabilities(token, path string) ([]string, error) {
+	defer metrics.MeasureSince([]string{"core", "capabilities"}, time.Now())
+	if path == "" {
+		return nil, fmt.Errorf("missing path")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("missing token")
+	}
+
+	// Resolve the token policy
+	te, err := c.tokenStore.Lookup(token)
+	if err != nil {
+		return nil, err
+	}
+	if te == nil {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	// Construct the corresponding ACL object
+	acl, err := c.policy.ACL(te.Policies...)
+	if err != nil {
+		return nil, err
+	}
+	return acl.Capabilities(path), nil
+}
@@ -1,7 +1,7 @@
 package vault
 
 import (
-	"bytes"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,8 +13,12 @@ import (
 
 	"github.com/armon/go-metrics"
 	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/helper/leveled-log"
 	"github.com/hashicorp/vault/helper/mlock"
+	"github.com/hashicorp/vault/helper/pgpkeys"
+	"github.com/hashicorp/vault/helper/prometheus-sink"
 	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/plugin"
 	"github.com/hashicorp/vault/physical"
 	"github.com/hashicorp/vault/shamir"
 )
@@ -26,10 +30,19 @@ const (
 	// how many secret parts must be used to reconstruct the master key.
 	coreSealConfigPath = "core/seal-config"
 
+	// coreMasterKeyPath is the path used to store the master key
+	// encrypted by the MasterKeySource. It is stored outside of the
+	// barrier, since it is needed to unseal it.
+	coreMasterKeyPath = "core/master-key"
+
 	// coreLockPath is the path used to acquire a coordinating lock
 	// for a highly-available deploy.
 	coreLockPath = "core/lock"
 
+	// sysHelpPrefix is the prefix of the paths used to read the help of
+	// any other path, such as "sys/help/secret/foo"
+	sysHelpPrefix = "sys/help/"
+
 	// coreLeaderPrefix is the prefix used for the UUID that contains
 	// the currently elected leader.
 	coreLeaderPrefix = "core/leader/"
@@ -37,6 +50,27 @@ const (
 	// lockRetryInterval is the interval we re-attempt to acquire the
 	// HA lock if an error is encountered
 	lockRetryInterval = 10 * time.Second
+
+	// leaderValueAttempts is the number of times we attempt to read
+	// a missing HA lock value before giving up on finding the leader
+	leaderValueAttempts = 3
+
+	// leaderValueRetryInterval is the interval between attempts to
+	// read the value of the HA lock
+	leaderValueRetryInterval = 100 * time.Millisecond
+
+	// manualStepDownSleepPeriod is how long a Vault that stepped down
+	// waits before contending for the HA lock again, so that another
+	// Vault can acquire it
+	manualStepDownSleepPeriod = 10 * time.Second
+
+	// healthCheckInterval is the interval at which the health of the
+	// physical backend is checked while unsealed
+	healthCheckInterval = 10 * time.Second
+
+	// defaultMetricsInterval is the default interval at which metrics
+	// are emitted while unsealed
+	defaultMetricsInterval = time.Second
 )
 
 var (
@@ -44,6 +78,10 @@ var (
 	// a sealed barrier. No operation is expected to succeed before unsealing
 	ErrSealed = errors.New("Vault is sealed")
 
+	// ErrSealing is returned if an unseal is attempted while the
+	// Vault is still being sealed.
+	ErrSealing = errors.New("Vault is being sealed")
+
 	// ErrStandby is returned if an operation is performed on
 	// a standby Vault. No operation is expected to succeed until active.
 	ErrStandby = errors.New("Vault is in standby mode")
@@ -63,8 +101,33 @@ var (
 	// ErrHANotEnabled is returned if the operation only makes sense
 	// in an HA setting
 	ErrHANotEnabled = errors.New("Vault is not configured for highly-available mode")
+
+	// ErrLeaderUnknown is returned if the HA lock is held but its value
+	// or the leader entry it points to is missing. This is usually a
+	// transient state while a new leader advertises itself and does not
+	// indicate a broken cluster.
+	ErrLeaderUnknown = errors.New("Vault leader could not be determined")
+
+	// ErrUnsealNonceMismatch is returned if a key is provided with the
+	// wrong nonce for the unseal in progress, which is then reset
+	ErrUnsealNonceMismatch = errors.New("unseal nonce does not match, unseal progress reset")
+
+	// ErrSecureSealRequired is returned if Seal is used while secure seal
+	// is enabled, which requires the unseal keys to seal
+	ErrSecureSealRequired = errors.New("secure seal is enabled, unseal keys are required to seal")
 )
 
+// ErrStandbyRedirect is returned by HandleRequest on a standby Vault.
+// It carries the advertise address of the active Vault, so the request
+// can be redirected. LeaderAddr is empty if the leader is unknown.
+type ErrStandbyRedirect struct {
+	LeaderAddr string
+}
+
+func (e ErrStandbyRedirect) Error() string {
+	return ErrStandby.Error()
+}
+
 // SealConfig is used to describe the seal configuration
 type SealConfig struct {
 	// SecretShares is the number of shares the secret is
@@ -74,6 +137,19 @@ type SealConfig struct {
 	// SecretThreshold is the number of parts required
 	// to open the vault. This is the T value of Shamir
 	SecretThreshold int `json:"secret_threshold"`
+
+	// PGPKeys are optional OpenPGP public keys, one per share. If set,
+	// each share is returned encrypted to the key at the same index.
+	// They are not stored with the configuration.
+	PGPKeys []string `json:"-"`
+
+	// ShareLabels are optional labels, one per share, returned with the
+	// shares for the bookkeeping of operators. They are not stored.
+	ShareLabels []string `json:"-"`
+
+	// SecureSeal requires the threshold of unseal keys to seal the Vault,
+	// in addition to a root token, so that no single operator can seal it
+	SecureSeal bool `json:"secure_seal,omitempty"`
 }
 
 // Validate is used to sanity check the seal configuration
@@ -93,14 +169,49 @@ func (s *SealConfig) Validate() error {
 	if s.SecretThreshold > s.SecretShares {
 		return fmt.Errorf("secret threshold cannot be larger than secret shares")
 	}
+	if len(s.PGPKeys) > 0 {
+		if len(s.PGPKeys) != s.SecretShares {
+			return fmt.Errorf("count mismatch between number of PGP keys and secret shares")
+		}
+		for i, key := range s.PGPKeys {
+			if _, err := pgpkeys.ParsePublicKey(key); err != nil {
+				return fmt.Errorf("failed to parse PGP key %d: %v", i+1, err)
+			}
+		}
+	}
+	if len(s.ShareLabels) > 0 && len(s.ShareLabels) != s.SecretShares {
+		return fmt.Errorf("count mismatch between number of share labels and secret shares")
+	}
 	return nil
 }
 
+// SealStatus is a consistent snapshot of the seal state of the Vault
+type SealStatus struct {
+	Sealed          bool
+	SecretThreshold int
+	SecretShares    int
+
+	// Progress is the number of keys provided to the unseal in
+	// progress, which is identified by the Nonce
+	Progress int
+	Nonce    string
+}
+
 // InitResult is used to provide the key parts back after
 // they are generated as part of the initialization.
 type InitResult struct {
 	SecretShares [][]byte
 	RootToken    string
+
+	// Shares are the same shares in the same order, with the label given
+	// for each in the seal configuration, if any
+	Shares []SealKeyShare
+}
+
+// SealKeyShare is a share of the master key with its label
+type SealKeyShare struct {
+	Label string
+	Key   []byte
 }
 
 // ErrInvalidKey is returned if there is an error with a
@@ -145,25 +256,54 @@ type Core struct {
 	stateLock sync.RWMutex
 	sealed    bool
 
-	standby       bool
-	standbyDoneCh chan struct{}
-	standbyStopCh chan struct{}
+	// sealing is set while a seal is in progress. The sealed flag is
+	// set as soon as sealing begins so that no new requests are admitted,
+	// but the teardown may not have completed until this is cleared.
+	sealing bool
+
+	standby          bool
+	standbyDoneCh    chan struct{}
+	standbyStopCh    chan struct{}
+	manualStepDownCh chan struct{}
 
 	// unlockParts has the keys provided to Unseal until
 	// the threshold number of parts is available.
 	unlockParts [][]byte
 
+	// unlockNonce identifies the unseal in progress, and is generated
+	// when the first part is provided
+	unlockNonce string
+
+	// sealParts has the keys provided to SecureSeal until the
+	// threshold number of parts is available
+	sealParts [][]byte
+
+	// generateRootConfig is the root token generation in progress, and
+	// generateRootParts has the keys provided to it so far. Both are
+	// protected by the generateRootLock.
+	generateRootLock   sync.Mutex
+	generateRootConfig *generateRootConfig
+	generateRootParts  [][]byte
+
+	// wrappingLock serializes unwraps, so that a wrapped response is
+	// only returned once
+	wrappingLock sync.Mutex
+
 	// mounts is loaded after unseal since it is a protected
-	// configuration
-	mounts *MountTable
+	// configuration. The table is replaced rather than modified, and
+	// mountsLock must be held to read or swap it.
+	mounts     *MountTable
+	mountsLock sync.RWMutex
 
 	// auth is loaded after unseal since it is a protected
-	// configuration
-	auth *MountTable
+	// configuration. authLock must be held to read or swap it.
+	auth     *MountTable
+	authLock sync.RWMutex
 
 	// audit is loaded after unseal since it is a protected
-	// configuration
-	audit *MountTable
+	// configuration. auditLock must be held to read or swap it.
+	audit     *MountTable
+	auditLock sync.RWMutex
 
 	// auditBroker is used to ingest the audit events and fan
 	// out into the configured audit backends
@@ -179,16 +319,115 @@ type Core struct {
 	// rollback manager is used to run rollbacks periodically
 	rollback *RollbackManager
 
+	// rewrap manager is used to re-encrypt entries after a key rotation
+	rewrap *RewrapManager
+
 	// policy store is used to manage named ACL policies
 	policy *PolicyStore
 
 	// token store is used to manage authentication tokens
 	tokenStore *TokenStore
 
+	// idempotency store is used to replay the results of keyed writes
+	idempotency *IdempotencyStore
+
+	// counters tracks the number of requests per mount. These are
+	// in-memory only and reset on unseal.
+	counters *requestCounters
+
+	// requireExplicitPolicy is used to reject tokens that only carry
+	// the default policy
+	requireExplicitPolicy bool
+
+	// leaseConfig is the system-wide default and maximum lease. It is
+	// loaded after unseal and may be changed at runtime.
+	leaseConfig     *leaseConfig
+	leaseConfigLock sync.RWMutex
+
+	// revocationGrace is how long after expiration leases are revoked
+	revocationGrace time.Duration
+
+	// strictFields is used to reject requests with unknown fields
+	strictFields bool
+
+	// maxMounts is the maximum number of entries in each mount table
+	maxMounts int
+
+	// breakGlassAlert is called for each request using the break-glass
+	// override, if set
+	breakGlassAlert func(*logical.Auth, *logical.Request)
+
+	// rateLimiter throttles requests before they are routed, and
+	// rateLimitDefaults are the configured limits, which are used
+	// unless the limits have been changed at runtime
+	rateLimiter       *rateLimiter
+	rateLimitDefaults RateLimitConfig
+
+	// requestTimeout is how long a request may take before the client
+	// is sent an error, or zero for no limit
+	requestTimeout time.Duration
+
+	// requestSizeLimit is the maximum size of the data of a request,
+	// unless overridden by its mount, or zero for no limit
+	requestSizeLimit int
+
+	// prometheusSink holds the metrics to expose for scraping, if enabled
+	prometheusSink *prometheussink.Sink
+
+	// sealWrapper is used to wrap the values of seal wrapped mounts
+	sealWrapper SealWrapper
+
+	// masterKeySource is used to store the master key so that the Vault
+	// can be unsealed without the unseal keys, if set
+	masterKeySource MasterKeySource
+
+	// mandatoryAudit is an audit backend from the configuration that
+	// must be set up before the Vault becomes active
+	mandatoryAudit *MountEntry
+
+	// scheduler runs the periodic jobs that must only run on the
+	// active node
+	scheduler *Scheduler
+
+	// manualTimers is a test hook. When set before unsealing, the
+	// rollback and expiration managers do not run on timers and must
+	// be driven using their RunNow methods.
+	manualTimers bool
+
 	// metricsCh is used to stop the metrics streaming
 	metricsCh chan struct{}
 
-	logger *log.Logger
+	// metricsInterval is how often metrics are emitted, and
+	// disableLeaseMetrics stops the emission of the lease and token counts.
+	// These are read each time around the metrics loop.
+	metricsInterval     time.Duration
+	disableLeaseMetrics bool
+	metricsLock         sync.RWMutex
+
+	// cacheMetricsLast is the use of the physical cache when its
+	// metrics were last emitted, so that the counts since are emitted
+	cacheMetricsLock sync.Mutex
+	cacheMetricsLast physical.CacheStats
+
+	// healthCh is used to stop the health checks of the physical
+	// backend, and healthErr is the result of the last check
+	healthCh   chan struct{}
+	healthErr  error
+	healthLock sync.RWMutex
+
+	// healthInterval is how often the physical backend is checked
+	healthInterval time.Duration
+
+	// storageFailureThreshold is the number of consecutive failed health
+	// checks after which the Vault seals itself, or zero to never seal.
+	// sealOnPanic seals the Vault if a request panics.
+	storageFailureThreshold int
+	sealOnPanic             bool
+
+	// enableRaw exposes the decrypted barrier entries at sys/raw
+	enableRaw bool
+
+	logger leveledlog.Logger
 }
 
 // CoreConfig is used to parameterize a core
@@ -197,11 +436,109 @@ type CoreConfig struct {
 	CredentialBackends map[string]logical.Factory
 	AuditBackends      map[string]audit.Factory
 	Physical           physical.Backend
-	Logger             *log.Logger
+	Logger             leveledlog.Logger
 	DisableCache       bool   // Disables the LRU cache on the physical backend
 	DisableMlock       bool   // Disables mlock syscall
 	CacheSize          int    // Custom cache size of zero for default
 	AdvertiseAddr      string // Set as the leader address for HA
+
+	// CacheVerifyPrefixes are the prefixes of critical keys whose writes
+	// are read back from the physical backend before they are cached
+	CacheVerifyPrefixes []string
+
+	// RequireExplicitPolicy rejects the creation of tokens that have no
+	// policy other than "default". Root tokens are exempt.
+	RequireExplicitPolicy bool
+
+	// RevocationGrace delays the revocation of expired leases, to allow
+	// for clock skew with external systems. Defaults to zero.
+	RevocationGrace time.Duration
+
+	// StrictFields rejects requests carrying fields that are not
+	// accepted by the request path, for backends that declare their
+	// fields. By default unknown fields are ignored.
+	StrictFields bool
+
+	// SealWrapper is used to additionally wrap the values of mounts
+	// with seal wrapping enabled. It must remain available for as long
+	// as any such mount exists, or their data cannot be read.
+	SealWrapper SealWrapper
+
+	// MasterKeySource is used to store the master key encrypted by an
+	// external KMS, so that AutoUnseal can unseal the Vault without the
+	// unseal keys. The unseal keys are still generated, and can always
+	// be used to unseal manually, such as when the KMS is unreachable.
+	MasterKeySource MasterKeySource
+
+	// MandatoryAudit is an audit backend that is always enabled. It is
+	// set up during unseal before the Vault becomes active, and the
+	// Vault will not become active if it fails, so that no request is
+	// ever served without being audited.
+	MandatoryAudit *MountEntry
+
+	// MetricsInterval is how often metrics are emitted. Defaults to
+	// one second.
+	MetricsInterval time.Duration
+
+	// DisableLeaseMetrics stops the periodic emission of the number of
+	// leases and tokens, which requires scanning the pending leases and
+	// listing the tokens.
+	DisableLeaseMetrics bool
+
+	// PluginBackends maps logical backend types to the paths of the
+	// plugin executables that serve them. The plugins are launched
+	// for each mount and restarted if they exit.
+	PluginBackends map[string]string
+
+	// MaxMounts limits the number of entries in each of the mount, auth
+	// and audit tables, including the default entries. New mounts past
+	// the limit are rejected. Defaults to zero, which is unlimited.
+	MaxMounts int
+
+	// BreakGlassAlert is called for every request that overrides the
+	// ACL using the break-glass capability, after it has been audited.
+	// It can be used to alert operators, and must not block.
+	BreakGlassAlert func(auth *logical.Auth, req *logical.Request)
+
+	// RateLimit limits the rate of requests for each client token and
+	// across all requests. The limits can be changed at runtime, which
+	// overrides these. Defaults to no limits.
+	RateLimit RateLimitConfig
+
+	// RequestTimeout is how long a read may take before it is canceled
+	// and the client is sent an error, so that a stalled backend does
+	// not hold up clients indefinitely. Writes are not timed out, since
+	// they may still be applied after the client is sent the error.
+	// Defaults to zero, which is no limit.
+	RequestTimeout time.Duration
+
+	// MaxRequestSize is the maximum size in bytes of the data of a
+	// request, encoded as JSON. Larger requests are rejected before they
+	// are routed, so they never reach the barrier. Mounts can override
+	// the limit. Defaults to zero, which is no limit.
+	MaxRequestSize int
+
+	// PrometheusSink is exposed for scraping at sys/metrics if set. It
+	// must also be added to the go-metrics sinks to receive the metrics.
+	PrometheusSink *prometheussink.Sink
+
+	// StorageFailureThreshold is the number of consecutive failed health
+	// checks of the physical backend after which the Vault seals itself,
+	// discarding the master key, so that it fails closed rather than
+	// serving errors indefinitely. The backend is checked every ten
+	// seconds, using physical.HealthChecker if implemented and a read
+	// otherwise. Defaults to zero, which never seals.
+	StorageFailureThreshold int
+
+	// SealOnPanic seals the Vault if handling a request panics, since
+	// the state of the Vault may no longer be consistent
+	SealOnPanic bool
+
+	// EnableRaw exposes sys/raw, which reads, writes, deletes and lists
+	// the decrypted entries of the barrier directly, bypassing the
+	// backends. It is meant for recovering from corruption and requires
+	// a root token. Disabled by default.
+	EnableRaw bool
 }
 
 // NewCore isk used to construct a new core
@@ -221,6 +558,7 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 		_, isInmem := conf.Physical.(*physical.InmemBackend)
 		if !isCache && !isInmem {
 			cache := physical.NewCache(conf.Physical, conf.CacheSize)
+			cache.SetVerifyPrefixes(conf.CacheVerifyPrefixes)
 			conf.Physical = cache
 		}
 	}
@@ -249,7 +587,30 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 
 	// Make a default logger if not provided
 	if conf.Logger == nil {
-		conf.Logger = log.New(os.Stderr, "", log.LstdFlags)
+		conf.Logger = leveledlog.New(log.New(os.Stderr, "", log.LstdFlags))
+	}
+
+	// Validate the mandatory audit backend
+	var mandatoryAudit *MountEntry
+	if conf.MandatoryAudit != nil {
+		mandatoryAudit = conf.MandatoryAudit.Clone()
+		if !strings.HasSuffix(mandatoryAudit.Path, "/") {
+			mandatoryAudit.Path += "/"
+		}
+		if mandatoryAudit.Path == "/" {
+			return nil, fmt.Errorf("mandatory audit backend path must be specified")
+		}
+		if mandatoryAudit.UUID == "" {
+			mandatoryAudit.UUID = mandatoryAuditUUID
+		}
+	}
+
+	// Validate the rate limits
+	if err := conf.RateLimit.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid rate limit: %v", err)
+	}
+	if conf.MaxRequestSize < 0 {
+		return nil, fmt.Errorf("max request size cannot be negative")
 	}
 
 	// Setup the core
@@ -259,20 +620,48 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 		physical:      conf.Physical,
 		barrier:       barrier,
 		router:        NewRouter(),
+		counters:      newRequestCounters(),
 		sealed:        true,
 		standby:       true,
 		logger:        conf.Logger,
-	}
+
+		requireExplicitPolicy: conf.RequireExplicitPolicy,
+		revocationGrace:       conf.RevocationGrace,
+		strictFields:          conf.StrictFields,
+		sealWrapper:           conf.SealWrapper,
+		masterKeySource:       conf.MasterKeySource,
+		mandatoryAudit:        mandatoryAudit,
+		disableLeaseMetrics:   conf.DisableLeaseMetrics,
+		maxMounts:             conf.MaxMounts,
+		breakGlassAlert:       conf.BreakGlassAlert,
+		rateLimiter:           newRateLimiter(conf.RateLimit),
+		rateLimitDefaults:     conf.RateLimit,
+		requestTimeout:        conf.RequestTimeout,
+		requestSizeLimit:      conf.MaxRequestSize,
+		prometheusSink:        conf.PrometheusSink,
+		healthInterval:        healthCheckInterval,
+
+		storageFailureThreshold: conf.StorageFailureThreshold,
+		sealOnPanic:             conf.SealOnPanic,
+		enableRaw:               conf.EnableRaw,
+	}
+	c.SetMetricsInterval(conf.MetricsInterval)
 
 	// Setup the backends
 	logicalBackends := make(map[string]logical.Factory)
 	for k, f := range conf.LogicalBackends {
 		logicalBackends[k] = f
 	}
+	for k, path := range conf.PluginBackends {
+		logicalBackends[k] = plugin.Factory(path)
+	}
 	logicalBackends["generic"] = PassthroughBackendFactory
 	logicalBackends["system"] = func(map[string]string) (logical.Backend, error) {
 		return NewSystemBackend(c), nil
 	}
+	logicalBackends["cubbyhole"] = func(map[string]string) (logical.Backend, error) {
+		return NewCubbyholeBackend(c), nil
+	}
 	c.logicalBackends = logicalBackends
 
 	credentialBackends := make(map[string]logical.Factory)
@@ -292,28 +681,71 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 	return c, nil
 }
 
-// HandleRequest is used to handle a new incoming request
+// HandleRequest is used to handle a new incoming request. The request
+// is assigned a new ID, which the caller can read from it afterwards.
 func (c *Core) HandleRequest(req *logical.Request) (*logical.Response, error) {
+	req.ID = generateUUID()
+
+	// A request that times out keeps the state lock until it completes,
+	// so the lock is then released by handleRequestTimeout instead
 	c.stateLock.RLock()
-	defer c.stateLock.RUnlock()
+	locked := true
+	defer func() {
+		if locked {
+			c.stateLock.RUnlock()
+		}
+	}()
+	if c.sealOnPanic {
+		defer c.sealOnRequestPanic()
+	}
 	if c.sealed {
 		return nil, ErrSealed
 	}
 	if c.standby {
-		return nil, ErrStandby
+		_, advertise, err := c.leaderLocked()
+		if err != nil && err != ErrLeaderUnknown {
+			c.logger.Error("core: failed to lookup leader: %v", err)
+		}
+		return nil, ErrStandbyRedirect{LeaderAddr: advertise}
+	}
+
+	// Throttle the client before doing any work on its behalf
+	if ok, wait := c.rateLimiter.Allow(req.ClientToken, time.Now()); !ok {
+		metrics.IncrCounter([]string{"core", "rate_limited"}, 1)
+		return nil, ErrRateLimited{RetryAfter: wait}
+	}
+
+	// Reject oversized requests before they reach a backend
+	if err := c.checkRequestSize(req); err != nil {
+		return nil, err
+	}
+
+	// A read of the help prefix is a help request for the rest of the
+	// path. This is authorized as a help request for that path, which
+	// requires a valid token but no capability.
+	if req.Operation == logical.ReadOperation && strings.HasPrefix(req.Path, sysHelpPrefix) {
+		helpReq := *req
+		helpReq.Operation = logical.HelpOperation
+		helpReq.Path = strings.TrimPrefix(req.Path, sysHelpPrefix)
+		req = &helpReq
 	}
 
+	handler := c.handleRequest
 	if c.router.LoginPath(req.Path) {
-		return c.handleLoginRequest(req)
-	} else {
-		return c.handleRequest(req)
+		handler = c.handleLoginRequest
+	}
+	if c.requestTimeout > 0 && req.Operation == logical.ReadOperation {
+		locked = false
+		return c.handleRequestTimeout(req, handler)
 	}
+	return handler(req)
 }
 
 func (c *Core) handleRequest(req *logical.Request) (*logical.Response, error) {
 	defer metrics.MeasureSince([]string{"core", "handle_request"}, time.Now())
 	// Validate the token
-	auth, err := c.checkToken(req.Operation, req.Path, req.ClientToken)
+	auth, breakGlass, err := c.checkTokenOverride(
+		req.Operation, req.Path, req.ClientToken, req.BreakGlass)
 	if err != nil {
 		// If it is an internal error we return that, otherwise we
 		// return invalid request so that the status codes can be correct
@@ -331,76 +763,150 @@ func (c *Core) handleRequest(req *logical.Request) (*logical.Response, error) {
 	// Attach the display name
 	req.DisplayName = auth.DisplayName
 
+	// Only mark the request as break-glass if the override was used, so
+	// that the audit log singles out the requests it permitted
+	req.BreakGlass = breakGlass
+
+	// Reject any fields the backend does not accept
+	if c.strictFields && len(req.Data) > 0 {
+		if unknown, ok := c.router.UnknownFields(req); ok && len(unknown) > 0 {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"unknown fields: %s", strings.Join(unknown, ", "))), logical.ErrInvalidRequest
+		}
+	}
+
 	// Create an audit trail of the request
 	if err := c.auditBroker.LogRequest(auth, req); err != nil {
-		c.logger.Printf("[ERR] core: failed to audit request (%#v): %v",
-			req, err)
+		c.logger.Error("core: request %s: failed to audit request (%#v): %v",
+			req.ID, req, err)
 		return nil, ErrInternalError
 	}
+	if breakGlass {
+		metrics.IncrCounter([]string{"core", "break_glass"}, 1)
+		c.logger.Warn("core: request %s: break-glass override used for %s on '%s' (display name: %s)",
+			req.ID, req.Operation, req.Path, auth.DisplayName)
+		if c.breakGlassAlert != nil {
+			c.breakGlassAlert(auth, req)
+		}
+	}
+
+	// Count the request against the mount it is routed to
+	if mount := c.router.MatchingMount(req.Path); mount != "" {
+		c.counters.Increment(mount)
+	}
+
+	// Replay the original response if this write was already made with
+	// the same idempotency key
+	idempotent := req.IdempotencyKey != "" &&
+		req.Operation == logical.WriteOperation &&
+		c.router.IdempotentPath(req.Path)
+	if idempotent {
+		unlock := c.idempotency.Lock(req.ClientToken, req.Path, req.IdempotencyKey)
+		defer unlock()
+		resp, ok, err := c.idempotency.Get(req.ClientToken, req.Path, req.IdempotencyKey)
+		if err != nil {
+			c.logger.Error("core: request %s: failed to lookup idempotency key: %v", req.ID, err)
+			return nil, ErrInternalError
+		}
+		if ok {
+			// Replays are never wrapped, as that would hand out the
+			// original response again under a new wrapping token
+			var replayErr error
+			if req.WrapTTL > 0 {
+				resp, replayErr = logical.ErrorResponse(
+					"a response was already returned for this idempotency key "+
+						"and cannot be wrapped"), logical.ErrInvalidRequest
+			} else {
+				resp, replayErr = c.replayIdempotent(resp)
+			}
+			if replayErr != nil && replayErr != logical.ErrInvalidRequest {
+				c.logger.Error("core: request %s: failed to check idempotent response: %v", req.ID, replayErr)
+				return nil, ErrInternalError
+			}
+			if err := c.auditBroker.LogResponse(auth, req, resp, replayErr); err != nil {
+				c.logger.Error("core: request %s: failed to audit response (request: %#v, response: %#v): %v",
+					req.ID, req, resp, err)
+				return nil, ErrInternalError
+			}
+			return resp, replayErr
+		}
+	}
 
 	// Route the request
 	resp, err := c.router.Route(req)
 
 	// If there is a secret, we must register it with the expiration manager.
+	defaultLease, maxLease := c.MountLeaseConfig(req.Path)
 	if resp != nil && resp.Secret != nil {
 		// Apply the default lease if none given
 		if resp.Secret.Lease == 0 {
-			resp.Secret.Lease = defaultLeaseDuration
+			resp.Secret.Lease = defaultLease
 		}
 
 		// Limit the lease duration
-		if resp.Secret.Lease > maxLeaseDuration {
-			resp.Secret.Lease = maxLeaseDuration
+		if resp.Secret.Lease > maxLease {
+			resp.Secret.Lease = maxLease
 		}
 
-		// Register the lease
-		leaseID, err := c.expiration.Register(req, resp)
-		if err != nil {
-			c.logger.Printf(
-				"[ERR] core: failed to register lease "+
-					"(request: %#v, response: %#v): %v", req, resp, err)
-			return nil, ErrInternalError
+		// Register the lease, unless the mount has leases disabled, in
+		// which case the secret is returned without a lease ID
+		if !c.mountLeasesDisabled(req.Path) {
+			leaseID, err := c.expiration.Register(req, resp)
+			if err != nil {
+				c.logger.Error(
+					"core: request %s: failed to register lease "+
+						"(request: %#v, response: %#v): %v", req.ID, req, resp, err)
+				return nil, ErrInternalError
+			}
+			resp.Secret.LeaseID = leaseID
 		}
-		resp.Secret.LeaseID = leaseID
 	}
 
 	// Only the token store is allowed to return an auth block, for any
 	// other request this is an internal error
 	if resp != nil && resp.Auth != nil {
 		if !strings.HasPrefix(req.Path, "auth/token/") {
-			c.logger.Printf(
-				"[ERR] core: unexpected Auth response for non-token backend "+
-					"(request: %#v, response: %#v)", req, resp)
+			c.logger.Error(
+				"core: request %s: unexpected Auth response for non-token backend "+
+					"(request: %#v, response: %#v)", req.ID, req, resp)
 			return nil, ErrInternalError
 		}
 
 		// Set the default lease if non-provided, root tokens are exempt
 		if resp.Auth.Lease == 0 && !strListContains(resp.Auth.Policies, "root") {
-			resp.Auth.Lease = defaultLeaseDuration
+			resp.Auth.Lease = defaultLease
 		}
 
 		// Limit the lease duration
-		if resp.Auth.Lease > maxLeaseDuration {
-			resp.Auth.Lease = maxLeaseDuration
+		if resp.Auth.Lease > maxLease {
+			resp.Auth.Lease = maxLease
 		}
 
 		// Register with the expiration manager
 		if err := c.expiration.RegisterAuth(req.Path, resp.Auth); err != nil {
-			c.logger.Printf("[ERR] core: failed to register token lease "+
-				"(request: %#v, response: %#v): %v", req, resp, err)
+			c.logger.Error("core: request %s: failed to register token lease "+
+				"(request: %#v, response: %#v): %v", req.ID, req, resp, err)
 			return nil, ErrInternalError
 		}
 	}
 
 	// Create an audit trail of the response
 	if err := c.auditBroker.LogResponse(auth, req, resp, err); err != nil {
-		c.logger.Printf("[ERR] core: failed to audit response (request: %#v, response: %#v): %v",
-			req, resp, err)
+		c.logger.Error("core: request %s: failed to audit response (request: %#v, response: %#v): %v",
+			req.ID, req, resp, err)
 		return nil, ErrInternalError
 	}
 
-	// Return the response and error
-	return resp, err
+	// Record the result of a successful keyed write for replay
+	if idempotent && err == nil && !resp.IsError() {
+		if err := c.idempotency.Put(req.ClientToken, req.Path, req.IdempotencyKey, resp); err != nil {
+			c.logger.Error("core: request %s: failed to record idempotency key: %v", req.ID, err)
+			return nil, ErrInternalError
+		}
+	}
+
+	// Return the response and error, wrapped if requested
+	return c.maybeWrapResponse(req, resp, err)
 }
 
 // handleLoginRequest is used to handle a login request, which is an
@@ -410,14 +916,32 @@ func (c *Core) handleLoginRequest(req *logical.Request) (*logical.Response, erro
 
 	// Create an audit trail of the request, auth is not available on login requests
 	if err := c.auditBroker.LogRequest(nil, req); err != nil {
-		c.logger.Printf("[ERR] core: failed to audit request (%#v): %v",
-			req, err)
+		c.logger.Error("core: request %s: failed to audit request (%#v): %v",
+			req.ID, req, err)
 		return nil, ErrInternalError
 	}
 
 	// Route the request
 	resp, err := c.router.Route(req)
 
+	// Refuse to generate a token without an explicit policy if required
+	if resp != nil && resp.Auth != nil && c.requireExplicitPolicy &&
+		!hasExplicitPolicy(resp.Auth.Policies) {
+		resp = logical.ErrorResponse(errNoExplicitPolicy.Error())
+		err = logical.ErrInvalidRequest
+	}
+
+	// Constrain the login to the token role of its backend, if any
+	var role *tsRoleEntry
+	if resp != nil && resp.Auth != nil {
+		var roleResp *logical.Response
+		var roleErr error
+		role, roleResp, roleErr = c.loginTokenRole(req.Path, resp.Auth.Policies)
+		if roleErr != nil {
+			resp, err = roleResp, roleErr
+		}
+	}
+
 	// If the response generated an authentication, then generate the token
 	var auth *logical.Auth
 	if resp != nil && resp.Auth != nil {
@@ -431,6 +955,9 @@ func (c *Core) handleLoginRequest(req *logical.Request) (*logical.Response, erro
 		// Prepend the source to the display name
 		auth.DisplayName = strings.TrimSuffix(source+auth.DisplayName, "-")
 
+		// Attach the default policy
+		auth.Policies = withDefaultPolicy(auth.Policies)
+
 		// Generate a token
 		te := TokenEntry{
 			Path:        req.Path,
@@ -438,28 +965,33 @@ func (c *Core) handleLoginRequest(req *logical.Request) (*logical.Response, erro
 			Meta:        auth.Metadata,
 			DisplayName: auth.DisplayName,
 		}
+		if role != nil {
+			te.TTL = role.TTL
+		}
 		if err := c.tokenStore.Create(&te); err != nil {
-			c.logger.Printf("[ERR] core: failed to create token: %v", err)
+			c.logger.Error("core: request %s: failed to create token: %v", req.ID, err)
 			return nil, ErrInternalError
 		}
 
-		// Populate the client token
+		// Populate the client token and its accessor
 		resp.Auth.ClientToken = te.ID
+		resp.Auth.Accessor = te.Accessor
 
 		// Set the default lease if non-provided, root tokens are exempt
+		defaultLease, maxLease := c.LeaseConfig()
 		if auth.Lease == 0 && !strListContains(auth.Policies, "root") {
-			auth.Lease = defaultLeaseDuration
+			auth.Lease = defaultLease
 		}
 
 		// Limit the lease duration
-		if resp.Auth.Lease > maxLeaseDuration {
-			resp.Auth.Lease = maxLeaseDuration
+		if resp.Auth.Lease > maxLease {
+			resp.Auth.Lease = maxLease
 		}
 
 		// Register with the expiration manager
 		if err := c.expiration.RegisterAuth(req.Path, auth); err != nil {
-			c.logger.Printf("[ERR] core: failed to register token lease "+
-				"(request: %#v, response: %#v): %v", req, resp, err)
+			c.logger.Error("core: request %s: failed to register token lease "+
+				"(request: %#v, response: %#v): %v", req.ID, req, resp, err)
 			return nil, ErrInternalError
 		}
 
@@ -469,8 +1001,8 @@ func (c *Core) handleLoginRequest(req *logical.Request) (*logical.Response, erro
 
 	// Create an audit trail of the response
 	if err := c.auditBroker.LogResponse(auth, req, resp, err); err != nil {
-		c.logger.Printf("[ERR] core: failed to audit response (request: %#v, response: %#v): %v",
-			req, resp, err)
+		c.logger.Error("core: request %s: failed to audit response (request: %#v, response: %#v): %v",
+			req.ID, req, resp, err)
 		return nil, ErrInternalError
 	}
 
@@ -479,56 +1011,118 @@ func (c *Core) handleLoginRequest(req *logical.Request) (*logical.Response, erro
 
 func (c *Core) checkToken(
 	op logical.Operation, path string, token string) (*logical.Auth, error) {
+	auth, _, err := c.checkTokenOverride(op, path, token, false)
+	return auth, err
+}
+
+// checkTokenOverride is like checkToken, but if breakGlass is set and the
+// token has the break-glass capability for the path, the operation is
+// permitted even if the ACL denies it. It returns whether the override
+// was needed to permit the operation.
+func (c *Core) checkTokenOverride(op logical.Operation, path string,
+	token string, breakGlass bool) (*logical.Auth, bool, error) {
 	defer metrics.MeasureSince([]string{"core", "check_token"}, time.Now())
 
 	// Ensure there is a client token
 	if token == "" {
-		return nil, fmt.Errorf("missing client token")
+		return nil, false, fmt.Errorf("missing client token")
 	}
 
 	// Resolve the token policy
 	te, err := c.tokenStore.Lookup(token)
 	if err != nil {
-		c.logger.Printf("[ERR] core: failed to lookup token: %v", err)
-		return nil, ErrInternalError
+		c.logger.Error("core: failed to lookup token: %v", err)
+		return nil, false, ErrInternalError
 	}
 
 	// Ensure the token is valid
 	if te == nil {
-		return nil, logical.ErrPermissionDenied
+		return nil, false, logical.ErrPermissionDenied
 	}
 
 	// Attempt to use the token
-	if err := c.tokenStore.UseToken(te); err != nil {
-		c.logger.Printf("[ERR] core: failed to use token: %v", err)
-		return nil, ErrInternalError
+	if err := c.tokenStore.UseToken(te); err == errTokenUsesExhausted {
+		return nil, false, logical.ErrPermissionDenied
+	} else if err != nil {
+		c.logger.Error("core: failed to use token: %v", err)
+		return nil, false, ErrInternalError
+	}
+
+	// Paths beneath a templated mount are only accessible to the token
+	// whose metadata matches the path, whatever its policies allow
+	if !c.router.TemplateAllowed(path, te.Meta) {
+		return nil, false, logical.ErrPermissionDenied
 	}
 
 	// Construct the corresponding ACL object
 	acl, err := c.policy.ACL(te.Policies...)
 	if err != nil {
-		c.logger.Printf("[ERR] core: failed to construct ACL: %v", err)
-		return nil, ErrInternalError
-	}
-
-	// Check if this is a root protected path
-	if c.router.RootPath(path) && !acl.RootPrivilege(path) {
-		return nil, logical.ErrPermissionDenied
+		c.logger.Error("core: failed to construct ACL: %v", err)
+		return nil, false, ErrInternalError
 	}
 
-	// Check the standard non-root ACLs
-	if !acl.AllowOperation(op, path) {
-		return nil, logical.ErrPermissionDenied
+	// Check the ACL, the break-glass override bypasses the checks if granted
+	allowed, err := c.aclAllowed(acl, op, path)
+	if err != nil {
+		c.logger.Error("core: failed to check existence of '%s': %v", path, err)
+		return nil, false, ErrInternalError
+	}
+	override := false
+	if !allowed {
+		// Root protected paths always require sudo, the override never
+		// grants access to them
+		if c.router.RootPath(path) || !breakGlass || !acl.BreakGlass(path) {
+			return nil, false, logical.ErrPermissionDenied
+		}
+		override = true
 	}
 
 	// Create the auth response
 	auth := &logical.Auth{
 		ClientToken: token,
+		Accessor:    te.Accessor,
 		Policies:    te.Policies,
 		Metadata:    te.Meta,
 		DisplayName: te.DisplayName,
 	}
-	return auth, nil
+	return auth, override, nil
+}
+
+// aclAllowed checks if the ACL permits the operation on the path. The
+// standard non-root ACLs are checked, and if this is a root protected
+// path, that sudo is granted. A write without the update capability is
+// permitted as a create if nothing exists at the path yet.
+func (c *Core) aclAllowed(acl *ACL, op logical.Operation, path string) (bool, error) {
+	allowed, sudo := acl.AllowOperation(op, path)
+	if !allowed && op == logical.WriteOperation && acl.AllowCreate(path) {
+		exists, err := c.pathExists(path)
+		if err != nil {
+			return false, err
+		}
+		allowed = !exists
+	}
+	return allowed && (!c.router.RootPath(path) || sudo), nil
+}
+
+// pathExists checks if anything exists at the given path. A path is
+// considered to exist if its backend does not support the existence check.
+func (c *Core) pathExists(path string) (bool, error) {
+	resp, err := c.router.Route(&logical.Request{
+		Operation: logical.ExistenceCheckOperation,
+		Path:      path,
+	})
+	switch err.(type) {
+	case nil:
+	case ErrNoRoute:
+		return true, nil
+	default:
+		switch err {
+		case logical.ErrUnsupportedOperation, logical.ErrUnsupportedPath:
+			return true, nil
+		}
+		return false, err
+	}
+	return resp == nil || resp.Data["exists"] != false, nil
 }
 
 // Initialized checks if the Vault is already initialized
@@ -536,14 +1130,14 @@ func (c *Core) Initialized() (bool, error) {
 	// Check the barrier first
 	init, err := c.barrier.Initialized()
 	if err != nil {
-		c.logger.Printf("[ERR] core: barrier init check failed: %v", err)
+		c.logger.Error("core: barrier init check failed: %v", err)
 		return false, err
 	}
 	if !init {
 		return false, nil
 	}
 	if !init {
-		c.logger.Printf("[INFO] core: security barrier not initialized")
+		c.logger.Info("core: security barrier not initialized")
 		return false, nil
 	}
 
@@ -563,7 +1157,7 @@ func (c *Core) Initialized() (bool, error) {
 func (c *Core) Initialize(config *SealConfig) (*InitResult, error) {
 	// Check if the seal configuraiton is valid
 	if err := config.Validate(); err != nil {
-		c.logger.Printf("[ERR] core: invalid seal configuration: %v", err)
+		c.logger.Error("core: invalid seal configuration: %v", err)
 		return nil, fmt.Errorf("invalid seal configuration: %v", err)
 	}
 
@@ -586,76 +1180,97 @@ func (c *Core) Initialize(config *SealConfig) (*InitResult, error) {
 		return nil, fmt.Errorf("failed to encode seal configuration: %v", err)
 	}
 
+	// Generate a master key
+	masterKey, err := c.barrier.GenerateKey()
+	if err != nil {
+		c.logger.Error("core: failed to generate master key: %v", err)
+		return nil, fmt.Errorf("master key generation failed: %v", err)
+	}
+
+	// Encrypt the master key for auto-unseal first, so that nothing is
+	// stored if the KMS is unreachable
+	var sealedKey []byte
+	if c.masterKeySource != nil {
+		sealedKey, err = c.masterKeySource.Seal(masterKey)
+		if err != nil {
+			c.logger.Error("core: failed to seal master key: %v", err)
+			return nil, fmt.Errorf("failed to seal master key: %v", err)
+		}
+	}
+
 	// Store the seal configuration
 	pe := &physical.Entry{
 		Key:   coreSealConfigPath,
 		Value: buf,
 	}
 	if err := c.physical.Put(pe); err != nil {
-		c.logger.Printf("[ERR] core: failed to read seal configuration: %v", err)
+		c.logger.Error("core: failed to read seal configuration: %v", err)
 		return nil, fmt.Errorf("failed to check seal configuration: %v", err)
 	}
 
-	// Generate a master key
-	masterKey, err := c.barrier.GenerateKey()
-	if err != nil {
-		c.logger.Printf("[ERR] core: failed to generate master key: %v", err)
-		return nil, fmt.Errorf("master key generation failed: %v", err)
-	}
-
 	// Initialize the barrier
 	if err := c.barrier.Initialize(masterKey); err != nil {
-		c.logger.Printf("[ERR] core: failed to initialize barrier: %v", err)
+		c.logger.Error("core: failed to initialize barrier: %v", err)
 		return nil, fmt.Errorf("failed to initialize barrier: %v", err)
 	}
 
-	// Return the master key if only a single key part is used
-	results := new(InitResult)
-	if config.SecretShares == 1 {
-		results.SecretShares = append(results.SecretShares, masterKey)
+	// Store the sealed master key
+	if sealedKey != nil {
+		if err := c.physical.Put(&physical.Entry{
+			Key:   coreMasterKeyPath,
+			Value: sealedKey,
+		}); err != nil {
+			c.logger.Error("core: failed to store sealed master key: %v", err)
+			return nil, fmt.Errorf("failed to store sealed master key: %v", err)
+		}
+	}
 
-	} else {
-		// Split the master key using the Shamir algorithm
-		shares, err := shamir.Split(masterKey, config.SecretShares, config.SecretThreshold)
-		if err != nil {
-			c.logger.Printf("[ERR] core: failed to generate shares: %v", err)
-			return nil, fmt.Errorf("failed to generate shares: %v", err)
+	// Split the master key into the shares to return
+	results := new(InitResult)
+	results.SecretShares, err = c.splitMasterKey(config, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	results.Shares = make([]SealKeyShare, len(results.SecretShares))
+	for i, share := range results.SecretShares {
+		results.Shares[i].Key = share
+		if len(config.ShareLabels) > 0 {
+			results.Shares[i].Label = config.ShareLabels[i]
 		}
-		results.SecretShares = shares
 	}
-	c.logger.Printf("[INFO] core: security barrier initialized")
+	c.logger.Info("core: security barrier initialized")
 
 	// Unseal the barrier
 	if err := c.barrier.Unseal(masterKey); err != nil {
-		c.logger.Printf("[ERR] core: failed to unseal barrier: %v", err)
+		c.logger.Error("core: failed to unseal barrier: %v", err)
 		return nil, fmt.Errorf("failed to unseal barrier: %v", err)
 	}
 
 	// Ensure the barrier is re-sealed
 	defer func() {
 		if err := c.barrier.Seal(); err != nil {
-			c.logger.Printf("[ERR] core: failed to seal barrier: %v", err)
+			c.logger.Error("core: failed to seal barrier: %v", err)
 		}
 	}()
 
 	// Perform initial setup
 	if err := c.postUnseal(); err != nil {
-		c.logger.Printf("[ERR] core: post-unseal setup failed: %v", err)
+		c.logger.Error("core: post-unseal setup failed: %v", err)
 		return nil, err
 	}
 
 	// Generate a new root token
 	rootToken, err := c.tokenStore.RootToken()
 	if err != nil {
-		c.logger.Printf("[ERR] core: root token generation failed: %v", err)
+		c.logger.Error("core: root token generation failed: %v", err)
 		return nil, err
 	}
 	results.RootToken = rootToken.ID
-	c.logger.Printf("[INFO] core: root token generated")
+	c.logger.Info("core: root token generated")
 
 	// Prepare to re-seal
 	if err := c.preSeal(); err != nil {
-		c.logger.Printf("[ERR] core: pre-seal teardown failed: %v", err)
+		c.logger.Error("core: pre-seal teardown failed: %v", err)
 		return nil, err
 	}
 	return results, nil
@@ -675,10 +1290,38 @@ func (c *Core) Standby() (bool, error) {
 	return c.standby, nil
 }
 
-// Leader is used to get the current active leader
-func (c *Core) Leader() (bool, string, error) {
-	c.stateLock.RLock()
-	defer c.stateLock.RUnlock()
+// QueryPath checks if reads of the given path take their parameters
+// from the query string of the HTTP request
+func (c *Core) QueryPath(path string) bool {
+	return c.router.QueryPath(path)
+}
+
+// PrometheusSink returns the sink holding the metrics to expose for
+// scraping, or nil if it is not enabled
+func (c *Core) PrometheusSink() *prometheussink.Sink {
+	return c.prometheusSink
+}
+
+// Leader is used to get the current active leader. A missing lock
+// value is retried a few times, releasing the stateLock in between.
+func (c *Core) Leader() (isLeader bool, advertise string, err error) {
+	for attempt := 0; attempt < leaderValueAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(leaderValueRetryInterval)
+		}
+		c.stateLock.RLock()
+		isLeader, advertise, err = c.leaderLocked()
+		c.stateLock.RUnlock()
+		if err != ErrLeaderUnknown {
+			return
+		}
+	}
+	return
+}
+
+// leaderLocked is used to get the current active leader. It must be
+// called with the stateLock held.
+func (c *Core) leaderLocked() (bool, string, error) {
 	// Check if HA enabled
 	if c.ha == nil {
 		return false, "", ErrHANotEnabled
@@ -708,6 +1351,9 @@ func (c *Core) Leader() (bool, string, error) {
 	if !held {
 		return false, "", nil
 	}
+	if value == "" {
+		return false, "", ErrLeaderUnknown
+	}
 
 	// Value is the UUID of the leader, fetch the key
 	key := coreLeaderPrefix + value
@@ -716,7 +1362,7 @@ func (c *Core) Leader() (bool, string, error) {
 		return false, "", err
 	}
 	if entry == nil {
-		return false, "", nil
+		return false, "", ErrLeaderUnknown
 	}
 
 	// Leader address is in the entry
@@ -730,31 +1376,56 @@ func (c *Core) SealConfig() (*SealConfig, error) {
 	// Fetch the core configuration
 	pe, err := c.physical.Get(coreSealConfigPath)
 	if err != nil {
-		c.logger.Printf("[ERR] core: failed to read seal configuration: %v", err)
+		c.logger.Error("core: failed to read seal configuration: %v", err)
 		return nil, fmt.Errorf("failed to check seal configuration: %v", err)
 	}
 
 	// If the seal configuration is missing, we are not initialized
 	if pe == nil {
-		c.logger.Printf("[INFO] core: seal configuration missing, not initialized")
+		c.logger.Info("core: seal configuration missing, not initialized")
 		return nil, nil
 	}
 
 	// Decode the barrier entry
 	var conf SealConfig
 	if err := json.Unmarshal(pe.Value, &conf); err != nil {
-		c.logger.Printf("[ERR] core: failed to decode seal configuration: %v", err)
+		c.logger.Error("core: failed to decode seal configuration: %v", err)
 		return nil, fmt.Errorf("failed to decode seal configuration: %v", err)
 	}
 
 	// Check for a valid seal configuration
 	if err := conf.Validate(); err != nil {
-		c.logger.Printf("[ERR] core: invalid seal configuration: %v", err)
+		c.logger.Error("core: invalid seal configuration: %v", err)
 		return nil, fmt.Errorf("seal validation failed: %v", err)
 	}
 	return &conf, nil
 }
 
+// SealStatus returns the seal state of the Vault, the seal configuration
+// and the progress of the unseal, read together under the state lock so
+// that they are consistent. ErrNotInit is returned if the Vault is not
+// initialized.
+func (c *Core) SealStatus() (*SealStatus, error) {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+
+	config, err := c.SealConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, ErrNotInit
+	}
+
+	return &SealStatus{
+		Sealed:          c.sealed,
+		SecretThreshold: config.SecretThreshold,
+		SecretShares:    config.SecretShares,
+		Progress:        len(c.unlockParts),
+		Nonce:           c.unlockNonce,
+	}, nil
+}
+
 // SecretProgress returns the number of keys provided so far
 func (c *Core) SecretProgress() int {
 	c.stateLock.RLock()
@@ -762,33 +1433,55 @@ func (c *Core) SecretProgress() int {
 	return len(c.unlockParts)
 }
 
+// checkKeyLength is used to verify the length of an unseal key
+func (c *Core) checkKeyLength(key []byte) error {
+	min, max := c.barrier.KeyLength()
+	max += shamir.ShareOverhead
+	if len(key) < min {
+		return &ErrInvalidKey{fmt.Sprintf("key is shorter than minimum %d bytes", min)}
+	}
+	if len(key) > max {
+		return &ErrInvalidKey{fmt.Sprintf("key is longer than maximum %d bytes", max)}
+	}
+	return nil
+}
+
 // Unseal is used to provide one of the key parts to unseal the Vault.
+// Only the first part of an unseal can be provided without a nonce, so
+// the following parts must be provided with UnsealWithNonce.
 //
 // They key given as a parameter will automatically be zerod after
 // this method is done with it. If you want to keep the key around, a copy
 // should be made.
 func (c *Core) Unseal(key []byte) (bool, error) {
+	unsealed, _, err := c.UnsealWithNonce("", key)
+	return unsealed, err
+}
+
+// UnsealWithNonce is used to provide one of the key parts to unseal the
+// Vault as part of an unseal identified by a nonce. The first part
+// starts the unseal and generates its nonce, and every following part
+// must be provided with the same nonce. Providing the wrong nonce
+// resets the unseal in progress, so that a stale key cannot be slipped
+// into the unseal of other operators. The nonce of the unseal in
+// progress is returned, which is empty once unsealed.
+func (c *Core) UnsealWithNonce(nonce string, key []byte) (bool, string, error) {
 	defer metrics.MeasureSince([]string{"core", "unseal"}, time.Now())
 
 	// Verify the key length
-	min, max := c.barrier.KeyLength()
-	max += shamir.ShareOverhead
-	if len(key) < min {
-		return false, &ErrInvalidKey{fmt.Sprintf("key is shorter than minimum %d bytes", min)}
-	}
-	if len(key) > max {
-		return false, &ErrInvalidKey{fmt.Sprintf("key is longer than maximum %d bytes", max)}
+	if err := c.checkKeyLength(key); err != nil {
+		return false, "", err
 	}
 
 	// Get the seal configuration
 	config, err := c.SealConfig()
 	if err != nil {
-		return false, err
+		return false, "", err
 	}
 
 	// Ensure the barrier is initialized
 	if config == nil {
-		return false, ErrNotInit
+		return false, "", ErrNotInit
 	}
 
 	c.stateLock.Lock()
@@ -796,24 +1489,40 @@ func (c *Core) Unseal(key []byte) (bool, error) {
 
 	// Check if already unsealed
 	if !c.sealed {
-		return true, nil
+		return true, "", nil
+	}
+
+	// The teardown of a seal must complete before unsealing again
+	if c.sealing {
+		return false, "", ErrSealing
+	}
+
+	// Parts must be provided with the nonce of the unseal in progress
+	if len(c.unlockParts) > 0 &&
+		subtle.ConstantTimeCompare([]byte(nonce), []byte(c.unlockNonce)) != 1 {
+		c.logger.Warn("core: unseal nonce mismatch, resetting unseal progress")
+		c.resetUnlockParts()
+		return false, "", ErrUnsealNonceMismatch
 	}
 
 	// Check if we already have this piece
 	for _, existing := range c.unlockParts {
-		if bytes.Equal(existing, key) {
-			return false, nil
+		if subtle.ConstantTimeCompare(existing, key) == 1 {
+			return false, c.unlockNonce, nil
 		}
 	}
 
-	// Store this key
+	// Store this key, starting a new unseal if it is the first
+	if len(c.unlockParts) == 0 {
+		c.unlockNonce = generateUUID()
+	}
 	c.unlockParts = append(c.unlockParts, key)
 
 	// Check if we don't have enough keys to unlock
 	if len(c.unlockParts) < config.SecretThreshold {
-		c.logger.Printf("[DEBUG] core: cannot unseal, have %d of %d keys",
+		c.logger.Debug("core: cannot unseal, have %d of %d keys",
 			len(c.unlockParts), config.SecretThreshold)
-		return false, nil
+		return false, c.unlockNonce, nil
 	}
 
 	// Recover the master key
@@ -823,38 +1532,95 @@ func (c *Core) Unseal(key []byte) (bool, error) {
 		c.unlockParts = nil
 	} else {
 		masterKey, err = shamir.Combine(c.unlockParts)
-		c.unlockParts = nil
+		c.resetUnlockParts()
 		if err != nil {
-			return false, fmt.Errorf("failed to compute master key: %v", err)
+			return false, "", fmt.Errorf("failed to compute master key: %v", err)
 		}
 	}
+	c.unlockNonce = ""
 	defer memzero(masterKey)
 
+	if err := c.unsealMasterKey(masterKey); err != nil {
+		return false, "", err
+	}
+
+	// Store the master key for auto-unseal if it is not stored yet, such
+	// as when the source is configured after initialization
+	c.storeMissingMasterKey(masterKey)
+	return true, "", nil
+}
+
+// UnsealNonce returns the nonce of the unseal in progress, or an empty
+// string if no key parts have been provided
+func (c *Core) UnsealNonce() string {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	return c.unlockNonce
+}
+
+// unsealMasterKey is used to unseal the Vault using the master key,
+// however it was recovered. The state lock must be held.
+func (c *Core) unsealMasterKey(masterKey []byte) error {
 	// Attempt to unlock
 	if err := c.barrier.Unseal(masterKey); err != nil {
-		return false, err
+		return err
 	}
-	c.logger.Printf("[INFO] core: vault is unsealed")
+	c.logger.Info("core: vault is unsealed")
 
 	// Do post-unseal setup if HA is not enabled
 	if c.ha == nil {
 		c.standby = false
 		if err := c.postUnseal(); err != nil {
-			c.logger.Printf("[ERR] core: post-unseal setup failed: %v", err)
+			c.logger.Error("core: post-unseal setup failed: %v", err)
+			c.preSeal()
 			c.barrier.Seal()
-			c.logger.Printf("[WARN] core: vault is sealed")
-			return false, err
+			c.logger.Warn("core: vault is sealed")
+			return err
 		}
 	} else {
 		// Go to standby mode, wait until we are active to unseal
 		c.standbyDoneCh = make(chan struct{})
 		c.standbyStopCh = make(chan struct{})
-		go c.runStandby(c.standbyDoneCh, c.standbyStopCh)
+		c.manualStepDownCh = make(chan struct{}, 1)
+		go c.runStandby(c.standbyDoneCh, c.standbyStopCh, c.manualStepDownCh)
 	}
 
+	// Start checking the health of the physical backend
+	c.healthCh = make(chan struct{})
+	go c.checkHealth(c.healthCh)
+
 	// Success!
 	c.sealed = false
-	return true, nil
+	return nil
+}
+
+// ResetUnsealProcess is used to discard the key parts provided so far,
+// such as after a mistaken key, so that the unseal can be started over.
+// The number of parts discarded is returned, which is zero if the Vault
+// is already unsealed.
+func (c *Core) ResetUnsealProcess() int {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	if !c.sealed {
+		return 0
+	}
+
+	discarded := len(c.unlockParts)
+	c.resetUnlockParts()
+	if discarded > 0 {
+		c.logger.Info("core: unseal progress reset, discarded %d keys", discarded)
+	}
+	return discarded
+}
+
+// resetUnlockParts is used to discard the key parts provided so far,
+// zeroing them. The state lock must be held.
+func (c *Core) resetUnlockParts() {
+	for _, part := range c.unlockParts {
+		memzero(part)
+	}
+	c.unlockParts = nil
+	c.unlockNonce = ""
 }
 
 // Seal is used to re-seal the Vault. This requires the Vault to
@@ -863,6 +1629,8 @@ func (c *Core) Seal(token string) error {
 	defer metrics.MeasureSince([]string{"core", "seal"}, time.Now())
 	c.stateLock.Lock()
 	defer c.stateLock.Unlock()
+
+	// Nothing to do if sealed, or if another seal is in progress
 	if c.sealed {
 		return nil
 	}
@@ -873,20 +1641,104 @@ func (c *Core) Seal(token string) error {
 		return err
 	}
 
-	// Enable that we are sealed to prevent furthur transactions
+	// A secure seal requires the unseal keys as well
+	config, err := c.SealConfig()
+	if err != nil {
+		return err
+	}
+	if config != nil && config.SecureSeal {
+		return ErrSecureSealRequired
+	}
+	return c.sealLocked()
+}
+
+// Shutdown is used to stop the Core for a clean exit of the process. If
+// unsealed, the active operation is torn down and the HA lock is
+// released, so that a standby can take over without waiting for the
+// lock to expire. The Core is left sealed. This can be called more than
+// once, and from a signal handler.
+func (c *Core) Shutdown() error {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	if c.sealed {
+		return nil
+	}
+	c.logger.Info("core: shutting down")
+	return c.sealLocked()
+}
+
+// EmergencySeal is used to seal the Vault without a token, discarding
+// the master key. It is intended for an application embedding Vault to
+// fail closed when it detects an unrecoverable failure. The reason is
+// logged.
+func (c *Core) EmergencySeal(reason string) error {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	if c.sealed {
+		return nil
+	}
+	metrics.IncrCounter([]string{"core", "emergency_seal"}, 1)
+	return c.emergencySealLocked(reason)
+}
+
+// emergencySealLocked is used to seal the Vault on a failure. The master
+// key is discarded even if the teardown fails, which is likely when the
+// physical backend is failing. The state lock must be held.
+func (c *Core) emergencySealLocked(reason string) error {
+	c.logger.Warn("core: emergency seal: %s", reason)
+	if err := c.sealLocked(); err != nil {
+		c.barrier.Seal()
+		c.logger.Warn("core: vault is sealed")
+		return err
+	}
+	return nil
+}
+
+// sealOnRequestPanic is deferred by HandleRequest to seal the Vault if
+// the request panics. The seal must wait for the state lock, which is
+// held by the request, so it is done in the background before the panic
+// continues.
+func (c *Core) sealOnRequestPanic() {
+	if r := recover(); r != nil {
+		go c.EmergencySeal(fmt.Sprintf("request panicked: %v", r))
+		panic(r)
+	}
+}
+
+// sealLocked is used to seal the Vault once it has been authorized. The
+// state lock must be held, and the Vault must be unsealed.
+func (c *Core) sealLocked() error {
+	// Enable that we are sealed to prevent furthur transactions. This is
+	// done while holding the lock, so no request is admitted once the
+	// seal has begun, even while the lock is released below.
 	c.sealed = true
+	c.sealing = true
+	defer func() {
+		c.sealing = false
+	}()
+	c.resetSealParts()
+
+	// Abandon any root token generation in progress
+	c.generateRootLock.Lock()
+	c.resetGenerateRoot()
+	c.generateRootLock.Unlock()
+
+	// Stop checking the health of the physical backend
+	close(c.healthCh)
+	c.healthCh = nil
 
 	// Do pre-seal teardown if HA is not enabled
 	if c.ha == nil {
 		if err := c.preSeal(); err != nil {
-			c.logger.Printf("[ERR] core: pre-seal teardown failed: %v", err)
+			c.logger.Error("core: pre-seal teardown failed: %v", err)
 			return fmt.Errorf("internal error")
 		}
 	} else {
 		// Signal the standby goroutine to shutdown, wait for completion
 		close(c.standbyStopCh)
 
-		// Release the lock while we wait to avoid deadlocking
+		// Release the lock while we wait to avoid deadlocking, the
+		// standby goroutine needs it to complete the teardown
 		c.stateLock.Unlock()
 		<-c.standbyDoneCh
 		c.stateLock.Lock()
@@ -895,20 +1747,177 @@ func (c *Core) Seal(token string) error {
 	if err := c.barrier.Seal(); err != nil {
 		return err
 	}
-	c.logger.Printf("[INFO] core: vault is sealed")
+	c.logger.Info("core: vault is sealed")
+	return nil
+}
+
+// StepDown is used to step down from leadership. The Vault remains
+// unsealed and enters standby mode, so another Vault can become active.
+// This requires a root token.
+func (c *Core) StepDown(token string) error {
+	defer metrics.MeasureSince([]string{"core", "step_down"}, time.Now())
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.sealed {
+		return ErrSealed
+	}
+	if c.ha == nil {
+		return ErrHANotEnabled
+	}
+	if c.standby {
+		return ErrStandby
+	}
+
+	// Validate the token is a root token
+	if _, err := c.checkToken(logical.WriteOperation, "sys/step-down", token); err != nil {
+		return err
+	}
+
+	// Signal the standby goroutine, unless a step down is already queued
+	select {
+	case c.manualStepDownCh <- struct{}{}:
+	default:
+		c.logger.Warn("core: manual step down already queued")
+	}
 	return nil
 }
 
+// RevokeRootToken is used by a root token to revoke itself. This allows
+// a deployment to ensure that no root token remains once setup is done.
+// Any child tokens are orphaned rather than revoked.
+func (c *Core) RevokeRootToken(token string) error {
+	defer metrics.MeasureSince([]string{"core", "revoke_root_token"}, time.Now())
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.sealed {
+		return ErrSealed
+	}
+	if c.standby {
+		return ErrStandby
+	}
+
+	// Validate the token is a root token
+	auth, err := c.checkToken(logical.WriteOperation, "sys/revoke-root", token)
+	if err != nil {
+		return err
+	}
+
+	// Create an audit trail of the revocation
+	req := &logical.Request{
+		Operation:   logical.WriteOperation,
+		Path:        "sys/revoke-root",
+		ClientToken: token,
+		DisplayName: auth.DisplayName,
+	}
+	if err := c.auditBroker.LogRequest(auth, req); err != nil {
+		c.logger.Error("core: failed to audit request (%#v): %v",
+			req, err)
+		return ErrInternalError
+	}
+	c.logger.Warn("core: revoking root token (display name: %s)",
+		auth.DisplayName)
+
+	// Revoke the token and audit the result
+	err = c.tokenStore.Revoke(token)
+	if err != nil {
+		c.logger.Error("core: failed to revoke root token: %v", err)
+		err = ErrInternalError
+	}
+	if err := c.auditBroker.LogResponse(auth, req, nil, err); err != nil {
+		c.logger.Error("core: failed to audit response (request: %#v): %v",
+			req, err)
+		return ErrInternalError
+	}
+	return err
+}
+
+// Rotate is used by a root token to add a new encryption key to the
+// barrier. The new key is used for all writes from then on, while
+// values written with the previous keys remain readable, so this is
+// safe on a live active node. The existing values are rewrapped with
+// the new key in the background. The term of the new key is returned.
+func (c *Core) Rotate(token string) (uint32, error) {
+	defer metrics.MeasureSince([]string{"core", "rotate"}, time.Now())
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.sealed {
+		return 0, ErrSealed
+	}
+	if c.standby {
+		return 0, ErrStandby
+	}
+
+	// Validate the token is a root token
+	auth, err := c.checkToken(logical.WriteOperation, "sys/rotate", token)
+	if err != nil {
+		return 0, err
+	}
+
+	// Create an audit trail of the rotation
+	req := &logical.Request{
+		Operation:   logical.WriteOperation,
+		Path:        "sys/rotate",
+		ClientToken: token,
+		DisplayName: auth.DisplayName,
+	}
+	if err := c.auditBroker.LogRequest(auth, req); err != nil {
+		c.logger.Error("core: failed to audit request (%#v): %v",
+			req, err)
+		return 0, ErrInternalError
+	}
+
+	// Rotate the key and audit the result
+	term, err := c.barrier.Rotate()
+	if err != nil {
+		c.logger.Error("core: failed to rotate encryption key: %v", err)
+		err = ErrInternalError
+	} else {
+		c.logger.Info("core: installed encryption key term %d", term)
+		c.rewrap.Trigger()
+	}
+	if err := c.auditBroker.LogResponse(auth, req, nil, err); err != nil {
+		c.logger.Error("core: failed to audit response (request: %#v): %v",
+			req, err)
+		return 0, ErrInternalError
+	}
+	return term, err
+}
+
+// ActiveTerm returns the term of the encryption key that is used for
+// all new writes. It is only known by the active Vault, as the keyring
+// of a standby is not reloaded until it becomes active.
+func (c *Core) ActiveTerm() (uint32, error) {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.sealed {
+		return 0, ErrSealed
+	}
+	if c.standby {
+		return 0, ErrStandby
+	}
+	return c.barrier.ActiveTerm()
+}
+
 // postUnseal is invoked after the barrier is unsealed, but before
 // allowing any user operations. This allows us to setup any state that
 // requires the Vault to be unsealed such as mount tables, logical backends,
 // credential stores, etc.
 func (c *Core) postUnseal() error {
 	defer metrics.MeasureSince([]string{"core", "post_unseal"}, time.Now())
-	c.logger.Printf("[INFO] core: post-unseal setup starting")
+	c.logger.Info("core: post-unseal setup starting")
 	if cache, ok := c.physical.(*physical.Cache); ok {
 		cache.Purge()
 	}
+	if err := c.barrier.ReloadKeyring(); err != nil {
+		return err
+	}
+	c.counters = newRequestCounters()
+	if err := c.loadLeaseConfig(); err != nil {
+		return err
+	}
+	if err := c.loadRateLimitConfig(); err != nil {
+		return err
+	}
 	if err := c.loadMounts(); err != nil {
 		return err
 	}
@@ -918,6 +1927,9 @@ func (c *Core) postUnseal() error {
 	if err := c.startRollback(); err != nil {
 		return err
 	}
+	if err := c.startRewrap(); err != nil {
+		return err
+	}
 	if err := c.setupPolicyStore(); err != nil {
 		return nil
 	}
@@ -936,9 +1948,15 @@ func (c *Core) postUnseal() error {
 	if err := c.setupAudits(); err != nil {
 		return err
 	}
+	if err := c.setupIdempotency(); err != nil {
+		return err
+	}
+	if err := c.startScheduler(); err != nil {
+		return err
+	}
 	c.metricsCh = make(chan struct{})
 	go c.emitMetrics(c.metricsCh)
-	c.logger.Printf("[INFO] core: post-unseal setup complete")
+	c.logger.Info("core: post-unseal setup complete")
 	return nil
 }
 
@@ -946,11 +1964,17 @@ func (c *Core) postUnseal() error {
 // for any state teardown required.
 func (c *Core) preSeal() error {
 	defer metrics.MeasureSince([]string{"core", "pre_seal"}, time.Now())
-	c.logger.Printf("[INFO] core: pre-seal teardown starting")
+	c.logger.Info("core: pre-seal teardown starting")
 	if c.metricsCh != nil {
 		close(c.metricsCh)
 		c.metricsCh = nil
 	}
+	if err := c.stopScheduler(); err != nil {
+		return err
+	}
+	if err := c.teardownIdempotency(); err != nil {
+		return err
+	}
 	if err := c.teardownAudits(); err != nil {
 		return err
 	}
@@ -966,22 +1990,25 @@ func (c *Core) preSeal() error {
 	if err := c.stopRollback(); err != nil {
 		return err
 	}
+	if err := c.stopRewrap(); err != nil {
+		return err
+	}
 	if err := c.unloadMounts(); err != nil {
 		return err
 	}
 	if cache, ok := c.physical.(*physical.Cache); ok {
 		cache.Purge()
 	}
-	c.logger.Printf("[INFO] core: pre-seal teardown complete")
+	c.logger.Info("core: pre-seal teardown complete")
 	return nil
 }
 
 // runStandby is a long running routine that is used when an HA backend
 // is enabled. It waits until we are leader and switches this Vault to
 // active.
-func (c *Core) runStandby(doneCh, stopCh chan struct{}) {
+func (c *Core) runStandby(doneCh, stopCh, manualStepDownCh chan struct{}) {
 	defer close(doneCh)
-	c.logger.Printf("[INFO] core: entering standby mode")
+	c.logger.Info("core: entering standby mode")
 	for {
 		// Check for a shutdown
 		select {
@@ -994,7 +2021,7 @@ func (c *Core) runStandby(doneCh, stopCh chan struct{}) {
 		uuid := generateUUID()
 		lock, err := c.ha.LockWith(coreLockPath, uuid)
 		if err != nil {
-			c.logger.Printf("[ERR] core: failed to create lock: %v", err)
+			c.logger.Error("core: failed to create lock: %v", err)
 			return
 		}
 
@@ -1005,41 +2032,58 @@ func (c *Core) runStandby(doneCh, stopCh chan struct{}) {
 		if leaderCh == nil {
 			return
 		}
-		c.logger.Printf("[INFO] core: acquired lock, enabling active operation")
+		c.logger.Info("core: acquired lock, enabling active operation")
 
 		// Advertise ourself as leader
 		if err := c.advertiseLeader(uuid); err != nil {
-			c.logger.Printf("[ERR] core: leader advertisement setup failed: %v", err)
+			c.logger.Error("core: leader advertisement setup failed: %v", err)
 			lock.Unlock()
 			continue
 		}
 
-		// Attempt the post-unseal process
+		// Attempt the post-unseal process, unless a seal began while
+		// we were acquiring the lock
 		c.stateLock.Lock()
+		select {
+		case <-stopCh:
+			c.stateLock.Unlock()
+			if err := c.clearLeader(uuid); err != nil {
+				c.logger.Error("core: clearing leader advertisement failed: %v", err)
+			}
+			lock.Unlock()
+			return
+		default:
+		}
 		err = c.postUnseal()
 		if err == nil {
 			c.standby = false
+		} else {
+			c.preSeal()
 		}
 		c.stateLock.Unlock()
 
 		// Handle a failure to unseal
 		if err != nil {
-			c.logger.Printf("[ERR] core: post-unseal setup failed: %v", err)
+			c.logger.Error("core: post-unseal setup failed: %v", err)
 			lock.Unlock()
 			continue
 		}
 
 		// Monitor a loss of leadership
+		manualStepDown := false
 		select {
 		case <-leaderCh:
-			c.logger.Printf("[WARN] core: leadership lost, stopping active operation")
+			c.logger.Warn("core: leadership lost, stopping active operation")
 		case <-stopCh:
-			c.logger.Printf("[WARN] core: stopping active operation")
+			c.logger.Warn("core: stopping active operation")
+		case <-manualStepDownCh:
+			c.logger.Warn("core: stepping down from active operation to standby")
+			manualStepDown = true
 		}
 
 		// Clear ourself as leader
 		if err := c.clearLeader(uuid); err != nil {
-			c.logger.Printf("[ERR] core: clearing leader advertisement failed: %v", err)
+			c.logger.Error("core: clearing leader advertisement failed: %v", err)
 		}
 
 		// Attempt the pre-seal process
@@ -1052,10 +2096,19 @@ func (c *Core) runStandby(doneCh, stopCh chan struct{}) {
 		lock.Unlock()
 
 		// Check for a failure to prepare to seal
-		if err := c.preSeal(); err != nil {
-			c.logger.Printf("[ERR] core: pre-seal teardown failed: %v", err)
+		if err != nil {
+			c.logger.Error("core: pre-seal teardown failed: %v", err)
 			continue
 		}
+
+		// Give another Vault the chance to acquire the lock
+		if manualStepDown {
+			select {
+			case <-time.After(manualStepDownSleepPeriod):
+			case <-stopCh:
+				return
+			}
+		}
 	}
 }
 
@@ -1069,7 +2122,7 @@ func (c *Core) acquireLock(lock physical.Lock, stopCh <-chan struct{}) <-chan st
 		}
 
 		// Retry the acquisition
-		c.logger.Printf("[ERR] core: failed to acquire lock: %v", err)
+		c.logger.Error("core: failed to acquire lock: %v", err)
 		select {
 		case <-time.After(lockRetryInterval):
 		case <-stopCh:
@@ -1093,14 +2146,137 @@ func (c *Core) clearLeader(uuid string) error {
 	return c.barrier.Delete(key)
 }
 
+// StorageHealth returns the result of the last health check of the
+// physical backend, which is nil if it is healthy. Backends that do not
+// implement physical.HealthChecker are always healthy.
+func (c *Core) StorageHealth() error {
+	c.healthLock.RLock()
+	defer c.healthLock.RUnlock()
+	return c.healthErr
+}
+
+// checkHealth is used to periodically check the health of the physical
+// backend while unsealed, starting immediately. If the checks fail
+// repeatedly, the Vault is sealed when configured to.
+func (c *Core) checkHealth(stopCh chan struct{}) {
+	var check func() error
+	if hc, ok := c.physical.(physical.HealthChecker); ok {
+		check = hc.HealthCheck
+	} else if c.storageFailureThreshold > 0 {
+		// Without a health check, a failure is detected by reading
+		check = func() error {
+			_, err := c.physical.Get(coreSealConfigPath)
+			return err
+		}
+	} else {
+		return
+	}
+
+	var failures int
+	for {
+		err := check()
+		c.healthLock.Lock()
+		if err != nil && c.healthErr == nil {
+			c.logger.Error("core: physical backend is unhealthy: %v", err)
+		} else if err == nil && c.healthErr != nil {
+			c.logger.Info("core: physical backend is healthy")
+		}
+		c.healthErr = err
+		c.healthLock.Unlock()
+
+		if err != nil {
+			failures++
+		} else {
+			failures = 0
+		}
+		if c.storageFailureThreshold > 0 && failures >= c.storageFailureThreshold {
+			c.sealOnStorageFailure(stopCh, failures)
+			return
+		}
+
+		select {
+		case <-time.After(c.healthInterval):
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// sealOnStorageFailure is used to seal the Vault once the health checks
+// have failed too many times. Nothing is done if the checks were stopped
+// in the meantime, since the Vault has already been sealed.
+func (c *Core) sealOnStorageFailure(stopCh chan struct{}, failures int) {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	if c.sealed || c.healthCh != stopCh {
+		return
+	}
+
+	metrics.IncrCounter([]string{"core", "storage_failure_seal"}, 1)
+	reason := fmt.Sprintf("%d consecutive failed health checks of the physical backend", failures)
+	if err := c.emergencySealLocked(reason); err != nil {
+		c.logger.Error("core: emergency seal teardown failed: %v", err)
+	}
+}
+
+// SetMetricsInterval is used to change how often metrics are emitted.
+// A zero interval restores the default. This takes effect after the
+// current interval elapses and does not require a reseal.
+func (c *Core) SetMetricsInterval(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultMetricsInterval
+	}
+	c.metricsLock.Lock()
+	c.metricsInterval = interval
+	c.metricsLock.Unlock()
+}
+
+// metricsConfig returns the interval at which metrics are emitted
+// and whether the lease count should be emitted
+func (c *Core) metricsConfig() (time.Duration, bool) {
+	c.metricsLock.RLock()
+	defer c.metricsLock.RUnlock()
+	return c.metricsInterval, !c.disableLeaseMetrics
+}
+
 // emitMetrics is used to periodically expose metrics while runnig
 func (c *Core) emitMetrics(stopCh chan struct{}) {
 	for {
+		interval, leaseMetrics := c.metricsConfig()
 		select {
-		case <-time.After(time.Second):
-			c.expiration.emitMetrics()
+		case <-time.After(interval):
+			if !c.emitMetricsTick(stopCh, leaseMetrics) {
+				return
+			}
 		case <-stopCh:
 			return
 		}
 	}
 }
+
+// emitMetricsTick is used to emit the metrics once. The state lock is
+// held so that the metrics are not emitted while sealing, and false is
+// returned if the metrics have been stopped.
+func (c *Core) emitMetricsTick(stopCh chan struct{}, leaseMetrics bool) bool {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	select {
+	case <-stopCh:
+		return false
+	default:
+	}
+
+	c.mountsLock.RLock()
+	emitMountCount("mounts", c.mounts)
+	c.mountsLock.RUnlock()
+	c.authLock.RLock()
+	emitMountCount("auth", c.auth)
+	c.authLock.RUnlock()
+	c.emitCacheMetrics()
+
+	if leaseMetrics {
+		c.expiration.emitMetrics()
+		c.tokenStore.emitMetrics()
+	}
+	return true
+}
@@ -1,11 +1,18 @@
 package vault
 
 import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/helper/pgpkeys"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/physical"
 )
@@ -141,6 +148,255 @@ func TestCore_Init_MultiShare(t *testing.T) {
 	}
 }
 
+func TestCore_Init_ShareLabels(t *testing.T) {
+	c := TestCore(t)
+
+	// The labels must match the shares
+	_, err := c.Initialize(&SealConfig{
+		SecretShares:    3,
+		SecretThreshold: 2,
+		ShareLabels:     []string{"alice", "bob"},
+	})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+
+	labels := []string{"alice", "bob", "carol"}
+	res, err := c.Initialize(&SealConfig{
+		SecretShares:    3,
+		SecretThreshold: 2,
+		ShareLabels:     labels,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(res.Shares) != 3 {
+		t.Fatalf("bad: %#v", res.Shares)
+	}
+	for i, share := range res.Shares {
+		if share.Label != labels[i] || !bytes.Equal(share.Key, res.SecretShares[i]) {
+			t.Fatalf("bad: %d %#v", i, share)
+		}
+	}
+
+	// The labels are not stored
+	outConf, err := c.SealConfig()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if outConf.ShareLabels != nil {
+		t.Fatalf("bad: %#v", outConf)
+	}
+}
+
+func TestCore_Init_PGPKeys(t *testing.T) {
+	c := TestCore(t)
+
+	// The number of keys must match the number of shares
+	_, key1 := pgpkeys.TestKeyPair(t)
+	_, err := c.Initialize(&SealConfig{
+		SecretShares:    2,
+		SecretThreshold: 2,
+		PGPKeys:         []string{key1},
+	})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if init, _ := c.Initialized(); init {
+		t.Fatalf("should not be initialized")
+	}
+
+	var privs []*rsa.PrivateKey
+	var keys []string
+	for i := 0; i < 3; i++ {
+		priv, key := pgpkeys.TestKeyPair(t)
+		privs = append(privs, priv)
+		keys = append(keys, key)
+	}
+	res, err := c.Initialize(&SealConfig{
+		SecretShares:    3,
+		SecretThreshold: 2,
+		PGPKeys:         keys,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(res.SecretShares) != 3 {
+		t.Fatalf("bad: %v", res)
+	}
+
+	// Each share is decrypted with its own key, and unseals
+	var nonce string
+	for i, share := range res.SecretShares[:2] {
+		// The decrypted share is hex encoded, as entered to unseal
+		plain, err := hex.DecodeString(string(pgpkeys.TestDecrypt(t, privs[i], share)))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		var unsealed bool
+		unsealed, nonce, err = c.UnsealWithNonce(nonce, plain)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if unsealed != (i == 1) {
+			t.Fatalf("bad: %d %v", i, unsealed)
+		}
+	}
+}
+
+func TestCore_UnsealWithNonce(t *testing.T) {
+	c := TestCore(t)
+	res, err := c.Initialize(&SealConfig{
+		SecretShares:    5,
+		SecretThreshold: 3,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if nonce := c.UnsealNonce(); nonce != "" {
+		t.Fatalf("bad: %s", nonce)
+	}
+
+	// The first key starts an unseal and generates its nonce
+	unsealed, nonce, err := c.UnsealWithNonce("", TestKeyCopy(res.SecretShares[0]))
+	if err != nil || unsealed {
+		t.Fatalf("bad: %v %v", unsealed, err)
+	}
+	if nonce == "" || c.UnsealNonce() != nonce {
+		t.Fatalf("bad: %s", nonce)
+	}
+
+	// A key with the wrong nonce resets the unseal
+	_, _, err = c.UnsealWithNonce("stale", TestKeyCopy(res.SecretShares[1]))
+	if err != ErrUnsealNonceMismatch {
+		t.Fatalf("err: %v", err)
+	}
+	if prog := c.SecretProgress(); prog != 0 {
+		t.Fatalf("bad progress: %d", prog)
+	}
+
+	// So does a key without a nonce
+	if _, err := c.Unseal(TestKeyCopy(res.SecretShares[0])); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := c.Unseal(TestKeyCopy(res.SecretShares[1])); err != ErrUnsealNonceMismatch {
+		t.Fatalf("err: %v", err)
+	}
+	if prog := c.SecretProgress(); prog != 0 {
+		t.Fatalf("bad progress: %d", prog)
+	}
+	if c.UnsealNonce() != "" {
+		t.Fatalf("bad: %s", c.UnsealNonce())
+	}
+
+	// A new unseal generates a new nonce, which must be presented
+	_, newNonce, err := c.UnsealWithNonce("", TestKeyCopy(res.SecretShares[0]))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if newNonce == "" || newNonce == nonce {
+		t.Fatalf("bad: %s", newNonce)
+	}
+	for i, share := range res.SecretShares[1:3] {
+		unsealed, out, err := c.UnsealWithNonce(newNonce, TestKeyCopy(share))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if unsealed != (i == 1) {
+			t.Fatalf("bad: %d %v", i, unsealed)
+		}
+		if unsealed && out != "" {
+			t.Fatalf("bad: %s", out)
+		}
+	}
+	if c.UnsealNonce() != "" {
+		t.Fatalf("bad: %s", c.UnsealNonce())
+	}
+}
+
+func TestCore_ResetUnsealProcess(t *testing.T) {
+	c := TestCore(t)
+	res, err := c.Initialize(&SealConfig{
+		SecretShares:    5,
+		SecretThreshold: 3,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Provide two keys, keeping one to check that it is zeroed
+	part := TestKeyCopy(res.SecretShares[0])
+	var nonce string
+	for _, key := range [][]byte{part, TestKeyCopy(res.SecretShares[1])} {
+		if _, nonce, err = c.UnsealWithNonce(nonce, key); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	if n := c.ResetUnsealProcess(); n != 2 {
+		t.Fatalf("bad: %d", n)
+	}
+	if prog := c.SecretProgress(); prog != 0 {
+		t.Fatalf("bad progress: %d", prog)
+	}
+	for _, b := range part {
+		if b != 0 {
+			t.Fatalf("key not zeroed: %v", part)
+		}
+	}
+
+	// The unseal can be started over
+	nonce = ""
+	for i := 0; i < 3; i++ {
+		var unsealed bool
+		unsealed, nonce, err = c.UnsealWithNonce(nonce, TestKeyCopy(res.SecretShares[i]))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if unsealed != (i == 2) {
+			t.Fatalf("bad: %d %v", i, unsealed)
+		}
+	}
+
+	// Nothing to reset once unsealed
+	if n := c.ResetUnsealProcess(); n != 0 {
+		t.Fatalf("bad: %d", n)
+	}
+}
+
+func TestCore_SealStatus(t *testing.T) {
+	c := TestCore(t)
+	if _, err := c.SealStatus(); err != ErrNotInit {
+		t.Fatalf("err: %v", err)
+	}
+
+	res, err := c.Initialize(&SealConfig{
+		SecretShares:    5,
+		SecretThreshold: 3,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := c.Unseal(TestKeyCopy(res.SecretShares[0])); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	status, err := c.SealStatus()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected := &SealStatus{
+		Sealed:          true,
+		SecretThreshold: 3,
+		SecretShares:    5,
+		Progress:        1,
+		Nonce:           c.UnsealNonce(),
+	}
+	if !reflect.DeepEqual(status, expected) {
+		t.Fatalf("bad: %#v", status)
+	}
+}
+
 func TestCore_Unseal_MultiShare(t *testing.T) {
 	c := TestCore(t)
 
@@ -170,14 +426,16 @@ func TestCore_Unseal_MultiShare(t *testing.T) {
 		t.Fatalf("bad progress: %d", prog)
 	}
 
+	var nonce string
 	for i := 0; i < 5; i++ {
-		unseal, err := c.Unseal(res.SecretShares[i])
+		var unseal bool
+		unseal, nonce, err = c.UnsealWithNonce(nonce, res.SecretShares[i])
 		if err != nil {
 			t.Fatalf("err: %v", err)
 		}
 
 		// Ignore redundant
-		_, err = c.Unseal(res.SecretShares[i])
+		_, _, err = c.UnsealWithNonce(nonce, res.SecretShares[i])
 		if err != nil {
 			t.Fatalf("err: %v", err)
 		}
@@ -336,90 +594,148 @@ func TestCore_Seal_BadToken(t *testing.T) {
 	}
 }
 
-// Ensure we get a LeaseID
-func TestCore_HandleRequest_Lease(t *testing.T) {
+func TestCore_Rotate(t *testing.T) {
 	c, _, root := TestCoreUnsealed(t)
 
+	// Write a secret with the initial key
 	req := &logical.Request{
-		Operation: logical.WriteOperation,
-		Path:      "secret/test",
+		Operation:   logical.WriteOperation,
+		Path:        "secret/foo",
+		ClientToken: root,
+		Data:        map[string]interface{}{"test": "data"},
+	}
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Create a non-root child token
+	req = &logical.Request{
+		Operation:   logical.WriteOperation,
+		Path:        "auth/token/create",
+		ClientToken: root,
 		Data: map[string]interface{}{
-			"foo":   "bar",
-			"lease": "1h",
+			"policies": []string{"foo"},
 		},
-		ClientToken: root,
 	}
 	resp, err := c.HandleRequest(req)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
-	if resp != nil {
-		t.Fatalf("bad: %#v", resp)
+	child := resp.Auth.ClientToken
+
+	// A non-root token cannot rotate
+	if _, err := c.Rotate(child); err != logical.ErrPermissionDenied {
+		t.Fatalf("err: %v", err)
 	}
 
-	// Read the key
-	req.Operation = logical.ReadOperation
-	req.Data = nil
-	resp, err = c.HandleRequest(req)
+	term, err := c.Rotate(root)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
-	if resp == nil || resp.Secret == nil || resp.Data == nil {
-		t.Fatalf("bad: %#v", resp)
+	if term != 2 {
+		t.Fatalf("bad: %d", term)
 	}
-	if resp.Secret.Lease != time.Hour {
-		t.Fatalf("bad: %#v", resp.Secret)
+	if active, err := c.ActiveTerm(); err != nil || active != 2 {
+		t.Fatalf("bad: %d %v", active, err)
 	}
-	if resp.Secret.LeaseID == "" {
-		t.Fatalf("bad: %#v", resp.Secret)
+
+	// The secret is still readable
+	req = &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "secret/foo",
+		ClientToken: root,
 	}
-	if resp.Data["foo"] != "bar" {
-		t.Fatalf("bad: %#v", resp.Data)
+	resp, err = c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Data["test"] != "data" {
+		t.Fatalf("bad: %#v", resp)
 	}
 }
 
-func TestCore_HandleRequest_Lease_MaxLength(t *testing.T) {
+func TestCore_RevokeRootToken(t *testing.T) {
 	c, _, root := TestCoreUnsealed(t)
 
+	// Create a non-root child token
 	req := &logical.Request{
-		Operation: logical.WriteOperation,
-		Path:      "secret/test",
+		Operation:   logical.WriteOperation,
+		Path:        "auth/token/create",
+		ClientToken: root,
 		Data: map[string]interface{}{
-			"foo":   "bar",
-			"lease": "1000h",
+			"policies": []string{"foo"},
 		},
-		ClientToken: root,
 	}
 	resp, err := c.HandleRequest(req)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
-	if resp != nil {
-		t.Fatalf("bad: %#v", resp)
+	child := resp.Auth.ClientToken
+
+	// A non-root token cannot revoke the root token
+	if err := c.RevokeRootToken(child); err != logical.ErrPermissionDenied {
+		t.Fatalf("err: %v", err)
 	}
 
-	// Read the key
-	req.Operation = logical.ReadOperation
-	req.Data = nil
+	if err := c.RevokeRootToken(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The root token should be gone
+	te, err := c.tokenStore.Lookup(root)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if te != nil {
+		t.Fatalf("bad: %#v", te)
+	}
+	if err := c.Seal(root); err != logical.ErrPermissionDenied {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The child token is orphaned but still valid
+	te, err = c.tokenStore.Lookup(child)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if te == nil {
+		t.Fatalf("child token should remain")
+	}
+
+	// Requests should still be served without a root token
+	req = &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "auth/token/lookup-self",
+		ClientToken: child,
+	}
 	resp, err = c.HandleRequest(req)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
-	if resp == nil || resp.Secret == nil || resp.Data == nil {
+	if resp == nil || resp.Data["id"] != child {
 		t.Fatalf("bad: %#v", resp)
 	}
-	if resp.Secret.Lease != maxLeaseDuration {
-		t.Fatalf("bad: %#v", resp.Secret)
+}
+
+func TestCore_HandleRequest_NoMount(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	req := &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "missing/foo",
+		ClientToken: root,
 	}
-	if resp.Secret.LeaseID == "" {
-		t.Fatalf("bad: %#v", resp.Secret)
+	_, err := c.HandleRequest(req)
+	if noRoute, ok := err.(ErrNoRoute); !ok || noRoute.Path != "missing/foo" {
+		t.Fatalf("err: %v", err)
 	}
-	if resp.Data["foo"] != "bar" {
-		t.Fatalf("bad: %#v", resp.Data)
+	if !errors.Is(err, ErrNoMount) {
+		t.Fatalf("err: %v", err)
 	}
 }
 
-func TestCore_HandleRequest_Lease_DefaultLength(t *testing.T) {
+// Ensure we get a LeaseID
+func TestCore_HandleRequest_Lease(t *testing.T) {
 	c, _, root := TestCoreUnsealed(t)
 
 	req := &logical.Request{
@@ -427,7 +743,7 @@ func TestCore_HandleRequest_Lease_DefaultLength(t *testing.T) {
 		Path:      "secret/test",
 		Data: map[string]interface{}{
 			"foo":   "bar",
-			"lease": "0h",
+			"lease": "1h",
 		},
 		ClientToken: root,
 	}
@@ -449,7 +765,7 @@ func TestCore_HandleRequest_Lease_DefaultLength(t *testing.T) {
 	if resp == nil || resp.Secret == nil || resp.Data == nil {
 		t.Fatalf("bad: %#v", resp)
 	}
-	if resp.Secret.Lease != defaultLeaseDuration {
+	if resp.Secret.Lease != time.Hour {
 		t.Fatalf("bad: %#v", resp.Secret)
 	}
 	if resp.Secret.LeaseID == "" {
@@ -460,30 +776,170 @@ func TestCore_HandleRequest_Lease_DefaultLength(t *testing.T) {
 	}
 }
 
-func TestCore_HandleRequest_MissingToken(t *testing.T) {
-	c, _, _ := TestCoreUnsealed(t)
+func TestCore_HandleRequest_Lease_Disabled(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	me := &MountEntry{
+		Path:          "kv/",
+		Type:          "generic",
+		DisableLeases: true,
+	}
+	if err := c.mount(me); err != nil {
+		t.Fatalf("err: %v", err)
+	}
 
 	req := &logical.Request{
 		Operation: logical.WriteOperation,
-		Path:      "secret/test",
+		Path:      "kv/test",
 		Data: map[string]interface{}{
 			"foo":   "bar",
 			"lease": "1h",
 		},
+		ClientToken: root,
+	}
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
 	}
+
+	// The secret is returned, but no lease is registered
+	req.Operation = logical.ReadOperation
+	req.Data = nil
 	resp, err := c.HandleRequest(req)
-	if err != logical.ErrInvalidRequest {
+	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
-	if resp.Data["error"] != "missing client token" {
+	if resp == nil || resp.Secret == nil || resp.Data["foo"] != "bar" {
 		t.Fatalf("bad: %#v", resp)
 	}
-}
-
-func TestCore_HandleRequest_InvalidToken(t *testing.T) {
-	c, _, _ := TestCoreUnsealed(t)
-
-	req := &logical.Request{
+	if resp.Secret.LeaseID != "" {
+		t.Fatalf("bad: %#v", resp.Secret)
+	}
+	leases, err := CollectKeys(c.expiration.idView)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(leases) != 0 {
+		t.Fatalf("bad: %#v", leases)
+	}
+
+	// Enabling leases again leases later reads
+	if err := c.tuneMount("kv/", mountTuneConfig{}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, err = c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Secret == nil || resp.Secret.LeaseID == "" {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
+func TestCore_HandleRequest_Lease_MaxLength(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	req := &logical.Request{
+		Operation: logical.WriteOperation,
+		Path:      "secret/test",
+		Data: map[string]interface{}{
+			"foo":   "bar",
+			"lease": "1000h",
+		},
+		ClientToken: root,
+	}
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp != nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// Read the key
+	req.Operation = logical.ReadOperation
+	req.Data = nil
+	resp, err = c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Secret == nil || resp.Data == nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+	if resp.Secret.Lease != maxLeaseDuration {
+		t.Fatalf("bad: %#v", resp.Secret)
+	}
+	if resp.Secret.LeaseID == "" {
+		t.Fatalf("bad: %#v", resp.Secret)
+	}
+	if resp.Data["foo"] != "bar" {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+}
+
+func TestCore_HandleRequest_Lease_DefaultLength(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	req := &logical.Request{
+		Operation: logical.WriteOperation,
+		Path:      "secret/test",
+		Data: map[string]interface{}{
+			"foo":   "bar",
+			"lease": "0h",
+		},
+		ClientToken: root,
+	}
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp != nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// Read the key
+	req.Operation = logical.ReadOperation
+	req.Data = nil
+	resp, err = c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Secret == nil || resp.Data == nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+	if resp.Secret.Lease != defaultLeaseDuration {
+		t.Fatalf("bad: %#v", resp.Secret)
+	}
+	if resp.Secret.LeaseID == "" {
+		t.Fatalf("bad: %#v", resp.Secret)
+	}
+	if resp.Data["foo"] != "bar" {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+}
+
+func TestCore_HandleRequest_MissingToken(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+
+	req := &logical.Request{
+		Operation: logical.WriteOperation,
+		Path:      "secret/test",
+		Data: map[string]interface{}{
+			"foo":   "bar",
+			"lease": "1h",
+		},
+	}
+	resp, err := c.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Data["error"] != "missing client token" {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
+func TestCore_HandleRequest_InvalidToken(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+
+	req := &logical.Request{
 		Operation: logical.WriteOperation,
 		Path:      "secret/test",
 		Data: map[string]interface{}{
@@ -501,6 +957,53 @@ func TestCore_HandleRequest_InvalidToken(t *testing.T) {
 	}
 }
 
+func TestCore_HandleRequest_Help(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	// Create a token without any policy
+	req := &logical.Request{
+		Operation:   logical.WriteOperation,
+		Path:        "auth/token/create",
+		ClientToken: root,
+		Data: map[string]interface{}{
+			"policies": []string{"foo"},
+		},
+	}
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	token := resp.Auth.ClientToken
+
+	// The help of a mount, a path and the system backend are readable
+	for _, path := range []string{"sys/help/secret/", "sys/help/secret/foo", "sys/help/sys/mounts"} {
+		req := &logical.Request{
+			Operation:   logical.ReadOperation,
+			Path:        path,
+			ClientToken: token,
+		}
+		resp, err := c.HandleRequest(req)
+		if err != nil {
+			t.Fatalf("err %s: %v", path, err)
+		}
+		if help, ok := resp.Data["help"].(string); !ok || help == "" {
+			t.Fatalf("bad %s: %#v", path, resp)
+		}
+		if req.Path != path || req.Operation != logical.ReadOperation {
+			t.Fatalf("request modified: %#v", req)
+		}
+	}
+
+	// A valid token is required
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "sys/help/secret/foo",
+	}
+	if _, err := c.HandleRequest(req); err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v", err)
+	}
+}
+
 // Check that standard permissions work
 func TestCore_HandleRequest_NoSlash(t *testing.T) {
 	c, _, root := TestCoreUnsealed(t)
@@ -633,6 +1136,71 @@ func TestCore_HandleRequest_PermissionAllowed(t *testing.T) {
 	}
 }
 
+// Check that the create capability only permits writes to new paths
+func TestCore_HandleRequest_CreateCapability(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	testCoreMakeToken(t, c, root, "child", []string{"test"})
+
+	// Set the 'test' policy object to permit creates in secret/
+	req := &logical.Request{
+		Operation: logical.WriteOperation,
+		Path:      "sys/policy/test",
+		Data: map[string]interface{}{
+			"rules": `path "secret/" { capabilities = ["create", "read"] }`,
+		},
+		ClientToken: root,
+	}
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp != nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// The first write creates the path
+	req = &logical.Request{
+		Operation: logical.WriteOperation,
+		Path:      "secret/test",
+		Data: map[string]interface{}{
+			"foo": "bar",
+		},
+		ClientToken: "child",
+	}
+	resp, err = c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v, resp: %v", err, resp)
+	}
+
+	// Updating the path is denied
+	req.Data = map[string]interface{}{
+		"foo": "baz",
+	}
+	resp, err = c.HandleRequest(req)
+	if err != logical.ErrPermissionDenied {
+		t.Fatalf("err: %v, resp: %v", err, resp)
+	}
+
+	// Reading is permitted, but not deleting
+	req = &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "secret/test",
+		ClientToken: "child",
+	}
+	resp, err = c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Data["foo"] != "bar" {
+		t.Fatalf("bad: %#v", resp)
+	}
+	req.Operation = logical.DeleteOperation
+	resp, err = c.HandleRequest(req)
+	if err != logical.ErrPermissionDenied {
+		t.Fatalf("err: %v, resp: %v", err, resp)
+	}
+}
+
 func TestCore_HandleRequest_NoConnection(t *testing.T) {
 	noop := &NoopBackend{
 		Response: &logical.Response{},
@@ -782,18 +1350,25 @@ func TestCore_HandleLogin_Token(t *testing.T) {
 	}
 	expect := &TokenEntry{
 		ID:       clientToken,
-		Parent:   "",
-		Policies: []string{"foo", "bar"},
+		SaltedID: c.tokenStore.SaltID(clientToken),
+		Policies: []string{"foo", "bar", "default"},
 		Path:     "auth/foo/login",
 		Meta: map[string]string{
 			"user": "armon",
 		},
 		DisplayName: "foo-armon",
 	}
+	expect.CreationTime = te.CreationTime
+	expect.Accessor = te.Accessor
 	if !reflect.DeepEqual(te, expect) {
 		t.Fatalf("Bad: %#v expect: %#v", te, expect)
 	}
 
+	// Check the accessor is returned with the token
+	if te.Accessor == "" || lresp.Auth.Accessor != te.Accessor {
+		t.Fatalf("bad: %#v", lresp.Auth)
+	}
+
 	// Check that we have a lease with default duration
 	if lresp.Auth.Lease != defaultLeaseDuration {
 		t.Fatalf("bad: %#v", lresp.Auth)
@@ -804,7 +1379,7 @@ func TestCore_HandleRequest_AuditTrail(t *testing.T) {
 	// Create a noop audit backend
 	noop := &NoopAudit{}
 	c, _, root := TestCoreUnsealed(t)
-	c.auditBackends["noop"] = func(map[string]string) (audit.Backend, error) {
+	c.auditBackends["noop"] = func(*audit.BackendConfig) (audit.Backend, error) {
 		return noop, nil
 	}
 
@@ -859,61 +1434,258 @@ func TestCore_HandleRequest_AuditTrail(t *testing.T) {
 	if len(noop.Resp) != 2 || !reflect.DeepEqual(noop.Resp[1], resp) {
 		t.Fatalf("Bad: %#v", noop.Resp[1])
 	}
+
+	// The request and response entries share the ID of the request,
+	// which is unique to it
+	if req.ID == "" || noop.Req[0].ID != req.ID || noop.RespReq[1].ID != req.ID {
+		t.Fatalf("bad: %#v", req)
+	}
+	if noop.RespReq[0].ID == "" || noop.RespReq[0].ID == req.ID {
+		t.Fatalf("bad: %#v", noop.RespReq[0])
+	}
 }
 
-// Ensure we get a client token
-func TestCore_HandleLogin_AuditTrail(t *testing.T) {
-	// Create a badass credential backend that always logs in as armon
+func TestCore_HandleRequest_BreakGlass(t *testing.T) {
 	noop := &NoopAudit{}
-	noopBack := &NoopBackend{
-		Login: []string{"login"},
-		Response: &logical.Response{
-			Secret: &logical.Secret{
-				LeaseOptions: logical.LeaseOptions{
-					Lease: time.Hour,
-				},
-			},
-
-			Auth: &logical.Auth{
-				Policies: []string{"foo", "bar"},
-				Metadata: map[string]string{
-					"user": "armon",
-				},
-			},
-		},
-	}
 	c, _, root := TestCoreUnsealed(t)
-	c.credentialBackends["noop"] = func(map[string]string) (logical.Backend, error) {
-		return noopBack, nil
-	}
-	c.auditBackends["noop"] = func(map[string]string) (audit.Backend, error) {
+	c.auditBackends["noop"] = func(*audit.BackendConfig) (audit.Backend, error) {
 		return noop, nil
 	}
-
-	// Enable the credential backend
-	req := logical.TestRequest(t, logical.WriteOperation, "sys/auth/foo")
-	req.Data["type"] = "noop"
-	req.ClientToken = root
-	_, err := c.HandleRequest(req)
-	if err != nil {
-		t.Fatalf("err: %v", err)
+	var alerts []*logical.Request
+	c.breakGlassAlert = func(auth *logical.Auth, req *logical.Request) {
+		alerts = append(alerts, req)
 	}
 
-	// Enable the audit backend
-	req = logical.TestRequest(t, logical.WriteOperation, "sys/audit/noop")
+	req := logical.TestRequest(t, logical.WriteOperation, "sys/audit/noop")
 	req.Data["type"] = "noop"
 	req.ClientToken = root
-	_, err = c.HandleRequest(req)
-	if err != nil {
+	if _, err := c.HandleRequest(req); err != nil {
 		t.Fatalf("err: %v", err)
 	}
 
-	// Attempt to login
-	lreq := &logical.Request{
-		Path: "auth/foo/login",
+	// Create a policy with read access and the break-glass capability
+	req = &logical.Request{
+		Operation:   logical.WriteOperation,
+		Path:        "sys/policy/emergency",
+		ClientToken: root,
+		Data: map[string]interface{}{
+			"rules": `
+path "secret/" { policy = "read" }
+break_glass = ["secret/", "sys/mounts/"]
+`,
+		},
 	}
-	lresp, err := c.HandleRequest(lreq)
-	if err != nil {
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	req = &logical.Request{
+		Operation:   logical.WriteOperation,
+		Path:        "auth/token/create",
+		ClientToken: root,
+		Data: map[string]interface{}{
+			"policies": []string{"emergency"},
+		},
+	}
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	token := resp.Auth.ClientToken
+
+	// Without the override the write is denied
+	req = &logical.Request{
+		Operation:   logical.WriteOperation,
+		Path:        "secret/foo",
+		ClientToken: token,
+		Data:        map[string]interface{}{"foo": "bar"},
+	}
+	if _, err := c.HandleRequest(req); err != logical.ErrPermissionDenied {
+		t.Fatalf("err: %v", err)
+	}
+
+	// With the override the write is permitted, and audited as such
+	req.BreakGlass = true
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	last := noop.Req[len(noop.Req)-1]
+	if last.Path != "secret/foo" || !last.BreakGlass {
+		t.Fatalf("bad: %#v", last)
+	}
+	if len(alerts) != 1 || alerts[0] != req {
+		t.Fatalf("bad: %#v", alerts)
+	}
+
+	// The override is not marked if the ACL permits the request
+	req = &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "secret/foo",
+		ClientToken: token,
+		BreakGlass:  true,
+	}
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if req.BreakGlass || len(alerts) != 1 {
+		t.Fatalf("bad: %#v", req)
+	}
+
+	// The override does not extend past the granted paths
+	req = &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "sys/mounts",
+		ClientToken: token,
+		BreakGlass:  true,
+	}
+	if _, err := c.HandleRequest(req); err != logical.ErrPermissionDenied {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The override does not grant root protected paths
+	req = &logical.Request{
+		Operation:   logical.WriteOperation,
+		Path:        "sys/mounts/foo",
+		ClientToken: token,
+		Data:        map[string]interface{}{"type": "generic"},
+		BreakGlass:  true,
+	}
+	if _, err := c.HandleRequest(req); err != logical.ErrPermissionDenied {
+		t.Fatalf("err: %v", err)
+	}
+	if len(alerts) != 1 {
+		t.Fatalf("bad: %#v", alerts)
+	}
+
+	// Root does not imply the capability
+	req = &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "secret/foo",
+		ClientToken: root,
+		BreakGlass:  true,
+	}
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if req.BreakGlass || len(alerts) != 1 {
+		t.Fatalf("bad: %#v", req)
+	}
+}
+
+func TestCore_HandleLogin_TokenRole(t *testing.T) {
+	noop := &NoopBackend{
+		Login: []string{"login"},
+		Response: &logical.Response{
+			Auth: &logical.Auth{
+				Policies: []string{"foo"},
+			},
+		},
+	}
+	c, _, root := TestCoreUnsealed(t)
+	c.credentialBackends["noop"] = func(map[string]string) (logical.Backend, error) {
+		return noop, nil
+	}
+
+	// Enable the credential backend bound to a role that does not exist yet
+	req := logical.TestRequest(t, logical.WriteOperation, "sys/auth/foo")
+	req.Data["type"] = "noop"
+	req.Data["token_role"] = "test"
+	req.ClientToken = root
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	lreq := &logical.Request{
+		Path: "auth/foo/login",
+	}
+	lresp, err := c.HandleRequest(lreq)
+	if err != logical.ErrInvalidRequest || lresp.Auth != nil {
+		t.Fatalf("bad: %v %#v", err, lresp)
+	}
+
+	// The role does not allow the policy of the login
+	req = logical.TestRequest(t, logical.WriteOperation, "auth/token/roles/test")
+	req.Data["allowed_policies"] = "bar"
+	req.Data["ttl"] = "1h"
+	req.ClientToken = root
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	lresp, err = c.HandleRequest(lreq)
+	if err != logical.ErrInvalidRequest || lresp.Auth != nil {
+		t.Fatalf("bad: %v %#v", err, lresp)
+	}
+
+	// The login succeeds once allowed, with the TTL of the role
+	req.Data["allowed_policies"] = "foo,bar"
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	lresp, err = c.HandleRequest(lreq)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	te, err := c.tokenStore.Lookup(lresp.Auth.ClientToken)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if te.TTL != time.Hour {
+		t.Fatalf("bad: %#v", te)
+	}
+}
+
+// Ensure we get a client token
+func TestCore_HandleLogin_AuditTrail(t *testing.T) {
+	// Create a badass credential backend that always logs in as armon
+	noop := &NoopAudit{}
+	noopBack := &NoopBackend{
+		Login: []string{"login"},
+		Response: &logical.Response{
+			Secret: &logical.Secret{
+				LeaseOptions: logical.LeaseOptions{
+					Lease: time.Hour,
+				},
+			},
+
+			Auth: &logical.Auth{
+				Policies: []string{"foo", "bar"},
+				Metadata: map[string]string{
+					"user": "armon",
+				},
+			},
+		},
+	}
+	c, _, root := TestCoreUnsealed(t)
+	c.credentialBackends["noop"] = func(map[string]string) (logical.Backend, error) {
+		return noopBack, nil
+	}
+	c.auditBackends["noop"] = func(*audit.BackendConfig) (audit.Backend, error) {
+		return noop, nil
+	}
+
+	// Enable the credential backend
+	req := logical.TestRequest(t, logical.WriteOperation, "sys/auth/foo")
+	req.Data["type"] = "noop"
+	req.ClientToken = root
+	_, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Enable the audit backend
+	req = logical.TestRequest(t, logical.WriteOperation, "sys/audit/noop")
+	req.Data["type"] = "noop"
+	req.ClientToken = root
+	_, err = c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Attempt to login
+	lreq := &logical.Request{
+		Path: "auth/foo/login",
+	}
+	lresp, err := c.HandleRequest(lreq)
+	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
 
@@ -938,7 +1710,7 @@ func TestCore_HandleLogin_AuditTrail(t *testing.T) {
 	if auth.ClientToken != clientToken {
 		t.Fatalf("bad client token: %#v", auth)
 	}
-	if len(auth.Policies) != 2 || auth.Policies[0] != "foo" || auth.Policies[1] != "bar" {
+	if !reflect.DeepEqual(auth.Policies, []string{"foo", "bar", "default"}) {
 		t.Fatalf("bad: %#v", auth)
 	}
 	if len(noop.RespReq) != 2 || !reflect.DeepEqual(noop.RespReq[1], lreq) {
@@ -949,6 +1721,47 @@ func TestCore_HandleLogin_AuditTrail(t *testing.T) {
 	}
 }
 
+// Ensure a login granting only the default policy is rejected
+func TestCore_HandleLogin_RequireExplicitPolicy(t *testing.T) {
+	noop := &NoopBackend{
+		Login: []string{"login"},
+		Response: &logical.Response{
+			Auth: &logical.Auth{
+				Policies: []string{"default"},
+			},
+		},
+	}
+	c, _, root := TestCoreUnsealed(t)
+	c.requireExplicitPolicy = true
+	c.credentialBackends["noop"] = func(map[string]string) (logical.Backend, error) {
+		return noop, nil
+	}
+
+	// Enable the credential backend
+	req := logical.TestRequest(t, logical.WriteOperation, "sys/auth/foo")
+	req.Data["type"] = "noop"
+	req.ClientToken = root
+	_, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Attempt to login with only the default policy
+	lreq := &logical.Request{
+		Path: "auth/foo/login",
+	}
+	lresp, err := c.HandleRequest(lreq)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v", err)
+	}
+	if lresp.Data["error"] != errNoExplicitPolicy.Error() {
+		t.Fatalf("bad: %#v", lresp)
+	}
+	if lresp.Auth != nil {
+		t.Fatalf("bad: %#v", lresp)
+	}
+}
+
 // Check that we register a lease for new tokens
 func TestCore_HandleRequest_CreateToken_Lease(t *testing.T) {
 	c, _, root := TestCoreUnsealed(t)
@@ -974,12 +1787,15 @@ func TestCore_HandleRequest_CreateToken_Lease(t *testing.T) {
 		t.Fatalf("err: %v", err)
 	}
 	expect := &TokenEntry{
-		ID:          clientToken,
-		Parent:      root,
-		Policies:    []string{"foo"},
-		Path:        "auth/token/create",
-		DisplayName: "token",
-	}
+		ID:           clientToken,
+		SaltedID:     c.tokenStore.SaltID(clientToken),
+		SaltedParent: c.tokenStore.SaltID(root),
+		Policies:     []string{"foo", "default"},
+		Path:         "auth/token/create",
+		DisplayName:  "token",
+	}
+	expect.CreationTime = te.CreationTime
+	expect.Accessor = te.Accessor
 	if !reflect.DeepEqual(te, expect) {
 		t.Fatalf("Bad: %#v expect: %#v", te, expect)
 	}
@@ -1121,9 +1937,9 @@ func TestCore_Standby(t *testing.T) {
 		t.Fatalf("should be standby")
 	}
 
-	// Request should fail in standby mode
+	// Request should fail in standby mode, with the address of the leader
 	_, err = core2.HandleRequest(req)
-	if err != ErrStandby {
+	if err != (ErrStandbyRedirect{LeaderAddr: "foo"}) {
 		t.Fatalf("err: %v", err)
 	}
 
@@ -1197,3 +2013,705 @@ func TestCore_Standby(t *testing.T) {
 		t.Fatalf("Bad advertise: %v", advertise)
 	}
 }
+
+func TestCore_StepDown(t *testing.T) {
+	// Create the first core and initialize it
+	inm := physical.NewInmemHA()
+	core, err := NewCore(&CoreConfig{
+		Physical:      inm,
+		AdvertiseAddr: "foo",
+		DisableMlock:  true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	key, root := TestCoreInit(t, core)
+	if _, err := core.Unseal(TestKeyCopy(key)); err != nil {
+		t.Fatalf("unseal err: %s", err)
+	}
+	testWaitActive(t, core)
+
+	// Create a second core, attached to same in-memory store
+	core2, err := NewCore(&CoreConfig{
+		Physical:      inm,
+		AdvertiseAddr: "bar",
+		DisableMlock:  true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := core2.Unseal(TestKeyCopy(key)); err != nil {
+		t.Fatalf("unseal err: %s", err)
+	}
+
+	// A standby cannot step down, and a root token is required
+	if err := core2.StepDown(root); err != ErrStandby {
+		t.Fatalf("err: %v", err)
+	}
+	if err := core.StepDown(""); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// Step down the first core, the second should become active
+	if err := core.StepDown(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testWaitActive(t, core2)
+
+	// The first core is a standby, but remains unsealed
+	standby, err := core.Standby()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !standby {
+		t.Fatalf("should be standby")
+	}
+	sealed, err := core.Sealed()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if sealed {
+		t.Fatalf("should not be sealed")
+	}
+	isLeader, advertise, err := core.Leader()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if isLeader || advertise != "bar" {
+		t.Fatalf("bad: %v %v", isLeader, advertise)
+	}
+}
+
+func TestCore_StepDown_HANotEnabled(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	if err := c.StepDown(root); err != ErrHANotEnabled {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+// testWaitActive waits for the core to leave standby mode
+func testWaitActive(t *testing.T, c *Core) {
+	start := time.Now()
+	for time.Now().Sub(start) < time.Second {
+		standby, err := c.Standby()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if !standby {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("should not be in standby mode")
+}
+
+// healthCheckInmem is an in-memory backend with a configurable health
+type healthCheckInmem struct {
+	*physical.InmemBackend
+
+	l   sync.Mutex
+	err error
+}
+
+func (h *healthCheckInmem) HealthCheck() error {
+	h.l.Lock()
+	defer h.l.Unlock()
+	return h.err
+}
+
+func (h *healthCheckInmem) setHealth(err error) {
+	h.l.Lock()
+	defer h.l.Unlock()
+	h.err = err
+}
+
+func TestCore_StorageHealth(t *testing.T) {
+	inm := &healthCheckInmem{
+		InmemBackend: physical.NewInmem(),
+		err:          fmt.Errorf("unhealthy"),
+	}
+	c, err := NewCore(&CoreConfig{
+		Physical:     inm,
+		DisableMlock: true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	key, root := TestCoreInit(t, c)
+	if _, err := c.Unseal(TestKeyCopy(key)); err != nil {
+		t.Fatalf("unseal err: %s", err)
+	}
+
+	// The backend is checked as soon as the Vault is unsealed
+	start := time.Now()
+	for c.StorageHealth() == nil {
+		if time.Now().Sub(start) > time.Second {
+			t.Fatalf("should be unhealthy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The checks stop once sealed, and start again when unsealed
+	if err := c.Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	inm.setHealth(nil)
+	if _, err := c.Unseal(TestKeyCopy(key)); err != nil {
+		t.Fatalf("unseal err: %s", err)
+	}
+	start = time.Now()
+	for c.StorageHealth() != nil {
+		if time.Now().Sub(start) > time.Second {
+			t.Fatalf("should be healthy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCore_StorageFailureThreshold(t *testing.T) {
+	inm := &healthCheckInmem{
+		InmemBackend: physical.NewInmem(),
+	}
+	c, err := NewCore(&CoreConfig{
+		Physical:                inm,
+		DisableMlock:            true,
+		StorageFailureThreshold: 3,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	c.healthInterval = 10 * time.Millisecond
+	key, _ := TestCoreInit(t, c)
+	if _, err := c.Unseal(TestKeyCopy(key)); err != nil {
+		t.Fatalf("unseal err: %s", err)
+	}
+
+	// The Vault seals itself once the checks keep failing
+	inm.setHealth(fmt.Errorf("unhealthy"))
+	start := time.Now()
+	for {
+		if sealed, _ := c.Sealed(); sealed {
+			break
+		}
+		if time.Now().Sub(start) > time.Second {
+			t.Fatalf("should be sealed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sealed, _ := c.barrier.Sealed(); !sealed {
+		t.Fatalf("barrier should be sealed")
+	}
+
+	// It can be unsealed again once the backend recovers
+	inm.setHealth(nil)
+	if unsealed, err := c.Unseal(TestKeyCopy(key)); err != nil || !unsealed {
+		t.Fatalf("unseal err: %v %v", unsealed, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if sealed, _ := c.Sealed(); sealed {
+		t.Fatalf("should not be sealed")
+	}
+}
+
+func TestCore_Shutdown(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+	if err := c.Shutdown(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if sealed, _ := c.Sealed(); !sealed {
+		t.Fatalf("should be sealed")
+	}
+	if c.metricsCh != nil || c.rollback != nil || c.expiration != nil {
+		t.Fatalf("should be torn down")
+	}
+
+	// Shutting down again is a no-op
+	if err := c.Shutdown(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestCore_Shutdown_Standby(t *testing.T) {
+	inm := physical.NewInmemHA()
+	core, err := NewCore(&CoreConfig{
+		Physical:      inm,
+		AdvertiseAddr: "foo",
+		DisableMlock:  true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	key, _ := TestCoreInit(t, core)
+	if _, err := core.Unseal(TestKeyCopy(key)); err != nil {
+		t.Fatalf("unseal err: %s", err)
+	}
+	waitForActive := func(c *Core) {
+		start := time.Now()
+		for time.Now().Sub(start) < time.Second {
+			if standby, _ := c.Standby(); !standby {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("should not be in standby mode")
+	}
+	waitForActive(core)
+
+	core2, err := NewCore(&CoreConfig{
+		Physical:      inm,
+		AdvertiseAddr: "bar",
+		DisableMlock:  true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := core2.Unseal(TestKeyCopy(key)); err != nil {
+		t.Fatalf("unseal err: %s", err)
+	}
+	if standby, _ := core2.Standby(); !standby {
+		t.Fatalf("should be standby")
+	}
+
+	// The lock is released on shutdown, so the standby takes over
+	if err := core.Shutdown(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	waitForActive(core2)
+	if isLeader, advertise, err := core2.Leader(); err != nil || !isLeader || advertise != "bar" {
+		t.Fatalf("bad: %v %v %v", isLeader, advertise, err)
+	}
+}
+
+func TestCore_EmergencySeal(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+	if err := c.EmergencySeal("test"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if sealed, _ := c.Sealed(); !sealed {
+		t.Fatalf("should be sealed")
+	}
+	if sealed, _ := c.barrier.Sealed(); !sealed {
+		t.Fatalf("barrier should be sealed")
+	}
+
+	// Sealing again is a no-op
+	if err := c.EmergencySeal("test"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+// panicBackend is a backend that panics on every request
+type panicBackend struct {
+	NoopBackend
+}
+
+func (b *panicBackend) HandleRequest(req *logical.Request) (*logical.Response, error) {
+	panic("test panic")
+}
+
+func TestCore_SealOnPanic(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	c.sealOnPanic = true
+	c.logicalBackends["panic"] = func(map[string]string) (logical.Backend, error) {
+		return &panicBackend{}, nil
+	}
+	if err := c.mount(&MountEntry{Path: "panic/", Type: "panic"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("should panic")
+			}
+		}()
+		c.HandleRequest(&logical.Request{
+			Operation:   logical.ReadOperation,
+			Path:        "panic/foo",
+			ClientToken: root,
+		})
+	}()
+
+	start := time.Now()
+	for {
+		if sealed, _ := c.Sealed(); sealed {
+			break
+		}
+		if time.Now().Sub(start) > time.Second {
+			t.Fatalf("should be sealed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// flakyLockHA wraps an in-memory HA backend, returning a missing lock
+// value a configurable number of times, or failing lock value reads
+// with err.
+type flakyLockHA struct {
+	*physical.InmemHABackend
+	missing int
+	err     error
+}
+
+func (f *flakyLockHA) LockWith(key, value string) (physical.Lock, error) {
+	lock, err := f.InmemHABackend.LockWith(key, value)
+	if err != nil {
+		return nil, err
+	}
+	return &flakyLock{lock: lock, ha: f}, nil
+}
+
+type flakyLock struct {
+	lock physical.Lock
+	ha   *flakyLockHA
+}
+
+func (f *flakyLock) Lock(stopCh <-chan struct{}) (<-chan struct{}, error) {
+	return f.lock.Lock(stopCh)
+}
+
+func (f *flakyLock) Unlock() error {
+	return f.lock.Unlock()
+}
+
+func (f *flakyLock) Value() (bool, string, error) {
+	if f.ha.err != nil {
+		return false, "", f.ha.err
+	}
+	if f.ha.missing > 0 {
+		f.ha.missing--
+		return true, "", nil
+	}
+	return f.lock.Value()
+}
+
+func TestCore_Leader_LockValueMissing(t *testing.T) {
+	inm := &flakyLockHA{InmemHABackend: physical.NewInmemHA()}
+	core, err := NewCore(&CoreConfig{
+		Physical:      inm,
+		AdvertiseAddr: "foo",
+		DisableMlock:  true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	key, _ := TestCoreInit(t, core)
+	if _, err := core.Unseal(TestKeyCopy(key)); err != nil {
+		t.Fatalf("unseal err: %s", err)
+	}
+
+	// Wait for core to become active
+	start := time.Now()
+	var standby bool
+	for time.Now().Sub(start) < time.Second {
+		standby, err = core.Standby()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if !standby {
+			break
+		}
+	}
+	if standby {
+		t.Fatalf("should not be in standby mode")
+	}
+
+	// Create a second core in standby
+	core2, err := NewCore(&CoreConfig{
+		Physical:      inm,
+		AdvertiseAddr: "bar",
+		DisableMlock:  true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := core2.Unseal(TestKeyCopy(key)); err != nil {
+		t.Fatalf("unseal err: %s", err)
+	}
+
+	// A missing value should be retried
+	inm.missing = leaderValueAttempts - 1
+	isLeader, advertise, err := core2.Leader()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if isLeader || advertise != "foo" {
+		t.Fatalf("bad: %v %v", isLeader, advertise)
+	}
+
+	// A persistently missing value should report an unknown leader
+	inm.missing = leaderValueAttempts
+	isLeader, advertise, err = core2.Leader()
+	if err != ErrLeaderUnknown {
+		t.Fatalf("err: %v", err)
+	}
+	if isLeader || advertise != "" {
+		t.Fatalf("bad: %v %v", isLeader, advertise)
+	}
+
+	// Other errors should be returned as-is without retrying
+	inm.err = fmt.Errorf("lock read failed")
+	start = time.Now()
+	_, _, err = core2.Leader()
+	if err != inm.err {
+		t.Fatalf("err: %v", err)
+	}
+	if time.Now().Sub(start) >= leaderValueRetryInterval {
+		t.Fatalf("should not retry")
+	}
+}
+
+func TestCore_ManualTimers(t *testing.T) {
+	c := TestCore(t)
+	c.manualTimers = true
+	key, _ := TestCoreInit(t, c)
+	if _, err := c.Unseal(TestKeyCopy(key)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if !c.rollback.manual || !c.expiration.manual {
+		t.Fatalf("managers should be manual")
+	}
+	c.rollback.RunNow()
+	if err := c.expiration.RunNow(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestCore_HandleRequest_StrictFields(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	req := &logical.Request{
+		Operation: logical.WriteOperation,
+		Path:      "sys/mounts/foo",
+		Data: map[string]interface{}{
+			"type": "generic",
+			"tpye": "generic",
+		},
+		ClientToken: root,
+	}
+
+	// Unknown fields are ignored by default
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	c.strictFields = true
+	req.Path = "sys/mounts/bar"
+	resp, err := c.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Data["error"] != "unknown fields: tpye" {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// Paths that accept arbitrary fields are not checked
+	req = &logical.Request{
+		Operation: logical.WriteOperation,
+		Path:      "secret/foo",
+		Data: map[string]interface{}{
+			"anything": "bar",
+		},
+		ClientToken: root,
+	}
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestCore_Seal_ConcurrentRequests(t *testing.T) {
+	backends := map[string]physical.Backend{
+		"inmem":    physical.NewInmem(),
+		"inmem-ha": physical.NewInmemHA(),
+	}
+	for name, inm := range backends {
+		core, err := NewCore(&CoreConfig{
+			Physical:      inm,
+			AdvertiseAddr: "foo",
+			DisableMlock:  true,
+		})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		key, root := TestCoreInit(t, core)
+
+		// Hammer the core with requests until told to stop
+		stopCh := make(chan struct{})
+		errCh := make(chan error, 4)
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stopCh:
+						return
+					default:
+					}
+					req := &logical.Request{
+						Operation:   logical.ReadOperation,
+						Path:        "sys/mounts",
+						ClientToken: root,
+					}
+					_, err := core.HandleRequest(req)
+					if _, ok := err.(ErrStandbyRedirect); ok {
+						continue
+					}
+					switch err {
+					case nil, ErrSealed:
+					default:
+						errCh <- err
+						return
+					}
+				}
+			}()
+		}
+
+		// Repeatedly unseal and seal while the requests are running
+		for i := 0; i < 5; i++ {
+			if _, err := core.Unseal(TestKeyCopy(key)); err != nil {
+				t.Fatalf("%s: unseal err: %v", name, err)
+			}
+			start := time.Now()
+			for time.Now().Sub(start) < time.Second {
+				if standby, _ := core.Standby(); !standby {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			if standby, _ := core.Standby(); standby {
+				t.Fatalf("%s: should not be in standby mode", name)
+			}
+			if err := core.Seal(root); err != nil {
+				t.Fatalf("%s: seal err: %v", name, err)
+			}
+			if sealed, _ := core.Sealed(); !sealed {
+				t.Fatalf("%s: should be sealed", name)
+			}
+		}
+		close(stopCh)
+		wg.Wait()
+
+		select {
+		case err := <-errCh:
+			t.Fatalf("%s: request err: %v", name, err)
+		default:
+		}
+	}
+}
+
+func TestCore_Unseal_WhileSealing(t *testing.T) {
+	c := TestCore(t)
+	key, _ := TestCoreInit(t, c)
+
+	// Simulate a seal whose teardown has not completed
+	c.stateLock.Lock()
+	c.sealing = true
+	c.stateLock.Unlock()
+
+	if _, err := c.Unseal(TestKeyCopy(key)); err != ErrSealing {
+		t.Fatalf("err: %v", err)
+	}
+
+	c.stateLock.Lock()
+	c.sealing = false
+	c.stateLock.Unlock()
+
+	if unseal, err := c.Unseal(TestKeyCopy(key)); err != nil || !unseal {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestCore_MetricsInterval(t *testing.T) {
+	inm := physical.NewInmem()
+	c, err := NewCore(&CoreConfig{
+		Physical:            inm,
+		DisableMlock:        true,
+		DisableLeaseMetrics: true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	interval, leases := c.metricsConfig()
+	if interval != defaultMetricsInterval || leases {
+		t.Fatalf("bad: %v %v", interval, leases)
+	}
+
+	// The interval can be changed at runtime
+	c.SetMetricsInterval(time.Minute)
+	if interval, _ := c.metricsConfig(); interval != time.Minute {
+		t.Fatalf("bad: %v", interval)
+	}
+
+	// A zero interval restores the default
+	c.SetMetricsInterval(0)
+	if interval, _ := c.metricsConfig(); interval != defaultMetricsInterval {
+		t.Fatalf("bad: %v", interval)
+	}
+}
+
+func TestCore_EmitMetrics(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+	c.logicalBackends["noop"] = func(map[string]string) (logical.Backend, error) {
+		return &NoopBackend{}, nil
+	}
+	if err := c.mount(&MountEntry{Path: "noop/", Type: "noop"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "noop/foo",
+	}
+	resp := &logical.Response{
+		Secret: &logical.Secret{
+			LeaseOptions: logical.LeaseOptions{
+				Lease: time.Hour,
+			},
+		},
+	}
+	var leaseIDs []string
+	for i := 0; i < 2; i++ {
+		id, err := c.expiration.Register(req, resp)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		leaseIDs = append(leaseIDs, id)
+	}
+
+	stopCh := make(chan struct{})
+	if !c.emitMetricsTick(stopCh, true) {
+		t.Fatalf("should emit")
+	}
+	num, counts := c.expiration.leaseCounts()
+	if num != 2 || !reflect.DeepEqual(counts, map[string]int{"noop/": 2}) {
+		t.Fatalf("bad: %d %v", num, counts)
+	}
+	if num := c.tokenStore.numTokens(); num != 1 {
+		t.Fatalf("bad: %d", num)
+	}
+
+	// The count of a mount is reset once its leases are revoked
+	for _, id := range leaseIDs {
+		if err := c.expiration.Revoke(id); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	num, counts = c.expiration.leaseCounts()
+	if num != 0 || !reflect.DeepEqual(counts, map[string]int{"noop/": 0}) {
+		t.Fatalf("bad: %d %v", num, counts)
+	}
+	num, counts = c.expiration.leaseCounts()
+	if num != 0 || len(counts) != 0 {
+		t.Fatalf("bad: %d %v", num, counts)
+	}
+
+	// Nothing is emitted once stopped
+	close(stopCh)
+	if c.emitMetricsTick(stopCh, true) {
+		t.Fatalf("should not emit")
+	}
+}
@@ -0,0 +1,51 @@
+package vault
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// requestCounters maintains in-memory request counts keyed by mount
+// point. The counters are per-node and are reset whenever the Vault
+// is unsealed, they are not meant to be a durable or cluster-wide view.
+type requestCounters struct {
+	l      sync.RWMutex
+	counts map[string]*uint64
+}
+
+// newRequestCounters returns an empty set of counters
+func newRequestCounters() *requestCounters {
+	return &requestCounters{
+		counts: make(map[string]*uint64),
+	}
+}
+
+// Increment is used to count a request against the given mount. Once a
+// counter exists, incrementing only takes a read lock and an atomic add
+// so that concurrent requests do not contend.
+func (r *requestCounters) Increment(mount string) {
+	r.l.RLock()
+	count, ok := r.counts[mount]
+	r.l.RUnlock()
+	if !ok {
+		r.l.Lock()
+		count, ok = r.counts[mount]
+		if !ok {
+			count = new(uint64)
+			r.counts[mount] = count
+		}
+		r.l.Unlock()
+	}
+	atomic.AddUint64(count, 1)
+}
+
+// Snapshot returns a copy of the current counts
+func (r *requestCounters) Snapshot() map[string]uint64 {
+	r.l.RLock()
+	defer r.l.RUnlock()
+	out := make(map[string]uint64, len(r.counts))
+	for mount, count := range r.counts {
+		out[mount] = atomic.LoadUint64(count)
+	}
+	return out
+}
@@ -6,11 +6,14 @@ import (
 	"log"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/armon/go-metrics"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/helper/leveled-log"
 	"github.com/hashicorp/vault/logical"
 )
 
@@ -39,6 +42,11 @@ const (
 
 	// defaultLeaseDuration is the lease duration used when no lease is specified
 	defaultLeaseDuration = maxLeaseDuration
+
+	// tidyExpiredGrace is how long after expiration a lease that is still
+	// stored is revoked by Tidy. This leaves time for the revocation
+	// attempts of the expiration timer.
+	tidyExpiredGrace = time.Hour
 )
 
 // ExpirationManager is used by the Core to manage leases. Secrets
@@ -50,17 +58,34 @@ type ExpirationManager struct {
 	idView     *BarrierView
 	tokenView  *BarrierView
 	tokenStore *TokenStore
-	logger     *log.Logger
+	logger     leveledlog.Logger
 
 	pending     map[string]*time.Timer
 	pendingLock sync.Mutex
+
+	// revocationGrace is how long after expiration a lease is revoked.
+	// This allows for clock skew with external systems.
+	revocationGrace time.Duration
+
+	// manual is a test hook that disables the expiration timers, so
+	// expired leases are only revoked when triggered with RunNow.
+	manual bool
+
+	// leaseConfig returns the default and maximum lease durations of
+	// a path, used to bound renewals. The defaults are used if nil.
+	leaseConfig func(path string) (time.Duration, time.Duration)
+
+	// metricMounts are the mounts that had leases when last counted, so
+	// their count is reset once their leases are gone. This is only used
+	// by leaseCounts.
+	metricMounts map[string]struct{}
 }
 
 // NewExpirationManager creates a new ExpirationManager that is backed
 // using a given view, and uses the provided router for revocation.
-func NewExpirationManager(router *Router, view *BarrierView, ts *TokenStore, logger *log.Logger) *ExpirationManager {
+func NewExpirationManager(router *Router, view *BarrierView, ts *TokenStore, logger leveledlog.Logger) *ExpirationManager {
 	if logger == nil {
-		logger = log.New(os.Stderr, "", log.LstdFlags)
+		logger = leveledlog.New(log.New(os.Stderr, "", log.LstdFlags))
 	}
 	exp := &ExpirationManager{
 		router:     router,
@@ -81,6 +106,9 @@ func (c *Core) setupExpiration() error {
 
 	// Create the manager
 	mgr := NewExpirationManager(c.router, view, c.tokenStore, c.logger)
+	mgr.revocationGrace = c.revocationGrace
+	mgr.manual = c.manualTimers
+	mgr.leaseConfig = c.MountLeaseConfig
 	c.expiration = mgr
 
 	// Link the token store to this
@@ -108,6 +136,11 @@ func (c *Core) stopExpiration() error {
 // Restore is used to recover the lease states when starting.
 // This is used after starting the vault.
 func (m *ExpirationManager) Restore() error {
+	// No timers are used when expiration is triggered manually
+	if m.manual {
+		return nil
+	}
+
 	m.pendingLock.Lock()
 	defer m.pendingLock.Unlock()
 
@@ -135,8 +168,8 @@ func (m *ExpirationManager) Restore() error {
 			continue
 		}
 
-		// Determine the remaining time to expiration
-		expires := le.ExpireTime.Sub(time.Now().UTC())
+		// Determine the remaining time to revocation
+		expires := m.revokeTime(le).Sub(time.Now().UTC())
 		if expires <= 0 {
 			expires = minRevokeDelay
 		}
@@ -147,7 +180,7 @@ func (m *ExpirationManager) Restore() error {
 		})
 	}
 	if len(m.pending) > 0 {
-		m.logger.Printf("[INFO] expire: restored %d leases", len(m.pending))
+		m.logger.Info("expire: restored %d leases", len(m.pending))
 	}
 	return nil
 }
@@ -190,7 +223,7 @@ func (m *ExpirationManager) Revoke(leaseID string) error {
 	}
 
 	// Delete the secondary index
-	if err := m.indexByToken(le.ClientToken, le.LeaseID); err != nil {
+	if err := m.removeIndexByToken(le.ClientToken, le.LeaseID); err != nil {
 		return err
 	}
 
@@ -206,7 +239,8 @@ func (m *ExpirationManager) Revoke(leaseID string) error {
 
 // RevokePrefix is used to revoke all secrets with a given prefix.
 // The prefix maps to that of the mount table to make this simpler
-// to reason about.
+// to reason about. The leases are matched by their path rather than
+// their ID, since a lease keeps its ID when its mount is moved.
 func (m *ExpirationManager) RevokePrefix(prefix string) error {
 	defer metrics.MeasureSince([]string{"expire", "revoke-prefix"}, time.Now())
 	// Ensure there is a trailing slash
@@ -214,18 +248,69 @@ func (m *ExpirationManager) RevokePrefix(prefix string) error {
 		prefix = prefix + "/"
 	}
 
+	// Accumulate existing leases. All the leases are scanned, as the
+	// leases of a moved mount are not under its prefix.
+	all, err := CollectKeys(m.idView)
+	if err != nil {
+		return fmt.Errorf("failed to scan for leases: %v", err)
+	}
+	var existing []string
+	for _, leaseID := range all {
+		le, err := m.loadEntry(leaseID)
+		if err != nil {
+			return err
+		}
+		if le != nil && strings.HasPrefix(le.Path, prefix) {
+			existing = append(existing, leaseID)
+		}
+	}
+
+	// Revoke all the keys. A failure does not stop the revocation of
+	// the remaining keys, so that as many as possible are revoked.
+	var merr *multierror.Error
+	for idx, leaseID := range existing {
+		if err := m.Revoke(leaseID); err != nil {
+			merr = multierror.Append(merr, fmt.Errorf(
+				"failed to revoke '%s' (%d / %d): %v",
+				leaseID, idx+1, len(existing), err))
+		}
+	}
+	return merr.ErrorOrNil()
+}
+
+// MovePrefix is used to update the leases with a given prefix after
+// their mount is moved to a new prefix. The lease IDs do not change, so
+// the leases can still be renewed and revoked by the IDs that were
+// issued, but they are routed to the mount at its new prefix.
+func (m *ExpirationManager) MovePrefix(src, dst string) error {
+	defer metrics.MeasureSince([]string{"expire", "move-prefix"}, time.Now())
+	// Ensure there is a trailing slash
+	if !strings.HasSuffix(src, "/") {
+		src = src + "/"
+	}
+	if !strings.HasSuffix(dst, "/") {
+		dst = dst + "/"
+	}
+
 	// Accumulate existing leases
-	sub := m.idView.SubView(prefix)
+	sub := m.idView.SubView(src)
 	existing, err := CollectKeys(sub)
 	if err != nil {
 		return fmt.Errorf("failed to scan for leases: %v", err)
 	}
 
-	// Revoke all the keys
 	for idx, suffix := range existing {
-		leaseID := prefix + suffix
-		if err := m.Revoke(leaseID); err != nil {
-			return fmt.Errorf("failed to revoke '%s' (%d / %d): %v",
+		leaseID := src + suffix
+		le, err := m.loadEntry(leaseID)
+		if err != nil {
+			return err
+		}
+		if le == nil || !strings.HasPrefix(le.Path, src) {
+			continue
+		}
+		le.Path = dst + strings.TrimPrefix(le.Path, src)
+		if err := m.persistEntry(le); err != nil {
+			return fmt.Errorf("failed to move '%s' (%d / %d): %v",
 				leaseID, idx+1, len(existing), err)
 		}
 	}
@@ -235,21 +320,74 @@ func (m *ExpirationManager) RevokePrefix(prefix string) error {
 // RevokeByToken is used to revoke all the secrets issued with
 // a given token. This is done by using the secondary index.
 func (m *ExpirationManager) RevokeByToken(token string) error {
+	return m.revokeBySaltedToken(m.tokenStore.SaltID(token))
+}
+
+// revokeBySaltedToken is used to revoke all the secrets issued with a
+// token given its salted ID
+func (m *ExpirationManager) revokeBySaltedToken(saltedToken string) error {
 	defer metrics.MeasureSince([]string{"expire", "revoke-by-token"}, time.Now())
 	// Lookup the leases
-	existing, err := m.lookupByToken(token)
+	existing, err := m.lookupBySaltedToken(saltedToken)
 	if err != nil {
 		return fmt.Errorf("failed to scan for leases: %v", err)
 	}
 
-	// Revoke all the keys
+	// Revoke all the keys. As with RevokePrefix, a failure does not stop
+	// the revocation of the remaining keys, so that a backend that is
+	// unavailable does not leave the secrets of the others live.
+	var merr *multierror.Error
 	for idx, leaseID := range existing {
 		if err := m.Revoke(leaseID); err != nil {
-			return fmt.Errorf("failed to revoke '%s' (%d / %d): %v",
-				leaseID, idx+1, len(existing), err)
+			merr = multierror.Append(merr, fmt.Errorf(
+				"failed to revoke '%s' (%d / %d): %v",
+				leaseID, idx+1, len(existing), err))
 		}
 	}
-	return nil
+	return merr.ErrorOrNil()
+}
+
+// Lookup is used to read the lease entry for the given LeaseID.
+// A nil entry is returned if the lease does not exist.
+func (m *ExpirationManager) Lookup(leaseID string) (*leaseEntry, error) {
+	defer metrics.MeasureSince([]string{"expire", "lookup"}, time.Now())
+	return m.loadEntry(leaseID)
+}
+
+// List is used to describe the leases with IDs under the given
+// prefix. The prefix maps to that of the mount table, as with
+// RevokePrefix, and an empty prefix lists all the leases.
+func (m *ExpirationManager) List(prefix string) ([]*LeaseListEntry, error) {
+	defer metrics.MeasureSince([]string{"expire", "list"}, time.Now())
+	// Ensure there is a trailing slash
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+
+	// Accumulate existing leases
+	sub := m.idView.SubView(prefix)
+	existing, err := CollectKeys(sub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan for leases: %v", err)
+	}
+	sort.Strings(existing)
+
+	out := make([]*LeaseListEntry, 0, len(existing))
+	for _, suffix := range existing {
+		le, err := m.loadEntry(prefix + suffix)
+		if err != nil {
+			return nil, err
+		}
+		if le == nil {
+			continue
+		}
+		out = append(out, &LeaseListEntry{
+			LeaseID:    le.LeaseID,
+			IssueTime:  le.IssueTime,
+			ExpireTime: le.ExpireTime,
+		})
+	}
+	return out, nil
 }
 
 // Renew is used to renew a secret using the given leaseID
@@ -267,6 +405,12 @@ func (m *ExpirationManager) Renew(leaseID string, increment time.Duration) (*log
 		return nil, err
 	}
 
+	// Limit the increment to the max lease
+	maxLease := m.maxLease(le.Path)
+	if increment > maxLease {
+		increment = maxLease
+	}
+
 	// Attempt to renew the entry
 	resp, err := m.renewEntry(le, increment)
 	if err != nil {
@@ -283,8 +427,13 @@ func (m *ExpirationManager) Renew(leaseID string, increment time.Duration) (*log
 		return nil, err
 	}
 
-	// Attach the LeaseID
+	// Attach the LeaseID. The lease returned by the backend starts now,
+	// and is limited to the max lease.
 	resp.Secret.LeaseID = leaseID
+	resp.Secret.LeaseIssue = time.Now().UTC()
+	if resp.Secret.Lease > maxLease {
+		resp.Secret.Lease = maxLease
+	}
 
 	// Update the lease entry
 	le.Data = resp.Data
@@ -338,6 +487,12 @@ func (m *ExpirationManager) RenewToken(source string, token string,
 	resp.Auth.ClientToken = token
 	resp.Auth.LeaseIncrement = 0
 	resp.Auth.LeaseIssue = time.Now().UTC()
+	if maxLease := m.maxLease(le.Path); resp.Auth.Lease > maxLease {
+		resp.Auth.Lease = maxLease
+	}
+	if err := m.limitTokenLease(resp.Auth); err != nil {
+		return nil, err
+	}
 
 	// Update the lease entry
 	le.Auth = resp.Auth
@@ -403,6 +558,11 @@ func (m *ExpirationManager) Register(req *logical.Request, resp *logical.Respons
 func (m *ExpirationManager) RegisterAuth(source string, auth *logical.Auth) error {
 	defer metrics.MeasureSince([]string{"expire", "register-auth"}, time.Now())
 
+	// The lease must not outlive the token
+	if err := m.limitTokenLease(auth); err != nil {
+		return err
+	}
+
 	// Setup some of the fields on auth
 	auth.LeaseIssue = time.Now().UTC()
 
@@ -426,8 +586,37 @@ func (m *ExpirationManager) RegisterAuth(source string, auth *logical.Auth) erro
 	return nil
 }
 
+// limitTokenLease limits the lease of a token to the remaining TTL of the
+// token, so that the token is revoked along with its leases, children and
+// accessor once the TTL elapses. A token with a TTL is given a lease even
+// if it would otherwise have none.
+func (m *ExpirationManager) limitTokenLease(auth *logical.Auth) error {
+	te, err := m.tokenStore.Lookup(auth.ClientToken)
+	if err != nil {
+		return fmt.Errorf("failed to lookup token: %v", err)
+	}
+	if te == nil || te.TTL == 0 {
+		return nil
+	}
+	remaining := te.CreationTime.Add(te.TTL).Sub(time.Now())
+	if auth.Lease == 0 || auth.Lease > remaining {
+		auth.Lease = remaining
+	}
+	return nil
+}
+
 // updatePending is used to update a pending invocation for a lease
 func (m *ExpirationManager) updatePending(le *leaseEntry, leaseTotal time.Duration) {
+	// No timers are used when expiration is triggered manually
+	if m.manual {
+		return
+	}
+
+	// Delay the revocation by the grace period
+	if leaseTotal > 0 {
+		leaseTotal += m.revocationGrace
+	}
+
 	m.pendingLock.Lock()
 	defer m.pendingLock.Unlock()
 
@@ -466,13 +655,152 @@ func (m *ExpirationManager) expireID(leaseID string) {
 	for attempt := uint(0); attempt < maxRevokeAttempts; attempt++ {
 		err := m.Revoke(leaseID)
 		if err == nil {
-			m.logger.Printf("[INFO] expire: revoked '%s'", leaseID)
+			m.logger.Info("expire: revoked '%s'", leaseID)
 			return
 		}
-		m.logger.Printf("[ERR] expire: failed to revoke '%s': %v", leaseID, err)
+		m.logger.Error("expire: failed to revoke '%s': %v", leaseID, err)
 		time.Sleep((1 << attempt) * revokeRetryBase)
 	}
-	m.logger.Printf("[ERR] expire: maximum revoke attempts for '%s' reached", leaseID)
+	m.logger.Error("expire: maximum revoke attempts for '%s' reached", leaseID)
+}
+
+// RunNow is used to immediately revoke every lease that has expired,
+// instead of waiting on the timers. This is used by tests.
+func (m *ExpirationManager) RunNow() error {
+	existing, err := CollectKeys(m.idView)
+	if err != nil {
+		return fmt.Errorf("failed to scan for leases: %v", err)
+	}
+
+	now := time.Now().UTC()
+	for _, leaseID := range existing {
+		le, err := m.loadEntry(leaseID)
+		if err != nil {
+			return err
+		}
+		if le == nil || le.ExpireTime.IsZero() || m.revokeTime(le).After(now) {
+			continue
+		}
+		if err := m.Revoke(leaseID); err != nil {
+			return err
+		}
+		m.logger.Info("expire: revoked '%s'", leaseID)
+	}
+	return nil
+}
+
+// Tidy is used to remove the lease state left behind by failed or
+// interrupted revocations, returning how many entries were removed.
+// Leases whose token no longer exists, or that expired long ago and are
+// no longer pending, are revoked, and undecodable leases are deleted.
+// The token index entries of leases that no longer exist are deleted.
+// This is safe to run while leases are being registered and revoked.
+func (m *ExpirationManager) Tidy() (int, error) {
+	defer metrics.MeasureSince([]string{"expire", "tidy"}, time.Now())
+	existing, err := CollectKeys(m.idView)
+	if err != nil {
+		return 0, fmt.Errorf("failed to scan for leases: %v", err)
+	}
+
+	removed := 0
+	now := time.Now().UTC()
+	for _, leaseID := range existing {
+		le, err := m.loadEntry(leaseID)
+		if err != nil {
+			m.logger.Warn("expire: removing undecodable lease '%s': %v", leaseID, err)
+			if err := m.deleteEntry(leaseID); err != nil {
+				return removed, err
+			}
+			removed++
+			continue
+		}
+		if le == nil {
+			continue
+		}
+
+		revoke, err := m.tidyLease(le, now)
+		if err != nil {
+			return removed, err
+		}
+		if !revoke {
+			continue
+		}
+		if err := m.Revoke(leaseID); err != nil {
+			m.logger.Error("expire: failed to revoke '%s' while tidying: %v", leaseID, err)
+			continue
+		}
+		removed++
+	}
+
+	// Remove the index entries of leases that no longer exist. A lease
+	// is persisted before it is indexed, so this does not race with the
+	// registration of new leases.
+	indexes, err := CollectKeys(m.tokenView)
+	if err != nil {
+		return removed, fmt.Errorf("failed to scan for lease indexes: %v", err)
+	}
+	for _, key := range indexes {
+		raw, err := m.tokenView.Get(key)
+		if err != nil {
+			return removed, fmt.Errorf("failed to read lease index: %v", err)
+		}
+		if raw == nil {
+			continue
+		}
+		out, err := m.idView.Get(string(raw.Value))
+		if err != nil {
+			return removed, fmt.Errorf("failed to read lease entry: %v", err)
+		}
+		if out != nil {
+			continue
+		}
+		if err := m.tokenView.Delete(key); err != nil {
+			return removed, fmt.Errorf("failed to delete lease index entry: %v", err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// tidyLease is used to determine if a lease should be revoked by Tidy
+func (m *ExpirationManager) tidyLease(le *leaseEntry, now time.Time) (bool, error) {
+	// Leases that expired long ago were not revoked by their timer
+	if !le.ExpireTime.IsZero() && m.revokeTime(le).Add(tidyExpiredGrace).Before(now) {
+		m.pendingLock.Lock()
+		_, pending := m.pending[le.LeaseID]
+		m.pendingLock.Unlock()
+		if !pending {
+			return true, nil
+		}
+	}
+
+	// Leases outlive their token if its revocation failed
+	if le.ClientToken == "" {
+		return false, nil
+	}
+	te, err := m.tokenStore.lookupSalted(m.tokenStore.SaltID(le.ClientToken))
+	if err != nil {
+		return false, err
+	}
+	return te == nil, nil
+}
+
+// revokeTime returns the time at which an entry is revoked, which is
+// its expiration time plus the revocation grace period
+func (m *ExpirationManager) revokeTime(le *leaseEntry) time.Time {
+	if le.ExpireTime.IsZero() {
+		return le.ExpireTime
+	}
+	return le.ExpireTime.Add(m.revocationGrace)
+}
+
+// maxLease returns the maximum lease duration of a path
+func (m *ExpirationManager) maxLease(path string) time.Duration {
+	if m.leaseConfig == nil {
+		return maxLeaseDuration
+	}
+	_, maxLease := m.leaseConfig(path)
+	return maxLease
 }
 
 // revokeEntry is used to attempt revocation of an internal entry
@@ -591,8 +919,14 @@ func (m *ExpirationManager) removeIndexByToken(token, leaseID string) error {
 
 // lookupByToken is used to lookup all the leaseID's via the
 func (m *ExpirationManager) lookupByToken(token string) ([]string, error) {
+	return m.lookupBySaltedToken(m.tokenStore.SaltID(token))
+}
+
+// lookupBySaltedToken is used to lookup all the leaseID's of a token
+// given its salted ID
+func (m *ExpirationManager) lookupBySaltedToken(saltedToken string) ([]string, error) {
 	// Scan via the index for sub-leases
-	prefix := m.tokenStore.SaltID(token) + "/"
+	prefix := saltedToken + "/"
 	subKeys, err := m.tokenView.List(prefix)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list leases: %v", err)
@@ -613,12 +947,48 @@ func (m *ExpirationManager) lookupByToken(token string) ([]string, error) {
 	return leaseIDs, nil
 }
 
-// emitMetrics is invoked periodically to emit statistics
+// emitMetrics is invoked periodically to emit statistics. Along with
+// the total, the number of leases of each mount is emitted, so that a
+// backend whose leases grow without bound can be found.
 func (m *ExpirationManager) emitMetrics() {
+	num, counts := m.leaseCounts()
+	metrics.SetGauge([]string{"expire", "num_leases"}, float32(num))
+	for mount, count := range counts {
+		metrics.SetGauge([]string{"expire", "num_leases_by_mount",
+			strings.Replace(mount, "/", "-", -1)}, float32(count))
+	}
+}
+
+// leaseCounts returns the number of pending leases, in total and by
+// mount. The mounts that had leases when last counted are included
+// with a count of zero once their leases are gone, so that their count
+// is reset. This is only used by emitMetrics.
+func (m *ExpirationManager) leaseCounts() (int, map[string]int) {
 	m.pendingLock.Lock()
 	num := len(m.pending)
+	leaseIDs := make([]string, 0, num)
+	for leaseID := range m.pending {
+		leaseIDs = append(leaseIDs, leaseID)
+	}
 	m.pendingLock.Unlock()
-	metrics.SetGauge([]string{"expire", "num_leases"}, float32(num))
+
+	counts := make(map[string]int)
+	for _, leaseID := range leaseIDs {
+		counts[m.router.MatchingMount(leaseID)]++
+	}
+	for mount := range m.metricMounts {
+		if _, ok := counts[mount]; !ok {
+			counts[mount] = 0
+		}
+	}
+
+	m.metricMounts = make(map[string]struct{}, len(counts))
+	for mount, count := range counts {
+		if count > 0 {
+			m.metricMounts[mount] = struct{}{}
+		}
+	}
+	return num, counts
 }
 
 // leaseEntry is used to structure the values the expiration
@@ -634,11 +1004,29 @@ type leaseEntry struct {
 	ExpireTime  time.Time              `json:"expire_time"`
 }
 
+// LeaseListEntry describes a lease in a listing
+type LeaseListEntry struct {
+	LeaseID    string
+	IssueTime  time.Time
+	ExpireTime time.Time
+}
+
 // encode is used to JSON encode the lease entry
 func (l *leaseEntry) encode() ([]byte, error) {
 	return json.Marshal(l)
 }
 
+// isRenewable checks if the backend declared the lease as renewable
+func (le *leaseEntry) isRenewable() bool {
+	if le.Secret != nil {
+		return le.Secret.Renewable
+	}
+	if le.Auth != nil {
+		return le.Auth.Renewable
+	}
+	return false
+}
+
 func (le *leaseEntry) renewable() error {
 	// If there is no entry, cannot review
 	if le == nil || le.ExpireTime.IsZero() {
@@ -651,10 +1039,7 @@ func (le *leaseEntry) renewable() error {
 	}
 
 	// Determine if the lease is renewable
-	if le.Secret != nil && !le.Secret.Renewable {
-		return fmt.Errorf("lease is not renewable")
-	}
-	if le.Auth != nil && !le.Auth.Renewable {
+	if !le.isRenewable() {
 		return fmt.Errorf("lease is not renewable")
 	}
 	return nil
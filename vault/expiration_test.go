@@ -1,6 +1,7 @@
 package vault
 
 import (
+	"fmt"
 	"reflect"
 	"sort"
 	"strings"
@@ -169,6 +170,67 @@ func TestExpiration_RegisterAuth_NoLease(t *testing.T) {
 	}
 }
 
+func TestExpiration_RegisterAuth_TokenTTL(t *testing.T) {
+	exp := mockExpiration(t)
+	root, err := exp.tokenStore.RootToken()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	te := &TokenEntry{Path: "test", Policies: []string{"dev"}, TTL: 50 * time.Millisecond}
+	if err := exp.tokenStore.Create(te); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	child := &TokenEntry{Path: "test", Policies: []string{"dev"}, Parent: te.ID}
+	if err := exp.tokenStore.Create(child); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The lease is limited to the TTL of the token, even if it has none
+	auth := &logical.Auth{
+		ClientToken: te.ID,
+		LeaseOptions: logical.LeaseOptions{
+			Lease: time.Hour,
+		},
+	}
+	if err := exp.RegisterAuth("auth/github/login", auth); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if auth.Lease <= 0 || auth.Lease > 50*time.Millisecond {
+		t.Fatalf("bad: %v", auth.Lease)
+	}
+	rootAuth := &logical.Auth{ClientToken: root.ID}
+	if err := exp.RegisterAuth("auth/github/login", rootAuth); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if rootAuth.Lease != 0 {
+		t.Fatalf("bad: %v", rootAuth.Lease)
+	}
+
+	// The token, its child and its accessor are revoked once it expires
+	saltedId := exp.tokenStore.SaltID(te.ID)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		out, err := exp.tokenStore.lookupSalted(saltedId)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if out == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("token not revoked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if out, err := exp.tokenStore.Lookup(child.ID); err != nil || out != nil {
+		t.Fatalf("bad: %#v %v", out, err)
+	}
+	raw, err := exp.tokenStore.view.Get(accessorPrefix + exp.tokenStore.SaltID(te.Accessor))
+	if err != nil || raw != nil {
+		t.Fatalf("bad: %#v %v", raw, err)
+	}
+}
+
 func TestExpiration_Revoke(t *testing.T) {
 	exp := mockExpiration(t)
 	noop := &NoopBackend{}
@@ -207,6 +269,92 @@ func TestExpiration_Revoke(t *testing.T) {
 	}
 }
 
+func TestExpiration_Tidy(t *testing.T) {
+	exp := mockExpiration(t)
+	noop := &NoopBackend{}
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+	exp.router.Mount(noop, "prod/aws/", generateUUID(), view)
+
+	te := &TokenEntry{Path: "test", Policies: []string{"dev"}}
+	if err := exp.tokenStore.Create(te); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	register := func(token string) string {
+		req := &logical.Request{
+			Operation:   logical.ReadOperation,
+			Path:        "prod/aws/foo",
+			ClientToken: token,
+		}
+		resp := &logical.Response{
+			Secret: &logical.Secret{
+				LeaseOptions: logical.LeaseOptions{
+					Lease: time.Hour,
+				},
+			},
+		}
+		id, err := exp.Register(req, resp)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return id
+	}
+	live := register(te.ID)
+	orphaned := register("missing")
+
+	// A lease that expired long ago and whose revocation gave up
+	expired := register(te.ID)
+	le, err := exp.loadEntry(expired)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	le.ExpireTime = time.Now().UTC().Add(-2 * tidyExpiredGrace)
+	if err := exp.persistEntry(le); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	exp.pendingLock.Lock()
+	exp.pending[expired].Stop()
+	delete(exp.pending, expired)
+	exp.pendingLock.Unlock()
+
+	// An undecodable lease, and an index entry of a missing lease
+	if err := exp.idView.Put(&logical.StorageEntry{Key: "prod/aws/bad", Value: []byte("{")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := exp.indexByToken(te.ID, "prod/aws/gone"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	n, err := exp.Tidy()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n != 4 {
+		t.Fatalf("bad: %d", n)
+	}
+	if len(noop.Requests) != 2 {
+		t.Fatalf("bad: %#v", noop.Requests)
+	}
+	for _, id := range []string{orphaned, expired, "prod/aws/bad"} {
+		if out, err := exp.idView.Get(id); err != nil || out != nil {
+			t.Fatalf("bad: %s %v %v", id, err, out)
+		}
+	}
+	leases, err := exp.lookupByToken(te.ID)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(leases, []string{live}) {
+		t.Fatalf("bad: %#v", leases)
+	}
+
+	// Nothing is left to remove
+	if n, err := exp.Tidy(); err != nil || n != 0 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+}
+
 func TestExpiration_RevokeOnExpire(t *testing.T) {
 	exp := mockExpiration(t)
 	noop := &NoopBackend{}
@@ -256,6 +404,172 @@ func TestExpiration_RevokeOnExpire(t *testing.T) {
 	}
 }
 
+func TestExpiration_RunNow(t *testing.T) {
+	exp := mockExpiration(t)
+	exp.manual = true
+	noop := &NoopBackend{}
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+	exp.router.Mount(noop, "prod/aws/", generateUUID(), view)
+
+	leases := map[string]time.Duration{
+		"prod/aws/expired": time.Nanosecond,
+		"prod/aws/active":  time.Hour,
+	}
+	ids := make(map[string]string)
+	for path, lease := range leases {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      path,
+		}
+		resp := &logical.Response{
+			Secret: &logical.Secret{
+				LeaseOptions: logical.LeaseOptions{
+					Lease: lease,
+				},
+			},
+			Data: map[string]interface{}{
+				"access_key": "xyz",
+				"secret_key": "abcd",
+			},
+		}
+		id, err := exp.Register(req, resp)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		ids[path] = id
+	}
+
+	// No timers should be pending
+	if len(exp.pending) != 0 {
+		t.Fatalf("bad: %#v", exp.pending)
+	}
+
+	if err := exp.RunNow(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Only the expired lease should be revoked
+	if len(noop.Requests) != 1 {
+		t.Fatalf("bad: %#v", noop.Requests)
+	}
+	if req := noop.Requests[0]; req.Operation != logical.RevokeOperation || req.Path != "expired" {
+		t.Fatalf("bad: %#v", req)
+	}
+	if le, _ := exp.loadEntry(ids["prod/aws/expired"]); le != nil {
+		t.Fatalf("bad: %#v", le)
+	}
+	if le, _ := exp.loadEntry(ids["prod/aws/active"]); le == nil {
+		t.Fatalf("active lease should remain")
+	}
+}
+
+func TestExpiration_RevocationGrace(t *testing.T) {
+	exp := mockExpiration(t)
+	exp.manual = true
+	exp.revocationGrace = time.Hour
+	noop := &NoopBackend{}
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+	exp.router.Mount(noop, "prod/aws/", generateUUID(), view)
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "prod/aws/foo",
+	}
+	resp := &logical.Response{
+		Secret: &logical.Secret{
+			LeaseOptions: logical.LeaseOptions{
+				Lease: time.Nanosecond,
+			},
+		},
+	}
+	id, err := exp.Register(req, resp)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The lease is expired but within the grace period
+	if err := exp.RunNow(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(noop.Requests) != 0 {
+		t.Fatalf("bad: %#v", noop.Requests)
+	}
+
+	le, err := exp.loadEntry(id)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if le == nil {
+		t.Fatalf("lease should remain")
+	}
+	if !exp.revokeTime(le).Equal(le.ExpireTime.Add(time.Hour)) {
+		t.Fatalf("bad: %v %v", exp.revokeTime(le), le.ExpireTime)
+	}
+
+	// Once past the grace period the lease is revoked
+	exp.revocationGrace = 0
+	if err := exp.RunNow(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(noop.Requests) != 1 || noop.Requests[0].Operation != logical.RevokeOperation {
+		t.Fatalf("bad: %#v", noop.Requests)
+	}
+}
+
+func TestExpiration_List(t *testing.T) {
+	exp := mockExpiration(t)
+	noop := &NoopBackend{}
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+	exp.router.Mount(noop, "prod/aws/", generateUUID(), view)
+
+	paths := []string{
+		"prod/aws/foo",
+		"prod/aws/sub/bar",
+		"prod/aws/zip",
+	}
+	leaseIDs := make(map[string]string)
+	for _, path := range paths {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      path,
+		}
+		resp := &logical.Response{
+			Secret: &logical.Secret{
+				LeaseOptions: logical.LeaseOptions{
+					Lease: time.Hour,
+				},
+			},
+		}
+		id, err := exp.Register(req, resp)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		leaseIDs[path] = id
+	}
+
+	out, err := exp.List("prod/aws/sub")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(out) != 1 || out[0].LeaseID != leaseIDs["prod/aws/sub/bar"] {
+		t.Fatalf("bad: %#v", out)
+	}
+	if out[0].IssueTime.IsZero() || !out[0].ExpireTime.After(out[0].IssueTime) {
+		t.Fatalf("bad: %#v", out[0])
+	}
+
+	out, err = exp.List("")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(out) != 3 {
+		t.Fatalf("bad: %#v", out)
+	}
+}
+
 func TestExpiration_RevokePrefix(t *testing.T) {
 	exp := mockExpiration(t)
 	noop := &NoopBackend{}
@@ -316,6 +630,129 @@ func TestExpiration_RevokePrefix(t *testing.T) {
 	}
 }
 
+func TestExpiration_MovePrefix(t *testing.T) {
+	exp := mockExpiration(t)
+	noop := &NoopBackend{}
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+	exp.router.Mount(noop, "prod/aws/", generateUUID(), view)
+
+	var leaseIDs []string
+	for _, path := range []string{"prod/aws/foo", "prod/aws/sub/bar"} {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      path,
+		}
+		resp := &logical.Response{
+			Secret: &logical.Secret{
+				LeaseOptions: logical.LeaseOptions{
+					Lease: time.Hour,
+				},
+			},
+		}
+		leaseID, err := exp.Register(req, resp)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		leaseIDs = append(leaseIDs, leaseID)
+	}
+
+	// Move the mount and its leases
+	if err := exp.router.Remount("prod/aws/", "prod/new/"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := exp.MovePrefix("prod/aws", "prod/new"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	le, err := exp.Lookup(leaseIDs[1])
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if le.LeaseID != leaseIDs[1] || le.Path != "prod/new/sub/bar" {
+		t.Fatalf("bad: %#v", le)
+	}
+
+	// The leases are revoked by their original IDs through the new mount
+	for _, leaseID := range leaseIDs {
+		if err := exp.Revoke(leaseID); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	expect := []string{"foo", "sub/bar"}
+	sort.Strings(noop.Paths)
+	if !reflect.DeepEqual(noop.Paths, expect) {
+		t.Fatalf("bad: %v", noop.Paths)
+	}
+}
+
+// failingBackend is a backend that fails the requests of a path
+type failingBackend struct {
+	*NoopBackend
+	path string
+}
+
+func (f *failingBackend) HandleRequest(req *logical.Request) (*logical.Response, error) {
+	if req.Path == f.path {
+		return nil, fmt.Errorf("failing backend")
+	}
+	return f.NoopBackend.HandleRequest(req)
+}
+
+func TestExpiration_RevokePrefix_PartialFailure(t *testing.T) {
+	exp := mockExpiration(t)
+	noop := &NoopBackend{}
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+
+	// The revocation of the first lease fails
+	fail := &failingBackend{NoopBackend: noop, path: "foo"}
+	exp.router.Mount(fail, "prod/aws/", generateUUID(), view)
+
+	paths := []string{
+		"prod/aws/foo",
+		"prod/aws/sub/bar",
+		"prod/aws/zip",
+	}
+	leaseIDs := make(map[string]string)
+	for _, path := range paths {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      path,
+		}
+		resp := &logical.Response{
+			Secret: &logical.Secret{
+				LeaseOptions: logical.LeaseOptions{
+					Lease: time.Hour,
+				},
+			},
+		}
+		id, err := exp.Register(req, resp)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		leaseIDs[path] = id
+	}
+
+	if err := exp.RevokePrefix("prod/aws/"); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// The other leases are still revoked
+	if len(noop.Requests) != 2 {
+		t.Fatalf("Bad: %v", noop.Requests)
+	}
+	for path, id := range leaseIDs {
+		le, err := exp.loadEntry(id)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if (le != nil) != (path == "prod/aws/foo") {
+			t.Fatalf("bad: %s %#v", path, le)
+		}
+	}
+}
+
 func TestExpiration_RevokeByToken(t *testing.T) {
 	exp := mockExpiration(t)
 	noop := &NoopBackend{}
@@ -377,6 +814,69 @@ func TestExpiration_RevokeByToken(t *testing.T) {
 	}
 }
 
+func TestExpiration_RevokeByToken_PartialFailure(t *testing.T) {
+	exp := mockExpiration(t)
+	noop := &NoopBackend{}
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+	exp.router.Mount(noop, "prod/aws/", generateUUID(), view)
+
+	paths := []string{
+		"prod/aws/foo",
+		"prod/aws/sub/bar",
+		"prod/aws/zip",
+	}
+	leaseIDs := make(map[string]string)
+	for _, path := range paths {
+		req := &logical.Request{
+			Operation:   logical.ReadOperation,
+			Path:        path,
+			ClientToken: "foobarbaz",
+		}
+		resp := &logical.Response{
+			Secret: &logical.Secret{
+				LeaseOptions: logical.LeaseOptions{
+					Lease: time.Hour,
+				},
+			},
+		}
+		id, err := exp.Register(req, resp)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		leaseIDs[path] = id
+	}
+
+	// Point the first lease at a path without a mount so that its
+	// revocation fails
+	le, err := exp.loadEntry(leaseIDs["prod/aws/foo"])
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	le.Path = "unmounted/foo"
+	if err := exp.persistEntry(le); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := exp.RevokeByToken("foobarbaz"); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// The other leases of the token are still revoked
+	if len(noop.Requests) != 2 {
+		t.Fatalf("Bad: %v", noop.Requests)
+	}
+	for path, id := range leaseIDs {
+		le, err := exp.loadEntry(id)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if (le != nil) != (path == "prod/aws/foo") {
+			t.Fatalf("bad: %s %#v", path, le)
+		}
+	}
+}
+
 func TestExpiration_RenewToken(t *testing.T) {
 	exp := mockExpiration(t)
 	root, err := exp.tokenStore.RootToken()
@@ -497,6 +997,67 @@ func TestExpiration_Renew(t *testing.T) {
 	}
 }
 
+func TestExpiration_Renew_MaxLease(t *testing.T) {
+	exp := mockExpiration(t)
+	exp.leaseConfig = func(string) (time.Duration, time.Duration) {
+		return time.Hour, 2 * time.Hour
+	}
+	noop := &NoopBackend{}
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+	exp.router.Mount(noop, "prod/aws/", generateUUID(), view)
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "prod/aws/foo",
+	}
+	resp := &logical.Response{
+		Secret: &logical.Secret{
+			LeaseOptions: logical.LeaseOptions{
+				Lease:     time.Hour,
+				Renewable: true,
+			},
+		},
+	}
+	id, err := exp.Register(req, resp)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The backend grants more than the max lease
+	noop.Response = &logical.Response{
+		Secret: &logical.Secret{
+			LeaseOptions: logical.LeaseOptions{
+				Lease: 10 * time.Hour,
+			},
+		},
+	}
+
+	out, err := exp.Renew(id, 10*time.Hour)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out.Secret.Lease != 2*time.Hour {
+		t.Fatalf("bad: %#v", out.Secret)
+	}
+
+	noop.Lock()
+	if inc := noop.Requests[0].Secret.LeaseIncrement; inc != 2*time.Hour {
+		t.Fatalf("bad: %v", inc)
+	}
+	noop.Unlock()
+
+	// The stored expiration is updated
+	le, err := exp.loadEntry(id)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	until := le.ExpireTime.Sub(time.Now())
+	if until < time.Hour || until > 2*time.Hour {
+		t.Fatalf("bad: %v", le.ExpireTime)
+	}
+}
+
 func TestExpiration_Renew_NotRenewable(t *testing.T) {
 	exp := mockExpiration(t)
 	noop := &NoopBackend{}
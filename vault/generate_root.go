@@ -0,0 +1,284 @@
+package vault
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/helper/pgpkeys"
+)
+
+// generateRootOTPSize is the size of the one-time pad used to encode a
+// generated root token, which is that of a token ID
+const generateRootOTPSize = 36
+
+var (
+	// ErrGenerateRootNotStarted is returned if a key is provided while
+	// no root token generation is in progress
+	ErrGenerateRootNotStarted = errors.New("no root token generation in progress")
+
+	// ErrGenerateRootInProgress is returned if a root token generation
+	// is started while another is in progress
+	ErrGenerateRootInProgress = errors.New("root token generation already in progress")
+
+	// ErrGenerateRootNonceMismatch is returned if a key is provided with
+	// the wrong nonce for the root token generation in progress
+	ErrGenerateRootNonceMismatch = errors.New("root token generation nonce does not match")
+)
+
+// generateRootConfig is the configuration of the root token generation
+// in progress. The new root token is encoded with exactly one of the
+// one-time pad or the PGP key.
+type generateRootConfig struct {
+	Nonce  string
+	OTP    []byte
+	PGPKey *pgpkeys.PublicKey
+}
+
+// GenerateRootStatus is the state of the root token generation
+type GenerateRootStatus struct {
+	// Started is set while a generation is in progress, which is
+	// identified by the Nonce
+	Started bool
+	Nonce   string
+
+	// Progress is the number of keys provided so far, and Required is
+	// the threshold of keys
+	Progress int
+	Required int
+
+	// PGPKeyID is the ID of the PGP key the token is encrypted to, if any
+	PGPKeyID string
+}
+
+// GenerateRootResult is the outcome of providing a key to the root token
+// generation. EncodedRootToken is set once the threshold is reached.
+type GenerateRootResult struct {
+	Progress int
+	Required int
+
+	// EncodedRootToken is the new root token, XORed with the one-time
+	// pad and base64 encoded, or encrypted to the PGP key and ASCII
+	// armored
+	EncodedRootToken string
+}
+
+// GenerateRootInit is used to start generating a new root token, to
+// recover root access when every root token is lost. The token is
+// generated once the threshold of unseal keys is provided, and is
+// returned encoded with either the one-time pad, which must be the
+// base64 encoding of 36 random bytes, or the PGP public key, so that it
+// is only readable by the operator who started the generation.
+func (c *Core) GenerateRootInit(otp, pgpKey string) error {
+	if (otp == "") == (pgpKey == "") {
+		return fmt.Errorf("exactly one of a one-time pad or a PGP key is required")
+	}
+	config := &generateRootConfig{}
+	if otp != "" {
+		raw, err := base64.StdEncoding.DecodeString(otp)
+		if err != nil {
+			return fmt.Errorf("failed to decode one-time pad: %v", err)
+		}
+		if len(raw) != generateRootOTPSize {
+			return fmt.Errorf("one-time pad must be %d bytes", generateRootOTPSize)
+		}
+		config.OTP = raw
+	} else {
+		key, err := pgpkeys.ParsePublicKey(pgpKey)
+		if err != nil {
+			return fmt.Errorf("failed to parse PGP key: %v", err)
+		}
+		config.PGPKey = key
+	}
+
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.sealed {
+		return ErrSealed
+	}
+	if c.standby {
+		return ErrStandby
+	}
+
+	c.generateRootLock.Lock()
+	defer c.generateRootLock.Unlock()
+	if c.generateRootConfig != nil {
+		return ErrGenerateRootInProgress
+	}
+	config.Nonce = generateUUID()
+	c.generateRootConfig = config
+	c.logger.Info("core: root token generation started, nonce: %s", config.Nonce)
+	return nil
+}
+
+// GenerateRootStatus returns the state of the root token generation
+func (c *Core) GenerateRootStatus() (*GenerateRootStatus, error) {
+	config, err := c.SealConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, ErrNotInit
+	}
+
+	c.generateRootLock.Lock()
+	defer c.generateRootLock.Unlock()
+	status := &GenerateRootStatus{
+		Progress: len(c.generateRootParts),
+		Required: config.SecretThreshold,
+	}
+	if c.generateRootConfig != nil {
+		status.Started = true
+		status.Nonce = c.generateRootConfig.Nonce
+		if c.generateRootConfig.PGPKey != nil {
+			status.PGPKeyID = hex.EncodeToString(c.generateRootConfig.PGPKey.KeyID[:])
+		}
+	}
+	return status, nil
+}
+
+// GenerateRootUpdate is used to provide one of the unseal keys to the
+// root token generation identified by the nonce. Once the threshold of
+// keys is provided, the master key they recover is verified and a new
+// root token is created and returned encoded. The generation is then
+// complete, whether it succeeded or not.
+//
+// The key given as a parameter will automatically be zeroed once it is
+// no longer needed. If you want to keep the key around, a copy should
+// be made.
+func (c *Core) GenerateRootUpdate(nonce string, key []byte) (*GenerateRootResult, error) {
+	defer metrics.MeasureSince([]string{"core", "generate_root"}, time.Now())
+
+	// Verify the key length
+	if err := c.checkKeyLength(key); err != nil {
+		return nil, err
+	}
+
+	// Get the seal configuration
+	config, err := c.SealConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, ErrNotInit
+	}
+
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.sealed {
+		return nil, ErrSealed
+	}
+	if c.standby {
+		return nil, ErrStandby
+	}
+
+	c.generateRootLock.Lock()
+	defer c.generateRootLock.Unlock()
+	if c.generateRootConfig == nil {
+		return nil, ErrGenerateRootNotStarted
+	}
+	if subtle.ConstantTimeCompare([]byte(nonce), []byte(c.generateRootConfig.Nonce)) != 1 {
+		return nil, ErrGenerateRootNonceMismatch
+	}
+
+	// Check if we already have this piece
+	for _, existing := range c.generateRootParts {
+		if subtle.ConstantTimeCompare(existing, key) == 1 {
+			return &GenerateRootResult{
+				Progress: len(c.generateRootParts),
+				Required: config.SecretThreshold,
+			}, nil
+		}
+	}
+	c.generateRootParts = append(c.generateRootParts, key)
+
+	// Check if we don't have enough keys
+	if len(c.generateRootParts) < config.SecretThreshold {
+		c.logger.Debug("core: cannot generate root token, have %d of %d keys",
+			len(c.generateRootParts), config.SecretThreshold)
+		return &GenerateRootResult{
+			Progress: len(c.generateRootParts),
+			Required: config.SecretThreshold,
+		}, nil
+	}
+
+	// The generation completes with this key whatever the outcome
+	genConfig := c.generateRootConfig
+	defer c.resetGenerateRoot()
+
+	// Recover and verify the master key
+	masterKey, err := c.combineKeys(config, c.generateRootParts)
+	if err != nil {
+		return nil, err
+	}
+	defer memzero(masterKey)
+	if err := c.barrier.VerifyMaster(masterKey); err != nil {
+		c.logger.Warn("core: root token generation failed to verify master key: %v", err)
+		return nil, err
+	}
+
+	// Generate the root token
+	te, err := c.tokenStore.RootToken()
+	if err != nil {
+		c.logger.Error("core: root token generation failed: %v", err)
+		return nil, err
+	}
+
+	// Encode the token so only the operator who started can read it
+	var encoded string
+	if genConfig.OTP != nil {
+		buf := []byte(te.ID)
+		if len(buf) != len(genConfig.OTP) {
+			c.tokenStore.Revoke(te.ID)
+			return nil, fmt.Errorf("root token does not match the one-time pad size")
+		}
+		for i := range buf {
+			buf[i] ^= genConfig.OTP[i]
+		}
+		encoded = base64.StdEncoding.EncodeToString(buf)
+	} else {
+		raw, err := genConfig.PGPKey.Encrypt([]byte(te.ID))
+		if err != nil {
+			c.tokenStore.Revoke(te.ID)
+			return nil, fmt.Errorf("failed to encrypt root token: %v", err)
+		}
+		encoded = string(raw)
+	}
+	c.logger.Info("core: root token generated, nonce: %s", genConfig.Nonce)
+
+	return &GenerateRootResult{
+		Progress:         config.SecretThreshold,
+		Required:         config.SecretThreshold,
+		EncodedRootToken: encoded,
+	}, nil
+}
+
+// GenerateRootCancel is used to cancel the root token generation in
+// progress, discarding the keys provided so far
+func (c *Core) GenerateRootCancel() error {
+	c.generateRootLock.Lock()
+	defer c.generateRootLock.Unlock()
+	if c.generateRootConfig != nil {
+		c.logger.Info("core: root token generation canceled, nonce: %s",
+			c.generateRootConfig.Nonce)
+	}
+	c.resetGenerateRoot()
+	return nil
+}
+
+// resetGenerateRoot is used to end the root token generation, zeroing
+// the keys provided. The generate root lock must be held.
+func (c *Core) resetGenerateRoot() {
+	for _, part := range c.generateRootParts {
+		memzero(part)
+	}
+	c.generateRootParts = nil
+	if c.generateRootConfig != nil {
+		memzero(c.generateRootConfig.OTP)
+	}
+	c.generateRootConfig = nil
+}
@@ -0,0 +1,218 @@
+package vault
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/pgpkeys"
+)
+
+// testGenerateRootOTP returns a random one-time pad, raw and encoded
+func testGenerateRootOTP(t *testing.T) ([]byte, string) {
+	otp := make([]byte, generateRootOTPSize)
+	if _, err := rand.Read(otp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return otp, base64.StdEncoding.EncodeToString(otp)
+}
+
+// testCoreGenerateRoot returns an unsealed core with a threshold of
+// three keys, along with its unseal keys
+func testCoreGenerateRoot(t *testing.T) (*Core, [][]byte) {
+	c := TestCore(t)
+	res, err := c.Initialize(&SealConfig{
+		SecretShares:    5,
+		SecretThreshold: 3,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var nonce string
+	for i := 0; i < 3; i++ {
+		if _, nonce, err = c.UnsealWithNonce(nonce, TestKeyCopy(res.SecretShares[i])); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	return c, res.SecretShares
+}
+
+func TestCore_GenerateRoot_OTP(t *testing.T) {
+	c, keys := testCoreGenerateRoot(t)
+	otp, encodedOTP := testGenerateRootOTP(t)
+
+	// Keys are rejected until a generation is started
+	if _, err := c.GenerateRootUpdate("", TestKeyCopy(keys[0])); err != ErrGenerateRootNotStarted {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := c.GenerateRootInit(encodedOTP, ""); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := c.GenerateRootInit(encodedOTP, ""); err != ErrGenerateRootInProgress {
+		t.Fatalf("err: %v", err)
+	}
+	status, err := c.GenerateRootStatus()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !status.Started || status.Nonce == "" || status.Progress != 0 || status.Required != 3 {
+		t.Fatalf("bad: %#v", status)
+	}
+	nonce := status.Nonce
+
+	// The nonce must match
+	if _, err := c.GenerateRootUpdate("foo", TestKeyCopy(keys[0])); err != ErrGenerateRootNonceMismatch {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Repeated keys are not counted
+	for i, key := range [][]byte{keys[1], keys[1], keys[3]} {
+		result, err := c.GenerateRootUpdate(nonce, TestKeyCopy(key))
+		if err != nil || result.EncodedRootToken != "" {
+			t.Fatalf("bad: %d %#v %v", i, result, err)
+		}
+	}
+	status, err = c.GenerateRootStatus()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if status.Progress != 2 {
+		t.Fatalf("bad: %#v", status)
+	}
+
+	result, err := c.GenerateRootUpdate(nonce, TestKeyCopy(keys[4]))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if result.Progress != 3 || result.Required != 3 {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// Decode the token with the one-time pad
+	token, err := base64.StdEncoding.DecodeString(result.EncodedRootToken)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for i := range token {
+		token[i] ^= otp[i]
+	}
+	te, err := c.tokenStore.Lookup(string(token))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if te == nil || len(te.Policies) != 1 || te.Policies[0] != "root" {
+		t.Fatalf("bad: %#v", te)
+	}
+
+	// The generation is complete
+	status, err = c.GenerateRootStatus()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if status.Started || status.Progress != 0 {
+		t.Fatalf("bad: %#v", status)
+	}
+}
+
+func TestCore_GenerateRoot_PGP(t *testing.T) {
+	c, keys := testCoreGenerateRoot(t)
+	priv, pub := pgpkeys.TestKeyPair(t)
+
+	if err := c.GenerateRootInit("", pub); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	status, err := c.GenerateRootStatus()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if status.PGPKeyID == "" {
+		t.Fatalf("bad: %#v", status)
+	}
+
+	var result *GenerateRootResult
+	for _, key := range keys[:3] {
+		result, err = c.GenerateRootUpdate(status.Nonce, TestKeyCopy(key))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	token := pgpkeys.TestDecrypt(t, priv, []byte(result.EncodedRootToken))
+	te, err := c.tokenStore.Lookup(string(token))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if te == nil || len(te.Policies) != 1 || te.Policies[0] != "root" {
+		t.Fatalf("bad: %#v", te)
+	}
+}
+
+func TestCore_GenerateRoot_InvalidInit(t *testing.T) {
+	c, _ := testCoreGenerateRoot(t)
+	_, encodedOTP := testGenerateRootOTP(t)
+	_, pub := pgpkeys.TestKeyPair(t)
+
+	cases := [][2]string{
+		{"", ""},
+		{encodedOTP, pub},
+		{"foo", ""},
+		{base64.StdEncoding.EncodeToString([]byte("short")), ""},
+		{"", "foo"},
+	}
+	for i, tc := range cases {
+		if err := c.GenerateRootInit(tc[0], tc[1]); err == nil {
+			t.Fatalf("%d: should fail", i)
+		}
+	}
+}
+
+func TestCore_GenerateRoot_InvalidKey(t *testing.T) {
+	c, _ := testCoreGenerateRoot(t)
+	_, encodedOTP := testGenerateRootOTP(t)
+
+	// Keys from another Vault do not recover the master key
+	_, otherKeys := testCoreGenerateRoot(t)
+
+	if err := c.GenerateRootInit(encodedOTP, ""); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	status, _ := c.GenerateRootStatus()
+	for _, key := range otherKeys[:2] {
+		if _, err := c.GenerateRootUpdate(status.Nonce, TestKeyCopy(key)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if _, err := c.GenerateRootUpdate(status.Nonce, TestKeyCopy(otherKeys[2])); err == nil {
+		t.Fatalf("should fail")
+	}
+
+	// A failed attempt ends the generation
+	status, _ = c.GenerateRootStatus()
+	if status.Started || status.Progress != 0 {
+		t.Fatalf("bad: %#v", status)
+	}
+}
+
+func TestCore_GenerateRoot_Cancel(t *testing.T) {
+	c, keys := testCoreGenerateRoot(t)
+	_, encodedOTP := testGenerateRootOTP(t)
+
+	if err := c.GenerateRootInit(encodedOTP, ""); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	status, _ := c.GenerateRootStatus()
+	if _, err := c.GenerateRootUpdate(status.Nonce, TestKeyCopy(keys[0])); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := c.GenerateRootCancel(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	status, _ = c.GenerateRootStatus()
+	if status.Started || status.Progress != 0 {
+		t.Fatalf("bad: %#v", status)
+	}
+	if _, err := c.GenerateRootUpdate(status.Nonce, TestKeyCopy(keys[1])); err != ErrGenerateRootNotStarted {
+		t.Fatalf("err: %v", err)
+	}
+}
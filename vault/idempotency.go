@@ -0,0 +1,238 @@
+package vault
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/logical"
+)
+
+const (
+	// idempotencySubPath is the sub-path used for the idempotency store
+	// view. This is nested under the system view.
+	idempotencySubPath = "idempotency/"
+
+	// idempotencyTTL is how long the result of a keyed write is kept
+	// around for replay.
+	idempotencyTTL = 24 * time.Hour
+
+	// idempotencyTidyInterval is how often expired keys are removed
+	idempotencyTidyInterval = time.Hour
+)
+
+// IdempotencyStore is used to record the results of writes that carry
+// an idempotency key, so that a retried write returns the original
+// response instead of being executed a second time. The client token
+// of a response is never recorded, so a write that created a token is
+// not executed again, but the token is only returned once.
+type IdempotencyStore struct {
+	view *BarrierView
+	ttl  time.Duration
+
+	// locks holds a lock per key while a keyed write is in progress, so
+	// that concurrent retries are not both executed
+	locks     map[string]*idempotencyLock
+	locksLock sync.Mutex
+}
+
+// idempotencyLock is the lock of a single key, which is removed once
+// no request holds or waits for it
+type idempotencyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// idempotencyEntry is the value persisted for a single key
+type idempotencyEntry struct {
+	Path       string            `json:"path"`
+	Response   *logical.Response `json:"response"`
+	ExpireTime time.Time         `json:"expire_time"`
+}
+
+// NewIdempotencyStore creates a new IdempotencyStore that is backed by
+// the given view. Results are retained for the given TTL.
+func NewIdempotencyStore(view *BarrierView, ttl time.Duration) *IdempotencyStore {
+	s := &IdempotencyStore{
+		view:  view,
+		ttl:   ttl,
+		locks: make(map[string]*idempotencyLock),
+	}
+	return s
+}
+
+// setupIdempotency is used to initialize the idempotency store
+// when the vault is being unsealed.
+func (c *Core) setupIdempotency() error {
+	// Create a sub-view
+	view := c.systemView.SubView(idempotencySubPath)
+
+	// Create the store, expired keys are tidied by the scheduler
+	c.idempotency = NewIdempotencyStore(view, idempotencyTTL)
+	return nil
+}
+
+// teardownIdempotency is used to reverse setupIdempotency
+// when the vault is being sealed.
+func (c *Core) teardownIdempotency() error {
+	c.idempotency = nil
+	return nil
+}
+
+// Lock is used to hold the lock of the key from looking up its response
+// until the response of the write is recorded. The returned function
+// releases the lock.
+func (s *IdempotencyStore) Lock(token, path, key string) func() {
+	id := idempotencyID(token, path, key)
+	s.locksLock.Lock()
+	l, ok := s.locks[id]
+	if !ok {
+		l = new(idempotencyLock)
+		s.locks[id] = l
+	}
+	l.refs++
+	s.locksLock.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		s.locksLock.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(s.locks, id)
+		}
+		s.locksLock.Unlock()
+	}
+}
+
+// Get returns the response recorded for the key, or nil if no
+// unexpired response exists. The key is scoped to the client token
+// and path so that one client can never replay another's result.
+func (s *IdempotencyStore) Get(token, path, key string) (*logical.Response, bool, error) {
+	defer metrics.MeasureSince([]string{"idempotency", "get"}, time.Now())
+	raw, err := s.view.Get(idempotencyID(token, path, key))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read idempotency key: %v", err)
+	}
+	if raw == nil {
+		return nil, false, nil
+	}
+
+	var entry idempotencyEntry
+	if err := json.Unmarshal(raw.Value, &entry); err != nil {
+		return nil, false, fmt.Errorf("failed to decode idempotency key: %v", err)
+	}
+	if time.Now().After(entry.ExpireTime) {
+		return nil, false, nil
+	}
+	return entry.Response, true, nil
+}
+
+// Put records the response for the key. The client token of an auth
+// response is cleared, so that the token is not persisted again.
+func (s *IdempotencyStore) Put(token, path, key string, resp *logical.Response) error {
+	defer metrics.MeasureSince([]string{"idempotency", "put"}, time.Now())
+	if resp != nil && resp.Auth != nil {
+		auth := *resp.Auth
+		auth.ClientToken = ""
+		copied := *resp
+		copied.Auth = &auth
+		resp = &copied
+	}
+	entry := &idempotencyEntry{
+		Path:       path,
+		Response:   resp,
+		ExpireTime: time.Now().Add(s.ttl),
+	}
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode idempotency key: %v", err)
+	}
+
+	le := &logical.StorageEntry{
+		Key:   idempotencyID(token, path, key),
+		Value: buf,
+	}
+	if err := s.view.Put(le); err != nil {
+		return fmt.Errorf("failed to persist idempotency key: %v", err)
+	}
+	return nil
+}
+
+// Tidy removes all the expired keys, returning how many were removed
+func (s *IdempotencyStore) Tidy() (int, error) {
+	defer metrics.MeasureSince([]string{"idempotency", "tidy"}, time.Now())
+	keys, err := CollectKeys(s.view)
+	if err != nil {
+		return 0, fmt.Errorf("failed to scan for idempotency keys: %v", err)
+	}
+
+	now := time.Now()
+	removed := 0
+	for _, key := range keys {
+		raw, err := s.view.Get(key)
+		if err != nil {
+			return removed, fmt.Errorf("failed to read idempotency key: %v", err)
+		}
+		if raw == nil {
+			continue
+		}
+
+		// Entries that cannot be decoded are removed as well
+		var entry idempotencyEntry
+		if err := json.Unmarshal(raw.Value, &entry); err == nil && now.Before(entry.ExpireTime) {
+			continue
+		}
+
+		if err := s.view.Delete(key); err != nil {
+			return removed, fmt.Errorf("failed to delete idempotency key: %v", err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// replayIdempotent is used to check a recorded response before it is
+// replayed. The response is not replayed if its lease or token has been
+// revoked, and a response that created a token is replaced with an
+// error, since the token is only returned by the original write.
+func (c *Core) replayIdempotent(resp *logical.Response) (*logical.Response, error) {
+	if resp == nil {
+		return nil, nil
+	}
+	if resp.Secret != nil && resp.Secret.LeaseID != "" {
+		le, err := c.expiration.Lookup(resp.Secret.LeaseID)
+		if err != nil {
+			return nil, err
+		}
+		if le == nil {
+			return logical.ErrorResponse(
+				"the lease created with this idempotency key has been revoked"), logical.ErrInvalidRequest
+		}
+	}
+	if resp.Auth != nil {
+		te, err := c.tokenStore.LookupByAccessor(resp.Auth.Accessor)
+		if err != nil {
+			return nil, err
+		}
+		if te == nil {
+			return logical.ErrorResponse(
+				"the token created with this idempotency key has been revoked"), logical.ErrInvalidRequest
+		}
+		return logical.ErrorResponse(fmt.Sprintf(
+			"a token was already created with this idempotency key and is "+
+				"not returned again (accessor: %s)", resp.Auth.Accessor)), logical.ErrInvalidRequest
+	}
+	return resp, nil
+}
+
+// idempotencyID is used to derive the storage key from the client token,
+// request path and the idempotency key provided by the client.
+func idempotencyID(token, path, key string) string {
+	hash := sha1.Sum([]byte(token + "\x00" + path + "\x00" + key))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,217 @@
+package vault
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func mockIdempotencyStore(t *testing.T, ttl time.Duration) *IdempotencyStore {
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "foo/")
+	return NewIdempotencyStore(view, ttl)
+}
+
+func TestIdempotencyStore_GetPut(t *testing.T) {
+	s := mockIdempotencyStore(t, time.Hour)
+
+	// Missing key
+	resp, ok, err := s.Get("token", "secret/foo", "key")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if ok || resp != nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	expected := &logical.Response{
+		Data: map[string]interface{}{
+			"foo": "bar",
+		},
+	}
+	if err := s.Put("token", "secret/foo", "key", expected); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	resp, ok, err = s.Get("token", "secret/foo", "key")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !ok || !reflect.DeepEqual(resp, expected) {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// The key is scoped to the token and path
+	if _, ok, _ := s.Get("other", "secret/foo", "key"); ok {
+		t.Fatalf("should not replay for another token")
+	}
+	if _, ok, _ := s.Get("token", "secret/bar", "key"); ok {
+		t.Fatalf("should not replay for another path")
+	}
+}
+
+func TestIdempotencyStore_Tidy(t *testing.T) {
+	s := mockIdempotencyStore(t, -time.Second)
+
+	if err := s.Put("token", "secret/foo", "key", nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Expired keys are not replayed
+	if _, ok, _ := s.Get("token", "secret/foo", "key"); ok {
+		t.Fatalf("should be expired")
+	}
+
+	n, err := s.Tidy()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("bad: %d", n)
+	}
+
+	keys, err := CollectKeys(s.view)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("bad: %v", keys)
+	}
+}
+
+func TestCore_HandleRequest_Idempotent(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	create := func(key string) (*logical.Response, error) {
+		req := &logical.Request{
+			Operation:      logical.WriteOperation,
+			Path:           "auth/token/create",
+			ClientToken:    root,
+			IdempotencyKey: key,
+		}
+		return c.HandleRequest(req)
+	}
+
+	first, err := create("foo")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if first == nil || first.Auth == nil || first.Auth.ClientToken == "" {
+		t.Fatalf("bad: %#v", first)
+	}
+
+	// The recorded response does not hold the token
+	recorded, ok, err := c.idempotency.Get(root, "auth/token/create", "foo")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !ok || recorded.Auth == nil || recorded.Auth.ClientToken != "" {
+		t.Fatalf("bad: %#v", recorded)
+	}
+
+	// Replaying the key does not create or return a token
+	resp, err := create("foo")
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || !resp.IsError() || resp.Auth != nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+	if n := c.tokenStore.numTokens(); n != 2 {
+		t.Fatalf("bad: %d", n)
+	}
+
+	// A different key or no key creates a new token
+	for _, key := range []string{"bar", ""} {
+		other, err := create(key)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if other == nil || other.Auth == nil || other.Auth.ClientToken == first.Auth.ClientToken {
+			t.Fatalf("bad: %#v", other)
+		}
+	}
+
+	// Once the token is revoked, the replay reports the revocation
+	if err := c.tokenStore.Revoke(first.Auth.ClientToken); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, err = create("foo")
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || !strings.Contains(resp.Data["error"].(string), "revoked") {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
+func TestCore_HandleRequest_Idempotent_Concurrent(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	var wg sync.WaitGroup
+	tokens := make(chan string, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := &logical.Request{
+				Operation:      logical.WriteOperation,
+				Path:           "auth/token/create",
+				ClientToken:    root,
+				IdempotencyKey: "foo",
+			}
+			resp, _ := c.HandleRequest(req)
+			if resp != nil && resp.Auth != nil {
+				tokens <- resp.Auth.ClientToken
+			}
+		}()
+	}
+	wg.Wait()
+	close(tokens)
+
+	// Only one of the writes is executed
+	if n := len(tokens); n != 1 {
+		t.Fatalf("bad: %d", n)
+	}
+	if len(c.idempotency.locks) != 0 {
+		t.Fatalf("bad: %#v", c.idempotency.locks)
+	}
+}
+
+func TestCore_HandleRequest_Idempotent_NotFlagged(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	// The passthrough backend does not flag any paths, so the key
+	// is ignored and every write is executed.
+	for _, value := range []string{"one", "two"} {
+		req := &logical.Request{
+			Operation: logical.WriteOperation,
+			Path:      "secret/foo",
+			Data: map[string]interface{}{
+				"value": value,
+			},
+			ClientToken:    root,
+			IdempotencyKey: "key",
+		}
+		if _, err := c.HandleRequest(req); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	req := &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "secret/foo",
+		ClientToken: root,
+	}
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Data["value"] != "two" {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
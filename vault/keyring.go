@@ -0,0 +1,58 @@
+package vault
+
+import (
+	"crypto/cipher"
+	"time"
+)
+
+// barrierTerm is the persisted form of a key added by rotation
+type barrierTerm struct {
+	Term        uint32
+	Key         []byte
+	InstallTime time.Time
+}
+
+// keyring holds the encryption keys of the barrier, each identified by
+// its term. Values are encrypted with the active key, which has the
+// highest term, and the term is stored with each value so that it is
+// decrypted with the key that encrypted it. A keyring is never modified
+// once created, so it can be swapped in a single assignment.
+type keyring struct {
+	activeTerm uint32
+	keys       map[uint32]cipher.AEAD
+}
+
+// newKeyring creates a keyring holding only the given key
+func newKeyring(term uint32, gcm cipher.AEAD) *keyring {
+	return &keyring{
+		activeTerm: term,
+		keys:       map[uint32]cipher.AEAD{term: gcm},
+	}
+}
+
+// addKey returns a copy of the keyring with the given key added. The
+// key becomes the active key if its term is the highest.
+func (k *keyring) addKey(term uint32, gcm cipher.AEAD) *keyring {
+	out := &keyring{
+		activeTerm: k.activeTerm,
+		keys:       make(map[uint32]cipher.AEAD, len(k.keys)+1),
+	}
+	for t, key := range k.keys {
+		out.keys[t] = key
+	}
+	out.keys[term] = gcm
+	if term > out.activeTerm {
+		out.activeTerm = term
+	}
+	return out
+}
+
+// activeKey returns the term and key used to encrypt
+func (k *keyring) activeKey() (uint32, cipher.AEAD) {
+	return k.activeTerm, k.keys[k.activeTerm]
+}
+
+// termKey returns the key of the given term, or nil if it is unknown
+func (k *keyring) termKey(term uint32) cipher.AEAD {
+	return k.keys[term]
+}
@@ -0,0 +1,166 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// coreLeaseConfigPath is used to store the system-wide lease
+	// configuration. It is protected within the Vault itself.
+	coreLeaseConfigPath = "core/lease-config"
+)
+
+var (
+	// loadLeaseConfigFailed if loading the lease configuration encounters an error
+	loadLeaseConfigFailed = errors.New("failed to setup lease configuration")
+)
+
+// leaseConfig is the system-wide lease configuration. It determines the
+// lease applied to secrets and tokens that do not request one, and the
+// maximum lease any of them may have.
+type leaseConfig struct {
+	DefaultLease time.Duration `json:"default_lease"`
+	MaxLease     time.Duration `json:"max_lease"`
+}
+
+// defaultLeaseConfig returns the lease configuration used until the
+// operator changes it
+func defaultLeaseConfig() *leaseConfig {
+	return &leaseConfig{
+		DefaultLease: defaultLeaseDuration,
+		MaxLease:     maxLeaseDuration,
+	}
+}
+
+// Validate is used to sanity check the lease configuration
+func (l *leaseConfig) Validate() error {
+	if l.DefaultLease <= 0 {
+		return fmt.Errorf("default lease must be positive")
+	}
+	if l.MaxLease <= 0 {
+		return fmt.Errorf("max lease must be positive")
+	}
+	if l.DefaultLease > l.MaxLease {
+		return fmt.Errorf("default lease cannot be larger than max lease")
+	}
+	return nil
+}
+
+// loadLeaseConfig is invoked as part of postUnseal to load the
+// persisted lease configuration, if any
+func (c *Core) loadLeaseConfig() error {
+	raw, err := c.barrier.Get(coreLeaseConfigPath)
+	if err != nil {
+		c.logger.Error("core: failed to read lease configuration: %v", err)
+		return loadLeaseConfigFailed
+	}
+
+	conf := defaultLeaseConfig()
+	if raw != nil {
+		if err := json.Unmarshal(raw.Value, conf); err != nil {
+			c.logger.Error("core: failed to decode lease configuration: %v", err)
+			return loadLeaseConfigFailed
+		}
+	}
+
+	c.leaseConfigLock.Lock()
+	c.leaseConfig = conf
+	c.leaseConfigLock.Unlock()
+	return nil
+}
+
+// LeaseConfig returns the default and maximum lease durations
+func (c *Core) LeaseConfig() (time.Duration, time.Duration) {
+	c.leaseConfigLock.RLock()
+	defer c.leaseConfigLock.RUnlock()
+	if c.leaseConfig == nil {
+		return defaultLeaseDuration, maxLeaseDuration
+	}
+	return c.leaseConfig.DefaultLease, c.leaseConfig.MaxLease
+}
+
+// MountLeaseConfig returns the default and maximum lease durations for
+// the given path, which are those of its mount if tuned and otherwise
+// the system-wide values
+func (c *Core) MountLeaseConfig(path string) (time.Duration, time.Duration) {
+	defaultLease, maxLease := c.LeaseConfig()
+
+	mount := c.router.MatchingMount(path)
+	if mount == "" {
+		return defaultLease, maxLease
+	}
+
+	c.mountsLock.RLock()
+	var mountDefault, mountMax time.Duration
+	if c.mounts != nil {
+		if entry := c.mounts.Find(mount); entry != nil {
+			mountDefault, mountMax = entry.DefaultLeaseTTL, entry.MaxLeaseTTL
+		}
+	}
+	c.mountsLock.RUnlock()
+
+	if mountMax > 0 {
+		maxLease = mountMax
+	}
+	if mountDefault > 0 {
+		defaultLease = mountDefault
+	}
+	if defaultLease > maxLease {
+		defaultLease = maxLease
+	}
+	return defaultLease, maxLease
+}
+
+// mountLeasesDisabled returns whether the mount of a path has leases
+// disabled, in which case its secrets are not registered with the
+// expiration manager
+func (c *Core) mountLeasesDisabled(path string) bool {
+	mount := c.router.MatchingMount(path)
+	if mount == "" {
+		return false
+	}
+
+	c.mountsLock.RLock()
+	defer c.mountsLock.RUnlock()
+	if c.mounts == nil {
+		return false
+	}
+	entry := c.mounts.Find(mount)
+	return entry != nil && entry.DisableLeases
+}
+
+// SetLeaseConfig is used to update and persist the default and maximum
+// lease durations. The new values apply to leases created afterwards,
+// existing leases are unaffected.
+func (c *Core) SetLeaseConfig(defaultLease, maxLease time.Duration) error {
+	conf := &leaseConfig{
+		DefaultLease: defaultLease,
+		MaxLease:     maxLease,
+	}
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(conf)
+	if err != nil {
+		return fmt.Errorf("failed to encode lease configuration: %v", err)
+	}
+
+	c.leaseConfigLock.Lock()
+	defer c.leaseConfigLock.Unlock()
+	entry := &Entry{
+		Key:   coreLeaseConfigPath,
+		Value: raw,
+	}
+	if err := c.barrier.Put(entry); err != nil {
+		c.logger.Error("core: failed to persist lease configuration: %v", err)
+		return errors.New("failed to update lease configuration")
+	}
+	c.leaseConfig = conf
+	c.logger.Info("core: set default lease to %s and max lease to %s",
+		defaultLease, maxLease)
+	return nil
+}
@@ -0,0 +1,98 @@
+package vault
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestCore_LeaseConfig(t *testing.T) {
+	c, key, root := TestCoreUnsealed(t)
+
+	def, max := c.LeaseConfig()
+	if def != defaultLeaseDuration || max != maxLeaseDuration {
+		t.Fatalf("bad: %v %v", def, max)
+	}
+
+	// The default cannot exceed the max
+	if err := c.SetLeaseConfig(2*time.Hour, time.Hour); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := c.SetLeaseConfig(0, time.Hour); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	if err := c.SetLeaseConfig(time.Hour, 2*time.Hour); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// New leases use the configuration immediately
+	req := &logical.Request{
+		Operation: logical.WriteOperation,
+		Path:      "secret/test",
+		Data: map[string]interface{}{
+			"foo":   "bar",
+			"lease": "1000h",
+		},
+		ClientToken: root,
+	}
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	req.Operation = logical.ReadOperation
+	req.Data = nil
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Secret == nil || resp.Secret.Lease != 2*time.Hour {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// The configuration survives a reseal
+	if err := c.Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if unseal, err := c.Unseal(TestKeyCopy(key)); err != nil || !unseal {
+		t.Fatalf("err: %v", err)
+	}
+	def, max = c.LeaseConfig()
+	if def != time.Hour || max != 2*time.Hour {
+		t.Fatalf("bad: %v %v", def, max)
+	}
+}
+
+func TestCore_MountLeasesDisabled_Tune(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+
+	// Tuning swaps the mount table while it is being read, which must
+	// be safe under the race detector
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		for i := 0; i < 50; i++ {
+			conf := mountTuneConfig{DisableLeases: i%2 == 0}
+			if err := c.tuneMount("secret/", conf); err != nil {
+				t.Errorf("err: %v", err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		c.mountLeasesDisabled("secret/foo")
+		c.MountLeaseConfig("secret/foo")
+	}
+	<-doneCh
+
+	// The last tune enabled the leases
+	if c.mountLeasesDisabled("secret/foo") {
+		t.Fatalf("leases should be enabled")
+	}
+	if err := c.tuneMount("secret/", mountTuneConfig{DisableLeases: true}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !c.mountLeasesDisabled("secret/foo") {
+		t.Fatalf("leases should be disabled")
+	}
+}
@@ -0,0 +1,214 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	// cubbyholeMountPath is where the cubbyhole backend is always mounted
+	cubbyholeMountPath = "cubbyhole/"
+)
+
+// NewCubbyholeBackend is used to create a new cubbyhole backend. The
+// core is used to salt the token IDs with the token store.
+func NewCubbyholeBackend(core *Core) logical.Backend {
+	b := &CubbyholeBackend{Core: core}
+
+	b.Backend = &framework.Backend{
+		Help: strings.TrimSpace(cubbyholeHelp),
+
+		Paths: []*framework.Path{
+			&framework.Path{
+				Pattern:         ".*",
+				ArbitraryFields: true,
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ReadOperation:   b.handleRead,
+					logical.WriteOperation:  b.handleWrite,
+					logical.DeleteOperation: b.handleDelete,
+					logical.ListOperation:   b.handleList,
+
+					logical.ExistenceCheckOperation: b.handleExistenceCheck,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(cubbyholeHelpSynopsis),
+				HelpDescription: strings.TrimSpace(cubbyholeHelpDescription),
+			},
+		},
+	}
+
+	return b
+}
+
+// CubbyholeBackend is used to store secrets that are only visible to
+// the token that wrote them. The secrets of each token are stored under
+// the salted token ID, and are destroyed when the token is revoked.
+type CubbyholeBackend struct {
+	*framework.Backend
+
+	Core *Core
+}
+
+// tokenPrefix returns the storage prefix of the requesting token. The
+// ID is salted so that token IDs are never written to storage.
+func (b *CubbyholeBackend) tokenPrefix(req *logical.Request) (string, error) {
+	if req.ClientToken == "" {
+		return "", fmt.Errorf("client token empty")
+	}
+	return b.Core.tokenStore.SaltID(req.ClientToken) + "/", nil
+}
+
+func (b *CubbyholeBackend) handleRead(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	prefix, err := b.tokenPrefix(req)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	// Read the path
+	out, err := req.Storage.Get(prefix + req.Path)
+	if err != nil {
+		return nil, fmt.Errorf("read failed: %v", err)
+	}
+
+	// Fast-path the no data case
+	if out == nil {
+		return nil, nil
+	}
+
+	// Decode the data
+	var rawData map[string]interface{}
+	if err := json.Unmarshal(out.Value, &rawData); err != nil {
+		return nil, fmt.Errorf("json decoding failed: %v", err)
+	}
+
+	// Secrets in a cubbyhole live as long as the token, so they are
+	// returned without a lease
+	resp := &logical.Response{
+		Data: rawData,
+	}
+	return resp, nil
+}
+
+func (b *CubbyholeBackend) handleExistenceCheck(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	prefix, err := b.tokenPrefix(req)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	out, err := req.Storage.Get(prefix + req.Path)
+	if err != nil {
+		return nil, fmt.Errorf("existence check failed: %v", err)
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"exists": out != nil,
+		},
+	}
+	return resp, nil
+}
+
+func (b *CubbyholeBackend) handleWrite(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	prefix, err := b.tokenPrefix(req)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	// Check that some fields are given
+	if len(req.Data) == 0 {
+		return logical.ErrorResponse("missing data fields"), logical.ErrInvalidRequest
+	}
+
+	// JSON encode the data
+	buf, err := json.Marshal(req.Data)
+	if err != nil {
+		return nil, fmt.Errorf("json encoding failed: %v", err)
+	}
+
+	// Write out a new key
+	entry := &logical.StorageEntry{
+		Key:   prefix + req.Path,
+		Value: buf,
+	}
+	if err := req.Storage.Put(entry); err != nil {
+		return nil, fmt.Errorf("failed to write: %v", err)
+	}
+
+	return nil, nil
+}
+
+func (b *CubbyholeBackend) handleDelete(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	prefix, err := b.tokenPrefix(req)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	// Delete the key at the request path
+	if err := req.Storage.Delete(prefix + req.Path); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *CubbyholeBackend) handleList(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	prefix, err := b.tokenPrefix(req)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	// List the keys at the prefix given by the request
+	keys, err := req.Storage.List(prefix + req.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate the response
+	return logical.ListResponse(keys), nil
+}
+
+// destroyCubbyhole is used to delete the secrets of a revoked token,
+// given its salted ID. It is a no-op if the cubbyhole is not mounted.
+func (c *Core) destroyCubbyhole(saltedID string) error {
+	if c.router.MatchingMount(cubbyholeMountPath) != cubbyholeMountPath {
+		return nil
+	}
+	view := c.router.MatchingView(cubbyholeMountPath)
+	return ClearView(view.SubView(saltedID + "/"))
+}
+
+const cubbyholeHelp = `
+The cubbyhole backend reads and writes arbitrary secrets to the backend.
+The secrets are encrypted/decrypted by Vault: they are never stored
+unencrypted in the backend and the backend never has an opportunity to
+see the unencrypted value.
+
+This backend differs from the 'generic' backend in that it is scoped to
+the token. The secrets are only visible to the token that wrote them,
+and are destroyed when the token is revoked.
+`
+
+const cubbyholeHelpSynopsis = `
+Pass-through secret storage to a token-specific cubbyhole in the storage
+backend, allowing you to read/write arbitrary data into secret storage.
+`
+
+const cubbyholeHelpDescription = `
+The cubbyhole backend reads and writes arbitrary data into secret storage,
+encrypting it along the way.
+
+The view of each token is separate: two tokens writing to the same path
+do not see each other's secrets. All of the secrets of a token are
+deleted when the token is revoked or expires, and secrets are read
+without a lease since they cannot outlive the token.
+`
@@ -0,0 +1,140 @@
+package vault
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// testCubbyholeToken creates a child token of the root token that only
+// has the default policy
+func testCubbyholeToken(t *testing.T, c *Core, root string) string {
+	req := &logical.Request{
+		Operation:   logical.WriteOperation,
+		Path:        "auth/token/create",
+		ClientToken: root,
+	}
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return resp.Auth.ClientToken
+}
+
+func testCubbyholeRequest(t *testing.T, c *Core, op logical.Operation,
+	path, token string, data map[string]interface{}) *logical.Response {
+	req := &logical.Request{
+		Operation:   op,
+		Path:        path,
+		ClientToken: token,
+		Data:        data,
+	}
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err %s %s: %v", op, path, err)
+	}
+	return resp
+}
+
+func TestCubbyholeBackend_Isolation(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	token := testCubbyholeToken(t, c, root)
+
+	// Both tokens write to the same path
+	for _, tok := range []string{root, token} {
+		testCubbyholeRequest(t, c, logical.WriteOperation, "cubbyhole/foo", tok,
+			map[string]interface{}{"value": tok})
+	}
+	testCubbyholeRequest(t, c, logical.WriteOperation, "cubbyhole/bar", root,
+		map[string]interface{}{"value": "bar"})
+
+	// Each token only sees its own secrets
+	for _, tok := range []string{root, token} {
+		resp := testCubbyholeRequest(t, c, logical.ReadOperation, "cubbyhole/foo", tok, nil)
+		if resp == nil || resp.Data["value"] != tok {
+			t.Fatalf("bad: %#v", resp)
+		}
+		if resp.Secret != nil {
+			t.Fatalf("bad: %#v", resp.Secret)
+		}
+	}
+	resp := testCubbyholeRequest(t, c, logical.ListOperation, "cubbyhole/", token, nil)
+	if keys := resp.Data["keys"]; !reflect.DeepEqual(keys, []string{"foo"}) {
+		t.Fatalf("bad: %#v", keys)
+	}
+	resp = testCubbyholeRequest(t, c, logical.ReadOperation, "cubbyhole/bar", token, nil)
+	if resp != nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// Deleting only affects the secret of the token
+	testCubbyholeRequest(t, c, logical.DeleteOperation, "cubbyhole/foo", token, nil)
+	resp = testCubbyholeRequest(t, c, logical.ReadOperation, "cubbyhole/foo", root, nil)
+	if resp == nil || resp.Data["value"] != root {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
+func TestCubbyholeBackend_Revoke(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	token := testCubbyholeToken(t, c, root)
+
+	for _, tok := range []string{root, token} {
+		testCubbyholeRequest(t, c, logical.WriteOperation, "cubbyhole/foo/bar", tok,
+			map[string]interface{}{"value": tok})
+	}
+
+	// The secrets are stored under the token IDs salted by the store
+	view := c.router.MatchingView(cubbyholeMountPath)
+	keys, err := CollectKeys(view)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	sort.Strings(keys)
+	expected := []string{
+		c.tokenStore.SaltID(root) + "/foo/bar",
+		c.tokenStore.SaltID(token) + "/foo/bar",
+	}
+	sort.Strings(expected)
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("bad: %v %v", keys, expected)
+	}
+
+	// Revoking the token destroys its cubbyhole
+	if err := c.tokenStore.RevokeTree(token); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	keys, err = CollectKeys(view)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected = []string{c.tokenStore.SaltID(root) + "/foo/bar"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("bad: %v %v", keys, expected)
+	}
+
+	// Other cubbyholes are untouched
+	resp := testCubbyholeRequest(t, c, logical.ReadOperation, "cubbyhole/foo/bar", root, nil)
+	if resp == nil || resp.Data["value"] != root {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
+func TestCubbyholeBackend_Protected(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+
+	if err := c.mount(&MountEntry{Path: "foo", Type: "cubbyhole"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := c.mount(&MountEntry{Path: "cubbyhole/foo", Type: "generic"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := c.unmount("cubbyhole"); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := c.remount("cubbyhole", "foo"); err == nil {
+		t.Fatalf("expected error")
+	}
+}
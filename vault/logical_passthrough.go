@@ -1,22 +1,148 @@
 package vault
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
 
+const (
+	// passthroughStreamThreshold is the size above which values are
+	// written to storage as a stream, so that the barrier encrypts them
+	// in chunks. This only bounds the memory used to encrypt the value.
+	// The secret itself is still held in memory whole, since requests
+	// and responses carry its decoded data.
+	passthroughStreamThreshold = 512 * 1024
+
+	// passthroughEntryVersion is prefixed to values stored along with
+	// their metadata. Values written before metadata was tracked are
+	// plain JSON objects and so never begin with this byte.
+	passthroughEntryVersion byte = 0x1
+
+	// passthroughMetadataKey is the reserved key under which the
+	// metadata of a secret is returned when it is read
+	passthroughMetadataKey = "metadata"
+
+	// passthroughHistoryPrefix is the reserved prefix under which the
+	// data of the previous versions of secrets is stored, if versioning
+	// is enabled
+	passthroughHistoryPrefix = "history/"
+)
+
+// passthroughEntry is how the passthrough backend stores a secret. If
+// versioning is enabled, the metadata of the previous versions is kept
+// with the current version, oldest first, and their data is stored
+// under separate keys.
+type passthroughEntry struct {
+	Data     map[string]interface{} `json:"data"`
+	Metadata *passthroughMetadata   `json:"metadata"`
+	Versions []*passthroughMetadata `json:"versions,omitempty"`
+}
+
+// passthroughMetadata tracks the history of a secret. Secrets written
+// before metadata was tracked are treated as version 1 with unknown
+// creation and update times.
+type passthroughMetadata struct {
+	Version     int       `json:"version"`
+	CreatedTime time.Time `json:"created_time"`
+	UpdatedTime time.Time `json:"updated_time"`
+
+	// DeletedTime is set when the version is soft deleted, and
+	// Destroyed when its data has been permanently removed
+	DeletedTime *time.Time `json:"deleted_time,omitempty"`
+	Destroyed   bool       `json:"destroyed,omitempty"`
+}
+
+// toMap returns the metadata as it is returned in a response. Unknown
+// times are returned as nil.
+func (m *passthroughMetadata) toMap() map[string]interface{} {
+	out := map[string]interface{}{
+		"version":      m.Version,
+		"created_time": nil,
+		"updated_time": nil,
+	}
+	if !m.CreatedTime.IsZero() {
+		out["created_time"] = m.CreatedTime
+	}
+	if !m.UpdatedTime.IsZero() {
+		out["updated_time"] = m.UpdatedTime
+	}
+	return out
+}
+
+// decodePassthroughEntry decodes a stored secret, converting values
+// written before metadata was tracked
+func decodePassthroughEntry(value []byte) (*passthroughEntry, error) {
+	// Values written with metadata are prefixed by the version byte
+	if len(value) > 0 && value[0] == passthroughEntryVersion {
+		var entry passthroughEntry
+		if err := json.Unmarshal(value[1:], &entry); err != nil {
+			return nil, fmt.Errorf("json decoding failed: %v", err)
+		}
+		if entry.Data == nil {
+			entry.Data = make(map[string]interface{})
+		}
+		if entry.Metadata == nil {
+			entry.Metadata = &passthroughMetadata{Version: 1}
+		}
+		return &entry, nil
+	}
+
+	// An entry with a zero-length value can only have been written
+	// outside of this backend, but it still exists so it is returned
+	// with no data rather than as a missing key.
+	entry := &passthroughEntry{
+		Data:     make(map[string]interface{}),
+		Metadata: &passthroughMetadata{Version: 1},
+	}
+	if len(value) > 0 {
+		if err := json.Unmarshal(value, &entry.Data); err != nil {
+			return nil, fmt.Errorf("json decoding failed: %v", err)
+		}
+	}
+	return entry, nil
+}
+
+// encode is used to encode the entry for storage
+func (e *passthroughEntry) encode() ([]byte, error) {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("json encoding failed: %v", err)
+	}
+	return append([]byte{passthroughEntryVersion}, buf...), nil
+}
+
 // logical.Factory
-func PassthroughBackendFactory(map[string]string) (logical.Backend, error) {
+func PassthroughBackendFactory(conf map[string]string) (logical.Backend, error) {
 	var b PassthroughBackend
+
+	// Determine how many previous versions of each secret are kept
+	if raw := conf["versions"]; raw != "" {
+		versions, err := strconv.Atoi(raw)
+		if err != nil || versions < 0 {
+			return nil, fmt.Errorf("invalid versions option: %s", raw)
+		}
+		b.maxVersions = versions
+	}
+
+	// The version management paths are only reserved if versioning is
+	// enabled, otherwise they could shadow existing secrets
+	var paths []*framework.Path
+	if b.maxVersions > 0 {
+		paths = append(paths, b.versionPaths()...)
+	}
+
 	b.Backend = &framework.Backend{
 		Help: strings.TrimSpace(passthroughHelp),
 
-		Paths: []*framework.Path{
+		Paths: append(paths,
 			&framework.Path{
 				Pattern: ".*",
 				Fields: map[string]*framework.FieldSchema{
@@ -24,31 +150,45 @@ func PassthroughBackendFactory(map[string]string) (logical.Backend, error) {
 						Type:        framework.TypeString,
 						Description: "Lease time for this key when read. Ex: 1h",
 					},
+					"version": &framework.FieldSchema{
+						Type:        framework.TypeInt,
+						Description: "Version to read, if versioning is enabled. Defaults to the latest.",
+					},
 				},
+				ArbitraryFields: true,
 
 				Callbacks: map[logical.Operation]framework.OperationFunc{
 					logical.ReadOperation:   b.handleRead,
 					logical.WriteOperation:  b.handleWrite,
 					logical.DeleteOperation: b.handleDelete,
 					logical.ListOperation:   b.handleList,
+
+					logical.ExistenceCheckOperation: b.handleExistenceCheck,
 				},
 
 				HelpSynopsis:    strings.TrimSpace(passthroughHelpSynopsis),
 				HelpDescription: strings.TrimSpace(passthroughHelpDescription),
 			},
-		},
+		),
 
 		Secrets: []*framework.Secret{
 			&framework.Secret{
 				Type: "generic",
 
-				Renew:  b.handleRead,
+				Renew:  b.handleRenew,
 				Revoke: b.handleRevoke,
 			},
 		},
 	}
 
-	return b, nil
+	// Previous versions are read with a query parameter
+	if b.maxVersions > 0 {
+		b.Backend.PathsSpecial = &logical.Paths{
+			Query: []string{"*"},
+		}
+	}
+
+	return &b, nil
 }
 
 // PassthroughBackend is used storing secrets directly into the physical
@@ -57,6 +197,33 @@ func PassthroughBackendFactory(map[string]string) (logical.Backend, error) {
 // fancy.
 type PassthroughBackend struct {
 	*framework.Backend
+
+	// maxVersions is the number of previous versions kept of each
+	// secret. Versioning is disabled if zero.
+	maxVersions int
+
+	// writeLock is held while a secret is read and rewritten, so that
+	// check-and-set writes and version actions do not race
+	writeLock sync.Mutex
+}
+
+// Exportable implements logical.ExportableBackend. The passthrough
+// backend only stores static data, so it can always be exported.
+func (b *PassthroughBackend) Exportable() bool {
+	return true
+}
+
+// ExportEntry implements logical.ExportableBackend. Only the data of the
+// current version of each secret is exported, as a JSON object.
+func (b *PassthroughBackend) ExportEntry(storage logical.Storage, key string) (*logical.StorageEntry, error) {
+	if strings.HasPrefix(key, passthroughHistoryPrefix) {
+		return nil, nil
+	}
+	entry, err := b.getEntry(storage, key)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	return logical.StorageEntryJSON(key, entry.Data)
 }
 
 func (b *PassthroughBackend) handleRevoke(
@@ -65,25 +232,53 @@ func (b *PassthroughBackend) handleRevoke(
 	return nil, nil
 }
 
+func (b *PassthroughBackend) handleRenew(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.read(req, 0)
+}
+
 func (b *PassthroughBackend) handleRead(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	// Determine the version to read, if versioning is enabled
+	var version int
+	if b.maxVersions > 0 {
+		if _, _, err := data.GetOkErr("version"); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		version = data.Get("version").(int)
+	}
+	return b.read(req, version)
+}
+
+// read is used to read the given version of the secret at the request
+// path, or the latest if the version is zero
+func (b *PassthroughBackend) read(req *logical.Request, version int) (*logical.Response, error) {
 	// Read the path
-	out, err := req.Storage.Get(req.Path)
+	entry, err := b.getEntry(req.Storage, req.Path)
 	if err != nil {
-		return nil, fmt.Errorf("read failed: %v", err)
+		return nil, err
 	}
 
 	// Fast-path the no data case
-	if out == nil {
+	if entry == nil {
 		return nil, nil
 	}
 
-	// Decode the data
-	var rawData map[string]interface{}
-	if err := json.Unmarshal(out.Value, &rawData); err != nil {
-		return nil, fmt.Errorf("json decoding failed: %v", err)
+	// Find the version, which is missing if it has been pruned or
+	// deleted
+	if version != 0 && version != entry.Metadata.Version {
+		entry, err = b.getVersion(req.Storage, req.Path, entry, version)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if entry == nil || entry.Metadata.DeletedTime != nil || entry.Metadata.Destroyed {
+		return nil, nil
 	}
 
+	rawData := entry.Data
+	rawData[passthroughMetadataKey] = entry.Metadata.toMap()
+
 	// Generate the response
 	resp := b.Secret("generic").Response(rawData, nil)
 	resp.Secret.Renewable = false
@@ -101,33 +296,156 @@ func (b *PassthroughBackend) handleRead(
 	return resp, nil
 }
 
+func (b *PassthroughBackend) handleExistenceCheck(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	// Probe for the key without reading the value if possible
+	var exists bool
+	if es, ok := req.Storage.(logical.ExistenceStorage); ok {
+		var err error
+		exists, err = es.Exists(req.Path)
+		if err != nil {
+			return nil, fmt.Errorf("existence check failed: %v", err)
+		}
+	} else {
+		out, err := req.Storage.Get(req.Path)
+		if err != nil {
+			return nil, fmt.Errorf("existence check failed: %v", err)
+		}
+		exists = out != nil
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"exists": exists,
+		},
+	}
+	return resp, nil
+}
+
 func (b *PassthroughBackend) handleWrite(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	// Check that some fields are given
+	// Check that some fields are given. Empty writes are rejected since
+	// they would be indistinguishable from a deleted key, use a delete
+	// to remove a secret instead.
 	if len(req.Data) == 0 {
-		return nil, fmt.Errorf("missing data fields")
+		return logical.ErrorResponse("missing data fields"), logical.ErrInvalidRequest
 	}
+	if _, ok := req.Data[passthroughMetadataKey]; ok {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"'%s' is a reserved field", passthroughMetadataKey)), logical.ErrInvalidRequest
+	}
+
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
 
-	// JSON encode the data
-	buf, err := json.Marshal(req.Data)
+	prev, err := b.getEntry(req.Storage, req.Path)
 	if err != nil {
-		return nil, fmt.Errorf("json encoding failed: %v", err)
+		return nil, err
 	}
 
-	// Write out a new key
-	entry := &logical.StorageEntry{
-		Key:   req.Path,
-		Value: buf,
+	// Reject a check-and-set write if the secret has changed since the
+	// client read it. A missing secret is version 0.
+	if req.CAS != nil {
+		var current int
+		if prev != nil {
+			current = prev.Metadata.Version
+		}
+		if expected := *req.CAS; expected != current {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"check-and-set failed: expected version %d, current version is %d",
+				expected, current)), logical.ErrInvalidRequest
+		}
 	}
-	if err := req.Storage.Put(entry); err != nil {
-		return nil, fmt.Errorf("failed to write: %v", err)
+
+	// Bump the version of any existing secret, keeping its creation time
+	now := time.Now().UTC()
+	entry := &passthroughEntry{
+		Data: req.Data,
+		Metadata: &passthroughMetadata{
+			Version:     1,
+			CreatedTime: now,
+			UpdatedTime: now,
+		},
+	}
+	var pruned []*passthroughMetadata
+	if prev != nil {
+		entry.Metadata.Version = prev.Metadata.Version + 1
+		entry.Metadata.CreatedTime = prev.Metadata.CreatedTime
+		if b.maxVersions > 0 {
+			if err := b.putVersion(req.Storage, req.Path, prev); err != nil {
+				return nil, err
+			}
+			entry.Versions, pruned = prev.pushVersion(b.maxVersions)
+		}
 	}
 
+	if err := b.putEntry(req.Storage, req.Path, entry); err != nil {
+		return nil, err
+	}
+	for _, meta := range pruned {
+		if err := b.deleteVersion(req.Storage, req.Path, meta.Version); err != nil {
+			return nil, err
+		}
+	}
 	return nil, nil
 }
 
+// getEntry is used to read and decode the secret at the given path
+func (b *PassthroughBackend) getEntry(storage logical.Storage, path string) (*passthroughEntry, error) {
+	out, err := storage.Get(path)
+	if err != nil {
+		return nil, fmt.Errorf("read failed: %v", err)
+	}
+	if out == nil {
+		return nil, nil
+	}
+	return decodePassthroughEntry(out.Value)
+}
+
+// putEntry is used to encode and write the secret at the given path
+func (b *PassthroughBackend) putEntry(storage logical.Storage, path string, entry *passthroughEntry) error {
+	buf, err := entry.encode()
+	if err != nil {
+		return err
+	}
+
+	// Write out large values as a stream if possible, so that they are
+	// encrypted in chunks rather than as a single value. The encoded
+	// value is already in memory, so this does not reduce the memory
+	// used by the backend itself.
+	if stream, ok := storage.(logical.StreamStorage); ok && len(buf) > passthroughStreamThreshold {
+		if err := stream.PutStream(path, bytes.NewReader(buf)); err != nil {
+			return fmt.Errorf("failed to write: %v", err)
+		}
+		return nil
+	}
+
+	// Write out a new key
+	se := &logical.StorageEntry{
+		Key:   path,
+		Value: buf,
+	}
+	if err := storage.Put(se); err != nil {
+		return fmt.Errorf("failed to write: %v", err)
+	}
+	return nil
+}
+
 func (b *PassthroughBackend) handleDelete(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	// If versioning is enabled, only soft delete the latest version so
+	// that it can be recovered
+	if b.maxVersions > 0 {
+		b.writeLock.Lock()
+		defer b.writeLock.Unlock()
+
+		entry, err := b.getEntry(req.Storage, req.Path)
+		if err != nil || entry == nil {
+			return nil, err
+		}
+		return b.versionAction(req.Storage, req.Path, entry, "delete", 0)
+	}
+
 	// Delete the key at the request path
 	if err := req.Storage.Delete(req.Path); err != nil {
 		return nil, err
@@ -144,6 +462,17 @@ func (b *PassthroughBackend) handleList(
 		return nil, err
 	}
 
+	// The previous versions are not secrets themselves
+	if b.maxVersions > 0 && req.Path == "" {
+		filtered := keys[:0]
+		for _, key := range keys {
+			if key != passthroughHistoryPrefix {
+				filtered = append(filtered, key)
+			}
+		}
+		keys = filtered
+	}
+
 	// Generate the response
 	return logical.ListResponse(keys), nil
 }
@@ -168,6 +497,16 @@ const passthroughHelpDescription = `
 The pass-through backend reads and writes arbitrary data into secret storage,
 encrypting it along the way.
 
+When a secret is read, its version and the times it was created and
+last updated are returned under the reserved "metadata" key. Each write
+bumps the version. Secrets written before this was tracked report
+version 1 with unknown times.
+
+A write can be made a check-and-set by passing the version the secret
+is expected to have in the X-Vault-CAS header, or 0 if it must not exist
+yet. The write is rejected if the secret has been written since, so that
+concurrent writers do not lose updates.
+
 A lease can be specified when writing with the "lease" field. If given, then
 when the secret is read, Vault will report a lease with that duration. It
 is expected that the consumer of this backend properly writes renewed keys
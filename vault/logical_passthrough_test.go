@@ -2,6 +2,7 @@ package vault
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -38,6 +39,56 @@ func TestPassthroughBackend_Write(t *testing.T) {
 	}
 }
 
+func TestPassthroughBackend_Write_Empty(t *testing.T) {
+	b := testPassthroughBackend()
+	req := logical.TestRequest(t, logical.WriteOperation, "foo")
+
+	resp, err := b.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v", err)
+	}
+	if !resp.IsError() {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	out, err := req.Storage.Get("foo")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out != nil {
+		t.Fatalf("bad: %#v", out)
+	}
+}
+
+func TestPassthroughBackend_Read_EmptyValue(t *testing.T) {
+	b := testPassthroughBackend()
+	req := logical.TestRequest(t, logical.ReadOperation, "foo")
+
+	// Store a zero-length entry directly
+	err := req.Storage.Put(&logical.StorageEntry{Key: "foo", Value: []byte{}})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil {
+		t.Fatalf("empty value should be present")
+	}
+	expected := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"version":      1,
+			"created_time": nil,
+			"updated_time": nil,
+		},
+	}
+	if !reflect.DeepEqual(resp.Data, expected) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+}
+
 func TestPassthroughBackend_Read(t *testing.T) {
 	b := testPassthroughBackend()
 	req := logical.TestRequest(t, logical.WriteOperation, "foo")
@@ -72,11 +123,161 @@ func TestPassthroughBackend_Read(t *testing.T) {
 
 	resp.Secret.InternalData = nil
 	resp.Secret.LeaseID = ""
+	delete(resp.Data, "metadata")
 	if !reflect.DeepEqual(resp, expected) {
 		t.Fatalf("bad response.\n\nexpected: %#v\n\nGot: %#v", expected, resp)
 	}
 }
 
+func TestPassthroughBackend_Metadata(t *testing.T) {
+	b := testPassthroughBackend()
+	req := logical.TestRequest(t, logical.WriteOperation, "foo")
+	req.Data["raw"] = "test"
+	storage := req.Storage
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	read := func() map[string]interface{} {
+		req := logical.TestRequest(t, logical.ReadOperation, "foo")
+		req.Storage = storage
+		resp, err := b.HandleRequest(req)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return resp.Data["metadata"].(map[string]interface{})
+	}
+
+	meta := read()
+	created, ok := meta["created_time"].(time.Time)
+	if meta["version"] != 1 || !ok || created.IsZero() || meta["updated_time"] != created {
+		t.Fatalf("bad: %#v", meta)
+	}
+
+	// Writes bump the version and keep the creation time
+	req = logical.TestRequest(t, logical.WriteOperation, "foo")
+	req.Data["raw"] = "test2"
+	req.Storage = storage
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	meta = read()
+	updated, ok := meta["updated_time"].(time.Time)
+	if meta["version"] != 2 || meta["created_time"] != created || !ok || updated.Before(created) {
+		t.Fatalf("bad: %#v", meta)
+	}
+
+	// The metadata key is reserved
+	req = logical.TestRequest(t, logical.WriteOperation, "foo")
+	req.Data["metadata"] = "nope"
+	req.Storage = storage
+	if _, err := b.HandleRequest(req); err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestPassthroughBackend_CAS(t *testing.T) {
+	b := testPassthroughBackend()
+	storage := &logical.InmemStorage{}
+
+	write := func(data map[string]interface{}, cas *int) error {
+		req := logical.TestRequest(t, logical.WriteOperation, "foo")
+		req.Data = data
+		req.CAS = cas
+		req.Storage = storage
+		_, err := b.HandleRequest(req)
+		return err
+	}
+	read := func() map[string]interface{} {
+		req := logical.TestRequest(t, logical.ReadOperation, "foo")
+		req.Storage = storage
+		resp, err := b.HandleRequest(req)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return resp.Data
+	}
+	version := func(v int) *int { return &v }
+
+	// A version of 0 only creates the secret
+	if err := write(map[string]interface{}{"raw": "1"}, version(0)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := write(map[string]interface{}{"raw": "2"}, version(0)); err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The version must match the current one
+	if err := write(map[string]interface{}{"raw": "2"}, version(1)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := write(map[string]interface{}{"raw": "3"}, version(1)); err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v", err)
+	}
+	data := read()
+	if data["raw"] != "2" || data["metadata"].(map[string]interface{})["version"] != 2 {
+		t.Fatalf("bad: %#v", data)
+	}
+
+	// Writes without a version are unaffected, and a "cas" field is
+	// stored like any other
+	if err := write(map[string]interface{}{"raw": "3", "cas": "1"}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if data := read(); data["raw"] != "3" || data["cas"] != "1" {
+		t.Fatalf("bad: %#v", data)
+	}
+}
+
+func TestPassthroughBackend_Metadata_Legacy(t *testing.T) {
+	b := testPassthroughBackend()
+	req := logical.TestRequest(t, logical.ReadOperation, "foo")
+	storage := req.Storage
+
+	// Store a value written before metadata was tracked
+	err := storage.Put(&logical.StorageEntry{Key: "foo", Value: []byte(`{"raw":"test"}`)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected := map[string]interface{}{
+		"raw": "test",
+		"metadata": map[string]interface{}{
+			"version":      1,
+			"created_time": nil,
+			"updated_time": nil,
+		},
+	}
+	if !reflect.DeepEqual(resp.Data, expected) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// Updating it starts tracking, with the creation time still unknown
+	req = logical.TestRequest(t, logical.WriteOperation, "foo")
+	req.Data["raw"] = "test2"
+	req.Storage = storage
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	req = logical.TestRequest(t, logical.ReadOperation, "foo")
+	req.Storage = storage
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	meta := resp.Data["metadata"].(map[string]interface{})
+	if meta["version"] != 2 || meta["created_time"] != nil || meta["updated_time"] == nil {
+		t.Fatalf("bad: %#v", meta)
+	}
+	if resp.Data["raw"] != "test2" {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+}
+
 func TestPassthroughBackend_Delete(t *testing.T) {
 	b := testPassthroughBackend()
 	req := logical.TestRequest(t, logical.WriteOperation, "foo")
@@ -108,6 +309,43 @@ func TestPassthroughBackend_Delete(t *testing.T) {
 	}
 }
 
+func TestPassthroughBackend_ExistenceCheck(t *testing.T) {
+	b := testPassthroughBackend()
+	req := logical.TestRequest(t, logical.ExistenceCheckOperation, "foo")
+	storage := req.Storage
+
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Data["exists"] != false {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	req = logical.TestRequest(t, logical.WriteOperation, "foo")
+	req.Storage = storage
+	req.Data["raw"] = "test"
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The value is not returned and no lease is created
+	req = logical.TestRequest(t, logical.ExistenceCheckOperation, "foo")
+	req.Storage = storage
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected := &logical.Response{
+		Data: map[string]interface{}{
+			"exists": true,
+		},
+	}
+	if !reflect.DeepEqual(resp, expected) {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
 func TestPassthroughBackend_List(t *testing.T) {
 	b := testPassthroughBackend()
 	req := logical.TestRequest(t, logical.WriteOperation, "foo")
@@ -140,3 +378,43 @@ func testPassthroughBackend() logical.Backend {
 	b, _ := PassthroughBackendFactory(nil)
 	return b
 }
+
+func TestPassthroughBackend_Write_Stream(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	// Write a value large enough to be streamed
+	value := strings.Repeat("a", passthroughStreamThreshold)
+	req := &logical.Request{
+		Operation: logical.WriteOperation,
+		Path:      "secret/foo",
+		Data: map[string]interface{}{
+			"value": value,
+		},
+		ClientToken: root,
+	}
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The value should be stored in chunks
+	keys, err := c.barrier.List(barrierChunkPrefix)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("bad: %v", keys)
+	}
+
+	req = &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "secret/foo",
+		ClientToken: root,
+	}
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Data["value"] != value {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
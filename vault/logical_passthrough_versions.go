@@ -0,0 +1,206 @@
+package vault
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// versionPaths returns the paths used to manage the versions of
+// secrets. These are only used if versioning is enabled.
+func (b *PassthroughBackend) versionPaths() []*framework.Path {
+	return []*framework.Path{
+		&framework.Path{
+			Pattern: "(?P<action>delete|undelete|destroy)/(?P<key>.+)",
+			Fields: map[string]*framework.FieldSchema{
+				"action": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "One of delete, undelete or destroy.",
+				},
+				"key": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "Key of the secret.",
+				},
+				"version": &framework.FieldSchema{
+					Type:        framework.TypeInt,
+					Description: "Version to act on. Defaults to the latest.",
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.WriteOperation: b.handleVersionAction,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(passthroughVersionsHelpSynopsis),
+			HelpDescription: strings.TrimSpace(passthroughVersionsHelpDescription),
+		},
+
+		// The previous versions are only accessed through the versions
+		// of their secret
+		&framework.Path{
+			Pattern: passthroughHistoryPrefix + ".*",
+		},
+	}
+}
+
+// versionMetadata returns the metadata of the given version of the
+// secret, or nil if it is not the current version and is not kept
+func (e *passthroughEntry) versionMetadata(version int) *passthroughMetadata {
+	if e.Metadata.Version == version {
+		return e.Metadata
+	}
+	for _, meta := range e.Versions {
+		if meta.Version == version {
+			return meta
+		}
+	}
+	return nil
+}
+
+// pushVersion returns the metadata of the previous versions for the
+// version that replaces this one, keeping at most max of them, and the
+// metadata of the versions that are pruned
+func (e *passthroughEntry) pushVersion(max int) ([]*passthroughMetadata, []*passthroughMetadata) {
+	versions := make([]*passthroughMetadata, 0, len(e.Versions)+1)
+	versions = append(versions, e.Versions...)
+	versions = append(versions, e.Metadata)
+	if len(versions) <= max {
+		return versions, nil
+	}
+	return versions[len(versions)-max:], versions[:len(versions)-max]
+}
+
+// versionKey returns the key the data of a previous version is stored
+// under
+func versionKey(key string, version int) string {
+	return passthroughHistoryPrefix + key + "/" + strconv.Itoa(version)
+}
+
+// getVersion is used to read a previous version of the secret, or nil
+// if it is not kept
+func (b *PassthroughBackend) getVersion(storage logical.Storage, key string,
+	entry *passthroughEntry, version int) (*passthroughEntry, error) {
+	meta := entry.versionMetadata(version)
+	if meta == nil {
+		return nil, nil
+	}
+	prev := &passthroughEntry{Metadata: meta}
+	if meta.Destroyed {
+		return prev, nil
+	}
+	out, err := b.getEntry(storage, versionKey(key, version))
+	if err != nil || out == nil {
+		return nil, err
+	}
+	prev.Data = out.Data
+	return prev, nil
+}
+
+// putVersion is used to write the data of the current version of the
+// secret before it is replaced
+func (b *PassthroughBackend) putVersion(storage logical.Storage, key string, entry *passthroughEntry) error {
+	if entry.Metadata.Destroyed {
+		return nil
+	}
+	return b.putEntry(storage, versionKey(key, entry.Metadata.Version),
+		&passthroughEntry{Data: entry.Data})
+}
+
+// deleteVersion is used to remove the data of a previous version
+func (b *PassthroughBackend) deleteVersion(storage logical.Storage, key string, version int) error {
+	if err := storage.Delete(versionKey(key, version)); err != nil {
+		return fmt.Errorf("failed to delete version %d: %v", version, err)
+	}
+	return nil
+}
+
+func (b *PassthroughBackend) handleVersionAction(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	action := data.Get("action").(string)
+	key := data.Get("key").(string)
+	if _, _, err := data.GetOkErr("version"); err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	version := data.Get("version").(int)
+
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
+
+	entry, err := b.getEntry(req.Storage, key)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"no secret at '%s'", key)), logical.ErrInvalidRequest
+	}
+	return b.versionAction(req.Storage, key, entry, action, version)
+}
+
+// versionAction is used to apply an action to the given version of a
+// secret, or the latest if the version is zero. The write lock must be
+// held.
+func (b *PassthroughBackend) versionAction(storage logical.Storage, key string,
+	entry *passthroughEntry, action string, version int) (*logical.Response, error) {
+	// Find the version to act on, defaulting to the latest
+	target := entry.Metadata
+	if version != 0 {
+		target = entry.versionMetadata(version)
+	}
+	if target == nil {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"version %d of '%s' not found", version, key)), logical.ErrInvalidRequest
+	}
+
+	switch action {
+	case "delete":
+		if target.DeletedTime == nil {
+			now := time.Now().UTC()
+			target.DeletedTime = &now
+		}
+	case "undelete":
+		if target.Destroyed {
+			return logical.ErrorResponse(fmt.Sprintf("version %d of '%s' is destroyed",
+				target.Version, key)), logical.ErrInvalidRequest
+		}
+		target.DeletedTime = nil
+	case "destroy":
+		if target == entry.Metadata {
+			entry.Data = nil
+		} else if err := b.deleteVersion(storage, key, target.Version); err != nil {
+			return nil, err
+		}
+		target.Destroyed = true
+	}
+
+	if err := b.putEntry(storage, key, entry); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+const passthroughVersionsHelpSynopsis = `
+Soft delete, undelete or permanently destroy a version of a secret.
+`
+
+const passthroughVersionsHelpDescription = `
+When versioning is enabled with the "versions" mount option, each write
+to a secret keeps the previous versions, up to the configured number.
+A specific version can be read by passing the "version" parameter.
+
+The data of the previous versions is stored under the reserved
+"history/" prefix, which cannot be accessed directly.
+
+Writing to "delete/<key>" marks a version as deleted so that it can no
+longer be read, and writing to "undelete/<key>" restores it. Writing to
+"destroy/<key>" permanently removes the data of a version, which cannot
+be undone. These act on the latest version unless "version" is given.
+
+Deleting "<key>" itself is the same as writing to "delete/<key>", so the
+latest version can still be undeleted. The history of the secret is kept
+until it is pruned by later writes.
+`
@@ -0,0 +1,168 @@
+package vault
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestPassthroughBackend_Versions(t *testing.T) {
+	b, err := PassthroughBackendFactory(map[string]string{"versions": "2"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	_, barrier, _ := mockBarrier(t)
+	storage := NewBarrierView(barrier, "logical/")
+
+	request := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		req := logical.TestRequest(t, op, path)
+		req.Storage = storage
+		for k, v := range data {
+			req.Data[k] = v
+		}
+		resp, err := b.HandleRequest(req)
+		if err != nil {
+			t.Fatalf("err: %v %#v", err, resp)
+		}
+		return resp
+	}
+	readVersion := func(version int) interface{} {
+		resp := request(logical.ReadOperation, "foo", map[string]interface{}{
+			"version": version,
+		})
+		if resp == nil {
+			return nil
+		}
+		return resp.Data["value"]
+	}
+
+	for _, v := range []string{"one", "two", "three", "four"} {
+		request(logical.WriteOperation, "foo", map[string]interface{}{"value": v})
+	}
+
+	// The latest and two previous versions can be read, older versions
+	// have been pruned
+	if v := readVersion(0); v != "four" {
+		t.Fatalf("bad: %v", v)
+	}
+	if v := readVersion(3); v != "three" {
+		t.Fatalf("bad: %v", v)
+	}
+	if v := readVersion(2); v != "two" {
+		t.Fatalf("bad: %v", v)
+	}
+	if v := readVersion(1); v != nil {
+		t.Fatalf("bad: %v", v)
+	}
+
+	// The previous versions are stored under their own keys, and the
+	// pruned versions are removed
+	keys, err := storage.List("history/foo/")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"2", "3"}) {
+		t.Fatalf("bad: %#v", keys)
+	}
+
+	// The previous versions are not listed or accessible as secrets
+	resp := request(logical.ListOperation, "", nil)
+	if !reflect.DeepEqual(resp.Data["keys"], []string{"foo"}) {
+		t.Fatalf("bad: %#v", resp)
+	}
+	req := logical.TestRequest(t, logical.ReadOperation, "history/foo/2")
+	req.Storage = storage
+	if _, err := b.HandleRequest(req); err != logical.ErrUnsupportedOperation {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Soft delete the latest version, which hides it until undeleted
+	request(logical.WriteOperation, "delete/foo", nil)
+	if v := readVersion(0); v != nil {
+		t.Fatalf("bad: %v", v)
+	}
+	if v := readVersion(3); v != "three" {
+		t.Fatalf("bad: %v", v)
+	}
+	request(logical.WriteOperation, "undelete/foo", nil)
+	if v := readVersion(4); v != "four" {
+		t.Fatalf("bad: %v", v)
+	}
+
+	// Destroying a version cannot be undone
+	request(logical.WriteOperation, "destroy/foo", map[string]interface{}{"version": 3})
+	if v := readVersion(3); v != nil {
+		t.Fatalf("bad: %v", v)
+	}
+	if out, err := storage.Get("history/foo/3"); err != nil || out != nil {
+		t.Fatalf("bad: %#v %v", out, err)
+	}
+	req = logical.TestRequest(t, logical.WriteOperation, "undelete/foo")
+	req.Storage = storage
+	req.Data["version"] = 3
+	if _, err := b.HandleRequest(req); err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Unknown versions are rejected
+	req = logical.TestRequest(t, logical.WriteOperation, "delete/foo")
+	req.Storage = storage
+	req.Data["version"] = 1
+	if _, err := b.HandleRequest(req); err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Deleting the secret only soft deletes the latest version
+	request(logical.DeleteOperation, "foo", nil)
+	if v := readVersion(0); v != nil {
+		t.Fatalf("bad: %v", v)
+	}
+	if v := readVersion(2); v != "two" {
+		t.Fatalf("bad: %v", v)
+	}
+	request(logical.WriteOperation, "undelete/foo", nil)
+	if v := readVersion(0); v != "four" {
+		t.Fatalf("bad: %v", v)
+	}
+
+	// A write after a delete creates a new version
+	request(logical.DeleteOperation, "foo", nil)
+	request(logical.WriteOperation, "foo", map[string]interface{}{"value": "five"})
+	if v := readVersion(5); v != "five" {
+		t.Fatalf("bad: %v", v)
+	}
+	if v := readVersion(4); v != nil {
+		t.Fatalf("bad: %v", v)
+	}
+
+	// Deleting a missing secret is a no-op
+	request(logical.DeleteOperation, "bar", nil)
+}
+
+func TestPassthroughBackend_Versions_Disabled(t *testing.T) {
+	b := testPassthroughBackend()
+	storage := new(logical.InmemStorage)
+
+	// Without versioning the version paths are ordinary secrets
+	req := logical.TestRequest(t, logical.WriteOperation, "delete/foo")
+	req.Storage = storage
+	req.Data["value"] = "bar"
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	req = logical.TestRequest(t, logical.ReadOperation, "delete/foo")
+	req.Storage = storage
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Data["value"] != "bar" {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// Invalid options are rejected
+	if _, err := PassthroughBackendFactory(map[string]string{"versions": "-1"}); err == nil {
+		t.Fatalf("expected error")
+	}
+}
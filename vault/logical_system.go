@@ -1,17 +1,21 @@
 package vault
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/hashicorp/vault/audit"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
+	"github.com/mitchellh/mapstructure"
 )
 
 func NewSystemBackend(core *Core) logical.Backend {
 	b := &SystemBackend{Core: core}
 
-	return &framework.Backend{
+	backend := &framework.Backend{
 		Help: strings.TrimSpace(sysHelpRoot),
 
 		PathsSpecial: &logical.Paths{
@@ -24,8 +28,23 @@ func NewSystemBackend(core *Core) logical.Backend {
 				"policy/*",
 				"audit",
 				"audit/*",
-				"seal", // Must be set for Core.Seal() logic
+				"seal",        // Must be set for Core.Seal() logic
+				"export/*",    // Must be set for Core.ExportMount() logic
+				"revoke-root", // Must be set for Core.RevokeRootToken() logic
+				"step-down",   // Must be set for Core.StepDown() logic
+				"rotate",      // Must be set for Core.Rotate() logic
+				"verify",      // Must be set for Core.VerifyBarrier() logic
+				"tidy/*",
+				"config/ttl",
+				"config/rate-limit",
 				"raw/*",
+				"capabilities",
+				"internal/tables/*",
+			},
+
+			Query: []string{
+				"mounts-tree",
+				"internal/mounts",
 			},
 		},
 
@@ -41,6 +60,59 @@ func NewSystemBackend(core *Core) logical.Backend {
 				HelpDescription: strings.TrimSpace(sysHelp["mounts"][1]),
 			},
 
+			&framework.Path{
+				Pattern: "mounts-tree$",
+
+				Fields: map[string]*framework.FieldSchema{
+					"prefix": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["mounts-tree_prefix"][0]),
+					},
+				},
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ReadOperation: b.handleMountTree,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysHelp["mounts-tree"][0]),
+				HelpDescription: strings.TrimSpace(sysHelp["mounts-tree"][1]),
+			},
+
+			&framework.Path{
+				Pattern: "mounts/(?P<path>.+?)/tune$",
+
+				Fields: map[string]*framework.FieldSchema{
+					"path": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["mount_path"][0]),
+					},
+					"default_lease_ttl": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["tune_default_lease_ttl"][0]),
+					},
+					"max_lease_ttl": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["tune_max_lease_ttl"][0]),
+					},
+					"disable_leases": &framework.FieldSchema{
+						Type:        framework.TypeBool,
+						Description: strings.TrimSpace(sysHelp["mount_disable_leases"][0]),
+					},
+					"max_request_size": &framework.FieldSchema{
+						Type:        framework.TypeInt,
+						Description: strings.TrimSpace(sysHelp["mount_max_request_size"][0]),
+					},
+				},
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ReadOperation:  b.handleMountTuneRead,
+					logical.WriteOperation: b.handleMountTuneWrite,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysHelp["mount_tune"][0]),
+				HelpDescription: strings.TrimSpace(sysHelp["mount_tune"][1]),
+			},
+
 			&framework.Path{
 				Pattern: "mounts/(?P<path>.+)",
 
@@ -58,6 +130,26 @@ func NewSystemBackend(core *Core) logical.Backend {
 						Type:        framework.TypeString,
 						Description: strings.TrimSpace(sysHelp["mount_desc"][0]),
 					},
+					"seal_wrap": &framework.FieldSchema{
+						Type:        framework.TypeBool,
+						Description: strings.TrimSpace(sysHelp["mount_seal_wrap"][0]),
+					},
+					"read_only": &framework.FieldSchema{
+						Type:        framework.TypeBool,
+						Description: strings.TrimSpace(sysHelp["mount_read_only"][0]),
+					},
+					"disable_leases": &framework.FieldSchema{
+						Type:        framework.TypeBool,
+						Description: strings.TrimSpace(sysHelp["mount_disable_leases"][0]),
+					},
+					"max_request_size": &framework.FieldSchema{
+						Type:        framework.TypeInt,
+						Description: strings.TrimSpace(sysHelp["mount_max_request_size"][0]),
+					},
+					"options": &framework.FieldSchema{
+						Type:        framework.TypeMap,
+						Description: strings.TrimSpace(sysHelp["mount_options"][0]),
+					},
 				},
 
 				Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -113,6 +205,43 @@ func NewSystemBackend(core *Core) logical.Backend {
 				HelpDescription: strings.TrimSpace(sysHelp["renew"][1]),
 			},
 
+			&framework.Path{
+				Pattern: "leases/lookup/?$",
+
+				Fields: map[string]*framework.FieldSchema{
+					"lease_id": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["lease_id"][0]),
+					},
+				},
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ListOperation: b.handleLeaseList,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysHelp["lease-lookup"][0]),
+				HelpDescription: strings.TrimSpace(sysHelp["lease-lookup"][1]),
+			},
+
+			&framework.Path{
+				Pattern: "leases/lookup/(?P<lease_id>.+)",
+
+				Fields: map[string]*framework.FieldSchema{
+					"lease_id": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["lease_id"][0]),
+					},
+				},
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ReadOperation: b.handleLeaseLookup,
+					logical.ListOperation: b.handleLeaseList,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysHelp["lease-lookup"][0]),
+				HelpDescription: strings.TrimSpace(sysHelp["lease-lookup"][1]),
+			},
+
 			&framework.Path{
 				Pattern: "revoke/(?P<lease_id>.+)",
 
@@ -149,6 +278,28 @@ func NewSystemBackend(core *Core) logical.Backend {
 				HelpDescription: strings.TrimSpace(sysHelp["revoke-prefix"][1]),
 			},
 
+			&framework.Path{
+				Pattern: "tidy/tokens$",
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.WriteOperation: b.handleTidyTokens,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysHelp["tidy-tokens"][0]),
+				HelpDescription: strings.TrimSpace(sysHelp["tidy-tokens"][1]),
+			},
+
+			&framework.Path{
+				Pattern: "tidy/leases$",
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.WriteOperation: b.handleTidyLeases,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysHelp["tidy-leases"][0]),
+				HelpDescription: strings.TrimSpace(sysHelp["tidy-leases"][1]),
+			},
+
 			&framework.Path{
 				Pattern: "auth$",
 
@@ -176,6 +327,10 @@ func NewSystemBackend(core *Core) logical.Backend {
 						Type:        framework.TypeString,
 						Description: strings.TrimSpace(sysHelp["auth_desc"][0]),
 					},
+					"token_role": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["auth_token_role"][0]),
+					},
 				},
 
 				Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -188,16 +343,46 @@ func NewSystemBackend(core *Core) logical.Backend {
 			},
 
 			&framework.Path{
-				Pattern: "policy$",
+				Pattern: "policy/?$",
 
 				Callbacks: map[logical.Operation]framework.OperationFunc{
 					logical.ReadOperation: b.handlePolicyList,
+					logical.ListOperation: b.handlePolicyList,
 				},
 
 				HelpSynopsis:    strings.TrimSpace(sysHelp["policy-list"][0]),
 				HelpDescription: strings.TrimSpace(sysHelp["policy-list"][1]),
 			},
 
+			&framework.Path{
+				Pattern: "policy/(?P<name>test)$",
+
+				// The checks are read from the raw request data
+				ArbitraryFields: true,
+
+				Fields: map[string]*framework.FieldSchema{
+					"name": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["policy-name"][0]),
+					},
+					"rules": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["policy-rules"][0]),
+					},
+				},
+
+				// A policy named "test" can still be managed, a write
+				// with rules sets the policy
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ReadOperation:   b.handlePolicyRead,
+					logical.WriteOperation:  b.handlePolicyTest,
+					logical.DeleteOperation: b.handlePolicyDelete,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysHelp["policy-test"][0]),
+				HelpDescription: strings.TrimSpace(sysHelp["policy-test"][1]),
+			},
+
 			&framework.Path{
 				Pattern: "policy/(?P<name>.+)",
 
@@ -222,6 +407,46 @@ func NewSystemBackend(core *Core) logical.Backend {
 				HelpDescription: strings.TrimSpace(sysHelp["policy"][1]),
 			},
 
+			&framework.Path{
+				Pattern: "capabilities$",
+
+				Fields: map[string]*framework.FieldSchema{
+					"token": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["capabilities_token"][0]),
+					},
+					"path": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["capabilities_path"][0]),
+					},
+				},
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.WriteOperation: b.handleCapabilities,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysHelp["capabilities"][0]),
+				HelpDescription: strings.TrimSpace(sysHelp["capabilities"][1]),
+			},
+
+			&framework.Path{
+				Pattern: "capabilities-self$",
+
+				Fields: map[string]*framework.FieldSchema{
+					"path": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["capabilities_path"][0]),
+					},
+				},
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.WriteOperation: b.handleCapabilitiesSelf,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysHelp["capabilities-self"][0]),
+				HelpDescription: strings.TrimSpace(sysHelp["capabilities-self"][1]),
+			},
+
 			&framework.Path{
 				Pattern: "audit$",
 
@@ -253,6 +478,22 @@ func NewSystemBackend(core *Core) logical.Backend {
 						Type:        framework.TypeMap,
 						Description: strings.TrimSpace(sysHelp["audit_opts"][0]),
 					},
+					"best_effort": &framework.FieldSchema{
+						Type:        framework.TypeBool,
+						Description: strings.TrimSpace(sysHelp["audit_best_effort"][0]),
+					},
+					"omit_fields": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["audit_omit_fields"][0]),
+					},
+					"truncate_fields": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["audit_truncate_fields"][0]),
+					},
+					"truncate_length": &framework.FieldSchema{
+						Type:        framework.TypeInt,
+						Description: strings.TrimSpace(sysHelp["audit_truncate_length"][0]),
+					},
 				},
 
 				Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -265,25 +506,172 @@ func NewSystemBackend(core *Core) logical.Backend {
 			},
 
 			&framework.Path{
-				Pattern: "raw/(?P<path>.+)",
+				Pattern: "internal/counters/mounts$",
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ReadOperation: b.handleMountCounters,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysHelp["mount-counters"][0]),
+				HelpDescription: strings.TrimSpace(sysHelp["mount-counters"][1]),
+			},
+
+			&framework.Path{
+				Pattern: "internal/cache-stats$",
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ReadOperation: b.handleCacheStats,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysHelp["cache-stats"][0]),
+				HelpDescription: strings.TrimSpace(sysHelp["cache-stats"][1]),
+			},
+
+			&framework.Path{
+				Pattern: "internal/tables/mounts$",
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ReadOperation: b.handleMountTableDetail,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysHelp["mount-table-detail"][0]),
+				HelpDescription: strings.TrimSpace(sysHelp["mount-table-detail"][1]),
+			},
+
+			&framework.Path{
+				Pattern: "internal/tables/auth$",
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ReadOperation: b.handleAuthTableDetail,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysHelp["auth-table-detail"][0]),
+				HelpDescription: strings.TrimSpace(sysHelp["auth-table-detail"][1]),
+			},
+
+			&framework.Path{
+				Pattern: "internal/mounts$",
 
 				Fields: map[string]*framework.FieldSchema{
-					"path": &framework.FieldSchema{
-						Type: framework.TypeString,
+					"type": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["mounts_type"][0]),
+					},
+				},
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ReadOperation: b.handleMountsByType,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysHelp["mounts-by-type"][0]),
+				HelpDescription: strings.TrimSpace(sysHelp["mounts-by-type"][1]),
+			},
+
+			&framework.Path{
+				Pattern: "config/state$",
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ReadOperation: b.handleConfigState,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysHelp["config-state"][0]),
+				HelpDescription: strings.TrimSpace(sysHelp["config-state"][1]),
+			},
+
+			&framework.Path{
+				Pattern: "rewrap$",
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ReadOperation: b.handleRewrapStatus,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysHelp["rewrap"][0]),
+				HelpDescription: strings.TrimSpace(sysHelp["rewrap"][1]),
+			},
+
+			&framework.Path{
+				Pattern: "config/ttl$",
+
+				Fields: map[string]*framework.FieldSchema{
+					"default_lease_ttl": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["default_lease_ttl"][0]),
 					},
-					"value": &framework.FieldSchema{
-						Type: framework.TypeString,
+					"max_lease_ttl": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["max_lease_ttl"][0]),
 					},
 				},
 
 				Callbacks: map[logical.Operation]framework.OperationFunc{
-					logical.ReadOperation:   b.handleRawRead,
-					logical.WriteOperation:  b.handleRawWrite,
-					logical.DeleteOperation: b.handleRawDelete,
+					logical.WriteOperation: b.handleConfigTTL,
 				},
+
+				HelpSynopsis:    strings.TrimSpace(sysHelp["config-ttl"][0]),
+				HelpDescription: strings.TrimSpace(sysHelp["config-ttl"][1]),
+			},
+
+			&framework.Path{
+				Pattern: "config/rate-limit$",
+
+				Fields: map[string]*framework.FieldSchema{
+					"token_rate": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["token_rate"][0]),
+					},
+					"token_burst": &framework.FieldSchema{
+						Type:        framework.TypeInt,
+						Description: strings.TrimSpace(sysHelp["token_burst"][0]),
+					},
+					"global_rate": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["global_rate"][0]),
+					},
+					"global_burst": &framework.FieldSchema{
+						Type:        framework.TypeInt,
+						Description: strings.TrimSpace(sysHelp["global_burst"][0]),
+					},
+				},
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ReadOperation:  b.handleRateLimitRead,
+					logical.WriteOperation: b.handleRateLimitWrite,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysHelp["config-rate-limit"][0]),
+				HelpDescription: strings.TrimSpace(sysHelp["config-rate-limit"][1]),
 			},
 		},
 	}
+
+	// The raw barrier entries are only exposed if explicitly enabled
+	if core.enableRaw {
+		backend.Paths = append(backend.Paths, &framework.Path{
+			Pattern: "raw/(?P<path>.*)",
+
+			Fields: map[string]*framework.FieldSchema{
+				"path": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: strings.TrimSpace(sysHelp["raw_path"][0]),
+				},
+				"value": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: strings.TrimSpace(sysHelp["raw_value"][0]),
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   b.handleRawRead,
+				logical.WriteOperation:  b.handleRawWrite,
+				logical.DeleteOperation: b.handleRawDelete,
+				logical.ListOperation:   b.handleRawList,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysHelp["raw"][0]),
+			HelpDescription: strings.TrimSpace(sysHelp["raw"][1]),
+		})
+	}
+	return backend
 }
 
 // SystemBackend implements logical.Backend and is used to interact with
@@ -296,16 +684,20 @@ type SystemBackend struct {
 // handleMountTable handles the "mounts" endpoint to provide the mount table
 func (b *SystemBackend) handleMountTable(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	b.Core.mounts.Lock()
-	defer b.Core.mounts.Unlock()
+	b.Core.mountsLock.RLock()
+	defer b.Core.mountsLock.RUnlock()
 
 	resp := &logical.Response{
 		Data: make(map[string]interface{}),
 	}
 	for _, entry := range b.Core.mounts.Entries {
-		info := map[string]string{
+		info := map[string]interface{}{
 			"type":        entry.Type,
 			"description": entry.Description,
+			"read_only":   entry.ReadOnly,
+		}
+		if entry.Template != "" {
+			info["template"] = entry.Template
 		}
 		resp.Data[entry.Path] = info
 	}
@@ -313,6 +705,309 @@ func (b *SystemBackend) handleMountTable(
 	return resp, nil
 }
 
+// handleMountTableDetail handles the "internal/tables/mounts" endpoint to
+// provide every field of the entries of the mount table
+func (b *SystemBackend) handleMountTableDetail(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.Core.mountsLock.RLock()
+	defer b.Core.mountsLock.RUnlock()
+	return mountTableDetail(b.Core.mounts), nil
+}
+
+// handleAuthTableDetail handles the "internal/tables/auth" endpoint to
+// provide every field of the entries of the credential backend table
+func (b *SystemBackend) handleAuthTableDetail(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.Core.authLock.RLock()
+	defer b.Core.authLock.RUnlock()
+	return mountTableDetail(b.Core.auth), nil
+}
+
+// mountTableDetail is used to build a response with every field of the
+// entries of a mount table, keyed by path. The lease durations are in
+// seconds. The lock of the table must be held.
+func mountTableDetail(table *MountTable) *logical.Response {
+	resp := &logical.Response{
+		Data: make(map[string]interface{}),
+	}
+	for _, entry := range table.Entries {
+		// The entry is copied since the response is encoded after the
+		// lock is released. The tainted flag is not part of the copy.
+		e := entry.Clone()
+		var filter map[string]interface{}
+		if e.AuditFilter != nil {
+			filter = map[string]interface{}{
+				"omit":            e.AuditFilter.Omit,
+				"truncate":        e.AuditFilter.Truncate,
+				"truncate_length": e.AuditFilter.TruncateLength,
+			}
+		}
+		resp.Data[e.Path] = map[string]interface{}{
+			"type":              e.Type,
+			"description":       e.Description,
+			"uuid":              e.UUID,
+			"options":           e.Options,
+			"tainted":           entry.Tainted,
+			"seal_wrap":         e.SealWrap,
+			"read_only":         e.ReadOnly,
+			"best_effort":       e.BestEffort,
+			"audit_filter":      filter,
+			"template":          e.Template,
+			"token_role":        e.TokenRole,
+			"default_lease_ttl": int64(e.DefaultLeaseTTL / time.Second),
+			"max_lease_ttl":     int64(e.MaxLeaseTTL / time.Second),
+			"disable_leases":    e.DisableLeases,
+			"max_request_size":  e.MaxRequestSize,
+			"storage_version":   e.StorageVersion,
+		}
+	}
+	return resp
+}
+
+// handleMountTree handles the "mounts-tree" endpoint to provide the
+// mounts beneath a prefix, as a list and as a tree of path segments
+func (b *SystemBackend) handleMountTree(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	prefix := data.Get("prefix").(string)
+	mounts := b.Core.router.MountsUnder(prefix)
+
+	list := make([]map[string]interface{}, 0, len(mounts))
+	for _, m := range mounts {
+		list = append(list, map[string]interface{}{
+			"path": m.Path,
+			"type": m.Type,
+		})
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"prefix": prefix,
+			"mounts": list,
+			"tree":   mountTree(prefix, mounts),
+		},
+	}
+	return resp, nil
+}
+
+// mountTree arranges the mounts beneath the prefix by path segment.
+// Each node is keyed by its segment, and has the type and path if it
+// is a mount, and the nodes beneath it as children. Segments end with
+// a slash, so they do not collide with the other keys.
+func mountTree(prefix string, mounts []MountInfo) map[string]interface{} {
+	root := make(map[string]interface{})
+	for _, m := range mounts {
+		nodes := root
+		segments := strings.SplitAfter(strings.TrimPrefix(m.Path, prefix), "/")
+		if segments[len(segments)-1] == "" {
+			segments = segments[:len(segments)-1]
+		}
+
+		// The mount at the prefix itself is the root of the tree
+		if len(segments) == 0 {
+			root["type"] = m.Type
+			root["path"] = m.Path
+			continue
+		}
+		for i, segment := range segments {
+			node, ok := nodes[segment].(map[string]interface{})
+			if !ok {
+				node = make(map[string]interface{})
+				nodes[segment] = node
+			}
+			if i == len(segments)-1 {
+				node["type"] = m.Type
+				node["path"] = m.Path
+				continue
+			}
+			children, ok := node["children"].(map[string]interface{})
+			if !ok {
+				children = make(map[string]interface{})
+				node["children"] = children
+			}
+			nodes = children
+		}
+	}
+	return root
+}
+
+// handleMountCounters handles the "internal/counters/mounts" endpoint
+// to provide the per-mount request counts of this node
+func (b *SystemBackend) handleMountCounters(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	resp := &logical.Response{
+		Data: make(map[string]interface{}),
+	}
+	for mount, count := range b.Core.counters.Snapshot() {
+		resp.Data[mount] = count
+	}
+	return resp, nil
+}
+
+// handleCacheStats handles the "internal/cache-stats" endpoint to
+// provide the use of the physical cache of this node
+func (b *SystemBackend) handleCacheStats(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	stats, enabled := b.Core.CacheStats()
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"enabled":   enabled,
+			"size":      stats.Size,
+			"capacity":  stats.Capacity,
+			"hits":      stats.Hits,
+			"misses":    stats.Misses,
+			"evictions": stats.Evictions,
+			"hit_ratio": stats.HitRatio(),
+		},
+	}
+	return resp, nil
+}
+
+// handleMountsByType handles the "internal/mounts" endpoint to find
+// every mount of a backend type
+func (b *SystemBackend) handleMountsByType(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	backendType := data.Get("type").(string)
+	if backendType == "" {
+		return logical.ErrorResponse(
+				"backend type must be specified as a string"),
+			logical.ErrInvalidRequest
+	}
+
+	resp := &logical.Response{
+		Data: make(map[string]interface{}),
+	}
+	for table, entries := range b.Core.MountsByType(backendType) {
+		mounts := make(map[string]interface{}, len(entries))
+		for _, entry := range entries {
+			mounts[entry.Path] = map[string]interface{}{
+				"type":        entry.Type,
+				"description": entry.Description,
+				"options":     entry.Options,
+			}
+		}
+		resp.Data[table] = mounts
+	}
+	return resp, nil
+}
+
+// handleConfigState handles the "config/state" endpoint to read the
+// system-wide lease configuration
+func (b *SystemBackend) handleConfigState(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	defaultLease, maxLease := b.Core.LeaseConfig()
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"default_lease_ttl": int64(defaultLease / time.Second),
+			"max_lease_ttl":     int64(maxLease / time.Second),
+		},
+	}
+	return resp, nil
+}
+
+// handleRewrapStatus handles the "rewrap" endpoint to read the progress
+// of rewrapping the barrier entries with the active encryption key
+func (b *SystemBackend) handleRewrapStatus(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	status := b.Core.rewrap.Status()
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"term":      status.Term,
+			"running":   status.Running,
+			"checked":   status.Checked,
+			"rewrapped": status.Rewrapped,
+			"remaining": status.Remaining,
+		},
+	}
+	return resp, nil
+}
+
+// handleConfigTTL handles the "config/ttl" endpoint to update the
+// system-wide lease configuration
+func (b *SystemBackend) handleConfigTTL(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	defaultLease, maxLease := b.Core.LeaseConfig()
+
+	// Only update the values that are provided
+	if raw, ok := data.GetOk("default_lease_ttl"); ok {
+		dur, err := time.ParseDuration(raw.(string))
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"invalid default_lease_ttl: %v", err)), logical.ErrInvalidRequest
+		}
+		defaultLease = dur
+	}
+	if raw, ok := data.GetOk("max_lease_ttl"); ok {
+		dur, err := time.ParseDuration(raw.(string))
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"invalid max_lease_ttl: %v", err)), logical.ErrInvalidRequest
+		}
+		maxLease = dur
+	}
+
+	if err := b.Core.SetLeaseConfig(defaultLease, maxLease); err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	return nil, nil
+}
+
+// handleRateLimitRead handles the "config/rate-limit" endpoint to read
+// the request rate limits
+func (b *SystemBackend) handleRateLimitRead(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	conf := b.Core.RateLimitConfig()
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"token_rate":   conf.TokenRate,
+			"token_burst":  conf.TokenBurst,
+			"global_rate":  conf.GlobalRate,
+			"global_burst": conf.GlobalBurst,
+		},
+	}
+	return resp, nil
+}
+
+// handleRateLimitWrite handles the "config/rate-limit" endpoint to
+// update the request rate limits
+func (b *SystemBackend) handleRateLimitWrite(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	conf := b.Core.RateLimitConfig()
+
+	// Only update the values that are provided
+	for name, rate := range map[string]*float64{
+		"token_rate":  &conf.TokenRate,
+		"global_rate": &conf.GlobalRate,
+	} {
+		raw, ok := data.GetOk(name)
+		if !ok {
+			continue
+		}
+		val, err := strconv.ParseFloat(raw.(string), 64)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"invalid %s: %v", name, err)), logical.ErrInvalidRequest
+		}
+		*rate = val
+	}
+	for name, burst := range map[string]*int{
+		"token_burst":  &conf.TokenBurst,
+		"global_burst": &conf.GlobalBurst,
+	} {
+		raw, ok, err := data.GetOkErr(name)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		if ok {
+			*burst = raw.(int)
+		}
+	}
+
+	if err := b.Core.SetRateLimitConfig(conf); err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	return nil, nil
+}
+
 // handleMount is used to mount a new path
 func (b *SystemBackend) handleMount(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -320,6 +1015,11 @@ func (b *SystemBackend) handleMount(
 	path := data.Get("path").(string)
 	logicalType := data.Get("type").(string)
 	description := data.Get("description").(string)
+	sealWrap := data.Get("seal_wrap").(bool)
+	readOnly := data.Get("read_only").(bool)
+	disableLeases := data.Get("disable_leases").(bool)
+	maxRequestSize := data.Get("max_request_size").(int)
+	options := data.Get("options").(map[string]interface{})
 
 	if logicalType == "" {
 		return logical.ErrorResponse(
@@ -327,11 +1027,27 @@ func (b *SystemBackend) handleMount(
 			logical.ErrInvalidRequest
 	}
 
+	optionMap := make(map[string]string)
+	for k, v := range options {
+		vStr, ok := v.(string)
+		if !ok {
+			return logical.ErrorResponse("options must be string valued"),
+				logical.ErrInvalidRequest
+		}
+		optionMap[k] = vStr
+	}
+
 	// Create the mount entry
 	me := &MountEntry{
 		Path:        path,
 		Type:        logicalType,
 		Description: description,
+		SealWrap:    sealWrap,
+		ReadOnly:    readOnly,
+		Options:     optionMap,
+
+		DisableLeases:  disableLeases,
+		MaxRequestSize: maxRequestSize,
 	}
 
 	// Attempt mount
@@ -349,11 +1065,88 @@ func (b *SystemBackend) handleUnmount(
 		return logical.ErrorResponse("path cannot be blank"), logical.ErrInvalidRequest
 	}
 
-	// Attempt unmount
-	if err := b.Core.unmount(suffix); err != nil {
+	// Attempt unmount
+	if err := b.Core.unmount(suffix); err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	return nil, nil
+}
+
+// handleMountTuneRead is used to read the lease configuration of a mount
+func (b *SystemBackend) handleMountTuneRead(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	path := data.Get("path").(string)
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+
+	b.Core.mountsLock.RLock()
+	defer b.Core.mountsLock.RUnlock()
+	entry := b.Core.mounts.Find(path)
+	if entry == nil {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"no matching mount at '%s'", path)), logical.ErrInvalidRequest
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"default_lease_ttl": int64(entry.DefaultLeaseTTL / time.Second),
+			"max_lease_ttl":     int64(entry.MaxLeaseTTL / time.Second),
+			"disable_leases":    entry.DisableLeases,
+			"max_request_size":  entry.MaxRequestSize,
+		},
+	}
+	return resp, nil
+}
+
+// handleMountTuneWrite is used to set the lease configuration of a mount
+func (b *SystemBackend) handleMountTuneWrite(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	path := data.Get("path").(string)
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+
+	b.Core.mountsLock.RLock()
+	var conf mountTuneConfig
+	entry := b.Core.mounts.Find(path)
+	if entry != nil {
+		conf = entry.tuneConfig()
+	}
+	b.Core.mountsLock.RUnlock()
+	if entry == nil {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"no matching mount at '%s'", path)), logical.ErrInvalidRequest
+	}
+
+	// Only update the values that are provided
+	if raw, ok := data.GetOk("default_lease_ttl"); ok {
+		dur, err := time.ParseDuration(raw.(string))
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"invalid default_lease_ttl: %v", err)), logical.ErrInvalidRequest
+		}
+		conf.DefaultLeaseTTL = dur
+	}
+	if raw, ok := data.GetOk("max_lease_ttl"); ok {
+		dur, err := time.ParseDuration(raw.(string))
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"invalid max_lease_ttl: %v", err)), logical.ErrInvalidRequest
+		}
+		conf.MaxLeaseTTL = dur
+	}
+	if raw, ok := data.GetOk("disable_leases"); ok {
+		conf.DisableLeases = raw.(bool)
+	}
+	if raw, ok := data.GetOk("max_request_size"); ok {
+		conf.MaxRequestSize = raw.(int)
+	}
+
+	if err := b.Core.tuneMount(path, conf); err != nil {
 		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
 	}
-
 	return nil, nil
 }
 
@@ -395,6 +1188,62 @@ func (b *SystemBackend) handleRenew(
 	return resp, err
 }
 
+// handleLeaseLookup is used to read the details of a given LeaseID
+func (b *SystemBackend) handleLeaseLookup(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	// Get all the options
+	leaseID := data.Get("lease_id").(string)
+
+	// Invoke the expiration manager directly
+	le, err := b.Core.expiration.Lookup(leaseID)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	if le == nil {
+		return nil, nil
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"id":          le.LeaseID,
+			"renewable":   le.isRenewable(),
+			"issue_time":  le.IssueTime,
+			"expire_time": le.ExpireTime,
+
+			// The lease is only revoked after the grace period
+			"effective_expire_time": b.Core.expiration.revokeTime(le),
+		},
+	}
+	return resp, nil
+}
+
+// handleLeaseList is used to list the leases under a prefix, which is
+// given in place of the LeaseID
+func (b *SystemBackend) handleLeaseList(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	prefix := data.Get("lease_id").(string)
+
+	// Invoke the expiration manager directly
+	entries, err := b.Core.expiration.List(prefix)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	keys := make([]string, 0, len(entries))
+	info := make(map[string]interface{}, len(entries))
+	for _, le := range entries {
+		keys = append(keys, le.LeaseID)
+		info[le.LeaseID] = map[string]interface{}{
+			"issue_time":  le.IssueTime,
+			"expire_time": le.ExpireTime,
+		}
+	}
+
+	resp := logical.ListResponse(keys)
+	resp.Data["key_info"] = info
+	return resp, nil
+}
+
 // handleRevoke is used to revoke a given LeaseID
 func (b *SystemBackend) handleRevoke(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -421,11 +1270,42 @@ func (b *SystemBackend) handleRevokePrefix(
 	return nil, nil
 }
 
+// handleTidyTokens handles the "tidy/tokens" endpoint to remove the
+// dangling and expired token state
+func (b *SystemBackend) handleTidyTokens(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	n, err := b.Core.tidyTokens()
+	if err != nil {
+		return nil, err
+	}
+	return tidyResponse(n), nil
+}
+
+// handleTidyLeases handles the "tidy/leases" endpoint to remove the
+// dangling and expired lease state
+func (b *SystemBackend) handleTidyLeases(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	n, err := b.Core.tidyLeases()
+	if err != nil {
+		return nil, err
+	}
+	return tidyResponse(n), nil
+}
+
+// tidyResponse is the response of the tidy endpoints
+func tidyResponse(removed int) *logical.Response {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"removed": removed,
+		},
+	}
+}
+
 // handleAuthTable handles the "auth" endpoint to provide the auth table
 func (b *SystemBackend) handleAuthTable(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	b.Core.auth.Lock()
-	defer b.Core.auth.Unlock()
+	b.Core.authLock.RLock()
+	defer b.Core.authLock.RUnlock()
 
 	resp := &logical.Response{
 		Data: make(map[string]interface{}),
@@ -434,6 +1314,7 @@ func (b *SystemBackend) handleAuthTable(
 		info := map[string]string{
 			"type":        entry.Type,
 			"description": entry.Description,
+			"token_role":  entry.TokenRole,
 		}
 		resp.Data[entry.Path] = info
 	}
@@ -459,6 +1340,7 @@ func (b *SystemBackend) handleEnableAuth(
 		Path:        path,
 		Type:        logicalType,
 		Description: description,
+		TokenRole:   data.Get("token_role").(string),
 	}
 
 	// Attempt enabling
@@ -483,15 +1365,105 @@ func (b *SystemBackend) handleDisableAuth(
 	return nil, nil
 }
 
+// handleCapabilities handles the "capabilities" endpoint to provide the
+// capabilities of a token on a path
+func (b *SystemBackend) handleCapabilities(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.capabilitiesResponse(data.Get("token").(string), data.Get("path").(string))
+}
+
+// handleCapabilitiesSelf handles the "capabilities-self" endpoint to
+// provide the capabilities of the token of the request on a path
+func (b *SystemBackend) handleCapabilitiesSelf(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.capabilitiesResponse(req.ClientToken, data.Get("path").(string))
+}
+
+// capabilitiesResponse is used to respond with the capabilities of the
+// given token on the given path
+func (b *SystemBackend) capabilitiesResponse(token, path string) (*logical.Response, error) {
+	capabilities, err := b.Core.Capabilities(token, path)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"capabilities": capabilities,
+		},
+	}, nil
+}
+
 // handlePolicyList handles the "policy" endpoint to provide the enabled policies
 func (b *SystemBackend) handlePolicyList(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	// Get all the configured policies
 	policies, err := b.Core.policy.ListPolicies()
+	if err != nil {
+		return nil, err
+	}
 
 	// Add the special "root" policy
 	policies = append(policies, "root")
-	return logical.ListResponse(policies), err
+	return logical.ListResponse(policies), nil
+}
+
+// handlePolicyTest handles the "policy/test" endpoint to check the
+// operations a token with the given policies would be permitted
+func (b *SystemBackend) handlePolicyTest(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if _, ok := req.Data["rules"]; ok {
+		return b.handlePolicySet(req, data)
+	}
+
+	var input struct {
+		Policies interface{}
+		Checks   []struct {
+			Operation string
+			Path      string
+		}
+	}
+	if err := mapstructure.WeakDecode(req.Data, &input); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"Error decoding request: %s", err)), logical.ErrInvalidRequest
+	}
+
+	// Policies may be given as a list or a comma-separated string
+	var policies []string
+	if raw, ok := input.Policies.(string); ok {
+		for _, p := range strings.Split(raw, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				policies = append(policies, p)
+			}
+		}
+	} else if err := mapstructure.WeakDecode(input.Policies, &policies); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"Error decoding policies: %s", err)), logical.ErrInvalidRequest
+	}
+	checks := make([]PolicyCheck, len(input.Checks))
+	for i, check := range input.Checks {
+		checks[i] = PolicyCheck{
+			Operation: logical.Operation(check.Operation),
+			Path:      check.Path,
+		}
+	}
+
+	allowed, err := b.Core.CheckPolicies(policies, checks)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	results := make([]map[string]interface{}, len(checks))
+	for i, check := range checks {
+		results[i] = map[string]interface{}{
+			"operation": string(check.Operation),
+			"path":      check.Path,
+			"allowed":   allowed[i],
+		}
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"results": results,
+		},
+	}, nil
 }
 
 // handlePolicyRead handles the "policy/<name>" endpoint to read a policy
@@ -551,8 +1523,8 @@ func (b *SystemBackend) handlePolicyDelete(
 // handleAuditTable handles the "audit" endpoint to provide the audit table
 func (b *SystemBackend) handleAuditTable(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	b.Core.audit.Lock()
-	defer b.Core.audit.Unlock()
+	b.Core.auditLock.RLock()
+	defer b.Core.auditLock.RUnlock()
 
 	resp := &logical.Response{
 		Data: make(map[string]interface{}),
@@ -562,6 +1534,10 @@ func (b *SystemBackend) handleAuditTable(
 			"type":        entry.Type,
 			"description": entry.Description,
 			"options":     entry.Options,
+			"best_effort": entry.BestEffort,
+		}
+		if entry.AuditFilter != nil {
+			info["filter"] = entry.AuditFilter
 		}
 		resp.Data[entry.Path] = info
 	}
@@ -576,6 +1552,7 @@ func (b *SystemBackend) handleEnableAudit(
 	backendType := data.Get("type").(string)
 	description := data.Get("description").(string)
 	options := data.Get("options").(map[string]interface{})
+	bestEffort := data.Get("best_effort").(bool)
 
 	optionMap := make(map[string]string)
 	for k, v := range options {
@@ -587,12 +1564,29 @@ func (b *SystemBackend) handleEnableAudit(
 		optionMap[k] = vStr
 	}
 
+	// Build the filter of the fields, if any
+	var filter *audit.Filter
+	omit := splitFields(data.Get("omit_fields").(string))
+	truncate := splitFields(data.Get("truncate_fields").(string))
+	if len(omit) > 0 || len(truncate) > 0 {
+		filter = &audit.Filter{
+			Omit:           omit,
+			Truncate:       truncate,
+			TruncateLength: data.Get("truncate_length").(int),
+		}
+		if err := filter.Validate(); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+	}
+
 	// Create the mount entry
 	me := &MountEntry{
 		Path:        path,
 		Type:        backendType,
 		Description: description,
 		Options:     optionMap,
+		BestEffort:  bestEffort,
+		AuditFilter: filter,
 	}
 
 	// Attempt enabling
@@ -602,6 +1596,17 @@ func (b *SystemBackend) handleEnableAudit(
 	return nil, nil
 }
 
+// splitFields is used to split a comma separated list of fields
+func splitFields(raw string) []string {
+	var fields []string
+	for _, field := range strings.Split(raw, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
 // handleDisableAudit is used to disable an audit backend
 func (b *SystemBackend) handleDisableAudit(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -648,6 +1653,20 @@ func (b *SystemBackend) handleRawWrite(
 	return nil, nil
 }
 
+// handleRawList is used to list directly from the barrier
+func (b *SystemBackend) handleRawList(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	path := data.Get("path").(string)
+	if path != "" && !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	keys, err := b.Core.barrier.List(path)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	return logical.ListResponse(keys), nil
+}
+
 // handleRawDelete is used to delete directly from the barrier
 func (b *SystemBackend) handleRawDelete(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -674,6 +1693,21 @@ and a user friendly description of the purpose for the mount.
 		`,
 	},
 
+	"mounts-tree": {
+		"List the mounted backends beneath a prefix.",
+		`
+List the mount path and type of every backend mounted beneath a prefix,
+including the credential backends beneath "auth/". The mounts are returned
+as a list sorted by path, and as a tree keyed by path segment for rendering
+the mount hierarchy. Mounts being unmounted or remounted are omitted.
+		`,
+	},
+
+	"mounts-tree_prefix": {
+		`The prefix of the mount paths to list. All mounts are listed if empty.`,
+		"",
+	},
+
 	"mount": {
 		`Mount a new backend at a new path.`,
 		`
@@ -681,6 +1715,11 @@ Mount a backend at a new path. A backend can be mounted multiple times at
 multiple paths in order to configure multiple separately configured backends.
 Example: you might have an AWS backend for the east coast, and one for the
 west coast.
+
+The final segment of the path may be a template such as "home/{{user}}",
+which binds the segment to the "user" key of the token metadata. Each token
+then has its own storage at "home/<user>/", and is denied access to the
+storage of others. Tokens without the key are denied access.
 		`,
 	},
 
@@ -699,10 +1738,62 @@ west coast.
 		"",
 	},
 
+	"mount_options": {
+		`Configuration options for the backend, specific to its type.`,
+		"",
+	},
+
+	"mount_read_only": {
+		`Whether the mount rejects writes and deletes, regardless of policy.`,
+		"",
+	},
+
+	"mount_disable_leases": {
+		`Whether secrets of the mount are returned without a lease. Such
+secrets cannot be renewed or revoked, so this is only suitable for static
+secrets.`,
+		"",
+	},
+
+	"mount_max_request_size": {
+		`The maximum size in bytes of the data of requests to the mount,
+overriding that of the server. Zero uses the limit of the server.`,
+		"",
+	},
+
+	"mount_seal_wrap": {
+		`Whether values are also wrapped by the seal wrapper. Requires the
+seal wrapper to remain available for as long as the mount exists.`,
+		"",
+	},
+
+	"mount_tune": {
+		"Tune the lease configuration of a mount.",
+		`
+Reads or sets the default and maximum lease durations of the mount at
+the given path. These override the system-wide lease durations for the
+secrets of the mount, and a value of "0" falls back to the system-wide
+value. Leases can also be disabled for the mount entirely. Any value may
+be omitted to leave it unchanged.
+		`,
+	},
+
+	"tune_default_lease_ttl": {
+		`The default lease duration of the mount, such as "1h".`,
+		"",
+	},
+
+	"tune_max_lease_ttl": {
+		`The maximum lease duration of the mount, such as "720h".`,
+		"",
+	},
+
 	"remount": {
 		"Move the mount point of an already-mounted backend.",
 		`
-Change the mount point of an already-mounted backend.
+Change the mount point of an already-mounted backend. The data of the
+backend is kept, and existing leases keep their lease IDs but are renewed
+and revoked through the new mount point.
 		`,
 	},
 
@@ -726,6 +1817,23 @@ lease and to prevent an automatic revocation.
 		`,
 	},
 
+	"lease-lookup": {
+		"Read the details of a lease",
+		`
+Returns the issue and expiration time of a lease, as well as whether
+the backend that issued it allows the lease to be renewed. Clients
+can use this to decide whether to attempt a renewal at all.
+
+If a revocation grace period is configured, expired leases are only
+revoked once the grace period passes. The effective expiration time
+includes this grace period.
+
+Listing this path, optionally followed by a prefix such as "secret/",
+returns the IDs of the leases under the prefix along with their issue
+and expiration times.
+		`,
+	},
+
 	"lease_id": {
 		"The lease identifier to renew. This is included with a lease.",
 		"",
@@ -754,7 +1862,8 @@ an example, "prod/aws/" might be the AWS logical backend, and due to
 a change in the "ops" policy, we may want to invalidate all the secrets
 generated. We can do a revoke prefix at "prod/aws/ops" to revoke all
 the ops secrets. This does a prefix match on the Lease IDs and revokes
-all matching leases.
+all matching leases. If some leases fail to be revoked, the remaining
+leases are still revoked and the failures are returned.
 		`,
 	},
 
@@ -795,6 +1904,38 @@ Example: you might have an OAuth backend for GitHub, and one for Google Apps.
 		"",
 	},
 
+	"auth_token_role": {
+		`Name of the token role constraining the policies and TTL of the tokens of logins.`,
+		"",
+	},
+
+	"capabilities": {
+		`Fetch the capabilities of a token on a path.`,
+		`
+Returns the capabilities that the given token has on the given path,
+which are empty if the token is permitted nothing on the path.
+		`,
+	},
+
+	"capabilities-self": {
+		`Fetch the capabilities of the token of the request on a path.`,
+		`
+Returns the capabilities that the token used to make the request has on
+the given path, which are empty if the token is permitted nothing on the
+path.
+		`,
+	},
+
+	"capabilities_token": {
+		`The token to fetch the capabilities of.`,
+		"",
+	},
+
+	"capabilities_path": {
+		`The path to fetch the capabilities on.`,
+		"",
+	},
+
 	"policy-list": {
 		`List the configured access control policies.`,
 		`
@@ -811,6 +1952,36 @@ or delete a policy.
 		`,
 	},
 
+	"policy-test": {
+		`Check the operations a token with the given policies would be permitted.`,
+		`
+Given a list of policies and a list of checks, each an operation and a path,
+returns whether a token with the policies would be permitted each operation
+without creating a token. The ACL is checked exactly as for a request. The
+policies must exist. A write with rules sets the policy named "test" instead.
+		`,
+	},
+
+	"raw": {
+		`Read, write, delete or list the decrypted entries of the barrier.`,
+		`
+This bypasses the backends and the structure of the stored entries, and is
+meant as a last resort for inspecting and repairing corrupted entries. A
+careless write can leave the Vault unusable. Only available if enabled in the
+core configuration.
+		`,
+	},
+
+	"raw_path": {
+		`The path of the entry in the barrier. Example: "core/mounts"`,
+		"",
+	},
+
+	"raw_value": {
+		`The value to write to the entry.`,
+		"",
+	},
+
 	"policy-name": {
 		`The name of the policy. Example: "ops"`,
 		"",
@@ -849,10 +2020,188 @@ a user friendly description of the audit backend, and it's configuration options
 		"",
 	},
 
+	"audit_omit_fields": {
+		`Comma separated list of request and response data fields whose
+values are replaced with a marker before they are audited.`,
+		"",
+	},
+
+	"audit_truncate_fields": {
+		`Comma separated list of request and response data fields whose
+values are truncated to truncate_length before they are audited.`,
+		"",
+	},
+
+	"audit_truncate_length": {
+		`Length that the values of truncate_fields are truncated to.`,
+		"",
+	},
+
+	"audit_best_effort": {
+		`Whether failures of the audit backend are only logged. Requests fail
+only if every backend that is not best effort fails to log them.`,
+		"",
+	},
+
 	"audit": {
 		`Enable or disable audit backends.`,
 		`
 Enable a new audit backend or disable an existing backend.
 		`,
 	},
+
+	"mount-counters": {
+		`Request counts for each mount point.`,
+		`
+Returns the number of requests routed to each mount point since this
+Vault was last unsealed. The counters are kept in memory only, so they
+are reset whenever the Vault is sealed and are specific to this node
+rather than the whole cluster.
+		`,
+	},
+
+	"cache-stats": {
+		`Statistics of the physical cache.`,
+		`
+Returns the number of entries in the cache of the physical backend and its
+capacity, along with the number of reads served from the cache (hits), the
+reads passed to the backend (misses), the entries evicted to make room for
+others, and the hit ratio. The counts are since this node started and are
+specific to it. If many entries are evicted and the hit ratio is low, the
+cache size should be increased. "enabled" is false if the cache is disabled.
+		`,
+	},
+
+	"mount-table-detail": {
+		`Read every field of the mount table.`,
+		`
+Returns every field of each entry of the mount table, keyed by mount
+point, including the UUID of its storage, its options and its tuning.
+This is intended for tooling that reconciles the mounts against a
+desired state, and requires a root token.
+		`,
+	},
+
+	"auth-table-detail": {
+		`Read every field of the credential backend table.`,
+		`
+Returns every field of each entry of the credential backend table, keyed
+by mount point, including the UUID of its storage, its options and its
+tuning. This is intended for tooling that reconciles the credential
+backends against a desired state, and requires a root token.
+		`,
+	},
+
+	"mounts-by-type": {
+		`Find all the mounts of a backend type.`,
+		`
+Returns every mount of the backend type given by the "type" parameter,
+grouped by the "mounts", "auth" and "audit" tables. This is useful for
+operations that apply to all the mounts of a type, such as rotating
+the CA of every PKI mount.
+		`,
+	},
+
+	"rewrap": {
+		`Read the progress of rewrapping entries after a key rotation.`,
+		`
+After the encryption key is rotated, every entry of the barrier that is
+encrypted with a previous key is re-encrypted with the new key in the
+background. Returns the term of the key entries are rewrapped with, whether
+a pass is running, and the number of entries checked, rewrapped and
+remaining. An interrupted pass is resumed when a Vault next becomes active.
+		`,
+	},
+
+	"tidy-tokens": {
+		`Remove the token state left behind by failed revocations.`,
+		`
+Tokens are normally removed along with their indexes when they are revoked,
+but a revocation that fails or is interrupted can leave entries behind. This
+revokes the tokens whose TTL has elapsed, and deletes the index entries of
+tokens that no longer exist. Returns the number of entries removed. This also
+runs periodically in the background on the active node.
+		`,
+	},
+
+	"tidy-leases": {
+		`Remove the lease state left behind by failed revocations.`,
+		`
+Leases are normally revoked when they expire or when their token is revoked,
+but a revocation that fails or is interrupted can leave them behind. This
+revokes the leases whose token no longer exists or that expired more than an
+hour ago, and deletes the index entries of leases that no longer exist.
+Returns the number of entries removed. This also runs periodically in the
+background on the active node.
+		`,
+	},
+
+	"config-state": {
+		`Read the system-wide configuration.`,
+		`
+Returns the system-wide default and maximum lease durations, in seconds.
+The default lease is applied to secrets and tokens that do not specify
+one, and no lease may be longer than the maximum.
+		`,
+	},
+
+	"config-ttl": {
+		`Configure the system-wide lease durations.`,
+		`
+Sets the system-wide default and maximum lease durations. Either value
+may be omitted to leave it unchanged, but the default may not be larger
+than the maximum. The change is persisted and applies to every lease
+created afterwards, existing leases keep their current duration.
+		`,
+	},
+
+	"default_lease_ttl": {
+		`The default lease duration, such as "1h".`,
+		"",
+	},
+
+	"max_lease_ttl": {
+		`The maximum lease duration, such as "720h".`,
+		"",
+	},
+
+	"mounts_type": {
+		`The backend type to search for, such as "pki".`,
+		"",
+	},
+
+	"config-rate-limit": {
+		`Configure the request rate limits.`,
+		`
+Reads or sets the rate limits applied to requests before they are
+routed. Each limit is a number of requests per second, with bursts of up
+to the given size, which defaults to the rate rounded up. The token limit
+applies to the requests made with each client token and the global limit
+to all requests. A rate of zero disables the limit.
+
+Any value may be omitted to leave it unchanged. The change is persisted
+and overrides the limits in the configuration of the server. Requests
+over a limit are rejected with a 429 status code.
+		`,
+	},
+
+	"token_rate": {
+		`Requests per second allowed for each client token, or 0 for no limit.`,
+		"",
+	},
+
+	"token_burst": {
+		`Requests each client token may burst to. Defaults to the rate.`,
+		"",
+	},
+
+	"global_rate": {
+		`Requests per second allowed across all requests, or 0 for no limit.`,
+		"",
+	},
+
+	"global_burst": {
+		`Requests all clients may burst to. Defaults to the rate.`,
+		"",
+	},
 }
@@ -3,9 +3,11 @@ package vault
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/vault/audit"
 	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/physical"
 )
 
 func TestSystemBackend_RootPaths(t *testing.T) {
@@ -19,7 +21,17 @@ func TestSystemBackend_RootPaths(t *testing.T) {
 		"audit",
 		"audit/*",
 		"seal",
+		"export/*",
+		"revoke-root",
+		"step-down",
+		"rotate",
+		"verify",
+		"tidy/*",
+		"config/ttl",
+		"config/rate-limit",
 		"raw/*",
+		"capabilities",
+		"internal/tables/*",
 	}
 
 	b := testSystemBackend(t)
@@ -38,13 +50,20 @@ func TestSystemBackend_mounts(t *testing.T) {
 	}
 
 	exp := map[string]interface{}{
-		"secret/": map[string]string{
+		"secret/": map[string]interface{}{
 			"type":        "generic",
 			"description": "generic secret storage",
+			"read_only":   false,
 		},
-		"sys/": map[string]string{
+		"cubbyhole/": map[string]interface{}{
+			"type":        "cubbyhole",
+			"description": "per-token private secret storage",
+			"read_only":   false,
+		},
+		"sys/": map[string]interface{}{
 			"type":        "system",
 			"description": "system endpoints used for control, policy and debugging",
+			"read_only":   false,
 		},
 	}
 	if !reflect.DeepEqual(resp.Data, exp) {
@@ -52,6 +71,224 @@ func TestSystemBackend_mounts(t *testing.T) {
 	}
 }
 
+func TestSystemBackend_mountTune(t *testing.T) {
+	b := testSystemBackend(t)
+
+	req := logical.TestRequest(t, logical.WriteOperation, "mounts/secret/tune")
+	req.Data["default_lease_ttl"] = "1h"
+	req.Data["max_lease_ttl"] = "2h"
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+
+	req = logical.TestRequest(t, logical.ReadOperation, "mounts/secret/tune")
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	exp := map[string]interface{}{
+		"default_lease_ttl": int64(3600),
+		"max_lease_ttl":     int64(7200),
+		"disable_leases":    false,
+		"max_request_size":  0,
+	}
+	if !reflect.DeepEqual(resp.Data, exp) {
+		t.Fatalf("got: %#v expect: %#v", resp.Data, exp)
+	}
+
+	// The default cannot exceed the max
+	req = logical.TestRequest(t, logical.WriteOperation, "mounts/secret/tune")
+	req.Data["default_lease_ttl"] = "3h"
+	resp, err = b.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+
+	// Leases can be disabled and the request size limited without
+	// changing the durations
+	req = logical.TestRequest(t, logical.WriteOperation, "mounts/secret/tune")
+	req.Data["disable_leases"] = true
+	req.Data["max_request_size"] = 1024
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	req = logical.TestRequest(t, logical.ReadOperation, "mounts/secret/tune")
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	exp["disable_leases"] = true
+	exp["max_request_size"] = 1024
+	if !reflect.DeepEqual(resp.Data, exp) {
+		t.Fatalf("got: %#v expect: %#v", resp.Data, exp)
+	}
+
+	// Unknown mounts are rejected
+	req = logical.TestRequest(t, logical.ReadOperation, "mounts/nope/tune")
+	resp, err = b.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+}
+
+func TestSystemBackend_mountCounters(t *testing.T) {
+	c, key, root := TestCoreUnsealed(t)
+
+	for i := 0; i < 2; i++ {
+		req := logical.TestRequest(t, logical.WriteOperation, "secret/foo")
+		req.Data["value"] = "bar"
+		req.ClientToken = root
+		if _, err := c.HandleRequest(req); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	req := logical.TestRequest(t, logical.ReadOperation, "sys/internal/counters/mounts")
+	req.ClientToken = root
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	exp := map[string]interface{}{
+		"secret/": uint64(2),
+		"sys/":    uint64(1),
+	}
+	if !reflect.DeepEqual(resp.Data, exp) {
+		t.Fatalf("got: %#v expect: %#v", resp.Data, exp)
+	}
+
+	// Counters are reset on reseal
+	if err := c.Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if unseal, err := c.Unseal(key); err != nil || !unseal {
+		t.Fatalf("err: %v", err)
+	}
+	resp, err = c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	exp = map[string]interface{}{
+		"sys/": uint64(1),
+	}
+	if !reflect.DeepEqual(resp.Data, exp) {
+		t.Fatalf("got: %#v expect: %#v", resp.Data, exp)
+	}
+}
+
+func TestSystemBackend_mountsByType(t *testing.T) {
+	b := testSystemBackend(t)
+
+	req := logical.TestRequest(t, logical.ReadOperation, "internal/mounts")
+	resp, err := b.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v", err)
+	}
+
+	req.Data["type"] = "token"
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	exp := map[string]interface{}{
+		"auth": map[string]interface{}{
+			"token/": map[string]interface{}{
+				"type":        "token",
+				"description": "token based credentials",
+				"options":     map[string]string{},
+			},
+		},
+	}
+	if !reflect.DeepEqual(resp.Data, exp) {
+		t.Fatalf("got: %#v expect: %#v", resp.Data, exp)
+	}
+}
+
+func TestSystemBackend_configTTL(t *testing.T) {
+	c, b, _ := testCoreSystemBackend(t)
+
+	req := logical.TestRequest(t, logical.WriteOperation, "config/ttl")
+	req.Data["default_lease_ttl"] = "1h"
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %#v", err, resp)
+	}
+
+	req = logical.TestRequest(t, logical.ReadOperation, "config/state")
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	exp := map[string]interface{}{
+		"default_lease_ttl": int64(3600),
+		"max_lease_ttl":     int64(maxLeaseDuration / time.Second),
+	}
+	if !reflect.DeepEqual(resp.Data, exp) {
+		t.Fatalf("got: %#v expect: %#v", resp.Data, exp)
+	}
+
+	// The default may not exceed the max
+	req = logical.TestRequest(t, logical.WriteOperation, "config/ttl")
+	req.Data["max_lease_ttl"] = "30m"
+	resp, err = b.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v", err)
+	}
+	req.Data["max_lease_ttl"] = "bogus"
+	resp, err = b.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v", err)
+	}
+	if def, _ := c.LeaseConfig(); def != time.Hour {
+		t.Fatalf("bad: %v", def)
+	}
+}
+
+func TestSystemBackend_configRateLimit(t *testing.T) {
+	c, b, _ := testCoreSystemBackend(t)
+
+	req := logical.TestRequest(t, logical.WriteOperation, "config/rate-limit")
+	req.Data["token_rate"] = "0.5"
+	req.Data["global_burst"] = 20
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %#v", err, resp)
+	}
+
+	req = logical.TestRequest(t, logical.ReadOperation, "config/rate-limit")
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	exp := map[string]interface{}{
+		"token_rate":   0.5,
+		"token_burst":  0,
+		"global_rate":  float64(0),
+		"global_burst": 20,
+	}
+	if !reflect.DeepEqual(resp.Data, exp) {
+		t.Fatalf("got: %#v expect: %#v", resp.Data, exp)
+	}
+
+	// Invalid values are rejected and leave the limits unchanged
+	for _, data := range []map[string]interface{}{
+		{"global_rate": "bogus"},
+		{"global_rate": "-1"},
+		{"token_burst": -1},
+	} {
+		req = logical.TestRequest(t, logical.WriteOperation, "config/rate-limit")
+		req.Data = data
+		if _, err := b.HandleRequest(req); err != logical.ErrInvalidRequest {
+			t.Fatalf("err %v: %v", data, err)
+		}
+	}
+	if conf := c.RateLimitConfig(); conf.TokenRate != 0.5 || conf.GlobalBurst != 20 {
+		t.Fatalf("bad: %#v", conf)
+	}
+}
+
 func TestSystemBackend_mount(t *testing.T) {
 	b := testSystemBackend(t)
 
@@ -192,6 +429,112 @@ func TestSystemBackend_renew(t *testing.T) {
 	}
 }
 
+func TestSystemBackend_leaseLookup(t *testing.T) {
+	core, b, root := testCoreSystemBackend(t)
+
+	// Create a key with and without a renewable lease
+	req := logical.TestRequest(t, logical.WriteOperation, "secret/foo")
+	req.Data["foo"] = "bar"
+	req.ClientToken = root
+	if _, err := core.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	req = logical.TestRequest(t, logical.WriteOperation, "secret/bar")
+	req.Data["foo"] = "bar"
+	req.Data["lease"] = "1h"
+	req.ClientToken = root
+	if _, err := core.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for path, renewable := range map[string]bool{"foo": false, "bar": true} {
+		req = logical.TestRequest(t, logical.ReadOperation, "secret/"+path)
+		req.ClientToken = root
+		resp, err := core.HandleRequest(req)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		leaseID := resp.Secret.LeaseID
+
+		req2 := logical.TestRequest(t, logical.ReadOperation, "leases/lookup/"+leaseID)
+		resp2, err := b.HandleRequest(req2)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if resp2.Data["id"] != leaseID {
+			t.Fatalf("bad: %#v", resp2)
+		}
+		if resp2.Data["renewable"] != renewable {
+			t.Fatalf("bad: %s %#v", path, resp2)
+		}
+		if resp2.Data["expire_time"].(time.Time).IsZero() {
+			t.Fatalf("bad: %#v", resp2)
+		}
+		if resp2.Data["effective_expire_time"] != resp2.Data["expire_time"] {
+			t.Fatalf("bad: %#v", resp2)
+		}
+	}
+
+	// Unknown leases are not found
+	req = logical.TestRequest(t, logical.ReadOperation, "leases/lookup/foobarbaz")
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp != nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
+func TestSystemBackend_leaseList(t *testing.T) {
+	core, b, root := testCoreSystemBackend(t)
+
+	// Create leases under two paths
+	for _, path := range []string{"foo", "bar/baz"} {
+		req := logical.TestRequest(t, logical.WriteOperation, "secret/"+path)
+		req.Data["foo"] = "bar"
+		req.ClientToken = root
+		if _, err := core.HandleRequest(req); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	leases := make(map[string]string)
+	for _, path := range []string{"foo", "bar/baz"} {
+		req := logical.TestRequest(t, logical.ReadOperation, "secret/"+path)
+		req.ClientToken = root
+		resp, err := core.HandleRequest(req)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		leases[path] = resp.Secret.LeaseID
+	}
+
+	// List all the leases
+	req := logical.TestRequest(t, logical.ListOperation, "leases/lookup/")
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	keys := resp.Data["keys"].([]string)
+	if len(keys) != 2 {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// List the leases under a prefix
+	req = logical.TestRequest(t, logical.ListOperation, "leases/lookup/secret/bar")
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(resp.Data["keys"], []string{leases["bar/baz"]}) {
+		t.Fatalf("bad: %#v", resp)
+	}
+	info := resp.Data["key_info"].(map[string]interface{})[leases["bar/baz"]].(map[string]interface{})
+	if info["issue_time"].(time.Time).IsZero() || info["expire_time"].(time.Time).IsZero() {
+		t.Fatalf("bad: %#v", info)
+	}
+}
+
 func TestSystemBackend_renew_invalidID(t *testing.T) {
 	b := testSystemBackend(t)
 
@@ -328,6 +671,7 @@ func TestSystemBackend_authTable(t *testing.T) {
 		"token/": map[string]string{
 			"type":        "token",
 			"description": "token based credentials",
+			"token_role":  "",
 		},
 	}
 	if !reflect.DeepEqual(resp.Data, exp) {
@@ -335,6 +679,146 @@ func TestSystemBackend_authTable(t *testing.T) {
 	}
 }
 
+func TestSystemBackend_mountTree(t *testing.T) {
+	c, b, _ := testCoreSystemBackend(t)
+
+	for _, path := range []string{"prod/aws/", "prod/db/"} {
+		if err := c.mount(&MountEntry{Path: path, Type: "generic"}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if err := c.remount("prod/db/", "prod/pg/"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.ReadOperation, "mounts-tree")
+	req.Data["prefix"] = "prod/"
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected := map[string]interface{}{
+		"prefix": "prod/",
+		"mounts": []map[string]interface{}{
+			{"path": "prod/aws/", "type": "generic"},
+			{"path": "prod/pg/", "type": "generic"},
+		},
+		"tree": map[string]interface{}{
+			"aws/": map[string]interface{}{"path": "prod/aws/", "type": "generic"},
+			"pg/":  map[string]interface{}{"path": "prod/pg/", "type": "generic"},
+		},
+	}
+	if !reflect.DeepEqual(resp.Data, expected) {
+		t.Fatalf("got: %#v expect: %#v", resp.Data, expected)
+	}
+
+	// Nested segments and credential backends are in the full tree
+	req = logical.TestRequest(t, logical.ReadOperation, "mounts-tree")
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	tree := resp.Data["tree"].(map[string]interface{})
+	prod := tree["prod/"].(map[string]interface{})
+	if _, ok := prod["children"].(map[string]interface{})["pg/"]; !ok {
+		t.Fatalf("bad: %#v", tree)
+	}
+	auth := tree["auth/"].(map[string]interface{})
+	token := auth["children"].(map[string]interface{})["token/"].(map[string]interface{})
+	if token["type"] != "token" {
+		t.Fatalf("bad: %#v", tree)
+	}
+}
+
+func TestSystemBackend_cacheStats(t *testing.T) {
+	// The cache is disabled for the in-memory backend
+	b := testSystemBackend(t)
+	req := logical.TestRequest(t, logical.ReadOperation, "internal/cache-stats")
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Data["enabled"] != false {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	c, err := NewCore(&CoreConfig{
+		Physical:     physical.NewCache(physical.NewInmem(), 0),
+		DisableMlock: true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	key, _ := TestCoreInit(t, c)
+	if _, err := c.Unseal(TestKeyCopy(key)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	b = NewSystemBackend(c)
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Data["enabled"] != true || resp.Data["capacity"] != physical.DefaultCacheSize {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	if resp.Data["size"].(int) == 0 || resp.Data["misses"].(uint64) == 0 {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	if ratio := resp.Data["hit_ratio"].(float64); ratio < 0 || ratio > 1 {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+}
+
+func TestSystemBackend_tableDetail(t *testing.T) {
+	c, b, _ := testCoreSystemBackend(t)
+	conf := c.mounts.Find("secret/").tuneConfig()
+	conf.DefaultLeaseTTL = time.Hour
+	if err := c.tuneMount("secret/", conf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.ReadOperation, "internal/tables/mounts")
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(resp.Data) != len(c.mounts.Entries) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	exp := map[string]interface{}{
+		"type":              "generic",
+		"description":       "generic secret storage",
+		"uuid":              c.mounts.Find("secret/").UUID,
+		"options":           map[string]string{},
+		"tainted":           false,
+		"seal_wrap":         false,
+		"read_only":         false,
+		"best_effort":       false,
+		"audit_filter":      map[string]interface{}(nil),
+		"template":          "",
+		"token_role":        "",
+		"default_lease_ttl": int64(3600),
+		"max_lease_ttl":     int64(0),
+		"disable_leases":    false,
+		"max_request_size":  0,
+		"storage_version":   0,
+	}
+	if !reflect.DeepEqual(resp.Data["secret/"], exp) {
+		t.Fatalf("got: %#v expect: %#v", resp.Data["secret/"], exp)
+	}
+
+	req = logical.TestRequest(t, logical.ReadOperation, "internal/tables/auth")
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	info, ok := resp.Data["token/"].(map[string]interface{})
+	if !ok || info["type"] != "token" || info["uuid"] != c.auth.Find("token/").UUID {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+}
+
 func TestSystemBackend_enableAuth(t *testing.T) {
 	c, b, _ := testCoreSystemBackend(t)
 	c.credentialBackends["noop"] = func(map[string]string) (logical.Backend, error) {
@@ -401,6 +885,81 @@ func TestSystemBackend_disableAuth_invalid(t *testing.T) {
 	}
 }
 
+func TestSystemBackend_capabilities(t *testing.T) {
+	c, b, root := testCoreSystemBackend(t)
+	testCoreMakeToken(t, c, root, "child", []string{"test"})
+
+	policy, _ := Parse(`path "secret/" { capabilities = ["read", "list"] }`)
+	policy.Name = "test"
+	if err := c.policy.SetPolicy(policy); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.WriteOperation, "capabilities")
+	req.Data["token"] = "child"
+	req.Data["path"] = "secret/foo"
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	exp := map[string]interface{}{
+		"capabilities": []string{"read", "list"},
+	}
+	if !reflect.DeepEqual(resp.Data, exp) {
+		t.Fatalf("got: %#v expect: %#v", resp.Data, exp)
+	}
+
+	// Nothing permitted is not an error
+	req.Data["path"] = "sys/mounts"
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	exp = map[string]interface{}{
+		"capabilities": []string{},
+	}
+	if !reflect.DeepEqual(resp.Data, exp) {
+		t.Fatalf("got: %#v expect: %#v", resp.Data, exp)
+	}
+
+	// An unknown token is
+	req.Data["token"] = "unknown"
+	resp, err = b.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestSystemBackend_capabilitiesSelf(t *testing.T) {
+	c, _, root := testCoreSystemBackend(t)
+
+	// The token of the request reaches the system backend
+	req := logical.TestRequest(t, logical.WriteOperation, "sys/capabilities-self")
+	req.ClientToken = root
+	req.Data["path"] = "secret/foo"
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	exp := map[string]interface{}{
+		"capabilities": []string{"create", "read", "update", "delete", "list", "sudo"},
+	}
+	if !reflect.DeepEqual(resp.Data, exp) {
+		t.Fatalf("got: %#v expect: %#v", resp.Data, exp)
+	}
+
+	// The capabilities of other tokens require root
+	testCoreMakeToken(t, c, root, "child", []string{"test"})
+	req = logical.TestRequest(t, logical.WriteOperation, "sys/capabilities")
+	req.ClientToken = "child"
+	req.Data["token"] = root
+	req.Data["path"] = "secret/foo"
+	resp, err = c.HandleRequest(req)
+	if err != logical.ErrPermissionDenied {
+		t.Fatalf("err: %v, resp: %v", err, resp)
+	}
+}
+
 func TestSystemBackend_policyList(t *testing.T) {
 	b := testSystemBackend(t)
 	req := logical.TestRequest(t, logical.ReadOperation, "policy")
@@ -410,7 +969,17 @@ func TestSystemBackend_policyList(t *testing.T) {
 	}
 
 	exp := map[string]interface{}{
-		"keys": []string{"root"},
+		"keys": []string{"default", "root"},
+	}
+	if !reflect.DeepEqual(resp.Data, exp) {
+		t.Fatalf("got: %#v expect: %#v", resp.Data, exp)
+	}
+
+	// The policies can also be listed with the list operation
+	req = logical.TestRequest(t, logical.ListOperation, "policy/")
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
 	}
 	if !reflect.DeepEqual(resp.Data, exp) {
 		t.Fatalf("got: %#v expect: %#v", resp.Data, exp)
@@ -455,7 +1024,7 @@ func TestSystemBackend_policyCRUD(t *testing.T) {
 	}
 
 	exp = map[string]interface{}{
-		"keys": []string{"foo", "root"},
+		"keys": []string{"default", "foo", "root"},
 	}
 	if !reflect.DeepEqual(resp.Data, exp) {
 		t.Fatalf("got: %#v expect: %#v", resp.Data, exp)
@@ -489,7 +1058,7 @@ func TestSystemBackend_policyCRUD(t *testing.T) {
 	}
 
 	exp = map[string]interface{}{
-		"keys": []string{"root"},
+		"keys": []string{"default", "root"},
 	}
 	if !reflect.DeepEqual(resp.Data, exp) {
 		t.Fatalf("got: %#v expect: %#v", resp.Data, exp)
@@ -498,7 +1067,7 @@ func TestSystemBackend_policyCRUD(t *testing.T) {
 
 func TestSystemBackend_enableAudit(t *testing.T) {
 	c, b, _ := testCoreSystemBackend(t)
-	c.auditBackends["noop"] = func(map[string]string) (audit.Backend, error) {
+	c.auditBackends["noop"] = func(*audit.BackendConfig) (audit.Backend, error) {
 		return &NoopAudit{}, nil
 	}
 
@@ -527,9 +1096,44 @@ func TestSystemBackend_enableAudit_invalid(t *testing.T) {
 	}
 }
 
+func TestSystemBackend_enableAudit_filter(t *testing.T) {
+	c, b, _ := testCoreSystemBackend(t)
+	c.auditBackends["noop"] = func(*audit.BackendConfig) (audit.Backend, error) {
+		return &NoopAudit{}, nil
+	}
+
+	// A truncate length is required to truncate
+	req := logical.TestRequest(t, logical.WriteOperation, "audit/foo")
+	req.Data["type"] = "noop"
+	req.Data["truncate_fields"] = "blob"
+	resp, err := b.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Data["error"] != "truncate length must be positive" {
+		t.Fatalf("bad: %v", resp)
+	}
+
+	req.Data["omit_fields"] = "password, key"
+	req.Data["truncate_length"] = 16
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expected := &audit.Filter{
+		Omit:           []string{"password", "key"},
+		Truncate:       []string{"blob"},
+		TruncateLength: 16,
+	}
+	entry := c.audit.Entries[0]
+	if !reflect.DeepEqual(entry.AuditFilter, expected) {
+		t.Fatalf("bad: %#v", entry.AuditFilter)
+	}
+}
+
 func TestSystemBackend_auditTable(t *testing.T) {
 	c, b, _ := testCoreSystemBackend(t)
-	c.auditBackends["noop"] = func(map[string]string) (audit.Backend, error) {
+	c.auditBackends["noop"] = func(*audit.BackendConfig) (audit.Backend, error) {
 		return &NoopAudit{}, nil
 	}
 
@@ -539,6 +1143,7 @@ func TestSystemBackend_auditTable(t *testing.T) {
 	req.Data["options"] = map[string]interface{}{
 		"foo": "bar",
 	}
+	req.Data["best_effort"] = true
 	b.HandleRequest(req)
 
 	req = logical.TestRequest(t, logical.ReadOperation, "audit")
@@ -554,6 +1159,7 @@ func TestSystemBackend_auditTable(t *testing.T) {
 			"options": map[string]string{
 				"foo": "bar",
 			},
+			"best_effort": true,
 		},
 	}
 	if !reflect.DeepEqual(resp.Data, exp) {
@@ -563,7 +1169,7 @@ func TestSystemBackend_auditTable(t *testing.T) {
 
 func TestSystemBackend_disableAudit(t *testing.T) {
 	c, b, _ := testCoreSystemBackend(t)
-	c.auditBackends["noop"] = func(map[string]string) (audit.Backend, error) {
+	c.auditBackends["noop"] = func(*audit.BackendConfig) (audit.Backend, error) {
 		return &NoopAudit{}, nil
 	}
 
@@ -600,7 +1206,7 @@ func TestSystemBackend_disableAudit_invalid(t *testing.T) {
 }
 
 func TestSystemBackend_rawRead(t *testing.T) {
-	b := testSystemBackend(t)
+	_, b := testRawSystemBackend(t)
 
 	req := logical.TestRequest(t, logical.ReadOperation, "raw/"+coreMountConfigPath)
 	resp, err := b.HandleRequest(req)
@@ -613,7 +1219,7 @@ func TestSystemBackend_rawRead(t *testing.T) {
 }
 
 func TestSystemBackend_rawWrite(t *testing.T) {
-	c, b, _ := testCoreSystemBackend(t)
+	c, b := testRawSystemBackend(t)
 
 	req := logical.TestRequest(t, logical.WriteOperation, "raw/sys/policy/test")
 	req.Data["value"] = `path "secret/" { policy = "read" }`
@@ -639,7 +1245,7 @@ func TestSystemBackend_rawWrite(t *testing.T) {
 }
 
 func TestSystemBackend_rawDelete(t *testing.T) {
-	c, b, _ := testCoreSystemBackend(t)
+	c, b := testRawSystemBackend(t)
 
 	// set the policy!
 	p := &Policy{Name: "test"}
@@ -669,12 +1275,184 @@ func TestSystemBackend_rawDelete(t *testing.T) {
 	}
 }
 
+func TestSystemBackend_rawList(t *testing.T) {
+	_, b := testRawSystemBackend(t)
+
+	req := logical.TestRequest(t, logical.ListOperation, "raw/")
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !strListContains(resp.Data["keys"].([]string), "core/") {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	req = logical.TestRequest(t, logical.ListOperation, "raw/core")
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !strListContains(resp.Data["keys"].([]string), "mounts") {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
+func TestSystemBackend_rawDisabled(t *testing.T) {
+	b := testSystemBackend(t)
+
+	req := logical.TestRequest(t, logical.ReadOperation, "raw/"+coreMountConfigPath)
+	resp, err := b.HandleRequest(req)
+	if err != logical.ErrUnsupportedPath {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+}
+
+func TestSystemBackend_tidy(t *testing.T) {
+	c, b, root := testCoreSystemBackend(t)
+
+	// Leave an index entry behind for a token that does not exist
+	path := parentPrefix + c.tokenStore.SaltID(root) + "/" + c.tokenStore.SaltID("missing")
+	if err := c.tokenStore.view.Put(&logical.StorageEntry{Key: path}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.WriteOperation, "tidy/tokens")
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Data["removed"] != 1 {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	req = logical.TestRequest(t, logical.WriteOperation, "tidy/leases")
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Data["removed"] != 0 {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
+func TestSystemBackend_policyTest(t *testing.T) {
+	c, b, _ := testCoreSystemBackend(t)
+
+	req := logical.TestRequest(t, logical.WriteOperation, "policy/foo")
+	req.Data["rules"] = `
+path "secret/*" {
+	policy = "read"
+}
+path "secret/new" {
+	capabilities = ["create"]
+}
+path "sys/policy" {
+	policy = "read"
+}
+`
+	if resp, err := b.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+
+	// Something exists at the path that only allows creation
+	existing := &logical.Request{
+		Operation: logical.WriteOperation,
+		Path:      "secret/new",
+		Data:      map[string]interface{}{"foo": "bar"},
+	}
+	if _, err := c.router.Route(existing); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req = logical.TestRequest(t, logical.WriteOperation, "policy/test")
+	req.Data["policies"] = "foo, default"
+	req.Data["checks"] = []interface{}{
+		map[string]interface{}{"operation": "read", "path": "secret/foo"},
+		map[string]interface{}{"operation": "write", "path": "secret/foo"},
+		map[string]interface{}{"operation": "write", "path": "secret/new"},
+		map[string]interface{}{"operation": "list", "path": "sys/policy"},
+		map[string]interface{}{"operation": "read", "path": "sys/policy"},
+	}
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	var allowed []bool
+	for _, result := range resp.Data["results"].([]map[string]interface{}) {
+		allowed = append(allowed, result["allowed"].(bool))
+	}
+	// The root protected path requires sudo
+	exp := []bool{true, false, false, false, false}
+	if !reflect.DeepEqual(allowed, exp) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// Unknown policies and operations are rejected
+	req.Data["policies"] = []string{"foo", "bar"}
+	if resp, err := b.HandleRequest(req); err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	req.Data["policies"] = []string{"foo"}
+	req.Data["checks"] = []interface{}{
+		map[string]interface{}{"operation": "revoke", "path": "secret/foo"},
+	}
+	if resp, err := b.HandleRequest(req); err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+
+	// The root policy is permitted everything
+	req.Data["policies"] = []string{"root"}
+	req.Data["checks"] = []interface{}{
+		map[string]interface{}{"operation": "read", "path": "sys/policy"},
+	}
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if !resp.Data["results"].([]map[string]interface{})[0]["allowed"].(bool) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+}
+
 func testSystemBackend(t *testing.T) logical.Backend {
 	c, _, _ := TestCoreUnsealed(t)
 	return NewSystemBackend(c)
 }
 
+func testRawSystemBackend(t *testing.T) (*Core, logical.Backend) {
+	c, _, _ := TestCoreUnsealed(t)
+	c.enableRaw = true
+	return c, NewSystemBackend(c)
+}
+
 func testCoreSystemBackend(t *testing.T) (*Core, logical.Backend, string) {
 	c, _, root := TestCoreUnsealed(t)
 	return c, NewSystemBackend(c), root
 }
+
+func TestSystemBackend_rewrap(t *testing.T) {
+	c, b, root := testCoreSystemBackend(t)
+	if _, err := c.Rotate(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Wait for the pass started by the rotation to complete
+	var resp *logical.Response
+	for i := 0; i < 100; i++ {
+		var err error
+		req := logical.TestRequest(t, logical.ReadOperation, "rewrap")
+		resp, err = b.HandleRequest(req)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if resp.Data["term"] == uint32(2) && resp.Data["running"] == false {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if resp.Data["term"] != uint32(2) || resp.Data["running"] != false ||
+		resp.Data["remaining"] != 0 || resp.Data["rewrapped"].(int) == 0 {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+}
@@ -0,0 +1,113 @@
+package vault
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/physical"
+)
+
+// MasterKeySource is used to protect the master key with an external
+// KMS, so that the Vault can be unsealed automatically at startup
+// rather than waiting for the unseal keys. The master key is sealed by
+// the source when the Vault is initialized, and the result is stored
+// outside of the barrier. It is unsealed by the source to unseal the
+// Vault.
+//
+// The unseal keys are generated as usual, and can always be used to
+// unseal the Vault if the source is unavailable.
+type MasterKeySource interface {
+	// Seal is used to encrypt the master key for storage
+	Seal(masterKey []byte) ([]byte, error)
+
+	// Unseal is used to recover the master key that was sealed
+	Unseal(sealedKey []byte) ([]byte, error)
+}
+
+// AutoUnseal is used to unseal the Vault using the master key recovered
+// by the MasterKeySource. It returns whether the Vault was unsealed.
+// Nothing is done if no source is configured, or if the Vault is not
+// initialized or has no sealed master key stored, in which case it
+// must be unsealed with the unseal keys. If the source fails, such as
+// when the KMS is unreachable, the error is returned and the Vault
+// remains sealed, and can still be unsealed with the unseal keys.
+func (c *Core) AutoUnseal() (bool, error) {
+	defer metrics.MeasureSince([]string{"core", "auto_unseal"}, time.Now())
+	if c.masterKeySource == nil {
+		return false, nil
+	}
+
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+
+	// Check if already unsealed
+	if !c.sealed {
+		return true, nil
+	}
+
+	// The teardown of a seal must complete before unsealing again
+	if c.sealing {
+		return false, ErrSealing
+	}
+
+	// Read the sealed master key
+	pe, err := c.physical.Get(coreMasterKeyPath)
+	if err != nil {
+		c.logger.Error("core: failed to read sealed master key: %v", err)
+		return false, fmt.Errorf("failed to read sealed master key: %v", err)
+	}
+	if pe == nil {
+		c.logger.Info("core: no sealed master key stored, unseal keys are required")
+		return false, nil
+	}
+
+	// Recover the master key
+	masterKey, err := c.masterKeySource.Unseal(pe.Value)
+	if err != nil {
+		c.logger.Warn("core: failed to unseal master key, unseal keys are required: %v", err)
+		return false, fmt.Errorf("failed to unseal master key: %v", err)
+	}
+	defer memzero(masterKey)
+
+	if err := c.unsealMasterKey(masterKey); err != nil {
+		return false, err
+	}
+	c.logger.Info("core: vault is auto-unsealed")
+	return true, nil
+}
+
+// storeMissingMasterKey is used to store the master key sealed by the
+// MasterKeySource, if one is configured and no key is stored yet. This
+// allows a Vault initialized before the source was configured to be
+// auto-unsealed once it has been unsealed with the unseal keys. A
+// failure is only logged, since the Vault is already unsealed.
+func (c *Core) storeMissingMasterKey(masterKey []byte) {
+	if c.masterKeySource == nil {
+		return
+	}
+
+	pe, err := c.physical.Get(coreMasterKeyPath)
+	if err != nil {
+		c.logger.Warn("core: failed to read sealed master key: %v", err)
+		return
+	}
+	if pe != nil {
+		return
+	}
+
+	sealedKey, err := c.masterKeySource.Seal(masterKey)
+	if err != nil {
+		c.logger.Warn("core: failed to seal master key: %v", err)
+		return
+	}
+	pe = &physical.Entry{
+		Key:   coreMasterKeyPath,
+		Value: sealedKey,
+	}
+	if err := c.physical.Put(pe); err != nil {
+		c.logger.Warn("core: failed to store sealed master key: %v", err)
+		return
+	}
+	c.logger.Info("core: stored sealed master key for auto-unseal")
+}
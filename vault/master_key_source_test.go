@@ -0,0 +1,147 @@
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// testMasterKeySource seals the master key by inverting its bits after
+// the prefix, and fails with err if set, as if the KMS were unreachable
+type testMasterKeySource struct {
+	prefix []byte
+	err    error
+}
+
+func (s *testMasterKeySource) invert(in []byte) []byte {
+	out := make([]byte, len(in))
+	for i, b := range in {
+		out[i] = ^b
+	}
+	return out
+}
+
+func (s *testMasterKeySource) Seal(masterKey []byte) ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return append(append([]byte{}, s.prefix...), s.invert(masterKey)...), nil
+}
+
+func (s *testMasterKeySource) Unseal(sealedKey []byte) ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.invert(sealedKey[len(s.prefix):]), nil
+}
+
+func TestCore_AutoUnseal(t *testing.T) {
+	c := TestCore(t)
+	c.masterKeySource = &testMasterKeySource{}
+	key, root := TestCoreInit(t, c)
+
+	// The master key is only stored sealed. With a single share, the
+	// share is the master key.
+	pe, err := c.physical.Get(coreMasterKeyPath)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if pe == nil || bytes.Equal(pe.Value, key) {
+		t.Fatalf("bad: %#v", pe)
+	}
+
+	unsealed, err := c.AutoUnseal()
+	if err != nil || !unsealed {
+		t.Fatalf("bad: %v %v", unsealed, err)
+	}
+	if sealed, _ := c.Sealed(); sealed {
+		t.Fatalf("should be unsealed")
+	}
+
+	// Auto-unseal again after sealing
+	if err := c.Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	unsealed, err = c.AutoUnseal()
+	if err != nil || !unsealed {
+		t.Fatalf("bad: %v %v", unsealed, err)
+	}
+}
+
+func TestCore_AutoUnseal_NoSource(t *testing.T) {
+	c := TestCore(t)
+	TestCoreInit(t, c)
+
+	unsealed, err := c.AutoUnseal()
+	if err != nil || unsealed {
+		t.Fatalf("bad: %v %v", unsealed, err)
+	}
+	if sealed, _ := c.Sealed(); !sealed {
+		t.Fatalf("should be sealed")
+	}
+}
+
+func TestCore_AutoUnseal_Unreachable(t *testing.T) {
+	c := TestCore(t)
+	source := &testMasterKeySource{}
+	c.masterKeySource = source
+	key, _ := TestCoreInit(t, c)
+
+	// The Vault remains sealed if the KMS is unreachable
+	source.err = fmt.Errorf("unreachable")
+	unsealed, err := c.AutoUnseal()
+	if err == nil || unsealed {
+		t.Fatalf("bad: %v %v", unsealed, err)
+	}
+	if sealed, _ := c.Sealed(); !sealed {
+		t.Fatalf("should be sealed")
+	}
+
+	// The unseal keys can still be used
+	unsealed, err = c.Unseal(TestKeyCopy(key))
+	if err != nil || !unsealed {
+		t.Fatalf("bad: %v %v", unsealed, err)
+	}
+}
+
+func TestCore_AutoUnseal_Initialize_Unreachable(t *testing.T) {
+	c := TestCore(t)
+	c.masterKeySource = &testMasterKeySource{err: fmt.Errorf("unreachable")}
+
+	// Nothing is initialized if the master key cannot be sealed
+	if _, err := c.Initialize(&SealConfig{SecretShares: 1, SecretThreshold: 1}); err == nil {
+		t.Fatalf("expected error")
+	}
+	init, err := c.Initialized()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if init {
+		t.Fatalf("should not be initialized")
+	}
+}
+
+func TestCore_AutoUnseal_StoreMissing(t *testing.T) {
+	// Initialize without a source
+	c := TestCore(t)
+	key, root := TestCoreInit(t, c)
+
+	// Nothing is stored to auto-unseal with yet
+	c.masterKeySource = &testMasterKeySource{}
+	unsealed, err := c.AutoUnseal()
+	if err != nil || unsealed {
+		t.Fatalf("bad: %v %v", unsealed, err)
+	}
+
+	// Unsealing with the unseal keys stores the sealed master key
+	if _, err := c.Unseal(TestKeyCopy(key)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := c.Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	unsealed, err = c.AutoUnseal()
+	if err != nil || !unsealed {
+		t.Fatalf("bad: %v %v", unsealed, err)
+	}
+}
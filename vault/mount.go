@@ -6,9 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"strings"
-	"sync"
+	"time"
 
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/helper/leveled-log"
 	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/physical"
 )
 
 const (
@@ -34,15 +38,13 @@ var (
 	protectedMounts = []string{
 		"audit/",
 		"auth/",
+		"cubbyhole/",
 		"sys/",
 	}
 )
 
 // MountTable is used to represent the internal mount table
 type MountTable struct {
-	// This lock should be held whenever modifying the Entries field.
-	sync.RWMutex
-
 	Entries []*MountEntry `json:"entries"`
 }
 
@@ -90,6 +92,17 @@ func (t *MountTable) SetTaint(path string, value bool) bool {
 	return false
 }
 
+// ByType is used to return copies of all the entries of a given type
+func (t *MountTable) ByType(backendType string) []*MountEntry {
+	var out []*MountEntry
+	for _, entry := range t.Entries {
+		if entry.Type == backendType {
+			out = append(out, entry.Clone())
+		}
+	}
+	return out
+}
+
 // Remove is used to remove a given path entry
 func (t *MountTable) Remove(path string) bool {
 	n := len(t.Entries)
@@ -103,14 +116,47 @@ func (t *MountTable) Remove(path string) bool {
 	return false
 }
 
+// splitMountTemplate is used to split a templated mount path such as
+// "home/{{username}}/" into the path of the mount and the token metadata
+// key bound to the segment beneath it. Only the final segment may be a
+// template. An empty key is returned if the path is not templated.
+func splitMountTemplate(path string) (string, string, error) {
+	if !strings.Contains(path, "{{") && !strings.Contains(path, "}}") {
+		return path, "", nil
+	}
+
+	trimmed := strings.TrimSuffix(path, "/")
+	idx := strings.LastIndex(trimmed, "/")
+	segment := trimmed[idx+1:]
+	prefix := trimmed[:idx+1]
+	key := strings.TrimSuffix(strings.TrimPrefix(segment, "{{"), "}}")
+	if idx == -1 || key == "" || "{{"+key+"}}" != segment ||
+		strings.ContainsAny(key, "{}") || strings.ContainsAny(prefix, "{}") {
+		return "", "", fmt.Errorf("invalid mount template '%s'", path)
+	}
+	return prefix, key, nil
+}
+
 // MountEntry is used to represent a mount table entry
 type MountEntry struct {
-	Path        string            `json:"path"`              // Mount Path
-	Type        string            `json:"type"`              // Logical backend Type
-	Description string            `json:"description"`       // User-provided description
-	UUID        string            `json:"uuid"`              // Barrier view UUID
-	Options     map[string]string `json:"options"`           // Backend configuration
-	Tainted     bool              `json:"tainted,omitempty"` // Set as a Write-Ahead flag for unmount/remount
+	Path        string            `json:"path"`                   // Mount Path
+	Type        string            `json:"type"`                   // Logical backend Type
+	Description string            `json:"description"`            // User-provided description
+	UUID        string            `json:"uuid"`                   // Barrier view UUID
+	Options     map[string]string `json:"options"`                // Backend configuration
+	Tainted     bool              `json:"tainted,omitempty"`      // Set as a Write-Ahead flag for unmount/remount
+	SealWrap    bool              `json:"seal_wrap,omitempty"`    // Values are also wrapped by the SealWrapper
+	ReadOnly    bool              `json:"read_only,omitempty"`    // Writes and deletes are rejected
+	BestEffort  bool              `json:"best_effort,omitempty"`  // Audit failures never fail requests
+	AuditFilter *audit.Filter     `json:"audit_filter,omitempty"` // Fields filtered before auditing
+	Template    string            `json:"template,omitempty"`     // Token metadata key bound to the first segment
+	TokenRole   string            `json:"token_role,omitempty"`   // Token role constraining the logins of a credential backend
+
+	DefaultLeaseTTL time.Duration `json:"default_lease_ttl,omitempty"` // Overrides the system default lease if set
+	MaxLeaseTTL     time.Duration `json:"max_lease_ttl,omitempty"`     // Overrides the system max lease if set
+	DisableLeases   bool          `json:"disable_leases,omitempty"`    // Secrets are returned without registering a lease
+	MaxRequestSize  int           `json:"max_request_size,omitempty"`  // Overrides the maximum request size of the core if set
+	StorageVersion  int           `json:"storage_version,omitempty"`   // Version of the data stored by the backend
 }
 
 // Returns a deep copy of the mount entry
@@ -119,19 +165,39 @@ func (e *MountEntry) Clone() *MountEntry {
 	for k, v := range e.Options {
 		optClone[k] = v
 	}
+	var filterClone *audit.Filter
+	if e.AuditFilter != nil {
+		filterClone = &audit.Filter{
+			Omit:           append([]string(nil), e.AuditFilter.Omit...),
+			Truncate:       append([]string(nil), e.AuditFilter.Truncate...),
+			TruncateLength: e.AuditFilter.TruncateLength,
+		}
+	}
 	return &MountEntry{
 		Path:        e.Path,
 		Type:        e.Type,
 		Description: e.Description,
 		UUID:        e.UUID,
 		Options:     optClone,
+		SealWrap:    e.SealWrap,
+		ReadOnly:    e.ReadOnly,
+		BestEffort:  e.BestEffort,
+		AuditFilter: filterClone,
+		Template:    e.Template,
+		TokenRole:   e.TokenRole,
+
+		DefaultLeaseTTL: e.DefaultLeaseTTL,
+		MaxLeaseTTL:     e.MaxLeaseTTL,
+		DisableLeases:   e.DisableLeases,
+		MaxRequestSize:  e.MaxRequestSize,
+		StorageVersion:  e.StorageVersion,
 	}
 }
 
 // Mount is used to mount a new backend to the mount table.
 func (c *Core) mount(me *MountEntry) error {
-	c.mounts.Lock()
-	defer c.mounts.Unlock()
+	c.mountsLock.Lock()
+	defer c.mountsLock.Unlock()
 
 	// Ensure we end the path in a slash
 	if !strings.HasSuffix(me.Path, "/") {
@@ -145,41 +211,77 @@ func (c *Core) mount(me *MountEntry) error {
 		}
 	}
 
+	// The cubbyhole is only mounted by default, since tokens are only
+	// scoped to a single cubbyhole
+	if me.Type == "cubbyhole" {
+		return fmt.Errorf("cannot mount another cubbyhole backend")
+	}
+
+	// Bind the final segment to token metadata if it is a template
+	path, template, err := splitMountTemplate(me.Path)
+	if err != nil {
+		return err
+	}
+	if template != "" {
+		me.Path = path
+		me.Template = template
+	}
+
 	// Verify there is no conflicting mount
 	if match := c.router.MatchingMount(me.Path); match != "" {
 		return fmt.Errorf("existing mount at '%s'", match)
 	}
 
+	// Verify the mount table is not full
+	if err := c.checkMountLimit(c.mounts); err != nil {
+		return err
+	}
+
 	// Lookup the new backend
-	backend, err := c.newLogicalBackend(me.Type, nil)
+	backend, err := c.newLogicalBackend(me.Type, me.Options)
+	if err != nil {
+		return err
+	}
+
+	// Determine the storage, which may be seal wrapped
+	storage, err := c.mountStorage(me)
 	if err != nil {
+		cleanupBackend(backend)
 		return err
 	}
 
 	// Generate a new UUID and view
 	me.UUID = generateUUID()
-	view := NewBarrierView(c.barrier, backendBarrierPrefix+me.UUID+"/")
+	view := NewBarrierView(storage, backendBarrierPrefix+me.UUID+"/")
+
+	// A new mount has no data to upgrade
+	if ub, ok := backend.(logical.UpgradeBackend); ok {
+		me.StorageVersion = ub.StorageVersion()
+	}
 
 	// Update the mount table
 	newTable := c.mounts.Clone()
 	newTable.Entries = append(newTable.Entries, me)
 	if err := c.persistMounts(newTable); err != nil {
+		cleanupBackend(backend)
 		return errors.New("failed to update mount table")
 	}
 	c.mounts = newTable
 
 	// Mount the backend
-	if err := c.router.Mount(backend, me.Path, me.UUID, view); err != nil {
+	if err := c.router.MountWith(backend, me.Path, me.UUID, view, me.routeConfig()); err != nil {
+		cleanupBackend(backend)
 		return err
 	}
-	c.logger.Printf("[INFO] core: mounted '%s' type: %s", me.Path, me.Type)
+	c.router.SetMountType(me.Path, me.Type)
+	c.logger.Info("core: mounted '%s' type: %s", me.Path, me.Type)
 	return nil
 }
 
 // Unmount is used to unmount a path.
 func (c *Core) unmount(path string) error {
-	c.mounts.Lock()
-	defer c.mounts.Unlock()
+	c.mountsLock.Lock()
+	defer c.mountsLock.Unlock()
 
 	// Ensure we end the path in a slash
 	if !strings.HasSuffix(path, "/") {
@@ -227,27 +329,35 @@ func (c *Core) unmount(path string) error {
 		return err
 	}
 
-	// Clear the data in the view
-	if err := ClearView(view); err != nil {
+	// Clear the data in the view along with removing the mount table
+	// entry, so that either both are committed or neither is
+	keys, err := CollectKeys(view)
+	if err != nil {
 		return err
 	}
-
-	// Remove the mount table entry
-	if err := c.removeMountEntry(path); err != nil {
+	txns := make([]*TxnEntry, 0, len(keys))
+	for _, key := range keys {
+		txns = append(txns, &TxnEntry{
+			Operation: physical.DeleteOperation,
+			Entry:     &Entry{Key: view.expandKey(key)},
+		})
+	}
+	if err := c.removeMountEntry(path, txns...); err != nil {
 		return err
 	}
-	c.logger.Printf("[INFO] core: unmounted '%s'", path)
+	c.logger.Info("core: unmounted '%s'", path)
 	return nil
 }
 
-// removeMountEntry is used to remove an entry from the mount table
-func (c *Core) removeMountEntry(path string) error {
+// removeMountEntry is used to remove an entry from the mount table. Any
+// other operations are applied in the same transaction.
+func (c *Core) removeMountEntry(path string, txns ...*TxnEntry) error {
 	// Remove the entry from the mount table
 	newTable := c.mounts.Clone()
 	newTable.Remove(path)
 
 	// Update the mount table
-	if err := c.persistMounts(newTable); err != nil {
+	if err := c.persistMounts(newTable, txns...); err != nil {
 		return errors.New("failed to update mount table")
 	}
 	c.mounts = newTable
@@ -270,8 +380,8 @@ func (c *Core) taintMountEntry(path string) error {
 
 // Remount is used to remount a path at a new mount point.
 func (c *Core) remount(src, dst string) error {
-	c.mounts.Lock()
-	defer c.mounts.Unlock()
+	c.mountsLock.Lock()
+	defer c.mountsLock.Unlock()
 
 	// Ensure we end the path in a slash
 	if !strings.HasSuffix(src, "/") {
@@ -281,11 +391,15 @@ func (c *Core) remount(src, dst string) error {
 		dst += "/"
 	}
 
-	// Prevent protected paths from being remounted
+	// Prevent protected paths from being remounted, or being the
+	// destination of a remount
 	for _, p := range protectedMounts {
 		if strings.HasPrefix(src, p) {
 			return fmt.Errorf("cannot remount '%s'", src)
 		}
+		if strings.HasPrefix(dst, p) {
+			return fmt.Errorf("cannot remount to '%s'", dst)
+		}
 	}
 
 	// Verify exact match of the route
@@ -294,10 +408,16 @@ func (c *Core) remount(src, dst string) error {
 		return fmt.Errorf("no matching mount at '%s'", src)
 	}
 
-	// Verify there is no conflicting mount
+	// Verify there is no conflicting mount, either containing the
+	// destination or beneath it
 	if match := c.router.MatchingMount(dst); match != "" {
 		return fmt.Errorf("existing mount at '%s'", match)
 	}
+	for _, ent := range c.mounts.Entries {
+		if strings.HasPrefix(ent.Path, dst) {
+			return fmt.Errorf("existing mount at '%s'", ent.Path)
+		}
+	}
 
 	// Mark the entry as tainted
 	if err := c.taintMountEntry(src); err != nil {
@@ -314,8 +434,8 @@ func (c *Core) remount(src, dst string) error {
 		return err
 	}
 
-	// Revoke all the dynamic keys
-	if err := c.expiration.RevokePrefix(src); err != nil {
+	// Route the existing leases to the new path
+	if err := c.expiration.MovePrefix(src, dst); err != nil {
 		return err
 	}
 
@@ -345,28 +465,118 @@ func (c *Core) remount(src, dst string) error {
 		return err
 	}
 
-	c.logger.Printf("[INFO] core: remounted '%s' to '%s'", src, dst)
+	c.logger.Info("core: remounted '%s' to '%s'", src, dst)
+	return nil
+}
+
+// mountTuneConfig is the configuration of a mount that can be changed
+// after it is mounted
+type mountTuneConfig struct {
+	// DefaultLeaseTTL and MaxLeaseTTL override the system-wide lease
+	// durations if set
+	DefaultLeaseTTL time.Duration
+	MaxLeaseTTL     time.Duration
+
+	// DisableLeases returns secrets without registering a lease
+	DisableLeases bool
+
+	// MaxRequestSize overrides the maximum request size of the core if set
+	MaxRequestSize int
+}
+
+// routeConfig returns the configuration of the mount used by the router
+func (e *MountEntry) routeConfig() *RouteConfig {
+	return &RouteConfig{
+		ReadOnly: e.ReadOnly,
+		Template: e.Template,
+	}
+}
+
+// tuneConfig returns the configuration of the mount that can be tuned
+func (e *MountEntry) tuneConfig() mountTuneConfig {
+	return mountTuneConfig{
+		DefaultLeaseTTL: e.DefaultLeaseTTL,
+		MaxLeaseTTL:     e.MaxLeaseTTL,
+		DisableLeases:   e.DisableLeases,
+		MaxRequestSize:  e.MaxRequestSize,
+	}
+}
+
+// tuneMount is used to set the lease configuration and the maximum
+// request size of a mount. A zero value falls back to the system-wide
+// value.
+func (c *Core) tuneMount(path string, conf mountTuneConfig) error {
+	c.mountsLock.Lock()
+	defer c.mountsLock.Unlock()
+
+	// Ensure we end the path in a slash
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+
+	// Validate the lease durations
+	if conf.DefaultLeaseTTL < 0 || conf.MaxLeaseTTL < 0 {
+		return fmt.Errorf("lease durations cannot be negative")
+	}
+	if conf.MaxLeaseTTL > 0 && conf.DefaultLeaseTTL > conf.MaxLeaseTTL {
+		return fmt.Errorf("default lease cannot be larger than max lease")
+	}
+	if conf.MaxRequestSize < 0 {
+		return fmt.Errorf("max request size cannot be negative")
+	}
+
+	// Update the entry in the mount table
+	newTable := c.mounts.Clone()
+	entry := newTable.Find(path)
+	if entry == nil {
+		return fmt.Errorf("no matching mount at '%s'", path)
+	}
+	entry.DefaultLeaseTTL = conf.DefaultLeaseTTL
+	entry.MaxLeaseTTL = conf.MaxLeaseTTL
+	entry.DisableLeases = conf.DisableLeases
+	entry.MaxRequestSize = conf.MaxRequestSize
+
+	// Update the mount table
+	if err := c.persistMounts(newTable); err != nil {
+		return errors.New("failed to update mount table")
+	}
+	c.mounts = newTable
+
+	c.logger.Info("core: tuned '%s' to default lease %s and max lease %s "+
+		"(leases disabled: %v, max request size: %d)", path, conf.DefaultLeaseTTL,
+		conf.MaxLeaseTTL, conf.DisableLeases, conf.MaxRequestSize)
 	return nil
 }
 
 // loadMounts is invoked as part of postUnseal to load the mount table
 func (c *Core) loadMounts() error {
+	c.mountsLock.Lock()
+	defer c.mountsLock.Unlock()
+
 	// Load the existing mount table
 	raw, err := c.barrier.Get(coreMountConfigPath)
 	if err != nil {
-		c.logger.Printf("[ERR] core: failed to read mount table: %v", err)
+		c.logger.Error("core: failed to read mount table: %v", err)
 		return loadMountsFailed
 	}
 	if raw != nil {
 		c.mounts = &MountTable{}
 		if err := json.Unmarshal(raw.Value, c.mounts); err != nil {
-			c.logger.Printf("[ERR] core: failed to decode mount table: %v", err)
+			c.logger.Error("core: failed to decode mount table: %v", err)
 			return loadMountsFailed
 		}
 	}
 
-	// Done if we have restored the mount table
+	// Done if we have restored the mount table, adding the cubbyhole
+	// to tables created before it was mounted by default
 	if c.mounts != nil {
+		if c.mounts.Find(cubbyholeMountPath) != nil {
+			return nil
+		}
+		c.mounts.Entries = append(c.mounts.Entries, cubbyholeMountEntry())
+		if err := c.persistMounts(c.mounts); err != nil {
+			return loadMountsFailed
+		}
 		return nil
 	}
 
@@ -378,12 +588,14 @@ func (c *Core) loadMounts() error {
 	return nil
 }
 
-// persistMounts is used to persist the mount table after modification
-func (c *Core) persistMounts(table *MountTable) error {
+// persistMounts is used to persist the mount table after modification.
+// Any other operations are applied in the same transaction, before the
+// table, so that a backend applying it in groups writes the table last.
+func (c *Core) persistMounts(table *MountTable, txns ...*TxnEntry) error {
 	// Marshal the table
 	raw, err := json.Marshal(table)
 	if err != nil {
-		c.logger.Printf("[ERR] core: failed to encode mount table: %v", err)
+		c.logger.Error("core: failed to encode mount table: %v", err)
 		return err
 	}
 
@@ -394,19 +606,26 @@ func (c *Core) persistMounts(table *MountTable) error {
 	}
 
 	// Write to the physical backend
-	if err := c.barrier.Put(entry); err != nil {
-		c.logger.Printf("[ERR] core: failed to persist mount table: %v", err)
+	txns = append(txns, &TxnEntry{Operation: physical.PutOperation, Entry: entry})
+	if err := barrierTransaction(c.barrier, txns); err != nil {
+		c.logger.Error("core: failed to persist mount table: %v", err)
 		return err
 	}
+	emitMountCount("mounts", table)
 	return nil
 }
 
 // setupMounts is invoked after we've loaded the mount table to
 // initialize the logical backends and setup the router
 func (c *Core) setupMounts() error {
+	c.mountsLock.Lock()
+	defer c.mountsLock.Unlock()
+
 	var backend logical.Backend
 	var view *BarrierView
 	var err error
+	var upgraded bool
+	emitMountCount("mounts", c.mounts)
 	for _, entry := range c.mounts.Entries {
 		// Initialize the backend, special casing for system
 		barrierPath := backendBarrierPrefix + entry.UUID + "/"
@@ -414,45 +633,112 @@ func (c *Core) setupMounts() error {
 			barrierPath = systemBarrierPrefix
 		}
 
-		backend, err = c.newLogicalBackend(entry.Type, nil)
+		backend, err = c.newLogicalBackend(entry.Type, entry.Options)
 		if err != nil {
-			c.logger.Printf(
-				"[ERR] core: failed to create mount entry %#v: %v",
+			c.logger.Error(
+				"core: failed to create mount entry %#v: %v",
 				entry, err)
 			return loadMountsFailed
 		}
 
 		// Create a barrier view using the UUID
-		view = NewBarrierView(c.barrier, barrierPath)
+		storage, err := c.mountStorage(entry)
+		if err != nil {
+			cleanupBackend(backend)
+			c.logger.Error(
+				"core: failed to setup storage for mount entry %#v: %v",
+				entry, err)
+			return loadMountsFailed
+		}
+		view = NewBarrierView(storage, barrierPath)
 
 		if entry.Type == "system" {
 			c.systemView = view
 		}
 
-		// Mount the backend
-		err = c.router.Mount(backend, entry.Path, entry.UUID, view)
+		// Upgrade the stored data before any request is routed
+		ok, err := c.upgradeMount(entry, backend, view)
+		if err != nil {
+			cleanupBackend(backend)
+			return loadMountsFailed
+		}
+		upgraded = upgraded || ok
+
+		// Mount the backend, read-only or templated if set in the
+		// mount table
+		err = c.router.MountWith(backend, entry.Path, entry.UUID, view, entry.routeConfig())
 		if err != nil {
-			c.logger.Printf("[ERR] core: failed to mount entry %#v: %v", entry, err)
+			cleanupBackend(backend)
+			c.logger.Error("core: failed to mount entry %#v: %v", entry, err)
 			return loadMountsFailed
 		}
+		c.router.SetMountType(entry.Path, entry.Type)
 
 		// Ensure the path is tainted if set in the mount table
 		if entry.Tainted {
 			c.router.Taint(entry.Path)
 		}
 	}
+
+	// Record the storage versions of the upgraded mounts
+	if upgraded {
+		if err := c.persistMounts(c.mounts); err != nil {
+			return loadMountsFailed
+		}
+	}
 	return nil
 }
 
+// upgradeMount is used to migrate the data stored by the backend of a
+// mount entry if it is older than the version of the backend. The entry
+// is updated with the new version, and true is returned if the mount
+// table must be persisted.
+func (c *Core) upgradeMount(entry *MountEntry, backend logical.Backend, view *BarrierView) (bool, error) {
+	ub, ok := backend.(logical.UpgradeBackend)
+	if !ok || entry.Tainted {
+		return false, nil
+	}
+	version := ub.StorageVersion()
+	if entry.StorageVersion >= version {
+		return false, nil
+	}
+
+	c.logger.Info("core: upgrading mount '%s' from storage version %d to %d",
+		entry.Path, entry.StorageVersion, version)
+	if err := ub.Upgrade(view, entry.StorageVersion); err != nil {
+		c.logger.Error("core: failed to upgrade mount '%s': %v", entry.Path, err)
+		return false, err
+	}
+	entry.StorageVersion = version
+	return true, nil
+}
+
 // unloadMounts is used before we seal the vault to reset the mounts to
 // their unloaded state. This is reversed by load and setup mounts.
 func (c *Core) unloadMounts() error {
+	c.mountsLock.Lock()
 	c.mounts = nil
+	c.mountsLock.Unlock()
+	c.router.Cleanup()
 	c.router = NewRouter()
 	c.systemView = nil
 	return nil
 }
 
+// checkMountLimit is used to verify that another entry can be added to
+// the given table without exceeding the configured maximum
+func (c *Core) checkMountLimit(table *MountTable) error {
+	if c.maxMounts > 0 && len(table.Entries) >= c.maxMounts {
+		return fmt.Errorf("cannot exceed the maximum of %d mounts", c.maxMounts)
+	}
+	return nil
+}
+
+// emitMountCount is used to emit the number of entries in a table
+func emitMountCount(name string, table *MountTable) {
+	metrics.SetGauge([]string{"core", name, "num_entries"}, float32(len(table.Entries)))
+}
+
 // newLogicalBackend is used to create and configure a new logical backend by name
 func (c *Core) newLogicalBackend(t string, conf map[string]string) (logical.Backend, error) {
 	f, ok := c.logicalBackends[t]
@@ -465,7 +751,7 @@ func (c *Core) newLogicalBackend(t string, conf map[string]string) (logical.Back
 		return nil, err
 	}
 
-	b.SetLogger(c.logger)
+	b.SetLogger(leveledlog.StdLogger(c.logger))
 	return b, nil
 }
 
@@ -478,6 +764,7 @@ func defaultMountTable() *MountTable {
 		Description: "generic secret storage",
 		UUID:        generateUUID(),
 	}
+	cubbyholeMount := cubbyholeMountEntry()
 	sysMount := &MountEntry{
 		Path:        "sys/",
 		Type:        "system",
@@ -485,6 +772,156 @@ func defaultMountTable() *MountTable {
 		UUID:        generateUUID(),
 	}
 	table.Entries = append(table.Entries, genericMount)
+	table.Entries = append(table.Entries, cubbyholeMount)
 	table.Entries = append(table.Entries, sysMount)
 	return table
 }
+
+// cubbyholeMountEntry creates the entry of the cubbyhole, which is
+// always mounted
+func cubbyholeMountEntry() *MountEntry {
+	return &MountEntry{
+		Path:        cubbyholeMountPath,
+		Type:        "cubbyhole",
+		Description: "per-token private secret storage",
+		UUID:        generateUUID(),
+	}
+}
+
+// MountsByType is used to find every mount of the given backend type
+// across the logical, credential and audit tables. The results are keyed
+// by the table name, which is one of "mounts", "auth" or "audit", and
+// tables without a match are omitted. The entries are copies and may be
+// freely modified. This must only be called while the Vault is unsealed.
+func (c *Core) MountsByType(backendType string) map[string][]*MountEntry {
+	// The tables are replaced rather than modified once loaded, so only
+	// the pointers must be read under the locks
+	c.mountsLock.RLock()
+	mounts := c.mounts
+	c.mountsLock.RUnlock()
+	c.authLock.RLock()
+	auth := c.auth
+	c.authLock.RUnlock()
+	c.auditLock.RLock()
+	audit := c.audit
+	c.auditLock.RUnlock()
+
+	tables := map[string]*MountTable{
+		"mounts": mounts,
+		"auth":   auth,
+		"audit":  audit,
+	}
+	out := make(map[string][]*MountEntry)
+	for name, table := range tables {
+		if table == nil {
+			continue
+		}
+		if entries := table.ByType(backendType); len(entries) > 0 {
+			out[name] = entries
+		}
+	}
+	return out
+}
+
+// ExportMount is used to export all the decrypted key/values of a
+// single logical mount. This requires a root token, and is only
+// permitted for backends that declare themselves exportable, since
+// the data of dynamic backends is meaningless outside of Vault.
+//
+// The entries are streamed to the given callback one at a time to
+// avoid buffering the entire mount in memory. If the callback returns
+// an error the export is aborted and that error is returned.
+func (c *Core) ExportMount(token, path string, cb func(*logical.StorageEntry) error) error {
+	defer metrics.MeasureSince([]string{"core", "export_mount"}, time.Now())
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.sealed {
+		return ErrSealed
+	}
+	if c.standby {
+		return ErrStandby
+	}
+
+	// Ensure we end the path in a slash
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+
+	// Validate the token is a root token
+	exportPath := "sys/export/" + path
+	auth, err := c.checkToken(logical.ReadOperation, exportPath, token)
+	if err != nil {
+		return err
+	}
+
+	// Create an audit trail of the export
+	req := &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        exportPath,
+		ClientToken: token,
+		DisplayName: auth.DisplayName,
+	}
+	if err := c.auditBroker.LogRequest(auth, req); err != nil {
+		c.logger.Error("core: failed to audit request (%#v): %v",
+			req, err)
+		return ErrInternalError
+	}
+	c.logger.Warn("core: exporting mount '%s' (display name: %s)",
+		path, auth.DisplayName)
+
+	// Export the mount and audit the result
+	count, err := c.exportMount(path, cb)
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"keys": count,
+		},
+	}
+	if err := c.auditBroker.LogResponse(auth, req, resp, err); err != nil {
+		c.logger.Error("core: failed to audit response (request: %#v, response: %#v): %v",
+			req, resp, err)
+		return ErrInternalError
+	}
+	return err
+}
+
+// exportMount streams the entries of the mount at the given path, as
+// decoded by the backend, returning the number of entries exported.
+func (c *Core) exportMount(path string, cb func(*logical.StorageEntry) error) (int, error) {
+	c.mountsLock.RLock()
+	entry := c.mounts.Find(path)
+	c.mountsLock.RUnlock()
+	if entry == nil || entry.Tainted {
+		return 0, fmt.Errorf("no matching mount")
+	}
+
+	// Ensure the backend supports being exported
+	backend := c.router.MatchingBackend(path)
+	exp, ok := backend.(logical.ExportableBackend)
+	if !ok || !exp.Exportable() {
+		return 0, fmt.Errorf("backend at '%s' (type: %s) cannot be exported",
+			path, entry.Type)
+	}
+
+	// Collect the keys, the values are read one at a time
+	view := c.router.MatchingView(path)
+	keys, err := CollectKeys(view)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, key := range keys {
+		out, err := exp.ExportEntry(view, key)
+		if err != nil {
+			return count, fmt.Errorf("failed to read '%s': %v", key, err)
+		}
+		if out == nil {
+			continue
+		}
+		if err := cb(out); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -2,10 +2,12 @@ package vault
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/physical"
 )
 
 func TestCore_DefaultMountTable(t *testing.T) {
@@ -73,6 +75,246 @@ func TestCore_Mount(t *testing.T) {
 	}
 }
 
+func TestCore_Mount_ReadOnly(t *testing.T) {
+	c, key, root := TestCoreUnsealed(t)
+	me := &MountEntry{
+		Path:     "foo",
+		Type:     "generic",
+		ReadOnly: true,
+	}
+	if err := c.mount(me); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.WriteOperation, "foo/bar")
+	req.Data["value"] = "baz"
+	req.ClientToken = root
+	resp, err := c.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// The mount is still read-only after unsealing again
+	if err := c.Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if unseal, err := c.Unseal(TestKeyCopy(key)); err != nil || !unseal {
+		t.Fatalf("err: %v", err)
+	}
+	req.Operation = logical.DeleteOperation
+	if _, err := c.HandleRequest(req); err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Reads are still allowed
+	req = logical.TestRequest(t, logical.ReadOperation, "foo/bar")
+	req.ClientToken = root
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestCore_Mount_Options(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	me := &MountEntry{
+		Path:    "foo",
+		Type:    "generic",
+		Options: map[string]string{"versions": "1"},
+	}
+	if err := c.mount(me); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The options configure the backend, which keeps a previous version
+	for _, v := range []string{"one", "two"} {
+		req := logical.TestRequest(t, logical.WriteOperation, "foo/bar")
+		req.Data["value"] = v
+		req.ClientToken = root
+		if _, err := c.HandleRequest(req); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	req := logical.TestRequest(t, logical.ReadOperation, "foo/bar")
+	req.Data["version"] = 1
+	req.ClientToken = root
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Data["value"] != "one" {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// Invalid options fail the mount
+	me = &MountEntry{
+		Path:    "bad",
+		Type:    "generic",
+		Options: map[string]string{"versions": "nope"},
+	}
+	if err := c.mount(me); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestCore_TuneMount(t *testing.T) {
+	c, key, root := TestCoreUnsealed(t)
+	if err := c.tuneMount("secret", mountTuneConfig{
+		DefaultLeaseTTL: time.Hour,
+		MaxLeaseTTL:     2 * time.Hour,
+	}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The mount overrides the system-wide durations
+	def, max := c.MountLeaseConfig("secret/foo")
+	if def != time.Hour || max != 2*time.Hour {
+		t.Fatalf("bad: %v %v", def, max)
+	}
+	def, max = c.MountLeaseConfig("sys/foo")
+	if def != defaultLeaseDuration || max != maxLeaseDuration {
+		t.Fatalf("bad: %v %v", def, max)
+	}
+
+	// Leases of the mount are limited
+	req := logical.TestRequest(t, logical.WriteOperation, "secret/foo")
+	req.Data["foo"] = "bar"
+	req.Data["lease"] = "10h"
+	req.ClientToken = root
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	req = logical.TestRequest(t, logical.ReadOperation, "secret/foo")
+	req.ClientToken = root
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Secret.Lease != 2*time.Hour {
+		t.Fatalf("bad: %#v", resp.Secret)
+	}
+
+	// Invalid durations are rejected
+	if err := c.tuneMount("secret", mountTuneConfig{
+		DefaultLeaseTTL: 3 * time.Hour,
+		MaxLeaseTTL:     2 * time.Hour,
+	}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := c.tuneMount("nope", mountTuneConfig{}); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// The tuning is persisted
+	conf := &CoreConfig{
+		Physical:     c.physical,
+		DisableMlock: true,
+	}
+	c2, err := NewCore(conf)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := c2.Unseal(key); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	def, max = c2.MountLeaseConfig("secret/foo")
+	if def != time.Hour || max != 2*time.Hour {
+		t.Fatalf("bad: %v %v", def, max)
+	}
+}
+
+func TestCore_Mount_Limit(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+	c.maxMounts = 4
+
+	// The default table has three entries, so only one more fits
+	if err := c.mount(&MountEntry{Path: "foo", Type: "generic"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	err := c.mount(&MountEntry{Path: "bar", Type: "generic"})
+	if err == nil || !strings.Contains(err.Error(), "maximum of 4 mounts") {
+		t.Fatalf("err: %v", err)
+	}
+	if match := c.router.MatchingMount("bar/"); match != "" {
+		t.Fatalf("bad: %s", match)
+	}
+
+	// Unmounting frees up space
+	if err := c.unmount("foo"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := c.mount(&MountEntry{Path: "bar", Type: "generic"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The limit applies to each table separately
+	c.credentialBackends["noop"] = func(map[string]string) (logical.Backend, error) {
+		return &NoopBackend{}, nil
+	}
+	for _, path := range []string{"one", "two", "three"} {
+		if err := c.enableCredential(&MountEntry{Path: path, Type: "noop"}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if err := c.enableCredential(&MountEntry{Path: "four", Type: "noop"}); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestCore_Mount_Cleanup(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+	noop := &cleanupNoopBackend{}
+	c.logicalBackends["noop"] = func(map[string]string) (logical.Backend, error) {
+		return noop, nil
+	}
+
+	// A backend that fails to mount is cleaned up
+	err := c.mount(&MountEntry{Path: "foo", Type: "noop", SealWrap: true})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if noop.cleanups != 1 {
+		t.Fatalf("bad: %d", noop.cleanups)
+	}
+	if match := c.router.MatchingMount("foo/"); match != "" {
+		t.Fatalf("bad: %s", match)
+	}
+}
+
+func TestCore_Mount_CubbyholeUpgrade(t *testing.T) {
+	c, key, _ := TestCoreUnsealed(t)
+
+	// Persist a table from before the cubbyhole was mounted by default
+	table := c.mounts.Clone()
+	table.Remove(cubbyholeMountPath)
+	if err := c.persistMounts(table); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	conf := &CoreConfig{
+		Physical:     c.physical,
+		DisableMlock: true,
+	}
+	c2, err := NewCore(conf)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := c2.Unseal(key); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The cubbyhole is added to the table
+	entry := c2.mounts.Find(cubbyholeMountPath)
+	if entry == nil || entry.Type != "cubbyhole" {
+		t.Fatalf("bad: %#v", entry)
+	}
+	if match := c2.router.MatchingMount("cubbyhole/foo"); match != cubbyholeMountPath {
+		t.Fatalf("bad: %s", match)
+	}
+}
+
 func TestCore_Unmount(t *testing.T) {
 	c, key, _ := TestCoreUnsealed(t)
 	err := c.unmount("secret")
@@ -190,6 +432,257 @@ func TestCore_Unmount_Cleanup(t *testing.T) {
 	}
 }
 
+// txnRecorder records the transactions applied to a physical backend
+type txnRecorder struct {
+	physical.Backend
+	txns [][]*physical.TxnEntry
+}
+
+func (r *txnRecorder) Transaction(txns []*physical.TxnEntry) error {
+	r.txns = append(r.txns, txns)
+	return physical.Transaction(r.Backend, txns)
+}
+
+func TestCore_Unmount_Transaction(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+	view := c.router.MatchingView("secret/")
+	se := &logical.StorageEntry{Key: "foo", Value: []byte("test")}
+	if err := view.Put(se); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	barrier := c.barrier.(*AESGCMBarrier)
+	recorder := &txnRecorder{Backend: barrier.backend}
+	barrier.backend = recorder
+	if err := c.unmount("secret/"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The data of the view is deleted along with removing the entry,
+	// which is written last
+	txns := recorder.txns[len(recorder.txns)-1]
+	if len(txns) != 2 {
+		t.Fatalf("bad: %#v", txns)
+	}
+	if txns[0].Operation != physical.DeleteOperation || txns[0].Entry.Key != view.prefix+"foo" {
+		t.Fatalf("bad: %#v", txns[0])
+	}
+	if txns[1].Operation != physical.PutOperation || txns[1].Entry.Key != coreMountConfigPath {
+		t.Fatalf("bad: %#v", txns[1])
+	}
+	if out, err := view.Get("foo"); err != nil || out != nil {
+		t.Fatalf("bad: %#v %v", out, err)
+	}
+}
+
+// upgradeNoopBackend is a NoopBackend that records its upgrades
+type upgradeNoopBackend struct {
+	NoopBackend
+	Version  int
+	Upgrades []int
+}
+
+func (n *upgradeNoopBackend) StorageVersion() int {
+	return n.Version
+}
+
+func (n *upgradeNoopBackend) Upgrade(s logical.Storage, from int) error {
+	n.Upgrades = append(n.Upgrades, from)
+	entry, err := s.Get("foo")
+	if err != nil || entry == nil {
+		return err
+	}
+	entry.Value = append([]byte("v2:"), entry.Value...)
+	return s.Put(entry)
+}
+
+func TestCore_Mount_Upgrade(t *testing.T) {
+	c, key, root := TestCoreUnsealed(t)
+	c.logicalBackends["noop"] = func(map[string]string) (logical.Backend, error) {
+		return &NoopBackend{}, nil
+	}
+
+	// Mount a backend without a storage version and inject data
+	me := &MountEntry{
+		Path: "test/",
+		Type: "noop",
+	}
+	if err := c.mount(me); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	view := c.router.MatchingView("test/")
+	if err := view.Put(&logical.StorageEntry{Key: "foo", Value: []byte("bar")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The data is upgraded on unseal once the backend has a new version
+	noop := &upgradeNoopBackend{Version: 2}
+	c.logicalBackends["noop"] = func(map[string]string) (logical.Backend, error) {
+		return noop, nil
+	}
+	reseal := func() {
+		if err := c.Seal(root); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if unseal, err := c.Unseal(TestKeyCopy(key)); err != nil || !unseal {
+			t.Fatalf("bad: %v %v", unseal, err)
+		}
+	}
+	reseal()
+	if !reflect.DeepEqual(noop.Upgrades, []int{0}) {
+		t.Fatalf("bad: %#v", noop.Upgrades)
+	}
+	if v := c.mounts.Find("test/").StorageVersion; v != 2 {
+		t.Fatalf("bad: %d", v)
+	}
+	entry, err := c.router.MatchingView("test/").Get("foo")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if entry == nil || string(entry.Value) != "v2:bar" {
+		t.Fatalf("bad: %#v", entry)
+	}
+
+	// The version is persisted, so the upgrade only runs once
+	reseal()
+	if len(noop.Upgrades) != 1 {
+		t.Fatalf("bad: %#v", noop.Upgrades)
+	}
+
+	// A new mount starts at the current version
+	me = &MountEntry{
+		Path: "new/",
+		Type: "noop",
+	}
+	if err := c.mount(me); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if me.StorageVersion != 2 {
+		t.Fatalf("bad: %d", me.StorageVersion)
+	}
+}
+
+func TestSplitMountTemplate(t *testing.T) {
+	valid := map[string][2]string{
+		"foo/":             {"foo/", ""},
+		"home/{{user}}/":   {"home/", "user"},
+		"a/b/{{user_id}}/": {"a/b/", "user_id"},
+	}
+	for path, expected := range valid {
+		prefix, key, err := splitMountTemplate(path)
+		if err != nil {
+			t.Fatalf("err %s: %v", path, err)
+		}
+		if prefix != expected[0] || key != expected[1] {
+			t.Fatalf("bad %s: %s %s", path, prefix, key)
+		}
+	}
+
+	invalid := []string{
+		"{{user}}/",
+		"home/{{}}/",
+		"home/{{user}}/foo/",
+		"home/x{{user}}/",
+		"{{a}}/{{b}}/",
+	}
+	for _, path := range invalid {
+		if _, _, err := splitMountTemplate(path); err == nil {
+			t.Fatalf("expected error: %s", path)
+		}
+	}
+}
+
+func TestCore_Mount_Template(t *testing.T) {
+	c, key, root := TestCoreUnsealed(t)
+	me := &MountEntry{
+		Path: "home/{{user}}",
+		Type: "generic",
+	}
+	if err := c.mount(me); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if me.Path != "home/" || me.Template != "user" {
+		t.Fatalf("bad: %#v", me)
+	}
+
+	req := &logical.Request{
+		Operation:   logical.WriteOperation,
+		Path:        "sys/policy/home",
+		ClientToken: root,
+		Data: map[string]interface{}{
+			"rules": `path "home/" { policy = "write" }`,
+		},
+	}
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Create a token for each user, and one without the metadata
+	tokens := make(map[string]string)
+	for _, user := range []string{"alice", "bob", ""} {
+		data := map[string]interface{}{"policies": []string{"home"}}
+		if user != "" {
+			data["meta"] = map[string]string{"user": user}
+		}
+		req := &logical.Request{
+			Operation:   logical.WriteOperation,
+			Path:        "auth/token/create",
+			ClientToken: root,
+			Data:        data,
+		}
+		resp, err := c.HandleRequest(req)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		tokens[user] = resp.Auth.ClientToken
+	}
+
+	write := func(token, path string) error {
+		_, err := c.HandleRequest(&logical.Request{
+			Operation:   logical.WriteOperation,
+			Path:        path,
+			ClientToken: token,
+			Data:        map[string]interface{}{"value": path},
+		})
+		return err
+	}
+	if err := write(tokens["alice"], "home/alice/foo"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, token := range []string{tokens["bob"], tokens[""], root} {
+		if err := write(token, "home/alice/foo"); err != logical.ErrPermissionDenied {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// The data is isolated in the storage of the mount
+	view := c.router.MatchingView("home/")
+	keys, err := CollectKeys(view)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"alice/foo"}) {
+		t.Fatalf("bad: %v", keys)
+	}
+
+	// The template is kept when the mount table is loaded
+	conf := &CoreConfig{
+		Physical:     c.physical,
+		DisableMlock: true,
+	}
+	c2, err := NewCore(conf)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if unseal, err := c2.Unseal(key); err != nil || !unseal {
+		t.Fatalf("err: %v %v", unseal, err)
+	}
+	if !c2.router.TemplateAllowed("home/alice/", map[string]string{"user": "alice"}) ||
+		c2.router.TemplateAllowed("home/alice/", nil) {
+		t.Fatalf("template not restored")
+	}
+}
+
 func TestCore_Remount(t *testing.T) {
 	c, key, _ := TestCoreUnsealed(t)
 	err := c.remount("secret", "foo")
@@ -289,7 +782,13 @@ func TestCore_Remount_Cleanup(t *testing.T) {
 		t.Fatalf("bad: %#v", noop.Requests)
 	}
 
-	// Revoke should be invoked
+	// The lease is not revoked, but is revoked through the new path
+	if len(noop.Requests) != 2 {
+		t.Fatalf("bad: %#v", noop.Requests)
+	}
+	if err := c.expiration.Revoke(resp.Secret.LeaseID); err != nil {
+		t.Fatalf("err: %v", err)
+	}
 	if noop.Requests[2].Operation != logical.RevokeOperation {
 		t.Fatalf("bad: %#v", noop.Requests)
 	}
@@ -307,12 +806,118 @@ func TestCore_Remount_Cleanup(t *testing.T) {
 	}
 }
 
+func TestCore_Remount_Unmount(t *testing.T) {
+	noop := &NoopBackend{}
+	c, _, root := TestCoreUnsealed(t)
+	c.logicalBackends["noop"] = func(map[string]string) (logical.Backend, error) {
+		return noop, nil
+	}
+
+	// Mount the noop backend
+	me := &MountEntry{
+		Path: "test/",
+		Type: "noop",
+	}
+	if err := c.mount(me); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Generate leased secret
+	noop.Response = &logical.Response{
+		Secret: &logical.Secret{
+			LeaseOptions: logical.LeaseOptions{
+				Lease: time.Hour,
+			},
+		},
+	}
+	r := &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "test/foo",
+		ClientToken: root,
+	}
+	resp, err := c.HandleRequest(r)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Secret.LeaseID == "" {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// Mounting a backend at the old path does not revoke the lease
+	if err := c.remount("test/", "new/"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	me = &MountEntry{
+		Path: "test/",
+		Type: "noop",
+	}
+	if err := c.mount(me); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := c.unmount("test/"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	le, err := c.expiration.Lookup(resp.Secret.LeaseID)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if le == nil {
+		t.Fatalf("lease revoked by the wrong mount")
+	}
+
+	// Unmounting the new path revokes the lease
+	if err := c.unmount("new/"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	le, err = c.expiration.Lookup(resp.Secret.LeaseID)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if le != nil {
+		t.Fatalf("bad: %#v", le)
+	}
+	last := noop.Requests[len(noop.Requests)-1]
+	if last.Operation != logical.RevokeOperation || last.Path != "foo" {
+		t.Fatalf("bad: %#v", noop.Requests)
+	}
+}
+
 func TestCore_Remount_Protected(t *testing.T) {
 	c, _, _ := TestCoreUnsealed(t)
 	err := c.remount("sys", "foo")
 	if err.Error() != "cannot remount 'sys/'" {
 		t.Fatalf("err: %v", err)
 	}
+
+	err = c.remount("secret", "auth/foo")
+	if err.Error() != "cannot remount to 'auth/foo/'" {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestCore_Remount_Conflict(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+	me := &MountEntry{
+		Path: "foo/bar",
+		Type: "generic",
+	}
+	if err := c.mount(me); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Neither a mount containing the destination nor a mount beneath it
+	// may exist
+	for _, dst := range []string{"foo/bar/baz", "foo/bar", "foo"} {
+		err := c.remount("secret", dst)
+		if err == nil || err.Error() != "existing mount at 'foo/bar/'" {
+			t.Fatalf("bad %s: %v", dst, err)
+		}
+	}
+
+	// The source is left untouched
+	if match := c.router.MatchingMount("secret/foo"); match != "secret/" {
+		t.Fatalf("bad: %s", match)
+	}
 }
 
 func TestDefaultMountTable(t *testing.T) {
@@ -321,7 +926,7 @@ func TestDefaultMountTable(t *testing.T) {
 }
 
 func verifyDefaultTable(t *testing.T, table *MountTable) {
-	if len(table.Entries) != 2 {
+	if len(table.Entries) != 3 {
 		t.Fatalf("bad: %v", table.Entries)
 	}
 	for idx, entry := range table.Entries {
@@ -334,6 +939,13 @@ func verifyDefaultTable(t *testing.T, table *MountTable) {
 				t.Fatalf("bad: %v", entry)
 			}
 		case 1:
+			if entry.Path != "cubbyhole/" {
+				t.Fatalf("bad: %v", entry)
+			}
+			if entry.Type != "cubbyhole" {
+				t.Fatalf("bad: %v", entry)
+			}
+		case 2:
 			if entry.Path != "sys/" {
 				t.Fatalf("bad: %v", entry)
 			}
@@ -350,3 +962,143 @@ func verifyDefaultTable(t *testing.T, table *MountTable) {
 	}
 
 }
+
+func TestCore_ExportMount(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	// Write a few secrets
+	for _, key := range []string{"foo", "bar/baz"} {
+		req := &logical.Request{
+			Operation: logical.WriteOperation,
+			Path:      "secret/" + key,
+			Data: map[string]interface{}{
+				"value": key,
+			},
+			ClientToken: root,
+		}
+		if _, err := c.HandleRequest(req); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// Export the mount
+	exported := make(map[string]interface{})
+	err := c.ExportMount(root, "secret", func(e *logical.StorageEntry) error {
+		var data map[string]interface{}
+		if err := e.DecodeJSON(&data); err != nil {
+			return err
+		}
+		exported[e.Key] = data["value"]
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected := map[string]interface{}{
+		"foo":     "foo",
+		"bar/baz": "bar/baz",
+	}
+	if !reflect.DeepEqual(exported, expected) {
+		t.Fatalf("bad: %#v", exported)
+	}
+
+	// A non-root token cannot export
+	te := &TokenEntry{Path: "test", Policies: []string{"foo"}}
+	if err := c.tokenStore.Create(te); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	err = c.ExportMount(te.ID, "secret", func(*logical.StorageEntry) error {
+		return nil
+	})
+	if err != logical.ErrPermissionDenied {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestCore_ExportMount_Versioned(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	me := &MountEntry{
+		Path:    "versioned/",
+		Type:    "generic",
+		Options: map[string]string{"versions": "2"},
+	}
+	if err := c.mount(me); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, value := range []string{"one", "two"} {
+		req := &logical.Request{
+			Operation:   logical.WriteOperation,
+			Path:        "versioned/foo",
+			Data:        map[string]interface{}{"value": value},
+			ClientToken: root,
+		}
+		if _, err := c.HandleRequest(req); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// Only the current version is exported, without the history
+	exported := make(map[string]string)
+	count, err := c.exportMount("versioned/", func(e *logical.StorageEntry) error {
+		exported[e.Key] = string(e.Value)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if count != 1 || len(exported) != 1 || exported["foo"] != `{"value":"two"}`+"\n" {
+		t.Fatalf("bad: %d %#v", count, exported)
+	}
+}
+
+func TestCore_ExportMount_NotExportable(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	me := &MountEntry{
+		Path: "foo",
+		Type: "noop",
+	}
+	if err := c.mount(me); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	err := c.ExportMount(root, "foo", func(*logical.StorageEntry) error {
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("should not be exportable")
+	}
+
+	err = c.ExportMount(root, "missing", func(*logical.StorageEntry) error {
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("should fail for missing mount")
+	}
+}
+
+func TestCore_MountsByType(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+
+	me := &MountEntry{
+		Path: "other",
+		Type: "generic",
+	}
+	if err := c.mount(me); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	out := c.MountsByType("generic")
+	if len(out) != 1 || len(out["mounts"]) != 2 {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	// The results are copies
+	out["mounts"][0].Type = "modified"
+	if len(c.MountsByType("generic")["mounts"]) != 2 {
+		t.Fatalf("results should be copies")
+	}
+
+	if out := c.MountsByType("missing"); len(out) != 0 {
+		t.Fatalf("bad: %#v", out)
+	}
+}
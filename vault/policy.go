@@ -2,6 +2,7 @@ package vault
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/hashicorp/hcl"
 )
@@ -13,12 +14,59 @@ const (
 	PathPolicySudo  = "sudo"
 )
 
+const (
+	DenyCapability   = "deny"
+	CreateCapability = "create"
+	ReadCapability   = "read"
+	UpdateCapability = "update"
+	DeleteCapability = "delete"
+	ListCapability   = "list"
+	SudoCapability   = "sudo"
+)
+
+const (
+	DenyCapabilityInt uint32 = 1 << iota
+	CreateCapabilityInt
+	ReadCapabilityInt
+	UpdateCapabilityInt
+	DeleteCapabilityInt
+	ListCapabilityInt
+	SudoCapabilityInt
+)
+
+// capabilityNames are the capabilities that may be granted, in the
+// order they are reported
+var capabilityNames = []string{
+	CreateCapability,
+	ReadCapability,
+	UpdateCapability,
+	DeleteCapability,
+	ListCapability,
+	SudoCapability,
+}
+
 var (
-	pathPolicyLevel = map[string]int{
-		PathPolicyDeny:  0,
-		PathPolicyRead:  1,
-		PathPolicyWrite: 2,
-		PathPolicySudo:  3,
+	// capabilityBits maps each capability to its bit in a bitmap
+	capabilityBits = map[string]uint32{
+		DenyCapability:   DenyCapabilityInt,
+		CreateCapability: CreateCapabilityInt,
+		ReadCapability:   ReadCapabilityInt,
+		UpdateCapability: UpdateCapabilityInt,
+		DeleteCapability: DeleteCapabilityInt,
+		ListCapability:   ListCapabilityInt,
+		SudoCapability:   SudoCapabilityInt,
+	}
+
+	// pathPolicyCapabilities maps each policy of the older syntax to
+	// the nearest capabilities
+	pathPolicyCapabilities = map[string]uint32{
+		PathPolicyDeny: DenyCapabilityInt,
+		PathPolicyRead: ReadCapabilityInt | ListCapabilityInt,
+		PathPolicyWrite: CreateCapabilityInt | ReadCapabilityInt |
+			UpdateCapabilityInt | DeleteCapabilityInt | ListCapabilityInt,
+		PathPolicySudo: CreateCapabilityInt | ReadCapabilityInt |
+			UpdateCapabilityInt | DeleteCapabilityInt | ListCapabilityInt |
+			SudoCapabilityInt,
 	}
 )
 
@@ -28,12 +76,25 @@ type Policy struct {
 	Name  string        `hcl:"name"`
 	Paths []*PathPolicy `hcl:"path,expand"`
 	Raw   string
+
+	// BreakGlass are the path prefixes on which requests may override
+	// the ACL in an emergency. This is empty unless explicitly granted.
+	BreakGlass []string `hcl:"break_glass"`
 }
 
 // PathPolicy represents a policy for a path in the namespace
 type PathPolicy struct {
-	Prefix string `hcl:",key"`
-	Policy string
+	Prefix       string `hcl:",key"`
+	Policy       string
+	Capabilities []string `hcl:"capabilities"`
+
+	// CapabilitiesBitmap combines the capabilities with those implied
+	// by the policy
+	CapabilitiesBitmap uint32
+
+	// Glob is set if the path ended with a "*", which is removed
+	// from the prefix
+	Glob bool
 }
 
 // Parse is used to parse the specified ACL rules into an
@@ -48,13 +109,38 @@ func Parse(rules string) (*Policy, error) {
 
 	// Validate the path policy
 	for _, pp := range p.Paths {
-		switch pp.Policy {
-		case PathPolicyDeny:
-		case PathPolicyRead:
-		case PathPolicyWrite:
-		case PathPolicySudo:
-		default:
-			return nil, fmt.Errorf("Invalid path policy: %#v", pp)
+		// Strip the glob character from the prefix
+		pp.Glob = strings.HasSuffix(pp.Prefix, "*")
+		if pp.Glob {
+			pp.Prefix = strings.TrimSuffix(pp.Prefix, "*")
+		}
+		if strings.Contains(pp.Prefix, "*") {
+			return nil, fmt.Errorf("Invalid path policy, glob is only supported at the end: %#v", pp)
+		}
+
+		// Combine the policy and the capabilities
+		pp.CapabilitiesBitmap = 0
+		if pp.Policy != "" {
+			bits, ok := pathPolicyCapabilities[pp.Policy]
+			if !ok {
+				return nil, fmt.Errorf("Invalid path policy: %#v", pp)
+			}
+			pp.CapabilitiesBitmap |= bits
+		}
+		for _, capability := range pp.Capabilities {
+			bit, ok := capabilityBits[capability]
+			if !ok {
+				return nil, fmt.Errorf("Invalid path capability: %#v", pp)
+			}
+			pp.CapabilitiesBitmap |= bit
+		}
+		if pp.CapabilitiesBitmap == 0 {
+			return nil, fmt.Errorf("Invalid path policy, no policy or capabilities: %#v", pp)
+		}
+
+		// A deny removes every other capability
+		if pp.CapabilitiesBitmap&DenyCapabilityInt != 0 {
+			pp.CapabilitiesBitmap = DenyCapabilityInt
 		}
 	}
 	return p, nil
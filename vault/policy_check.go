@@ -0,0 +1,66 @@
+package vault
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/logical"
+)
+
+// PolicyCheck is an operation on a path that policies are checked against
+type PolicyCheck struct {
+	Operation logical.Operation
+	Path      string
+}
+
+// CheckPolicies is used to determine whether a token with the given
+// policies would be permitted each of the operations, without creating
+// a token. The ACL is checked exactly as for a request, so the results
+// are authoritative, though the break-glass override is not considered.
+// This does not take the state lock, as it is called by the system
+// backend.
+func (c *Core) CheckPolicies(policies []string, checks []PolicyCheck) ([]bool, error) {
+	defer metrics.MeasureSince([]string{"core", "check_policies"}, time.Now())
+	if len(policies) == 0 {
+		return nil, fmt.Errorf("missing policies")
+	}
+
+	// Unknown policies would be ignored, so reject them rather than
+	// silently report that they grant nothing
+	for _, name := range policies {
+		p, err := c.policy.GetPolicy(name)
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			return nil, fmt.Errorf("unknown policy '%s'", name)
+		}
+	}
+
+	// Construct the ACL the token would have
+	acl, err := c.policy.ACL(policies...)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]bool, len(checks))
+	for i, check := range checks {
+		switch check.Operation {
+		case logical.ReadOperation, logical.WriteOperation,
+			logical.DeleteOperation, logical.ListOperation:
+		default:
+			return nil, fmt.Errorf("unsupported operation '%s'", check.Operation)
+		}
+		if check.Path == "" {
+			return nil, fmt.Errorf("missing path")
+		}
+
+		allowed, err := c.aclAllowed(acl, check.Operation, check.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check '%s': %v", check.Path, err)
+		}
+		results[i] = allowed
+	}
+	return results, nil
+}
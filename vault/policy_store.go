@@ -2,6 +2,7 @@ package vault
 
 import (
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/armon/go-metrics"
@@ -16,6 +17,39 @@ const (
 
 	// policyCacheSize is the number of policies that are kept cached
 	policyCacheSize = 1024
+
+	// defaultPolicyName is the name of the policy attached to every
+	// token other than root tokens, unless opted out
+	defaultPolicyName = "default"
+
+	// defaultPolicyRules are the rules of the default policy when it is
+	// first created, which permit a token to manage itself
+	defaultPolicyRules = `
+# Allow tokens to look up their own properties
+path "auth/token/lookup-self" {
+	capabilities = ["read"]
+}
+
+# Allow tokens to renew themselves
+path "auth/token/renew-self" {
+	capabilities = ["update"]
+}
+
+# Allow tokens to revoke themselves
+path "auth/token/revoke-self" {
+	capabilities = ["update"]
+}
+
+# Allow tokens to look up their own capabilities on a path
+path "sys/capabilities-self" {
+	capabilities = ["update"]
+}
+
+# Allow tokens to use their own cubbyhole
+path "cubbyhole/*" {
+	capabilities = ["create", "read", "update", "delete", "list"]
+}
+`
 )
 
 // PolicyStore is used to provide durable storage of policy, and to
@@ -44,6 +78,23 @@ func (c *Core) setupPolicyStore() error {
 
 	// Create the policy store
 	c.policy = NewPolicyStore(view)
+
+	// Create the default policy if it does not exist, it is otherwise
+	// left as modified
+	policy, err := c.policy.GetPolicy(defaultPolicyName)
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		policy, err = Parse(defaultPolicyRules)
+		if err != nil {
+			return err
+		}
+		policy.Name = defaultPolicyName
+		if err := c.policy.SetPolicy(policy); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -127,6 +178,9 @@ func (ps *PolicyStore) DeletePolicy(name string) error {
 	if name == "root" {
 		return fmt.Errorf("cannot delete root policy")
 	}
+	if name == defaultPolicyName {
+		return fmt.Errorf("cannot delete default policy")
+	}
 	if err := ps.view.Delete(name); err != nil {
 		return fmt.Errorf("failed to delete policy: %v", err)
 	}
@@ -139,9 +193,18 @@ func (ps *PolicyStore) DeletePolicy(name string) error {
 // ACL is used to return an ACL which is built using the
 // named policies.
 func (ps *PolicyStore) ACL(names ...string) (*ACL, error) {
+	// Sort and de-duplicate the names so that the policies are always
+	// fetched and merged in the same order
+	sorted := make([]string, len(names))
+	copy(sorted, names)
+	sort.Strings(sorted)
+
 	// Fetch the policies
 	var policy []*Policy
-	for _, name := range names {
+	for i, name := range sorted {
+		if i > 0 && name == sorted[i-1] {
+			continue
+		}
 		p, err := ps.GetPolicy(name)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get policy '%s': %v", name, err)
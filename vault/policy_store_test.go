@@ -40,6 +40,45 @@ func TestPolicyStore_Root(t *testing.T) {
 	}
 }
 
+func TestPolicyStore_Default(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+
+	// The default policy is created on setup
+	p, err := c.policy.GetPolicy("default")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if p == nil || len(p.Paths) == 0 {
+		t.Fatalf("bad: %v", p)
+	}
+
+	// Delete should fail
+	err = c.policy.DeletePolicy("default")
+	if err == nil || err.Error() != "cannot delete default policy" {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Set should work, and be kept on the next setup
+	p, err = Parse(`path "secret/" { policy = "read" }`)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	p.Name = "default"
+	if err := c.policy.SetPolicy(p); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := c.setupPolicyStore(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	out, err := c.policy.GetPolicy("default")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out == nil || out.Raw != p.Raw {
+		t.Fatalf("bad: %v", out)
+	}
+}
+
 func TestPolicyStore_CRUD(t *testing.T) {
 	ps := mockPolicyStore(t)
 
@@ -128,3 +167,36 @@ func TestPolicyStore_ACL(t *testing.T) {
 	}
 	testLayeredACL(t, acl)
 }
+
+func TestPolicyStore_ACL_Order(t *testing.T) {
+	ps := mockPolicyStore(t)
+
+	policy, _ := Parse(aclPolicy)
+	if err := ps.SetPolicy(policy); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	policy, _ = Parse(aclPolicy2)
+	if err := ps.SetPolicy(policy); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expected, err := ps.ACL("dev", "ops")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	names := []string{"ops", "dev", "ops", "dev"}
+	acl, err := ps.ACL(names...)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(aclRules(acl), aclRules(expected)) {
+		t.Fatalf("bad: %v %v", aclRules(acl), aclRules(expected))
+	}
+	testLayeredACL(t, acl)
+
+	// The given names are not modified
+	if names[0] != "ops" {
+		t.Fatalf("bad: %v", names)
+	}
+}
@@ -16,13 +16,45 @@ func TestPolicy_Parse(t *testing.T) {
 	}
 
 	expect := []*PathPolicy{
-		&PathPolicy{"", "deny"},
-		&PathPolicy{"stage/", "sudo"},
-		&PathPolicy{"prod/", "read"},
+		&PathPolicy{
+			Prefix:             "",
+			Policy:             "deny",
+			CapabilitiesBitmap: DenyCapabilityInt,
+		},
+		&PathPolicy{
+			Prefix:             "stage/",
+			Policy:             "sudo",
+			CapabilitiesBitmap: pathPolicyCapabilities[PathPolicySudo],
+		},
+		&PathPolicy{
+			Prefix:             "prod/",
+			Policy:             "read",
+			CapabilitiesBitmap: ReadCapabilityInt | ListCapabilityInt,
+		},
+		&PathPolicy{
+			Prefix:             "prod/app/",
+			Policy:             "write",
+			CapabilitiesBitmap: pathPolicyCapabilities[PathPolicyWrite],
+			Glob:               true,
+		},
+		&PathPolicy{
+			Prefix:             "prod/deploy/",
+			Capabilities:       []string{"create", "list"},
+			CapabilitiesBitmap: CreateCapabilityInt | ListCapabilityInt,
+		},
+		&PathPolicy{
+			Prefix:             "prod/secrets/",
+			Policy:             "read",
+			Capabilities:       []string{"deny"},
+			CapabilitiesBitmap: DenyCapabilityInt,
+		},
 	}
 	if !reflect.DeepEqual(p.Paths, expect) {
 		t.Fatalf("bad: %#v", p)
 	}
+	if !reflect.DeepEqual(p.BreakGlass, []string{"prod/"}) {
+		t.Fatalf("bad: %#v", p.BreakGlass)
+	}
 }
 
 var rawPolicy = `
@@ -43,4 +75,43 @@ path "stage/" {
 path "prod/" {
 	policy = "read"
 }
+
+# Write privilege to everything under the production app
+path "prod/app/*" {
+	policy = "write"
+}
+
+# Allow creating new deploys, but not updating existing ones
+path "prod/deploy/" {
+	capabilities = ["create", "list"]
+}
+
+# A deny removes every other capability
+path "prod/secrets/" {
+	policy = "read"
+	capabilities = ["deny"]
+}
+
+# Allow overriding the ACL on production in an emergency
+break_glass = ["prod/"]
 `
+
+func TestPolicy_Parse_InvalidCapability(t *testing.T) {
+	rules := []string{
+		`path "prod/" { capabilities = ["write"] }`,
+		`path "prod/" { policy = "list" }`,
+		`path "prod/" { capabilities = [] }`,
+	}
+	for _, raw := range rules {
+		if _, err := Parse(raw); err == nil {
+			t.Fatalf("expected error: %s", raw)
+		}
+	}
+}
+
+func TestPolicy_Parse_InvalidGlob(t *testing.T) {
+	_, err := Parse(`path "prod/*/foo" { policy = "read" }`)
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+}
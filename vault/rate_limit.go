@@ -0,0 +1,257 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// coreRateLimitConfigPath is used to store the rate limits when they
+	// are changed at runtime, overriding the configured limits
+	coreRateLimitConfigPath = "core/rate-limit"
+
+	// rateLimitSweepInterval is how often the buckets of idle tokens
+	// are evicted
+	rateLimitSweepInterval = time.Minute
+)
+
+var (
+	// loadRateLimitConfigFailed if loading the rate limits encounters an error
+	loadRateLimitConfigFailed = errors.New("failed to setup rate limits")
+)
+
+// ErrRateLimited is returned by HandleRequest when a request exceeds the
+// rate limit of its token or the global rate limit. RetryAfter is how
+// long until the request would be allowed.
+type ErrRateLimited struct {
+	RetryAfter time.Duration
+}
+
+func (e ErrRateLimited) Error() string {
+	return "rate limit exceeded"
+}
+
+// RateLimitConfig is the configuration of the request rate limits. Each
+// limit is a token bucket that refills at the given rate per second and
+// holds up to the burst, which defaults to the rate rounded up. A rate of
+// zero disables the limit.
+type RateLimitConfig struct {
+	// TokenRate limits the requests made with each client token.
+	// Requests without a token are only subject to the global limit.
+	TokenRate  float64 `json:"token_rate"`
+	TokenBurst int     `json:"token_burst"`
+
+	// GlobalRate limits all of the requests handled by the core
+	GlobalRate  float64 `json:"global_rate"`
+	GlobalBurst int     `json:"global_burst"`
+}
+
+// Validate is used to sanity check the rate limits
+func (r *RateLimitConfig) Validate() error {
+	if r.TokenRate < 0 || math.IsNaN(r.TokenRate) || math.IsInf(r.TokenRate, 0) {
+		return fmt.Errorf("token rate must be a non-negative number")
+	}
+	if r.GlobalRate < 0 || math.IsNaN(r.GlobalRate) || math.IsInf(r.GlobalRate, 0) {
+		return fmt.Errorf("global rate must be a non-negative number")
+	}
+	if r.TokenBurst < 0 {
+		return fmt.Errorf("token burst cannot be negative")
+	}
+	if r.GlobalBurst < 0 {
+		return fmt.Errorf("global burst cannot be negative")
+	}
+	return nil
+}
+
+// rateLimitBurst returns the burst of a limit, defaulting to the rate
+// rounded up
+func rateLimitBurst(rate float64, burst int) float64 {
+	if burst > 0 {
+		return float64(burst)
+	}
+	return math.Ceil(rate)
+}
+
+// tokenBucket is the state of a single limit. The bucket is refilled
+// lazily, based on the time it was last refilled.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill is used to add the tokens accumulated since the last refill
+func (b *tokenBucket) refill(rate, burst float64, now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed.Seconds()*rate)
+	}
+	b.last = now
+}
+
+// wait returns how long until the bucket holds a whole token
+func (b *tokenBucket) wait(rate float64) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// rateLimiter is used to limit the rate of requests, both for each
+// client token and globally. The bucket of a token is evicted once it
+// has been idle long enough to refill, since it is then equivalent to a
+// new bucket, so the state does not grow as tokens churn.
+type rateLimiter struct {
+	l         sync.Mutex
+	config    RateLimitConfig
+	global    *tokenBucket
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// newRateLimiter is used to create a rate limiter with the given limits
+func newRateLimiter(conf RateLimitConfig) *rateLimiter {
+	r := &rateLimiter{}
+	r.SetConfig(conf)
+	return r
+}
+
+// Config returns the current rate limits
+func (r *rateLimiter) Config() RateLimitConfig {
+	r.l.Lock()
+	defer r.l.Unlock()
+	return r.config
+}
+
+// SetConfig is used to change the rate limits. Every bucket is reset.
+func (r *rateLimiter) SetConfig(conf RateLimitConfig) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.config = conf
+	r.global = nil
+	r.buckets = make(map[string]*tokenBucket)
+}
+
+// Allow checks if a request made with the given token is allowed at the
+// given time, consuming from its buckets if so. If not, the second
+// return value is how long until the request would be allowed.
+func (r *rateLimiter) Allow(token string, now time.Time) (bool, time.Duration) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	conf := r.config
+
+	if now.Sub(r.lastSweep) >= rateLimitSweepInterval {
+		r.sweep(now)
+	}
+
+	// Refill the buckets that apply to the request, creating them full
+	var global, bucket *tokenBucket
+	if conf.GlobalRate > 0 {
+		burst := rateLimitBurst(conf.GlobalRate, conf.GlobalBurst)
+		if r.global == nil {
+			r.global = &tokenBucket{tokens: burst, last: now}
+		}
+		global = r.global
+		global.refill(conf.GlobalRate, burst, now)
+	}
+	if conf.TokenRate > 0 && token != "" {
+		burst := rateLimitBurst(conf.TokenRate, conf.TokenBurst)
+		bucket = r.buckets[token]
+		if bucket == nil {
+			bucket = &tokenBucket{tokens: burst, last: now}
+			r.buckets[token] = bucket
+		}
+		bucket.refill(conf.TokenRate, burst, now)
+	}
+
+	// Only consume if every bucket allows the request, so that a
+	// rejected request does not count against the other limit
+	var wait time.Duration
+	if global != nil {
+		wait = global.wait(conf.GlobalRate)
+	}
+	if bucket != nil {
+		if w := bucket.wait(conf.TokenRate); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	if global != nil {
+		global.tokens--
+	}
+	if bucket != nil {
+		bucket.tokens--
+	}
+	return true, 0
+}
+
+// sweep is used to evict the buckets that have been idle long enough to
+// refill. The lock must be held.
+func (r *rateLimiter) sweep(now time.Time) {
+	r.lastSweep = now
+	if r.config.TokenRate <= 0 {
+		return
+	}
+	burst := rateLimitBurst(r.config.TokenRate, r.config.TokenBurst)
+	idle := time.Duration(burst / r.config.TokenRate * float64(time.Second))
+	for token, bucket := range r.buckets {
+		if now.Sub(bucket.last) >= idle {
+			delete(r.buckets, token)
+		}
+	}
+}
+
+// loadRateLimitConfig is invoked as part of postUnseal to load the rate
+// limits that were changed at runtime, if any. Otherwise the configured
+// limits are used.
+func (c *Core) loadRateLimitConfig() error {
+	raw, err := c.barrier.Get(coreRateLimitConfigPath)
+	if err != nil {
+		c.logger.Error("core: failed to read rate limits: %v", err)
+		return loadRateLimitConfigFailed
+	}
+
+	conf := c.rateLimitDefaults
+	if raw != nil {
+		if err := json.Unmarshal(raw.Value, &conf); err != nil {
+			c.logger.Error("core: failed to decode rate limits: %v", err)
+			return loadRateLimitConfigFailed
+		}
+	}
+	c.rateLimiter.SetConfig(conf)
+	return nil
+}
+
+// RateLimitConfig returns the current rate limits
+func (c *Core) RateLimitConfig() RateLimitConfig {
+	return c.rateLimiter.Config()
+}
+
+// SetRateLimitConfig is used to update and persist the rate limits. The
+// new limits apply immediately, and override the configured limits
+// until changed again.
+func (c *Core) SetRateLimitConfig(conf RateLimitConfig) error {
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(conf)
+	if err != nil {
+		return fmt.Errorf("failed to encode rate limits: %v", err)
+	}
+	entry := &Entry{
+		Key:   coreRateLimitConfigPath,
+		Value: raw,
+	}
+	if err := c.barrier.Put(entry); err != nil {
+		c.logger.Error("core: failed to persist rate limits: %v", err)
+		return errors.New("failed to update rate limits")
+	}
+
+	c.rateLimiter.SetConfig(conf)
+	return nil
+}
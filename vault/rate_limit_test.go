@@ -0,0 +1,145 @@
+package vault
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/physical"
+)
+
+func TestRateLimiter_Token(t *testing.T) {
+	r := newRateLimiter(RateLimitConfig{TokenRate: 2, TokenBurst: 3})
+	now := time.Now()
+
+	// The burst is allowed, then the token is throttled
+	for i := 0; i < 3; i++ {
+		if ok, _ := r.Allow("foo", now); !ok {
+			t.Fatalf("should be allowed: %d", i)
+		}
+	}
+	ok, wait := r.Allow("foo", now)
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("bad: %v %v", ok, wait)
+	}
+
+	// Other tokens and requests without a token are unaffected
+	if ok, _ := r.Allow("bar", now); !ok {
+		t.Fatalf("should be allowed")
+	}
+	for i := 0; i < 10; i++ {
+		if ok, _ := r.Allow("", now); !ok {
+			t.Fatalf("should be allowed")
+		}
+	}
+
+	// The bucket refills at the rate
+	if ok, _ := r.Allow("foo", now.Add(500*time.Millisecond)); !ok {
+		t.Fatalf("should be allowed")
+	}
+	if ok, _ := r.Allow("foo", now.Add(500*time.Millisecond)); ok {
+		t.Fatalf("should be throttled")
+	}
+}
+
+func TestRateLimiter_Global(t *testing.T) {
+	r := newRateLimiter(RateLimitConfig{TokenRate: 1, GlobalRate: 2})
+	now := time.Now()
+
+	// The burst defaults to the rate
+	if ok, _ := r.Allow("foo", now); !ok {
+		t.Fatalf("should be allowed")
+	}
+	if ok, _ := r.Allow("", now); !ok {
+		t.Fatalf("should be allowed")
+	}
+	if ok, _ := r.Allow("bar", now); ok {
+		t.Fatalf("should be throttled")
+	}
+
+	// A request rejected by its token does not use the global limit
+	later := now.Add(500 * time.Millisecond)
+	if ok, _ := r.Allow("foo", later); ok {
+		t.Fatalf("should be throttled")
+	}
+	if ok, _ := r.Allow("bar", later); !ok {
+		t.Fatalf("should be allowed")
+	}
+}
+
+func TestRateLimiter_Evict(t *testing.T) {
+	r := newRateLimiter(RateLimitConfig{TokenRate: 1, TokenBurst: 10})
+	now := time.Now()
+	r.Allow("foo", now)
+	r.Allow("bar", now.Add(rateLimitSweepInterval-time.Second))
+	if len(r.buckets) != 2 {
+		t.Fatalf("bad: %v", r.buckets)
+	}
+
+	// Only the buckets that have refilled are evicted
+	r.Allow("baz", now.Add(rateLimitSweepInterval))
+	if len(r.buckets) != 2 || r.buckets["foo"] != nil {
+		t.Fatalf("bad: %v", r.buckets)
+	}
+}
+
+func TestRateLimiter_Disabled(t *testing.T) {
+	r := newRateLimiter(RateLimitConfig{})
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		if ok, _ := r.Allow("foo", now); !ok {
+			t.Fatalf("should be allowed")
+		}
+	}
+	if len(r.buckets) != 0 || r.global != nil {
+		t.Fatalf("bad: %v %v", r.buckets, r.global)
+	}
+}
+
+func TestCore_HandleRequest_RateLimited(t *testing.T) {
+	c, key, root := TestCoreUnsealed(t)
+	if err := c.SetRateLimitConfig(RateLimitConfig{TokenRate: 0.001, TokenBurst: 1}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "secret/foo",
+		ClientToken: root,
+	}
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	_, err := c.HandleRequest(req)
+	limited, ok := err.(ErrRateLimited)
+	if !ok || limited.RetryAfter <= 0 {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The limits are kept after a restart
+	conf := &CoreConfig{
+		Physical:     c.physical,
+		DisableMlock: true,
+	}
+	c2, err := NewCore(conf)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := c2.Unseal(key); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if conf := c2.RateLimitConfig(); conf.TokenRate != 0.001 || conf.TokenBurst != 1 {
+		t.Fatalf("bad: %#v", conf)
+	}
+}
+
+func TestNewCore_RateLimit(t *testing.T) {
+	conf := &CoreConfig{
+		Physical:     physical.NewInmem(),
+		DisableMlock: true,
+		RateLimit:    RateLimitConfig{GlobalRate: -1},
+	}
+	if _, err := NewCore(conf); err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -0,0 +1,152 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/helper/pgpkeys"
+	"github.com/hashicorp/vault/physical"
+	"github.com/hashicorp/vault/shamir"
+)
+
+// RekeyResult is used to provide the key parts back after
+// they are generated as part of a rekey.
+type RekeyResult struct {
+	SecretShares [][]byte
+}
+
+// Rekey is used to split the master key into a new set of shares under
+// the given seal configuration. The current threshold of unseal keys
+// must be provided to reconstruct the master key, which is verified
+// against the barrier before any change is made.
+//
+// The master key itself is not changed, so the barrier is untouched and
+// only the seal configuration is written, in a single put. A failure
+// at any point leaves the current shares valid.
+func (c *Core) Rekey(config *SealConfig, keys [][]byte) (*RekeyResult, error) {
+	defer metrics.MeasureSince([]string{"core", "rekey"}, time.Now())
+
+	// Check if the seal configuraiton is valid
+	if err := config.Validate(); err != nil {
+		c.logger.Error("core: invalid rekey seal configuration: %v", err)
+		return nil, fmt.Errorf("invalid seal configuration: %v", err)
+	}
+
+	// Serialize with unsealing and sealing
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	if c.sealed {
+		return nil, ErrSealed
+	}
+	if c.standby {
+		return nil, ErrStandby
+	}
+
+	// Get the current seal configuration
+	existing, err := c.SealConfig()
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, ErrNotInit
+	}
+
+	// The secure seal setting is not part of the shares, so it is kept
+	config.SecureSeal = existing.SecureSeal
+
+	// Recover and verify the master key
+	masterKey, err := c.combineKeys(existing, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer memzero(masterKey)
+	if err := c.barrier.VerifyMaster(masterKey); err != nil {
+		c.logger.Error("core: rekey failed to verify master key: %v", err)
+		return nil, err
+	}
+
+	// Generate the new shares before storing the configuration, so
+	// that the configuration is never changed without them
+	shares, err := c.splitMasterKey(config, masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Store the new seal configuration
+	buf, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode seal configuration: %v", err)
+	}
+	pe := &physical.Entry{
+		Key:   coreSealConfigPath,
+		Value: buf,
+	}
+	if err := c.physical.Put(pe); err != nil {
+		c.logger.Error("core: failed to store seal configuration: %v", err)
+		return nil, fmt.Errorf("failed to store seal configuration: %v", err)
+	}
+	c.logger.Info("core: rekeyed to %d shares with a threshold of %d",
+		config.SecretShares, config.SecretThreshold)
+	return &RekeyResult{SecretShares: shares}, nil
+}
+
+// combineKeys is used to recover the master key from at least the
+// threshold of unseal keys of the given configuration. The returned
+// key is a copy, and should be zeroed once used.
+func (c *Core) combineKeys(config *SealConfig, keys [][]byte) ([]byte, error) {
+	for _, key := range keys {
+		if err := c.checkKeyLength(key); err != nil {
+			return nil, err
+		}
+	}
+	if len(keys) < config.SecretThreshold {
+		return nil, fmt.Errorf("%d unseal keys are required, but %d were given",
+			config.SecretThreshold, len(keys))
+	}
+
+	if config.SecretThreshold == 1 {
+		masterKey := make([]byte, len(keys[0]))
+		copy(masterKey, keys[0])
+		return masterKey, nil
+	}
+	masterKey, err := shamir.Combine(keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute master key: %v", err)
+	}
+	return masterKey, nil
+}
+
+// splitMasterKey is used to split the master key into shares using the
+// given configuration. The master key is returned as the only share if
+// a single share is used. The shares are encrypted if PGP keys are set.
+func (c *Core) splitMasterKey(config *SealConfig, masterKey []byte) ([][]byte, error) {
+	var shares [][]byte
+	if config.SecretShares == 1 {
+		share := make([]byte, len(masterKey))
+		copy(share, masterKey)
+		shares = [][]byte{share}
+	} else {
+		// Split the master key using the Shamir algorithm
+		var err error
+		shares, err = shamir.Split(masterKey, config.SecretShares, config.SecretThreshold)
+		if err != nil {
+			c.logger.Error("core: failed to generate shares: %v", err)
+			return nil, fmt.Errorf("failed to generate shares: %v", err)
+		}
+	}
+	if len(config.PGPKeys) == 0 {
+		return shares, nil
+	}
+
+	encrypted, err := pgpkeys.EncryptShares(shares, config.PGPKeys)
+	for _, share := range shares {
+		memzero(share)
+	}
+	if err != nil {
+		c.logger.Error("core: failed to encrypt shares: %v", err)
+		return nil, err
+	}
+	return encrypted, nil
+}
@@ -0,0 +1,107 @@
+package vault
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCore_Rekey(t *testing.T) {
+	c, master, root := TestCoreUnsealed(t)
+
+	// Split into multiple shares
+	newConf := &SealConfig{SecretShares: 5, SecretThreshold: 3}
+	result, err := c.Rekey(newConf, [][]byte{TestKeyCopy(master)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(result.SecretShares) != 5 {
+		t.Fatalf("bad: %#v", result)
+	}
+	conf, err := c.SealConfig()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(conf, newConf) {
+		t.Fatalf("bad: %#v", conf)
+	}
+
+	// The new shares unseal the Vault
+	if err := c.Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var nonce string
+	for i, share := range result.SecretShares[:3] {
+		var unsealed bool
+		unsealed, nonce, err = c.UnsealWithNonce(nonce, TestKeyCopy(share))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if unsealed != (i == 2) {
+			t.Fatalf("bad: %d %v", i, unsealed)
+		}
+	}
+
+	// Rekey back to a single share, using a different set of shares
+	result, err = c.Rekey(&SealConfig{SecretShares: 1, SecretThreshold: 1},
+		result.SecretShares[2:])
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(result.SecretShares) != 1 || !bytes.Equal(result.SecretShares[0], master) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestCore_Rekey_InvalidKeys(t *testing.T) {
+	c, master, _ := TestCoreUnsealed(t)
+	newConf := &SealConfig{SecretShares: 5, SecretThreshold: 3}
+	result, err := c.Rekey(newConf, [][]byte{TestKeyCopy(master)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	shares := result.SecretShares
+
+	// Too few keys
+	if _, err := c.Rekey(newConf, shares[:2]); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// Keys that do not reconstruct the master key
+	other, _, _ := TestCoreUnsealed(t)
+	otherResult, err := other.Rekey(newConf, [][]byte{TestKeyCopy(master)})
+	if err == nil {
+		t.Fatalf("expected error: %#v", otherResult)
+	}
+	bad := [][]byte{shares[0], shares[1], TestKeyCopy(shares[2])}
+	bad[2][0]++
+	if _, err := c.Rekey(newConf, bad); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// Invalid configuration
+	if _, err := c.Rekey(&SealConfig{SecretShares: 2, SecretThreshold: 3}, shares); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// The seal configuration is unchanged by failures
+	conf, err := c.SealConfig()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(conf, newConf) {
+		t.Fatalf("bad: %#v", conf)
+	}
+}
+
+func TestCore_Rekey_Sealed(t *testing.T) {
+	c, master, root := TestCoreUnsealed(t)
+	if err := c.Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	_, err := c.Rekey(&SealConfig{SecretShares: 1, SecretThreshold: 1},
+		[][]byte{TestKeyCopy(master)})
+	if err != ErrSealed {
+		t.Fatalf("err: %v", err)
+	}
+}
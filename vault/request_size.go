@@ -0,0 +1,63 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/logical"
+)
+
+// ErrRequestTooLarge is returned by HandleRequest when the data of a
+// request is larger than the maximum request size of its mount
+type ErrRequestTooLarge struct {
+	Size  int
+	Limit int
+}
+
+func (e ErrRequestTooLarge) Error() string {
+	return fmt.Sprintf("request of %d bytes exceeds the maximum request size of %d bytes",
+		e.Size, e.Limit)
+}
+
+// maxRequestSize returns the maximum request size for a path, which is
+// that of its mount if set and that of the core otherwise. Zero means
+// there is no limit.
+func (c *Core) maxRequestSize(path string) int {
+	limit := c.requestSizeLimit
+
+	mount := c.router.MatchingMount(path)
+	if mount == "" {
+		return limit
+	}
+
+	c.mountsLock.RLock()
+	defer c.mountsLock.RUnlock()
+	if c.mounts == nil {
+		return limit
+	}
+	if entry := c.mounts.Find(mount); entry != nil && entry.MaxRequestSize > 0 {
+		limit = entry.MaxRequestSize
+	}
+	return limit
+}
+
+// checkRequestSize is used to reject a request whose data is larger than
+// the maximum request size, before it is routed. The size is that of the
+// data encoded as JSON, which is how backends usually store it.
+func (c *Core) checkRequestSize(req *logical.Request) error {
+	limit := c.maxRequestSize(req.Path)
+	if limit == 0 || len(req.Data) == 0 {
+		return nil
+	}
+
+	raw, err := json.Marshal(req.Data)
+	if err != nil {
+		return fmt.Errorf("failed to encode request data: %v", err)
+	}
+	if len(raw) > limit {
+		metrics.IncrCounter([]string{"core", "request_too_large"}, 1)
+		return ErrRequestTooLarge{Size: len(raw), Limit: limit}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package vault
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestCore_HandleRequest_TooLarge(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	c.requestSizeLimit = 64
+
+	// Small requests are handled as usual
+	req := &logical.Request{
+		Operation:   logical.WriteOperation,
+		Path:        "secret/foo",
+		Data:        map[string]interface{}{"foo": "bar"},
+		ClientToken: root,
+	}
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Large requests are rejected before reaching the backend
+	req = &logical.Request{
+		Operation:   logical.WriteOperation,
+		Path:        "secret/bar",
+		Data:        map[string]interface{}{"foo": strings.Repeat("a", 128)},
+		ClientToken: root,
+	}
+	resp, err := c.HandleRequest(req)
+	if _, ok := err.(ErrRequestTooLarge); !ok || resp != nil {
+		t.Fatalf("bad: %#v %v", resp, err)
+	}
+
+	req = &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "secret/bar",
+		ClientToken: root,
+	}
+	resp, err = c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp != nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// The mount limit overrides that of the core
+	conf := c.mounts.Find("secret/").tuneConfig()
+	conf.MaxRequestSize = 1024
+	if err := c.tuneMount("secret/", conf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	req = &logical.Request{
+		Operation:   logical.WriteOperation,
+		Path:        "secret/bar",
+		Data:        map[string]interface{}{"foo": strings.Repeat("a", 128)},
+		ClientToken: root,
+	}
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestCore_MaxRequestSize_Tune(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+
+	// Tuning swaps the mount table while it is being read, which must
+	// be safe under the race detector
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		for i := 1; i <= 50; i++ {
+			if err := c.tuneMount("secret/", mountTuneConfig{MaxRequestSize: i}); err != nil {
+				t.Errorf("err: %v", err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		c.maxRequestSize("secret/foo")
+	}
+	<-doneCh
+
+	if limit := c.maxRequestSize("secret/foo"); limit != 50 {
+		t.Fatalf("bad: %d", limit)
+	}
+}
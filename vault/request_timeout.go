@@ -0,0 +1,143 @@
+package vault
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/logical"
+)
+
+// ErrRequestTimeout is returned by HandleRequest when a request does not
+// complete within the request timeout
+type ErrRequestTimeout struct {
+	Timeout time.Duration
+}
+
+func (e ErrRequestTimeout) Error() string {
+	return fmt.Sprintf("request timed out after %s", e.Timeout)
+}
+
+// requestResult is the outcome of a request handled in the background
+type requestResult struct {
+	resp *logical.Response
+	err  error
+
+	// panicked is set if the handler panicked, with the value recovered
+	// and the stack of the panic
+	panicked  bool
+	recovered interface{}
+	stack     []byte
+}
+
+// handleRequestTimeout is used to handle a request in the background,
+// giving up on it once the request timeout passes. The request is
+// canceled, so that the router and the framework stop handling it and
+// its storage refuses any further access. A storage call that is
+// already waiting on the physical backend is not interrupted. If the
+// request completes anyway, any lease or token it created is revoked,
+// since the client never received it. The read lock of the state lock
+// must be held, and is released once the request completes, which may
+// be after this returns, so a backend that hangs still delays sealing
+// and stepping down. Only reads are handled this way, since a write
+// that timed out could still be applied.
+// A panic of the handler is raised again here if the request has not
+// timed out, so that it is handled like that of any other request.
+func (c *Core) handleRequestTimeout(req *logical.Request,
+	handler func(*logical.Request) (*logical.Response, error)) (*logical.Response, error) {
+	// Handle a copy of the request, since the handler may still be
+	// using it after we have returned to the caller
+	cancelCh := make(chan struct{})
+	bgReq := *req
+	bgReq.Cancel = cancelCh
+
+	var lock sync.Mutex
+	var timedOut bool
+	doneCh := make(chan requestResult, 1)
+	go func() {
+		defer c.stateLock.RUnlock()
+		var res requestResult
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					res.panicked = true
+					res.recovered = r
+					res.stack = debug.Stack()
+				}
+			}()
+			res.resp, res.err = handler(&bgReq)
+		}()
+
+		lock.Lock()
+		defer lock.Unlock()
+		if timedOut {
+			if res.panicked {
+				c.logger.Error("core: request %s: panicked after timing out: %v\n%s",
+					req.ID, res.recovered, res.stack)
+				if c.sealOnPanic {
+					go c.EmergencySeal(fmt.Sprintf("request panicked: %v", res.recovered))
+				}
+				return
+			}
+			c.discardResponse(&bgReq, res.resp)
+			return
+		}
+		doneCh <- res
+	}()
+
+	timer := time.NewTimer(c.requestTimeout)
+	defer timer.Stop()
+	select {
+	case res := <-doneCh:
+		return res.result()
+	case <-timer.C:
+	}
+
+	// Check for a result once more, since the request may have completed
+	// as the timer fired
+	lock.Lock()
+	defer lock.Unlock()
+	select {
+	case res := <-doneCh:
+		return res.result()
+	default:
+	}
+	timedOut = true
+	close(cancelCh)
+
+	metrics.IncrCounter([]string{"core", "request_timeout"}, 1)
+	c.logger.Warn("core: request %s: timed out after %s (path: '%s')",
+		req.ID, c.requestTimeout, req.Path)
+	return nil, ErrRequestTimeout{Timeout: c.requestTimeout}
+}
+
+// result returns the response and error of the request, raising the
+// panic of the handler again if it panicked
+func (r requestResult) result() (*logical.Response, error) {
+	if r.panicked {
+		panic(r.recovered)
+	}
+	return r.resp, r.err
+}
+
+// discardResponse is used to revoke the lease or token created by a
+// request that completed after it timed out. The state lock must be held.
+func (c *Core) discardResponse(req *logical.Request, resp *logical.Response) {
+	if resp == nil {
+		return
+	}
+	if resp.Secret != nil && resp.Secret.LeaseID != "" {
+		if err := c.expiration.Revoke(resp.Secret.LeaseID); err != nil {
+			c.logger.Error("core: request %s: failed to revoke lease of timed out request for '%s': %v",
+				req.ID, req.Path, err)
+		}
+	}
+	if resp.Auth != nil && resp.Auth.ClientToken != "" {
+		if err := c.tokenStore.RevokeTree(resp.Auth.ClientToken); err != nil {
+			c.logger.Error("core: request %s: failed to revoke token of timed out request for '%s': %v",
+				req.ID, req.Path, err)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package vault
+
+import (
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// stallBackend is a backend that blocks reads until released, returning
+// a leased secret
+type stallBackend struct {
+	sync.Mutex
+	releaseCh  chan struct{}
+	canceled   bool
+	storageErr error
+	revoked    int
+	revokedCh  chan struct{}
+}
+
+func (b *stallBackend) HandleRequest(req *logical.Request) (*logical.Response, error) {
+	if req.Operation == logical.RevokeOperation {
+		b.Lock()
+		b.revoked++
+		b.Unlock()
+		close(b.revokedCh)
+		return nil, nil
+	}
+
+	<-b.releaseCh
+	if req.Path == "panic" {
+		panic("stall backend")
+	}
+	b.Lock()
+	select {
+	case <-req.Cancel:
+		b.canceled = true
+	default:
+	}
+	_, b.storageErr = req.Storage.Get("foo")
+	b.Unlock()
+
+	resp := &logical.Response{
+		Secret: &logical.Secret{
+			LeaseOptions: logical.LeaseOptions{
+				Lease: time.Hour,
+			},
+		},
+		Data: map[string]interface{}{
+			"foo": "bar",
+		},
+	}
+	return resp, nil
+}
+
+func (b *stallBackend) SpecialPaths() *logical.Paths {
+	return nil
+}
+
+func (b *stallBackend) SetLogger(*log.Logger) {}
+
+func TestCore_HandleRequest_Timeout(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	c.requestTimeout = 50 * time.Millisecond
+
+	b := &stallBackend{
+		releaseCh: make(chan struct{}),
+		revokedCh: make(chan struct{}),
+	}
+	c.logicalBackends["stall"] = func(map[string]string) (logical.Backend, error) {
+		return b, nil
+	}
+	if err := c.mount(&MountEntry{Path: "stall/", Type: "stall"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Other requests complete as usual
+	req := &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "sys/mounts",
+		ClientToken: root,
+	}
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req = &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "stall/foo",
+		ClientToken: root,
+	}
+	resp, err := c.HandleRequest(req)
+	if _, ok := err.(ErrRequestTimeout); !ok || resp != nil {
+		t.Fatalf("bad: %#v %v", resp, err)
+	}
+	if req.Cancel != nil {
+		t.Fatalf("request modified: %#v", req)
+	}
+
+	// The backend sees the cancellation, its storage refuses access, and
+	// the lease of the late secret is revoked
+	close(b.releaseCh)
+	select {
+	case <-b.revokedCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("lease not revoked")
+	}
+	b.Lock()
+	defer b.Unlock()
+	if !b.canceled || b.storageErr != logical.ErrRequestCanceled || b.revoked != 1 {
+		t.Fatalf("bad: %#v", b)
+	}
+}
+
+func TestCore_HandleRequest_Timeout_Write(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	c.requestTimeout = 50 * time.Millisecond
+
+	b := &stallBackend{releaseCh: make(chan struct{})}
+	c.logicalBackends["stall"] = func(map[string]string) (logical.Backend, error) {
+		return b, nil
+	}
+	if err := c.mount(&MountEntry{Path: "stall/", Type: "stall"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Writes are not timed out
+	time.AfterFunc(200*time.Millisecond, func() { close(b.releaseCh) })
+	req := &logical.Request{
+		Operation:   logical.WriteOperation,
+		Path:        "stall/foo",
+		ClientToken: root,
+	}
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Data["foo"] != "bar" {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
+func TestCore_HandleRequest_Timeout_Panic(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	c.requestTimeout = time.Second
+
+	b := &stallBackend{releaseCh: make(chan struct{})}
+	close(b.releaseCh)
+	c.logicalBackends["stall"] = func(map[string]string) (logical.Backend, error) {
+		return b, nil
+	}
+	if err := c.mount(&MountEntry{Path: "stall/", Type: "stall"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The panic is raised to the caller rather than crashing the process
+	func() {
+		defer func() {
+			if r := recover(); r != "stall backend" {
+				t.Fatalf("bad: %v", r)
+			}
+		}()
+		req := &logical.Request{
+			Operation:   logical.ReadOperation,
+			Path:        "stall/panic",
+			ClientToken: root,
+		}
+		c.HandleRequest(req)
+	}()
+
+	// The state lock is released
+	if err := c.Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
@@ -0,0 +1,347 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/helper/leveled-log"
+)
+
+const (
+	// coreRewrapPath is used to store the progress of rewrapping, so
+	// that it can be resumed after a restart or by another Vault
+	coreRewrapPath = "core/rewrap"
+
+	// rewrapBatchSize is the number of entries rewrapped between pauses.
+	// Progress is persisted after every batch.
+	rewrapBatchSize = 64
+
+	// rewrapPause is how long to pause between batches, so that
+	// rewrapping does not starve requests of the barrier
+	rewrapPause = 100 * time.Millisecond
+)
+
+var (
+	// rewrapExcludedPaths are the keys and directories that are not
+	// encrypted with the keyring and so must not be rewrapped. They are
+	// also skipped when verifying the barrier.
+	rewrapExcludedPaths = []string{
+		"barrier/",
+		coreSealConfigPath,
+		coreMasterKeyPath,
+		coreLockPath,
+	}
+
+	// errRewrapStopped is returned when a rewrap is interrupted by
+	// stopping the manager
+	errRewrapStopped = errors.New("rewrap stopped")
+)
+
+// RewrapManager is responsible for re-encrypting the entries of the
+// barrier with the active encryption key after a rotation, so that the
+// previous keys eventually protect no data.
+//
+// A pass walks every key of the barrier in order and rewraps the ones
+// encrypted with a previous key. The last key that was reached is
+// persisted periodically, so a pass that is interrupted by sealing or
+// losing leadership is resumed by the next active Vault. The manager
+// only runs on the active Vault.
+type RewrapManager struct {
+	logger    leveledlog.Logger
+	barrier   SecurityBarrier
+	batchSize int
+	pause     time.Duration
+
+	statusLock sync.RWMutex
+	status     RewrapStatus
+
+	triggerCh    chan struct{}
+	doneCh       chan struct{}
+	shutdown     bool
+	shutdownCh   chan struct{}
+	shutdownLock sync.Mutex
+}
+
+// RewrapStatus is the progress of the current or last pass
+type RewrapStatus struct {
+	// Term is the term of the key entries are rewrapped with
+	Term uint32
+
+	// Running is whether a pass is in progress
+	Running bool
+
+	// Checked is the number of entries checked by the pass, of which
+	// Rewrapped were encrypted with a previous key
+	Checked   int
+	Rewrapped int
+
+	// Remaining is the number of entries left to check
+	Remaining int
+}
+
+// rewrapState is the persisted progress of a pass
+type rewrapState struct {
+	Term    uint32
+	LastKey string
+	Done    bool
+}
+
+// NewRewrapManager is used to create a new rewrap manager
+func NewRewrapManager(logger leveledlog.Logger, barrier SecurityBarrier) *RewrapManager {
+	m := &RewrapManager{
+		logger:     logger,
+		barrier:    barrier,
+		batchSize:  rewrapBatchSize,
+		pause:      rewrapPause,
+		triggerCh:  make(chan struct{}, 1),
+		doneCh:     make(chan struct{}),
+		shutdownCh: make(chan struct{}),
+	}
+	return m
+}
+
+// Start starts the rewrap manager, resuming any pass that is not done
+func (m *RewrapManager) Start() {
+	m.Trigger()
+	go m.run()
+}
+
+// Stop stops the running manager, waiting for the current batch
+// to complete
+func (m *RewrapManager) Stop() {
+	m.shutdownLock.Lock()
+	defer m.shutdownLock.Unlock()
+	if !m.shutdown {
+		m.shutdown = true
+		close(m.shutdownCh)
+		<-m.doneCh
+	}
+}
+
+// Trigger is used to request a pass with the active key. If a pass is
+// in progress, another is made once it completes.
+func (m *RewrapManager) Trigger() {
+	select {
+	case m.triggerCh <- struct{}{}:
+	default:
+	}
+}
+
+// Status returns the progress of the current or last pass
+func (m *RewrapManager) Status() RewrapStatus {
+	m.statusLock.RLock()
+	defer m.statusLock.RUnlock()
+	return m.status
+}
+
+// run is a long running routine to make a pass when triggered
+func (m *RewrapManager) run() {
+	defer close(m.doneCh)
+	for {
+		select {
+		case <-m.triggerCh:
+			err := m.rewrap()
+			if err == errRewrapStopped {
+				return
+			}
+			if err != nil {
+				m.logger.Error("rewrap: failed to rewrap entries: %v", err)
+			}
+
+		case <-m.shutdownCh:
+			return
+		}
+	}
+}
+
+// rewrap is used to make a pass with the active key, resuming the
+// persisted pass if it was for the same key
+func (m *RewrapManager) rewrap() error {
+	defer metrics.MeasureSince([]string{"rewrap", "pass"}, time.Now())
+	term, err := m.barrier.ActiveTerm()
+	if err != nil {
+		return err
+	}
+	state, err := m.loadState()
+	if err != nil {
+		return err
+	}
+
+	// Determine where to start. A barrier that was never rotated has
+	// nothing to rewrap.
+	switch {
+	case state != nil && state.Term == term && state.Done:
+		return nil
+	case state != nil && state.Term == term:
+	case state == nil && term == keyEpoch:
+		return nil
+	default:
+		state = &rewrapState{Term: term}
+	}
+
+	keys, err := m.collectKeys("", state.LastKey)
+	if err != nil {
+		return err
+	}
+	m.setStatus(RewrapStatus{Term: term, Running: true, Remaining: len(keys)})
+	defer m.updateStatus(func(s *RewrapStatus) { s.Running = false })
+	if state.LastKey == "" {
+		m.logger.Info("rewrap: rewrapping entries with key term %d", term)
+	} else {
+		m.logger.Info("rewrap: resuming rewrapping entries with key term %d", term)
+	}
+
+	for i, key := range keys {
+		rewrapped, err := m.barrier.Rewrap(key)
+		if err != nil {
+			return fmt.Errorf("failed to rewrap '%s': %v", key, err)
+		}
+		m.updateStatus(func(s *RewrapStatus) {
+			s.Checked++
+			s.Remaining--
+			if rewrapped {
+				s.Rewrapped++
+			}
+		})
+
+		if (i+1)%m.batchSize != 0 || i == len(keys)-1 {
+			continue
+		}
+		state.LastKey = key
+		if err := m.persistState(state); err != nil {
+			return err
+		}
+		select {
+		case <-time.After(m.pause):
+		case <-m.shutdownCh:
+			return errRewrapStopped
+		}
+	}
+
+	state.Done = true
+	if err := m.persistState(state); err != nil {
+		return err
+	}
+	status := m.Status()
+	m.logger.Info("rewrap: rewrapped %d of %d entries with key term %d",
+		status.Rewrapped, status.Checked, term)
+	return nil
+}
+
+// collectKeys is used to list the keys under the prefix that sort after
+// the given key, in order
+func (m *RewrapManager) collectKeys(prefix, after string) ([]string, error) {
+	return collectBarrierKeys(m.barrier, prefix, after)
+}
+
+// collectBarrierKeys is used to list the keys of the barrier under the
+// prefix that sort after the given key, in order. The keys that are not
+// encrypted with the keyring are excluded.
+func collectBarrierKeys(barrier SecurityBarrier, prefix, after string) ([]string, error) {
+	children, err := barrier.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(children)
+
+	var out []string
+	for _, child := range children {
+		key := prefix + child
+		if !strings.HasSuffix(key, "/") {
+			if key > after && !rewrapExcluded(key) {
+				out = append(out, key)
+			}
+			continue
+		}
+
+		// Skip the directories that sort entirely before the key
+		if key < after && !strings.HasPrefix(after, key) || rewrapExcluded(key) {
+			continue
+		}
+		keys, err := collectBarrierKeys(barrier, key, after)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, keys...)
+	}
+	return out, nil
+}
+
+// rewrapExcluded checks if a key or directory must not be rewrapped
+func rewrapExcluded(key string) bool {
+	for _, path := range rewrapExcludedPaths {
+		if key == path {
+			return true
+		}
+	}
+	return false
+}
+
+// loadState is used to read the persisted progress
+func (m *RewrapManager) loadState() (*rewrapState, error) {
+	raw, err := m.barrier.Get(coreRewrapPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rewrap state: %v", err)
+	}
+	if raw == nil {
+		return nil, nil
+	}
+
+	state := new(rewrapState)
+	if err := json.Unmarshal(raw.Value, state); err != nil {
+		return nil, fmt.Errorf("failed to decode rewrap state: %v", err)
+	}
+	return state, nil
+}
+
+// persistState is used to persist the progress
+func (m *RewrapManager) persistState(state *rewrapState) error {
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode rewrap state: %v", err)
+	}
+	entry := &Entry{
+		Key:   coreRewrapPath,
+		Value: buf,
+	}
+	if err := m.barrier.Put(entry); err != nil {
+		return fmt.Errorf("failed to persist rewrap state: %v", err)
+	}
+	return nil
+}
+
+func (m *RewrapManager) setStatus(status RewrapStatus) {
+	m.statusLock.Lock()
+	defer m.statusLock.Unlock()
+	m.status = status
+}
+
+func (m *RewrapManager) updateStatus(fn func(*RewrapStatus)) {
+	m.statusLock.Lock()
+	defer m.statusLock.Unlock()
+	fn(&m.status)
+}
+
+// The methods below are the hooks from core that are called pre/post seal.
+
+// startRewrap is used to start the rewrap manager after unsealing
+func (c *Core) startRewrap() error {
+	c.rewrap = NewRewrapManager(c.logger, c.barrier)
+	c.rewrap.Start()
+	return nil
+}
+
+// stopRewrap is used to stop running the rewrap manager before sealing
+func (c *Core) stopRewrap() error {
+	if c.rewrap != nil {
+		c.rewrap.Stop()
+		c.rewrap = nil
+	}
+	return nil
+}
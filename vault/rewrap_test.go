@@ -0,0 +1,170 @@
+package vault
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/leveled-log"
+	"github.com/hashicorp/vault/physical"
+)
+
+// mockRewrap returns a rewrap manager for a barrier holding the given
+// number of entries, all encrypted with a previous key
+func mockRewrap(t *testing.T, entries int) (physical.Backend, SecurityBarrier, *RewrapManager) {
+	inm, b, _ := mockBarrier(t)
+	for i := 0; i < entries; i++ {
+		entry := &Entry{Key: fmt.Sprintf("foo/%02d", i), Value: []byte("test")}
+		if err := b.Put(entry); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if _, err := b.Rotate(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	logger := leveledlog.New(log.New(os.Stderr, "", log.LstdFlags))
+	m := NewRewrapManager(logger, b)
+	m.batchSize = 3
+	m.pause = 0
+	return inm, b, m
+}
+
+// testRewrapTerms returns the number of entries under foo/ that are
+// encrypted with the given term
+func testRewrapTerms(t *testing.T, inm physical.Backend, term uint32) int {
+	keys, err := inm.List("foo/")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	count := 0
+	for _, key := range keys {
+		pe, err := inm.Get("foo/" + key)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if valueTerm(pe.Value) == term {
+			count++
+		}
+	}
+	return count
+}
+
+func TestRewrapManager(t *testing.T) {
+	inm, _, m := mockRewrap(t, 10)
+	if err := m.rewrap(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if count := testRewrapTerms(t, inm, 2); count != 10 {
+		t.Fatalf("bad: %d", count)
+	}
+
+	// The keyring and seal configuration are checked but not rewrapped
+	status := m.Status()
+	if status.Term != 2 || status.Running || status.Rewrapped != 10 ||
+		status.Remaining != 0 || status.Checked < 10 {
+		t.Fatalf("bad: %#v", status)
+	}
+
+	// A completed pass is not repeated
+	if err := m.rewrap(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if m.Status() != status {
+		t.Fatalf("bad: %#v", m.Status())
+	}
+}
+
+func TestRewrapManager_Resume(t *testing.T) {
+	inm, _, m := mockRewrap(t, 10)
+
+	// Persist a pass that was interrupted after the fourth entry
+	state := &rewrapState{Term: 2, LastKey: "foo/03"}
+	if err := m.persistState(state); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := m.rewrap(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Only the entries after the last key are rewrapped
+	if count := testRewrapTerms(t, inm, 2); count != 6 {
+		t.Fatalf("bad: %d", count)
+	}
+	if status := m.Status(); status.Rewrapped != 6 {
+		t.Fatalf("bad: %#v", status)
+	}
+
+	state, err := m.loadState()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if state.Term != 2 || !state.Done {
+		t.Fatalf("bad: %#v", state)
+	}
+}
+
+func TestRewrapManager_NotRotated(t *testing.T) {
+	_, b, _ := mockBarrier(t)
+	logger := leveledlog.New(log.New(os.Stderr, "", log.LstdFlags))
+	m := NewRewrapManager(logger, b)
+	if err := m.rewrap(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if status := m.Status(); status.Checked != 0 {
+		t.Fatalf("bad: %#v", status)
+	}
+}
+
+func TestRewrapManager_MasterKeySource(t *testing.T) {
+	// The sealed master key is stored outside of the barrier, and looks
+	// like an entry encrypted with the first key
+	c := TestCore(t)
+	c.masterKeySource = &testMasterKeySource{prefix: []byte{0, 0, 0, 1, aesgcmVersionByte}}
+	key, _ := TestCoreInit(t, c)
+	if _, err := c.Unseal(TestKeyCopy(key)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := c.barrier.Rotate(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	logger := leveledlog.New(log.New(os.Stderr, "", log.LstdFlags))
+	m := NewRewrapManager(logger, c.barrier)
+	if err := m.rewrap(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if status := m.Status(); status.Rewrapped == 0 {
+		t.Fatalf("bad: %#v", status)
+	}
+
+	// The sealed master key is left as is
+	pe, err := c.physical.Get(coreMasterKeyPath)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if valueTerm(pe.Value) != 1 {
+		t.Fatalf("bad: %#v", pe)
+	}
+}
+
+func TestRewrapManager_collectKeys(t *testing.T) {
+	_, b, _ := mockBarrier(t)
+	for _, key := range []string{"a", "a-b", "a/b", "a/c/d", "b", coreSealConfigPath} {
+		if err := b.Put(&Entry{Key: key, Value: []byte("test")}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	logger := leveledlog.New(log.New(os.Stderr, "", log.LstdFlags))
+	m := NewRewrapManager(logger, b)
+
+	keys, err := m.collectKeys("", "a-b")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected := []string{"a/b", "a/c/d", "b"}
+	if fmt.Sprintf("%v", keys) != fmt.Sprintf("%v", expected) {
+		t.Fatalf("bad: %v", keys)
+	}
+}
@@ -1,12 +1,12 @@
 package vault
 
 import (
-	"log"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/helper/leveled-log"
 	"github.com/hashicorp/vault/logical"
 )
 
@@ -28,11 +28,18 @@ const (
 // on every mounted logical backend. It ensures that only one rollback operation
 // is in-flight at any given time within a single seal/unseal phase.
 type RollbackManager struct {
-	logger *log.Logger
-	mounts *MountTable
+	logger leveledlog.Logger
 	router *Router
 	period time.Duration
 
+	// backends returns the current mount entries. The mount table is
+	// replaced when modified, so it is looked up on every trigger.
+	backends func() []*MountEntry
+
+	// manual is a test hook that disables the periodic timer, so
+	// rollbacks only happen when triggered with RunNow.
+	manual bool
+
 	inflightAll  sync.WaitGroup
 	inflight     map[string]*rollbackState
 	inflightLock sync.Mutex
@@ -50,10 +57,10 @@ type rollbackState struct {
 }
 
 // NewRollbackManager is used to create a new rollback manager
-func NewRollbackManager(logger *log.Logger, mounts *MountTable, router *Router) *RollbackManager {
+func NewRollbackManager(logger leveledlog.Logger, backends func() []*MountEntry, router *Router) *RollbackManager {
 	r := &RollbackManager{
 		logger:     logger,
-		mounts:     mounts,
+		backends:   backends,
 		router:     router,
 		period:     rollbackPeriod,
 		inflight:   make(map[string]*rollbackState),
@@ -65,6 +72,10 @@ func NewRollbackManager(logger *log.Logger, mounts *MountTable, router *Router)
 
 // Start starts the rollback manager
 func (m *RollbackManager) Start() {
+	if m.manual {
+		close(m.doneCh)
+		return
+	}
 	go m.run()
 }
 
@@ -83,7 +94,7 @@ func (m *RollbackManager) Stop() {
 
 // run is a long running routine to periodically invoke rollback
 func (m *RollbackManager) run() {
-	m.logger.Printf("[INFO] rollback: starting rollback manager")
+	m.logger.Info("rollback: starting rollback manager")
 	tick := time.NewTicker(m.period)
 	defer tick.Stop()
 	defer close(m.doneCh)
@@ -93,7 +104,7 @@ func (m *RollbackManager) run() {
 			m.triggerRollbacks()
 
 		case <-m.shutdownCh:
-			m.logger.Printf("[INFO] rollback: stopping rollback manager")
+			m.logger.Info("rollback: stopping rollback manager")
 			return
 		}
 	}
@@ -101,12 +112,11 @@ func (m *RollbackManager) run() {
 
 // triggerRollbacks is used to trigger the rollbacks across all the backends
 func (m *RollbackManager) triggerRollbacks() {
-	m.mounts.RLock()
-	defer m.mounts.RUnlock()
+	backends := m.backends()
 	m.inflightLock.Lock()
 	defer m.inflightLock.Unlock()
 
-	for _, e := range m.mounts.Entries {
+	for _, e := range backends {
 		if _, ok := m.inflight[e.Path]; !ok {
 			m.startRollback(e.Path)
 		}
@@ -149,12 +159,20 @@ func (m *RollbackManager) attemptRollback(path string, rs *rollbackState) (err e
 		err = nil
 	}
 	if err != nil {
-		m.logger.Printf("[ERR] rollback: error rolling back %s: %s",
+		m.logger.Error("rollback: error rolling back %s: %s",
 			path, err)
 	}
 	return
 }
 
+// RunNow is used to trigger a rollback on every mount immediately,
+// waiting for all of them to complete. This is used by tests instead
+// of waiting on the timer.
+func (m *RollbackManager) RunNow() {
+	m.triggerRollbacks()
+	m.inflightAll.Wait()
+}
+
 // Rollback is used to trigger an immediate rollback of the path,
 // or to join an existing rollback operation if in flight.
 func (m *RollbackManager) Rollback(path string) error {
@@ -175,9 +193,20 @@ func (m *RollbackManager) Rollback(path string) error {
 
 // The methods below are the hooks from core that are called pre/post seal.
 
+// mountEntries returns the entries of the current mount table
+func (c *Core) mountEntries() []*MountEntry {
+	c.mountsLock.RLock()
+	defer c.mountsLock.RUnlock()
+	if c.mounts == nil {
+		return nil
+	}
+	return c.mounts.Entries
+}
+
 // startRollback is used to start the rollback manager after unsealing
 func (c *Core) startRollback() error {
-	c.rollback = NewRollbackManager(c.logger, c.mounts, c.router)
+	c.rollback = NewRollbackManager(c.logger, c.mountEntries, c.router)
+	c.rollback.manual = c.manualTimers
 	c.rollback.Start()
 	return nil
 }
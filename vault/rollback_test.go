@@ -6,6 +6,8 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/hashicorp/vault/helper/leveled-log"
 )
 
 // mockRollback returns a mock rollback manager
@@ -23,8 +25,8 @@ func mockRollback(t *testing.T) (*RollbackManager, *NoopBackend) {
 		t.Fatalf("err: %s", err)
 	}
 
-	logger := log.New(os.Stderr, "", log.LstdFlags)
-	rb := NewRollbackManager(logger, mounts, router)
+	logger := leveledlog.New(log.New(os.Stderr, "", log.LstdFlags))
+	rb := NewRollbackManager(logger, func() []*MountEntry { return mounts.Entries }, router)
 	rb.period = 10 * time.Millisecond
 	return rb, backend
 }
@@ -54,6 +56,29 @@ func TestRollbackManager(t *testing.T) {
 	}
 }
 
+func TestRollbackManager_RunNow(t *testing.T) {
+	m, backend := mockRollback(t)
+	m.manual = true
+
+	m.Start()
+	defer m.Stop()
+
+	// Nothing should happen without a trigger
+	if len(backend.Paths) > 0 {
+		t.Fatalf("bad: %#v", backend)
+	}
+
+	m.RunNow()
+	if len(backend.Paths) != 1 || backend.Paths[0] != "" {
+		t.Fatalf("bad: %#v", backend)
+	}
+
+	m.RunNow()
+	if len(backend.Paths) != 2 {
+		t.Fatalf("bad: %#v", backend)
+	}
+}
+
 func TestRollbackManager_Join(t *testing.T) {
 	m, backend := mockRollback(t)
 	if len(backend.Paths) > 0 {
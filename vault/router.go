@@ -3,7 +3,9 @@ package vault
 import (
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -13,6 +15,27 @@ import (
 	"github.com/hashicorp/vault/logical"
 )
 
+var (
+	// ErrNoMount is returned by the router if there is no mount that
+	// can handle the request path. This is distinct from any error
+	// returned by the backend the request was routed to.
+	ErrNoMount = errors.New("no handler for route")
+)
+
+// ErrNoRoute is the error returned by the router for a request path that
+// no mount can handle. It unwraps to ErrNoMount.
+type ErrNoRoute struct {
+	Path string
+}
+
+func (e ErrNoRoute) Error() string {
+	return fmt.Sprintf("%s '%s'", ErrNoMount, e.Path)
+}
+
+func (e ErrNoRoute) Unwrap() error {
+	return ErrNoMount
+}
+
 // Router is used to do prefix based routing of a request to a logical backend
 type Router struct {
 	l    sync.RWMutex
@@ -29,12 +52,17 @@ func NewRouter() *Router {
 
 // mountEntry is used to represent a mount point
 type mountEntry struct {
-	tainted    bool
-	salt       string
-	backend    logical.Backend
-	view       *BarrierView
-	rootPaths  *radix.Tree
-	loginPaths *radix.Tree
+	tainted         bool
+	readOnly        bool
+	template        string
+	mountType       string
+	salt            string
+	backend         logical.Backend
+	view            *BarrierView
+	rootPaths       *radix.Tree
+	loginPaths      *radix.Tree
+	idempotentPaths *radix.Tree
+	queryPaths      *radix.Tree
 }
 
 // SaltID is used to apply a salt and hash to an ID to make sure its not reversable
@@ -44,9 +72,47 @@ func (me *mountEntry) SaltID(id string) string {
 	return hex.EncodeToString(hash[:])
 }
 
+// relativePath returns the path relative to the mount point. If the mount
+// is templated, the first segment of the path is returned separately and
+// is not part of the relative path.
+func (me *mountEntry) relativePath(mount, path string) (string, string) {
+	remain := strings.TrimPrefix(path, mount)
+	if me.template == "" {
+		return "", remain
+	}
+	idx := strings.Index(remain, "/")
+	if idx == -1 {
+		return remain, ""
+	}
+	return remain[:idx], remain[idx+1:]
+}
+
+// RouteConfig is the configuration of a mount point that restricts how
+// requests are routed to it
+type RouteConfig struct {
+	// ReadOnly rejects any operation that modifies data
+	ReadOnly bool
+
+	// Template binds the first segment beneath the mount to the given
+	// key of the token metadata. Each value of the segment is routed to
+	// its own view within the storage of the mount.
+	Template string
+}
+
 // Mount is used to expose a logical backend at a given prefix, using a unique salt,
 // and the barrier view for that path.
 func (r *Router) Mount(backend logical.Backend, prefix, salt string, view *BarrierView) error {
+	return r.MountWith(backend, prefix, salt, view, nil)
+}
+
+// MountWith is used to mount a logical backend like Mount, with the
+// given configuration applied before any request can be routed to it
+func (r *Router) MountWith(backend logical.Backend, prefix, salt string,
+	view *BarrierView, conf *RouteConfig) error {
+	if conf == nil {
+		conf = &RouteConfig{}
+	}
+
 	r.l.Lock()
 	defer r.l.Unlock()
 
@@ -63,11 +129,15 @@ func (r *Router) Mount(backend logical.Backend, prefix, salt string, view *Barri
 
 	// Create a mount entry
 	me := &mountEntry{
-		tainted:    false,
-		backend:    backend,
-		view:       view,
-		rootPaths:  pathsToRadix(paths.Root),
-		loginPaths: pathsToRadix(paths.Unauthenticated),
+		tainted:         false,
+		readOnly:        conf.ReadOnly,
+		template:        conf.Template,
+		backend:         backend,
+		view:            view,
+		rootPaths:       pathsToRadix(paths.Root),
+		loginPaths:      pathsToRadix(paths.Unauthenticated),
+		idempotentPaths: pathsToRadix(paths.Idempotent),
+		queryPaths:      pathsToRadix(paths.Query),
 	}
 	r.root.Insert(prefix, me)
 	return nil
@@ -77,10 +147,30 @@ func (r *Router) Mount(backend logical.Backend, prefix, salt string, view *Barri
 func (r *Router) Unmount(prefix string) error {
 	r.l.Lock()
 	defer r.l.Unlock()
-	r.root.Delete(prefix)
+	if raw, ok := r.root.Delete(prefix); ok {
+		cleanupBackend(raw.(*mountEntry).backend)
+	}
 	return nil
 }
 
+// Cleanup is used to cleanup all the mounted backends when the router
+// is no longer used
+func (r *Router) Cleanup() {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.root.Walk(func(k string, raw interface{}) bool {
+		cleanupBackend(raw.(*mountEntry).backend)
+		return false
+	})
+}
+
+// cleanupBackend cleans up the backend if it holds any resources
+func cleanupBackend(backend logical.Backend) {
+	if cb, ok := backend.(logical.CleanupBackend); ok {
+		cb.Cleanup()
+	}
+}
+
 // Remount is used to change the mount location of a logical backend
 func (r *Router) Remount(src, dst string) error {
 	r.l.Lock()
@@ -121,6 +211,90 @@ func (r *Router) Untaint(path string) error {
 	return nil
 }
 
+// SetReadOnly is used to mark a path as read-only. Only operations that
+// do not modify data are allowed to proceed.
+func (r *Router) SetReadOnly(path string, value bool) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+	_, raw, ok := r.root.LongestPrefix(path)
+	if ok {
+		raw.(*mountEntry).readOnly = value
+	}
+	return nil
+}
+
+// SetMountType is used to record the backend type of the mount at a
+// path, which is reported by MountsUnder
+func (r *Router) SetMountType(path, mountType string) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+	_, raw, ok := r.root.LongestPrefix(path)
+	if ok {
+		raw.(*mountEntry).mountType = mountType
+	}
+	return nil
+}
+
+// MountInfo describes a mount point of the router
+type MountInfo struct {
+	Path string
+	Type string
+}
+
+// MountsUnder returns the mounts whose path begins with the prefix,
+// sorted by path. Tainted mounts are omitted since they are being
+// unmounted or remounted.
+func (r *Router) MountsUnder(prefix string) []MountInfo {
+	r.l.RLock()
+	defer r.l.RUnlock()
+	var mounts []MountInfo
+	r.root.WalkPrefix(prefix, func(k string, raw interface{}) bool {
+		me := raw.(*mountEntry)
+		if !me.tainted {
+			mounts = append(mounts, MountInfo{Path: k, Type: me.mountType})
+		}
+		return false
+	})
+	sort.Sort(mountInfoByPath(mounts))
+	return mounts
+}
+
+// mountInfoByPath is used to sort mounts by path
+type mountInfoByPath []MountInfo
+
+func (m mountInfoByPath) Len() int           { return len(m) }
+func (m mountInfoByPath) Less(i, j int) bool { return m[i].Path < m[j].Path }
+func (m mountInfoByPath) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+
+// TemplateAllowed checks if a token with the given metadata may access
+// the path. A path beneath a templated mount is only allowed if its
+// first segment is the value of the bound metadata key, so access is
+// denied if the token does not have the key. Paths of other mounts are
+// always allowed.
+func (r *Router) TemplateAllowed(path string, meta map[string]string) bool {
+	r.l.RLock()
+	mount, raw, ok := r.root.LongestPrefix(path)
+	r.l.RUnlock()
+	if !ok {
+		return true
+	}
+	me := raw.(*mountEntry)
+	if me.template == "" {
+		return true
+	}
+
+	segment, _ := me.relativePath(mount, path)
+	value, ok := meta[me.template]
+	return ok && validTemplateSegment(value) && segment == value
+}
+
+// validTemplateSegment checks if a value can be used as the segment of
+// a templated mount, so that it cannot escape its view
+func validTemplateSegment(segment string) bool {
+	return segment != "" && segment != "." && segment != ".." &&
+		!strings.Contains(segment, "/")
+}
+
 // MatchingMount returns the mount prefix that would be used for a path
 func (r *Router) MatchingMount(path string) string {
 	r.l.RLock()
@@ -143,6 +317,41 @@ func (r *Router) MatchingView(path string) *BarrierView {
 	return raw.(*mountEntry).view
 }
 
+// MatchingBackend returns the backend used for a path
+func (r *Router) MatchingBackend(path string) logical.Backend {
+	r.l.RLock()
+	_, raw, ok := r.root.LongestPrefix(path)
+	r.l.RUnlock()
+	if !ok {
+		return nil
+	}
+	return raw.(*mountEntry).backend
+}
+
+// UnknownFields returns the fields of the request data that are not
+// accepted by the backend the request would be routed to. The second
+// return value is false if the backend does not declare its fields.
+func (r *Router) UnknownFields(req *logical.Request) ([]string, bool) {
+	r.l.RLock()
+	mount, raw, ok := r.root.LongestPrefix(req.Path)
+	r.l.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	fb, ok := raw.(*mountEntry).backend.(logical.FieldsBackend)
+	if !ok {
+		return nil, false
+	}
+
+	// Check the fields against the path relative to the mount
+	original := req.Path
+	_, req.Path = raw.(*mountEntry).relativePath(mount, req.Path)
+	defer func() {
+		req.Path = original
+	}()
+	return fb.UnknownFields(req)
+}
+
 // Route is used to route a given request
 func (r *Router) Route(req *logical.Request) (*logical.Response, error) {
 	// If the path doesn't contain any slashes and doesn't end in a slash,
@@ -157,7 +366,7 @@ func (r *Router) Route(req *logical.Request) (*logical.Response, error) {
 	mount, raw, ok := r.root.LongestPrefix(req.Path)
 	r.l.RUnlock()
 	if !ok {
-		return nil, fmt.Errorf("no handler for route '%s'", req.Path)
+		return nil, ErrNoRoute{Path: req.Path}
 	}
 	defer metrics.MeasureSince([]string{"route", string(req.Operation),
 		strings.Replace(mount, "/", "-", -1)}, time.Now())
@@ -169,26 +378,56 @@ func (r *Router) Route(req *logical.Request) (*logical.Response, error) {
 		switch req.Operation {
 		case logical.RevokeOperation, logical.RollbackOperation:
 		default:
-			return nil, fmt.Errorf("no handler for route '%s'", req.Path)
+			return nil, ErrNoRoute{Path: req.Path}
+		}
+	}
+
+	// If the mount is read-only, reject any operation that modifies data
+	if me.readOnly {
+		switch req.Operation {
+		case logical.WriteOperation, logical.DeleteOperation:
+			return logical.ErrorResponse(fmt.Sprintf(
+				"mount '%s' is read-only", mount)), logical.ErrInvalidRequest
 		}
 	}
 
 	// Determine if this path is an unauthenticated path before we modify it
 	loginPath := r.LoginPath(req.Path)
 
+	// The templated segment of the path selects the storage view. There
+	// is nothing to roll back outside of those views.
+	segment, remain := me.relativePath(mount, req.Path)
+	storage := me.view
+	if me.template != "" {
+		if !validTemplateSegment(segment) {
+			if req.Operation == logical.RollbackOperation {
+				return nil, nil
+			}
+			return nil, ErrNoRoute{Path: req.Path}
+		}
+		storage = me.view.SubView(segment + "/")
+	}
+
 	// Adjust the path to exclude the routing prefix
 	original := req.Path
-	req.Path = strings.TrimPrefix(req.Path, mount)
+	req.Path = remain
 	if req.Path == "/" {
 		req.Path = ""
 	}
 
-	// Attach the storage view for the request
-	req.Storage = me.view
+	// Attach the storage view for the request. The view of a request
+	// that can be canceled refuses any access once it is.
+	if req.Cancel != nil {
+		storage = storage.WithCancel(req.Cancel)
+	}
+	req.Storage = storage
 
-	// Hash the request token unless this is the token backend
+	// Hash the request token unless this is the token, system or
+	// cubbyhole backend. The cubbyhole salts the token with the token
+	// store, so that it can be destroyed when the token is revoked.
 	clientToken := req.ClientToken
-	if !strings.HasPrefix(original, "auth/token/") {
+	if !strings.HasPrefix(original, "auth/token/") && !strings.HasPrefix(original, "sys/") &&
+		!strings.HasPrefix(original, cubbyholeMountPath) {
 		req.ClientToken = me.SaltID(req.ClientToken)
 	}
 
@@ -206,6 +445,13 @@ func (r *Router) Route(req *logical.Request) (*logical.Response, error) {
 		req.ClientToken = clientToken
 	}()
 
+	// Do not invoke the backend for a request that was already canceled
+	select {
+	case <-req.Cancel:
+		return nil, logical.ErrRequestCanceled
+	default:
+	}
+
 	// Invoke the backend
 	return me.backend.HandleRequest(req)
 }
@@ -221,7 +467,7 @@ func (r *Router) RootPath(path string) bool {
 	me := raw.(*mountEntry)
 
 	// Trim to get remaining path
-	remain := strings.TrimPrefix(path, mount)
+	_, remain := me.relativePath(mount, path)
 
 	// Check the rootPaths of this backend
 	match, raw, ok := me.rootPaths.LongestPrefix(remain)
@@ -250,7 +496,7 @@ func (r *Router) LoginPath(path string) bool {
 	me := raw.(*mountEntry)
 
 	// Trim to get remaining path
-	remain := strings.TrimPrefix(path, mount)
+	_, remain := me.relativePath(mount, path)
 
 	// Check the loginPaths of this backend
 	match, raw, ok := me.loginPaths.LongestPrefix(remain)
@@ -268,6 +514,50 @@ func (r *Router) LoginPath(path string) bool {
 	return match == remain
 }
 
+// IdempotentPath checks if the given path accepts idempotency keys
+func (r *Router) IdempotentPath(path string) bool {
+	return r.specialPath(path, func(me *mountEntry) *radix.Tree {
+		return me.idempotentPaths
+	})
+}
+
+// QueryPath checks if reads of the given path take query parameters
+func (r *Router) QueryPath(path string) bool {
+	return r.specialPath(path, func(me *mountEntry) *radix.Tree {
+		return me.queryPaths
+	})
+}
+
+// specialPath checks if the given path matches the special paths of its
+// backend returned by paths
+func (r *Router) specialPath(path string, paths func(*mountEntry) *radix.Tree) bool {
+	r.l.RLock()
+	mount, raw, ok := r.root.LongestPrefix(path)
+	r.l.RUnlock()
+	if !ok {
+		return false
+	}
+	me := raw.(*mountEntry)
+
+	// Trim to get remaining path
+	_, remain := me.relativePath(mount, path)
+
+	// Check the special paths of this backend
+	match, raw, ok := paths(me).LongestPrefix(remain)
+	if !ok {
+		return false
+	}
+	prefixMatch := raw.(bool)
+
+	// Handle the prefix match case
+	if prefixMatch {
+		return strings.HasPrefix(remain, match)
+	}
+
+	// Handle the exact match case
+	return match == remain
+}
+
 // pathsToRadix converts a the mapping of special paths to a mapping
 // of special paths to radix trees.
 func pathsToRadix(paths []string) *radix.Tree {
@@ -1,8 +1,10 @@
 package vault
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"reflect"
 	"strings"
 	"sync"
 	"testing"
@@ -13,12 +15,14 @@ import (
 type NoopBackend struct {
 	sync.Mutex
 
-	Root     []string
-	Login    []string
-	Paths    []string
-	Requests []*logical.Request
-	Response *logical.Response
-	Logger   *log.Logger
+	Root       []string
+	Login      []string
+	Idempotent []string
+	Query      []string
+	Paths      []string
+	Requests   []*logical.Request
+	Response   *logical.Response
+	Logger     *log.Logger
 }
 
 func (n *NoopBackend) HandleRequest(req *logical.Request) (*logical.Response, error) {
@@ -39,6 +43,8 @@ func (n *NoopBackend) SpecialPaths() *logical.Paths {
 	return &logical.Paths{
 		Root:            n.Root,
 		Unauthenticated: n.Login,
+		Idempotent:      n.Idempotent,
+		Query:           n.Query,
 	}
 }
 
@@ -67,7 +73,7 @@ func TestRouter_Mount(t *testing.T) {
 	}
 
 	if v := r.MatchingView("prod/aws/foo"); v != view {
-		t.Fatalf("bad: %s", v)
+		t.Fatalf("bad: %v", v)
 	}
 
 	if path := r.MatchingMount("stage/aws/foo"); path != "" {
@@ -75,7 +81,7 @@ func TestRouter_Mount(t *testing.T) {
 	}
 
 	if v := r.MatchingView("stage/aws/foo"); v != nil {
-		t.Fatalf("bad: %s", v)
+		t.Fatalf("bad: %v", v)
 	}
 
 	req := &logical.Request{
@@ -93,6 +99,57 @@ func TestRouter_Mount(t *testing.T) {
 	if len(n.Paths) != 1 || n.Paths[0] != "foo" {
 		t.Fatalf("bad: %v", n.Paths)
 	}
+
+	// Routing to a path without a mount is a distinct error
+	req = &logical.Request{
+		Path: "stage/aws/foo",
+	}
+	_, err = r.Route(req)
+	if _, ok := err.(ErrNoRoute); !ok {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestRouter_MountsUnder(t *testing.T) {
+	r := NewRouter()
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+
+	for _, path := range []string{"prod/aws/", "prod/consul/", "stage/aws/"} {
+		if err := r.Mount(&NoopBackend{}, path, generateUUID(), view); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		r.SetMountType(path, strings.Split(path, "/")[1])
+	}
+
+	expected := []MountInfo{
+		{Path: "prod/aws/", Type: "aws"},
+		{Path: "prod/consul/", Type: "consul"},
+	}
+	if mounts := r.MountsUnder("prod/"); !reflect.DeepEqual(mounts, expected) {
+		t.Fatalf("bad: %#v", mounts)
+	}
+	if mounts := r.MountsUnder(""); len(mounts) != 3 {
+		t.Fatalf("bad: %#v", mounts)
+	}
+
+	// Mounts reflect remounts, unmounts and taints
+	if err := r.Remount("prod/consul/", "stage/consul/"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	r.Taint("prod/aws/")
+	if mounts := r.MountsUnder("prod/"); len(mounts) != 0 {
+		t.Fatalf("bad: %#v", mounts)
+	}
+	if err := r.Unmount("stage/aws/"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected = []MountInfo{
+		{Path: "stage/consul/", Type: "consul"},
+	}
+	if mounts := r.MountsUnder("stage/"); !reflect.DeepEqual(mounts, expected) {
+		t.Fatalf("bad: %#v", mounts)
+	}
 }
 
 func TestRouter_Unmount(t *testing.T) {
@@ -115,7 +172,7 @@ func TestRouter_Unmount(t *testing.T) {
 		Path: "prod/aws/foo",
 	}
 	_, err = r.Route(req)
-	if !strings.Contains(err.Error(), "no handler for route") {
+	if _, ok := err.(ErrNoRoute); !ok {
 		t.Fatalf("err: %v", err)
 	}
 }
@@ -145,7 +202,7 @@ func TestRouter_Remount(t *testing.T) {
 		Path: "prod/aws/foo",
 	}
 	_, err = r.Route(req)
-	if !strings.Contains(err.Error(), "no handler for route") {
+	if _, ok := err.(ErrNoRoute); !ok {
 		t.Fatalf("err: %v", err)
 	}
 
@@ -237,6 +294,117 @@ func TestRouter_LoginPath(t *testing.T) {
 	}
 }
 
+func TestRouter_IdempotentPath(t *testing.T) {
+	r := NewRouter()
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+
+	n := &NoopBackend{
+		Idempotent: []string{
+			"create",
+			"issue/*",
+		},
+	}
+	err := r.Mount(n, "prod/", generateUUID(), view)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	type tcase struct {
+		path   string
+		expect bool
+	}
+	tcases := []tcase{
+		{"random", false},
+		{"prod/foo", false},
+		{"prod/create", true},
+		{"prod/create/foo", false},
+		{"prod/issue/web", true},
+	}
+
+	for _, tc := range tcases {
+		out := r.IdempotentPath(tc.path)
+		if out != tc.expect {
+			t.Fatalf("bad: path: %s expect: %v got %v", tc.path, tc.expect, out)
+		}
+	}
+}
+
+func TestRouter_QueryPath(t *testing.T) {
+	r := NewRouter()
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+
+	n := &NoopBackend{
+		Query: []string{
+			"search",
+			"versions/*",
+		},
+	}
+	err := r.Mount(n, "prod/", generateUUID(), view)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	type tcase struct {
+		path   string
+		expect bool
+	}
+	tcases := []tcase{
+		{"random", false},
+		{"prod/foo", false},
+		{"prod/search", true},
+		{"prod/search/foo", false},
+		{"prod/versions/web", true},
+	}
+
+	for _, tc := range tcases {
+		out := r.QueryPath(tc.path)
+		if out != tc.expect {
+			t.Fatalf("bad: path: %s expect: %v got %v", tc.path, tc.expect, out)
+		}
+	}
+}
+
+// cleanupNoopBackend is a NoopBackend that counts its cleanups
+type cleanupNoopBackend struct {
+	NoopBackend
+	cleanups int
+}
+
+func (n *cleanupNoopBackend) Cleanup() {
+	n.cleanups++
+}
+
+func TestRouter_Cleanup(t *testing.T) {
+	r := NewRouter()
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+
+	n1 := &cleanupNoopBackend{}
+	n2 := &cleanupNoopBackend{}
+	if err := r.Mount(n1, "foo/", generateUUID(), view); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := r.Mount(n2, "bar/", generateUUID(), view); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Unmounting cleans up the backend
+	if err := r.Unmount("foo/"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n1.cleanups != 1 || n2.cleanups != 0 {
+		t.Fatalf("bad: %d %d", n1.cleanups, n2.cleanups)
+	}
+
+	// Cleaning up the router cleans up the remaining backends
+	r.Cleanup()
+	if n1.cleanups != 1 || n2.cleanups != 1 {
+		t.Fatalf("bad: %d %d", n1.cleanups, n2.cleanups)
+	}
+}
+
 func TestRouter_Taint(t *testing.T) {
 	r := NewRouter()
 	_, barrier, _ := mockBarrier(t)
@@ -258,7 +426,7 @@ func TestRouter_Taint(t *testing.T) {
 		Path:      "prod/aws/foo",
 	}
 	_, err = r.Route(req)
-	if err.Error() != "no handler for route 'prod/aws/foo'" {
+	if _, ok := err.(ErrNoRoute); !ok {
 		t.Fatalf("err: %v", err)
 	}
 
@@ -276,6 +444,124 @@ func TestRouter_Taint(t *testing.T) {
 	}
 }
 
+func TestRouter_ReadOnly(t *testing.T) {
+	r := NewRouter()
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+
+	// The mount is read-only as soon as it is mounted
+	n := &NoopBackend{}
+	conf := &RouteConfig{ReadOnly: true}
+	err := r.MountWith(n, "prod/aws/", generateUUID(), view, conf)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Writes and deletes are rejected before reaching the backend
+	for _, op := range []logical.Operation{logical.WriteOperation, logical.DeleteOperation} {
+		req := &logical.Request{
+			Operation: op,
+			Path:      "prod/aws/foo",
+		}
+		resp, err := r.Route(req)
+		if err != logical.ErrInvalidRequest {
+			t.Fatalf("err: %v", err)
+		}
+		if resp == nil || !resp.IsError() {
+			t.Fatalf("bad: %#v", resp)
+		}
+	}
+	if len(n.Paths) != 0 {
+		t.Fatalf("bad: %v", n.Paths)
+	}
+
+	// Reads, rollbacks and revokes should work
+	for _, op := range []logical.Operation{
+		logical.ReadOperation, logical.RollbackOperation, logical.RevokeOperation} {
+		req := &logical.Request{
+			Operation: op,
+			Path:      "prod/aws/foo",
+		}
+		if _, err := r.Route(req); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// Clearing the flag allows writes again
+	r.SetReadOnly("prod/aws/", false)
+	req := &logical.Request{
+		Operation: logical.WriteOperation,
+		Path:      "prod/aws/foo",
+	}
+	if _, err := r.Route(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestRouter_Template(t *testing.T) {
+	r := NewRouter()
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+
+	n := &NoopBackend{Root: []string{"root"}}
+	conf := &RouteConfig{Template: "user"}
+	err := r.MountWith(n, "home/", generateUUID(), view, conf)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The segment selects the view and is not part of the path
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "home/alice/foo",
+	}
+	if _, err := r.Route(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n.Paths[0] != "foo" {
+		t.Fatalf("bad: %v", n.Paths)
+	}
+	if prefix := n.Requests[0].Storage.(*BarrierView).prefix; prefix != "logical/alice/" {
+		t.Fatalf("bad: %s", prefix)
+	}
+	if !r.RootPath("home/alice/root") || r.RootPath("home/root") {
+		t.Fatalf("bad root path")
+	}
+
+	// Only the segment matching the metadata is allowed
+	meta := map[string]string{"user": "alice"}
+	if !r.TemplateAllowed("home/alice/foo", meta) {
+		t.Fatalf("should be allowed")
+	}
+	for _, path := range []string{"home/bob/foo", "home/", "home/alice2/foo"} {
+		if r.TemplateAllowed(path, meta) {
+			t.Fatalf("should not be allowed: %s", path)
+		}
+	}
+	if r.TemplateAllowed("home/alice/foo", map[string]string{"other": "alice"}) {
+		t.Fatalf("should not be allowed without the key")
+	}
+	if r.TemplateAllowed("home/../foo", map[string]string{"user": ".."}) {
+		t.Fatalf("should not be allowed to escape the view")
+	}
+
+	// A path without a segment is not routed, except for a rollback
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "home/",
+	}
+	if _, err := r.Route(req); !errors.Is(err, ErrNoMount) {
+		t.Fatalf("err: %v", err)
+	}
+	req.Operation = logical.RollbackOperation
+	if _, err := r.Route(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(n.Paths) != 1 {
+		t.Fatalf("bad: %v", n.Paths)
+	}
+}
+
 func TestRouter_Untaint(t *testing.T) {
 	r := NewRouter()
 	_, barrier, _ := mockBarrier(t)
@@ -307,6 +593,43 @@ func TestRouter_Untaint(t *testing.T) {
 	}
 }
 
+func TestRouter_Cancel(t *testing.T) {
+	r := NewRouter()
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+
+	n := &NoopBackend{}
+	if err := r.Mount(n, "prod/aws/", generateUUID(), view); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The storage of a request that can be canceled refuses access once
+	// it is
+	cancelCh := make(chan struct{})
+	req := &logical.Request{
+		Path:   "prod/aws/foo",
+		Cancel: cancelCh,
+	}
+	if _, err := r.Route(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	close(cancelCh)
+	if _, err := n.Requests[0].Storage.Get("foo"); err != logical.ErrRequestCanceled {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := view.Get("foo"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A canceled request is not sent to the backend
+	if _, err := r.Route(req); err != logical.ErrRequestCanceled {
+		t.Fatalf("err: %v", err)
+	}
+	if len(n.Requests) != 1 {
+		t.Fatalf("bad: %v", n.Paths)
+	}
+}
+
 func TestPathsToRadix(t *testing.T) {
 	// Provide real paths
 	paths := []string{
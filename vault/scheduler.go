@@ -0,0 +1,146 @@
+package vault
+
+import (
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/helper/leveled-log"
+)
+
+// JobFunc is the function invoked for each run of a scheduled job
+type JobFunc func() error
+
+// Scheduler is used to run periodic background jobs that must only
+// run on the active node, such as cluster-wide maintenance. It is
+// started by postUnseal and stopped by preSeal, so the jobs stop
+// automatically when the node is sealed or steps down.
+type Scheduler struct {
+	logger leveledlog.Logger
+
+	l       sync.Mutex
+	jobs    []*scheduledJob
+	running bool
+	stopCh  chan struct{}
+	wg      sync.WaitGroup
+}
+
+// scheduledJob is a single registered job
+type scheduledJob struct {
+	name     string
+	interval time.Duration
+	fn       JobFunc
+}
+
+// NewScheduler is used to create a new scheduler
+func NewScheduler(logger leveledlog.Logger) *Scheduler {
+	s := &Scheduler{
+		logger: logger,
+	}
+	return s
+}
+
+// Register is used to add a job that runs every interval. If the
+// scheduler is already running the job is started immediately.
+func (s *Scheduler) Register(name string, interval time.Duration, fn JobFunc) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	job := &scheduledJob{
+		name:     name,
+		interval: interval,
+		fn:       fn,
+	}
+	s.jobs = append(s.jobs, job)
+	if s.running {
+		s.startJob(job)
+	}
+}
+
+// Start is used to start running all the registered jobs
+func (s *Scheduler) Start() {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.running {
+		return
+	}
+	s.running = true
+	s.stopCh = make(chan struct{})
+	for _, job := range s.jobs {
+		s.startJob(job)
+	}
+}
+
+// Stop is used to stop all the jobs. This waits for any in-flight
+// job runs to complete.
+func (s *Scheduler) Stop() {
+	s.l.Lock()
+	if !s.running {
+		s.l.Unlock()
+		return
+	}
+	s.running = false
+	close(s.stopCh)
+	s.l.Unlock()
+	s.wg.Wait()
+}
+
+// startJob is used to start the goroutine for a job.
+// This must be called with the lock held.
+func (s *Scheduler) startJob(job *scheduledJob) {
+	s.wg.Add(1)
+	go s.runJob(job, s.stopCh)
+}
+
+// runJob is a long running routine to periodically invoke a job
+func (s *Scheduler) runJob(job *scheduledJob, stopCh chan struct{}) {
+	defer s.wg.Done()
+	tick := time.NewTicker(job.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			start := time.Now()
+			if err := job.fn(); err != nil {
+				s.logger.Error("scheduler: job '%s' failed: %v", job.name, err)
+			}
+			metrics.MeasureSince([]string{"scheduler", job.name}, start)
+
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// The methods below are the hooks from core that are called pre/post seal.
+
+// startScheduler is used to register the leader-only jobs and start
+// the scheduler after unsealing
+func (c *Core) startScheduler() error {
+	c.scheduler = NewScheduler(c.logger)
+	c.scheduler.Register("idempotency-tidy", idempotencyTidyInterval, func() error {
+		n, err := c.idempotency.Tidy()
+		if err == nil && n > 0 {
+			c.logger.Debug("core: removed %d expired idempotency keys", n)
+		}
+		return err
+	})
+	c.scheduler.Register("tidy-tokens", tidyInterval, func() error {
+		_, err := c.tidyTokens()
+		return err
+	})
+	c.scheduler.Register("tidy-leases", tidyInterval, func() error {
+		_, err := c.tidyLeases()
+		return err
+	})
+	c.scheduler.Start()
+	return nil
+}
+
+// stopScheduler is used to stop the scheduler before sealing
+func (c *Core) stopScheduler() error {
+	if c.scheduler != nil {
+		c.scheduler.Stop()
+		c.scheduler = nil
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package vault
+
+import (
+	"log"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/leveled-log"
+)
+
+func TestScheduler(t *testing.T) {
+	logger := leveledlog.New(log.New(os.Stderr, "", log.LstdFlags))
+	s := NewScheduler(logger)
+
+	var runs uint64
+	s.Register("test", 5*time.Millisecond, func() error {
+		atomic.AddUint64(&runs, 1)
+		return nil
+	})
+
+	// Nothing runs until started
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadUint64(&runs); n != 0 {
+		t.Fatalf("bad: %d", n)
+	}
+
+	s.Start()
+	time.Sleep(50 * time.Millisecond)
+	s.Stop()
+
+	count := atomic.LoadUint64(&runs)
+	if count == 0 {
+		t.Fatalf("job should have run")
+	}
+
+	// Nothing runs after stopping
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadUint64(&runs); n != count {
+		t.Fatalf("should stop jobs: %d %d", count, n)
+	}
+}
+
+func TestScheduler_RegisterRunning(t *testing.T) {
+	logger := leveledlog.New(log.New(os.Stderr, "", log.LstdFlags))
+	s := NewScheduler(logger)
+	s.Start()
+	defer s.Stop()
+
+	doneCh := make(chan struct{}, 1)
+	s.Register("test", 5*time.Millisecond, func() error {
+		select {
+		case doneCh <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatalf("job should have run")
+	}
+}
+
+func TestCore_Scheduler_StopsOnSeal(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	s := c.scheduler
+	if s == nil || !s.running {
+		t.Fatalf("scheduler should be running")
+	}
+
+	if err := c.Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if c.scheduler != nil || s.running {
+		t.Fatalf("scheduler should be stopped")
+	}
+}
@@ -0,0 +1,180 @@
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+)
+
+// SealWrapper is used to additionally wrap the values of mounts that
+// opt in to seal wrapping. This is typically backed by a key held in an
+// external KMS or HSM, so that the most sensitive values are protected
+// by that key as well as the barrier.
+//
+// Values are wrapped before they are encrypted by the barrier and
+// unwrapped after they are decrypted. The wrapper must remain available
+// for as long as any seal wrapped mount exists, otherwise that data
+// can no longer be read.
+type SealWrapper interface {
+	// Wrap is used to wrap a plaintext value
+	Wrap(plaintext []byte) ([]byte, error)
+
+	// Unwrap is used to recover a value that was wrapped
+	Unwrap(ciphertext []byte) ([]byte, error)
+}
+
+// sealWrapStorage is a BarrierStorage that wraps all the values it
+// stores using a SealWrapper before passing them to the barrier.
+// It does not implement BarrierStreamStorage so streams are buffered.
+type sealWrapStorage struct {
+	barrier BarrierStorage
+	wrapper SealWrapper
+}
+
+// newSealWrapStorage returns a BarrierStorage that seal wraps values
+func newSealWrapStorage(barrier BarrierStorage, wrapper SealWrapper) *sealWrapStorage {
+	return &sealWrapStorage{
+		barrier: barrier,
+		wrapper: wrapper,
+	}
+}
+
+// BarrierStorage impl.
+func (s *sealWrapStorage) Put(entry *Entry) error {
+	defer metrics.MeasureSince([]string{"seal_wrap", "put"}, time.Now())
+	value, err := s.wrapper.Wrap(entry.Value)
+	if err != nil {
+		return fmt.Errorf("failed to seal wrap value: %v", err)
+	}
+	wrapped := &Entry{
+		Key:   entry.Key,
+		Value: value,
+	}
+	return s.barrier.Put(wrapped)
+}
+
+// BarrierStorage impl.
+func (s *sealWrapStorage) Get(key string) (*Entry, error) {
+	defer metrics.MeasureSince([]string{"seal_wrap", "get"}, time.Now())
+	entry, err := s.barrier.Get(key)
+	if err != nil || entry == nil {
+		return entry, err
+	}
+	value, err := s.wrapper.Unwrap(entry.Value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap value: %v", err)
+	}
+	entry.Value = value
+	return entry, nil
+}
+
+// BarrierStorage impl.
+func (s *sealWrapStorage) Delete(key string) error {
+	return s.barrier.Delete(key)
+}
+
+// BarrierStorage impl.
+func (s *sealWrapStorage) List(prefix string) ([]string, error) {
+	return s.barrier.List(prefix)
+}
+
+// mountStorage returns the storage to use for the view of a mount,
+// which seal wraps values if the mount requires it.
+func (c *Core) mountStorage(me *MountEntry) (BarrierStorage, error) {
+	if !me.SealWrap {
+		return c.barrier, nil
+	}
+	if c.sealWrapper == nil {
+		return nil, fmt.Errorf("seal wrapping requires a configured seal wrapper")
+	}
+	return newSealWrapStorage(c.barrier, c.sealWrapper), nil
+}
+
+// sealWrapMarker prefixes the values wrapped by a sealWrapPrefixStorage,
+// so that values stored before seal wrapping was enabled are recognized
+// and read as they are
+var sealWrapMarker = []byte("\x00sealwrap:")
+
+// sealWrapPrefixStorage is a BarrierStorage that seal wraps the values
+// of the keys under the given prefixes, and stores the others as they
+// are. It is used for the most sensitive entries of the Vault itself,
+// which may have been stored before a SealWrapper was configured.
+type sealWrapPrefixStorage struct {
+	barrier  BarrierStorage
+	wrapper  SealWrapper
+	prefixes []string
+}
+
+// SealWrapPrefixes returns a view with the same prefix whose entries
+// under the given prefixes, relative to the view, are also seal wrapped
+// by the wrapper. Entries stored before they were seal wrapped remain
+// readable, and are wrapped when they are next written.
+func (v *BarrierView) SealWrapPrefixes(wrapper SealWrapper, prefixes ...string) *BarrierView {
+	full := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		full[i] = v.expandKey(prefix)
+	}
+	storage := &sealWrapPrefixStorage{
+		barrier:  v.barrier,
+		wrapper:  wrapper,
+		prefixes: full,
+	}
+	return &BarrierView{barrier: storage, prefix: v.prefix}
+}
+
+// wrapped is used to check if the value of a key is seal wrapped
+func (s *sealWrapPrefixStorage) wrapped(key string) bool {
+	for _, prefix := range s.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// BarrierStorage impl.
+func (s *sealWrapPrefixStorage) Put(entry *Entry) error {
+	if !s.wrapped(entry.Key) {
+		return s.barrier.Put(entry)
+	}
+
+	defer metrics.MeasureSince([]string{"seal_wrap", "put"}, time.Now())
+	value, err := s.wrapper.Wrap(entry.Value)
+	if err != nil {
+		return fmt.Errorf("failed to seal wrap value: %v", err)
+	}
+	wrapped := &Entry{
+		Key:   entry.Key,
+		Value: append(append([]byte(nil), sealWrapMarker...), value...),
+	}
+	return s.barrier.Put(wrapped)
+}
+
+// BarrierStorage impl.
+func (s *sealWrapPrefixStorage) Get(key string) (*Entry, error) {
+	entry, err := s.barrier.Get(key)
+	if err != nil || entry == nil || !bytes.HasPrefix(entry.Value, sealWrapMarker) {
+		return entry, err
+	}
+
+	defer metrics.MeasureSince([]string{"seal_wrap", "get"}, time.Now())
+	value, err := s.wrapper.Unwrap(entry.Value[len(sealWrapMarker):])
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap value: %v", err)
+	}
+	entry.Value = value
+	return entry, nil
+}
+
+// BarrierStorage impl.
+func (s *sealWrapPrefixStorage) Delete(key string) error {
+	return s.barrier.Delete(key)
+}
+
+// BarrierStorage impl.
+func (s *sealWrapPrefixStorage) List(prefix string) ([]string, error) {
+	return s.barrier.List(prefix)
+}
@@ -0,0 +1,237 @@
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// testSealWrapper wraps values by prefixing them, so wrapped values
+// can be recognized in the barrier
+type testSealWrapper struct {
+	err error
+}
+
+func (w *testSealWrapper) Wrap(plaintext []byte) ([]byte, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	return append([]byte("wrapped:"), plaintext...), nil
+}
+
+func (w *testSealWrapper) Unwrap(ciphertext []byte) ([]byte, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	if !bytes.HasPrefix(ciphertext, []byte("wrapped:")) {
+		return nil, fmt.Errorf("not wrapped")
+	}
+	return ciphertext[len("wrapped:"):], nil
+}
+
+func TestSealWrapStorage(t *testing.T) {
+	_, barrier, _ := mockBarrier(t)
+	wrapper := &testSealWrapper{}
+	view := NewBarrierView(newSealWrapStorage(barrier, wrapper), "foo/")
+
+	entry := &logical.StorageEntry{Key: "test", Value: []byte("secret")}
+	if err := view.Put(entry); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The barrier holds the wrapped value
+	raw, err := barrier.Get("foo/test")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(raw.Value) != "wrapped:secret" {
+		t.Fatalf("bad: %q", raw.Value)
+	}
+
+	// Reads are unwrapped
+	out, err := view.Get("test")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out == nil || string(out.Value) != "secret" {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	// Streams are buffered through the wrapper
+	if err := view.PutStream("stream", bytes.NewReader([]byte("data"))); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	raw, err = barrier.Get("foo/stream")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(raw.Value) != "wrapped:data" {
+		t.Fatalf("bad: %q", raw.Value)
+	}
+
+	// Failures of the wrapper are returned
+	wrapper.err = fmt.Errorf("unavailable")
+	if _, err := view.Get("test"); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := view.Put(entry); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestCore_Mount_SealWrap(t *testing.T) {
+	c, key, root := TestCoreUnsealed(t)
+
+	// Seal wrapping requires a wrapper
+	me := &MountEntry{
+		Path:     "wrapped",
+		Type:     "generic",
+		SealWrap: true,
+	}
+	if err := c.mount(me); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	c.sealWrapper = &testSealWrapper{}
+	if err := c.mount(me); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.WriteOperation, "wrapped/foo")
+	req.Data["value"] = "bar"
+	req.ClientToken = root
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The value is wrapped within the barrier
+	raw, err := c.barrier.Get(backendBarrierPrefix + me.UUID + "/foo")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw == nil || !bytes.HasPrefix(raw.Value, []byte("wrapped:")) {
+		t.Fatalf("bad: %#v", raw)
+	}
+
+	// The mount is still wrapped after unsealing again
+	if err := c.Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if unseal, err := c.Unseal(TestKeyCopy(key)); err != nil || !unseal {
+		t.Fatalf("err: %v", err)
+	}
+	req = logical.TestRequest(t, logical.ReadOperation, "wrapped/foo")
+	req.ClientToken = root
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Data["value"] != "bar" {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
+func TestBarrierView_SealWrapPrefixes(t *testing.T) {
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "foo/")
+
+	// A value stored before seal wrapping is enabled
+	old := &logical.StorageEntry{Key: "secret/old", Value: []byte("old")}
+	if err := view.Put(old); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	wrapped := view.SealWrapPrefixes(&testSealWrapper{}, "secret/")
+	for _, key := range []string{"secret/new", "other"} {
+		entry := &logical.StorageEntry{Key: key, Value: []byte("value")}
+		if err := wrapped.Put(entry); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// Only the values under the prefixes are wrapped
+	raw, err := barrier.Get("foo/secret/new")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(raw.Value, append(append([]byte(nil), sealWrapMarker...), "wrapped:value"...)) {
+		t.Fatalf("bad: %q", raw.Value)
+	}
+	raw, err = barrier.Get("foo/other")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(raw.Value) != "value" {
+		t.Fatalf("bad: %q", raw.Value)
+	}
+
+	// Reads are transparent, including of values stored before
+	for key, exp := range map[string]string{
+		"secret/new": "value",
+		"secret/old": "old",
+		"other":      "value",
+	} {
+		out, err := wrapped.Get(key)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if out == nil || out.Key != key || string(out.Value) != exp {
+			t.Fatalf("bad: %#v", out)
+		}
+	}
+
+	// Sub-views keep wrapping
+	sub := wrapped.SubView("secret/")
+	if err := sub.Put(&logical.StorageEntry{Key: "sub", Value: []byte("value")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	raw, err = barrier.Get("foo/secret/sub")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.HasPrefix(raw.Value, sealWrapMarker) {
+		t.Fatalf("bad: %q", raw.Value)
+	}
+}
+
+func TestCore_TokenStore_SealWrap(t *testing.T) {
+	c, key, root := TestCoreUnsealed(t)
+
+	// Enable seal wrapping after the root token is stored
+	c.sealWrapper = &testSealWrapper{}
+	if err := c.Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if unseal, err := c.Unseal(TestKeyCopy(key)); err != nil || !unseal {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The root token can still be used
+	req := logical.TestRequest(t, logical.WriteOperation, "auth/token/create")
+	req.ClientToken = root
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	te, err := c.tokenStore.Lookup(resp.Auth.ClientToken)
+	if err != nil || te == nil {
+		t.Fatalf("bad: %#v %v", te, err)
+	}
+
+	// The new token and its accessor are wrapped within the barrier
+	prefix := systemBarrierPrefix + tokenSubPath
+	for _, path := range []string{
+		lookupPrefix + c.tokenStore.SaltID(te.ID),
+		accessorPrefix + c.tokenStore.SaltID(te.Accessor),
+	} {
+		raw, err := c.barrier.Get(prefix + path)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if raw == nil || !bytes.HasPrefix(raw.Value, sealWrapMarker) {
+			t.Fatalf("bad: %s %#v", path, raw)
+		}
+	}
+}
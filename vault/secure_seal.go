@@ -0,0 +1,99 @@
+package vault
+
+import (
+	"crypto/subtle"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/logical"
+)
+
+// SecureSeal is used to provide one of the key parts to seal the Vault
+// when secure seal is enabled in the seal configuration. Each part must
+// be provided with a root token, and the Vault is sealed once the
+// threshold of parts is provided and the master key they recover is
+// verified against the barrier. It returns whether the Vault is sealed.
+//
+// The key given as a parameter will automatically be zeroed once it is
+// no longer needed. If you want to keep the key around, a copy should
+// be made.
+func (c *Core) SecureSeal(token string, key []byte) (bool, error) {
+	defer metrics.MeasureSince([]string{"core", "secure_seal"}, time.Now())
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+
+	// Nothing to do if sealed
+	if c.sealed {
+		return true, nil
+	}
+
+	// Validate the token is a root token
+	_, err := c.checkToken(logical.WriteOperation, "sys/seal", token)
+	if err != nil {
+		return false, err
+	}
+
+	// Verify the key length
+	if err := c.checkKeyLength(key); err != nil {
+		return false, err
+	}
+
+	// Get the seal configuration
+	config, err := c.SealConfig()
+	if err != nil {
+		return false, err
+	}
+	if config == nil {
+		return false, ErrNotInit
+	}
+
+	// Check if we already have this piece
+	for _, existing := range c.sealParts {
+		if subtle.ConstantTimeCompare(existing, key) == 1 {
+			return false, nil
+		}
+	}
+	c.sealParts = append(c.sealParts, key)
+
+	// Check if we don't have enough keys to seal
+	if len(c.sealParts) < config.SecretThreshold {
+		c.logger.Debug("core: cannot seal, have %d of %d keys",
+			len(c.sealParts), config.SecretThreshold)
+		return false, nil
+	}
+
+	// Recover and verify the master key, starting over if it is wrong
+	masterKey, err := c.combineKeys(config, c.sealParts)
+	c.resetSealParts()
+	if err != nil {
+		return false, err
+	}
+	defer memzero(masterKey)
+	if err := c.barrier.VerifyMaster(masterKey); err != nil {
+		c.logger.Warn("core: secure seal failed to verify master key: %v", err)
+		return false, err
+	}
+
+	c.logger.Info("core: secure seal confirmed by %d keys", config.SecretThreshold)
+	if err := c.sealLocked(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SecureSealProgress returns the number of keys provided so far to
+// SecureSeal
+func (c *Core) SecureSealProgress() int {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	return len(c.sealParts)
+}
+
+// resetSealParts is used to discard the key parts provided to
+// SecureSeal so far, zeroing them. The state lock must be held.
+func (c *Core) resetSealParts() {
+	for _, part := range c.sealParts {
+		memzero(part)
+	}
+	c.sealParts = nil
+}
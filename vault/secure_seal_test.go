@@ -0,0 +1,122 @@
+package vault
+
+import (
+	"testing"
+)
+
+// testCoreSecureSeal returns an unsealed core with secure seal enabled,
+// along with its unseal keys and root token
+func testCoreSecureSeal(t *testing.T) (*Core, [][]byte, string) {
+	c := TestCore(t)
+	res, err := c.Initialize(&SealConfig{
+		SecretShares:    5,
+		SecretThreshold: 3,
+		SecureSeal:      true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var nonce string
+	for i := 0; i < 3; i++ {
+		if _, nonce, err = c.UnsealWithNonce(nonce, TestKeyCopy(res.SecretShares[i])); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if sealed, err := c.Sealed(); err != nil || sealed {
+		t.Fatalf("bad: %v %v", sealed, err)
+	}
+	return c, res.SecretShares, res.RootToken
+}
+
+func TestCore_SecureSeal(t *testing.T) {
+	c, keys, root := testCoreSecureSeal(t)
+
+	// A root token alone cannot seal
+	if err := c.Seal(root); err != ErrSecureSealRequired {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Each key must come with a root token
+	if _, err := c.SecureSeal("foo", TestKeyCopy(keys[0])); err == nil {
+		t.Fatalf("should fail")
+	}
+	if p := c.SecureSealProgress(); p != 0 {
+		t.Fatalf("bad: %d", p)
+	}
+
+	// The Vault is sealed once the threshold is reached, and repeated
+	// keys are not counted
+	for i, key := range [][]byte{keys[1], keys[1], keys[3]} {
+		sealed, err := c.SecureSeal(root, TestKeyCopy(key))
+		if err != nil || sealed {
+			t.Fatalf("bad: %d %v %v", i, sealed, err)
+		}
+	}
+	if p := c.SecureSealProgress(); p != 2 {
+		t.Fatalf("bad: %d", p)
+	}
+	sealed, err := c.SecureSeal(root, TestKeyCopy(keys[4]))
+	if err != nil || !sealed {
+		t.Fatalf("bad: %v %v", sealed, err)
+	}
+	if sealed, err := c.Sealed(); err != nil || !sealed {
+		t.Fatalf("bad: %v %v", sealed, err)
+	}
+	if p := c.SecureSealProgress(); p != 0 {
+		t.Fatalf("bad: %d", p)
+	}
+
+	// The Vault can be unsealed again as usual
+	var nonce string
+	for i := 2; i < 5; i++ {
+		var err error
+		if _, nonce, err = c.UnsealWithNonce(nonce, TestKeyCopy(keys[i])); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if sealed, err := c.Sealed(); err != nil || sealed {
+		t.Fatalf("bad: %v %v", sealed, err)
+	}
+}
+
+func TestCore_SecureSeal_InvalidKeys(t *testing.T) {
+	c, _, root := testCoreSecureSeal(t)
+	_, other, _ := testCoreSecureSeal(t)
+
+	// Keys of another Vault do not recover the master key, and the
+	// progress starts over
+	for i := 0; i < 3; i++ {
+		sealed, err := c.SecureSeal(root, TestKeyCopy(other[i]))
+		if i < 2 && err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if i == 2 && err == nil {
+			t.Fatalf("should fail")
+		}
+		if sealed {
+			t.Fatalf("should not be sealed")
+		}
+	}
+	if p := c.SecureSealProgress(); p != 0 {
+		t.Fatalf("bad: %d", p)
+	}
+	if sealed, err := c.Sealed(); err != nil || sealed {
+		t.Fatalf("bad: %v %v", sealed, err)
+	}
+}
+
+func TestCore_Seal_SecureSealDisabled(t *testing.T) {
+	c, key, root := TestCoreUnsealed(t)
+	if err := c.Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if unseal, err := c.Unseal(TestKeyCopy(key)); err != nil || !unseal {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The keys may still be used to seal
+	sealed, err := c.SecureSeal(root, TestKeyCopy(key))
+	if err != nil || !sealed {
+		t.Fatalf("bad: %v %v", sealed, err)
+	}
+}
@@ -15,7 +15,7 @@ import (
 // TestCore returns a pure in-memory, uninitialized core for testing.
 func TestCore(t *testing.T) *Core {
 	noopAudits := map[string]audit.Factory{
-		"noop": func(map[string]string) (audit.Backend, error) {
+		"noop": func(*audit.BackendConfig) (audit.Backend, error) {
 			return new(noopAudit), nil
 		},
 	}
@@ -0,0 +1,29 @@
+package vault
+
+import "time"
+
+const (
+	// tidyInterval is how often the token and lease state left behind by
+	// failed or interrupted revocations is removed
+	tidyInterval = 12 * time.Hour
+)
+
+// tidyTokens is used to remove the dangling and expired token state,
+// logging how many entries were removed
+func (c *Core) tidyTokens() (int, error) {
+	n, err := c.tokenStore.Tidy()
+	if n > 0 {
+		c.logger.Info("core: tidy removed %d token entries", n)
+	}
+	return n, err
+}
+
+// tidyLeases is used to remove the dangling and expired lease state,
+// logging how many entries were removed
+func (c *Core) tidyLeases() (int, error) {
+	n, err := c.expiration.Tidy()
+	if n > 0 {
+		c.logger.Info("core: tidy removed %d lease entries", n)
+	}
+	return n, err
+}
@@ -0,0 +1,262 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	// rolesPrefix is the prefix used to store the token roles
+	rolesPrefix = "roles/"
+)
+
+// tsRoleEntry is a token role. A role constrains the policies of the
+// tokens created with it, and sets their defaults, so that creating
+// tokens can be delegated safely.
+type tsRoleEntry struct {
+	// AllowedPolicies are the only policies a token created with the
+	// role may have. The default policy is always allowed.
+	AllowedPolicies []string `json:"allowed_policies"`
+
+	// Orphan creates tokens without a parent, so they are not revoked
+	// with the token that created them
+	Orphan bool `json:"orphan"`
+
+	// TTL is the TTL of the tokens if none is requested
+	TTL time.Duration `json:"ttl"`
+}
+
+// checkPolicies is used to verify that the policies are allowed by
+// the role
+func (r *tsRoleEntry) checkPolicies(name string, policies []string) error {
+	for _, p := range policies {
+		if p != defaultPolicyName && !strListContains(r.AllowedPolicies, p) {
+			return fmt.Errorf("policy '%s' is not allowed by token role '%s'", p, name)
+		}
+	}
+	return nil
+}
+
+// tokenRole is used to read a token role, returning nil if it does
+// not exist
+func (ts *TokenStore) tokenRole(name string) (*tsRoleEntry, error) {
+	raw, err := ts.view.Get(rolesPrefix + name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token role: %v", err)
+	}
+	if raw == nil {
+		return nil, nil
+	}
+
+	role := new(tsRoleEntry)
+	if err := json.Unmarshal(raw.Value, role); err != nil {
+		return nil, fmt.Errorf("failed to decode token role: %v", err)
+	}
+	return role, nil
+}
+
+// rolePaths returns the paths used to manage the token roles and to
+// create tokens with them
+func (ts *TokenStore) rolePaths() []*framework.Path {
+	return []*framework.Path{
+		&framework.Path{
+			Pattern: "create/(?P<role_name>.+)",
+
+			// The token parameters are read from the raw request data
+			ArbitraryFields: true,
+
+			Fields: map[string]*framework.FieldSchema{
+				"role_name": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "Name of the role to create the token with",
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.WriteOperation: ts.handleCreateAgainstRole,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(tokenCreateRoleHelp),
+			HelpDescription: strings.TrimSpace(tokenCreateRoleHelp),
+		},
+
+		&framework.Path{
+			Pattern: "roles/?$",
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: ts.handleRoleList,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(tokenRoleListHelp),
+			HelpDescription: strings.TrimSpace(tokenRoleListHelp),
+		},
+
+		&framework.Path{
+			Pattern: "roles/(?P<role_name>.+)",
+
+			Fields: map[string]*framework.FieldSchema{
+				"role_name": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "Name of the role",
+				},
+				"allowed_policies": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "Comma-separated list of the policies tokens of the role may have",
+				},
+				"orphan": &framework.FieldSchema{
+					Type:        framework.TypeBool,
+					Description: "If true, tokens of the role are created without a parent",
+				},
+				"ttl": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: "TTL of the tokens of the role if none is requested",
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   ts.handleRoleRead,
+				logical.WriteOperation:  ts.handleRoleWrite,
+				logical.DeleteOperation: ts.handleRoleDelete,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(tokenRoleHelp),
+			HelpDescription: strings.TrimSpace(tokenRoleHelp),
+		},
+	}
+}
+
+// handleRoleList handles the auth/token/roles path for listing roles
+func (ts *TokenStore) handleRoleList(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	names, err := ts.view.List(rolesPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(names), nil
+}
+
+// handleRoleRead handles reading a token role
+func (ts *TokenStore) handleRoleRead(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	role, err := ts.tokenRole(data.Get("role_name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"allowed_policies": role.AllowedPolicies,
+			"orphan":           role.Orphan,
+			"ttl":              int64(role.TTL / time.Second),
+		},
+	}
+	return resp, nil
+}
+
+// handleRoleWrite handles creating or replacing a token role
+func (ts *TokenStore) handleRoleWrite(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("role_name").(string)
+	role := &tsRoleEntry{
+		Orphan: data.Get("orphan").(bool),
+	}
+	for _, p := range strings.Split(data.Get("allowed_policies").(string), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			role.AllowedPolicies = append(role.AllowedPolicies, p)
+		}
+	}
+	if strListContains(role.AllowedPolicies, "root") {
+		return logical.ErrorResponse("token roles cannot allow the root policy"),
+			logical.ErrInvalidRequest
+	}
+	if raw, ok := data.GetOk("ttl"); ok {
+		dur, err := time.ParseDuration(raw.(string))
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"invalid ttl: %v", err)), logical.ErrInvalidRequest
+		}
+		if dur < 0 {
+			return logical.ErrorResponse("ttl must be positive"), logical.ErrInvalidRequest
+		}
+		role.TTL = dur
+	}
+
+	entry, err := logical.StorageEntryJSON(rolesPrefix+name, role)
+	if err != nil {
+		return nil, err
+	}
+	if err := ts.view.Put(entry); err != nil {
+		return nil, fmt.Errorf("failed to persist token role: %v", err)
+	}
+	return nil, nil
+}
+
+// handleRoleDelete handles deleting a token role. Tokens already created
+// with the role are not affected.
+func (ts *TokenStore) handleRoleDelete(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := ts.view.Delete(rolesPrefix + data.Get("role_name").(string)); err != nil {
+		return nil, fmt.Errorf("failed to delete token role: %v", err)
+	}
+	return nil, nil
+}
+
+// handleCreateAgainstRole handles the auth/token/create/<role_name> path
+// for creation of new tokens constrained by a role
+func (ts *TokenStore) handleCreateAgainstRole(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("role_name").(string)
+	role, err := ts.tokenRole(name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"unknown token role '%s'", name)), logical.ErrInvalidRequest
+	}
+	return ts.handleCreateCommon(req, name, role)
+}
+
+// loginTokenRole returns the token role that the credential backend of a
+// login is bound to, if any. An error is returned if the policies of the
+// login are not allowed by the role, along with the response for the
+// client.
+func (c *Core) loginTokenRole(path string, policies []string) (*tsRoleEntry, *logical.Response, error) {
+	name := c.credentialTokenRole(path)
+	if name == "" {
+		return nil, nil, nil
+	}
+
+	role, err := c.tokenStore.tokenRole(name)
+	if err != nil {
+		c.logger.Error("core: failed to read token role '%s': %v", name, err)
+		return nil, nil, ErrInternalError
+	}
+	if role == nil {
+		return nil, logical.ErrorResponse(fmt.Sprintf(
+			"unknown token role '%s'", name)), logical.ErrInvalidRequest
+	}
+	if err := role.checkPolicies(name, policies); err != nil {
+		return nil, logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	return role, nil, nil
+}
+
+const (
+	tokenCreateRoleHelp = `This endpoint will create a new token constrained by the given role.
+The policies must be allowed by the role rather than held by the client, and the role sets
+whether the token is an orphan and its default TTL.`
+	tokenRoleListHelp = `This endpoint will list the token roles.`
+	tokenRoleHelp     = `This endpoint will read, write or delete a token role. A role constrains
+the policies of the tokens created with it, and sets their defaults, so that creating tokens can
+be delegated. A credential backend can also be bound to a role when it is enabled, constraining
+the policies of its logins.`
+)
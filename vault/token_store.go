@@ -2,11 +2,16 @@ package vault
 
 import (
 	"crypto/sha1"
+	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/armon/go-metrics"
@@ -24,6 +29,10 @@ const (
 	// secondar parent based index
 	parentPrefix = "parent/"
 
+	// accessorPrefix is the prefix used to store the index from the
+	// accessor of a token to its ID
+	accessorPrefix = "accessor/"
+
 	// tokenSaltLocation is the path in the view we store our key salt.
 	// This is used to ensure the paths we write out are obfuscated so
 	// that token names cannot be guessed as that would compromise their
@@ -38,18 +47,76 @@ const (
 var (
 	// displayNameSanitize is used to sanitize a display name given to a token.
 	displayNameSanitize = regexp.MustCompile("[^a-zA-Z0-9-]")
+
+	// errNoExplicitPolicy is returned when a token would be created without
+	// any policy other than "default" while explicit policies are required.
+	errNoExplicitPolicy = errors.New(
+		`tokens must have at least one policy other than "default"`)
+
+	// errTokenUsesExhausted is returned by UseToken when a restricted use
+	// token has no uses left, because a parallel request used it up
+	errTokenUsesExhausted = errors.New("token has no remaining uses")
 )
 
+// hasExplicitPolicy checks if the policies contain at least one
+// policy other than "default". The root policy is always explicit.
+func hasExplicitPolicy(policies []string) bool {
+	for _, p := range policies {
+		if p != "" && p != "default" {
+			return true
+		}
+	}
+	return false
+}
+
+// withDefaultPolicy returns the policies with the default policy added,
+// unless already present. Root tokens are given no default policy.
+func withDefaultPolicy(policies []string) []string {
+	if strListContains(policies, "root") || strListContains(policies, defaultPolicyName) {
+		return policies
+	}
+	out := make([]string, 0, len(policies)+1)
+	out = append(out, policies...)
+	return append(out, defaultPolicyName)
+}
+
 // TokenStore is used to manage client tokens. Tokens are used for
 // clients to authenticate, and each token is mapped to an applicable
 // set of policy which is used for authorization.
 type TokenStore struct {
+	// tokenCount is the number of stored tokens, counted once when the
+	// store is loaded and then updated as tokens are created and
+	// revoked. This must be accessed atomically.
+	tokenCount int64
+
 	*framework.Backend
 
 	view *BarrierView
 	salt string
 
 	expiration *ExpirationManager
+
+	// cubbyholeDestroyer is used to delete the cubbyhole of a
+	// revoked token, given its salted ID
+	cubbyholeDestroyer func(string) error
+
+	// requireExplicitPolicy rejects child tokens without a policy
+	// other than "default"
+	requireExplicitPolicy bool
+
+	// leaseConfig returns the default and maximum lease durations,
+	// used to bound renewals
+	leaseConfig func() (time.Duration, time.Duration)
+
+	// useLock serializes the uses of restricted use tokens, so that
+	// parallel requests cannot use a token more times than allowed
+	useLock sync.Mutex
+
+	// indexLock is held for reading while a token and its indexes are
+	// written, and for writing by Tidy before removing an index entry,
+	// so that the entries of a token being created are not mistaken
+	// for dangling ones
+	indexLock sync.RWMutex
 }
 
 // NewTokenStore is used to construct a token store that is
@@ -58,9 +125,18 @@ func NewTokenStore(c *Core) (*TokenStore, error) {
 	// Create a sub-view
 	view := c.systemView.SubView(tokenSubPath)
 
+	// The token entries, and the accessors which lead to them, are also
+	// seal wrapped if a seal wrapper is configured
+	if c.sealWrapper != nil {
+		view = view.SealWrapPrefixes(c.sealWrapper, lookupPrefix, accessorPrefix)
+	}
+
 	// Initialize the store
 	t := &TokenStore{
-		view: view,
+		view:                  view,
+		requireExplicitPolicy: c.requireExplicitPolicy,
+		leaseConfig:           c.LeaseConfig,
+		cubbyholeDestroyer:    c.destroyCubbyhole,
 	}
 
 	// Look for the salt
@@ -83,21 +159,43 @@ func NewTokenStore(c *Core) (*TokenStore, error) {
 		}
 	}
 
+	// Count the existing tokens
+	saltedIds, err := view.List(lookupPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan for tokens: %v", err)
+	}
+	t.tokenCount = int64(len(saltedIds))
+
+	// Remove the plaintext IDs from the tokens written before they were
+	// only stored salted
+	for _, saltedId := range saltedIds {
+		if err := t.upgradeEntry(saltedId); err != nil {
+			return nil, err
+		}
+	}
+
 	// Setup the framework endpoints
 	t.Backend = &framework.Backend{
-		AuthRenew: framework.LeaseExtend(0, 0),
+		AuthRenew: t.authRenew,
 
 		PathsSpecial: &logical.Paths{
 			Root: []string{
 				"revoke-prefix/*",
+				"revoke-accessor/*",
+				"accessors",
 			},
 
 			Unauthenticated: []string{
 				"lookup-self",
 			},
+
+			Idempotent: []string{
+				"create",
+				"create/*",
+			},
 		},
 
-		Paths: []*framework.Path{
+		Paths: append([]*framework.Path{
 			&framework.Path{
 				Pattern: "create$",
 
@@ -130,19 +228,23 @@ func NewTokenStore(c *Core) (*TokenStore, error) {
 			&framework.Path{
 				Pattern: "lookup-self$",
 
-				Fields: map[string]*framework.FieldSchema{
-					"token": &framework.FieldSchema{
-						Type:        framework.TypeString,
-						Description: "Token to lookup",
-					},
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ReadOperation: t.handleLookupSelf,
 				},
 
+				HelpSynopsis:    strings.TrimSpace(tokenLookupSelfHelp),
+				HelpDescription: strings.TrimSpace(tokenLookupSelfHelp),
+			},
+
+			&framework.Path{
+				Pattern: "accessors$",
+
 				Callbacks: map[logical.Operation]framework.OperationFunc{
-					logical.ReadOperation: t.handleLookup,
+					logical.ListOperation: t.handleListAccessors,
 				},
 
-				HelpSynopsis:    strings.TrimSpace(tokenLookupHelp),
-				HelpDescription: strings.TrimSpace(tokenLookupHelp),
+				HelpSynopsis:    strings.TrimSpace(tokenListAccessorsHelp),
+				HelpDescription: strings.TrimSpace(tokenListAccessorsHelp),
 			},
 
 			&framework.Path{
@@ -199,6 +301,53 @@ func NewTokenStore(c *Core) (*TokenStore, error) {
 				HelpDescription: strings.TrimSpace(tokenRevokePrefixHelp),
 			},
 
+			&framework.Path{
+				Pattern: "revoke-accessor/(?P<accessor>.+)",
+
+				Fields: map[string]*framework.FieldSchema{
+					"accessor": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: "Accessor of the token to revoke",
+					},
+				},
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.WriteOperation: t.handleRevokeAccessor,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(tokenRevokeAccessorHelp),
+				HelpDescription: strings.TrimSpace(tokenRevokeAccessorHelp),
+			},
+
+			&framework.Path{
+				Pattern: "revoke-self$",
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.WriteOperation: t.handleRevokeSelf,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(tokenRevokeSelfHelp),
+				HelpDescription: strings.TrimSpace(tokenRevokeSelfHelp),
+			},
+
+			&framework.Path{
+				Pattern: "renew-self$",
+
+				Fields: map[string]*framework.FieldSchema{
+					"increment": &framework.FieldSchema{
+						Type:        framework.TypeInt,
+						Description: "The desired increment in seconds to the token expiration",
+					},
+				},
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.WriteOperation: t.handleRenewSelf,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(tokenRenewSelfHelp),
+				HelpDescription: strings.TrimSpace(tokenRenewSelfHelp),
+			},
+
 			&framework.Path{
 				Pattern: "renew/(?P<token>.+)",
 
@@ -220,21 +369,41 @@ func NewTokenStore(c *Core) (*TokenStore, error) {
 				HelpSynopsis:    strings.TrimSpace(tokenRenewHelp),
 				HelpDescription: strings.TrimSpace(tokenRenewHelp),
 			},
-		},
+		}, t.rolePaths()...),
 	}
 
 	return t, nil
 }
 
-// TokenEntry is used to represent a given token
+// TokenEntry is used to represent a given token. Only the salted forms of
+// the ID and parent are persisted, so the ID and parent are only set on
+// the entry given to Create and, for the ID, an entry found by Lookup.
 type TokenEntry struct {
-	ID          string            // ID of this entry, generally a random UUID
-	Parent      string            // Parent token, used for revocation trees
-	Policies    []string          // Which named policies should be used
-	Path        string            // Used for audit trails, this is something like "auth/user/login"
-	Meta        map[string]string // Used for auditing. This could include things like "source", "user", "ip"
-	DisplayName string            // Used for operators to be able to associate with the source
-	NumUses     int               // Used to restrict the number of uses (zero is unlimited). This is to support one-time-tokens (generalized).
+	ID           string            `json:"-"` // ID of this entry, generally a random UUID
+	Parent       string            `json:"-"` // Parent token, used for revocation trees
+	SaltedID     string            // Salted ID of this entry, set on creation
+	SaltedParent string            // Salted ID of the parent token, set on creation
+	Policies     []string          // Which named policies should be used
+	Path         string            // Used for audit trails, this is something like "auth/user/login"
+	Meta         map[string]string // Used for auditing. This could include things like "source", "user", "ip"
+	DisplayName  string            // Used for operators to be able to associate with the source
+	NumUses      int               // Used to restrict the number of uses (zero is unlimited). This is to support one-time-tokens (generalized).
+	TTL          time.Duration     // Lifetime of the token from its creation (zero is unlimited), independent of its lease
+	CreationTime time.Time         // Time the token was created, set on creation
+	Accessor     string            // Identifies the token without granting its use, safe to log
+}
+
+// expired checks if the TTL of the token has elapsed at the given time
+func (te *TokenEntry) expired(now time.Time) bool {
+	return te.TTL > 0 && now.After(te.CreationTime.Add(te.TTL))
+}
+
+// TokenListEntry describes a token in a listing without its ID, so
+// that listing tokens does not allow using them
+type TokenListEntry struct {
+	Accessor    string
+	DisplayName string
+	Policies    []string
 }
 
 // SetExpirationManager is used to provide the token store with
@@ -268,11 +437,33 @@ func (ts *TokenStore) RootToken() (*TokenEntry, error) {
 // a newly generated ID if not provided.
 func (ts *TokenStore) Create(entry *TokenEntry) error {
 	defer metrics.MeasureSince([]string{"token", "create"}, time.Now())
-	// Generate an ID if necessary
+	// Generate an ID if necessary. A given ID may replace an existing
+	// token, which must not be counted twice.
+	replaced := false
 	if entry.ID == "" {
 		entry.ID = generateUUID()
+	} else {
+		existing, err := ts.Lookup(entry.ID)
+		if err != nil {
+			return fmt.Errorf("failed to lookup token: %v", err)
+		}
+		replaced = existing != nil
+	}
+	if entry.CreationTime.IsZero() {
+		entry.CreationTime = time.Now().UTC()
+	}
+	if entry.Accessor == "" {
+		entry.Accessor = generateUUID()
 	}
 	saltedId := ts.SaltID(entry.ID)
+	entry.SaltedID = saltedId
+	entry.SaltedParent = ""
+	if entry.Parent != "" {
+		entry.SaltedParent = ts.SaltID(entry.Parent)
+	}
+
+	ts.indexLock.RLock()
+	defer ts.indexLock.RUnlock()
 
 	// Marshal the entry
 	enc, err := json.Marshal(entry)
@@ -295,45 +486,66 @@ func (ts *TokenStore) Create(entry *TokenEntry) error {
 		}
 
 		// Create the index entry
-		path := parentPrefix + ts.SaltID(entry.Parent) + "/" + saltedId
+		path := parentPrefix + entry.SaltedParent + "/" + saltedId
 		le := &logical.StorageEntry{Key: path}
 		if err := ts.view.Put(le); err != nil {
 			return fmt.Errorf("failed to persist entry: %v", err)
 		}
 	}
 
+	// Write the accessor index
+	path := accessorPrefix + ts.SaltID(entry.Accessor)
+	le := &logical.StorageEntry{Key: path, Value: []byte(saltedId)}
+	if err := ts.view.Put(le); err != nil {
+		return fmt.Errorf("failed to persist entry: %v", err)
+	}
+
 	// Write the primary ID
-	path := lookupPrefix + saltedId
-	le := &logical.StorageEntry{Key: path, Value: enc}
+	path = lookupPrefix + saltedId
+	le = &logical.StorageEntry{Key: path, Value: enc}
 	if err := ts.view.Put(le); err != nil {
 		return fmt.Errorf("failed to persist entry: %v", err)
 	}
+	if !replaced {
+		atomic.AddInt64(&ts.tokenCount, 1)
+	}
 	return nil
 }
 
 // UseToken is used to manage restricted use tokens and decrement
-// their available uses.
+// their available uses. The count is decremented from the stored entry
+// rather than the given one, which may be stale if the token is used by
+// parallel requests, and errTokenUsesExhausted is returned if no uses
+// remain.
 func (ts *TokenStore) UseToken(te *TokenEntry) error {
 	// If the token is not restricted, there is nothing to do
 	if te.NumUses == 0 {
 		return nil
 	}
 
+	ts.useLock.Lock()
+	defer ts.useLock.Unlock()
+
+	// Read the current count, the token is gone if it was used up
+	current, err := ts.lookupSalted(ts.SaltID(te.ID))
+	if err != nil {
+		return err
+	}
+	if current == nil || current.NumUses <= 0 {
+		return errTokenUsesExhausted
+	}
+
 	// Decrement the count
-	te.NumUses -= 1
-
-	// Revoke the token if there are no remaining uses.
-	// XXX: There is a race condition here with parallel
-	// requests using the same token. This would require
-	// some global coordination to avoid, as we must ensure
-	// no requests using the same restricted token are handled
-	// in parallel.
-	if te.NumUses == 0 {
+	te.NumUses = current.NumUses - 1
+	current.NumUses = te.NumUses
+
+	// Revoke the token if there are no remaining uses
+	if current.NumUses == 0 {
 		return ts.Revoke(te.ID)
 	}
 
 	// Marshal the entry
-	enc, err := json.Marshal(te)
+	enc, err := json.Marshal(current)
 	if err != nil {
 		return fmt.Errorf("failed to encode entry: %v", err)
 	}
@@ -354,9 +566,200 @@ func (ts *TokenStore) Lookup(id string) (*TokenEntry, error) {
 	if id == "" {
 		return nil, fmt.Errorf("cannot lookup blank token")
 	}
-	return ts.lookupSalted(ts.SaltID(id))
+	entry, err := ts.lookupSalted(ts.SaltID(id))
+	if err != nil || entry == nil {
+		return entry, err
+	}
+
+	// Entries are stored by the salted hash of the ID, and only hold the
+	// salted ID, so the presented token is never compared directly
+	// against stored data. The salted ID is verified in constant time.
+	if subtle.ConstantTimeCompare([]byte(entry.SaltedID), []byte(ts.SaltID(id))) != 1 {
+		return nil, nil
+	}
+	entry.ID = id
+
+	// Treat the token as invalid once its TTL has elapsed
+	if entry.expired(time.Now()) {
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// LookupByAccessor is used to find a token given its accessor. The ID of
+// the returned entry is not set, as only the salted ID is stored.
+func (ts *TokenStore) LookupByAccessor(accessor string) (*TokenEntry, error) {
+	defer metrics.MeasureSince([]string{"token", "lookup-accessor"}, time.Now())
+	if accessor == "" {
+		return nil, fmt.Errorf("cannot lookup blank accessor")
+	}
+
+	// Lookup the salted ID of the token
+	raw, err := ts.view.Get(accessorPrefix + ts.SaltID(accessor))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read entry: %v", err)
+	}
+	if raw == nil {
+		return nil, nil
+	}
+
+	// Verify the token still has this accessor
+	entry, err := ts.lookupSalted(string(raw.Value))
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(entry.Accessor), []byte(accessor)) != 1 {
+		return nil, nil
+	}
+	if entry.expired(time.Now()) {
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// List is used to describe all the valid tokens, sorted by accessor
+func (ts *TokenStore) List() ([]*TokenListEntry, error) {
+	defer metrics.MeasureSince([]string{"token", "list"}, time.Now())
+	saltedIds, err := ts.view.List(lookupPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan for tokens: %v", err)
+	}
+
+	now := time.Now()
+	out := make([]*TokenListEntry, 0, len(saltedIds))
+	for _, saltedId := range saltedIds {
+		entry, err := ts.lookupSalted(saltedId)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil || entry.expired(now) {
+			continue
+		}
+		out = append(out, &TokenListEntry{
+			Accessor:    entry.Accessor,
+			DisplayName: entry.DisplayName,
+			Policies:    entry.Policies,
+		})
+	}
+	sort.Sort(tokenListByAccessor(out))
+	return out, nil
+}
+
+// emitMetrics is invoked periodically to emit the number of tokens
+func (ts *TokenStore) emitMetrics() {
+	metrics.SetGauge([]string{"token", "num_tokens"}, float32(ts.numTokens()))
+}
+
+// numTokens returns the number of tokens stored. Tokens that have
+// expired but are not yet revoked are included.
+func (ts *TokenStore) numTokens() int {
+	return int(atomic.LoadInt64(&ts.tokenCount))
+}
+
+// Tidy is used to remove the token state left behind by failed or
+// interrupted revocations, returning how many entries were removed.
+// Tokens whose TTL has elapsed are revoked, and the parent and accessor
+// index entries of tokens that no longer exist are deleted. This is
+// safe to run while tokens are being created and revoked.
+func (ts *TokenStore) Tidy() (int, error) {
+	defer metrics.MeasureSince([]string{"token", "tidy"}, time.Now())
+	removed := 0
+
+	// Revoke the expired tokens whose leases did not revoke them, such as
+	// those created before the TTL limited the lease, along with their
+	// children as the lease would have
+	saltedIds, err := ts.view.List(lookupPrefix)
+	if err != nil {
+		return removed, fmt.Errorf("failed to scan for tokens: %v", err)
+	}
+	now := time.Now()
+	for _, saltedId := range saltedIds {
+		entry, err := ts.lookupSalted(saltedId)
+		if err != nil {
+			return removed, err
+		}
+		if entry == nil || !entry.expired(now) {
+			continue
+		}
+		if err := ts.revokeTreeSalted(saltedId, make(map[string]struct{})); err != nil {
+			return removed, fmt.Errorf("failed to revoke expired token: %v", err)
+		}
+		removed++
+	}
+
+	// Remove the parent index entries of children that no longer exist
+	parents, err := ts.view.List(parentPrefix)
+	if err != nil {
+		return removed, fmt.Errorf("failed to scan for parents: %v", err)
+	}
+	for _, parent := range parents {
+		children, err := ts.view.List(parentPrefix + parent)
+		if err != nil {
+			return removed, fmt.Errorf("failed to scan for children: %v", err)
+		}
+		for _, child := range children {
+			ok, err := ts.tidyIndex(parentPrefix+parent+child, child)
+			if err != nil {
+				return removed, err
+			}
+			if ok {
+				removed++
+			}
+		}
+	}
+
+	// Remove the accessor index entries of tokens that no longer exist
+	accessors, err := ts.view.List(accessorPrefix)
+	if err != nil {
+		return removed, fmt.Errorf("failed to scan for accessors: %v", err)
+	}
+	for _, accessor := range accessors {
+		raw, err := ts.view.Get(accessorPrefix + accessor)
+		if err != nil {
+			return removed, fmt.Errorf("failed to read entry: %v", err)
+		}
+		if raw == nil {
+			continue
+		}
+		ok, err := ts.tidyIndex(accessorPrefix+accessor, string(raw.Value))
+		if err != nil {
+			return removed, err
+		}
+		if ok {
+			removed++
+		}
+	}
+	return removed, nil
 }
 
+// tidyIndex is used to delete an index entry if the token it points to,
+// given by its salted ID, does not exist. It returns whether the entry
+// was deleted.
+func (ts *TokenStore) tidyIndex(path, saltedId string) (bool, error) {
+	// Wait for any token being created to be written in full
+	ts.indexLock.Lock()
+	defer ts.indexLock.Unlock()
+
+	raw, err := ts.view.Get(lookupPrefix + saltedId)
+	if err != nil {
+		return false, fmt.Errorf("failed to read entry: %v", err)
+	}
+	if raw != nil {
+		return false, nil
+	}
+	if err := ts.view.Delete(path); err != nil {
+		return false, fmt.Errorf("failed to delete entry: %v", err)
+	}
+	return true, nil
+}
+
+// tokenListByAccessor is used to sort a token listing by accessor
+type tokenListByAccessor []*TokenListEntry
+
+func (t tokenListByAccessor) Len() int           { return len(t) }
+func (t tokenListByAccessor) Less(i, j int) bool { return t[i].Accessor < t[j].Accessor }
+func (t tokenListByAccessor) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
 // lookupSlated is used to find a token given its salted ID
 func (ts *TokenStore) lookupSalted(saltedId string) (*TokenEntry, error) {
 	// Lookup token
@@ -379,6 +782,58 @@ func (ts *TokenStore) lookupSalted(saltedId string) (*TokenEntry, error) {
 	return entry, nil
 }
 
+// upgradeEntry is used to replace the plaintext ID and parent of a token
+// written before they were only stored salted, along with the plaintext
+// ID in its accessor index. It is only called while setting up the
+// store, before the token can be used.
+func (ts *TokenStore) upgradeEntry(saltedId string) error {
+	raw, err := ts.view.Get(lookupPrefix + saltedId)
+	if err != nil {
+		return fmt.Errorf("failed to read entry: %v", err)
+	}
+	if raw == nil {
+		return nil
+	}
+	var legacy struct {
+		ID       string
+		Parent   string
+		SaltedID string
+	}
+	if err := json.Unmarshal(raw.Value, &legacy); err != nil {
+		return fmt.Errorf("failed to decode entry: %v", err)
+	}
+	if legacy.SaltedID != "" {
+		return nil
+	}
+
+	entry := new(TokenEntry)
+	if err := json.Unmarshal(raw.Value, entry); err != nil {
+		return fmt.Errorf("failed to decode entry: %v", err)
+	}
+	entry.SaltedID = saltedId
+	if legacy.Parent != "" {
+		entry.SaltedParent = ts.SaltID(legacy.Parent)
+	}
+	enc, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode entry: %v", err)
+	}
+	if entry.Accessor != "" {
+		le := &logical.StorageEntry{
+			Key:   accessorPrefix + ts.SaltID(entry.Accessor),
+			Value: []byte(saltedId),
+		}
+		if err := ts.view.Put(le); err != nil {
+			return fmt.Errorf("failed to persist entry: %v", err)
+		}
+	}
+	le := &logical.StorageEntry{Key: lookupPrefix + saltedId, Value: enc}
+	if err := ts.view.Put(le); err != nil {
+		return fmt.Errorf("failed to persist entry: %v", err)
+	}
+	return nil
+}
+
 // Revoke is used to invalidate a given token, any child tokens
 // will be orphaned.
 func (ts *TokenStore) Revoke(id string) error {
@@ -403,21 +858,37 @@ func (ts *TokenStore) revokeSalted(saltedId string) error {
 	if ts.view.Delete(path); err != nil {
 		return fmt.Errorf("failed to delete entry: %v", err)
 	}
+	if entry != nil {
+		atomic.AddInt64(&ts.tokenCount, -1)
+	}
 
 	// Clear the secondary index if any
-	if entry != nil && entry.Parent != "" {
-		path := parentPrefix + ts.SaltID(entry.Parent) + "/" + saltedId
+	if entry != nil && entry.SaltedParent != "" {
+		path := parentPrefix + entry.SaltedParent + "/" + saltedId
 		if ts.view.Delete(path); err != nil {
 			return fmt.Errorf("failed to delete entry: %v", err)
 		}
 	}
 
+	// Clear the accessor index
+	if entry != nil && entry.Accessor != "" {
+		path := accessorPrefix + ts.SaltID(entry.Accessor)
+		if err := ts.view.Delete(path); err != nil {
+			return fmt.Errorf("failed to delete entry: %v", err)
+		}
+	}
+
 	// Revoke all secrets under this token
 	if entry != nil {
-		if err := ts.expiration.RevokeByToken(entry.ID); err != nil {
+		if err := ts.expiration.revokeBySaltedToken(saltedId); err != nil {
 			return err
 		}
 	}
+
+	// Destroy the cubbyhole of the token
+	if err := ts.cubbyholeDestroyer(saltedId); err != nil {
+		return fmt.Errorf("failed to destroy cubbyhole: %v", err)
+	}
 	return nil
 }
 
@@ -434,15 +905,22 @@ func (ts *TokenStore) RevokeTree(id string) error {
 	saltedId := ts.SaltID(id)
 
 	// Nuke the entire tree recursively
-	if err := ts.revokeTreeSalted(saltedId); err != nil {
+	if err := ts.revokeTreeSalted(saltedId, make(map[string]struct{})); err != nil {
 		return err
 	}
 	return nil
 }
 
 // revokeTreeSalted is used to invalide a given token and all
-// child tokens using a saltedID.
-func (ts *TokenStore) revokeTreeSalted(saltedId string) error {
+// child tokens using a saltedID. The tokens already visited are
+// tracked so that a cycle in the parent index, which can only be
+// the result of corruption, does not recurse forever.
+func (ts *TokenStore) revokeTreeSalted(saltedId string, visited map[string]struct{}) error {
+	if _, ok := visited[saltedId]; ok {
+		return nil
+	}
+	visited[saltedId] = struct{}{}
+
 	// Scan for child tokens
 	path := parentPrefix + saltedId + "/"
 	children, err := ts.view.List(path)
@@ -454,7 +932,7 @@ func (ts *TokenStore) revokeTreeSalted(saltedId string) error {
 	// we don't have the acutal ID of the child, but we have the salted
 	// value. Turns out, this is good enough!
 	for _, child := range children {
-		if err := ts.revokeTreeSalted(child); err != nil {
+		if err := ts.revokeTreeSalted(child, visited); err != nil {
 			return err
 		}
 	}
@@ -469,6 +947,13 @@ func (ts *TokenStore) revokeTreeSalted(saltedId string) error {
 // handleCreate handles the auth/token/create path for creation of new tokens
 func (ts *TokenStore) handleCreate(
 	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	return ts.handleCreateCommon(req, "", nil)
+}
+
+// handleCreateCommon is used to create a new token, constrained by the
+// given role if any
+func (ts *TokenStore) handleCreateCommon(
+	req *logical.Request, roleName string, role *tsRoleEntry) (*logical.Response, error) {
 	// Read the parent policy
 	parent, err := ts.Lookup(req.ClientToken)
 	if err != nil || parent == nil {
@@ -487,13 +972,16 @@ func (ts *TokenStore) handleCreate(
 
 	// Read and parse the fields
 	var data struct {
-		ID          string
-		Policies    []string
-		Metadata    map[string]string `mapstructure:"meta"`
-		NoParent    bool              `mapstructure:"no_parent"`
-		Lease       string
-		DisplayName string `mapstructure:"display_name"`
-		NumUses     int    `mapstructure:"num_uses"`
+		ID              string
+		Policies        []string
+		Metadata        map[string]string `mapstructure:"meta"`
+		NoParent        bool              `mapstructure:"no_parent"`
+		Orphan          bool
+		NoDefaultPolicy bool `mapstructure:"no_default_policy"`
+		Lease           string
+		TTL             string
+		DisplayName     string `mapstructure:"display_name"`
+		NumUses         int    `mapstructure:"num_uses"`
 	}
 	if err := mapstructure.WeakDecode(req.Data, &data); err != nil {
 		return logical.ErrorResponse(fmt.Sprintf(
@@ -514,6 +1002,9 @@ func (ts *TokenStore) handleCreate(
 		DisplayName: "token",
 		NumUses:     data.NumUses,
 	}
+	if role != nil {
+		te.Path += "/" + roleName
+	}
 
 	// Attach the given display name if any
 	if data.DisplayName != "" {
@@ -532,17 +1023,40 @@ func (ts *TokenStore) handleCreate(
 		te.ID = data.ID
 	}
 
-	// Only permit policies to be a subset unless the client is root
-	if len(data.Policies) == 0 {
-		data.Policies = parent.Policies
-	}
-	if !isRoot && !strListSubset(parent.Policies, data.Policies) {
-		return logical.ErrorResponse("child policies must be subset of parent"), logical.ErrInvalidRequest
+	// Only permit policies to be a subset unless the client is root. With
+	// a role, the policies must be allowed by the role instead.
+	switch {
+	case role != nil:
+		if len(data.Policies) == 0 {
+			data.Policies = role.AllowedPolicies
+		}
+		if err := role.checkPolicies(roleName, data.Policies); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+	default:
+		if len(data.Policies) == 0 {
+			data.Policies = parent.Policies
+		}
+		if !isRoot && !strListSubset(parent.Policies, data.Policies) {
+			return logical.ErrorResponse("child policies must be subset of parent"), logical.ErrInvalidRequest
+		}
 	}
 	te.Policies = data.Policies
 
-	// Only allow an orphan token if the client is root
-	if data.NoParent {
+	// Ensure the token is useful for something if required
+	if ts.requireExplicitPolicy && !hasExplicitPolicy(te.Policies) {
+		return logical.ErrorResponse(errNoExplicitPolicy.Error()), logical.ErrInvalidRequest
+	}
+
+	// Attach the default policy unless opted out
+	if !data.NoDefaultPolicy {
+		te.Policies = withDefaultPolicy(te.Policies)
+	}
+
+	// Only allow an orphan token if the client is root. An orphan token
+	// is not revoked with its creator. The "no_parent" name is kept for
+	// compatibility.
+	if data.Orphan || data.NoParent {
 		if !isRoot {
 			return logical.ErrorResponse("root required to create orphan token"),
 				logical.ErrInvalidRequest
@@ -551,6 +1065,11 @@ func (ts *TokenStore) handleCreate(
 		te.Parent = ""
 	}
 
+	// A role may create orphan tokens without root
+	if role != nil && role.Orphan {
+		te.Parent = ""
+	}
+
 	// Parse the lease if any
 	var leaseDuration time.Duration
 	if data.Lease != "" {
@@ -564,6 +1083,20 @@ func (ts *TokenStore) handleCreate(
 		leaseDuration = dur
 	}
 
+	// Parse the TTL if any
+	if data.TTL != "" {
+		dur, err := time.ParseDuration(data.TTL)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		if dur < 0 {
+			return logical.ErrorResponse("ttl must be positive"), logical.ErrInvalidRequest
+		}
+		te.TTL = dur
+	} else if role != nil {
+		te.TTL = role.TTL
+	}
+
 	// Create the token
 	if err := ts.Create(&te); err != nil {
 		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
@@ -581,12 +1114,37 @@ func (ts *TokenStore) handleCreate(
 				Renewable:        leaseDuration > 0,
 			},
 			ClientToken: te.ID,
+			Accessor:    te.Accessor,
 		},
 	}
 
 	return resp, nil
 }
 
+// handleListAccessors handles the auth/token/accessors path for listing
+// the accessors of the valid tokens
+func (ts *TokenStore) handleListAccessors(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entries, err := ts.List()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(entries))
+	info := make(map[string]interface{}, len(entries))
+	for _, entry := range entries {
+		keys = append(keys, entry.Accessor)
+		info[entry.Accessor] = map[string]interface{}{
+			"display_name": entry.DisplayName,
+			"policies":     entry.Policies,
+		}
+	}
+
+	resp := logical.ListResponse(keys)
+	resp.Data["key_info"] = info
+	return resp, nil
+}
+
 // handleRevokeTree handles the auth/token/revoke/id path for revocation of tokens
 // in a way that revokes all child tokens. Normally, using sys/revoke/leaseID will revoke
 // the token and all children anyways, but that is only available when there is a lease.
@@ -604,6 +1162,21 @@ func (ts *TokenStore) handleRevokeTree(
 	return nil, nil
 }
 
+// handleRevokeSelf handles the auth/token/revoke-self path for revocation
+// of the token of the request and its children
+func (ts *TokenStore) handleRevokeSelf(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if req.ClientToken == "" {
+		return logical.ErrorResponse("missing token ID"), logical.ErrInvalidRequest
+	}
+
+	// Revoke the token and its children
+	if err := ts.RevokeTree(req.ClientToken); err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	return nil, nil
+}
+
 // handleRevokeOrphan handles the auth/token/revoke-orphan/id path for revocation of tokens
 // in a way that leaves child tokens orphaned. Normally, using sys/revoke/leaseID will revoke
 // the token and all children.
@@ -647,6 +1220,19 @@ func (ts *TokenStore) handleLookup(
 	if id == "" {
 		id = req.ClientToken
 	}
+	return ts.lookupToken(id)
+}
+
+// handleLookupSelf handles the auth/token/lookup-self path for querying
+// information about the token of the request. Only that token can be
+// looked up, so the path can be permitted to every token.
+func (ts *TokenStore) handleLookupSelf(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return ts.lookupToken(req.ClientToken)
+}
+
+// lookupToken is used to return the information about the given token
+func (ts *TokenStore) lookupToken(id string) (*logical.Response, error) {
 	if id == "" {
 		return logical.ErrorResponse("missing token ID"), logical.ErrInvalidRequest
 	}
@@ -666,17 +1252,55 @@ func (ts *TokenStore) handleLookup(
 	// you could escalade your privileges.
 	resp := &logical.Response{
 		Data: map[string]interface{}{
-			"id":           out.ID,
-			"policies":     out.Policies,
-			"path":         out.Path,
-			"meta":         out.Meta,
-			"display_name": out.DisplayName,
-			"num_uses":     out.NumUses,
+			"id":            out.ID,
+			"policies":      out.Policies,
+			"path":          out.Path,
+			"meta":          out.Meta,
+			"display_name":  out.DisplayName,
+			"num_uses":      out.NumUses,
+			"ttl":           int64(out.TTL.Seconds()),
+			"creation_time": out.CreationTime.Unix(),
+			"accessor":      out.Accessor,
+			"orphan":        out.SaltedParent == "",
 		},
 	}
 	return resp, nil
 }
 
+// authRenew is invoked by the expiration manager to renew the lease of
+// a token. The lease is extended by the requested increment, up to the
+// maximum lease from the current time.
+func (ts *TokenStore) authRenew(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	_, maxLease := ts.leaseConfig()
+	return framework.LeaseExtend(maxLease, 0)(req, data)
+}
+
+// handleRevokeAccessor handles the auth/token/revoke-accessor/accessor
+// path for revocation of a token and its children by its accessor
+func (ts *TokenStore) handleRevokeAccessor(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	accessor := data.Get("accessor").(string)
+	if accessor == "" {
+		return logical.ErrorResponse("missing accessor"), logical.ErrInvalidRequest
+	}
+
+	// Lookup the token
+	out, err := ts.LookupByAccessor(accessor)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	if out == nil {
+		return logical.ErrorResponse("token not found"), logical.ErrInvalidRequest
+	}
+
+	// Revoke the token and its children
+	if err := ts.revokeTreeSalted(out.SaltedID, make(map[string]struct{})); err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	return nil, nil
+}
+
 // handleRenew handles the auth/token/renew/id path for renewal of tokens.
 // This is used to prevent token expiration and revocation.
 func (ts *TokenStore) handleRenew(
@@ -685,8 +1309,22 @@ func (ts *TokenStore) handleRenew(
 	if id == "" {
 		return logical.ErrorResponse("missing token ID"), logical.ErrInvalidRequest
 	}
-	incrementRaw := data.Get("increment").(int)
+	return ts.renewToken(id, data.Get("increment").(int))
+}
 
+// handleRenewSelf handles the auth/token/renew-self path for renewal of
+// the token of the request
+func (ts *TokenStore) handleRenewSelf(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if req.ClientToken == "" {
+		return logical.ErrorResponse("missing token ID"), logical.ErrInvalidRequest
+	}
+	return ts.renewToken(req.ClientToken, data.Get("increment").(int))
+}
+
+// renewToken is used to renew the lease of the given token by the
+// increment in seconds
+func (ts *TokenStore) renewToken(id string, incrementRaw int) (*logical.Response, error) {
 	// Convert the increment
 	increment := time.Duration(incrementRaw) * time.Second
 
@@ -701,7 +1339,7 @@ func (ts *TokenStore) handleRenew(
 		return logical.ErrorResponse("token not found"), logical.ErrInvalidRequest
 	}
 
-	// Revoke the token and its children
+	// Renew the lease of the token
 	auth, err := ts.expiration.RenewToken(out.Path, out.ID, increment)
 	if err != nil {
 		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
@@ -719,10 +1357,15 @@ const (
 Client tokens are used to identify a client and to allow Vault to associate policies and ACLs
 which are enforced on every request. This backend also allows for generating sub-tokens as well
 as revocation of tokens.`
-	tokenCreateHelp       = `The token create path is used to create new tokens.`
-	tokenLookupHelp       = `This endpoint will lookup a token and its properties.`
-	tokenRevokeHelp       = `This endpoint will delete the token and all of its child tokens.`
-	tokenRevokeOrphanHelp = `This endpoint will delete the token and orphan its child tokens.`
-	tokenRevokePrefixHelp = `This endpoint will delete all tokens generated under a prefix with their child tokens.`
-	tokenRenewHelp        = `This endpoint will renew the token and prevent expiration.`
+	tokenCreateHelp         = `The token create path is used to create new tokens.`
+	tokenLookupHelp         = `This endpoint will lookup a token and its properties.`
+	tokenLookupSelfHelp     = `This endpoint will lookup the token used to call it and its properties.`
+	tokenRevokeHelp         = `This endpoint will delete the token and all of its child tokens.`
+	tokenRevokeOrphanHelp   = `This endpoint will delete the token and orphan its child tokens.`
+	tokenRevokePrefixHelp   = `This endpoint will delete all tokens generated under a prefix with their child tokens.`
+	tokenListAccessorsHelp  = `This endpoint will list the accessors of all valid tokens with their display names and policies.`
+	tokenRevokeAccessorHelp = `This endpoint will delete the token with the given accessor and all of its child tokens.`
+	tokenRevokeSelfHelp     = `This endpoint will delete the token used to call it and all of its child tokens.`
+	tokenRenewHelp          = `This endpoint will renew the token and prevent expiration.`
+	tokenRenewSelfHelp      = `This endpoint will renew the token used to call it and prevent expiration.`
 )
@@ -1,17 +1,22 @@
 package vault
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/vault/helper/leveled-log"
 	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/physical"
 )
 
 func mockTokenStore(t *testing.T) (*Core, *TokenStore, string) {
-	logger := log.New(os.Stderr, "", log.LstdFlags)
+	logger := leveledlog.New(log.New(os.Stderr, "", log.LstdFlags))
 
 	c, _, root := TestCoreUnsealed(t)
 	ts, err := NewTokenStore(c)
@@ -122,6 +127,299 @@ func TestTokenStore_CreateLookup_ProvidedID(t *testing.T) {
 	}
 }
 
+func TestTokenStore_Lookup_MismatchedID(t *testing.T) {
+	_, ts, _ := mockTokenStore(t)
+
+	ent := &TokenEntry{Path: "test", Policies: []string{"dev"}}
+	if err := ts.Create(ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Store the entry under the salted ID of another token
+	raw, err := ts.view.Get(lookupPrefix + ts.SaltID(ent.ID))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	raw.Key = lookupPrefix + ts.SaltID("other")
+	if err := ts.view.Put(raw); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The entry must not be returned for the other token
+	out, err := ts.Lookup("other")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out != nil {
+		t.Fatalf("bad: %#v", out)
+	}
+}
+
+func TestTokenStore_Create_Salted(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+
+	ent := &TokenEntry{Parent: root, Path: "test", Policies: []string{"dev"}}
+	if err := ts.Create(ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Neither the ID nor the parent is stored in plaintext
+	for _, key := range []string{
+		lookupPrefix + ts.SaltID(ent.ID),
+		accessorPrefix + ts.SaltID(ent.Accessor),
+	} {
+		raw, err := ts.view.Get(key)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if raw == nil {
+			t.Fatalf("missing: %s", key)
+		}
+		if strings.Contains(string(raw.Value), ent.ID) || strings.Contains(string(raw.Value), root) {
+			t.Fatalf("bad: %s", raw.Value)
+		}
+	}
+}
+
+func TestTokenStore_UpgradeEntry(t *testing.T) {
+	c, ts, root := mockTokenStore(t)
+
+	// Write a token as it was stored with its plaintext ID and parent
+	id, accessor := generateUUID(), generateUUID()
+	raw := fmt.Sprintf(`{"ID":%q,"Parent":%q,"Policies":["dev"],"Path":"test","Accessor":%q}`,
+		id, root, accessor)
+	entries := []*logical.StorageEntry{
+		{Key: lookupPrefix + ts.SaltID(id), Value: []byte(raw)},
+		{Key: parentPrefix + ts.SaltID(root) + "/" + ts.SaltID(id)},
+		{Key: accessorPrefix + ts.SaltID(accessor), Value: []byte(id)},
+	}
+	for _, le := range entries {
+		if err := ts.view.Put(le); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// The entry is upgraded when the store is set up
+	ts, err := NewTokenStore(c)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ts.SetExpirationManager(c.expiration)
+	for _, le := range entries[:1] {
+		out, err := ts.view.Get(le.Key)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if strings.Contains(string(out.Value), id) || strings.Contains(string(out.Value), root) {
+			t.Fatalf("bad: %s", out.Value)
+		}
+	}
+
+	out, err := ts.Lookup(id)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out == nil || out.SaltedParent != ts.SaltID(root) || !reflect.DeepEqual(out.Policies, []string{"dev"}) {
+		t.Fatalf("bad: %#v", out)
+	}
+	out, err = ts.LookupByAccessor(accessor)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out == nil || out.SaltedID != ts.SaltID(id) {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	// The token is still revoked with its parent
+	if err := ts.RevokeTree(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	out, err = ts.Lookup(id)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out != nil {
+		t.Fatalf("bad: %#v", out)
+	}
+}
+
+func TestTokenStore_LookupByAccessor(t *testing.T) {
+	_, ts, _ := mockTokenStore(t)
+
+	ent := &TokenEntry{Path: "test", Policies: []string{"dev"}}
+	if err := ts.Create(ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if ent.Accessor == "" || ent.Accessor == ent.ID {
+		t.Fatalf("bad: %#v", ent)
+	}
+
+	out, err := ts.LookupByAccessor(ent.Accessor)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out == nil || out.SaltedID != ent.SaltedID || out.ID != "" {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	// The token ID is not an accessor
+	out, err = ts.LookupByAccessor(ent.ID)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out != nil {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	// The accessor is removed with the token
+	if err := ts.Revoke(ent.ID); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	out, err = ts.LookupByAccessor(ent.Accessor)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out != nil {
+		t.Fatalf("bad: %#v", out)
+	}
+}
+
+func TestTokenStore_List(t *testing.T) {
+	_, ts, _ := mockTokenStore(t)
+
+	ent1 := &TokenEntry{DisplayName: "one", Policies: []string{"foo"}}
+	if err := ts.Create(ent1); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ent2 := &TokenEntry{DisplayName: "two", Policies: []string{"bar"}}
+	if err := ts.Create(ent2); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Expired tokens are not listed
+	ent3 := &TokenEntry{
+		TTL:          time.Minute,
+		CreationTime: time.Now().UTC().Add(-time.Hour),
+	}
+	if err := ts.Create(ent3); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	out, err := ts.List()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The root token of the mock is listed as well
+	if len(out) != 3 {
+		t.Fatalf("bad: %#v", out)
+	}
+	found := make(map[string]*TokenListEntry)
+	for _, entry := range out {
+		found[entry.Accessor] = entry
+	}
+	if _, ok := found[ent3.Accessor]; ok {
+		t.Fatalf("bad: %#v", out)
+	}
+	expect := &TokenListEntry{
+		Accessor:    ent1.Accessor,
+		DisplayName: "one",
+		Policies:    []string{"foo"},
+	}
+	if !reflect.DeepEqual(found[ent1.Accessor], expect) {
+		t.Fatalf("bad: %#v", found[ent1.Accessor])
+	}
+	if !sort.IsSorted(tokenListByAccessor(out)) {
+		t.Fatalf("bad: %#v", out)
+	}
+}
+
+func TestTokenStore_Lookup_TTL(t *testing.T) {
+	_, ts, _ := mockTokenStore(t)
+
+	// A token whose TTL has elapsed is not returned
+	ent := &TokenEntry{
+		Path:         "test",
+		Policies:     []string{"dev"},
+		TTL:          time.Hour,
+		CreationTime: time.Now().UTC().Add(-2 * time.Hour),
+	}
+	if err := ts.Create(ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	out, err := ts.Lookup(ent.ID)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out != nil {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	// A token within its TTL is returned
+	ent = &TokenEntry{Path: "test", Policies: []string{"dev"}, TTL: time.Hour}
+	if err := ts.Create(ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	out, err = ts.Lookup(ent.ID)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out == nil {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	// A zero TTL never expires
+	ent = &TokenEntry{
+		Path:         "test",
+		Policies:     []string{"dev"},
+		CreationTime: time.Now().UTC().Add(-24 * 365 * time.Hour),
+	}
+	if err := ts.Create(ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	out, err = ts.Lookup(ent.ID)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out == nil {
+		t.Fatalf("bad: %#v", out)
+	}
+}
+
+func BenchmarkTokenStore_Lookup(b *testing.B) {
+	c, err := NewCore(&CoreConfig{
+		Physical:     physical.NewInmem(),
+		DisableMlock: true,
+	})
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+	result, err := c.Initialize(&SealConfig{
+		SecretShares:    1,
+		SecretThreshold: 1,
+	})
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+	if _, err := c.Unseal(result.SecretShares[0]); err != nil {
+		b.Fatalf("err: %v", err)
+	}
+
+	ts := c.tokenStore
+	ent := &TokenEntry{Path: "test", Policies: []string{"dev"}}
+	if err := ts.Create(ent); err != nil {
+		b.Fatalf("err: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		out, err := ts.Lookup(ent.ID)
+		if err != nil || out == nil {
+			b.Fatalf("err: %v %v", err, out)
+		}
+	}
+}
+
 func TestTokenStore_UseToken(t *testing.T) {
 	_, ts, root := mockTokenStore(t)
 
@@ -188,6 +486,98 @@ func TestTokenStore_UseToken(t *testing.T) {
 	}
 }
 
+func TestTokenStore_UseToken_Stale(t *testing.T) {
+	_, ts, _ := mockTokenStore(t)
+
+	ent := &TokenEntry{Path: "test", Policies: []string{"dev"}, NumUses: 2}
+	if err := ts.Create(ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Parallel requests each look up their own copy of the entry
+	var copies []*TokenEntry
+	for i := 0; i < 3; i++ {
+		out, err := ts.Lookup(ent.ID)
+		if err != nil || out == nil {
+			t.Fatalf("err: %v %v", err, out)
+		}
+		copies = append(copies, out)
+	}
+
+	// Only as many uses as allowed succeed
+	for i, te := range copies {
+		err := ts.UseToken(te)
+		if i < 2 && err != nil {
+			t.Fatalf("err: %d %v", i, err)
+		}
+		if i == 2 && err != errTokenUsesExhausted {
+			t.Fatalf("err: %d %v", i, err)
+		}
+	}
+	out, err := ts.Lookup(ent.ID)
+	if err != nil || out != nil {
+		t.Fatalf("bad: %v %#v", err, out)
+	}
+}
+
+func TestTokenStore_Tidy(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+
+	child := &TokenEntry{Path: "test", Policies: []string{"dev"}, Parent: root}
+	if err := ts.Create(child); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expired := &TokenEntry{
+		Path:         "test",
+		Policies:     []string{"dev"},
+		CreationTime: time.Now().UTC().Add(-time.Hour),
+		TTL:          time.Minute,
+	}
+	if err := ts.Create(expired); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Index entries left behind by an interrupted revocation
+	dangling := []string{
+		parentPrefix + ts.SaltID(root) + "/" + ts.SaltID("missing"),
+		accessorPrefix + ts.SaltID("accessor"),
+	}
+	for _, path := range dangling {
+		le := &logical.StorageEntry{Key: path, Value: []byte("missing")}
+		if err := ts.view.Put(le); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	n, err := ts.Tidy()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("bad: %d", n)
+	}
+	for _, path := range append(dangling, lookupPrefix+ts.SaltID(expired.ID)) {
+		if out, err := ts.view.Get(path); err != nil || out != nil {
+			t.Fatalf("bad: %s %v %v", path, err, out)
+		}
+	}
+
+	// The live tokens and their indexes are kept
+	if out, err := ts.LookupByAccessor(child.Accessor); err != nil || out == nil {
+		t.Fatalf("bad: %v %v", err, out)
+	}
+	children, err := ts.view.List(parentPrefix + ts.SaltID(root) + "/")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(children, []string{ts.SaltID(child.ID)}) {
+		t.Fatalf("bad: %#v", children)
+	}
+	if n, err := ts.Tidy(); err != nil || n != 0 {
+		t.Fatalf("bad: %d %v", n, err)
+	}
+}
+
 func TestTokenStore_Revoke(t *testing.T) {
 	_, ts, _ := mockTokenStore(t)
 
@@ -214,6 +604,43 @@ func TestTokenStore_Revoke(t *testing.T) {
 	}
 }
 
+func TestTokenStore_NumTokens(t *testing.T) {
+	_, ts, _ := mockTokenStore(t)
+	base := ts.numTokens()
+
+	ent := &TokenEntry{Path: "test", Policies: []string{"dev"}}
+	if err := ts.Create(ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if num := ts.numTokens(); num != base+1 {
+		t.Fatalf("bad: %d", num)
+	}
+
+	// Replacing a token with a given ID does not count it twice
+	ent2 := &TokenEntry{ID: ent.ID, Path: "test", Policies: []string{"ops"}}
+	if err := ts.Create(ent2); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if num := ts.numTokens(); num != base+1 {
+		t.Fatalf("bad: %d", num)
+	}
+
+	if err := ts.Revoke(ent.ID); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if num := ts.numTokens(); num != base {
+		t.Fatalf("bad: %d", num)
+	}
+
+	// Revoking a missing token does not change the count
+	if err := ts.Revoke(ent.ID); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if num := ts.numTokens(); num != base {
+		t.Fatalf("bad: %d", num)
+	}
+}
+
 func TestTokenStore_Revoke_Leases(t *testing.T) {
 	_, ts, _ := mockTokenStore(t)
 
@@ -286,6 +713,9 @@ func TestTokenStore_Revoke_Orphan(t *testing.T) {
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
+
+	// Only the salted parent is stored
+	ent2.Parent = ""
 	if !reflect.DeepEqual(out, ent2) {
 		t.Fatalf("bad: %#v", out)
 	}
@@ -335,29 +765,68 @@ func TestTokenStore_RevokeTree(t *testing.T) {
 	}
 }
 
-func TestTokenStore_HandleRequest_CreateToken_DisplayName(t *testing.T) {
-	_, ts, root := mockTokenStore(t)
-
-	req := logical.TestRequest(t, logical.WriteOperation, "create")
-	req.ClientToken = root
-	req.Data["display_name"] = "foo_bar.baz!"
+func TestTokenStore_RevokeTree_Cycle(t *testing.T) {
+	_, ts, _ := mockTokenStore(t)
 
-	resp, err := ts.HandleRequest(req)
-	if err != nil {
+	ent1 := &TokenEntry{}
+	if err := ts.Create(ent1); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	ent2 := &TokenEntry{Parent: ent1.ID}
+	if err := ts.Create(ent2); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Corrupt the parent index so that ent1 is also a child of ent2
+	le := &logical.StorageEntry{
+		Key: parentPrefix + ts.SaltID(ent2.ID) + "/" + ts.SaltID(ent1.ID),
+	}
+	if err := ts.view.Put(le); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := ts.RevokeTree(ent1.ID); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for _, id := range []string{ent1.ID, ent2.ID} {
+		out, err := ts.Lookup(id)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if out != nil {
+			t.Fatalf("bad: %#v", out)
+		}
+	}
+}
+
+func TestTokenStore_HandleRequest_CreateToken_DisplayName(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+
+	req := logical.TestRequest(t, logical.WriteOperation, "create")
+	req.ClientToken = root
+	req.Data["display_name"] = "foo_bar.baz!"
+
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
 		t.Fatalf("err: %v %v", err, resp)
 	}
 
 	expected := &TokenEntry{
-		ID:          resp.Auth.ClientToken,
-		Parent:      root,
-		Policies:    []string{"root"},
-		Path:        "auth/token/create",
-		DisplayName: "token-foo-bar-baz",
+		ID:           resp.Auth.ClientToken,
+		SaltedID:     ts.SaltID(resp.Auth.ClientToken),
+		SaltedParent: ts.SaltID(root),
+		Policies:     []string{"root"},
+		Path:         "auth/token/create",
+		DisplayName:  "token-foo-bar-baz",
 	}
 	out, err := ts.Lookup(resp.Auth.ClientToken)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
+	expected.CreationTime = out.CreationTime
+	expected.Accessor = out.Accessor
 	if !reflect.DeepEqual(out, expected) {
 		t.Fatalf("bad: %#v", out)
 	}
@@ -376,17 +845,20 @@ func TestTokenStore_HandleRequest_CreateToken_NumUses(t *testing.T) {
 	}
 
 	expected := &TokenEntry{
-		ID:          resp.Auth.ClientToken,
-		Parent:      root,
-		Policies:    []string{"root"},
-		Path:        "auth/token/create",
-		DisplayName: "token",
-		NumUses:     1,
+		ID:           resp.Auth.ClientToken,
+		SaltedID:     ts.SaltID(resp.Auth.ClientToken),
+		SaltedParent: ts.SaltID(root),
+		Policies:     []string{"root"},
+		Path:         "auth/token/create",
+		DisplayName:  "token",
+		NumUses:      1,
 	}
 	out, err := ts.Lookup(resp.Auth.ClientToken)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
+	expected.CreationTime = out.CreationTime
+	expected.Accessor = out.Accessor
 	if !reflect.DeepEqual(out, expected) {
 		t.Fatalf("bad: %#v", out)
 	}
@@ -437,16 +909,19 @@ func TestTokenStore_HandleRequest_CreateToken_NoPolicy(t *testing.T) {
 	}
 
 	expected := &TokenEntry{
-		ID:          resp.Auth.ClientToken,
-		Parent:      root,
-		Policies:    []string{"root"},
-		Path:        "auth/token/create",
-		DisplayName: "token",
+		ID:           resp.Auth.ClientToken,
+		SaltedID:     ts.SaltID(resp.Auth.ClientToken),
+		SaltedParent: ts.SaltID(root),
+		Policies:     []string{"root"},
+		Path:         "auth/token/create",
+		DisplayName:  "token",
 	}
 	out, err := ts.Lookup(resp.Auth.ClientToken)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
+	expected.CreationTime = out.CreationTime
+	expected.Accessor = out.Accessor
 	if !reflect.DeepEqual(out, expected) {
 		t.Fatalf("bad: %#v", out)
 	}
@@ -483,6 +958,45 @@ func TestTokenStore_HandleRequest_CreateToken(t *testing.T) {
 	}
 }
 
+func TestTokenStore_HandleRequest_CreateToken_DefaultPolicy(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+
+	req := logical.TestRequest(t, logical.WriteOperation, "create")
+	req.ClientToken = root
+	req.Data["policies"] = []string{"foo"}
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Auth.Policies, []string{"foo", "default"}) {
+		t.Fatalf("bad: %#v", resp.Auth.Policies)
+	}
+
+	// The default policy can be opted out of
+	req = logical.TestRequest(t, logical.WriteOperation, "create")
+	req.ClientToken = root
+	req.Data["policies"] = []string{"foo"}
+	req.Data["no_default_policy"] = true
+	resp, err = ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Auth.Policies, []string{"foo"}) {
+		t.Fatalf("bad: %#v", resp.Auth.Policies)
+	}
+
+	// Root tokens are not given the default policy
+	req = logical.TestRequest(t, logical.WriteOperation, "create")
+	req.ClientToken = root
+	resp, err = ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Auth.Policies, []string{"root"}) {
+		t.Fatalf("bad: %#v", resp.Auth.Policies)
+	}
+}
+
 func TestTokenStore_HandleRequest_CreateToken_RootID(t *testing.T) {
 	_, ts, root := mockTokenStore(t)
 
@@ -552,6 +1066,39 @@ func TestTokenStore_HandleRequest_CreateToken_NonRoot_InvalidSubset(t *testing.T
 	}
 }
 
+func TestTokenStore_HandleRequest_CreateToken_RequireExplicitPolicy(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+	ts.requireExplicitPolicy = true
+	testMakeToken(t, ts, root, "client", []string{"default", "foo"})
+
+	req := logical.TestRequest(t, logical.WriteOperation, "create")
+	req.ClientToken = "client"
+	req.Data["policies"] = []string{"default"}
+
+	resp, err := ts.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp.Data["error"] != errNoExplicitPolicy.Error() {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// An explicit policy is allowed
+	req.Data["policies"] = []string{"default", "foo"}
+	resp, err = ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+
+	// Root tokens are exempt
+	req = logical.TestRequest(t, logical.WriteOperation, "create")
+	req.ClientToken = root
+	resp, err = ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+}
+
 func TestTokenStore_HandleRequest_CreateToken_NonRoot_NoParent(t *testing.T) {
 	_, ts, root := mockTokenStore(t)
 	testMakeToken(t, ts, root, "client", []string{"foo"})
@@ -592,6 +1139,62 @@ func TestTokenStore_HandleRequest_CreateToken_Root_NoParent(t *testing.T) {
 	}
 }
 
+func TestTokenStore_HandleRequest_CreateToken_NonRoot_Orphan(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+	testMakeToken(t, ts, root, "client", []string{"foo"})
+
+	req := logical.TestRequest(t, logical.WriteOperation, "create")
+	req.ClientToken = "client"
+	req.Data["orphan"] = true
+	req.Data["policies"] = []string{"foo"}
+
+	resp, err := ts.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp.Data["error"] != "root required to create orphan token" {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
+func TestTokenStore_HandleRequest_CreateToken_Root_Orphan(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+	testMakeToken(t, ts, root, "parent", []string{"root"})
+
+	req := logical.TestRequest(t, logical.WriteOperation, "create")
+	req.ClientToken = "parent"
+	req.Data["orphan"] = true
+	req.Data["policies"] = []string{"foo"}
+
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	orphan := resp.Auth.ClientToken
+
+	// The orphan is reflected in lookups
+	req = logical.TestRequest(t, logical.ReadOperation, "lookup/"+orphan)
+	resp, err = ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp.Data["orphan"] != true {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// The orphan survives the revocation of its creator
+	if err := ts.RevokeTree("parent"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	out, err := ts.Lookup(orphan)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out == nil || out.Parent != "" {
+		t.Fatalf("bad: %#v", out)
+	}
+}
+
 func TestTokenStore_HandleRequest_CreateToken_Metadata(t *testing.T) {
 	_, ts, root := mockTokenStore(t)
 
@@ -641,6 +1244,168 @@ func TestTokenStore_HandleRequest_CreateToken_Lease(t *testing.T) {
 	}
 }
 
+func TestTokenStore_HandleRequest_CreateToken_TTL(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+
+	req := logical.TestRequest(t, logical.WriteOperation, "create")
+	req.ClientToken = root
+	req.Data["ttl"] = "1h"
+
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+
+	out, err := ts.Lookup(resp.Auth.ClientToken)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out == nil || out.TTL != time.Hour || out.CreationTime.IsZero() {
+		t.Fatalf("bad: %#v", out)
+	}
+}
+
+func TestTokenStore_HandleRequest_CreateToken_TTL_Invalid(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+
+	req := logical.TestRequest(t, logical.WriteOperation, "create")
+	req.ClientToken = root
+	req.Data["ttl"] = "-1h"
+
+	resp, err := ts.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+}
+
+func TestTokenStore_HandleRequest_Roles(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+
+	req := logical.TestRequest(t, logical.WriteOperation, "roles/test")
+	req.ClientToken = root
+	req.Data["allowed_policies"] = "foo, bar"
+	req.Data["orphan"] = true
+	req.Data["ttl"] = "1h"
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+
+	req = logical.TestRequest(t, logical.ReadOperation, "roles/test")
+	req.ClientToken = root
+	resp, err = ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	exp := map[string]interface{}{
+		"allowed_policies": []string{"foo", "bar"},
+		"orphan":           true,
+		"ttl":              int64(3600),
+	}
+	if !reflect.DeepEqual(resp.Data, exp) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	req = logical.TestRequest(t, logical.ListOperation, "roles/")
+	req.ClientToken = root
+	resp, err = ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if keys := resp.Data["keys"].([]string); !reflect.DeepEqual(keys, []string{"test"}) {
+		t.Fatalf("bad: %#v", keys)
+	}
+
+	req = logical.TestRequest(t, logical.DeleteOperation, "roles/test")
+	req.ClientToken = root
+	if resp, err := ts.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	req = logical.TestRequest(t, logical.ReadOperation, "roles/test")
+	req.ClientToken = root
+	resp, err = ts.HandleRequest(req)
+	if err != nil || resp != nil {
+		t.Fatalf("bad: %v %#v", err, resp)
+	}
+}
+
+func TestTokenStore_HandleRequest_Roles_Root(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+
+	req := logical.TestRequest(t, logical.WriteOperation, "roles/test")
+	req.ClientToken = root
+	req.Data["allowed_policies"] = "foo,root"
+	resp, err := ts.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+}
+
+func TestTokenStore_HandleRequest_CreateToken_Role(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+	testMakeToken(t, ts, root, "client", []string{"foo"})
+
+	req := logical.TestRequest(t, logical.WriteOperation, "roles/test")
+	req.ClientToken = root
+	req.Data["allowed_policies"] = "bar,baz"
+	req.Data["orphan"] = true
+	req.Data["ttl"] = "1h"
+	if resp, err := ts.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+
+	// The policies need not be held by the client, only allowed by the role
+	req = logical.TestRequest(t, logical.WriteOperation, "create/test")
+	req.ClientToken = "client"
+	req.Data["policies"] = []string{"bar"}
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	out, err := ts.Lookup(resp.Auth.ClientToken)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out.TTL != time.Hour || out.Parent != "" || out.Path != "auth/token/create/test" {
+		t.Fatalf("bad: %#v", out)
+	}
+	if !reflect.DeepEqual(out.Policies, []string{"bar", "default"}) {
+		t.Fatalf("bad: %#v", out.Policies)
+	}
+
+	// The policies default to those allowed by the role
+	req = logical.TestRequest(t, logical.WriteOperation, "create/test")
+	req.ClientToken = "client"
+	resp, err = ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	out, err = ts.Lookup(resp.Auth.ClientToken)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(out.Policies, []string{"bar", "baz", "default"}) {
+		t.Fatalf("bad: %#v", out.Policies)
+	}
+
+	// Policies outside the role are rejected, even if held by the client
+	req = logical.TestRequest(t, logical.WriteOperation, "create/test")
+	req.ClientToken = "client"
+	req.Data["policies"] = []string{"foo"}
+	resp, err = ts.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+
+	// Unknown roles are rejected
+	req = logical.TestRequest(t, logical.WriteOperation, "create/unknown")
+	req.ClientToken = "client"
+	resp, err = ts.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+}
+
 func TestTokenStore_HandleRequest_Revoke(t *testing.T) {
 	_, ts, root := mockTokenStore(t)
 	testMakeToken(t, ts, root, "child", []string{"root", "foo"})
@@ -673,6 +1438,95 @@ func TestTokenStore_HandleRequest_Revoke(t *testing.T) {
 	}
 }
 
+func TestTokenStore_HandleRequest_RevokeSelf(t *testing.T) {
+	c, _, root := mockTokenStore(t)
+	testCoreMakeToken(t, c, root, "child", []string{"foo"})
+
+	// The default policy permits the token to revoke itself
+	req := logical.TestRequest(t, logical.WriteOperation, "auth/token/revoke-self")
+	req.ClientToken = "child"
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp != nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	out, err := c.tokenStore.Lookup("child")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out != nil {
+		t.Fatalf("bad: %v", out)
+	}
+}
+
+func TestTokenStore_HandleRequest_ListAccessors(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+
+	rootEntry, err := ts.Lookup(root)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.ListOperation, "accessors")
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+
+	exp := map[string]interface{}{
+		"keys": []string{rootEntry.Accessor},
+		"key_info": map[string]interface{}{
+			rootEntry.Accessor: map[string]interface{}{
+				"display_name": "root",
+				"policies":     []string{"root"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(resp.Data, exp) {
+		t.Fatalf("bad: %#v exp: %#v", resp.Data, exp)
+	}
+}
+
+func TestTokenStore_HandleRequest_RevokeAccessor(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+	testMakeToken(t, ts, root, "child", []string{"root"})
+	testMakeToken(t, ts, "child", "sub-child", []string{"foo"})
+
+	child, err := ts.Lookup("child")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.WriteOperation, "revoke-accessor/"+child.Accessor)
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp != nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// The token and its children are revoked
+	for _, id := range []string{"child", "sub-child"} {
+		out, err := ts.Lookup(id)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if out != nil {
+			t.Fatalf("bad: %#v", out)
+		}
+	}
+
+	// Unknown accessors are rejected
+	resp, err = ts.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+}
+
 func TestTokenStore_HandleRequest_RevokeOrphan(t *testing.T) {
 	_, ts, root := mockTokenStore(t)
 	testMakeToken(t, ts, root, "child", []string{"root", "foo"})
@@ -716,13 +1570,22 @@ func TestTokenStore_HandleRequest_Lookup(t *testing.T) {
 		t.Fatalf("bad: %#v", resp)
 	}
 
+	rootEntry, err := ts.Lookup(root)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
 	exp := map[string]interface{}{
-		"id":           root,
-		"policies":     []string{"root"},
-		"path":         "auth/token/root",
-		"meta":         map[string]string(nil),
-		"display_name": "root",
-		"num_uses":     0,
+		"id":            root,
+		"policies":      []string{"root"},
+		"path":          "auth/token/root",
+		"meta":          map[string]string(nil),
+		"display_name":  "root",
+		"num_uses":      0,
+		"ttl":           int64(0),
+		"creation_time": rootEntry.CreationTime.Unix(),
+		"accessor":      rootEntry.Accessor,
+		"orphan":        true,
 	}
 	if !reflect.DeepEqual(resp.Data, exp) {
 		t.Fatalf("bad: %#v exp: %#v", resp.Data, exp)
@@ -781,19 +1644,45 @@ func TestTokenStore_HandleRequest_LookupSelf(t *testing.T) {
 		t.Fatalf("bad: %#v", resp)
 	}
 
+	rootEntry, err := ts.Lookup(root)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
 	exp := map[string]interface{}{
-		"id":           root,
-		"policies":     []string{"root"},
-		"path":         "auth/token/root",
-		"meta":         map[string]string(nil),
-		"display_name": "root",
-		"num_uses":     0,
+		"id":            root,
+		"policies":      []string{"root"},
+		"path":          "auth/token/root",
+		"meta":          map[string]string(nil),
+		"display_name":  "root",
+		"num_uses":      0,
+		"ttl":           int64(0),
+		"creation_time": rootEntry.CreationTime.Unix(),
+		"accessor":      rootEntry.Accessor,
+		"orphan":        true,
 	}
 	if !reflect.DeepEqual(resp.Data, exp) {
 		t.Fatalf("bad: %#v exp: %#v", resp.Data, exp)
 	}
 }
 
+func TestTokenStore_HandleRequest_LookupSelf_OtherToken(t *testing.T) {
+	_, ts, root := mockTokenStore(t)
+	testMakeToken(t, ts, root, "client", []string{"foo"})
+
+	// The token given in the request is ignored
+	req := logical.TestRequest(t, logical.ReadOperation, "lookup-self")
+	req.ClientToken = "client"
+	req.Data = map[string]interface{}{"token": root}
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp == nil || resp.Data["id"] != "client" {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
 func TestTokenStore_HandleRequest_Renew(t *testing.T) {
 	exp := mockExpiration(t)
 	ts := exp.tokenStore
@@ -835,6 +1724,138 @@ func TestTokenStore_HandleRequest_Renew(t *testing.T) {
 	}
 }
 
+func TestTokenStore_HandleRequest_RenewSelf(t *testing.T) {
+	exp := mockExpiration(t)
+	ts := exp.tokenStore
+
+	// Create new token
+	root, err := ts.RootToken()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Create a new token
+	auth := &logical.Auth{
+		ClientToken: root.ID,
+		LeaseOptions: logical.LeaseOptions{
+			Lease:     time.Hour,
+			Renewable: true,
+		},
+	}
+	err = exp.RegisterAuth("auth/token/root", auth)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Get the original expire time to compare
+	originalExpire := auth.ExpirationTime()
+
+	req := logical.TestRequest(t, logical.WriteOperation, "renew-self")
+	req.ClientToken = root.ID
+	req.Data["increment"] = "3600"
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+
+	// Get the new expire time
+	newExpire := resp.Auth.ExpirationTime()
+	expireDiff := newExpire.Sub(originalExpire)
+	if expireDiff < 30*time.Minute || expireDiff > 3*time.Hour {
+		t.Fatalf("bad: %#v", expireDiff)
+	}
+}
+
+func TestTokenStore_HandleRequest_Renew_MaxLease(t *testing.T) {
+	c, ts, _ := mockTokenStore(t)
+	exp := ts.expiration
+	if err := c.SetLeaseConfig(time.Hour, 2*time.Hour); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	root, err := ts.RootToken()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	auth := &logical.Auth{
+		ClientToken: root.ID,
+		LeaseOptions: logical.LeaseOptions{
+			Lease:     time.Hour,
+			Renewable: true,
+		},
+	}
+	if err := exp.RegisterAuth("auth/token/root", auth); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The increment is bounded by the max lease
+	req := logical.TestRequest(t, logical.WriteOperation, "renew/"+root.ID)
+	req.Data["increment"] = "36000"
+	resp, err := ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp.Auth.ExpirationTime().After(time.Now().Add(2 * time.Hour)) {
+		t.Fatalf("bad: %#v", resp.Auth)
+	}
+}
+
+func TestTokenStore_HandleRequest_Renew_NotRenewable(t *testing.T) {
+	exp := mockExpiration(t)
+	ts := exp.tokenStore
+
+	root, err := ts.RootToken()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	auth := &logical.Auth{
+		ClientToken: root.ID,
+		LeaseOptions: logical.LeaseOptions{
+			Lease: time.Hour,
+		},
+	}
+	if err := exp.RegisterAuth("auth/token/root", auth); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.WriteOperation, "renew/"+root.ID)
+	resp, err := ts.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+}
+
+func TestTokenStore_HandleRequest_Renew_Expired(t *testing.T) {
+	exp := mockExpiration(t)
+	ts := exp.tokenStore
+
+	ent := &TokenEntry{
+		Path:         "auth/token/create",
+		Policies:     []string{"root"},
+		TTL:          time.Minute,
+		CreationTime: time.Now().UTC().Add(-time.Hour),
+	}
+	if err := ts.Create(ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	auth := &logical.Auth{
+		ClientToken: ent.ID,
+		LeaseOptions: logical.LeaseOptions{
+			Lease:     time.Hour,
+			Renewable: true,
+		},
+	}
+	if err := exp.RegisterAuth(ent.Path, auth); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := logical.TestRequest(t, logical.WriteOperation, "renew/"+ent.ID)
+	resp, err := ts.HandleRequest(req)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+}
+
 func testMakeToken(t *testing.T, ts *TokenStore, root, client string, policy []string) {
 	req := logical.TestRequest(t, logical.WriteOperation, "create")
 	req.ClientToken = root
@@ -0,0 +1,230 @@
+package vault
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/logical"
+)
+
+var (
+	// verifyMountBatchSize is the number of entries that are verified
+	// before pausing, so that verifying a large mount or the barrier
+	// does not flood the physical backend with reads.
+	verifyMountBatchSize = 64
+
+	// verifyMountPause is how long to pause between batches
+	verifyMountPause = 50 * time.Millisecond
+)
+
+// MountVerification is the result of verifying the entries of a mount
+type MountVerification struct {
+	// Path is the mount that was verified
+	Path string
+
+	// Checked is the number of entries that were verified
+	Checked int
+
+	// Failed maps the key of each entry that could not be read back
+	// to the reason. The reason never includes any of the plaintext.
+	Failed map[string]string
+}
+
+// VerifyMount is used by a root token to verify that every entry stored
+// by the mount at the given path can still be decrypted and decoded.
+// This is intended for recovering from a suspected corruption of the
+// storage backend. Entries are verified in batches to avoid overloading
+// the backend, and the seal is not held between batches.
+func (c *Core) VerifyMount(token, mountPath string) (*MountVerification, error) {
+	defer metrics.MeasureSince([]string{"core", "verify_mount"}, time.Now())
+	c.stateLock.RLock()
+	if c.sealed {
+		c.stateLock.RUnlock()
+		return nil, ErrSealed
+	}
+	if c.standby {
+		c.stateLock.RUnlock()
+		return nil, ErrStandby
+	}
+
+	// Ensure we end the path in a slash
+	if !strings.HasSuffix(mountPath, "/") {
+		mountPath += "/"
+	}
+
+	// Find the view of the mount
+	if match := c.router.MatchingMount(mountPath); match != mountPath {
+		c.stateLock.RUnlock()
+		return nil, fmt.Errorf("no mount at '%s'", mountPath)
+	}
+	view := c.router.MatchingView(mountPath)
+
+	// Verifying requires access to the raw storage of the mount, so
+	// it is limited to the tokens able to read it directly
+	auth, err := c.checkToken(logical.ReadOperation, "sys/raw/"+view.prefix, token)
+	if err != nil {
+		c.stateLock.RUnlock()
+		return nil, err
+	}
+
+	// Create an audit trail of the verification
+	req := &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "sys/verify/" + mountPath,
+		ClientToken: token,
+		DisplayName: auth.DisplayName,
+	}
+	if err := c.auditBroker.LogRequest(auth, req); err != nil {
+		c.stateLock.RUnlock()
+		c.logger.Error("core: failed to audit request (%#v): %v",
+			req, err)
+		return nil, ErrInternalError
+	}
+
+	keys, err := CollectKeys(view)
+	c.stateLock.RUnlock()
+	if err != nil {
+		c.logger.Error("core: failed to list mount '%s': %v", mountPath, err)
+		return nil, fmt.Errorf("failed to list entries: %v", err)
+	}
+
+	result := &MountVerification{Path: mountPath}
+	result.Checked, result.Failed, err = c.verifyKeys(keys, func(key string) (bool, error) {
+		entry, err := view.Get(key)
+		return entry != nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Failed) > 0 {
+		c.logger.Warn("core: verified mount '%s': %d of %d entries failed",
+			mountPath, len(result.Failed), result.Checked)
+	} else {
+		c.logger.Info("core: verified mount '%s': %d entries",
+			mountPath, result.Checked)
+	}
+	return result, nil
+}
+
+// BarrierVerification is the result of verifying the entries of the
+// barrier
+type BarrierVerification struct {
+	// Checked is the number of entries that were verified
+	Checked int
+
+	// Failed maps the key of each entry that could not be decrypted to
+	// the reason. The reason never includes any of the plaintext.
+	Failed map[string]string
+}
+
+// VerifyBarrier is used by a root token to verify that every entry of
+// the barrier can still be decrypted, which also checks its integrity.
+// This is intended to check a Vault after an upgrade or a suspected
+// corruption of the storage backend, rather than finding a corrupted
+// entry when it is next read. A failed entry does not stop the scan.
+// Entries are verified in batches to avoid overloading the backend, and
+// the seal is not held between batches.
+func (c *Core) VerifyBarrier(token string) (*BarrierVerification, error) {
+	defer metrics.MeasureSince([]string{"core", "verify_barrier"}, time.Now())
+	c.stateLock.RLock()
+	if c.sealed {
+		c.stateLock.RUnlock()
+		return nil, ErrSealed
+	}
+	if c.standby {
+		c.stateLock.RUnlock()
+		return nil, ErrStandby
+	}
+
+	// Validate the token is a root token
+	auth, err := c.checkToken(logical.WriteOperation, "sys/verify", token)
+	if err != nil {
+		c.stateLock.RUnlock()
+		return nil, err
+	}
+
+	// Create an audit trail of the verification
+	req := &logical.Request{
+		Operation:   logical.WriteOperation,
+		Path:        "sys/verify",
+		ClientToken: token,
+		DisplayName: auth.DisplayName,
+	}
+	if err := c.auditBroker.LogRequest(auth, req); err != nil {
+		c.stateLock.RUnlock()
+		c.logger.Error("core: failed to audit request (%#v): %v",
+			req, err)
+		return nil, ErrInternalError
+	}
+
+	keys, err := collectBarrierKeys(c.barrier, "", "")
+	c.stateLock.RUnlock()
+	if err != nil {
+		c.logger.Error("core: failed to list the barrier: %v", err)
+		return nil, fmt.Errorf("failed to list entries: %v", err)
+	}
+
+	result := &BarrierVerification{}
+	result.Checked, result.Failed, err = c.verifyKeys(keys, func(key string) (bool, error) {
+		entry, err := c.barrier.Get(key)
+		return entry != nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Failed) > 0 {
+		c.logger.Warn("core: verified barrier: %d of %d entries failed",
+			len(result.Failed), result.Checked)
+	} else {
+		c.logger.Info("core: verified barrier: %d entries", result.Checked)
+	}
+	return result, nil
+}
+
+// verifyKeys reads back the given keys in batches, returning the number
+// of keys checked and the reason each failed key could not be read. Keys
+// deleted since they were listed are skipped.
+func (c *Core) verifyKeys(keys []string,
+	get func(key string) (bool, error)) (int, map[string]string, error) {
+	checked := 0
+	failed := make(map[string]string)
+	for len(keys) > 0 {
+		n := verifyMountBatchSize
+		if n > len(keys) {
+			n = len(keys)
+		}
+		if err := c.verifyBatch(keys[:n], get, &checked, failed); err != nil {
+			return 0, nil, err
+		}
+		keys = keys[n:]
+		if len(keys) > 0 {
+			time.Sleep(verifyMountPause)
+		}
+	}
+	return checked, failed, nil
+}
+
+// verifyBatch reads back the given keys, recording the keys that fail
+func (c *Core) verifyBatch(keys []string, get func(key string) (bool, error),
+	checked *int, failed map[string]string) error {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.sealed {
+		return ErrSealed
+	}
+
+	for _, key := range keys {
+		exists, err := get(key)
+		if err != nil {
+			failed[key] = err.Error()
+		} else if !exists {
+			continue
+		}
+		*checked++
+	}
+	return nil
+}
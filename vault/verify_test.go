@@ -0,0 +1,171 @@
+package vault
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/physical"
+)
+
+func TestCore_VerifyMount(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	// Verify in small batches to exercise the throttling
+	oldSize, oldPause := verifyMountBatchSize, verifyMountPause
+	verifyMountBatchSize, verifyMountPause = 2, time.Millisecond
+	defer func() {
+		verifyMountBatchSize, verifyMountPause = oldSize, oldPause
+	}()
+
+	for i := 0; i < 5; i++ {
+		req := logical.TestRequest(t, logical.WriteOperation, fmt.Sprintf("secret/foo%d", i))
+		req.Data["value"] = "bar"
+		req.ClientToken = root
+		if _, err := c.HandleRequest(req); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	result, err := c.VerifyMount(root, "secret")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if result.Path != "secret/" || result.Checked != 5 || len(result.Failed) != 0 {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// Corrupt one of the entries in the physical backend
+	view := c.router.MatchingView("secret/")
+	pe, err := c.physical.Get(view.prefix + "foo3")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	pe.Value[len(pe.Value)-1] ^= 0xff
+	if err := c.physical.Put(&physical.Entry{Key: pe.Key, Value: pe.Value}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	result, err = c.VerifyMount(root, "secret/")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if result.Checked != 5 || len(result.Failed) != 1 || result.Failed["foo3"] == "" {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// Only existing mounts can be verified
+	if _, err := c.VerifyMount(root, "nope"); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestCore_VerifyMount_NonRoot(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	// Create a non-root child token
+	req := &logical.Request{
+		Operation:   logical.WriteOperation,
+		Path:        "auth/token/create",
+		ClientToken: root,
+		Data: map[string]interface{}{
+			"policies": []string{"foo"},
+		},
+	}
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	child := resp.Auth.ClientToken
+
+	if _, err := c.VerifyMount(child, "secret/"); err != logical.ErrPermissionDenied {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestCore_VerifyBarrier(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	// Verify in small batches to exercise the throttling
+	oldSize, oldPause := verifyMountBatchSize, verifyMountPause
+	verifyMountBatchSize, verifyMountPause = 2, time.Millisecond
+	defer func() {
+		verifyMountBatchSize, verifyMountPause = oldSize, oldPause
+	}()
+
+	req := logical.TestRequest(t, logical.WriteOperation, "secret/foo")
+	req.Data["value"] = "bar"
+	req.ClientToken = root
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	result, err := c.VerifyBarrier(root)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if result.Checked == 0 || len(result.Failed) != 0 {
+		t.Fatalf("bad: %#v", result)
+	}
+	checked := result.Checked
+
+	// Corrupt the tag of the entry, and the entry of the mount table
+	view := c.router.MatchingView("secret/")
+	corrupt := []string{view.prefix + "foo", coreMountConfigPath}
+	for _, key := range corrupt {
+		pe, err := c.physical.Get(key)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		pe.Value[len(pe.Value)-1] ^= 0xff
+		if err := c.physical.Put(&physical.Entry{Key: pe.Key, Value: pe.Value}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// Every entry is still checked
+	result, err = c.VerifyBarrier(root)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if result.Checked != checked || len(result.Failed) != 2 {
+		t.Fatalf("bad: %#v", result)
+	}
+	for _, key := range corrupt {
+		if result.Failed[key] == "" {
+			t.Fatalf("bad: %#v", result)
+		}
+	}
+}
+
+func TestCore_VerifyBarrier_MasterKeySource(t *testing.T) {
+	// The sealed master key is stored outside of the barrier, and looks
+	// like an entry encrypted with the first key
+	c := TestCore(t)
+	c.masterKeySource = &testMasterKeySource{prefix: []byte{0, 0, 0, 1, aesgcmVersionByte}}
+	key, root := TestCoreInit(t, c)
+	if _, err := c.Unseal(TestKeyCopy(key)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	result, err := c.VerifyBarrier(root)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if result.Checked == 0 || len(result.Failed) != 0 {
+		t.Fatalf("bad: %#v", result)
+	}
+	if _, ok := result.Failed[coreMasterKeyPath]; ok {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestCore_VerifyBarrier_NonRoot(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	testCoreMakeToken(t, c, root, "child", []string{"foo"})
+
+	if _, err := c.VerifyBarrier("child"); err != logical.ErrPermissionDenied {
+		t.Fatalf("err: %v", err)
+	}
+}
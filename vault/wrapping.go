@@ -0,0 +1,199 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/logical"
+)
+
+const (
+	// wrappingTokenPath is the path recorded in wrapping tokens, which
+	// distinguishes them from other tokens
+	wrappingTokenPath = "sys/wrapping/wrap"
+
+	// wrappingUnwrapPath is the path unwraps are audited under
+	wrappingUnwrapPath = "sys/wrapping/unwrap"
+
+	// wrappedResponseKey is the key of the wrapped response in the
+	// cubbyhole of the wrapping token
+	wrappedResponseKey = "response"
+)
+
+// ErrInvalidWrappingToken is returned if a response is unwrapped with a
+// token that is not a wrapping token, or was already used
+var ErrInvalidWrappingToken = errors.New("invalid or expired wrapping token")
+
+// maybeWrapResponse wraps a successful response if the request asked
+// for it. The response is audited before it is wrapped.
+func (c *Core) maybeWrapResponse(req *logical.Request,
+	resp *logical.Response, err error) (*logical.Response, error) {
+	if req.WrapTTL <= 0 || err != nil || resp == nil || resp.IsError() {
+		return resp, err
+	}
+	wrapped, err := c.wrapResponse(req, resp)
+	if err != nil {
+		c.logger.Error("core: request %s: failed to wrap response: %v", req.ID, err)
+		return nil, ErrInternalError
+	}
+	return wrapped, nil
+}
+
+// wrapResponse is used to wrap the response of a request that asked for
+// it. The response is stored in the cubbyhole of a new single-use token
+// without a parent, and a response with only that token is returned.
+func (c *Core) wrapResponse(req *logical.Request, resp *logical.Response) (*logical.Response, error) {
+	defer metrics.MeasureSince([]string{"core", "wrap_response"}, time.Now())
+
+	// The wrapping token lives no longer than a lease could
+	ttl := req.WrapTTL
+	if _, maxLease := c.LeaseConfig(); ttl > maxLease {
+		ttl = maxLease
+	}
+
+	// The cubbyhole is always mounted, but guard against old tables
+	view := c.router.MatchingView(cubbyholeMountPath)
+	if view == nil {
+		return nil, fmt.Errorf("cubbyhole is not mounted")
+	}
+
+	// Encode the response before creating the token
+	buf, err := json.Marshal(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode response: %v", err)
+	}
+
+	// Create the token. Its only use is to unwrap the response, and any
+	// other use exhausts it, destroying the response.
+	te := TokenEntry{
+		Path:        wrappingTokenPath,
+		DisplayName: "response-wrapping",
+		NumUses:     1,
+		TTL:         ttl,
+	}
+	if err := c.tokenStore.Create(&te); err != nil {
+		return nil, fmt.Errorf("failed to create wrapping token: %v", err)
+	}
+
+	// Register the token so it is revoked, along with the response, if
+	// it is never unwrapped
+	auth := &logical.Auth{
+		LeaseOptions: logical.LeaseOptions{
+			Lease: ttl,
+		},
+		DisplayName: te.DisplayName,
+		ClientToken: te.ID,
+		Accessor:    te.Accessor,
+	}
+	if err := c.expiration.RegisterAuth(te.Path, auth); err != nil {
+		c.tokenStore.Revoke(te.ID)
+		return nil, fmt.Errorf("failed to register wrapping token: %v", err)
+	}
+
+	// Store the response in the cubbyhole of the token
+	entry := &logical.StorageEntry{
+		Key:   c.tokenStore.SaltID(te.ID) + "/" + wrappedResponseKey,
+		Value: buf,
+	}
+	if err := view.Put(entry); err != nil {
+		c.tokenStore.Revoke(te.ID)
+		return nil, fmt.Errorf("failed to store wrapped response: %v", err)
+	}
+
+	return &logical.Response{
+		WrapInfo: &logical.WrapInfo{
+			Token:        te.ID,
+			TTL:          ttl,
+			CreationTime: te.CreationTime,
+		},
+	}, nil
+}
+
+// Unwrap is used to retrieve a response wrapped with the given token.
+// The token is revoked, destroying the response, so it can only be
+// unwrapped once.
+func (c *Core) Unwrap(token string) (*logical.Response, error) {
+	defer metrics.MeasureSince([]string{"core", "unwrap"}, time.Now())
+
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.sealed {
+		return nil, ErrSealed
+	}
+	if c.standby {
+		return nil, ErrStandby
+	}
+
+	// Create an audit trail of the request, the wrapping token is not
+	// authenticated as it has no policies
+	req := &logical.Request{
+		ID:          generateUUID(),
+		Operation:   logical.WriteOperation,
+		Path:        wrappingUnwrapPath,
+		ClientToken: token,
+	}
+	if err := c.auditBroker.LogRequest(nil, req); err != nil {
+		c.logger.Error("core: request %s: failed to audit request (%#v): %v",
+			req.ID, req, err)
+		return nil, ErrInternalError
+	}
+
+	resp, err := c.unwrap(token)
+
+	// Create an audit trail of the response
+	if err := c.auditBroker.LogResponse(nil, req, resp, err); err != nil {
+		c.logger.Error("core: request %s: failed to audit response (request: %#v, response: %#v): %v",
+			req.ID, req, resp, err)
+		return nil, ErrInternalError
+	}
+	return resp, err
+}
+
+// unwrap reads and revokes the wrapping token. The state lock must be
+// held.
+func (c *Core) unwrap(token string) (*logical.Response, error) {
+	if token == "" {
+		return nil, ErrInvalidWrappingToken
+	}
+
+	// Serialize unwraps so the response is only read by one of them
+	c.wrappingLock.Lock()
+	defer c.wrappingLock.Unlock()
+
+	te, err := c.tokenStore.Lookup(token)
+	if err != nil {
+		c.logger.Error("core: failed to lookup wrapping token: %v", err)
+		return nil, ErrInternalError
+	}
+	if te == nil || te.Path != wrappingTokenPath {
+		return nil, ErrInvalidWrappingToken
+	}
+
+	// Read the response before revoking the token, which destroys it
+	view := c.router.MatchingView(cubbyholeMountPath)
+	if view == nil {
+		return nil, ErrInvalidWrappingToken
+	}
+	entry, err := view.Get(c.tokenStore.SaltID(token) + "/" + wrappedResponseKey)
+	if err != nil {
+		c.logger.Error("core: failed to read wrapped response: %v", err)
+		return nil, ErrInternalError
+	}
+	if err := c.tokenStore.Revoke(token); err != nil {
+		c.logger.Error("core: failed to revoke wrapping token: %v", err)
+		return nil, ErrInternalError
+	}
+	if entry == nil {
+		return nil, ErrInvalidWrappingToken
+	}
+
+	var resp logical.Response
+	if err := json.Unmarshal(entry.Value, &resp); err != nil {
+		c.logger.Error("core: failed to decode wrapped response: %v", err)
+		return nil, ErrInternalError
+	}
+	return &resp, nil
+}
@@ -0,0 +1,218 @@
+package vault
+
+import (
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestCore_WrapResponse(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	req := &logical.Request{
+		Operation:   logical.WriteOperation,
+		Path:        "secret/foo",
+		Data:        map[string]interface{}{"foo": "bar"},
+		ClientToken: root,
+	}
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Read the secret wrapped
+	req = &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "secret/foo",
+		ClientToken: root,
+		WrapTTL:     time.Minute,
+	}
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.WrapInfo == nil || resp.Data != nil || resp.Secret != nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+	if resp.WrapInfo.Token == "" || resp.WrapInfo.TTL != time.Minute {
+		t.Fatalf("bad: %#v", resp.WrapInfo)
+	}
+	token := resp.WrapInfo.Token
+
+	// The response is only unwrapped once
+	resp, err = c.Unwrap(token)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Data["foo"] != "bar" {
+		t.Fatalf("bad: %#v", resp)
+	}
+	if _, err := c.Unwrap(token); err != ErrInvalidWrappingToken {
+		t.Fatalf("err: %v", err)
+	}
+	if te, err := c.tokenStore.Lookup(token); err != nil || te != nil {
+		t.Fatalf("bad: %#v %v", te, err)
+	}
+}
+
+func TestCore_WrapResponse_Auth(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	req := &logical.Request{
+		Operation:   logical.WriteOperation,
+		Path:        "auth/token/create",
+		ClientToken: root,
+		WrapTTL:     time.Minute,
+	}
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.WrapInfo == nil || resp.Auth != nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// The unwrapped token is usable
+	resp, err = c.Unwrap(resp.WrapInfo.Token)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Auth == nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+	te, err := c.tokenStore.Lookup(resp.Auth.ClientToken)
+	if err != nil || te == nil {
+		t.Fatalf("bad: %#v %v", te, err)
+	}
+	if !reflect.DeepEqual(te.Policies, []string{"root"}) {
+		t.Fatalf("bad: %#v", te)
+	}
+}
+
+func TestCore_WrapResponse_OtherUse(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	req := &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "sys/mounts",
+		ClientToken: root,
+		WrapTTL:     time.Minute,
+	}
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	token := resp.WrapInfo.Token
+
+	// Using the token for anything else destroys the response
+	req = &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "secret/foo",
+		ClientToken: token,
+	}
+	if _, err := c.HandleRequest(req); err == nil {
+		t.Fatalf("should fail")
+	}
+	if _, err := c.Unwrap(token); err != ErrInvalidWrappingToken {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Other tokens cannot be unwrapped
+	if _, err := c.Unwrap(root); err != ErrInvalidWrappingToken {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := c.Unwrap(""); err != ErrInvalidWrappingToken {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestCore_WrapResponse_Error(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	// Errors are not wrapped
+	req := &logical.Request{
+		Operation:   logical.WriteOperation,
+		Path:        "sys/mounts/secret",
+		Data:        map[string]interface{}{"type": "generic"},
+		ClientToken: root,
+		WrapTTL:     time.Minute,
+	}
+	resp, err := c.HandleRequest(req)
+	if err == nil || resp == nil || resp.WrapInfo != nil {
+		t.Fatalf("bad: %#v %v", resp, err)
+	}
+}
+
+func TestCore_WrapResponse_Expiration(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	req := &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "sys/mounts",
+		ClientToken: root,
+		WrapTTL:     time.Minute,
+	}
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.WrapInfo == nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+	token := resp.WrapInfo.Token
+
+	// The wrapping token is registered with the expiration manager
+	leaseID := path.Join(wrappingTokenPath, c.tokenStore.SaltID(token))
+	le, err := c.expiration.Lookup(leaseID)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if le == nil || le.Auth == nil || le.Auth.Lease <= 0 || le.Auth.Lease > time.Minute {
+		t.Fatalf("bad: %#v", le)
+	}
+
+	// Expiring the lease revokes the token and the response
+	if err := c.expiration.Revoke(leaseID); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if te, err := c.tokenStore.Lookup(token); err != nil || te != nil {
+		t.Fatalf("bad: %#v %v", te, err)
+	}
+	if _, err := c.Unwrap(token); err != ErrInvalidWrappingToken {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestCore_WrapResponse_Idempotent(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	create := func(wrapTTL time.Duration) (*logical.Response, error) {
+		req := &logical.Request{
+			Operation:      logical.WriteOperation,
+			Path:           "auth/token/create",
+			ClientToken:    root,
+			IdempotencyKey: "foo",
+			WrapTTL:        wrapTTL,
+		}
+		return c.HandleRequest(req)
+	}
+
+	resp, err := create(time.Minute)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.WrapInfo == nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// The replay is not wrapped again
+	resp, err = create(time.Minute)
+	if err != logical.ErrInvalidRequest {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || !resp.IsError() || resp.WrapInfo != nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+}